@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// optionFlag collects repeated code=value flags.
+type optionFlag struct {
+  family int
+  // sub marks option 82 sub-options, which are numbered only.
+  sub  bool
+  opts []dhcp.Option
+}
+
+func (f *optionFlag) String() string { return "" }
+
+func (f *optionFlag) Set(s string) error {
+  o, err := dhcp.ParseOption(f.family, f.sub, s)
+  if err != nil {
+    return err
+  }
+  f.opts = append(f.opts, o)
+  return nil
+}
+
+// runDHCPTest sends a DORA or SARR exchange to a DHCP server and reports
+// what it handed out.
+func runDHCPTest(args []string) int {
+  fs := flag.NewFlagSet("dhcp-test", flag.ExitOnError)
+  v6 := fs.Bool("6", false, "test DHCPv6 instead of DHCPv4")
+  server := fs.String("server", "", "server `address[:port]` (default port 67 or 547)")
+  local := fs.String("local", "", "local `address[:port]` to bind; an unprivileged port works (default any, free port)")
+  mac := fs.String("mac", "", "client MAC address (default random)")
+  clientID := fs.String("client-id", "", "client identifier (DHCPv4) or DUID (DHCPv6) as hex")
+  relay := fs.String("relay", "", "giaddr (DHCPv4) or link-address (DHCPv6) to select the subnet by (default the local address); DHCPv4 replies go there")
+  vendor := fs.String("vendor-class", "", "vendor class identifier; for DHCPv6 written as `[enterprise:]data`")
+  prefix := fs.Bool("pd", false, "also ask for a delegated prefix (DHCPv6)")
+  noRequest := fs.Bool("no-request", false, "stop at the offer or advertise")
+  release := fs.Bool("release", true, "release the lease afterwards")
+  timeout := fs.Duration("timeout", 3*time.Second, "time to wait for each reply")
+  retries := fs.Int("retries", 2, "retransmissions of each message")
+  lookup := fs.Bool("lookup", true, "look up the subnet of the address through the Kea Control Agent")
+  opts := &optionFlag{family: 4}
+  relayInfo := &optionFlag{family: 4, sub: true}
+  fs.Var(opts, "option", "extra client option as `code=value` or name=value; hex with 0x or colons, otherwise text (repeatable)")
+  fs.Var(relayInfo, "relay-info", "option 82 sub-option (DHCPv4) or relay option (DHCPv6) as `code=value` (repeatable)")
+  fs.Usage = func() {
+    fmt.Fprintf(fs.Output(), "Usage: kea-web dhcp-test -server ADDRESS [flags]\n\n")
+    fmt.Fprintf(fs.Output(), "Plays a client behind a relay agent against a DHCP server and reports the offer.\n\n")
+    fs.PrintDefaults()
+  }
+  // The family changes how option names resolve, so look ahead for it.
+  for _, a := range args {
+    if a == "-6" || a == "--6" || a == "-6=true" {
+      opts.family, relayInfo.family = 6, 6
+    }
+  }
+  fs.Parse(args)
+
+  c := &dhcp.TestClient{
+    Family:    4,
+    Options:   opts.opts,
+    RelayInfo: relayInfo.opts,
+    Prefix:    *prefix,
+    NoRequest: *noRequest,
+    Release:   *release,
+    Timeout:   *timeout,
+    Retries:   *retries,
+  }
+  port := dhcp.ServerPort4
+  if *v6 {
+    c.Family, port = 6, dhcp.ServerPort6
+  }
+
+  var err error
+  if c.Server, err = parseAddrPort(*server, port); err != nil || *server == "" {
+    fmt.Fprintln(os.Stderr, "dhcp-test: -server needs an address")
+    fs.Usage()
+    return 2
+  }
+  if *local != "" {
+    if c.Local, err = parseAddrPort(*local, 0); err != nil {
+      fmt.Fprintf(os.Stderr, "dhcp-test: -local: %v\n", err)
+      return 2
+    }
+  }
+  if *relay != "" {
+    if c.RelayAddr, err = netip.ParseAddr(*relay); err != nil {
+      fmt.Fprintf(os.Stderr, "dhcp-test: -relay: %v\n", err)
+      return 2
+    }
+  }
+  if *mac == "" {
+    c.MAC = make(net.HardwareAddr, 6)
+    rand.Read(c.MAC)
+    c.MAC[0] = c.MAC[0]&^0x01 | 0x02
+  } else if c.MAC, err = net.ParseMAC(*mac); err != nil {
+    fmt.Fprintf(os.Stderr, "dhcp-test: -mac: %v\n", err)
+    return 2
+  }
+  if *clientID != "" {
+    if c.ClientID, err = hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(strings.TrimPrefix(*clientID, "0x"))); err != nil {
+      fmt.Fprintf(os.Stderr, "dhcp-test: -client-id: %v\n", err)
+      return 2
+    }
+  }
+  c.VendorClass = *vendor
+  if ent, data, ok := strings.Cut(*vendor, ":"); ok && c.Family == 6 {
+    if n, err := strconv.ParseUint(ent, 10, 32); err == nil {
+      c.Enterprise, c.VendorClass = uint32(n), data
+    }
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*retries+1)*4*(*timeout)+10*time.Second)
+  defer cancel()
+
+  res, err := c.Run(ctx)
+  if res == nil {
+    fmt.Fprintf(os.Stderr, "dhcp-test: %v\n", err)
+    return 1
+  }
+  fmt.Printf("DHCPv%d test of %s, MAC %s\n\n", c.Family, c.Server, c.MAC)
+  printTestResult(os.Stdout, res)
+  if res.Address.IsValid() && *lookup {
+    printSubnet(os.Stdout, c.Family, res.Address)
+  }
+  if err != nil {
+    fmt.Printf("\n%v\n", err)
+    return 1
+  }
+  if !res.Address.IsValid() {
+    return 1
+  }
+  return 0
+}
+
+func parseAddrPort(s string, defaultPort int) (netip.AddrPort, error) {
+  if ap, err := netip.ParseAddrPort(s); err == nil {
+    return ap, nil
+  }
+  a, err := netip.ParseAddr(strings.Trim(s, "[]"))
+  if err != nil {
+    return netip.AddrPort{}, err
+  }
+  return netip.AddrPortFrom(a, uint16(defaultPort)), nil
+}
+
+func printTestResult(w io.Writer, res *dhcp.TestResult) {
+  for _, s := range res.Steps {
+    switch {
+    case s.Err != nil:
+      fmt.Fprintf(w, "%s: %v after %d tries\n", s.Sent, s.Err, s.Tries)
+    case s.Received == "":
+      fmt.Fprintf(w, "%s sent\n", s.Sent)
+    default:
+      fmt.Fprintf(w, "%s → %s from %s in %s", s.Sent, s.Received, s.From, s.RTT.Round(time.Microsecond))
+      if s.Tries > 1 {
+        fmt.Fprintf(w, " after %d tries", s.Tries)
+      }
+      fmt.Fprintln(w)
+    }
+    printOptions(w, "  ", s.Options)
+    if len(s.RelayOptions) > 0 {
+      fmt.Fprintf(w, "  to the relay:\n")
+      printOptions(w, "    ", s.RelayOptions)
+    }
+  }
+
+  fmt.Fprintln(w)
+  fmt.Fprintf(w, "Outcome:  %s\n", res.Outcome)
+  if res.Server != "" {
+    fmt.Fprintf(w, "Server:   %s\n", res.Server)
+  }
+  if res.Address.IsValid() {
+    fmt.Fprintf(w, "Address:  %s\n", res.Address)
+  }
+  for _, l := range res.Leases {
+    if l.Prefix.Bits() != 128 {
+      fmt.Fprintf(w, "Prefix:   %s\n", l.Prefix)
+    }
+  }
+  if len(res.Statuses) > 0 {
+    fmt.Fprintf(w, "Status:   %s\n", strings.Join(res.Statuses, ", "))
+  }
+  fmt.Fprintf(w, "Total:    %s\n", res.Total.Round(time.Microsecond))
+}
+
+func printOptions(w io.Writer, indent string, opts []dhcp.DecodedOption) {
+  for _, o := range opts {
+    fmt.Fprintf(w, "%s%3d %-28s %s\n", indent, o.Code, o.Name, o.Value)
+    printOptions(w, indent+"    ", o.Sub)
+  }
+}
+
+// printSubnet names the configured subnet the address belongs to. The
+// server doesn't say which subnet it picked, so it is looked up in its
+// running configuration.
+func printSubnet(w io.Writer, family int, addr netip.Addr) {
+  utils.LoadEnv()
+  cfg := kea.ConfigFromEnv(utils.GetEnv())
+  if cfg.URL == "" {
+    return
+  }
+  service := kea.ServiceDHCP4
+  if family == 6 {
+    service = kea.ServiceDHCP6
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+  defer cancel()
+  subnets, err := kea.NewClient(cfg).Subnets(ctx, service)
+  if err != nil {
+    fmt.Fprintf(w, "Subnet:   unknown (%v)\n", err)
+    return
+  }
+  for _, s := range subnets {
+    if !s.Contains(addr) {
+      continue
+    }
+    fmt.Fprintf(w, "Subnet:   %d %s", s.ID, s.Subnet)
+    if s.SharedNetwork != "" {
+      fmt.Fprintf(w, " in shared network %s", s.SharedNetwork)
+    }
+    for _, p := range s.Pools {
+      if p.Contains(addr) {
+        fmt.Fprintf(w, ", pool %s", p.Pool)
+        if p.ClientClass != "" {
+          fmt.Fprintf(w, " (class %s)", p.ClientClass)
+        }
+      }
+    }
+    for _, r := range s.Reservations {
+      if r.IPAddress == addr.String() || slices.Contains(r.IPAddresses, addr.String()) {
+        fmt.Fprintf(w, ", reserved")
+      }
+    }
+    fmt.Fprintln(w)
+    return
+  }
+  fmt.Fprintf(w, "Subnet:   none of kea-%s's subnets holds %s\n", service, addr)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,32 +15,55 @@ const (
   shutdownTimeout = 30 * time.Second
 )
 
+// commands are the subcommands run instead of the web server.
+var commands = map[string]func(args []string) int{
+  "dhcp-test":     runDHCPTest,
+  "migrate-dhcpd": runMigrateDHCPD,
+  "token":         runToken,
+  "user":          runUser,
+}
+
 func main() {
+  if len(os.Args) > 1 {
+    if run, ok := commands[os.Args[1]]; ok {
+      os.Exit(run(os.Args[2:]))
+    }
+  }
+
   utils.LoadEnv()
   env := utils.GetEnv()
   utils.ParseCLI(&env)
   utils.ValidateEnv(env)
 
-  srv := web.NewServer("127.0.0.1:" + env.PORT)
+  listenCfg, err := web.ListenConfigFromEnv(env)
+  if err != nil {
+    utils.Fatal("Listen: %v", err)
+  }
+  srv := web.NewServer(env)
+  listeners, err := web.Listen(srv, listenCfg)
+  if err != nil {
+    utils.Fatal("Listen: %v", err)
+  }
 
-  // Graceful shutdown signal handling
+  // Graceful shutdown signal handling; SIGHUP reloads the certificate
   shutdownChan := make(chan os.Signal, 1)
-  signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
+  signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-  go func() {
-    utils.Info("Server running on http://localhost:%s", env.PORT)
-    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-      utils.Fatal("Server failed: %v", err)
-    }
-  }()
+  listeners.Serve()
 
   sig := <-shutdownChan
+  for sig == syscall.SIGHUP {
+    if err := listeners.Reload(); err != nil {
+      utils.Error("TLS reload failed, keeping the old certificate: %v", err)
+    }
+    sig = <-shutdownChan
+  }
   utils.Info("Received signal: %v. Shutting down...", sig)
 
   ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
   defer cancel()
 
-  if err := srv.Shutdown(ctx); err != nil {
+  if err := listeners.Shutdown(ctx); err != nil {
     utils.Error("Server shutdown failed: %v", err)
   } else {
     utils.Info("Server stopped.")
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rannday/kea-web/internal/migrate"
+)
+
+// runMigrateDHCPD converts an ISC dhcpd.conf, and optionally its lease
+// file, to Kea. The report of what couldn't be translated goes to stderr.
+func runMigrateDHCPD(args []string) int {
+  fs := flag.NewFlagSet("migrate-dhcpd", flag.ExitOnError)
+  conf := fs.String("conf", "/etc/dhcp/dhcpd.conf", "dhcpd.conf to convert")
+  leases := fs.String("leases", "", "dhcpd.leases to import (optional)")
+  out := fs.String("out", "-", "`file` to write the Dhcp4 configuration to (- for stdout)")
+  leasesOut := fs.String("leases-out", "", "`file` to write the imported leases to (default stdout after the configuration)")
+  backend := fs.String("lease-backend", migrate.BackendMemfile, "lease backend to configure and import into: memfile, mysql or postgresql")
+  fs.Usage = func() {
+    fmt.Fprintf(fs.Output(), "Usage: kea-web migrate-dhcpd [-conf dhcpd.conf] [-leases dhcpd.leases] [flags]\n\n")
+    fmt.Fprintf(fs.Output(), "Converts an ISC DHCP server configuration to a Kea Dhcp4 configuration and\nits leases to a memfile CSV file or a SQL script.\n\n")
+    fs.PrintDefaults()
+  }
+  fs.Parse(args)
+
+  switch *backend {
+  case migrate.BackendMemfile, migrate.BackendMySQL, migrate.BackendPostgreSQL:
+  default:
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: unknown lease backend %q\n", *backend)
+    return 2
+  }
+
+  src, err := os.ReadFile(*conf)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+    return 1
+  }
+  res, err := migrate.Convert(string(src), migrate.Options{LeaseBackend: *backend})
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %s: %v\n", *conf, err)
+    return 1
+  }
+  b, err := res.JSON()
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+    return 1
+  }
+  if err := writeOutput(*out, b); err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+    return 1
+  }
+  printFindings(os.Stderr, *conf, res.Findings)
+  s := res.Summary()
+  fmt.Fprintf(os.Stderr, "\n%d subnets (%d shared networks), %d pools, %d reservations, %d classes, %d option definitions\n",
+    s.Subnets, s.SharedNetworks, s.Pools, s.Reservations, s.Classes, s.OptionDefs)
+  fmt.Fprintf(os.Stderr, "%d untranslated, %d approximate, %d to review\n",
+    res.Count(migrate.Untranslated), res.Count(migrate.Approximate), res.Count(migrate.Review))
+
+  if *leases == "" {
+    return 0
+  }
+  src, err = os.ReadFile(*leases)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+    return 1
+  }
+  imp, err := migrate.ImportLeases(string(src), res.Config)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %s: %v\n", *leases, err)
+    return 1
+  }
+  w := io.Writer(os.Stdout)
+  if *leasesOut != "" && *leasesOut != "-" {
+    f, err := os.Create(*leasesOut)
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+      return 1
+    }
+    defer f.Close()
+    w = f
+  }
+  if err := migrate.WriteLeases(w, *backend, imp.Leases); err != nil {
+    fmt.Fprintf(os.Stderr, "migrate-dhcpd: %v\n", err)
+    return 1
+  }
+  fmt.Fprintln(os.Stderr)
+  printFindings(os.Stderr, *leases, imp.Findings)
+  fmt.Fprintf(os.Stderr, "%d leases imported", len(imp.Leases))
+  sep := "; skipped "
+  reasons := make([]string, 0, len(imp.Skipped))
+  for r := range imp.Skipped {
+    reasons = append(reasons, r)
+  }
+  sort.Strings(reasons)
+  for _, r := range reasons {
+    fmt.Fprintf(os.Stderr, "%s%d %s", sep, imp.Skipped[r], r)
+    sep = ", "
+  }
+  fmt.Fprintln(os.Stderr)
+  return 0
+}
+
+func writeOutput(path string, b []byte) error {
+  if path == "-" {
+    _, err := os.Stdout.Write(b)
+    return err
+  }
+  return os.WriteFile(path, b, 0o644)
+}
+
+func printFindings(w io.Writer, file string, findings []migrate.Finding) {
+  for _, f := range findings {
+    if f.Line > 0 {
+      fmt.Fprintf(w, "%s:%d: %s: %s\n", file, f.Line, f.Severity, f.Message)
+    } else {
+      fmt.Fprintf(w, "%s: %s: %s\n", file, f.Severity, f.Message)
+    }
+    if f.Statement != "" {
+      fmt.Fprintf(w, "    %s\n", f.Statement)
+    }
+  }
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// runToken manages the service API tokens: add, list and revoke.
+func runToken(args []string) int {
+  utils.LoadEnv()
+  fs := flag.NewFlagSet("token", flag.ExitOnError)
+  file := fs.String("file", filepath.Join(utils.GetEnv().DATA_DIR, "tokens.json"), "token `file`")
+  scopes := fs.String("scopes", string(auth.ScopeReadLeases), "comma separated `scopes` for add: read:leases, write:reservations, admin:config")
+  expires := fs.String("expires", "never", "when the token added expires: never, a number of days such as 90d, or a `date`")
+  servers := fs.String("servers", "", "comma separated Kea `servers` (dhcp4, dhcp6, d2) the token is limited to")
+  subnets := fs.String("subnets", "", "comma separated subnet `IDs` the token is limited to")
+  fs.Usage = func() {
+    fmt.Fprintf(fs.Output(), "Usage: kea-web token [-file tokens.json] [-scopes S] [-expires E] [-servers S] [-subnets IDS] add NAME\n       kea-web token [-file tokens.json] revoke ID\n       kea-web token [-file tokens.json] list\n\n")
+    fmt.Fprintf(fs.Output(), "Manages the service tokens scripts send as \"Authorization: Bearer TOKEN\". A new\ntoken is printed once on standard output; only its hash is kept.\n\n")
+    fs.PrintDefaults()
+  }
+  fs.Parse(args)
+
+  tokens, err := auth.NewTokens(*file)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "token: %v\n", err)
+    return 1
+  }
+  cmd, arg := fs.Arg(0), fs.Arg(1)
+  if cmd != "list" && (arg == "" || fs.NArg() != 2) {
+    fs.Usage()
+    return 2
+  }
+
+  switch cmd {
+  case "list":
+    list, err := tokens.List()
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+    now := time.Now()
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "ID\tNAME\tACTS AS\tSCOPES\tEXPIRES\tLAST USED\tSTATUS")
+    for _, t := range list {
+      as := t.Owner
+      if t.Service() {
+        as = "service: " + t.Access.String()
+      }
+      scopes := make([]string, len(t.Scopes))
+      for i, s := range t.Scopes {
+        scopes[i] = string(s)
+      }
+      exp, last, status := "never", "never", "active"
+      if !t.Expires.IsZero() {
+        exp = t.Expires.Local().Format("2006-01-02 15:04")
+      }
+      if !t.LastUsed.IsZero() {
+        last = t.LastUsed.Local().Format("2006-01-02 15:04") + " from " + t.LastUsedFrom
+      }
+      if !t.Revoked.IsZero() {
+        status = "revoked"
+      } else if !t.Active(now) {
+        status = "expired"
+      }
+      fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, as, strings.Join(scopes, ","), exp, last, status)
+    }
+    tw.Flush()
+    return 0
+  case "add":
+    sc, err := auth.ParseScopes(*scopes)
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+    exp, err := auth.ParseExpiry(*expires, time.Now())
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+    access, err := auth.ParseAccess(string(auth.ScopeRole(sc)), *servers, *subnets)
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+    secret, tok, err := tokens.Create(auth.Token{Name: arg, Access: access, Scopes: sc, Expires: exp})
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+    fmt.Println(secret)
+    fmt.Fprintf(os.Stderr, "token %s: added as %s\n", arg, tok.ID)
+    return 0
+  case "revoke":
+    if err := tokens.Revoke(arg); err != nil {
+      fmt.Fprintf(os.Stderr, "token: %v\n", err)
+      return 1
+    }
+  default:
+    fs.Usage()
+    return 2
+  }
+  fmt.Fprintf(os.Stderr, "token %s: %s done\n", arg, cmd)
+  return 0
+}
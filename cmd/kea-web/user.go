@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// readPassword asks for a password twice on a terminal; piped input is
+// read as a single line.
+func readPassword() (string, error) {
+  fd := int(os.Stdin.Fd())
+  if !term.IsTerminal(fd) {
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    if err != nil && line == "" {
+      return "", err
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+  }
+  fmt.Fprint(os.Stderr, "Password: ")
+  first, err := term.ReadPassword(fd)
+  fmt.Fprintln(os.Stderr)
+  if err != nil {
+    return "", err
+  }
+  fmt.Fprint(os.Stderr, "Again: ")
+  second, err := term.ReadPassword(fd)
+  fmt.Fprintln(os.Stderr)
+  if err != nil {
+    return "", err
+  }
+  if string(first) != string(second) {
+    return "", errors.New("the passwords don't match")
+  }
+  return string(first), nil
+}
+
+// runUser manages the local accounts: add, passwd, role, delete and list.
+func runUser(args []string) int {
+  utils.LoadEnv()
+  fs := flag.NewFlagSet("user", flag.ExitOnError)
+  file := fs.String("file", filepath.Join(utils.GetEnv().DATA_DIR, "users.json"), "account `file`")
+  role := fs.String("role", "", "`role` for add and role: viewer, operator or admin (default admin for the first account, viewer after)")
+  servers := fs.String("servers", "", "comma separated Kea `servers` (dhcp4, dhcp6, d2) the account is limited to")
+  subnets := fs.String("subnets", "", "comma separated subnet `IDs` the account is limited to")
+  fs.Usage = func() {
+    fmt.Fprintf(fs.Output(), "Usage: kea-web user [-file users.json] [-role R] [-servers S] [-subnets IDS] add|role NAME\n       kea-web user [-file users.json] passwd|delete NAME\n       kea-web user [-file users.json] list\n\n")
+    fmt.Fprintf(fs.Output(), "Manages the accounts that can sign in to kea-web. Passwords are read from the\nterminal, or as one line from standard input.\n\n")
+    fs.PrintDefaults()
+  }
+  fs.Parse(args)
+
+  users, err := auth.NewUsers(*file)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "user: %v\n", err)
+    return 1
+  }
+  cmd, name := fs.Arg(0), fs.Arg(1)
+  if cmd != "list" && (name == "" || fs.NArg() != 2) {
+    fs.Usage()
+    return 2
+  }
+
+  switch cmd {
+  case "list":
+    list, err := users.List()
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "user: %v\n", err)
+      return 1
+    }
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "NAME\tACCESS\tCREATED\tLAST LOGIN")
+    for _, u := range list {
+      last := "never"
+      if !u.LastLogin.IsZero() {
+        last = u.LastLogin.Local().Format("2006-01-02 15:04")
+      }
+      fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Name, u.Access, u.Created.Local().Format("2006-01-02 15:04"), last)
+    }
+    tw.Flush()
+    return 0
+  case "add", "passwd":
+    var access auth.Access
+    if cmd == "add" {
+      if *role == "" {
+        *role = string(auth.RoleViewer)
+        if users.Empty() {
+          *role = string(auth.RoleAdmin)
+        }
+      }
+      if access, err = auth.ParseAccess(*role, *servers, *subnets); err != nil {
+        fmt.Fprintf(os.Stderr, "user: %v\n", err)
+        return 1
+      }
+    }
+    password, err := readPassword()
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "user: %v\n", err)
+      return 1
+    }
+    if cmd == "add" {
+      err = users.Add(name, password, access)
+    } else {
+      err = users.SetPassword(name, password)
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "user: %v\n", err)
+      return 1
+    }
+  case "role":
+    if *role == "" {
+      fs.Usage()
+      return 2
+    }
+    access, err := auth.ParseAccess(*role, *servers, *subnets)
+    if err == nil {
+      err = users.SetAccess(name, access)
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "user: %v\n", err)
+      return 1
+    }
+  case "delete":
+    if err := users.Delete(name); err != nil {
+      fmt.Fprintf(os.Stderr, "user: %v\n", err)
+      return 1
+    }
+  default:
+    fs.Usage()
+    return 2
+  }
+  fmt.Fprintf(os.Stderr, "user %s: %s done\n", name, cmd)
+  return 0
+}
@@ -0,0 +1,287 @@
+// Package audit keeps an append-only record of what kea-web's users do:
+// the requests that change something and the Kea commands sent for them.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Kinds of events.
+const (
+  // KindRequest is a request to kea-web that changes something.
+  KindRequest = "request"
+  // KindCommand is a Kea command.
+  KindCommand = "command"
+)
+
+// Event is one audited action.
+type Event struct {
+  Time time.Time `json:"time"`
+  Kind string    `json:"kind"`
+  // User is who acted, "" for kea-web itself.
+  User string `json:"user,omitempty"`
+  // Token is the ID of the API token the request came with.
+  Token  string `json:"token,omitempty"`
+  Source string `json:"source,omitempty"`
+  // Server is the Kea service a command went to, "" for the Control
+  // Agent.
+  Server string `json:"server,omitempty"`
+  // Command is the Kea command, or the method and path of a request.
+  Command   string          `json:"command"`
+  Arguments json.RawMessage `json:"arguments,omitempty"`
+  // Result is Kea's result code, -1 when there was no answer, or the
+  // HTTP status of a request.
+  Result int    `json:"result"`
+  Text   string `json:"text,omitempty"`
+  OK     bool   `json:"ok"`
+}
+
+// Config is where events are written.
+type Config struct {
+  // File is the audit log kea-web reads back on the Audit Log page.
+  File string
+  // Syslog is the syslog server events are also sent to, as
+  // udp://HOST[:PORT], tcp://HOST[:PORT] or unix:///dev/log.
+  Syslog         string
+  SyslogFacility string
+  // JSONFile is a second JSON-lines file events are copied to, e.g. for
+  // a log shipper.
+  JSONFile string
+}
+
+// ConfigFromEnv reads the audit settings from the environment.
+func ConfigFromEnv(e utils.Env) Config {
+  file := e.AUDIT_LOG
+  if file == "" {
+    file = filepath.Join(e.DATA_DIR, "audit.log")
+  }
+  return Config{File: file, Syslog: e.AUDIT_SYSLOG, SyslogFacility: e.AUDIT_SYSLOG_FACILITY, JSONFile: e.AUDIT_JSON_FILE}
+}
+
+// forwarder sends events somewhere besides the audit log.
+type forwarder interface {
+  send(ev Event, line []byte) error
+  close() error
+}
+
+// queueSize is how many events may wait for the forwarders before new
+// ones are dropped.
+const queueSize = 1024
+
+// Log is the audit log. A nil Log records nothing.
+type Log struct {
+  path string
+
+  mu sync.Mutex
+  f  *os.File
+
+  forward []forwarder
+  queue   chan Event
+  done    chan struct{}
+}
+
+// Open opens the audit log of cfg for appending, creating it, and
+// connects the forwarders.
+func Open(cfg Config) (*Log, error) {
+  if err := os.MkdirAll(filepath.Dir(cfg.File), 0o700); err != nil {
+    return nil, err
+  }
+  f, err := openAppend(cfg.File)
+  if err != nil {
+    return nil, err
+  }
+  l := &Log{path: cfg.File, f: f}
+
+  if cfg.JSONFile != "" {
+    jf, err := openAppend(cfg.JSONFile)
+    if err != nil {
+      f.Close()
+      return nil, err
+    }
+    l.forward = append(l.forward, jsonFile{jf})
+  }
+  if cfg.Syslog != "" {
+    s, err := newSyslog(cfg.Syslog, cfg.SyslogFacility)
+    if err != nil {
+      l.Close()
+      return nil, err
+    }
+    l.forward = append(l.forward, s)
+  }
+  if len(l.forward) > 0 {
+    l.queue = make(chan Event, queueSize)
+    l.done = make(chan struct{})
+    go l.run()
+  }
+  return l, nil
+}
+
+// openAppend opens path for appending only; nothing kea-web does can
+// rewrite what was written.
+func openAppend(path string) (*os.File, error) {
+  return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+// Path is the file of the audit log.
+func (l *Log) Path() string {
+  return l.path
+}
+
+// Record appends ev to the log and queues it for the forwarders. A
+// write that fails is logged; the action it records has happened.
+func (l *Log) Record(ev Event) {
+  if l == nil {
+    return
+  }
+  if ev.Time.IsZero() {
+    ev.Time = time.Now()
+  }
+  ev.Time = ev.Time.UTC()
+  line, err := json.Marshal(ev)
+  if err != nil {
+    utils.Error("Audit: encode %s: %v", ev.Command, err)
+    return
+  }
+  line = append(line, '\n')
+
+  l.mu.Lock()
+  if _, err = l.f.Write(line); err == nil {
+    err = l.f.Sync()
+  }
+  l.mu.Unlock()
+  if err != nil {
+    utils.Error("Audit: write %s by %q: %v", ev.Command, ev.User, err)
+  }
+
+  if l.queue != nil {
+    select {
+    case l.queue <- ev:
+    default:
+      utils.Warn("Audit: forwarding queue full, %s by %q not forwarded", ev.Command, ev.User)
+    }
+  }
+}
+
+// run forwards queued events until the queue is closed.
+func (l *Log) run() {
+  defer close(l.done)
+  for ev := range l.queue {
+    line, _ := json.Marshal(ev)
+    for _, fw := range l.forward {
+      if err := fw.send(ev, line); err != nil {
+        utils.Warn("Audit: forward %s: %v", ev.Command, err)
+      }
+    }
+  }
+}
+
+// Close forwards the queued events and closes the files and
+// connections. Nothing may be recorded after it.
+func (l *Log) Close() error {
+  if l == nil {
+    return nil
+  }
+  if l.queue != nil {
+    close(l.queue)
+    <-l.done
+    l.queue = nil
+  }
+  var errs []error
+  for _, fw := range l.forward {
+    errs = append(errs, fw.close())
+  }
+  l.mu.Lock()
+  errs = append(errs, l.f.Close())
+  l.mu.Unlock()
+  return errors.Join(errs...)
+}
+
+// maxLine bounds the lines Read accepts; arguments are capped well
+// below it.
+const maxLine = 1 << 20
+
+// Read calls fn with the events of the log, oldest first, until fn
+// returns an error. Lines that don't decode are skipped.
+func (l *Log) Read(fn func(Event) error) error {
+  f, err := os.Open(l.path)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  sc := bufio.NewScanner(f)
+  sc.Buffer(make([]byte, 64*1024), maxLine)
+  line, bad := 0, 0
+  for sc.Scan() {
+    line++
+    var ev Event
+    if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+      bad++
+      continue
+    }
+    if err := fn(ev); err != nil {
+      return err
+    }
+  }
+  if bad > 0 {
+    utils.Warn("Audit: %d of %d lines of %s don't decode", bad, line, l.path)
+  }
+  if err := sc.Err(); err != nil {
+    return fmt.Errorf("%s line %d: %w", l.path, line+1, err)
+  }
+  return nil
+}
+
+// Filter selects events. Zero fields match everything.
+type Filter struct {
+  User    string
+  Server  string
+  Kind    string
+  Command string
+  // Result is "ok" or "failed".
+  Result string
+  Since  time.Time
+  Until  time.Time
+  // Text is searched for in the command, source, arguments and result
+  // text.
+  Text string
+}
+
+// Match reports whether ev passes the filter. User, server and kind
+// match exactly, the command and text as substrings, all ignoring case.
+func (f Filter) Match(ev Event) bool {
+  switch {
+  case f.User != "" && !strings.EqualFold(ev.User, f.User),
+    f.Server != "" && !strings.EqualFold(ev.Server, f.Server),
+    f.Kind != "" && ev.Kind != f.Kind,
+    f.Command != "" && !containsFold(ev.Command, f.Command),
+    f.Result == "ok" && !ev.OK,
+    f.Result == "failed" && ev.OK,
+    !f.Since.IsZero() && ev.Time.Before(f.Since),
+    !f.Until.IsZero() && !ev.Time.Before(f.Until):
+    return false
+  }
+  if f.Text == "" {
+    return true
+  }
+  for _, s := range []string{ev.Command, ev.Source, string(ev.Arguments), ev.Text} {
+    if containsFold(s, f.Text) {
+      return true
+    }
+  }
+  return false
+}
+
+func containsFold(s, sub string) bool {
+  return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
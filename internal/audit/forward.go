@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// jsonFile copies events to a second JSON-lines file.
+type jsonFile struct {
+  f *os.File
+}
+
+func (j jsonFile) send(_ Event, line []byte) error {
+  _, err := j.f.Write(append(line, '\n'))
+  return err
+}
+
+func (j jsonFile) close() error {
+  return j.f.Close()
+}
+
+// Syslog facilities by name.
+var facilities = map[string]int{
+  "kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+  "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14,
+  "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities of events that succeeded and failed.
+const (
+  severityNotice  = 5
+  severityWarning = 4
+)
+
+const syslogTimeout = 5 * time.Second
+
+// syslog sends events as RFC 5424 messages, over UDP, TCP with
+// octet-counting framing (RFC 6587), or a local socket. Broken
+// connections are redialled on the next event.
+type syslog struct {
+  network  string
+  addr     string
+  facility int
+  hostname string
+  conn     net.Conn
+}
+
+// newSyslog parses a udp://, tcp:// or unix:// URL and dials it.
+func newSyslog(rawURL, facility string) (*syslog, error) {
+  u, err := url.Parse(rawURL)
+  if err != nil {
+    return nil, fmt.Errorf("AUDIT_SYSLOG: %w", err)
+  }
+  s := &syslog{network: u.Scheme, addr: u.Host}
+  switch u.Scheme {
+  case "udp", "tcp":
+    if u.Port() == "" {
+      s.addr = net.JoinHostPort(u.Hostname(), "514")
+    }
+  case "unix":
+    s.network, s.addr = "unixgram", u.Path
+  default:
+    return nil, fmt.Errorf("AUDIT_SYSLOG: %q: scheme must be udp, tcp or unix", rawURL)
+  }
+  if facility == "" {
+    facility = "local0"
+  }
+  var ok bool
+  if s.facility, ok = facilities[strings.ToLower(facility)]; !ok {
+    return nil, fmt.Errorf("AUDIT_SYSLOG_FACILITY: unknown facility %q", facility)
+  }
+  if s.hostname, err = os.Hostname(); err != nil || s.hostname == "" {
+    s.hostname = "-"
+  }
+  if err := s.dial(); err != nil {
+    return nil, fmt.Errorf("AUDIT_SYSLOG: %w", err)
+  }
+  return s, nil
+}
+
+func (s *syslog) dial() error {
+  conn, err := net.DialTimeout(s.network, s.addr, syslogTimeout)
+  if err != nil && s.network == "unixgram" {
+    // Some syslog daemons listen on a stream socket.
+    conn, err = net.DialTimeout("unix", s.addr, syslogTimeout)
+    if err == nil {
+      s.network = "unix"
+    }
+  }
+  s.conn = conn
+  return err
+}
+
+// format renders ev as an RFC 5424 message with the event as JSON.
+func (s *syslog) format(ev Event, line []byte) []byte {
+  severity := severityNotice
+  if !ev.OK {
+    severity = severityWarning
+  }
+  msg := fmt.Appendf(nil, "<%d>1 %s %s kea-web %d %s - ",
+    s.facility*8+severity, ev.Time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, os.Getpid(), ev.Kind)
+  msg = append(msg, line...)
+  if s.network == "tcp" {
+    msg = append(fmt.Appendf(nil, "%d ", len(msg)), msg...)
+  }
+  return msg
+}
+
+func (s *syslog) send(ev Event, line []byte) error {
+  msg := s.format(ev, line)
+  var err error
+  for range 2 {
+    if s.conn == nil {
+      if err = s.dial(); err != nil {
+        continue
+      }
+    }
+    _ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+    if _, err = s.conn.Write(msg); err == nil {
+      return nil
+    }
+    s.conn.Close()
+    s.conn = nil
+  }
+  return err
+}
+
+func (s *syslog) close() error {
+  if s.conn == nil {
+    return nil
+  }
+  return s.conn.Close()
+}
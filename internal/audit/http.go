@@ -0,0 +1,247 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+type sourceKey struct{}
+
+// WithSource returns ctx carrying the address a request came from, for
+// the Kea commands sent on its behalf.
+func WithSource(ctx context.Context, addr string) context.Context {
+  return context.WithValue(ctx, sourceKey{}, addr)
+}
+
+func sourceFrom(ctx context.Context) string {
+  addr, _ := ctx.Value(sourceKey{}).(string)
+  return addr
+}
+
+// remoteAddr is the client address of r without the port.
+func remoteAddr(r *http.Request) string {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+  return host
+}
+
+// changes reports whether a request method may change something.
+func changes(method string) bool {
+  switch method {
+  case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+    return true
+  }
+  return false
+}
+
+// Middleware records every request that may change something, with its
+// caller, form or JSON body and outcome. It goes inside the auth
+// middleware, whose identity it records; sign-ins record the user name
+// they were tried with.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    source := remoteAddr(r)
+    r = r.WithContext(WithSource(r.Context(), source))
+    if l == nil || !changes(r.Method) {
+      next.ServeHTTP(w, r)
+      return
+    }
+
+    body := &capturedBody{ReadCloser: r.Body}
+    r.Body = body
+    rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+    next.ServeHTTP(rec, r)
+
+    ev := Event{
+      Kind:    KindRequest,
+      Source:  source,
+      Command: r.Method + " " + r.URL.RequestURI(),
+      Result:  rec.status,
+    }
+    if id, ok := auth.FromContext(r.Context()); ok {
+      ev.User, ev.Token = id.User, id.Token
+    } else if r.URL.Path == "/login" && r.PostForm != nil {
+      ev.User = r.PostForm.Get("username")
+    }
+    ev.Arguments = requestArguments(r, body)
+    ev.OK, ev.Text = rec.outcome()
+    l.Record(ev)
+  })
+}
+
+// requestArguments is the form the handler parsed, or the JSON body it
+// read, redacted.
+func requestArguments(r *http.Request, body *capturedBody) json.RawMessage {
+  if r.MultipartForm != nil {
+    args := map[string]any{}
+    for k, v := range r.MultipartForm.Value {
+      args[k] = formValue(v)
+    }
+    for k, files := range r.MultipartForm.File {
+      var names []string
+      for _, f := range files {
+        names = append(names, fmt.Sprintf("%s (%d bytes)", f.Filename, f.Size))
+      }
+      args[k] = formValue(names)
+    }
+    return Redact(args)
+  }
+  if len(r.PostForm) > 0 {
+    args := map[string]any{}
+    for k, v := range r.PostForm {
+      args[k] = formValue(v)
+    }
+    return Redact(args)
+  }
+  if body.Len() == 0 {
+    return nil
+  }
+  mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  if mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+    return mustJSON(map[string]any{"content-type": mt, "bytes": body.n})
+  }
+  if body.n > int64(body.Len()) {
+    return mustJSON(map[string]any{"truncated": true, "bytes": body.n})
+  }
+  return RedactJSON(body.Bytes())
+}
+
+func formValue(v []string) any {
+  if len(v) == 1 {
+    return v[0]
+  }
+  return v
+}
+
+// capturedBody keeps the first maxArguments bytes the handler reads of
+// a request body, and counts the rest.
+type capturedBody struct {
+  io.ReadCloser
+  bytes.Buffer
+  n int64
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+  n, err := b.ReadCloser.Read(p)
+  if room := maxArguments - b.Buffer.Len(); room > 0 {
+    b.Buffer.Write(p[:min(n, room)])
+  }
+  b.n += int64(n)
+  return n, err
+}
+
+// maxErrorBody is how much of an error answer is kept for its message.
+const maxErrorBody = 4096
+
+// recorder notes the status of an answer, and the start of its body
+// when it is an error.
+type recorder struct {
+  http.ResponseWriter
+  status      int
+  wroteHeader bool
+  body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+  if !rec.wroteHeader {
+    rec.status, rec.wroteHeader = status, true
+  }
+  rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+  rec.wroteHeader = true
+  if rec.status >= 400 {
+    if room := maxErrorBody - rec.body.Len(); room > 0 {
+      rec.body.Write(p[:min(len(p), room)])
+    }
+  }
+  return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (rec *recorder) Unwrap() http.ResponseWriter {
+  return rec.ResponseWriter
+}
+
+// outcome tells whether the request succeeded and why: the err or ok
+// message the pages redirect with, the message of a JSON API error or
+// a plain text error, else the status.
+func (rec *recorder) outcome() (bool, string) {
+  if loc := rec.Header().Get("Location"); loc != "" && rec.status < 400 {
+    if u, err := url.Parse(loc); err == nil {
+      q := u.Query()
+      if msg := q.Get("err"); msg != "" {
+        return false, msg
+      }
+      if msg := q.Get("ok"); msg != "" {
+        return true, msg
+      }
+    }
+  }
+  ok, text := rec.status < 400, http.StatusText(rec.status)
+  if ok {
+    return ok, text
+  }
+  mt, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+  switch mt {
+  case "application/json":
+    var env struct {
+      Error struct {
+        Message string `json:"message"`
+      } `json:"error"`
+    }
+    if json.Unmarshal(rec.body.Bytes(), &env) == nil && env.Error.Message != "" {
+      text = env.Error.Message
+    }
+  case "text/plain":
+    if msg := strings.TrimSpace(rec.body.String()); msg != "" {
+      text = msg
+    }
+  }
+  return ok, text
+}
+
+// KeaCommand records the Kea commands that change something, one event
+// per server that answered. Reads are recorded only when refused. It is
+// a kea.CommandHook.
+func (l *Log) KeaCommand(ctx context.Context, command string, services []string, args any, resps []kea.Response, err error) {
+  if l == nil || (err == nil && auth.CommandPermission(command) == auth.PermRead) {
+    return
+  }
+  ev := Event{Kind: KindCommand, Source: sourceFrom(ctx), Command: command}
+  if id, ok := auth.FromContext(ctx); ok {
+    ev.User, ev.Token = id.User, id.Token
+  }
+  if args != nil {
+    ev.Arguments = Redact(args)
+  }
+
+  if err != nil {
+    ev.Server, ev.Result, ev.Text = strings.Join(services, ","), -1, err.Error()
+    l.Record(ev)
+    return
+  }
+  for i, resp := range resps {
+    ev.Server = ""
+    if i < len(services) {
+      ev.Server = services[i]
+    }
+    ev.Result, ev.Text = resp.Result, resp.Text
+    ev.OK = resp.Result == kea.ResultSuccess || resp.Result == kea.ResultEmpty
+    l.Record(ev)
+  }
+}
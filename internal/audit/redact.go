@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces the values of sensitive keys.
+const Redacted = "[redacted]"
+
+const (
+  // maxArguments caps the recorded arguments; larger ones are replaced
+  // by their size and hash.
+  maxArguments = 64 * 1024
+  // maxString caps single strings, e.g. a configuration file posted in
+  // a form.
+  maxString = 1024
+)
+
+// sensitive reports whether the value of key must not be recorded:
+// passwords, secrets, tokens and keys, such as a lease database password
+// or a TSIG secret.
+func sensitive(key string) bool {
+  k := strings.ToLower(key)
+  if k == "key" || k == "keys" {
+    return true
+  }
+  for _, s := range []string{"password", "secret", "token", "csrf", "private"} {
+    if strings.Contains(k, s) {
+      return true
+    }
+  }
+  return false
+}
+
+// Redact encodes v as JSON with the values of sensitive keys replaced
+// and long strings shortened. Arguments too large to record become
+// their size and SHA-256 hash.
+func Redact(v any) json.RawMessage {
+  if v == nil {
+    return nil
+  }
+  raw, err := json.Marshal(v)
+  if err != nil {
+    return mustJSON(map[string]string{"error": err.Error()})
+  }
+  return RedactJSON(raw)
+}
+
+// RedactJSON is Redact for arguments that are JSON already.
+func RedactJSON(raw []byte) json.RawMessage {
+  dec := json.NewDecoder(bytes.NewReader(raw))
+  dec.UseNumber()
+  var v any
+  if err := dec.Decode(&v); err != nil {
+    return mustJSON(map[string]any{"invalid-json": true, "bytes": len(raw)})
+  }
+  if v == nil {
+    return nil
+  }
+  out := mustJSON(redact(v))
+  if len(out) > maxArguments {
+    sum := sha256.Sum256(raw)
+    return mustJSON(map[string]any{"truncated": true, "bytes": len(raw), "sha256": hex.EncodeToString(sum[:])})
+  }
+  return out
+}
+
+func redact(v any) any {
+  switch v := v.(type) {
+  case map[string]any:
+    for k, val := range v {
+      if sensitive(k) && val != nil && val != "" {
+        v[k] = Redacted
+      } else {
+        v[k] = redact(val)
+      }
+    }
+  case []any:
+    for i := range v {
+      v[i] = redact(v[i])
+    }
+  case string:
+    return shorten(v)
+  }
+  return v
+}
+
+// shorten cuts s to maxString bytes, noting how long it was.
+func shorten(s string) string {
+  if len(s) <= maxString {
+    return s
+  }
+  cut := maxString
+  for cut > 0 && !utf8.RuneStart(s[cut]) {
+    cut--
+  }
+  return fmt.Sprintf("%s… (%d bytes)", s[:cut], len(s))
+}
+
+func mustJSON(v any) json.RawMessage {
+  raw, _ := json.Marshal(v)
+  return raw
+}
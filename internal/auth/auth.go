@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// CookieName is the session cookie.
+const CookieName = "kea_web_session"
+
+// Sign-in throttling: after maxFailures failed attempts from an address
+// within failureWindow, it is refused until the window passes.
+const (
+  maxFailures   = 5
+  failureWindow = 15 * time.Minute
+)
+
+// Auth signs users in and out and guards the routes.
+type Auth struct {
+  Users    *Users
+  Sessions *Sessions
+  // OIDC is the single sign-on provider, nil when not configured.
+  OIDC *OIDC
+  // LDAP is the directory for names without a local account, nil when
+  // not configured.
+  LDAP *LDAP
+  // Tokens are the API tokens accepted as Bearer credentials, nil to
+  // accept none.
+  Tokens *Tokens
+
+  mu       sync.Mutex
+  failures map[string][]time.Time
+}
+
+// New returns the authenticator of the accounts in users.
+func New(users *Users, sessions *Sessions) *Auth {
+  return &Auth{Users: users, Sessions: sessions, failures: map[string][]time.Time{}}
+}
+
+type identityKey struct{}
+
+// ErrLocalName refuses an external sign-in under the name of a local
+// account, which would otherwise act with the account's access.
+var ErrLocalName = errors.New("the name belongs to a local account")
+
+// Identity is who made a request and what they may do.
+type Identity struct {
+  User string
+  Access
+  // Token is the ID of the API token the request came with, "" for
+  // browser sessions. Its scopes narrow the access further.
+  Token  string
+  Scopes []Scope
+  // CSRF is the CSRF token of a browser session.
+  CSRF string
+  // External is set for users signed in through an identity provider
+  // or the directory, who have no local account.
+  External bool
+}
+
+// Can reports whether the identity has perm: its role grants it and,
+// for API tokens, one of the token's scopes does.
+func (id Identity) Can(perm Permission) bool {
+  if !id.Access.Can(perm) {
+    return false
+  }
+  return id.Token == "" || slices.ContainsFunc(id.Scopes, func(s Scope) bool { return s.grants(perm) })
+}
+
+// FromContext returns the identity of a request that passed the
+// middleware.
+func FromContext(ctx context.Context) (Identity, bool) {
+  id, ok := ctx.Value(identityKey{}).(Identity)
+  return id, ok
+}
+
+// WithIdentity returns ctx carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+  return context.WithValue(ctx, identityKey{}, id)
+}
+
+// Public reports whether a path is reachable without signing in: the
+// login page and the static assets.
+func Public(path string) bool {
+  switch path {
+  case "/login", "/login/oidc", "/login/oidc/callback", "/sw.js", "/robots.txt", "/site.webmanifest":
+    return true
+  }
+  for _, prefix := range []string{"/css/", "/js/", "/static/"} {
+    if strings.HasPrefix(path, prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+// Middleware lets requests with a valid session or API token through,
+// carrying their identity. Others are sent to the login page, or
+// refused with 401 when they aren't page loads or call the API.
+// Sessions' requests that change something must pass the CSRF check;
+// API tokens aren't sent by browsers on their own and need none.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if Public(r.URL.Path) {
+      // Sign-ins have no session yet; a foreign origin still fails.
+      if changes(r.Method) {
+        if err := checkOrigin(r, false); err != nil {
+          csrfRefused(w, r, err)
+          return
+        }
+      }
+      next.ServeHTTP(w, r)
+      return
+    }
+    if secret, ok := bearer(r); ok {
+      id, err := a.Bearer(secret, r)
+      if err != nil {
+        utils.Warn("API request from %s to %s refused: %v", r.RemoteAddr, r.URL.Path, err)
+        w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+        unauthorized(w, r, "invalid_token", "Invalid API token")
+        return
+      }
+      w.Header().Set("Cache-Control", "no-store")
+      next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+      return
+    }
+    if id, ok := a.Current(r); ok {
+      if changes(r.Method) {
+        if err := checkCSRF(r, id.CSRF); err != nil {
+          csrfRefused(w, r, err)
+          return
+        }
+      }
+      w.Header().Set("Cache-Control", "no-store")
+      next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+      return
+    }
+    if api(r.URL.Path) {
+      w.Header().Set("WWW-Authenticate", "Bearer")
+      unauthorized(w, r, "unauthorized", "Sign in or send an API token")
+      return
+    }
+    if r.Method == http.MethodGet || r.Method == http.MethodHead {
+      http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+      return
+    }
+    http.Error(w, "Sign in required", http.StatusUnauthorized)
+  })
+}
+
+// api reports whether path belongs to the JSON API, whose clients get
+// errors in its envelope instead of the login page.
+func api(path string) bool {
+  return strings.HasPrefix(path, "/api/")
+}
+
+// unauthorized refuses r with 401, as JSON for the API.
+func unauthorized(w http.ResponseWriter, r *http.Request, code, msg string) {
+  refuse(w, r, http.StatusUnauthorized, code, msg)
+}
+
+// csrfRefused refuses r with 403 after a failed CSRF check.
+func csrfRefused(w http.ResponseWriter, r *http.Request, err error) {
+  utils.Warn("Request from %s to %s %s refused: %v", clientAddr(r), r.Method, r.URL.Path, err)
+  refuse(w, r, http.StatusForbidden, "csrf", "The request did not come from a kea-web page; reload the page and try again")
+}
+
+func refuse(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+  if !api(r.URL.Path) {
+    http.Error(w, msg, status)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  _ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"code": code, "message": msg}})
+}
+
+// Current returns the identity of r's session cookie. The account is
+// looked up on every request, so role changes apply at once and the
+// sessions of removed accounts end.
+func (a *Auth) Current(r *http.Request) (Identity, bool) {
+  c, err := r.Cookie(CookieName)
+  if err != nil {
+    return Identity{}, false
+  }
+  sess, ok := a.Sessions.Get(c.Value)
+  if !ok {
+    return Identity{}, false
+  }
+  if sess.External {
+    // A local account created under the name since ends the session.
+    if a.localName(sess.User) {
+      a.Sessions.Delete(c.Value)
+      return Identity{}, false
+    }
+    return Identity{User: sess.User, Access: sess.Access, CSRF: sess.CSRF, External: true}, true
+  }
+  user, err := a.Users.Get(sess.User)
+  if errors.Is(err, ErrNoUser) {
+    a.Sessions.Delete(c.Value)
+  }
+  if err != nil {
+    return Identity{}, false
+  }
+  return Identity{User: user.Name, Access: user.Access, CSRF: sess.CSRF}, true
+}
+
+// Authenticate checks a user name and password against the local
+// accounts, then the directory for names without one. External reports
+// whether the identity came from the directory.
+func (a *Auth) Authenticate(name, password string) (id Identity, external bool, err error) {
+  user, err := a.Users.Authenticate(name, password)
+  if err == nil {
+    return Identity{User: user.Name, Access: user.Access}, false, nil
+  }
+  if !errors.Is(err, ErrBadCredentials) || a.LDAP == nil {
+    return Identity{}, false, err
+  }
+  if _, err := a.Users.Get(name); !errors.Is(err, ErrNoUser) {
+    return Identity{}, false, ErrBadCredentials
+  }
+  access, err := a.LDAP.Authenticate(name, password)
+  if err != nil {
+    return Identity{}, false, err
+  }
+  return Identity{User: name, Access: access}, true, nil
+}
+
+// bearer returns the token of an "Authorization: Bearer" header.
+func bearer(r *http.Request) (string, bool) {
+  scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+  if !ok || !strings.EqualFold(scheme, "Bearer") {
+    return "", false
+  }
+  return strings.TrimSpace(token), true
+}
+
+// Bearer returns the identity of an API token. Personal tokens of
+// local users take the account's current access, so they end with the
+// account.
+func (a *Auth) Bearer(secret string, r *http.Request) (Identity, error) {
+  if a.Tokens == nil {
+    return Identity{}, ErrBadToken
+  }
+  tok, err := a.Tokens.Use(secret, clientAddr(r))
+  if err != nil {
+    return Identity{}, err
+  }
+  id := Identity{User: tok.User(), Access: tok.Access, Token: tok.ID, Scopes: tok.Scopes, External: tok.External}
+  if tok.External && a.localName(tok.Owner) {
+    return Identity{}, fmt.Errorf("token %s of %s: %w", tok.ID, tok.Owner, ErrLocalName)
+  }
+  if !tok.Service() && !tok.External {
+    user, err := a.Users.Get(tok.Owner)
+    if err != nil {
+      return Identity{}, fmt.Errorf("token %s of %s: %w", tok.ID, tok.Owner, err)
+    }
+    id.User, id.Access = user.Name, user.Access
+  }
+  return id, nil
+}
+
+// secure reports whether the browser reached us over HTTPS, directly or
+// through a proxy.
+func secure(r *http.Request) bool {
+  return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// Start signs a local user in on the browser of r.
+func (a *Auth) Start(w http.ResponseWriter, r *http.Request, user string) {
+  a.setSession(w, r, a.Sessions.Create(user))
+}
+
+// StartExternal signs in a user authenticated by an identity provider,
+// with the access mapped from its groups. Names of local accounts are
+// refused with ErrLocalName.
+func (a *Auth) StartExternal(w http.ResponseWriter, r *http.Request, user string, access Access) error {
+  if a.localName(user) {
+    return fmt.Errorf("%s: %w", user, ErrLocalName)
+  }
+  a.setSession(w, r, a.Sessions.CreateExternal(user, access))
+  return nil
+}
+
+// localName reports whether user may name a local account. Errors
+// reading the accounts count as a match, so they refuse rather than
+// let an external user through.
+func (a *Auth) localName(user string) bool {
+  _, err := a.Users.Get(user)
+  return !errors.Is(err, ErrNoUser)
+}
+
+func (a *Auth) setSession(w http.ResponseWriter, r *http.Request, token string) {
+  // A session cookie: it also ends when the browser closes.
+  http.SetCookie(w, &http.Cookie{
+    Name:     CookieName,
+    Value:    token,
+    Path:     "/",
+    HttpOnly: true,
+    Secure:   secure(r),
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+// End signs the browser of r out.
+func (a *Auth) End(w http.ResponseWriter, r *http.Request) {
+  if c, err := r.Cookie(CookieName); err == nil {
+    a.Sessions.Delete(c.Value)
+  }
+  http.SetCookie(w, &http.Cookie{
+    Name:     CookieName,
+    Value:    "",
+    Path:     "/",
+    MaxAge:   -1,
+    HttpOnly: true,
+    Secure:   secure(r),
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+func clientAddr(r *http.Request) string {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+  return host
+}
+
+// recentFailures drops failures older than the window. The caller holds
+// mu.
+func (a *Auth) recentFailures(addr string, now time.Time) []time.Time {
+  var recent []time.Time
+  for _, t := range a.failures[addr] {
+    if now.Sub(t) < failureWindow {
+      recent = append(recent, t)
+    }
+  }
+  if recent == nil {
+    delete(a.failures, addr)
+  } else {
+    a.failures[addr] = recent
+  }
+  return recent
+}
+
+// Throttled reports whether sign-in attempts from r's address are
+// refused for now.
+func (a *Auth) Throttled(r *http.Request) bool {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  return len(a.recentFailures(clientAddr(r), time.Now())) >= maxFailures
+}
+
+// Failed records a failed sign-in attempt from r's address.
+func (a *Auth) Failed(r *http.Request) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  addr, now := clientAddr(r), time.Now()
+  a.failures[addr] = append(a.recentFailures(addr, now), now)
+}
+
+// Succeeded clears the failures of r's address.
+func (a *Auth) Succeeded(r *http.Request) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  delete(a.failures, clientAddr(r))
+}
+
+// SafeNext returns the local path to go to after signing in, "/" when
+// next isn't one.
+func SafeNext(next string) string {
+  if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") || strings.HasPrefix(next, "/login") {
+    return "/"
+  }
+  return next
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testAuth(t *testing.T) *Auth {
+  t.Helper()
+  users, err := NewUsers(filepath.Join(t.TempDir(), "users.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := users.Add("alice", "alice-pass-12345", Access{Role: RoleViewer}); err != nil {
+    t.Fatal(err)
+  }
+  tokens, err := NewTokens(filepath.Join(t.TempDir(), "tokens.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  a := New(users, NewSessions(time.Hour, 24*time.Hour))
+  a.Tokens = tokens
+  return a
+}
+
+// session returns a request carrying the session cookie set on w.
+func session(w *httptest.ResponseRecorder) *http.Request {
+  r := httptest.NewRequest(http.MethodGet, "/", nil)
+  for _, c := range w.Result().Cookies() {
+    r.AddCookie(c)
+  }
+  return r
+}
+
+func TestStartExternalLocalName(t *testing.T) {
+  a := testAuth(t)
+  for _, name := range []string{"alice", "ALICE"} {
+    w := httptest.NewRecorder()
+    err := a.StartExternal(w, httptest.NewRequest(http.MethodGet, "/", nil), name, Access{Role: RoleAdmin})
+    if !errors.Is(err, ErrLocalName) {
+      t.Errorf("%s: err = %v", name, err)
+    }
+    if len(w.Result().Cookies()) != 0 {
+      t.Errorf("%s: session started", name)
+    }
+  }
+}
+
+func TestExternalSession(t *testing.T) {
+  a := testAuth(t)
+  w := httptest.NewRecorder()
+  if err := a.StartExternal(w, httptest.NewRequest(http.MethodGet, "/", nil), "bob", Access{Role: RoleOperator}); err != nil {
+    t.Fatal(err)
+  }
+  r := session(w)
+  id, ok := a.Current(r)
+  if !ok || id.User != "bob" || !id.External || id.Role != RoleOperator {
+    t.Fatalf("Current = %+v, %v", id, ok)
+  }
+
+  // A token of bob keeps the access of the external sign-in.
+  secret, _, err := a.Tokens.Create(Token{Name: "t", Owner: id.User, External: true, Access: id.Access, Scopes: []Scope{ScopeReadLeases}})
+  if err != nil {
+    t.Fatal(err)
+  }
+  tid, err := a.Bearer(secret, r)
+  if err != nil || tid.User != "bob" || !tid.External || tid.Role != RoleOperator {
+    t.Fatalf("Bearer = %+v, %v", tid, err)
+  }
+
+  // A local bob created since is someone else: the session and the
+  // token of the external bob end.
+  if err := a.Users.Add("bob", "bob-pass-123456", Access{Role: RoleAdmin}); err != nil {
+    t.Fatal(err)
+  }
+  if id, ok := a.Current(r); ok {
+    t.Errorf("external session continues as %+v", id)
+  }
+  if _, err := a.Bearer(secret, r); !errors.Is(err, ErrLocalName) {
+    t.Errorf("Bearer err = %v", err)
+  }
+}
+
+func TestTokenOwnedBy(t *testing.T) {
+  local := Identity{User: "bob"}
+  external := Identity{User: "Bob", External: true}
+  tests := []struct {
+    tok   Token
+    id    Identity
+    owned bool
+  }{
+    {Token{Owner: "bob"}, local, true},
+    {Token{Owner: "bob"}, external, false},
+    {Token{Owner: "bob", External: true}, external, true},
+    {Token{Owner: "bob", External: true}, local, false},
+    {Token{Name: "bob"}, local, false},
+    {Token{Owner: "carol"}, local, false},
+  }
+  for _, tt := range tests {
+    if got := tt.tok.OwnedBy(tt.id); got != tt.owned {
+      t.Errorf("%+v OwnedBy %+v = %v", tt.tok, tt.id, got)
+    }
+  }
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The form field and header that carry the CSRF token of a session.
+const (
+  CSRFField  = "csrf_token"
+  CSRFHeader = "X-CSRF-Token"
+)
+
+// ErrCSRF refuses a request that may have been forged by another site.
+var ErrCSRF = errors.New("CSRF check failed")
+
+// maxCSRFPeek bounds how much of a multipart body is read ahead to find
+// the token.
+const maxCSRFPeek = 64 * 1024
+
+// changes reports whether a request method may change something.
+func changes(method string) bool {
+  switch method {
+  case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+    return true
+  }
+  return false
+}
+
+// checkCSRF vets a state-changing request of a browser session. Forms
+// must carry the session's token, which the pages put in every form;
+// other bodies, such as JSON, must come from a page of this site, as
+// its Origin or Referer tells, unless they carry the token in the
+// header. A foreign Origin or Referer always fails.
+func checkCSRF(r *http.Request, token string) error {
+  if err := checkOrigin(r, false); err != nil {
+    return err
+  }
+  if sent := r.Header.Get(CSRFHeader); sent != "" {
+    return matchCSRF(sent, token)
+  }
+  mt, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  switch mt {
+  case "application/x-www-form-urlencoded":
+    return matchCSRF(r.PostFormValue(CSRFField), token)
+  case "multipart/form-data":
+    sent, err := multipartCSRF(r, params["boundary"])
+    if err != nil {
+      return err
+    }
+    return matchCSRF(sent, token)
+  }
+  return checkOrigin(r, true)
+}
+
+func matchCSRF(sent, token string) error {
+  if sent == "" {
+    return fmt.Errorf("%w: no token", ErrCSRF)
+  }
+  if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+    return fmt.Errorf("%w: wrong token", ErrCSRF)
+  }
+  return nil
+}
+
+// multipartCSRF reads the token from the first part of a multipart
+// body, where the pages put it, and puts back what it read, so the
+// handler parses the whole body with its own limits.
+func multipartCSRF(r *http.Request, boundary string) (string, error) {
+  if boundary == "" {
+    return "", fmt.Errorf("%w: multipart body without boundary", ErrCSRF)
+  }
+  var read bytes.Buffer
+  body := r.Body
+  defer func() {
+    r.Body = struct {
+      io.Reader
+      io.Closer
+    }{io.MultiReader(&read, body), body}
+  }()
+
+  mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxCSRFPeek), &read), boundary)
+  part, err := mr.NextPart()
+  if err != nil || part.FormName() != CSRFField {
+    return "", nil
+  }
+  value, err := io.ReadAll(io.LimitReader(part, 256))
+  if err != nil {
+    return "", nil
+  }
+  return string(value), nil
+}
+
+// checkOrigin compares the Origin of r, or its Referer without one,
+// with the host r was sent to. required also fails requests that carry
+// neither.
+func checkOrigin(r *http.Request, required bool) error {
+  from := r.Header.Get("Origin")
+  if from == "" || from == "null" {
+    from = r.Header.Get("Referer")
+  }
+  if from == "" {
+    if required {
+      return fmt.Errorf("%w: no Origin or Referer", ErrCSRF)
+    }
+    return nil
+  }
+  u, err := url.Parse(from)
+  if err != nil || u.Host == "" {
+    return fmt.Errorf("%w: bad origin %q", ErrCSRF, from)
+  }
+  for _, host := range []string{r.Host, r.Header.Get("X-Forwarded-Host")} {
+    if host != "" && strings.EqualFold(u.Host, host) {
+      return nil
+    }
+  }
+  return fmt.Errorf("%w: request from %s to %s", ErrCSRF, u.Host, r.Host)
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// ErrNoRole is returned for directory users whose groups map to no role.
+var ErrNoRole = errors.New("no kea-web role")
+
+// LDAPConfig describes the directory users sign in against.
+type LDAPConfig struct {
+  // URL is ldap://HOST[:PORT] or ldaps://HOST[:PORT].
+  URL string
+  // StartTLS upgrades ldap:// connections before binding.
+  StartTLS bool
+  // CAFile holds the certificates trusted for the directory; empty uses
+  // the system pool.
+  CAFile             string
+  InsecureSkipVerify bool
+  // BindDN and BindPassword are the service account that looks users
+  // up; empty binds anonymously.
+  BindDN       string
+  BindPassword string
+  BaseDN       string
+  // UserFilter finds the entry of a user; {username} is replaced with
+  // the escaped name typed at sign-in.
+  UserFilter string
+  // GroupBaseDN is searched with GroupFilter for the groups of an entry
+  // whose DN replaces {dn}. Groups listed in the entry's memberOf count
+  // as well; an empty GroupFilter uses memberOf alone.
+  GroupBaseDN string
+  GroupFilter string
+  // NestedGroups also looks up the groups of groups. Active Directory
+  // can do that itself with a GroupFilter such as
+  // (member:1.2.840.113556.1.4.1941:={dn}).
+  NestedGroups bool
+  // GroupNames lets rules match a group by name, the value of its first
+  // RDN, as well as by DN. Any group of that name under the searched
+  // base then matches, so it is off unless asked for.
+  GroupNames bool
+  // Roles maps group DNs, or names with GroupNames, to access.
+  Roles RoleMap
+  // Timeout bounds connecting, each request and waiting for a pooled
+  // connection.
+  Timeout  time.Duration
+  PoolSize int
+}
+
+// Enabled reports whether a directory is configured.
+func (c LDAPConfig) Enabled() bool {
+  return c.URL != ""
+}
+
+// LDAPConfigFromEnv reads the LDAP_* variables.
+func LDAPConfigFromEnv(e utils.Env) (LDAPConfig, error) {
+  cfg := LDAPConfig{
+    URL:                e.LDAP_URL,
+    StartTLS:           e.LDAP_STARTTLS,
+    CAFile:             e.LDAP_CA_FILE,
+    InsecureSkipVerify: e.LDAP_INSECURE_SKIP_VERIFY,
+    BindDN:             e.LDAP_BIND_DN,
+    BindPassword:       e.LDAP_BIND_PASSWORD,
+    BaseDN:             e.LDAP_BASE_DN,
+    UserFilter:         e.LDAP_USER_FILTER,
+    GroupBaseDN:        e.LDAP_GROUP_BASE_DN,
+    GroupFilter:        e.LDAP_GROUP_FILTER,
+    NestedGroups:       e.LDAP_NESTED_GROUPS,
+    GroupNames:         e.LDAP_GROUP_NAMES,
+    Timeout:            e.LDAP_TIMEOUT,
+    PoolSize:           e.LDAP_POOL_SIZE,
+  }
+  if !cfg.Enabled() {
+    return cfg, nil
+  }
+  u, err := url.Parse(cfg.URL)
+  if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+    return cfg, fmt.Errorf("LDAP_URL %q: want ldap://HOST or ldaps://HOST", cfg.URL)
+  }
+  if cfg.StartTLS && u.Scheme == "ldaps" {
+    return cfg, errors.New("LDAP_STARTTLS needs an ldap:// URL; ldaps:// is TLS already")
+  }
+  if cfg.BaseDN == "" {
+    return cfg, errors.New("LDAP needs a base DN")
+  }
+  if !strings.Contains(cfg.UserFilter, "{username}") {
+    return cfg, fmt.Errorf("LDAP_USER_FILTER %q lacks {username}", cfg.UserFilter)
+  }
+  if cfg.GroupFilter == "none" {
+    cfg.GroupFilter = ""
+  }
+  if cfg.GroupFilter != "" && !strings.Contains(cfg.GroupFilter, "{dn}") {
+    return cfg, fmt.Errorf("LDAP_GROUP_FILTER %q lacks {dn}", cfg.GroupFilter)
+  }
+  if e.LDAP_ROLE_MAP != "" {
+    rules, err := ParseRoleRules(e.LDAP_ROLE_MAP)
+    if err != nil {
+      return cfg, fmt.Errorf("LDAP_ROLE_MAP: %w", err)
+    }
+    cfg.Roles.Rules = rules
+  }
+  if e.LDAP_DEFAULT_ROLE != "" {
+    cfg.Roles.Default = Role(e.LDAP_DEFAULT_ROLE)
+  }
+  return cfg, cfg.Roles.Check()
+}
+
+// LDAP signs users in by binding as them. Lookups run on a small pool
+// of connections bound as the service account, so a sign-in costs no
+// new connection, and at most PoolSize run at once.
+type LDAP struct {
+  cfg LDAPConfig
+  tls *tls.Config
+
+  // slots holds a token per connection in use; idle holds the bound
+  // connections waiting for the next sign-in.
+  slots chan struct{}
+  idle  chan *ldap.Conn
+}
+
+// NewLDAP returns the directory sign-in of cfg.
+func NewLDAP(cfg LDAPConfig) (*LDAP, error) {
+  if cfg.Timeout <= 0 {
+    cfg.Timeout = 5 * time.Second
+  }
+  if cfg.PoolSize <= 0 {
+    cfg.PoolSize = 4
+  }
+  if cfg.GroupBaseDN == "" {
+    cfg.GroupBaseDN = cfg.BaseDN
+  }
+  u, err := url.Parse(cfg.URL)
+  if err != nil {
+    return nil, err
+  }
+  tc := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify}
+  if cfg.CAFile != "" {
+    pem, err := os.ReadFile(cfg.CAFile)
+    if err != nil {
+      return nil, err
+    }
+    tc.RootCAs = x509.NewCertPool()
+    if !tc.RootCAs.AppendCertsFromPEM(pem) {
+      return nil, fmt.Errorf("%s: no certificates", cfg.CAFile)
+    }
+  }
+  return &LDAP{
+    cfg:   cfg,
+    tls:   tc,
+    slots: make(chan struct{}, cfg.PoolSize),
+    idle:  make(chan *ldap.Conn, cfg.PoolSize),
+  }, nil
+}
+
+// dial connects to the directory and binds as the service account.
+func (l *LDAP) dial() (*ldap.Conn, error) {
+  conn, err := ldap.DialURL(l.cfg.URL,
+    ldap.DialWithDialer(&net.Dialer{Timeout: l.cfg.Timeout}),
+    ldap.DialWithTLSConfig(l.tls))
+  if err != nil {
+    return nil, fmt.Errorf("ldap: %w", err)
+  }
+  conn.SetTimeout(l.cfg.Timeout)
+  if l.cfg.StartTLS {
+    if err := conn.StartTLS(l.tls); err != nil {
+      conn.Close()
+      return nil, fmt.Errorf("ldap starttls: %w", err)
+    }
+  }
+  if err := l.bindService(conn); err != nil {
+    conn.Close()
+    return nil, err
+  }
+  return conn, nil
+}
+
+func (l *LDAP) bindService(conn *ldap.Conn) error {
+  var err error
+  if l.cfg.BindDN == "" {
+    err = conn.UnauthenticatedBind("")
+  } else {
+    err = conn.Bind(l.cfg.BindDN, l.cfg.BindPassword)
+  }
+  if err != nil {
+    return fmt.Errorf("ldap service bind: %w", err)
+  }
+  return nil
+}
+
+// get returns a bound connection, waiting at most Timeout for one to
+// be free.
+func (l *LDAP) get() (*ldap.Conn, error) {
+  t := time.NewTimer(l.cfg.Timeout)
+  defer t.Stop()
+  select {
+  case l.slots <- struct{}{}:
+  case <-t.C:
+    return nil, errors.New("ldap: every directory connection is busy")
+  }
+  for {
+    select {
+    case conn := <-l.idle:
+      if conn.IsClosing() {
+        conn.Close()
+        continue
+      }
+      return conn, nil
+    default:
+    }
+    conn, err := l.dial()
+    if err != nil {
+      <-l.slots
+      return nil, err
+    }
+    return conn, nil
+  }
+}
+
+// put gives a connection back, closing it when it can't be trusted to
+// be bound as the service account.
+func (l *LDAP) put(conn *ldap.Conn, broken bool) {
+  defer func() { <-l.slots }()
+  if broken || conn.IsClosing() {
+    conn.Close()
+    return
+  }
+  select {
+  case l.idle <- conn:
+  default:
+    conn.Close()
+  }
+}
+
+// Authenticate checks a user's password by binding as them and returns
+// the access mapped from their groups. Unknown users and wrong
+// passwords are ErrBadCredentials; users matching no role are
+// ErrNoRole.
+func (l *LDAP) Authenticate(name, password string) (Access, error) {
+  // An empty password is an unauthenticated bind, which succeeds.
+  if name == "" || password == "" {
+    return Access{}, ErrBadCredentials
+  }
+  conn, err := l.get()
+  if err != nil {
+    return Access{}, err
+  }
+  broken := false
+  defer func() { l.put(conn, broken) }()
+
+  res, err := conn.Search(ldap.NewSearchRequest(
+    l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(l.cfg.Timeout/time.Second), false,
+    strings.ReplaceAll(l.cfg.UserFilter, "{username}", ldap.EscapeFilter(name)),
+    []string{"memberOf"}, nil))
+  if err != nil {
+    broken = true
+    return Access{}, fmt.Errorf("ldap user search: %w", err)
+  }
+  if len(res.Entries) != 1 {
+    return Access{}, ErrBadCredentials
+  }
+  entry := res.Entries[0]
+
+  // The user's bind replaces the service account's on this connection,
+  // which is bound back before it returns to the pool.
+  bindErr := conn.Bind(entry.DN, password)
+  if err := l.bindService(conn); err != nil {
+    broken = true
+    if bindErr == nil {
+      return Access{}, err
+    }
+  }
+  if bindErr != nil {
+    if ldap.IsErrorWithCode(bindErr, ldap.LDAPResultInvalidCredentials) {
+      return Access{}, ErrBadCredentials
+    }
+    broken = true
+    return Access{}, fmt.Errorf("ldap bind: %w", bindErr)
+  }
+
+  groups, err := l.groups(conn, entry)
+  if err != nil {
+    broken = true
+    return Access{}, err
+  }
+  access, ok := l.cfg.Roles.Map(groups)
+  if !ok {
+    return Access{}, fmt.Errorf("%w for %s", ErrNoRole, name)
+  }
+  return access, nil
+}
+
+// groups returns the DNs of the groups of entry, and their names when
+// GroupNames is set. The user's groups are its memberOf values and the
+// GroupFilter matches; with NestedGroups, the groups of each group are
+// found the same way, reading memberOf from the group's own entry.
+func (l *LDAP) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+  seen := map[string]bool{}
+  var found []string
+  pending := []*ldap.Entry{entry}
+  for len(pending) > 0 {
+    e := pending[0]
+    pending = pending[1:]
+    parents := e.GetAttributeValues("memberOf")
+    if l.cfg.GroupFilter != "" {
+      res, err := conn.Search(ldap.NewSearchRequest(
+        l.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(l.cfg.Timeout/time.Second), false,
+        strings.ReplaceAll(l.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(e.DN)),
+        []string{"dn"}, nil))
+      if err != nil {
+        return nil, fmt.Errorf("ldap group search: %w", err)
+      }
+      for _, g := range res.Entries {
+        parents = append(parents, g.DN)
+      }
+    }
+    for _, dn := range parents {
+      key := strings.ToLower(dn)
+      if seen[key] {
+        continue
+      }
+      seen[key] = true
+      found = append(found, dn)
+      if !l.cfg.NestedGroups {
+        continue
+      }
+      g, err := l.group(conn, dn)
+      if err != nil {
+        return nil, err
+      }
+      pending = append(pending, g)
+    }
+  }
+
+  out := found
+  if l.cfg.GroupNames {
+    for _, dn := range found {
+      if parsed, err := ldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) > 0 {
+        out = append(out, parsed.RDNs[0].Attributes[0].Value)
+      }
+    }
+  }
+  return out, nil
+}
+
+// group reads the memberOf of the group entry dn. A group outside what
+// the service account can see has none.
+func (l *LDAP) group(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+  res, err := conn.Search(ldap.NewSearchRequest(
+    dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(l.cfg.Timeout/time.Second), false,
+    "(objectClass=*)", []string{"memberOf"}, nil))
+  if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(res.Entries) == 0) {
+    return &ldap.Entry{DN: dn}, nil
+  }
+  if err != nil {
+    return nil, fmt.Errorf("ldap group %s: %w", dn, err)
+  }
+  return res.Entries[0], nil
+}
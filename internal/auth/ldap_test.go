@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// LDAP result codes the stand-in directory answers with.
+const (
+  ldapSuccess            = 0
+  ldapNoSuchObject       = 32
+  ldapInvalidCredentials = 49
+)
+
+// stubEntry is an entry of the stand-in directory. Attribute names are
+// lower case.
+type stubEntry struct {
+  dn       string
+  password string
+  attrs    map[string][]string
+}
+
+// stubDirectory is an LDAP server on the loopback address that knows
+// simple binds, searches with equality, presence, and and or filters,
+// and unbinds.
+type stubDirectory struct {
+  entries []stubEntry
+}
+
+// testDirectory holds two people and these groups, lower ones members
+// of the ones above them:
+//
+//	cn=kea-ops,ou=groups       cn=kea-admins,ou=groups    cn=loop-b,ou=groups
+//	cn=net-ops,ou=groups                                   cn=loop-a,ou=groups
+//	alice                                                  alice
+//
+//	cn=kea-admins,ou=lab
+//	bob
+//
+// memberOf lists the direct groups of an entry; member is filled in
+// only for alice's, so the group filter finds her groups but not bob's.
+func testDirectory() *stubDirectory {
+  return &stubDirectory{entries: []stubEntry{
+    {dn: "cn=svc,dc=ex", password: "svc-pass"},
+    {dn: "uid=alice,ou=people,dc=ex", password: "alice-pass", attrs: map[string][]string{
+      "uid":      {"alice"},
+      "memberof": {"cn=net-ops,ou=groups,dc=ex", "cn=loop-a,ou=groups,dc=ex"},
+    }},
+    {dn: "uid=bob,ou=people,dc=ex", password: "bob-pass", attrs: map[string][]string{
+      "uid":      {"bob"},
+      "memberof": {"cn=kea-admins,ou=lab,dc=ex"},
+    }},
+    {dn: "cn=net-ops,ou=groups,dc=ex", attrs: map[string][]string{
+      "member":   {"uid=alice,ou=people,dc=ex"},
+      "memberof": {"cn=kea-ops,ou=groups,dc=ex"},
+    }},
+    {dn: "cn=kea-ops,ou=groups,dc=ex", attrs: map[string][]string{
+      "member": {"cn=net-ops,ou=groups,dc=ex"},
+    }},
+    {dn: "cn=kea-admins,ou=groups,dc=ex"},
+    {dn: "cn=kea-admins,ou=lab,dc=ex"},
+    {dn: "cn=loop-a,ou=groups,dc=ex", attrs: map[string][]string{
+      "member":   {"uid=alice,ou=people,dc=ex"},
+      "memberof": {"cn=loop-b,ou=groups,dc=ex"},
+    }},
+    {dn: "cn=loop-b,ou=groups,dc=ex", attrs: map[string][]string{
+      "member":   {"cn=loop-a,ou=groups,dc=ex"},
+      "memberof": {"cn=loop-a,ou=groups,dc=ex"},
+    }},
+  }}
+}
+
+// start serves the directory until the test ends and returns its URL.
+func (d *stubDirectory) start(t *testing.T) string {
+  t.Helper()
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  var wg sync.WaitGroup
+  var mu sync.Mutex
+  var conns []net.Conn
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    for {
+      c, err := l.Accept()
+      if err != nil {
+        return
+      }
+      mu.Lock()
+      conns = append(conns, c)
+      mu.Unlock()
+      wg.Add(1)
+      go func() {
+        defer wg.Done()
+        d.serve(c)
+      }()
+    }
+  }()
+  t.Cleanup(func() {
+    l.Close()
+    mu.Lock()
+    for _, c := range conns {
+      c.Close()
+    }
+    mu.Unlock()
+    wg.Wait()
+  })
+  return "ldap://" + l.Addr().String()
+}
+
+func (d *stubDirectory) find(dn string) *stubEntry {
+  for i, e := range d.entries {
+    if strings.EqualFold(e.dn, dn) {
+      return &d.entries[i]
+    }
+  }
+  return nil
+}
+
+func (d *stubDirectory) serve(c net.Conn) {
+  defer c.Close()
+  for {
+    p, err := ber.ReadPacket(c)
+    if err != nil || len(p.Children) < 2 {
+      return
+    }
+    id, _ := p.Children[0].Value.(int64)
+    op := p.Children[1]
+    switch op.Tag {
+    case ber.Tag(0): // bind
+      dn, password := berString(op.Children[1]), berString(op.Children[2])
+      code := int64(ldapInvalidCredentials)
+      if e := d.find(dn); (dn == "" && password == "") || (e != nil && e.password != "" && e.password == password) {
+        code = ldapSuccess
+      }
+      c.Write(ldapResult(id, 1, code).Bytes())
+    case ber.Tag(2): // unbind
+      return
+    case ber.Tag(3): // search
+      base, filter := berString(op.Children[0]), op.Children[6]
+      scope, _ := op.Children[1].Value.(int64)
+      code := int64(ldapSuccess)
+      if scope == 0 {
+        if e := d.find(base); e == nil {
+          code = ldapNoSuchObject
+        } else if matches(e, filter) {
+          c.Write(ldapEntry(id, e).Bytes())
+        }
+      } else {
+        for i := range d.entries {
+          e := &d.entries[i]
+          if strings.HasSuffix(strings.ToLower(e.dn), ","+strings.ToLower(base)) && matches(e, filter) {
+            c.Write(ldapEntry(id, e).Bytes())
+          }
+        }
+      }
+      c.Write(ldapResult(id, 5, code).Bytes())
+    default:
+      return
+    }
+  }
+}
+
+func berString(p *ber.Packet) string {
+  return string(p.Data.Bytes())
+}
+
+// matches evaluates the filters the tests use.
+func matches(e *stubEntry, f *ber.Packet) bool {
+  switch f.Tag {
+  case ber.Tag(0): // and
+    for _, c := range f.Children {
+      if !matches(e, c) {
+        return false
+      }
+    }
+    return true
+  case ber.Tag(1): // or
+    for _, c := range f.Children {
+      if matches(e, c) {
+        return true
+      }
+    }
+    return false
+  case ber.Tag(3): // equality
+    attr, want := strings.ToLower(berString(f.Children[0])), berString(f.Children[1])
+    for _, v := range e.attrs[attr] {
+      if strings.EqualFold(v, want) {
+        return true
+      }
+    }
+    return false
+  case ber.Tag(7): // present
+    attr := strings.ToLower(berString(f))
+    return attr == "objectclass" || len(e.attrs[attr]) > 0
+  }
+  return false
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+  p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+  p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+  p.AppendChild(op)
+  return p
+}
+
+func ldapResult(id int64, app ber.Tag, code int64) *ber.Packet {
+  r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, app, nil, "")
+  r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+  r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+  r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+  return ldapMessage(id, r)
+}
+
+func ldapEntry(id int64, e *stubEntry) *ber.Packet {
+  r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "")
+  r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+  attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+  for name, values := range e.attrs {
+    a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+    // Directories send memberOf in its own case.
+    if name == "memberof" {
+      name = "memberOf"
+    }
+    a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+    set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+    for _, v := range values {
+      set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+    }
+    a.AppendChild(set)
+    attrs.AppendChild(a)
+  }
+  r.AppendChild(attrs)
+  return ldapMessage(id, r)
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+  url := testDirectory().start(t)
+  rules := []RoleRule{
+    {Value: "cn=kea-ops,ou=groups,dc=ex", Access: Access{Role: RoleOperator}},
+    {Value: "cn=kea-admins,ou=groups,dc=ex", Access: Access{Role: RoleAdmin}},
+    {Value: "loop-b", Access: Access{Role: RoleViewer}},
+    {Value: "kea-admins", Access: Access{Role: RoleAdmin}},
+  }
+  tests := []struct {
+    name     string
+    filter   string
+    nested   bool
+    names    bool
+    user     string
+    password string
+    role     Role
+    err      error
+  }{
+    // Without a filter, nesting comes from the groups' own memberOf.
+    {"memberOf nested", "", true, false, "alice", "alice-pass", RoleOperator, nil},
+    {"memberOf flat", "", false, false, "alice", "alice-pass", "", ErrNoRole},
+    {"filter nested", "(member={dn})", true, false, "alice", "alice-pass", RoleOperator, nil},
+    {"filter flat", "(member={dn})", false, false, "alice", "alice-pass", "", ErrNoRole},
+    // cn=kea-admins,ou=lab is not the admins group of the rules; its
+    // name matches only when names are asked for.
+    {"same name elsewhere", "(member={dn})", true, false, "bob", "bob-pass", "", ErrNoRole},
+    {"names", "(member={dn})", true, true, "bob", "bob-pass", RoleAdmin, nil},
+    {"names cycle", "", true, true, "alice", "alice-pass", RoleOperator, nil},
+    {"wrong password", "", true, false, "alice", "bob-pass", "", ErrBadCredentials},
+    {"unknown user", "", true, false, "carol", "carol-pass", "", ErrBadCredentials},
+    {"empty password", "", true, false, "alice", "", "", ErrBadCredentials},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      l, err := NewLDAP(LDAPConfig{
+        URL:          url,
+        BindDN:       "cn=svc,dc=ex",
+        BindPassword: "svc-pass",
+        BaseDN:       "dc=ex",
+        UserFilter:   "(&(objectClass=*)(uid={username}))",
+        GroupFilter:  tt.filter,
+        NestedGroups: tt.nested,
+        GroupNames:   tt.names,
+        Roles:        RoleMap{Rules: rules},
+        Timeout:      2 * time.Second,
+      })
+      if err != nil {
+        t.Fatal(err)
+      }
+      access, err := l.Authenticate(tt.user, tt.password)
+      if tt.err != nil {
+        if !errors.Is(err, tt.err) {
+          t.Fatalf("err = %v, want %v", err, tt.err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatal(err)
+      }
+      if access.Role != tt.role {
+        t.Errorf("role = %s, want %s", access.Role, tt.role)
+      }
+    })
+  }
+}
+
+func TestLDAPGroups(t *testing.T) {
+  d := testDirectory()
+  url := d.start(t)
+  l, err := NewLDAP(LDAPConfig{
+    URL:          url,
+    BindDN:       "cn=svc,dc=ex",
+    BindPassword: "svc-pass",
+    BaseDN:       "dc=ex",
+    NestedGroups: true,
+    Timeout:      2 * time.Second,
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  conn, err := l.get()
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer l.put(conn, false)
+
+  alice := d.find("uid=alice,ou=people,dc=ex")
+  groups, err := l.groups(conn, ldap.NewEntry(alice.dn, map[string][]string{"memberOf": alice.attrs["memberof"]}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{
+    "cn=net-ops,ou=groups,dc=ex",
+    "cn=loop-a,ou=groups,dc=ex",
+    "cn=kea-ops,ou=groups,dc=ex",
+    "cn=loop-b,ou=groups,dc=ex",
+  }
+  if strings.Join(groups, ";") != strings.Join(want, ";") {
+    t.Errorf("groups = %v, want %v", groups, want)
+  }
+}
+
+func TestLDAPConfigFromEnv(t *testing.T) {
+  env := utils.Env{
+    LDAP_URL:          "ldap://dc1.example.org",
+    LDAP_BASE_DN:      "dc=example,dc=org",
+    LDAP_USER_FILTER:  "(uid={username})",
+    LDAP_GROUP_FILTER: "none",
+    LDAP_GROUP_NAMES:  true,
+    LDAP_ROLE_MAP:     "cn=kea-admins,ou=groups,dc=example,dc=org=admin",
+  }
+  cfg, err := LDAPConfigFromEnv(env)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if cfg.GroupFilter != "" || !cfg.GroupNames || len(cfg.Roles.Rules) != 1 || cfg.Roles.Rules[0].Value != "cn=kea-admins,ou=groups,dc=example,dc=org" {
+    t.Errorf("config = %+v", cfg)
+  }
+
+  env.LDAP_GROUP_FILTER = "(member=x)"
+  if _, err := LDAPConfigFromEnv(env); err == nil {
+    t.Error("group filter without {dn} accepted")
+  }
+  env.LDAP_GROUP_FILTER, env.LDAP_URL = "", "ldaps://dc1"
+  env.LDAP_STARTTLS = true
+  if _, err := LDAPConfigFromEnv(env); err == nil {
+    t.Error("StartTLS on ldaps:// accepted")
+  }
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// oidcCookie carries a pending sign-in in the browser that started it.
+const oidcCookie = "kea_web_oidc"
+
+// oidcPendingTTL is how long the user has to finish at the provider.
+const oidcPendingTTL = 10 * time.Minute
+
+// OIDCConfig describes the OpenID Connect provider.
+type OIDCConfig struct {
+  Issuer       string `json:"issuer"`
+  ClientID     string `json:"client_id"`
+  ClientSecret string `json:"client_secret,omitempty"`
+  // RedirectURL is registered with the provider; empty derives it from
+  // the request as https://HOST/login/oidc/callback.
+  RedirectURL string   `json:"redirect_url,omitempty"`
+  Scopes      []string `json:"scopes,omitempty"`
+  // UsernameClaim names the user; it falls back to email, then sub.
+  UsernameClaim string `json:"username_claim,omitempty"`
+  // RoleClaim holds the groups or roles matched by Roles. A dotted path
+  // reaches into objects, e.g. "realm_access.roles".
+  RoleClaim string  `json:"role_claim,omitempty"`
+  Roles     RoleMap `json:"roles"`
+
+  // HTTPClient reaches the provider; nil uses a client with a timeout.
+  HTTPClient *http.Client `json:"-"`
+}
+
+// Enabled reports whether a provider is configured.
+func (c OIDCConfig) Enabled() bool {
+  return c.Issuer != ""
+}
+
+// OIDCConfigFromEnv reads the OIDC_CONFIG file, if any, then applies
+// the OIDC_* variables over it.
+func OIDCConfigFromEnv(e utils.Env) (OIDCConfig, error) {
+  var cfg OIDCConfig
+  if e.OIDC_CONFIG != "" {
+    b, err := os.ReadFile(e.OIDC_CONFIG)
+    if err != nil {
+      return cfg, err
+    }
+    if err := json.Unmarshal(b, &cfg); err != nil {
+      return cfg, fmt.Errorf("%s: %w", e.OIDC_CONFIG, err)
+    }
+  }
+  set := func(dst *string, v string) {
+    if v != "" {
+      *dst = v
+    }
+  }
+  set(&cfg.Issuer, e.OIDC_ISSUER)
+  set(&cfg.ClientID, e.OIDC_CLIENT_ID)
+  set(&cfg.ClientSecret, e.OIDC_CLIENT_SECRET)
+  set(&cfg.RedirectURL, e.OIDC_REDIRECT_URL)
+  set(&cfg.UsernameClaim, e.OIDC_USERNAME_CLAIM)
+  set(&cfg.RoleClaim, e.OIDC_ROLE_CLAIM)
+  if e.OIDC_SCOPES != "" {
+    cfg.Scopes = strings.Fields(strings.ReplaceAll(e.OIDC_SCOPES, ",", " "))
+  }
+  if e.OIDC_ROLE_MAP != "" {
+    rules, err := ParseRoleRules(e.OIDC_ROLE_MAP)
+    if err != nil {
+      return cfg, fmt.Errorf("OIDC_ROLE_MAP: %w", err)
+    }
+    cfg.Roles.Rules = rules
+  }
+  if e.OIDC_DEFAULT_ROLE != "" {
+    cfg.Roles.Default = Role(e.OIDC_DEFAULT_ROLE)
+  }
+
+  if !cfg.Enabled() {
+    return cfg, nil
+  }
+  if cfg.ClientID == "" {
+    return cfg, errors.New("OIDC needs a client ID")
+  }
+  return cfg, cfg.Roles.Check()
+}
+
+// oidcPending is a sign-in waiting for the provider's callback. It is
+// kept signed in the oidcCookie rather than on the server, so hitting
+// /login/oidc costs no memory.
+type oidcPending struct {
+  State    string `json:"state"`
+  Verifier string `json:"verifier"`
+  Nonce    string `json:"nonce"`
+  Next     string `json:"next"`
+  Created  int64  `json:"created"`
+}
+
+// OIDC signs users in with the authorization code flow and PKCE. The
+// provider is discovered on first use, so kea-web starts while it is
+// unreachable; its signing keys are cached and refetched when a token
+// names an unknown key.
+type OIDC struct {
+  cfg OIDCConfig
+
+  // key signs the oidcCookie; pending sign-ins end with the process.
+  key []byte
+
+  mu       sync.Mutex
+  provider *oidc.Provider
+  verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDC returns the OIDC sign-in of cfg.
+func NewOIDC(cfg OIDCConfig) *OIDC {
+  if len(cfg.Scopes) == 0 {
+    cfg.Scopes = []string{"profile", "email", "groups"}
+  }
+  if cfg.UsernameClaim == "" {
+    cfg.UsernameClaim = "preferred_username"
+  }
+  if cfg.RoleClaim == "" {
+    cfg.RoleClaim = "groups"
+  }
+  if cfg.HTTPClient == nil {
+    cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+  }
+  key := make([]byte, 32)
+  rand.Read(key)
+  return &OIDC{cfg: cfg, key: key}
+}
+
+func (o *OIDC) context(ctx context.Context) context.Context {
+  return oidc.ClientContext(ctx, o.cfg.HTTPClient)
+}
+
+// discover returns the provider, fetching its metadata the first time.
+// The fetch runs unlocked, so a provider that doesn't answer holds up
+// only the requests waiting on it; the first to succeed is kept.
+func (o *OIDC) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+  o.mu.Lock()
+  p, v := o.provider, o.verifier
+  o.mu.Unlock()
+  if p != nil {
+    return p, v, nil
+  }
+
+  p, err := oidc.NewProvider(o.context(ctx), o.cfg.Issuer)
+  if err != nil {
+    return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+  }
+  o.mu.Lock()
+  defer o.mu.Unlock()
+  if o.provider == nil {
+    o.provider = p
+    o.verifier = p.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+  }
+  return o.provider, o.verifier, nil
+}
+
+// oauth2Config returns the client configuration, with the redirect URL
+// worked out for r when none is configured.
+func (o *OIDC) oauth2Config(r *http.Request, p *oidc.Provider) *oauth2.Config {
+  redirect := o.cfg.RedirectURL
+  if redirect == "" {
+    scheme := "http"
+    if secure(r) {
+      scheme = "https"
+    }
+    redirect = scheme + "://" + r.Host + "/login/oidc/callback"
+  }
+  return &oauth2.Config{
+    ClientID:     o.cfg.ClientID,
+    ClientSecret: o.cfg.ClientSecret,
+    Endpoint:     p.Endpoint(),
+    RedirectURL:  redirect,
+    Scopes:       append([]string{oidc.ScopeOpenID}, o.cfg.Scopes...),
+  }
+}
+
+// seal returns pend as a cookie value: its JSON and an HMAC of it.
+func (o *OIDC) seal(pend oidcPending) string {
+  b, _ := json.Marshal(pend)
+  payload := base64.RawURLEncoding.EncodeToString(b)
+  return payload + "." + base64.RawURLEncoding.EncodeToString(o.mac(payload))
+}
+
+// open returns the pending sign-in of a cookie value made by seal.
+func (o *OIDC) open(value string) (oidcPending, bool) {
+  var pend oidcPending
+  payload, sig, _ := strings.Cut(value, ".")
+  got, err := base64.RawURLEncoding.DecodeString(sig)
+  if err != nil || !hmac.Equal(got, o.mac(payload)) {
+    return pend, false
+  }
+  b, err := base64.RawURLEncoding.DecodeString(payload)
+  if err != nil || json.Unmarshal(b, &pend) != nil {
+    return pend, false
+  }
+  return pend, true
+}
+
+func (o *OIDC) mac(payload string) []byte {
+  m := hmac.New(sha256.New, o.key)
+  m.Write([]byte(payload))
+  return m.Sum(nil)
+}
+
+func randomString() string {
+  b := make([]byte, 32)
+  rand.Read(b)
+  return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Begin sends the browser to the provider, remembering where to go
+// after signing in.
+func (o *OIDC) Begin(w http.ResponseWriter, r *http.Request, next string) error {
+  p, _, err := o.discover(r.Context())
+  if err != nil {
+    return err
+  }
+  pend := oidcPending{
+    State:    randomString(),
+    Verifier: oauth2.GenerateVerifier(),
+    Nonce:    randomString(),
+    Next:     next,
+    Created:  time.Now().Unix(),
+  }
+  http.SetCookie(w, &http.Cookie{
+    Name:     oidcCookie,
+    Value:    o.seal(pend),
+    Path:     "/login/oidc",
+    MaxAge:   int(oidcPendingTTL / time.Second),
+    HttpOnly: true,
+    Secure:   secure(r),
+    SameSite: http.SameSiteLaxMode,
+  })
+  url := o.oauth2Config(r, p).AuthCodeURL(pend.State, oauth2.S256ChallengeOption(pend.Verifier), oidc.Nonce(pend.Nonce))
+  http.Redirect(w, r, url, http.StatusFound)
+  return nil
+}
+
+// Finish handles the provider's callback: it exchanges the code,
+// verifies the ID token and maps its claims to a user name and access.
+// It returns the page to go to next.
+func (o *OIDC) Finish(w http.ResponseWriter, r *http.Request) (string, Access, string, error) {
+  q := r.URL.Query()
+  if e := q.Get("error"); e != "" {
+    return "", Access{}, "", fmt.Errorf("provider refused the sign-in: %s %s", e, q.Get("error_description"))
+  }
+  var pend oidcPending
+  c, err := r.Cookie(oidcCookie)
+  if err == nil {
+    pend, _ = o.open(c.Value)
+  }
+  if state := q.Get("state"); state == "" || pend.State != state {
+    return "", Access{}, "", errors.New("the sign-in didn't start in this browser; try again")
+  }
+  http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/login/oidc", MaxAge: -1, HttpOnly: true, Secure: secure(r)})
+  if time.Since(time.Unix(pend.Created, 0)) > oidcPendingTTL {
+    return "", Access{}, "", errors.New("the sign-in expired; try again")
+  }
+
+  p, verifier, err := o.discover(r.Context())
+  if err != nil {
+    return "", Access{}, "", err
+  }
+  ctx := o.context(r.Context())
+  tok, err := o.oauth2Config(r, p).Exchange(ctx, q.Get("code"), oauth2.VerifierOption(pend.Verifier))
+  if err != nil {
+    return "", Access{}, "", fmt.Errorf("oidc code exchange: %w", err)
+  }
+  raw, ok := tok.Extra("id_token").(string)
+  if !ok {
+    return "", Access{}, "", errors.New("oidc: the provider returned no ID token")
+  }
+  idToken, err := verifier.Verify(ctx, raw)
+  if err != nil {
+    return "", Access{}, "", fmt.Errorf("oidc: %w", err)
+  }
+  if idToken.Nonce != pend.Nonce {
+    return "", Access{}, "", errors.New("oidc: ID token nonce mismatch")
+  }
+
+  var claims map[string]any
+  if err := idToken.Claims(&claims); err != nil {
+    return "", Access{}, "", fmt.Errorf("oidc: %w", err)
+  }
+  user := claimString(claims, o.cfg.UsernameClaim)
+  if user == "" {
+    user = claimString(claims, "email")
+  }
+  if user == "" {
+    user = idToken.Subject
+  }
+  access, ok := o.cfg.Roles.Map(claimValues(claims, o.cfg.RoleClaim))
+  if !ok {
+    return user, Access{}, "", fmt.Errorf("%s has no kea-web role", user)
+  }
+  return user, access, pend.Next, nil
+}
+
+// claimValue follows a dotted path through the claims.
+func claimValue(claims map[string]any, path string) any {
+  var v any = claims
+  for _, part := range strings.Split(path, ".") {
+    m, ok := v.(map[string]any)
+    if !ok {
+      return nil
+    }
+    v = m[part]
+  }
+  return v
+}
+
+func claimString(claims map[string]any, path string) string {
+  s, _ := claimValue(claims, path).(string)
+  return s
+}
+
+// claimValues returns a claim holding a string or a list of strings.
+func claimValues(claims map[string]any, path string) []string {
+  switch v := claimValue(claims, path).(type) {
+  case string:
+    return strings.Fields(v)
+  case []any:
+    var out []string
+    for _, x := range v {
+      if s, ok := x.(string); ok {
+        out = append(out, s)
+      }
+    }
+    return out
+  }
+  return nil
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "kea-web"
+
+// fakeIssuer is an OpenID provider on an httptest server. Its token
+// endpoint takes the code of the last sign-in once, checks the PKCE
+// verifier against its challenge and answers with an ID token of
+// claims, signed with key.
+type fakeIssuer struct {
+  srv *httptest.Server
+  key *rsa.PrivateKey
+  // forged signs instead, with a key the JWKS doesn't publish.
+  forged *rsa.PrivateKey
+
+  mu        sync.Mutex
+  code      string
+  challenge string
+  nonce     string
+  claims    map[string]any
+  forge     bool
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+  t.Helper()
+  f := &fakeIssuer{}
+  var err error
+  if f.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+    t.Fatal(err)
+  }
+  if f.forged, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+    t.Fatal(err)
+  }
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+    writeTestJSON(w, map[string]any{
+      "issuer":                                f.srv.URL,
+      "authorization_endpoint":                f.srv.URL + "/authorize",
+      "token_endpoint":                        f.srv.URL + "/token",
+      "jwks_uri":                              f.srv.URL + "/jwks",
+      "id_token_signing_alg_values_supported": []string{"RS256"},
+    })
+  })
+  mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+    writeTestJSON(w, map[string]any{"keys": []map[string]string{{
+      "kty": "RSA",
+      "kid": "k1",
+      "alg": "RS256",
+      "use": "sig",
+      "n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+      "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+    }}})
+  })
+  mux.HandleFunc("POST /token", f.token)
+  f.srv = httptest.NewServer(mux)
+  t.Cleanup(f.srv.Close)
+  return f
+}
+
+func writeTestJSON(w http.ResponseWriter, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+  code := r.PostFormValue("code")
+  if r.PostFormValue("grant_type") != "authorization_code" || code == "" || code != f.code ||
+    base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusBadRequest)
+    w.Write([]byte(`{"error":"invalid_grant"}`))
+    return
+  }
+  f.code = ""
+  now := time.Now()
+  claims := map[string]any{
+    "iss":   f.srv.URL,
+    "aud":   testClientID,
+    "iat":   now.Unix(),
+    "exp":   now.Add(5 * time.Minute).Unix(),
+    "nonce": f.nonce,
+  }
+  for k, v := range f.claims {
+    claims[k] = v
+  }
+  key := f.key
+  if f.forge {
+    key = f.forged
+  }
+  writeTestJSON(w, map[string]any{
+    "access_token": "access-1",
+    "token_type":   "Bearer",
+    "expires_in":   300,
+    "id_token":     signJWT(key, claims),
+  })
+}
+
+// signJWT returns claims as an RS256 JWT of key k1.
+func signJWT(key *rsa.PrivateKey, claims map[string]any) string {
+  header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+  payload, _ := json.Marshal(claims)
+  signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+  sum := sha256.Sum256([]byte(signed))
+  sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+  return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// begin starts a sign-in going to next and returns the callback the
+// provider would send the browser to, with the state cookie set.
+func (f *fakeIssuer) begin(t *testing.T, o *OIDC, next string) *http.Request {
+  t.Helper()
+  w := httptest.NewRecorder()
+  if err := o.Begin(w, httptest.NewRequest(http.MethodGet, "https://kea.example/login/oidc", nil), next); err != nil {
+    t.Fatal(err)
+  }
+  loc, err := url.Parse(w.Header().Get("Location"))
+  if err != nil || !strings.HasPrefix(loc.String(), f.srv.URL+"/authorize?") {
+    t.Fatalf("Begin redirected to %q", w.Header().Get("Location"))
+  }
+  q := loc.Query()
+  if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != "https://kea.example/login/oidc/callback" {
+    t.Errorf("authorization request %v", q)
+  }
+  if !strings.Contains(q.Get("scope"), "openid") {
+    t.Errorf("scope %q lacks openid", q.Get("scope"))
+  }
+  f.mu.Lock()
+  f.code = randomString()
+  f.challenge, f.nonce = q.Get("code_challenge"), q.Get("nonce")
+  code := f.code
+  f.mu.Unlock()
+
+  r := httptest.NewRequest(http.MethodGet, "https://kea.example/login/oidc/callback?code="+code+"&state="+url.QueryEscape(q.Get("state")), nil)
+  for _, c := range w.Result().Cookies() {
+    r.AddCookie(c)
+  }
+  return r
+}
+
+func testOIDC(f *fakeIssuer) *OIDC {
+  return NewOIDC(OIDCConfig{
+    Issuer:       f.srv.URL,
+    ClientID:     testClientID,
+    ClientSecret: "secret",
+    Roles: RoleMap{Rules: []RoleRule{
+      {Value: "kea-admins", Access: Access{Role: RoleAdmin}},
+      {Value: "kea-ops", Access: Access{Role: RoleOperator, Subnets: []int64{3}}},
+    }},
+  })
+}
+
+func TestOIDCFinish(t *testing.T) {
+  f := newFakeIssuer(t)
+  o := testOIDC(f)
+
+  tests := []struct {
+    name   string
+    claims map[string]any
+    forge  bool
+    user   string
+    role   Role
+    err    string
+  }{
+    {"username claim", map[string]any{"sub": "s1", "preferred_username": "alice", "email": "alice@example.com", "groups": []string{"staff", "kea-admins"}}, false, "alice", RoleAdmin, ""},
+    {"email", map[string]any{"sub": "s2", "email": "bob@example.com", "groups": "staff kea-ops"}, false, "bob@example.com", RoleOperator, ""},
+    {"subject", map[string]any{"sub": "s3", "groups": []string{"kea-ops", "kea-admins"}}, false, "s3", RoleAdmin, ""},
+    {"no role", map[string]any{"sub": "s4", "preferred_username": "carol", "groups": []string{"staff"}}, false, "", "", "carol has no kea-web role"},
+    {"nonce", map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins", "nonce": "replayed"}, false, "", "", "nonce mismatch"},
+    {"audience", map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins", "aud": "other-app"}, false, "", "", "audience"},
+    {"expired", map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins", "exp": time.Now().Add(-time.Hour).Unix()}, false, "", "", "expired"},
+    {"issuer", map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins", "iss": "https://idp.example"}, false, "", "", "different provider"},
+    {"signature", map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins"}, true, "", "", "signature"},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      f.mu.Lock()
+      f.claims, f.forge = tt.claims, tt.forge
+      f.mu.Unlock()
+
+      r := f.begin(t, o, "/leases")
+      user, access, next, err := o.Finish(httptest.NewRecorder(), r)
+      if tt.err != "" {
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+          t.Fatalf("err = %v, want %q", err, tt.err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatal(err)
+      }
+      if user != tt.user || access.Role != tt.role || next != "/leases" {
+        t.Errorf("Finish = %q, %+v, %q", user, access, next)
+      }
+    })
+  }
+}
+
+func TestOIDCFinishRefused(t *testing.T) {
+  f := newFakeIssuer(t)
+  o := testOIDC(f)
+  f.claims = map[string]any{"sub": "s1", "preferred_username": "alice", "groups": "kea-admins"}
+
+  // A callback without the state cookie didn't start in this browser.
+  r := f.begin(t, o, "/")
+  stray := httptest.NewRequest(http.MethodGet, r.URL.String(), nil)
+  if _, _, _, err := o.Finish(httptest.NewRecorder(), stray); err == nil || !strings.Contains(err.Error(), "didn't start in this browser") {
+    t.Errorf("no cookie: err = %v", err)
+  }
+
+  // A replayed callback gets nowhere with the used code.
+  if _, _, _, err := o.Finish(httptest.NewRecorder(), r); err != nil {
+    t.Fatal(err)
+  }
+  if _, _, _, err := o.Finish(httptest.NewRecorder(), r); err == nil || !strings.Contains(err.Error(), "code exchange") {
+    t.Errorf("replayed callback: err = %v", err)
+  }
+
+  // The cookie can't be altered, nor outlive the sign-in.
+  r = f.begin(t, o, "/")
+  c, _ := r.Cookie(oidcCookie)
+  pend, ok := o.open(c.Value)
+  if !ok || pend.Next != "/" {
+    t.Fatalf("cookie holds %+v, %v", pend, ok)
+  }
+  payload, sig, _ := strings.Cut(o.seal(oidcPending{State: pend.State, Next: "/users"}), ".")
+  _, orig, _ := strings.Cut(c.Value, ".")
+  pend.Created = time.Now().Add(-oidcPendingTTL - time.Minute).Unix()
+  for value, want := range map[string]string{
+    payload + "." + orig:      "didn't start in this browser",
+    payload + "." + sig + "x": "didn't start in this browser",
+    o.seal(pend):              "expired",
+  } {
+    forged := httptest.NewRequest(http.MethodGet, r.URL.String(), nil)
+    forged.AddCookie(&http.Cookie{Name: oidcCookie, Value: value})
+    if _, _, _, err := o.Finish(httptest.NewRecorder(), forged); err == nil || !strings.Contains(err.Error(), want) {
+      t.Errorf("cookie %q: err = %v, want %q", value, err, want)
+    }
+  }
+
+  r = f.begin(t, o, "/")
+  q := r.URL.Query()
+  q.Set("code", "code-2")
+  r.URL.RawQuery = q.Encode()
+  if _, _, _, err := o.Finish(httptest.NewRecorder(), r); err == nil || !strings.Contains(err.Error(), "code exchange") {
+    t.Errorf("bad code: err = %v", err)
+  }
+
+  r = httptest.NewRequest(http.MethodGet, "/login/oidc/callback?error=access_denied&error_description=no", nil)
+  if _, _, _, err := o.Finish(httptest.NewRecorder(), r); err == nil || !strings.Contains(err.Error(), "access_denied") {
+    t.Errorf("provider error: err = %v", err)
+  }
+}
+
+func TestOIDCDiscoverUnlocked(t *testing.T) {
+  entered, release := make(chan struct{}), make(chan struct{})
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    close(entered)
+    <-release
+    http.NotFound(w, r)
+  }))
+  defer srv.Close()
+  defer close(release)
+
+  o := NewOIDC(OIDCConfig{Issuer: srv.URL, ClientID: testClientID})
+  done := make(chan error)
+  go func() {
+    _, _, err := o.discover(t.Context())
+    done <- err
+  }()
+  <-entered
+  if !o.mu.TryLock() {
+    t.Fatal("discovery holds the lock while the provider answers")
+  }
+  o.mu.Unlock()
+  release <- struct{}{}
+  if err := <-done; err == nil {
+    t.Error("discovery of a missing provider succeeded")
+  }
+}
+
+func TestClaimValues(t *testing.T) {
+  claims := map[string]any{
+    "groups":       []any{"a", 1, "b"},
+    "scope":        "x y",
+    "realm_access": map[string]any{"roles": []any{"kea-admins"}},
+  }
+  tests := map[string]string{
+    "groups":             "a b",
+    "scope":              "x y",
+    "realm_access.roles": "kea-admins",
+    "realm_access.none":  "",
+    "scope.deeper":       "",
+  }
+  for path, want := range tests {
+    if got := strings.Join(claimValues(claims, path), " "); got != want {
+      t.Errorf("claimValues(%q) = %q, want %q", path, got, want)
+    }
+  }
+}
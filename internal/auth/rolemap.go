@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RoleRule grants an access to users of an identity provider whose
+// groups or claim values include Value.
+type RoleRule struct {
+  Value string `json:"value"`
+  Access
+}
+
+// RoleMap turns the groups of an externally authenticated user into an
+// access. Default applies when no rule matches; empty refuses sign-in.
+type RoleMap struct {
+  Rules   []RoleRule `json:"rules"`
+  Default Role       `json:"default,omitempty"`
+}
+
+// ParseRoleRules reads rules written as "value=role" pairs separated by
+// semicolons, e.g. "kea-admins=admin;kea-ops=operator". The value ends
+// at the last "=", so LDAP group DNs can be used as they are.
+func ParseRoleRules(s string) ([]RoleRule, error) {
+  var rules []RoleRule
+  for _, item := range strings.Split(s, ";") {
+    if item = strings.TrimSpace(item); item == "" {
+      continue
+    }
+    i := strings.LastIndex(item, "=")
+    if i <= 0 {
+      return nil, fmt.Errorf("role rule %q: want value=role", item)
+    }
+    role, err := ParseRole(item[i+1:])
+    if err != nil {
+      return nil, fmt.Errorf("role rule %q: %w", item, err)
+    }
+    rules = append(rules, RoleRule{Value: strings.TrimSpace(item[:i]), Access: Access{Role: role}})
+  }
+  return rules, nil
+}
+
+// Check validates the rules and the default role.
+func (m RoleMap) Check() error {
+  if m.Default != "" {
+    if _, err := ParseRole(string(m.Default)); err != nil {
+      return err
+    }
+  }
+  for _, r := range m.Rules {
+    if r.Value == "" {
+      return fmt.Errorf("role rule without a value")
+    }
+    if err := CheckAccess(r.Access); err != nil {
+      return fmt.Errorf("role rule %q: %w", r.Value, err)
+    }
+  }
+  return nil
+}
+
+// Map returns the access of a user in groups: the most privileged
+// matching rule, the first one of equal privilege. Values compare
+// case-insensitively.
+func (m RoleMap) Map(groups []string) (Access, bool) {
+  var best *RoleRule
+  for i, r := range m.Rules {
+    if !slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, r.Value) }) {
+      continue
+    }
+    if best == nil || slices.Index(Roles, r.role()) > slices.Index(Roles, best.role()) {
+      best = &m.Rules[i]
+    }
+  }
+  if best != nil {
+    return best.Access, true
+  }
+  if m.Default != "" {
+    return Access{Role: m.Default}, true
+  }
+  return Access{}, false
+}
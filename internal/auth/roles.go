@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Role is what an account may do.
+type Role string
+
+// Roles, from least to most privileged. Accounts created before roles
+// existed have none and are admins.
+const (
+  RoleViewer   Role = "viewer"
+  RoleOperator Role = "operator"
+  RoleAdmin    Role = "admin"
+)
+
+// Roles lists the roles in order of privilege.
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+// ParseRole checks a role name.
+func ParseRole(s string) (Role, error) {
+  r := Role(strings.ToLower(strings.TrimSpace(s)))
+  if !slices.Contains(Roles, r) {
+    return "", fmt.Errorf("unknown role %q (viewer, operator or admin)", s)
+  }
+  return r, nil
+}
+
+// ParseAccess builds an access from a role name and comma or space
+// separated lists of servers and subnet IDs, as typed in a form or on
+// the command line.
+func ParseAccess(role, servers, subnets string) (Access, error) {
+  r, err := ParseRole(role)
+  if err != nil {
+    return Access{}, err
+  }
+  split := func(s string) []string {
+    return strings.FieldsFunc(s, func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
+  }
+  access := Access{Role: r, Servers: split(strings.ToLower(servers))}
+  for _, s := range split(subnets) {
+    id, err := strconv.ParseInt(s, 10, 64)
+    if err != nil {
+      return Access{}, fmt.Errorf("invalid subnet ID %q", s)
+    }
+    access.Subnets = append(access.Subnets, id)
+  }
+  return access, CheckAccess(access)
+}
+
+// Permission is a class of actions.
+type Permission string
+
+const (
+  // PermRead views pages, statistics and configuration.
+  PermRead Permission = "read"
+  // PermLeases adds, changes and deletes leases and reservations.
+  PermLeases Permission = "leases"
+  // PermConfig changes the Kea configuration and controls the services.
+  PermConfig Permission = "config"
+  // PermUsers manages the kea-web accounts.
+  PermUsers Permission = "users"
+)
+
+// ErrForbidden is returned for actions outside the caller's role or
+// scope.
+var ErrForbidden = errors.New("permission denied")
+
+// Access is an account's role and the Kea servers and subnets it is
+// limited to. Empty lists mean every server or subnet.
+type Access struct {
+  Role    Role     `json:"role,omitempty"`
+  Servers []string `json:"servers,omitempty"`
+  Subnets []int64  `json:"subnets,omitempty"`
+}
+
+func (a Access) role() Role {
+  if a.Role == "" {
+    return RoleAdmin
+  }
+  return a.Role
+}
+
+// Scoped reports whether the access is limited to some servers or
+// subnets.
+func (a Access) Scoped() bool {
+  return len(a.Servers) > 0 || len(a.Subnets) > 0
+}
+
+// Can reports whether the role grants perm. Managing users also needs
+// an unscoped admin, or a scoped one could widen its own scope.
+func (a Access) Can(perm Permission) bool {
+  switch a.role() {
+  case RoleViewer:
+    return perm == PermRead
+  case RoleOperator:
+    return perm == PermRead || perm == PermLeases
+  case RoleAdmin:
+    return perm != PermUsers || !a.Scoped()
+  }
+  return false
+}
+
+// Server reports whether the Kea service (dhcp4, dhcp6 or d2) is in
+// scope.
+func (a Access) Server(service string) bool {
+  return len(a.Servers) == 0 || slices.Contains(a.Servers, service)
+}
+
+// Subnet reports whether the subnet ID is in scope.
+func (a Access) Subnet(id int64) bool {
+  return len(a.Subnets) == 0 || slices.Contains(a.Subnets, id)
+}
+
+// Whole reports whether a Kea service is in scope as a whole, as its
+// configuration files and unit are. "" stands for the control agent and
+// host-wide files, which only unscoped accounts may change.
+func (a Access) Whole(service string) bool {
+  if service == "" {
+    return !a.Scoped()
+  }
+  return len(a.Subnets) == 0 && a.Server(service)
+}
+
+// Allows reports whether perm may be used on a subnet of a service.
+func (a Access) Allows(perm Permission, service string, subnetID int64) bool {
+  return a.Can(perm) && a.Server(service) && a.Subnet(subnetID)
+}
+
+// String describes the access, e.g. "operator (dhcp4; subnets 1, 2)".
+func (a Access) String() string {
+  var scope []string
+  if len(a.Servers) > 0 {
+    scope = append(scope, strings.Join(a.Servers, ", "))
+  }
+  if len(a.Subnets) > 0 {
+    ids := make([]string, len(a.Subnets))
+    for i, id := range a.Subnets {
+      ids[i] = fmt.Sprint(id)
+    }
+    scope = append(scope, "subnets "+strings.Join(ids, ", "))
+  }
+  if len(scope) == 0 {
+    return string(a.role())
+  }
+  return string(a.role()) + " (" + strings.Join(scope, "; ") + ")"
+}
+
+// Allowed reports whether the caller of ctx has perm. Contexts without
+// an identity are kea-web's own background work and are allowed.
+func Allowed(ctx context.Context, perm Permission) bool {
+  id, ok := FromContext(ctx)
+  return !ok || id.Can(perm)
+}
+
+// Require wraps a handler that needs perm, refusing others with 403.
+func Require(perm Permission, h http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if !Allowed(r.Context(), perm) {
+      http.Error(w, "Permission denied", http.StatusForbidden)
+      return
+    }
+    h(w, r)
+  }
+}
+
+// CommandPermission classifies a Kea command: reads, lease and
+// reservation changes, and everything else as configuration.
+func CommandPermission(command string) Permission {
+  switch command {
+  case "build-report", "config-get", "config-hash-get", "config-test", "list-commands",
+    "status-get", "version-get":
+    return PermRead
+  }
+  if strings.Contains(command, "-get-by-") {
+    return PermRead
+  }
+  for _, suffix := range []string{"-get", "-get-all", "-get-page", "-list"} {
+    if strings.HasSuffix(command, suffix) {
+      return PermRead
+    }
+  }
+  if strings.HasPrefix(command, "lease4-") || strings.HasPrefix(command, "lease6-") ||
+    strings.HasPrefix(command, "reservation-") {
+    return PermLeases
+  }
+  return PermConfig
+}
+
+// CheckCommand is the kea client's command check: the caller of ctx
+// needs the command's permission, every target service in scope and,
+// when limited to subnets, every subnet the arguments name in scope.
+// Subnet-limited callers can't make changes that name no subnet, nor
+// configuration changes beyond the remote-subnet commands. Reads that
+// name no subnet, such as config-get, pass: kea-web needs them to find
+// the caller's subnets, so whatever shows their answer must cut it down
+// to the caller's scope.
+func CheckCommand(ctx context.Context, command string, services []string, args any) error {
+  id, ok := FromContext(ctx)
+  if !ok {
+    return nil
+  }
+  perm := CommandPermission(command)
+  if !id.Can(perm) {
+    return fmt.Errorf("%w: %s needs the %s permission", ErrForbidden, command, perm)
+  }
+  for _, service := range services {
+    if !id.Server(service) {
+      return fmt.Errorf("%w: %s is outside %s's servers", ErrForbidden, service, id.User)
+    }
+  }
+  if len(services) == 0 && len(id.Servers) > 0 && perm != PermRead {
+    return fmt.Errorf("%w: %s targets the control agent and %s is limited to servers", ErrForbidden, command, id.User)
+  }
+  if len(id.Subnets) == 0 {
+    return nil
+  }
+  if perm == PermConfig && !strings.HasPrefix(command, "remote-subnet") {
+    return fmt.Errorf("%w: %s changes more than subnets and %s is limited to subnets", ErrForbidden, command, id.User)
+  }
+  ids, err := subnetIDs(args)
+  if err != nil {
+    return err
+  }
+  if len(ids) == 0 && perm != PermRead {
+    return fmt.Errorf("%w: %s names no subnet and %s is limited to subnets", ErrForbidden, command, id.User)
+  }
+  for _, sid := range ids {
+    if !id.Subnet(sid) {
+      return fmt.Errorf("%w: subnet %d is outside %s's subnets", ErrForbidden, sid, id.User)
+    }
+  }
+  return nil
+}
+
+// subnetIDs collects the "subnet-id" values of command arguments, and
+// the "id" of the entries of a "subnets" list.
+func subnetIDs(args any) ([]int64, error) {
+  if args == nil {
+    return nil, nil
+  }
+  b, err := json.Marshal(args)
+  if err != nil {
+    return nil, err
+  }
+  var v any
+  if err := json.Unmarshal(b, &v); err != nil {
+    return nil, err
+  }
+  var ids []int64
+  var walk func(v any, inSubnets bool)
+  walk = func(v any, inSubnets bool) {
+    switch v := v.(type) {
+    case map[string]any:
+      for k, x := range v {
+        if n, ok := x.(float64); ok && (k == "subnet-id" || (inSubnets && k == "id")) {
+          ids = append(ids, int64(n))
+          continue
+        }
+        walk(x, k == "subnets")
+      }
+    case []any:
+      for _, x := range v {
+        walk(x, inSubnets)
+      }
+    }
+  }
+  walk(v, false)
+  return ids, nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default session lifetimes.
+const (
+  DefaultIdleTimeout = 30 * time.Minute
+  DefaultMaxAge      = 12 * time.Hour
+)
+
+// Session is a signed in browser.
+type Session struct {
+  User string
+  // External sessions were started by an identity provider and carry
+  // the access it granted; local ones look the account up.
+  External bool
+  Access   Access
+  // CSRF is the token the session's forms and scripts send back with
+  // requests that change something.
+  CSRF     string
+  Created  time.Time
+  LastSeen time.Time
+}
+
+// Sessions are kept in memory, keyed by the hash of their cookie value
+// so the map never holds a usable token. A restart signs everyone out.
+type Sessions struct {
+  // Idle ends a session not used for that long, MaxAge any session
+  // that old.
+  Idle   time.Duration
+  MaxAge time.Duration
+
+  mu       sync.Mutex
+  sessions map[[sha256.Size]byte]*Session
+  swept    time.Time
+}
+
+// NewSessions returns an empty session table.
+func NewSessions(idle, maxAge time.Duration) *Sessions {
+  if idle <= 0 {
+    idle = DefaultIdleTimeout
+  }
+  if maxAge <= 0 {
+    maxAge = DefaultMaxAge
+  }
+  return &Sessions{Idle: idle, MaxAge: maxAge, sessions: map[[sha256.Size]byte]*Session{}}
+}
+
+func (s *Sessions) expired(sess *Session, now time.Time) bool {
+  return now.Sub(sess.LastSeen) > s.Idle || now.Sub(sess.Created) > s.MaxAge
+}
+
+// Create starts a session for a local user and returns its token.
+func (s *Sessions) Create(user string) string {
+  return s.create(Session{User: user})
+}
+
+// CreateExternal starts a session for a user signed in by an identity
+// provider, with the access it mapped to.
+func (s *Sessions) CreateExternal(user string, access Access) string {
+  return s.create(Session{User: user, External: true, Access: access})
+}
+
+func (s *Sessions) create(sess Session) string {
+  token := randomToken()
+  sess.CSRF = randomToken()
+  now := time.Now()
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.sweep(now)
+  sess.Created, sess.LastSeen = now, now
+  s.sessions[sha256.Sum256([]byte(token))] = &sess
+  return token
+}
+
+func randomToken() string {
+  b := make([]byte, 32)
+  rand.Read(b)
+  return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Get returns the session of token and marks it used.
+func (s *Sessions) Get(token string) (Session, bool) {
+  if token == "" {
+    return Session{}, false
+  }
+  key := sha256.Sum256([]byte(token))
+  now := time.Now()
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  sess, ok := s.sessions[key]
+  if !ok {
+    return Session{}, false
+  }
+  if s.expired(sess, now) {
+    delete(s.sessions, key)
+    return Session{}, false
+  }
+  sess.LastSeen = now
+  return *sess, true
+}
+
+// Delete ends the session of token.
+func (s *Sessions) Delete(token string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  delete(s.sessions, sha256.Sum256([]byte(token)))
+}
+
+// DeleteUser ends every session of a local user, after a password
+// change or when the account is removed.
+func (s *Sessions) DeleteUser(user string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for k, sess := range s.sessions {
+    if !sess.External && strings.EqualFold(sess.User, user) {
+      delete(s.sessions, k)
+    }
+  }
+}
+
+// sweep drops expired sessions, at most once a minute. The caller holds
+// mu.
+func (s *Sessions) sweep(now time.Time) {
+  if now.Sub(s.swept) < time.Minute {
+    return
+  }
+  s.swept = now
+  for k, sess := range s.sessions {
+    if s.expired(sess, now) {
+      delete(s.sessions, k)
+    }
+  }
+}
@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Scope is what an API token may do, on top of its owner's role.
+type Scope string
+
+const (
+  // ScopeReadLeases reads leases, reservations, statistics and
+  // configuration.
+  ScopeReadLeases Scope = "read:leases"
+  // ScopeWriteReservations also changes leases and reservations.
+  ScopeWriteReservations Scope = "write:reservations"
+  // ScopeAdminConfig also changes the configuration and controls the
+  // services.
+  ScopeAdminConfig Scope = "admin:config"
+)
+
+// Scopes lists the token scopes.
+var Scopes = []Scope{ScopeReadLeases, ScopeWriteReservations, ScopeAdminConfig}
+
+// perm is the permission a scope is about.
+func (s Scope) perm() Permission {
+  switch s {
+  case ScopeWriteReservations:
+    return PermLeases
+  case ScopeAdminConfig:
+    return PermConfig
+  }
+  return PermRead
+}
+
+// grants reports whether the scope allows perm: reading, and what the
+// scope is about. No scope manages users.
+func (s Scope) grants(perm Permission) bool {
+  return slices.Contains(Scopes, s) && (perm == PermRead || perm == s.perm())
+}
+
+// Covers reports whether the role grants every scope, as it must for
+// its owner to create a token with them.
+func (a Access) Covers(scopes []Scope) bool {
+  for _, s := range scopes {
+    if !a.Can(s.perm()) {
+      return false
+    }
+  }
+  return true
+}
+
+// ParseScopes reads a comma or space separated list of scopes.
+func ParseScopes(s string) ([]Scope, error) {
+  var scopes []Scope
+  for _, f := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' || unicode.IsSpace(c) }) {
+    scope := Scope(strings.ToLower(f))
+    if !slices.Contains(Scopes, scope) {
+      return nil, fmt.Errorf("unknown scope %q (read:leases, write:reservations or admin:config)", f)
+    }
+    if !slices.Contains(scopes, scope) {
+      scopes = append(scopes, scope)
+    }
+  }
+  if len(scopes) == 0 {
+    return nil, errors.New("a token needs at least one scope")
+  }
+  return scopes, nil
+}
+
+// ScopeRole is the least role that covers scopes, the role of service
+// tokens.
+func ScopeRole(scopes []Scope) Role {
+  switch {
+  case slices.Contains(scopes, ScopeAdminConfig):
+    return RoleAdmin
+  case slices.Contains(scopes, ScopeWriteReservations):
+    return RoleOperator
+  }
+  return RoleViewer
+}
+
+// ParseExpiry reads when a token expires: "" or "never", a number of
+// days such as "90d", a duration such as "12h" or a date.
+func ParseExpiry(s string, now time.Time) (time.Time, error) {
+  s = strings.TrimSpace(s)
+  switch {
+  case s == "" || s == "never":
+    return time.Time{}, nil
+  case strings.HasSuffix(s, "d"):
+    if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days > 0 {
+      return now.AddDate(0, 0, days).UTC().Truncate(time.Second), nil
+    }
+  default:
+    if d, err := time.ParseDuration(s); err == nil && d > 0 {
+      return now.Add(d).UTC().Truncate(time.Second), nil
+    }
+    if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil && t.After(now) {
+      return t.UTC(), nil
+    }
+  }
+  return time.Time{}, fmt.Errorf("invalid expiry %q: want never, 90d, 12h or a future date", s)
+}
+
+// tokenPrefix starts every token, so leaked ones are easy to find.
+const tokenPrefix = "kwt_"
+
+// lastUsedInterval is how stale a token's last use may be on disk, so
+// busy scripts don't rewrite the file on every request.
+const lastUsedInterval = time.Minute
+
+var (
+  ErrBadToken  = errors.New("invalid API token")
+  ErrNoToken   = errors.New("no such token")
+  ErrTokenName = errors.New("a service token of that name exists")
+)
+
+// Token is an API token. Personal tokens act for their owner, limited
+// to their scopes; service tokens act on their own with the access
+// given when they were created. Only the token's hash is kept.
+type Token struct {
+  ID   string `json:"id"`
+  Name string `json:"name"`
+  // Owner is the user of a personal token, "" for service tokens.
+  Owner string `json:"owner,omitempty"`
+  // External personal tokens belong to a user signed in through an
+  // identity provider and keep the access the user had then; local
+  // owners' tokens follow their account.
+  External     bool      `json:"external,omitempty"`
+  Access       Access    `json:"access"`
+  Scopes       []Scope   `json:"scopes"`
+  Hash         string    `json:"hash"`
+  Created      time.Time `json:"created"`
+  Expires      time.Time `json:"expires,omitzero"`
+  LastUsed     time.Time `json:"last_used,omitzero"`
+  LastUsedFrom string    `json:"last_used_from,omitempty"`
+  Revoked      time.Time `json:"revoked,omitzero"`
+}
+
+// Service reports whether the token acts on its own.
+func (t Token) Service() bool {
+  return t.Owner == ""
+}
+
+// Active reports whether the token can still be used.
+func (t Token) Active(now time.Time) bool {
+  return t.Revoked.IsZero() && (t.Expires.IsZero() || now.Before(t.Expires))
+}
+
+// User is the name the token's requests act as.
+func (t Token) User() string {
+  if t.Service() {
+    return t.Name
+  }
+  return t.Owner
+}
+
+// OwnedBy reports whether t is a personal token of id. Local and
+// external users of the same name own different tokens.
+func (t Token) OwnedBy(id Identity) bool {
+  return !t.Service() && t.External == id.External && strings.EqualFold(t.Owner, id.User)
+}
+
+func hashToken(secret string) string {
+  sum := sha256.Sum256([]byte(secret))
+  return hex.EncodeToString(sum[:])
+}
+
+type tokensFile struct {
+  Tokens []Token `json:"tokens"`
+}
+
+// Tokens is the API token file. Like the account file it is re-read
+// when it changes on disk.
+type Tokens struct {
+  path string
+
+  mu      sync.Mutex
+  tokens  []Token
+  modTime time.Time
+}
+
+// NewTokens opens the token file at path; a missing file has no tokens.
+func NewTokens(path string) (*Tokens, error) {
+  t := &Tokens{path: path}
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  return t, t.load()
+}
+
+// load re-reads the file if it changed. The caller holds mu.
+func (t *Tokens) load() error {
+  info, err := os.Stat(t.path)
+  if errors.Is(err, os.ErrNotExist) {
+    t.tokens, t.modTime = nil, time.Time{}
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  if info.ModTime().Equal(t.modTime) && t.tokens != nil {
+    return nil
+  }
+  b, err := os.ReadFile(t.path)
+  if err != nil {
+    return err
+  }
+  var f tokensFile
+  if err := json.Unmarshal(b, &f); err != nil {
+    return fmt.Errorf("%s: %w", t.path, err)
+  }
+  t.tokens, t.modTime = f.Tokens, info.ModTime()
+  if t.tokens == nil {
+    t.tokens = []Token{}
+  }
+  return nil
+}
+
+// save writes the file. The caller holds mu.
+func (t *Tokens) save() error {
+  if err := writeJSON(t.path, tokensFile{Tokens: t.tokens}); err != nil {
+    return err
+  }
+  if info, err := os.Stat(t.path); err == nil {
+    t.modTime = info.ModTime()
+  }
+  return nil
+}
+
+// List returns the tokens, revoked ones included.
+func (t *Tokens) List() ([]Token, error) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if err := t.load(); err != nil {
+    return nil, err
+  }
+  return slices.Clone(t.tokens), nil
+}
+
+// Create adds a token described by tok, of which Name, Owner,
+// External, Access, Scopes and Expires are used, and returns the
+// secret. The secret is shown this once; only its hash is kept.
+func (t *Tokens) Create(tok Token) (string, Token, error) {
+  if tok.Name = strings.TrimSpace(tok.Name); tok.Name == "" {
+    return "", Token{}, errors.New("a token needs a name")
+  }
+  if tok.Service() && !validName.MatchString(tok.Name) {
+    return "", Token{}, fmt.Errorf("invalid service token name %q", tok.Name)
+  }
+  if len(tok.Scopes) == 0 {
+    return "", Token{}, errors.New("a token needs at least one scope")
+  }
+  if tok.Service() || tok.External {
+    if err := CheckAccess(tok.Access); err != nil {
+      return "", Token{}, err
+    }
+  }
+  now := time.Now()
+  if !tok.Expires.IsZero() && !tok.Expires.After(now) {
+    return "", Token{}, errors.New("the expiry date has passed")
+  }
+
+  id := make([]byte, 6)
+  rand.Read(id)
+  b := make([]byte, 32)
+  rand.Read(b)
+  secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+  tok.ID = hex.EncodeToString(id)
+  tok.Hash = hashToken(secret)
+  tok.Created = now.UTC().Truncate(time.Second)
+  tok.LastUsed, tok.LastUsedFrom, tok.Revoked = time.Time{}, "", time.Time{}
+
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if err := t.load(); err != nil {
+    return "", Token{}, err
+  }
+  if tok.Service() && slices.ContainsFunc(t.tokens, func(x Token) bool {
+    return x.Service() && x.Active(now) && strings.EqualFold(x.Name, tok.Name)
+  }) {
+    return "", Token{}, ErrTokenName
+  }
+  t.tokens = append(t.tokens, tok)
+  return secret, tok, t.save()
+}
+
+// Get returns the token with an ID.
+func (t *Tokens) Get(id string) (Token, error) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if err := t.load(); err != nil {
+    return Token{}, err
+  }
+  i := slices.IndexFunc(t.tokens, func(x Token) bool { return x.ID == id })
+  if i < 0 {
+    return Token{}, ErrNoToken
+  }
+  return t.tokens[i], nil
+}
+
+// Revoke ends a token at once. It stays listed with its history.
+func (t *Tokens) Revoke(id string) error {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if err := t.load(); err != nil {
+    return err
+  }
+  i := slices.IndexFunc(t.tokens, func(x Token) bool { return x.ID == id })
+  if i < 0 {
+    return ErrNoToken
+  }
+  if !t.tokens[i].Revoked.IsZero() {
+    return nil
+  }
+  t.tokens[i].Revoked = time.Now().UTC().Truncate(time.Second)
+  return t.save()
+}
+
+// Use returns the active token of secret and records its use from
+// addr.
+func (t *Tokens) Use(secret, addr string) (Token, error) {
+  if !strings.HasPrefix(secret, tokenPrefix) {
+    return Token{}, ErrBadToken
+  }
+  hash := hashToken(secret)
+  now := time.Now()
+
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if err := t.load(); err != nil {
+    return Token{}, err
+  }
+  i := slices.IndexFunc(t.tokens, func(x Token) bool { return x.Hash == hash })
+  if i < 0 || !t.tokens[i].Active(now) {
+    return Token{}, ErrBadToken
+  }
+  tok := &t.tokens[i]
+  stale := now.Sub(tok.LastUsed) >= lastUsedInterval || tok.LastUsedFrom != addr
+  tok.LastUsed, tok.LastUsedFrom = now.UTC().Truncate(time.Second), addr
+  if stale {
+    if err := t.save(); err != nil {
+      return Token{}, err
+    }
+  }
+  return *tok, nil
+}
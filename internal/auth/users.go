@@ -0,0 +1,313 @@
+// Package auth holds kea-web's user accounts and sign-in sessions.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptCost is the work factor of new password hashes.
+const bcryptCost = 12
+
+// minPasswordLength is the shortest password accepted.
+const minPasswordLength = 10
+
+var (
+  ErrBadCredentials = errors.New("wrong user name or password")
+  ErrUserExists     = errors.New("user already exists")
+  ErrNoUser         = errors.New("no such user")
+)
+
+// validName limits user names to what is safe in logs and URLs.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// User is a local account.
+type User struct {
+  Name         string    `json:"name"`
+  PasswordHash string    `json:"password_hash"`
+  Created      time.Time `json:"created"`
+  LastLogin    time.Time `json:"last_login,omitzero"`
+  Access
+}
+
+type usersFile struct {
+  Users []User `json:"users"`
+}
+
+// Users is the account file. It is re-read when it changes on disk, so
+// accounts added with "kea-web user" apply to a running server.
+type Users struct {
+  path string
+
+  mu      sync.Mutex
+  users   []User
+  modTime time.Time
+  // dummy is hashed against when the user doesn't exist, so unknown
+  // names take as long to reject as wrong passwords.
+  dummy []byte
+}
+
+// NewUsers opens the account file at path; a missing file has no users.
+func NewUsers(path string) (*Users, error) {
+  dummy, err := bcrypt.GenerateFromPassword([]byte("kea-web"), bcryptCost)
+  if err != nil {
+    return nil, err
+  }
+  u := &Users{path: path, dummy: dummy}
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  return u, u.load()
+}
+
+// load re-reads the file if it changed. The caller holds mu.
+func (u *Users) load() error {
+  info, err := os.Stat(u.path)
+  if errors.Is(err, os.ErrNotExist) {
+    u.users, u.modTime = nil, time.Time{}
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  if info.ModTime().Equal(u.modTime) && u.users != nil {
+    return nil
+  }
+  b, err := os.ReadFile(u.path)
+  if err != nil {
+    return err
+  }
+  var f usersFile
+  if err := json.Unmarshal(b, &f); err != nil {
+    return fmt.Errorf("%s: %w", u.path, err)
+  }
+  u.users, u.modTime = f.Users, info.ModTime()
+  if u.users == nil {
+    u.users = []User{}
+  }
+  return nil
+}
+
+// save writes the file. The caller holds mu.
+func (u *Users) save() error {
+  if err := writeJSON(u.path, usersFile{Users: u.users}); err != nil {
+    return err
+  }
+  if info, err := os.Stat(u.path); err == nil {
+    u.modTime = info.ModTime()
+  }
+  return nil
+}
+
+// writeJSON writes v to path atomically, readable by its owner only.
+func writeJSON(path string, v any) error {
+  b, err := json.MarshalIndent(v, "", "  ")
+  if err != nil {
+    return err
+  }
+  dir := filepath.Dir(path)
+  if err := os.MkdirAll(dir, 0o700); err != nil {
+    return err
+  }
+  tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  if _, err := tmp.Write(append(b, '\n')); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Chmod(0o600); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp.Name(), path)
+}
+
+func (u *Users) find(name string) int {
+  return slices.IndexFunc(u.users, func(x User) bool { return strings.EqualFold(x.Name, name) })
+}
+
+// List returns the accounts.
+func (u *Users) List() ([]User, error) {
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return nil, err
+  }
+  return slices.Clone(u.users), nil
+}
+
+// Empty reports whether no account exists yet.
+func (u *Users) Empty() bool {
+  users, err := u.List()
+  return err == nil && len(users) == 0
+}
+
+// Get returns the account called name.
+func (u *Users) Get(name string) (User, error) {
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return User{}, err
+  }
+  i := u.find(name)
+  if i < 0 {
+    return User{}, ErrNoUser
+  }
+  return u.users[i], nil
+}
+
+// Authenticate checks a password and records the login.
+func (u *Users) Authenticate(name, password string) (User, error) {
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return User{}, err
+  }
+  i := u.find(name)
+  if i < 0 {
+    bcrypt.CompareHashAndPassword(u.dummy, []byte(password))
+    return User{}, ErrBadCredentials
+  }
+  if bcrypt.CompareHashAndPassword([]byte(u.users[i].PasswordHash), []byte(password)) != nil {
+    return User{}, ErrBadCredentials
+  }
+  u.users[i].LastLogin = time.Now().UTC().Truncate(time.Second)
+  if err := u.save(); err != nil {
+    return User{}, err
+  }
+  return u.users[i], nil
+}
+
+// CheckPassword enforces the password policy.
+func CheckPassword(password string) error {
+  if len(password) < minPasswordLength {
+    return fmt.Errorf("passwords need at least %d characters", minPasswordLength)
+  }
+  if len(password) > 72 {
+    return errors.New("passwords can't be longer than 72 bytes")
+  }
+  return nil
+}
+
+// servers are the Kea services an account can be limited to.
+var servers = []string{"dhcp4", "dhcp6", "d2"}
+
+// CheckAccess validates a role and its scope.
+func CheckAccess(access Access) error {
+  if _, err := ParseRole(string(access.Role)); err != nil {
+    return err
+  }
+  for _, s := range access.Servers {
+    if !slices.Contains(servers, s) {
+      return fmt.Errorf("unknown server %q (dhcp4, dhcp6 or d2)", s)
+    }
+  }
+  for _, id := range access.Subnets {
+    if id <= 0 {
+      return fmt.Errorf("invalid subnet ID %d", id)
+    }
+  }
+  return nil
+}
+
+// Add creates an account.
+func (u *Users) Add(name, password string, access Access) error {
+  if !validName.MatchString(name) {
+    return fmt.Errorf("invalid user name %q", name)
+  }
+  if err := CheckAccess(access); err != nil {
+    return err
+  }
+  if err := CheckPassword(password); err != nil {
+    return err
+  }
+  hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+  if err != nil {
+    return err
+  }
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return err
+  }
+  if u.find(name) >= 0 {
+    return ErrUserExists
+  }
+  u.users = append(u.users, User{
+    Name:         name,
+    PasswordHash: string(hash),
+    Created:      time.Now().UTC().Truncate(time.Second),
+    Access:       access,
+  })
+  return u.save()
+}
+
+// SetAccess replaces the role and scope of an account.
+func (u *Users) SetAccess(name string, access Access) error {
+  if err := CheckAccess(access); err != nil {
+    return err
+  }
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return err
+  }
+  i := u.find(name)
+  if i < 0 {
+    return ErrNoUser
+  }
+  u.users[i].Access = access
+  return u.save()
+}
+
+// SetPassword replaces the password of an account.
+func (u *Users) SetPassword(name, password string) error {
+  if err := CheckPassword(password); err != nil {
+    return err
+  }
+  hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+  if err != nil {
+    return err
+  }
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return err
+  }
+  i := u.find(name)
+  if i < 0 {
+    return ErrNoUser
+  }
+  u.users[i].PasswordHash = string(hash)
+  return u.save()
+}
+
+// Delete removes an account.
+func (u *Users) Delete(name string) error {
+  u.mu.Lock()
+  defer u.mu.Unlock()
+  if err := u.load(); err != nil {
+    return err
+  }
+  i := u.find(name)
+  if i < 0 {
+    return ErrNoUser
+  }
+  u.users = slices.Delete(u.users, i, i+1)
+  return u.save()
+}
@@ -0,0 +1,903 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+)
+
+const (
+  defaultNAKStorm       = 10
+  defaultNAKStormWindow = 10 * time.Second
+  defaultMaxPackets     = 50000
+  // exchangeGap is how long a transaction stays open for more packets,
+  // and how soon a REQUEST must follow the DISCOVER it belongs to.
+  exchangeGap = 60 * time.Second
+  // unansweredGrace is how long a server gets to answer before the end
+  // of the capture; later requests are not reported as unanswered.
+  unansweredGrace = 2 * time.Second
+  // clientNAKs is the number of NAKs to one client worth a finding.
+  clientNAKs = 3
+)
+
+// Severities of findings.
+const (
+  SeverityError   = "error"
+  SeverityWarning = "warning"
+  SeverityInfo    = "info"
+)
+
+// Options tune the analysis.
+type Options struct {
+  // KnownServers lists the server identifiers (IPv4 addresses or DUIDs)
+  // and server addresses expected to answer. When empty, any server is
+  // accepted but several servers on one family are reported.
+  KnownServers []string
+  // NAKStorm NAKs within NAKStormWindow are reported as a storm.
+  NAKStorm       int
+  NAKStormWindow time.Duration
+  // MaxPackets caps the DHCP packets analysed.
+  MaxPackets int
+}
+
+// Packet is a decoded DHCP packet of the capture.
+type Packet struct {
+  // N is the frame number, counting from 1 like Wireshark.
+  N       int
+  Time    time.Time
+  // Offset is Time relative to the start of the capture.
+  Offset  string
+  Family  int
+  Src     netip.AddrPort
+  Dst     netip.AddrPort
+  SrcMAC  string
+  VLAN    int
+  Type    string
+  XID     uint32
+  Client  string
+  Server  string
+  Relay   string
+  Address string
+  Status  string
+  Options []dhcp.DecodedOption
+  Error   string
+
+  msgType    int
+  fromServer bool
+  nak        bool
+  selecting  bool
+  renewing   bool
+}
+
+// FromServer reports whether a server sent the packet.
+func (p *Packet) FromServer() bool {
+  return p.fromServer
+}
+
+// Transaction groups the packets of one exchange between a client and
+// the servers.
+type Transaction struct {
+  ID      int
+  Family  int
+  XID     uint32
+  Client  string
+  Kind    string
+  Outcome string
+  Start   time.Time
+  End     time.Time
+  Address string
+  Servers []string
+  Packets []*Packet
+
+  answered bool
+}
+
+// Duration is the time from the first to the last packet.
+func (t *Transaction) Duration() time.Duration {
+  return t.End.Sub(t.Start).Round(time.Microsecond)
+}
+
+// Failed reports whether the exchange did not complete.
+func (t *Transaction) Failed() bool {
+  switch t.Outcome {
+  case "ack", "reply", "sent":
+    return false
+  }
+  return true
+}
+
+// Server is a DHCP server seen answering in the capture.
+type Server struct {
+  Family  int
+  ID      string
+  Addrs   []string
+  Offers  int
+  Acks    int
+  Naks    int
+  Known   bool
+  First   time.Time
+  FirstN  int
+}
+
+// Finding is a problem spotted in the capture.
+type Finding struct {
+  Severity string
+  Kind     string
+  Message  string
+  Time     time.Time
+  // Packets are frame numbers to look at.
+  Packets []int
+}
+
+// Report is the result of analysing a capture.
+type Report struct {
+  Format       string
+  Frames       int
+  Start        time.Time
+  End          time.Time
+  DHCP         int
+  Malformed    int
+  Fragments    int
+  Truncated    bool
+  ReadError    string
+  Packets      []*Packet
+  Transactions []*Transaction
+  Servers      []*Server
+  Findings     []Finding
+}
+
+// Offset renders t relative to the start of the capture.
+func (r *Report) Offset(t time.Time) string {
+  if t.IsZero() || r.Start.IsZero() {
+    return ""
+  }
+  return fmt.Sprintf("+%.3fs", t.Sub(r.Start).Seconds())
+}
+
+// Count returns how many findings have severity.
+func (r *Report) Count(severity string) int {
+  n := 0
+  for _, f := range r.Findings {
+    if f.Severity == severity {
+      n++
+    }
+  }
+  return n
+}
+
+// Analyze reads a pcap or pcapng capture and reports its DHCP traffic.
+func Analyze(in io.Reader, opts Options) (*Report, error) {
+  if opts.NAKStorm <= 0 {
+    opts.NAKStorm = defaultNAKStorm
+  }
+  if opts.NAKStormWindow <= 0 {
+    opts.NAKStormWindow = defaultNAKStormWindow
+  }
+  if opts.MaxPackets <= 0 {
+    opts.MaxPackets = defaultMaxPackets
+  }
+
+  rd, err := NewReader(in)
+  if err != nil {
+    return nil, err
+  }
+  rep := &Report{Format: rd.Format}
+
+  for {
+    f, err := rd.Next()
+    if errors.Is(err, io.EOF) {
+      break
+    }
+    if err != nil {
+      if rep.Frames == 0 {
+        return nil, err
+      }
+      // Keep what was read; captures cut short are common.
+      rep.ReadError = err.Error()
+      break
+    }
+    rep.Frames++
+    if !f.Time.IsZero() {
+      if rep.Start.IsZero() || f.Time.Before(rep.Start) {
+        rep.Start = f.Time
+      }
+      if f.Time.After(rep.End) {
+        rep.End = f.Time
+      }
+    }
+
+    d, err := DecodeUDP(f)
+    switch {
+    case errors.Is(err, errFragment):
+      rep.Fragments++
+      continue
+    case err != nil:
+      continue
+    }
+    p := decodePacket(rep.Frames, d)
+    if p == nil {
+      continue
+    }
+    if len(rep.Packets) >= opts.MaxPackets {
+      rep.Truncated = true
+      break
+    }
+    rep.DHCP++
+    if p.Error != "" {
+      rep.Malformed++
+    }
+    rep.Packets = append(rep.Packets, p)
+  }
+
+  for _, p := range rep.Packets {
+    p.Offset = rep.Offset(p.Time)
+  }
+  rep.Transactions = groupTransactions(rep.Packets)
+  rep.Servers = collectServers(rep.Packets, opts.KnownServers)
+  rep.Findings = findProblems(rep, opts)
+  return rep, nil
+}
+
+// isDHCP4Port and isDHCP6Port pick the datagrams worth decoding.
+func isDHCP4Port(d Datagram) bool {
+  return d.Src.Port() == 67 || d.Dst.Port() == 67 || d.Src.Port() == 68 || d.Dst.Port() == 68
+}
+
+func isDHCP6Port(d Datagram) bool {
+  return d.Src.Port() == 547 || d.Dst.Port() == 547 || d.Src.Port() == 546 || d.Dst.Port() == 546
+}
+
+// decodePacket decodes a DHCP datagram, or returns nil for other
+// traffic.
+func decodePacket(n int, d Datagram) *Packet {
+  p := &Packet{N: n, Time: d.Time, Src: d.Src, Dst: d.Dst, VLAN: d.VLAN}
+  if d.SrcMAC != nil {
+    p.SrcMAC = d.SrcMAC.String()
+  }
+
+  switch {
+  case d.Src.Addr().Unmap().Is4() && isDHCP4Port(d):
+    p.Family = 4
+    pkt, err := dhcp.ParsePacket4(d.Payload)
+    if pkt == nil {
+      p.Type = "malformed"
+      p.Error = err.Error()
+      return p
+    }
+    if err != nil {
+      p.Error = err.Error()
+    }
+    fillPacket4(p, pkt)
+  case d.Src.Addr().Is6() && isDHCP6Port(d):
+    p.Family = 6
+    pkt, err := dhcp.ParsePacket6(d.Payload)
+    if pkt == nil {
+      p.Type = "malformed"
+      p.Error = err.Error()
+      return p
+    }
+    if err != nil {
+      p.Error = err.Error()
+    }
+    fillPacket6(p, pkt)
+  default:
+    return nil
+  }
+  return p
+}
+
+func fillPacket4(p *Packet, pkt *dhcp.Packet4) {
+  p.msgType = pkt.MessageType()
+  p.Type = dhcp.MessageTypeName4(p.msgType)
+  p.XID = pkt.XID
+  p.fromServer = pkt.Op == dhcp.BootReply
+  if pkt.HLen > 0 && len(pkt.CHAddr) > 0 {
+    p.Client = pkt.CHAddr.String()
+  } else {
+    p.Client = dhcp.HexString(pkt.Option(dhcp.OptClientID4))
+  }
+  if pkt.GIAddr.IsValid() && !pkt.GIAddr.IsUnspecified() {
+    p.Relay = pkt.GIAddr.String()
+  }
+
+  if p.fromServer {
+    if id := pkt.ServerID(); id.IsValid() {
+      p.Server = id.String()
+    } else {
+      p.Server = p.Src.Addr().Unmap().String()
+    }
+  }
+  switch p.msgType {
+  case dhcp.Offer4, dhcp.Ack4, 0:
+    if !pkt.YIAddr.IsUnspecified() {
+      p.Address = pkt.YIAddr.String()
+    }
+  case dhcp.Nak4:
+    p.nak = true
+  case dhcp.Request4:
+    p.selecting = pkt.ServerID().IsValid() && pkt.CIAddr.IsUnspecified()
+    p.renewing = !pkt.CIAddr.IsUnspecified()
+    fallthrough
+  case dhcp.Decline4, dhcp.Release4, dhcp.Inform4:
+    if a := pkt.RequestedAddress(); a.IsValid() {
+      p.Address = a.String()
+    } else if !pkt.CIAddr.IsUnspecified() {
+      p.Address = pkt.CIAddr.String()
+    }
+  }
+  p.Options = dhcp.DecodeOptions(4, pkt.Options)
+}
+
+func fillPacket6(p *Packet, pkt *dhcp.Packet6) {
+  inner := pkt.Inner()
+  p.msgType = inner.Type
+  p.Type = dhcp.MessageTypeName6(inner.Type)
+  p.XID = inner.XID
+  p.Client = dhcp.HexString(inner.Option(dhcp.OptClientID6))
+  switch inner.Type {
+  case dhcp.Advertise6, dhcp.Reply6, dhcp.Reconfigure6:
+    p.fromServer = true
+    p.Server = dhcp.HexString(inner.Option(dhcp.OptServerID6))
+    if p.Server == "" {
+      p.Server = p.Src.Addr().String()
+    }
+  case dhcp.Request6:
+    p.selecting = true
+  }
+
+  relays := pkt.Relays()
+  if len(relays) > 0 {
+    p.Relay = relays[len(relays)-1].LinkAddr.String()
+  }
+
+  leases, statuses := inner.Leases()
+  var addrs []string
+  for _, l := range leases {
+    if l.Status == dhcp.StatusSuccess {
+      if l.Prefix.Bits() == 128 {
+        addrs = append(addrs, l.Prefix.Addr().String())
+      } else {
+        addrs = append(addrs, l.Prefix.String())
+      }
+    }
+  }
+  p.Address = strings.Join(addrs, ", ")
+  var failed []string
+  for _, s := range statuses {
+    if s != dhcp.StatusSuccess {
+      failed = append(failed, dhcp.StatusName(s))
+    }
+  }
+  p.Status = strings.Join(failed, ", ")
+  // A reply refusing the client's bindings is DHCPv6's NAK.
+  if inner.Type == dhcp.Reply6 && len(failed) > 0 && len(addrs) == 0 {
+    for _, s := range statuses {
+      if s == dhcp.StatusNoBinding || s == dhcp.StatusNotOnLink {
+        p.nak = true
+      }
+    }
+  }
+
+  for _, r := range relays {
+    var opts []dhcp.Option
+    for _, o := range r.Options {
+      if o.Code != dhcp.OptRelayMsg6 {
+        opts = append(opts, o)
+      }
+    }
+    p.Options = append(p.Options, dhcp.DecodedOption{
+      Name:  strings.ToLower(dhcp.MessageTypeName6(r.Type)),
+      Value: fmt.Sprintf("hop %d link %s peer %s", r.HopCount, r.LinkAddr, r.PeerAddr),
+      Sub:   dhcp.DecodeOptions(6, opts),
+    })
+  }
+  p.Options = append(p.Options, dhcp.DecodeOptions(6, inner.Options)...)
+}
+
+// groupTransactions assigns packets to exchanges by transaction ID and
+// client. A REQUEST that picks an offer joins the client's open
+// DISCOVER (or SOLICIT) exchange even under another transaction ID, as
+// DHCPv6 always and some DHCPv4 clients do.
+func groupTransactions(packets []*Packet) []*Transaction {
+  var out []*Transaction
+  open := map[string]*Transaction{}
+  discovering := map[string]*Transaction{}
+
+  for _, p := range packets {
+    if p.Type == "malformed" {
+      continue
+    }
+    key := strconv.Itoa(p.Family) + "|" + strconv.FormatUint(uint64(p.XID), 16) + "|" + p.Client
+    clientKey := strconv.Itoa(p.Family) + "|" + p.Client
+
+    t := open[key]
+    if t != nil && p.Time.Sub(t.End) > exchangeGap {
+      t = nil
+    }
+    if t == nil && p.selecting && !p.fromServer {
+      if d := discovering[clientKey]; d != nil && p.Time.Sub(d.End) <= exchangeGap && d.Outcome == "" {
+        t = d
+      }
+    }
+    if t == nil {
+      t = &Transaction{ID: len(out) + 1, Family: p.Family, XID: p.XID, Client: p.Client, Start: p.Time}
+      out = append(out, t)
+    }
+    open[key] = t
+    if !p.fromServer && (p.msgType == dhcp.Discover4 && p.Family == 4 || p.msgType == dhcp.Solicit6 && p.Family == 6) {
+      discovering[clientKey] = t
+    }
+
+    t.Packets = append(t.Packets, p)
+    t.End = p.Time
+    if p.fromServer {
+      t.answered = true
+      if !containsString(t.Servers, p.Server) {
+        t.Servers = append(t.Servers, p.Server)
+      }
+      // Final answers close the exchange for the REQUEST merge.
+      if p.Family == 4 && (p.msgType == dhcp.Ack4 || p.msgType == dhcp.Nak4) || p.Family == 6 && p.msgType == dhcp.Reply6 {
+        t.Outcome = "done"
+      }
+    }
+  }
+
+  for _, t := range out {
+    classify(t)
+  }
+  return out
+}
+
+// classify names the kind and outcome of an exchange.
+func classify(t *Transaction) {
+  seen := map[int]bool{}
+  var first *Packet
+  for _, p := range t.Packets {
+    if !p.fromServer {
+      seen[p.msgType] = true
+      if first == nil {
+        first = p
+      }
+    }
+  }
+
+  if t.Family == 4 {
+    switch {
+    case seen[dhcp.Discover4]:
+      t.Kind = "DORA"
+    case seen[dhcp.Request4]:
+      req := firstOfType(t.Packets, dhcp.Request4)
+      switch {
+      case req.selecting:
+        t.Kind = "DORA"
+      case req.renewing && !isBroadcast4(req.Dst.Addr()):
+        t.Kind = "renew"
+      case req.renewing:
+        t.Kind = "rebind"
+      default:
+        t.Kind = "init-reboot"
+      }
+    case seen[dhcp.Decline4]:
+      t.Kind = "decline"
+    case seen[dhcp.Release4]:
+      t.Kind = "release"
+    case seen[dhcp.Inform4]:
+      t.Kind = "inform"
+    case first != nil:
+      t.Kind = strings.ToLower(first.Type)
+    default:
+      t.Kind = "server only"
+    }
+  } else {
+    switch {
+    case seen[dhcp.Solicit6] && !seen[dhcp.Request6] && hasServerType(t.Packets, dhcp.Reply6):
+      t.Kind = "rapid-commit"
+    case seen[dhcp.Solicit6] || seen[dhcp.Request6]:
+      t.Kind = "SARR"
+    case seen[dhcp.Renew6]:
+      t.Kind = "renew"
+    case seen[dhcp.Rebind6]:
+      t.Kind = "rebind"
+    case seen[dhcp.Confirm6]:
+      t.Kind = "confirm"
+    case seen[dhcp.Decline6]:
+      t.Kind = "decline"
+    case seen[dhcp.Release6]:
+      t.Kind = "release"
+    case seen[dhcp.InformationRequest6]:
+      t.Kind = "information-request"
+    case first != nil:
+      t.Kind = strings.ToLower(first.Type)
+    default:
+      t.Kind = "server only"
+    }
+  }
+
+  // The last server answer decides the outcome.
+  var last *Packet
+  for _, p := range t.Packets {
+    if p.fromServer {
+      last = p
+      if p.Address != "" {
+        t.Address = p.Address
+      }
+    }
+  }
+  switch {
+  case last == nil && (t.Kind == "release" || t.Kind == "decline" && t.Family == 4):
+    t.Outcome = "sent"
+    if first != nil {
+      t.Address = first.Address
+    }
+  case last == nil:
+    t.Outcome = "no response"
+  case last.nak:
+    t.Outcome = "nak"
+  case t.Family == 4 && last.msgType == dhcp.Ack4:
+    t.Outcome = "ack"
+  case t.Family == 6 && last.msgType == dhcp.Reply6 && last.Status != "":
+    t.Outcome = strings.ToLower(last.Status)
+  case t.Family == 6 && last.msgType == dhcp.Reply6:
+    t.Outcome = "reply"
+  case seen[dhcp.Request4] && t.Family == 4 || seen[dhcp.Request6] && t.Family == 6:
+    t.Outcome = "no reply to request"
+  case t.Family == 4 && last.msgType == dhcp.Offer4:
+    t.Outcome = "offered, no request"
+  case t.Family == 6 && last.msgType == dhcp.Advertise6:
+    t.Outcome = "advertised, no request"
+  default:
+    t.Outcome = strings.ToLower(last.Type)
+  }
+}
+
+func firstOfType(packets []*Packet, typ int) *Packet {
+  for _, p := range packets {
+    if !p.fromServer && p.msgType == typ {
+      return p
+    }
+  }
+  return nil
+}
+
+func hasServerType(packets []*Packet, typ int) bool {
+  for _, p := range packets {
+    if p.fromServer && p.msgType == typ {
+      return true
+    }
+  }
+  return false
+}
+
+func isBroadcast4(a netip.Addr) bool {
+  return a.Unmap() == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
+
+func containsString(list []string, s string) bool {
+  for _, v := range list {
+    if v == s {
+      return true
+    }
+  }
+  return false
+}
+
+// normalizeServerID makes DUIDs comparable however they are written.
+func normalizeServerID(s string) string {
+  s = strings.ToLower(strings.TrimSpace(s))
+  if a, err := netip.ParseAddr(s); err == nil {
+    return a.Unmap().String()
+  }
+  return strings.NewReplacer(":", "", "-", "", " ", "").Replace(s)
+}
+
+// ServerSet matches servers against a list of identifiers and addresses.
+type ServerSet map[string]bool
+
+// NewServerSet builds a set from IPv4 server identifiers, DUIDs (in any
+// hex notation) and server addresses.
+func NewServerSet(ids []string) ServerSet {
+  set := ServerSet{}
+  for _, id := range ids {
+    if id = normalizeServerID(id); id != "" {
+      set[id] = true
+    }
+  }
+  return set
+}
+
+// Has reports whether s is in the set by identifier or by any of its
+// addresses.
+func (set ServerSet) Has(s *Server) bool {
+  if set[normalizeServerID(s.ID)] {
+    return true
+  }
+  for _, a := range s.Addrs {
+    if set[normalizeServerID(a)] {
+      return true
+    }
+  }
+  return false
+}
+
+// collectServers lists the servers that answered, matching them against
+// the known servers.
+func collectServers(packets []*Packet, known []string) []*Server {
+  byID := map[string]*Server{}
+  var out []*Server
+  for _, p := range packets {
+    if !p.fromServer {
+      continue
+    }
+    key := strconv.Itoa(p.Family) + "|" + p.Server
+    s := byID[key]
+    if s == nil {
+      s = &Server{Family: p.Family, ID: p.Server, First: p.Time, FirstN: p.N}
+      byID[key] = s
+      out = append(out, s)
+    }
+    if a := p.Src.Addr().Unmap().String(); !containsString(s.Addrs, a) {
+      s.Addrs = append(s.Addrs, a)
+    }
+    switch {
+    case p.nak:
+      s.Naks++
+    case p.Family == 4 && p.msgType == dhcp.Offer4, p.Family == 6 && p.msgType == dhcp.Advertise6:
+      s.Offers++
+    case p.Family == 4 && p.msgType == dhcp.Ack4, p.Family == 6 && p.msgType == dhcp.Reply6:
+      s.Acks++
+    }
+  }
+
+  set := NewServerSet(known)
+  for _, s := range out {
+    s.Known = set.Has(s)
+  }
+  return out
+}
+
+// findProblems looks for unanswered clients, NAK storms, unexpected
+// servers, declines and damaged packets.
+func findProblems(rep *Report, opts Options) []Finding {
+  var out []Finding
+
+  // Servers.
+  if len(opts.KnownServers) > 0 {
+    for _, s := range rep.Servers {
+      if s.Known {
+        continue
+      }
+      out = append(out, Finding{
+        Severity: SeverityError,
+        Kind:     "unexpected-server",
+        Message: fmt.Sprintf("DHCPv%d server %s (%s) is not a known server: %d offers, %d acks, %d NAKs",
+          s.Family, s.ID, strings.Join(s.Addrs, ", "), s.Offers, s.Acks, s.Naks),
+        Time:    s.First,
+        Packets: []int{s.FirstN},
+      })
+    }
+  } else {
+    for _, family := range []int{4, 6} {
+      var ids []string
+      var first []int
+      for _, s := range rep.Servers {
+        if s.Family == family {
+          ids = append(ids, s.ID+" ("+strings.Join(s.Addrs, ", ")+")")
+          first = append(first, s.FirstN)
+        }
+      }
+      if len(ids) > 1 {
+        out = append(out, Finding{
+          Severity: SeverityWarning,
+          Kind:     "multiple-servers",
+          Message:  fmt.Sprintf("%d DHCPv%d servers answered: %s. List the expected ones to flag the others.", len(ids), family, strings.Join(ids, "; ")),
+          Packets:  first,
+        })
+      }
+    }
+  }
+
+  // Unanswered requests, per client.
+  type unanswered struct {
+    family  int
+    typ     string
+    count   int
+    first   time.Time
+    packets []int
+  }
+  var clients []string
+  byClient := map[string]*unanswered{}
+  for _, t := range rep.Transactions {
+    if len(t.Packets) == 0 || t.answered || t.Outcome == "sent" || t.Kind == "server only" {
+      continue
+    }
+    if rep.End.Sub(t.End) < unansweredGrace {
+      continue
+    }
+    k := strconv.Itoa(t.Family) + "|" + t.Client
+    u := byClient[k]
+    if u == nil {
+      u = &unanswered{family: t.Family, typ: t.Packets[0].Type, first: t.Start}
+      byClient[k] = u
+      clients = append(clients, k)
+    }
+    for _, p := range t.Packets {
+      u.count++
+      if len(u.packets) < 10 {
+        u.packets = append(u.packets, p.N)
+      }
+    }
+  }
+  for _, k := range clients {
+    u := byClient[k]
+    client := strings.SplitN(k, "|", 2)[1]
+    out = append(out, Finding{
+      Severity: SeverityWarning,
+      Kind:     "unanswered",
+      Message:  fmt.Sprintf("DHCPv%d client %s sent %d packets (%s) that no server answered", u.family, client, u.count, u.typ),
+      Time:     u.first,
+      Packets:  u.packets,
+    })
+  }
+  if len(clients) >= 5 {
+    out = append(out, Finding{
+      Severity: SeverityError,
+      Kind:     "unanswered",
+      Message:  fmt.Sprintf("%d clients got no answer at all; is the server reachable on this link or through the relay?", len(clients)),
+    })
+  }
+
+  // NAK storms and clients stuck in NAK loops.
+  naks := map[int][]*Packet{}
+  perClient := map[string][]*Packet{}
+  var nakClients []string
+  for _, p := range rep.Packets {
+    if !p.nak {
+      continue
+    }
+    naks[p.Family] = append(naks[p.Family], p)
+    k := strconv.Itoa(p.Family) + "|" + p.Client
+    if perClient[k] == nil {
+      nakClients = append(nakClients, k)
+    }
+    perClient[k] = append(perClient[k], p)
+  }
+  for _, family := range []int{4, 6} {
+    out = append(out, nakStorms(naks[family], opts)...)
+  }
+  for _, k := range nakClients {
+    ps := perClient[k]
+    if len(ps) < clientNAKs {
+      continue
+    }
+    var frames []int
+    for _, p := range ps {
+      if len(frames) < 10 {
+        frames = append(frames, p.N)
+      }
+    }
+    out = append(out, Finding{
+      Severity: SeverityWarning,
+      Kind:     "nak-loop",
+      Message:  fmt.Sprintf("DHCPv%d client %s was refused %d times (last by %s)", ps[0].Family, ps[0].Client, len(ps), ps[len(ps)-1].Server),
+      Time:     ps[0].Time,
+      Packets:  frames,
+    })
+  }
+
+  // Declines point at address conflicts.
+  for _, p := range rep.Packets {
+    if !p.fromServer && (p.Family == 4 && p.msgType == dhcp.Decline4 || p.Family == 6 && p.msgType == dhcp.Decline6) {
+      out = append(out, Finding{
+        Severity: SeverityWarning,
+        Kind:     "decline",
+        Message:  fmt.Sprintf("DHCPv%d client %s declined %s; the address is probably in use by another host", p.Family, p.Client, orDash(p.Address)),
+        Time:     p.Time,
+        Packets:  []int{p.N},
+      })
+    }
+  }
+
+  if rep.Malformed > 0 {
+    var frames []int
+    for _, p := range rep.Packets {
+      if p.Error != "" && len(frames) < 10 {
+        frames = append(frames, p.N)
+      }
+    }
+    out = append(out, Finding{
+      Severity: SeverityWarning,
+      Kind:     "malformed",
+      Message:  fmt.Sprintf("%d DHCP packets could not be fully decoded", rep.Malformed),
+      Packets:  frames,
+    })
+  }
+  if rep.Fragments > 0 {
+    out = append(out, Finding{
+      Severity: SeverityInfo,
+      Kind:     "fragments",
+      Message:  fmt.Sprintf("%d IP fragments were skipped; fragmented DHCP packets are not reassembled", rep.Fragments),
+    })
+  }
+  if rep.ReadError != "" {
+    out = append(out, Finding{
+      Severity: SeverityInfo,
+      Kind:     "truncated",
+      Message:  "The capture ends early: " + rep.ReadError,
+    })
+  }
+  if rep.Truncated {
+    out = append(out, Finding{
+      Severity: SeverityInfo,
+      Kind:     "truncated",
+      Message:  fmt.Sprintf("Only the first %d DHCP packets were analysed", len(rep.Packets)),
+    })
+  }
+
+  rank := map[string]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+  sort.SliceStable(out, func(i, j int) bool { return rank[out[i].Severity] < rank[out[j].Severity] })
+  return out
+}
+
+// nakStorms finds runs of at least opts.NAKStorm NAKs within
+// opts.NAKStormWindow; naks are in capture order.
+func nakStorms(naks []*Packet, opts Options) []Finding {
+  var out []Finding
+  for i := 0; i < len(naks); {
+    j := i
+    for j+1 < len(naks) && naks[j+1].Time.Sub(naks[i].Time) <= opts.NAKStormWindow {
+      j++
+    }
+    if j-i+1 < opts.NAKStorm {
+      i++
+      continue
+    }
+    // Extend the storm while the rate holds.
+    for j+1 < len(naks) && naks[j+1].Time.Sub(naks[j+1-opts.NAKStorm+1].Time) <= opts.NAKStormWindow {
+      j++
+    }
+    storm := naks[i : j+1]
+    servers := map[string]bool{}
+    clients := map[string]bool{}
+    var frames []int
+    for _, p := range storm {
+      servers[p.Server] = true
+      clients[p.Client] = true
+      if len(frames) < 10 {
+        frames = append(frames, p.N)
+      }
+    }
+    out = append(out, Finding{
+      Severity: SeverityError,
+      Kind:     "nak-storm",
+      Message: fmt.Sprintf("DHCPv%d NAK storm: %d NAKs in %.1fs from %s to %d clients",
+        storm[0].Family, len(storm), storm[len(storm)-1].Time.Sub(storm[0].Time).Seconds(), strings.Join(sortedKeys(servers), ", "), len(clients)),
+      Time:    storm[0].Time,
+      Packets: frames,
+    })
+    i = j + 1
+  }
+  return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+  out := make([]string, 0, len(m))
+  for k := range m {
+    out = append(out, k)
+  }
+  sort.Strings(out)
+  return out
+}
+
+func orDash(s string) string {
+  if s == "" {
+    return "-"
+  }
+  return s
+}
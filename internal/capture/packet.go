@@ -0,0 +1,197 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+  etherTypeIPv4 = 0x0800
+  etherTypeIPv6 = 0x86dd
+  etherTypeVLAN = 0x8100
+  etherTypeQinQ = 0x88a8
+  protoUDP      = 17
+)
+
+// Datagram is a UDP datagram pulled out of a frame.
+type Datagram struct {
+  Time    time.Time
+  SrcMAC  net.HardwareAddr
+  VLAN    int
+  Src     netip.AddrPort
+  Dst     netip.AddrPort
+  Payload []byte
+}
+
+// errNotUDP marks frames that are valid but carry something else.
+var errNotUDP = errors.New("not UDP")
+
+// errFragment marks IP fragments, which are not reassembled.
+var errFragment = errors.New("IP fragment")
+
+// DecodeUDP strips the link, IP and UDP headers off a frame. Frames that
+// are not UDP return errNotUDP.
+func DecodeUDP(f Frame) (Datagram, error) {
+  d := Datagram{Time: f.Time}
+  b := f.Data
+  etherType := 0
+
+  switch f.LinkType {
+  case LinkEthernet:
+    if len(b) < 14 {
+      return d, fmt.Errorf("short Ethernet header")
+    }
+    d.SrcMAC = net.HardwareAddr(b[6:12])
+    etherType = int(binary.BigEndian.Uint16(b[12:]))
+    b = b[14:]
+    for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+      if len(b) < 4 {
+        return d, fmt.Errorf("short VLAN tag")
+      }
+      if d.VLAN == 0 {
+        d.VLAN = int(binary.BigEndian.Uint16(b) & 0x0fff)
+      }
+      etherType = int(binary.BigEndian.Uint16(b[2:]))
+      b = b[4:]
+    }
+  case LinkSLL:
+    if len(b) < 16 {
+      return d, fmt.Errorf("short SLL header")
+    }
+    if n := int(binary.BigEndian.Uint16(b[4:])); n == 6 {
+      d.SrcMAC = net.HardwareAddr(b[6:12])
+    }
+    etherType = int(binary.BigEndian.Uint16(b[14:]))
+    b = b[16:]
+  case LinkSLL2:
+    if len(b) < 20 {
+      return d, fmt.Errorf("short SLL2 header")
+    }
+    if b[11] == 6 {
+      d.SrcMAC = net.HardwareAddr(b[12:18])
+    }
+    etherType = int(binary.BigEndian.Uint16(b))
+    b = b[20:]
+  case LinkNull, LinkLoop:
+    if len(b) < 4 {
+      return d, fmt.Errorf("short loopback header")
+    }
+    // The address family is in the capturing host's byte order.
+    fam := binary.LittleEndian.Uint32(b)
+    if fam > 0xffff {
+      fam = binary.BigEndian.Uint32(b)
+    }
+    switch fam {
+    case 2:
+      etherType = etherTypeIPv4
+    case 10, 24, 28, 30:
+      etherType = etherTypeIPv6
+    }
+    b = b[4:]
+  case LinkRaw, LinkIPv4, LinkIPv6:
+    if len(b) > 0 {
+      switch b[0] >> 4 {
+      case 4:
+        etherType = etherTypeIPv4
+      case 6:
+        etherType = etherTypeIPv6
+      }
+    }
+  default:
+    return d, fmt.Errorf("link type %d is not supported", f.LinkType)
+  }
+
+  var src, dst netip.Addr
+  var err error
+  switch etherType {
+  case etherTypeIPv4:
+    src, dst, b, err = ipv4Payload(b)
+  case etherTypeIPv6:
+    src, dst, b, err = ipv6Payload(b)
+  default:
+    return d, errNotUDP
+  }
+  if err != nil {
+    return d, err
+  }
+
+  if len(b) < 8 {
+    return d, fmt.Errorf("short UDP header")
+  }
+  n := int(binary.BigEndian.Uint16(b[4:]))
+  if n < 8 {
+    return d, fmt.Errorf("bad UDP length %d", n)
+  }
+  if n > len(b) {
+    if !f.Truncated {
+      return d, fmt.Errorf("UDP length %d overruns the frame", n)
+    }
+    n = len(b)
+  }
+  d.Src = netip.AddrPortFrom(src, binary.BigEndian.Uint16(b))
+  d.Dst = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2:]))
+  d.Payload = b[8:n]
+  return d, nil
+}
+
+func ipv4Payload(b []byte) (src, dst netip.Addr, payload []byte, err error) {
+  if len(b) < 20 || b[0]>>4 != 4 {
+    return src, dst, nil, fmt.Errorf("bad IPv4 header")
+  }
+  ihl := int(b[0]&0x0f) * 4
+  total := int(binary.BigEndian.Uint16(b[2:]))
+  if ihl < 20 || len(b) < ihl {
+    return src, dst, nil, fmt.Errorf("bad IPv4 header length")
+  }
+  if total >= ihl && total < len(b) {
+    // Drop Ethernet padding.
+    b = b[:total]
+  }
+  src = netip.AddrFrom4([4]byte(b[12:16]))
+  dst = netip.AddrFrom4([4]byte(b[16:20]))
+  if b[9] != protoUDP {
+    return src, dst, nil, errNotUDP
+  }
+  if flags := binary.BigEndian.Uint16(b[6:]); flags&0x2000 != 0 || flags&0x1fff != 0 {
+    return src, dst, nil, errFragment
+  }
+  return src, dst, b[ihl:], nil
+}
+
+func ipv6Payload(b []byte) (src, dst netip.Addr, payload []byte, err error) {
+  if len(b) < 40 || b[0]>>4 != 6 {
+    return src, dst, nil, fmt.Errorf("bad IPv6 header")
+  }
+  plen := int(binary.BigEndian.Uint16(b[4:]))
+  next := int(b[6])
+  src = netip.AddrFrom16([16]byte(b[8:24]))
+  dst = netip.AddrFrom16([16]byte(b[24:40]))
+  b = b[40:]
+  if plen > 0 && plen < len(b) {
+    b = b[:plen]
+  }
+  // Skip extension headers.
+  for {
+    switch next {
+    case protoUDP:
+      return src, dst, b, nil
+    case 0, 43, 60: // hop-by-hop, routing, destination options
+      if len(b) < 8 {
+        return src, dst, nil, fmt.Errorf("short IPv6 extension header")
+      }
+      n := (int(b[1]) + 1) * 8
+      if n > len(b) {
+        return src, dst, nil, fmt.Errorf("short IPv6 extension header")
+      }
+      next, b = int(b[0]), b[n:]
+    case 44: // fragment
+      return src, dst, nil, errFragment
+    default:
+      return src, dst, nil, errNotUDP
+    }
+  }
+}
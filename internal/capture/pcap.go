@@ -0,0 +1,285 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Link types (https://www.tcpdump.org/linktypes.html) this package
+// decodes.
+const (
+  LinkNull     = 0
+  LinkEthernet = 1
+  LinkRaw      = 101
+  LinkLoop     = 108
+  LinkSLL      = 113
+  LinkIPv4     = 228
+  LinkIPv6     = 229
+  LinkSLL2     = 276
+)
+
+const (
+  // maxFrameLen rejects records claiming absurd sizes, which usually
+  // means the file is corrupt.
+  maxFrameLen = 1 << 20
+
+  pcapMagicMicro = 0xa1b2c3d4
+  pcapMagicNano  = 0xa1b23c4d
+  pcapngSHB      = 0x0a0d0d0a
+  pcapngBOM      = 0x1a2b3c4d
+
+  pcapngIDB = 0x00000001
+  pcapngPB  = 0x00000002 // obsolete packet block
+  pcapngSPB = 0x00000003
+  pcapngEPB = 0x00000006
+)
+
+// Frame is one captured link-layer frame.
+type Frame struct {
+  Time     time.Time
+  LinkType int
+  Data     []byte
+  // Truncated is set when the capture snap length cut the frame.
+  Truncated bool
+}
+
+// Reader reads frames from a pcap or pcapng stream.
+type Reader struct {
+  r      *bufio.Reader
+  Format string // "pcap" or "pcapng"
+
+  // pcap
+  order    binary.ByteOrder
+  nano     bool
+  linkType int
+
+  // pcapng: the interfaces of the current section.
+  ifaces []pcapngIface
+}
+
+type pcapngIface struct {
+  linkType int
+  // tsUnit is the length of one timestamp tick.
+  tsUnit time.Duration
+  // tsDiv divides ticks finer than a nanosecond.
+  tsDiv uint64
+}
+
+// ErrFormat is returned for input that is neither pcap nor pcapng.
+var ErrFormat = errors.New("not a pcap or pcapng file")
+
+// NewReader reads the file header and detects the format.
+func NewReader(r io.Reader) (*Reader, error) {
+  br := bufio.NewReaderSize(r, 64<<10)
+  head, err := br.Peek(4)
+  if err != nil {
+    return nil, ErrFormat
+  }
+  rd := &Reader{r: br}
+
+  if binary.BigEndian.Uint32(head) == pcapngSHB {
+    rd.Format = "pcapng"
+    // The section header block is read by Next like any other block.
+    return rd, nil
+  }
+
+  rd.Format = "pcap"
+  hdr := make([]byte, 24)
+  if _, err := io.ReadFull(br, hdr); err != nil {
+    return nil, ErrFormat
+  }
+  switch {
+  case binary.LittleEndian.Uint32(hdr) == pcapMagicMicro:
+    rd.order = binary.LittleEndian
+  case binary.BigEndian.Uint32(hdr) == pcapMagicMicro:
+    rd.order = binary.BigEndian
+  case binary.LittleEndian.Uint32(hdr) == pcapMagicNano:
+    rd.order, rd.nano = binary.LittleEndian, true
+  case binary.BigEndian.Uint32(hdr) == pcapMagicNano:
+    rd.order, rd.nano = binary.BigEndian, true
+  default:
+    return nil, ErrFormat
+  }
+  // The FCS flags live in the top bits of the link type.
+  rd.linkType = int(rd.order.Uint32(hdr[20:]) & 0x0fffffff)
+  return rd, nil
+}
+
+// Next returns the next frame, or io.EOF at the end of the file.
+func (rd *Reader) Next() (Frame, error) {
+  if rd.Format == "pcapng" {
+    return rd.nextBlock()
+  }
+  return rd.nextRecord()
+}
+
+func (rd *Reader) nextRecord() (Frame, error) {
+  hdr := make([]byte, 16)
+  if _, err := io.ReadFull(rd.r, hdr); err != nil {
+    if errors.Is(err, io.ErrUnexpectedEOF) {
+      return Frame{}, fmt.Errorf("truncated record header")
+    }
+    return Frame{}, err
+  }
+  sec := int64(rd.order.Uint32(hdr))
+  frac := int64(rd.order.Uint32(hdr[4:]))
+  incl := rd.order.Uint32(hdr[8:])
+  orig := rd.order.Uint32(hdr[12:])
+  if incl > maxFrameLen {
+    return Frame{}, fmt.Errorf("record of %d bytes; the file is corrupt", incl)
+  }
+  data := make([]byte, incl)
+  if _, err := io.ReadFull(rd.r, data); err != nil {
+    return Frame{}, fmt.Errorf("truncated record")
+  }
+  if !rd.nano {
+    frac *= 1000
+  }
+  return Frame{
+    Time:      time.Unix(sec, frac).UTC(),
+    LinkType:  rd.linkType,
+    Data:      data,
+    Truncated: orig > incl,
+  }, nil
+}
+
+// nextBlock reads pcapng blocks until one holds a packet.
+func (rd *Reader) nextBlock() (Frame, error) {
+  for {
+    typ, body, err := rd.readBlock()
+    if err != nil {
+      return Frame{}, err
+    }
+    switch typ {
+    case pcapngSHB:
+      rd.ifaces = nil
+    case pcapngIDB:
+      if len(body) < 8 {
+        return Frame{}, fmt.Errorf("short interface description block")
+      }
+      rd.ifaces = append(rd.ifaces, rd.parseIDB(body))
+    case pcapngEPB, pcapngPB:
+      if len(body) < 20 {
+        return Frame{}, fmt.Errorf("short packet block")
+      }
+      var id uint32
+      if typ == pcapngEPB {
+        id = rd.order.Uint32(body)
+      } else {
+        id = uint32(rd.order.Uint16(body))
+      }
+      if int(id) >= len(rd.ifaces) {
+        return Frame{}, fmt.Errorf("packet on undeclared interface %d", id)
+      }
+      ifc := rd.ifaces[id]
+      ts := uint64(rd.order.Uint32(body[4:]))<<32 | uint64(rd.order.Uint32(body[8:]))
+      incl, orig := rd.order.Uint32(body[12:]), rd.order.Uint32(body[16:])
+      if int(incl) > len(body)-20 {
+        return Frame{}, fmt.Errorf("packet block overruns its length")
+      }
+      return Frame{
+        Time:      ifc.time(ts),
+        LinkType:  ifc.linkType,
+        Data:      body[20 : 20+incl],
+        Truncated: orig > incl,
+      }, nil
+    case pcapngSPB:
+      // No timestamp and no captured length; the packet runs to the
+      // padding.
+      if len(rd.ifaces) == 0 || len(body) < 4 {
+        return Frame{}, fmt.Errorf("simple packet block without interface")
+      }
+      orig := rd.order.Uint32(body)
+      data := body[4:]
+      if int(orig) < len(data) {
+        data = data[:orig]
+      }
+      return Frame{LinkType: rd.ifaces[0].linkType, Data: data, Truncated: int(orig) > len(data)}, nil
+    }
+  }
+}
+
+// readBlock reads one pcapng block and returns its type and body.
+func (rd *Reader) readBlock() (uint32, []byte, error) {
+  hdr := make([]byte, 8)
+  if _, err := io.ReadFull(rd.r, hdr); err != nil {
+    if errors.Is(err, io.ErrUnexpectedEOF) {
+      return 0, nil, fmt.Errorf("truncated block header")
+    }
+    return 0, nil, err
+  }
+
+  typ := binary.BigEndian.Uint32(hdr)
+  if typ == pcapngSHB {
+    // The byte order is only known after reading the byte-order magic.
+    bom, err := rd.r.Peek(4)
+    if err != nil {
+      return 0, nil, fmt.Errorf("truncated section header")
+    }
+    switch {
+    case binary.LittleEndian.Uint32(bom) == pcapngBOM:
+      rd.order = binary.LittleEndian
+    case binary.BigEndian.Uint32(bom) == pcapngBOM:
+      rd.order = binary.BigEndian
+    default:
+      return 0, nil, ErrFormat
+    }
+  } else if rd.order == nil {
+    return 0, nil, ErrFormat
+  } else {
+    typ = rd.order.Uint32(hdr)
+  }
+
+  total := rd.order.Uint32(hdr[4:])
+  if total < 12 || total%4 != 0 || total > maxFrameLen {
+    return 0, nil, fmt.Errorf("bad block length %d", total)
+  }
+  rest := make([]byte, total-8)
+  if _, err := io.ReadFull(rd.r, rest); err != nil {
+    return 0, nil, fmt.Errorf("truncated block")
+  }
+  // rest ends with the repeated total length.
+  return typ, rest[:len(rest)-4], nil
+}
+
+// parseIDB reads the link type and timestamp resolution of an interface.
+func (rd *Reader) parseIDB(body []byte) pcapngIface {
+  ifc := pcapngIface{linkType: int(rd.order.Uint16(body)), tsUnit: time.Microsecond}
+  opts := body[8:]
+  for len(opts) >= 4 {
+    code, n := rd.order.Uint16(opts), int(rd.order.Uint16(opts[2:]))
+    if code == 0 || 4+n > len(opts) {
+      break
+    }
+    if code == 9 && n >= 1 {
+      // if_tsresol: a power of ten, or of two with the top bit set.
+      v := opts[4]
+      if v&0x80 != 0 {
+        ifc.tsUnit, ifc.tsDiv = time.Second, uint64(1)<<(v&0x7f)
+      } else if v <= 9 {
+        ifc.tsUnit = time.Duration(math.Pow10(9 - int(v)))
+      } else {
+        ifc.tsUnit, ifc.tsDiv = time.Second, uint64(math.Pow10(int(v)))
+      }
+    }
+    opts = opts[4+(n+3)&^3:]
+  }
+  return ifc
+}
+
+func (ifc pcapngIface) time(ts uint64) time.Time {
+  if ifc.tsDiv != 0 {
+    sec := ts / ifc.tsDiv
+    frac := ts % ifc.tsDiv
+    return time.Unix(int64(sec), int64(float64(frac)/float64(ifc.tsDiv)*1e9)).UTC()
+  }
+  unit := uint64(ifc.tsUnit)
+  sec := ts / (uint64(time.Second) / unit)
+  rem := ts % (uint64(time.Second) / unit)
+  return time.Unix(int64(sec), int64(rem*unit)).UTC()
+}
@@ -0,0 +1,95 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// Marshal encodes p. Options longer than 255 bytes are split over
+// several instances (RFC 3396); the end option is added.
+func (p *Packet4) Marshal() []byte {
+  b := make([]byte, bootpHeaderLen, bootpHeaderLen+64)
+  b[0] = byte(p.Op)
+  b[1] = byte(p.HType)
+  b[2] = byte(p.HLen)
+  b[3] = byte(p.Hops)
+  binary.BigEndian.PutUint32(b[4:], p.XID)
+  binary.BigEndian.PutUint16(b[8:], p.Secs)
+  binary.BigEndian.PutUint16(b[10:], p.Flags)
+  putAddr4(b[12:16], p.CIAddr)
+  putAddr4(b[16:20], p.YIAddr)
+  putAddr4(b[20:24], p.SIAddr)
+  putAddr4(b[24:28], p.GIAddr)
+  copy(b[28:44], p.CHAddr)
+  copy(b[44:108], p.SName)
+  copy(b[108:236], p.File)
+
+  b = append(b, MagicCookie...)
+  b = AppendOptions4(b, p.Options)
+  b = append(b, optEnd4)
+  // Some relays and servers drop packets shorter than a BOOTP packet.
+  for len(b) < 300 {
+    b = append(b, optPad4)
+  }
+  return b
+}
+
+// AppendOptions4 appends DHCPv4 options, or sub-options, to b.
+func AppendOptions4(b []byte, opts []Option) []byte {
+  for _, o := range opts {
+    data := o.Data
+    for {
+      n := min(len(data), 255)
+      b = append(b, byte(o.Code), byte(n))
+      b = append(b, data[:n]...)
+      data = data[n:]
+      if len(data) == 0 {
+        break
+      }
+    }
+  }
+  return b
+}
+
+// Marshal encodes p. A relay message carries p.Relayed as its relay-msg
+// option, after its other options.
+func (p *Packet6) Marshal() []byte {
+  var b []byte
+  if p.Type == RelayForw6 || p.Type == RelayRepl6 {
+    b = make([]byte, 34)
+    b[0], b[1] = byte(p.Type), byte(p.HopCount)
+    putAddr16(b[2:18], p.LinkAddr)
+    putAddr16(b[18:34], p.PeerAddr)
+    b = AppendOptions6(b, p.Options)
+    if p.Relayed != nil {
+      b = AppendOptions6(b, []Option{{Code: OptRelayMsg6, Data: p.Relayed.Marshal()}})
+    }
+    return b
+  }
+  b = []byte{byte(p.Type), byte(p.XID >> 16), byte(p.XID >> 8), byte(p.XID)}
+  return AppendOptions6(b, p.Options)
+}
+
+// AppendOptions6 appends DHCPv6 options to b.
+func AppendOptions6(b []byte, opts []Option) []byte {
+  for _, o := range opts {
+    b = binary.BigEndian.AppendUint16(b, uint16(o.Code))
+    b = binary.BigEndian.AppendUint16(b, uint16(len(o.Data)))
+    b = append(b, o.Data...)
+  }
+  return b
+}
+
+func putAddr4(b []byte, a netip.Addr) {
+  if a.Is4() || a.Is4In6() {
+    v := a.Unmap().As4()
+    copy(b, v[:])
+  }
+}
+
+func putAddr16(b []byte, a netip.Addr) {
+  if a.IsValid() {
+    v := a.As16()
+    copy(b, v[:])
+  }
+}
@@ -0,0 +1,459 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// DecodedOption is an option rendered for people: its Kea name and its
+// value as text, with encapsulated options in Sub.
+type DecodedOption struct {
+  Code  int
+  Name  string
+  Value string
+  Sub   []DecodedOption
+}
+
+// Relay agent information sub-options (option 82, RFC 3046 and later).
+var agentSubOptions = map[int]string{
+  1:   "circuit-id",
+  2:   "remote-id",
+  4:   "docsis-device-class",
+  5:   "link-selection",
+  6:   "subscriber-id",
+  7:   "radius-attributes",
+  8:   "authentication",
+  9:   "vendor-specific",
+  10:  "relay-flags",
+  11:  "server-id-override",
+  12:  "relay-id",
+  19:  "relay-source-port",
+  151: "vrf-name",
+  152: "relay-agent-flags",
+}
+
+// DUID types (RFC 8415 section 11).
+var duidTypes = map[int]string{
+  1: "DUID-LLT",
+  2: "DUID-EN",
+  3: "DUID-LL",
+  4: "DUID-UUID",
+}
+
+// DecodeOptions decodes the options of a family 4 or 6 packet.
+func DecodeOptions(family int, opts []Option) []DecodedOption {
+  out := make([]DecodedOption, 0, len(opts))
+  for _, o := range opts {
+    out = append(out, DecodeOption(family, o))
+  }
+  return out
+}
+
+// DecodeOption renders one option. Standard options are decoded by the
+// type Kea gives them; a few structured ones get their own decoder and
+// anything else is shown as hex.
+func DecodeOption(family int, o Option) DecodedOption {
+  d := DecodedOption{Code: o.Code, Name: kea.OptionName(family, o.Code)}
+  b := o.Data
+  if family == 6 {
+    if decodeOption6(&d, b) {
+      return d
+    }
+  } else if decodeOption4(&d, b) {
+    return d
+  }
+  def, ok := kea.StdOption(family, o.Code)
+  if !ok {
+    d.Value = printable(b)
+    return d
+  }
+  d.Value = decodeByType(def, b)
+  return d
+}
+
+func decodeOption4(d *DecodedOption, b []byte) bool {
+  switch d.Code {
+  case OptMessageType4:
+    if len(b) == 1 {
+      d.Value = MessageTypeName4(int(b[0]))
+      return true
+    }
+  case 15:
+    // Declared as fqdn, but sent as plain text.
+    d.Value = printable(b)
+    return true
+  case 55:
+    d.Value = optionList(4, b, 1)
+    return true
+  case OptClientID4:
+    if len(b) == 7 && b[0] == 1 {
+      d.Value = "hw " + net.HardwareAddr(b[1:]).String()
+    } else {
+      d.Value = HexString(b)
+    }
+    return true
+  case 81:
+    if len(b) >= 3 {
+      name := b[3:]
+      if b[0]&0x04 != 0 {
+        if n, ok := wireName(name); ok {
+          d.Value = fmt.Sprintf("flags %#02x %s", b[0], n)
+          return true
+        }
+      }
+      d.Value = fmt.Sprintf("flags %#02x %s", b[0], printable(name))
+      return true
+    }
+  case OptRelayAgentInfo4:
+    d.Sub = subOptions4(b, agentSubOptions)
+    d.Value = fmt.Sprintf("%d sub-options", len(d.Sub))
+    return true
+  case 121:
+    if v, ok := classlessRoutes(b); ok {
+      d.Value = v
+      return true
+    }
+  }
+  return false
+}
+
+func decodeOption6(d *DecodedOption, b []byte) bool {
+  switch d.Code {
+  case OptClientID6, OptServerID6:
+    d.Value = FormatDUID(b)
+    return true
+  case OptIANA6, OptIAPD6:
+    if len(b) >= 12 {
+      d.Value = fmt.Sprintf("IAID %d T1 %d T2 %d", binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:]))
+      d.Sub = subOptions6(b[12:])
+      return true
+    }
+  case OptIATA6:
+    if len(b) >= 4 {
+      d.Value = fmt.Sprintf("IAID %d", binary.BigEndian.Uint32(b))
+      d.Sub = subOptions6(b[4:])
+      return true
+    }
+  case OptIAAddr6:
+    if len(b) >= 24 {
+      d.Value = fmt.Sprintf("%s preferred %d valid %d", netip.AddrFrom16([16]byte(b[:16])), binary.BigEndian.Uint32(b[16:]), binary.BigEndian.Uint32(b[20:]))
+      d.Sub = subOptions6(b[24:])
+      return true
+    }
+  case OptIAPrefix6:
+    if len(b) >= 25 {
+      p := netip.PrefixFrom(netip.AddrFrom16([16]byte(b[9:25])), int(b[8]))
+      d.Value = fmt.Sprintf("%s preferred %d valid %d", p, binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:]))
+      d.Sub = subOptions6(b[25:])
+      return true
+    }
+  case 6, 43:
+    d.Value = optionList(6, b, 2)
+    return true
+  case 8:
+    if len(b) == 2 {
+      d.Value = fmt.Sprintf("%.2fs", float64(binary.BigEndian.Uint16(b))/100)
+      return true
+    }
+  case OptRelayMsg6:
+    if len(b) > 0 {
+      d.Value = fmt.Sprintf("%s (%d bytes)", MessageTypeName6(int(b[0])), len(b))
+      return true
+    }
+  case OptStatusCode6:
+    if len(b) >= 2 {
+      d.Value = StatusName(int(binary.BigEndian.Uint16(b)))
+      if len(b) > 2 {
+        d.Value += ": " + string(b[2:])
+      }
+      return true
+    }
+  case 16:
+    if len(b) >= 4 {
+      d.Value = fmt.Sprintf("enterprise %d %s", binary.BigEndian.Uint32(b), strings.Join(tuples(b[4:]), ", "))
+      return true
+    }
+  case 17:
+    if len(b) >= 4 {
+      d.Value = fmt.Sprintf("enterprise %d", binary.BigEndian.Uint32(b))
+      opts, _ := ParseOptions6(b[4:])
+      for _, o := range opts {
+        d.Sub = append(d.Sub, DecodedOption{Code: o.Code, Name: "suboption-" + strconv.Itoa(o.Code), Value: printable(o.Data)})
+      }
+      return true
+    }
+  case 18:
+    d.Value = printable(b)
+    return true
+  case 37:
+    if len(b) >= 4 {
+      d.Value = fmt.Sprintf("enterprise %d %s", binary.BigEndian.Uint32(b), printable(b[4:]))
+      return true
+    }
+  case 39:
+    if len(b) >= 1 {
+      n, ok := wireName(b[1:])
+      if !ok {
+        n = printable(b[1:])
+      }
+      d.Value = fmt.Sprintf("flags %#02x %s", b[0], n)
+      return true
+    }
+  case 79:
+    if len(b) >= 2 {
+      d.Value = fmt.Sprintf("hwtype %d %s", binary.BigEndian.Uint16(b), net.HardwareAddr(b[2:]))
+      return true
+    }
+  }
+  return false
+}
+
+// decodeByType renders b as Kea's option type of def.
+func decodeByType(def kea.OptionDef, b []byte) string {
+  size := map[string]int{"ipv4-address": 4, "ipv6-address": 16, "uint8": 1, "uint16": 2, "uint32": 4, "int32": 4, "boolean": 1}[def.Type]
+  switch def.Type {
+  case "empty":
+    if len(b) == 0 {
+      return ""
+    }
+  case "string":
+    return printable(b)
+  case "fqdn":
+    var names []string
+    for rest := b; len(rest) > 0; {
+      n, used, ok := readWireName(rest)
+      if !ok {
+        return printable(b)
+      }
+      names = append(names, n)
+      rest = rest[used:]
+      if !def.Array {
+        break
+      }
+    }
+    return strings.Join(names, ", ")
+  }
+  if size == 0 || len(b) == 0 || len(b)%size != 0 || (!def.Array && len(b) != size) {
+    return HexString(b)
+  }
+
+  var vals []string
+  for i := 0; i < len(b); i += size {
+    v := b[i : i+size]
+    switch def.Type {
+    case "ipv4-address":
+      vals = append(vals, netip.AddrFrom4([4]byte(v)).String())
+    case "ipv6-address":
+      vals = append(vals, netip.AddrFrom16([16]byte(v)).String())
+    case "uint8":
+      vals = append(vals, strconv.Itoa(int(v[0])))
+    case "uint16":
+      vals = append(vals, strconv.Itoa(int(binary.BigEndian.Uint16(v))))
+    case "uint32":
+      vals = append(vals, strconv.FormatUint(uint64(binary.BigEndian.Uint32(v)), 10))
+    case "int32":
+      vals = append(vals, strconv.Itoa(int(int32(binary.BigEndian.Uint32(v)))))
+    case "boolean":
+      vals = append(vals, strconv.FormatBool(v[0] != 0))
+    }
+  }
+  return strings.Join(vals, ", ")
+}
+
+// optionList names the option codes of a request list; width is the
+// size of one code.
+func optionList(family int, b []byte, width int) string {
+  var names []string
+  for i := 0; i+width <= len(b); i += width {
+    code := int(b[i])
+    if width == 2 {
+      code = int(binary.BigEndian.Uint16(b[i:]))
+    }
+    names = append(names, kea.OptionName(family, code))
+  }
+  return strings.Join(names, ", ")
+}
+
+// subOptions4 decodes code/length/value sub-options.
+func subOptions4(b []byte, names map[int]string) []DecodedOption {
+  var out []DecodedOption
+  for i := 0; i+2 <= len(b); {
+    code, n := int(b[i]), int(b[i+1])
+    if i+2+n > len(b) {
+      break
+    }
+    name, ok := names[code]
+    if !ok {
+      name = "suboption-" + strconv.Itoa(code)
+    }
+    v := b[i+2 : i+2+n]
+    value := printable(v)
+    if (code == 5 || code == 11) && n == 4 {
+      value = netip.AddrFrom4([4]byte(v)).String()
+    }
+    out = append(out, DecodedOption{Code: code, Name: name, Value: value})
+    i += 2 + n
+  }
+  return out
+}
+
+// subOptions6 decodes the options encapsulated in an IA or address.
+func subOptions6(b []byte) []DecodedOption {
+  opts, _ := ParseOptions6(b)
+  return DecodeOptions(6, opts)
+}
+
+// classlessRoutes decodes option 121 (RFC 3442).
+func classlessRoutes(b []byte) (string, bool) {
+  var routes []string
+  for i := 0; i < len(b); {
+    bits := int(b[i])
+    n := (bits + 7) / 8
+    if bits > 32 || i+1+n+4 > len(b) {
+      return "", false
+    }
+    var dst [4]byte
+    copy(dst[:], b[i+1:i+1+n])
+    gw := netip.AddrFrom4([4]byte(b[i+1+n : i+1+n+4]))
+    routes = append(routes, fmt.Sprintf("%s via %s", netip.PrefixFrom(netip.AddrFrom4(dst), bits), gw))
+    i += 1 + n + 4
+  }
+  return strings.Join(routes, ", "), true
+}
+
+// tuples splits length-prefixed (uint16) opaque values.
+func tuples(b []byte) []string {
+  var out []string
+  for i := 0; i+2 <= len(b); {
+    n := int(binary.BigEndian.Uint16(b[i:]))
+    if i+2+n > len(b) {
+      break
+    }
+    out = append(out, printable(b[i+2:i+2+n]))
+    i += 2 + n
+  }
+  return out
+}
+
+// wireName decodes a whole buffer as one DNS name in wire format.
+func wireName(b []byte) (string, bool) {
+  n, used, ok := readWireName(b)
+  return n, ok && used == len(b)
+}
+
+// readWireName decodes one DNS name in wire format (no compression). A
+// partial name, without the terminating root label, is accepted at the
+// end of the buffer as some clients send those.
+func readWireName(b []byte) (string, int, bool) {
+  var labels []string
+  i := 0
+  for i < len(b) {
+    n := int(b[i])
+    if n == 0 {
+      return strings.Join(labels, ".") + ".", i + 1, true
+    }
+    if n > 63 || i+1+n > len(b) {
+      return "", 0, false
+    }
+    labels = append(labels, string(b[i+1:i+1+n]))
+    i += 1 + n
+  }
+  return strings.Join(labels, "."), i, len(labels) > 0
+}
+
+// FormatDUID renders a DUID as hex with its type and, for link-layer
+// DUIDs, the hardware address.
+func FormatDUID(b []byte) string {
+  if len(b) < 2 {
+    return HexString(b)
+  }
+  t := int(binary.BigEndian.Uint16(b))
+  name, ok := duidTypes[t]
+  if !ok {
+    return HexString(b)
+  }
+  switch {
+  case t == 1 && len(b) > 8:
+    return fmt.Sprintf("%s (%s %s)", HexString(b), name, net.HardwareAddr(b[8:]))
+  case t == 3 && len(b) > 4:
+    return fmt.Sprintf("%s (%s %s)", HexString(b), name, net.HardwareAddr(b[4:]))
+  case t == 2 && len(b) >= 6:
+    return fmt.Sprintf("%s (%s enterprise %d)", HexString(b), name, binary.BigEndian.Uint32(b[2:]))
+  }
+  return fmt.Sprintf("%s (%s)", HexString(b), name)
+}
+
+// HexString renders b as colon separated hex, the way Kea writes
+// identifiers.
+func HexString(b []byte) string {
+  if len(b) == 0 {
+    return ""
+  }
+  h := hex.EncodeToString(b)
+  var sb strings.Builder
+  for i := 0; i < len(h); i += 2 {
+    if i > 0 {
+      sb.WriteByte(':')
+    }
+    sb.WriteString(h[i : i+2])
+  }
+  return sb.String()
+}
+
+// ParseOption reads an option written as code=value or, unless numbered
+// is set (relay agent sub-options), name=value. The value is hex when
+// 0x-prefixed or colon separated, and text otherwise.
+func ParseOption(family int, numbered bool, s string) (Option, error) {
+  name, value, ok := strings.Cut(s, "=")
+  if !ok {
+    return Option{}, fmt.Errorf("%q is not code=value", s)
+  }
+  code, err := strconv.Atoi(strings.TrimSpace(name))
+  if err != nil {
+    def, found := kea.StdOptionByName(family, strings.TrimSpace(name))
+    if numbered || !found {
+      return Option{}, fmt.Errorf("unknown option %q", name)
+    }
+    code = def.Code
+  }
+  return Option{Code: code, Data: ParseOptionValue(value)}, nil
+}
+
+// ParseOptionValue reads 0x-prefixed or colon separated hex, and takes
+// anything else as text.
+func ParseOptionValue(s string) []byte {
+  h := s
+  if strings.HasPrefix(h, "0x") {
+    h = h[2:]
+  } else if !strings.Contains(h, ":") {
+    return []byte(s)
+  }
+  if b, err := hex.DecodeString(strings.ReplaceAll(h, ":", "")); err == nil {
+    return b
+  }
+  return []byte(s)
+}
+
+// printable quotes b when it is plain text and falls back to hex.
+func printable(b []byte) string {
+  if len(b) == 0 {
+    return ""
+  }
+  for i, c := range b {
+    if c == 0 && i == len(b)-1 {
+      b = b[:i]
+      break
+    }
+    if c < 0x20 || c > 0x7e {
+      return HexString(b)
+    }
+  }
+  return strconv.Quote(string(b))
+}
@@ -0,0 +1,263 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DHCPv4 message types (option 53).
+const (
+  Discover4 = 1
+  Offer4    = 2
+  Request4  = 3
+  Decline4  = 4
+  Ack4      = 5
+  Nak4      = 6
+  Release4  = 7
+  Inform4   = 8
+)
+
+var messageTypes4 = map[int]string{
+  1:  "DISCOVER",
+  2:  "OFFER",
+  3:  "REQUEST",
+  4:  "DECLINE",
+  5:  "ACK",
+  6:  "NAK",
+  7:  "RELEASE",
+  8:  "INFORM",
+  9:  "FORCERENEW",
+  10: "LEASEQUERY",
+  11: "LEASEUNASSIGNED",
+  12: "LEASEUNKNOWN",
+  13: "LEASEACTIVE",
+  14: "BULKLEASEQUERY",
+  15: "LEASEQUERYDONE",
+  16: "ACTIVELEASEQUERY",
+  17: "LEASEQUERYSTATUS",
+  18: "TLS",
+}
+
+// MessageTypeName4 names a DHCPv4 message type.
+func MessageTypeName4(t int) string {
+  if n, ok := messageTypes4[t]; ok {
+    return n
+  }
+  if t == 0 {
+    return "BOOTP"
+  }
+  return fmt.Sprintf("TYPE-%d", t)
+}
+
+// Well-known DHCPv4 option codes.
+const (
+  OptRequestedAddress4 = 50
+  OptOverload4         = 52
+  OptMessageType4      = 53
+  OptServerID4         = 54
+  OptClientID4         = 61
+  OptRelayAgentInfo4   = 82
+  optPad4              = 0
+  optEnd4              = 255
+)
+
+// MagicCookie starts the options of a DHCPv4 packet.
+var MagicCookie = []byte{99, 130, 83, 99}
+
+const (
+  // bootpHeaderLen is the fixed part of a BOOTP/DHCPv4 packet up to the
+  // magic cookie.
+  bootpHeaderLen = 236
+  BootRequest    = 1
+  BootReply      = 2
+)
+
+// Option is one option as found on the wire.
+type Option struct {
+  Code int
+  Data []byte
+}
+
+// Packet4 is a decoded DHCPv4 (or BOOTP) packet.
+type Packet4 struct {
+  Op     int
+  HType  int
+  HLen   int
+  Hops   int
+  XID    uint32
+  Secs   uint16
+  Flags  uint16
+  CIAddr netip.Addr
+  YIAddr netip.Addr
+  SIAddr netip.Addr
+  GIAddr netip.Addr
+  CHAddr net.HardwareAddr
+  SName  string
+  File   string
+  // Options are in wire order, with overloaded sname/file options
+  // appended.
+  Options []Option
+}
+
+// ErrShortPacket is returned for packets cut before their fixed header
+// ends.
+var ErrShortPacket = errors.New("packet too short")
+
+// ParsePacket4 decodes a DHCPv4 packet. A packet without the magic
+// cookie is taken as plain BOOTP.
+func ParsePacket4(b []byte) (*Packet4, error) {
+  if len(b) < bootpHeaderLen {
+    return nil, ErrShortPacket
+  }
+  p := &Packet4{
+    Op:     int(b[0]),
+    HType:  int(b[1]),
+    HLen:   int(b[2]),
+    Hops:   int(b[3]),
+    XID:    binary.BigEndian.Uint32(b[4:8]),
+    Secs:   binary.BigEndian.Uint16(b[8:10]),
+    Flags:  binary.BigEndian.Uint16(b[10:12]),
+    CIAddr: addr4(b[12:16]),
+    YIAddr: addr4(b[16:20]),
+    SIAddr: addr4(b[20:24]),
+    GIAddr: addr4(b[24:28]),
+  }
+  if p.Op != BootRequest && p.Op != BootReply {
+    return nil, fmt.Errorf("bad op %d", p.Op)
+  }
+  hlen := p.HLen
+  if hlen > 16 {
+    hlen = 16
+  }
+  p.CHAddr = net.HardwareAddr(bytes.Clone(b[28 : 28+hlen]))
+  sname, file := b[44:108], b[108:236]
+
+  if len(b) < bootpHeaderLen+4 || !bytes.Equal(b[236:240], MagicCookie) {
+    p.SName, p.File = cString(sname), cString(file)
+    return p, nil
+  }
+  opts, err := parseOptions4(b[240:])
+  if err != nil {
+    return p, err
+  }
+  p.Options = opts
+
+  overload := 0
+  if o := p.Option(OptOverload4); len(o) == 1 {
+    overload = int(o[0])
+  }
+  if overload&1 != 0 {
+    more, err := parseOptions4(file)
+    if err != nil {
+      return p, fmt.Errorf("file field options: %w", err)
+    }
+    p.Options = append(p.Options, more...)
+  } else {
+    p.File = cString(file)
+  }
+  if overload&2 != 0 {
+    more, err := parseOptions4(sname)
+    if err != nil {
+      return p, fmt.Errorf("sname field options: %w", err)
+    }
+    p.Options = append(p.Options, more...)
+  } else {
+    p.SName = cString(sname)
+  }
+  return p, nil
+}
+
+// parseOptions4 reads code/length/value options up to the end option.
+// Options split over several instances (RFC 3396) are concatenated.
+func parseOptions4(b []byte) ([]Option, error) {
+  var out []Option
+  index := map[int]int{}
+  for i := 0; i < len(b); {
+    code := int(b[i])
+    switch code {
+    case optPad4:
+      i++
+      continue
+    case optEnd4:
+      return out, nil
+    }
+    if i+1 >= len(b) {
+      return out, fmt.Errorf("option %d: missing length", code)
+    }
+    n := int(b[i+1])
+    if i+2+n > len(b) {
+      return out, fmt.Errorf("option %d: length %d overruns the packet", code, n)
+    }
+    data := b[i+2 : i+2+n]
+    if j, ok := index[code]; ok {
+      out[j].Data = append(out[j].Data, data...)
+    } else {
+      index[code] = len(out)
+      out = append(out, Option{Code: code, Data: bytes.Clone(data)})
+    }
+    i += 2 + n
+  }
+  // A missing end option is common enough to tolerate.
+  return out, nil
+}
+
+// ParseOptions4 reads DHCPv4 options or sub-options, such as those of
+// option 82.
+func ParseOptions4(b []byte) ([]Option, error) {
+  return parseOptions4(b)
+}
+
+// Option returns the data of the first option with code, or nil.
+func (p *Packet4) Option(code int) []byte {
+  for _, o := range p.Options {
+    if o.Code == code {
+      return o.Data
+    }
+  }
+  return nil
+}
+
+// MessageType returns option 53, or 0 for BOOTP.
+func (p *Packet4) MessageType() int {
+  if o := p.Option(OptMessageType4); len(o) == 1 {
+    return int(o[0])
+  }
+  return 0
+}
+
+// ServerID returns option 54.
+func (p *Packet4) ServerID() netip.Addr {
+  if o := p.Option(OptServerID4); len(o) == 4 {
+    return addr4(o)
+  }
+  return netip.Addr{}
+}
+
+// RequestedAddress returns option 50.
+func (p *Packet4) RequestedAddress() netip.Addr {
+  if o := p.Option(OptRequestedAddress4); len(o) == 4 {
+    return addr4(o)
+  }
+  return netip.Addr{}
+}
+
+// Broadcast reports whether the client asked for broadcast replies.
+func (p *Packet4) Broadcast() bool {
+  return p.Flags&0x8000 != 0
+}
+
+func addr4(b []byte) netip.Addr {
+  return netip.AddrFrom4([4]byte(b[:4]))
+}
+
+// cString returns b up to its first NUL.
+func cString(b []byte) string {
+  if i := bytes.IndexByte(b, 0); i >= 0 {
+    b = b[:i]
+  }
+  return string(b)
+}
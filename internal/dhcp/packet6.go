@@ -0,0 +1,270 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// DHCPv6 message types.
+const (
+  Solicit6            = 1
+  Advertise6          = 2
+  Request6            = 3
+  Confirm6            = 4
+  Renew6              = 5
+  Rebind6             = 6
+  Reply6              = 7
+  Release6            = 8
+  Decline6            = 9
+  Reconfigure6        = 10
+  InformationRequest6 = 11
+  RelayForw6          = 12
+  RelayRepl6          = 13
+)
+
+var messageTypes6 = map[int]string{
+  1:  "SOLICIT",
+  2:  "ADVERTISE",
+  3:  "REQUEST",
+  4:  "CONFIRM",
+  5:  "RENEW",
+  6:  "REBIND",
+  7:  "REPLY",
+  8:  "RELEASE",
+  9:  "DECLINE",
+  10: "RECONFIGURE",
+  11: "INFORMATION-REQUEST",
+  12: "RELAY-FORW",
+  13: "RELAY-REPL",
+  14: "LEASEQUERY",
+  15: "LEASEQUERY-REPLY",
+  16: "LEASEQUERY-DONE",
+  17: "LEASEQUERY-DATA",
+  18: "RECONFIGURE-REQUEST",
+  19: "RECONFIGURE-REPLY",
+  20: "DHCPV4-QUERY",
+  21: "DHCPV4-RESPONSE",
+}
+
+// MessageTypeName6 names a DHCPv6 message type.
+func MessageTypeName6(t int) string {
+  if n, ok := messageTypes6[t]; ok {
+    return n
+  }
+  return fmt.Sprintf("TYPE-%d", t)
+}
+
+// Well-known DHCPv6 option codes.
+const (
+  OptClientID6   = 1
+  OptServerID6   = 2
+  OptIANA6       = 3
+  OptIATA6       = 4
+  OptIAAddr6     = 5
+  OptRelayMsg6   = 9
+  OptStatusCode6 = 13
+  OptIAPD6       = 25
+  OptIAPrefix6   = 26
+)
+
+// DHCPv6 status codes.
+const (
+  StatusSuccess       = 0
+  StatusUnspecFail    = 1
+  StatusNoAddrsAvail  = 2
+  StatusNoBinding     = 3
+  StatusNotOnLink     = 4
+  StatusUseMulticast  = 5
+  StatusNoPrefixAvail = 6
+)
+
+var statusCodes6 = map[int]string{
+  0: "Success",
+  1: "UnspecFail",
+  2: "NoAddrsAvail",
+  3: "NoBinding",
+  4: "NotOnLink",
+  5: "UseMulticast",
+  6: "NoPrefixAvail",
+}
+
+// StatusName names a DHCPv6 status code.
+func StatusName(code int) string {
+  if n, ok := statusCodes6[code]; ok {
+    return n
+  }
+  return fmt.Sprintf("status-%d", code)
+}
+
+// Packet6 is a decoded DHCPv6 message. Relay messages carry the hop
+// count and addresses and wrap the relayed message in Relayed.
+type Packet6 struct {
+  Type    int
+  XID     uint32
+  Options []Option
+
+  HopCount int
+  LinkAddr netip.Addr
+  PeerAddr netip.Addr
+  Relayed  *Packet6
+}
+
+// maxRelayDepth bounds nested relay messages (RFC 8415 HOP_COUNT_LIMIT).
+const maxRelayDepth = 32
+
+// ParsePacket6 decodes a DHCPv6 message, unwrapping relay messages.
+func ParsePacket6(b []byte) (*Packet6, error) {
+  return parsePacket6(b, 0)
+}
+
+func parsePacket6(b []byte, depth int) (*Packet6, error) {
+  if len(b) < 4 {
+    return nil, ErrShortPacket
+  }
+  p := &Packet6{Type: int(b[0])}
+  body := b[4:]
+  if p.Type == RelayForw6 || p.Type == RelayRepl6 {
+    if len(b) < 34 {
+      return nil, ErrShortPacket
+    }
+    p.HopCount = int(b[1])
+    p.LinkAddr = netip.AddrFrom16([16]byte(b[2:18]))
+    p.PeerAddr = netip.AddrFrom16([16]byte(b[18:34]))
+    body = b[34:]
+  } else {
+    p.XID = uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+  }
+
+  opts, err := ParseOptions6(body)
+  p.Options = opts
+  if err != nil {
+    return p, err
+  }
+  if p.Type == RelayForw6 || p.Type == RelayRepl6 {
+    msg := p.Option(OptRelayMsg6)
+    if msg == nil {
+      return p, fmt.Errorf("%s without relay-msg", MessageTypeName6(p.Type))
+    }
+    if depth >= maxRelayDepth {
+      return p, fmt.Errorf("relay messages nested too deeply")
+    }
+    p.Relayed, err = parsePacket6(msg, depth+1)
+  }
+  return p, err
+}
+
+// ParseOptions6 reads a sequence of DHCPv6 options.
+func ParseOptions6(b []byte) ([]Option, error) {
+  var out []Option
+  for i := 0; i < len(b); {
+    if i+4 > len(b) {
+      return out, fmt.Errorf("truncated option header")
+    }
+    code := int(binary.BigEndian.Uint16(b[i:]))
+    n := int(binary.BigEndian.Uint16(b[i+2:]))
+    if i+4+n > len(b) {
+      return out, fmt.Errorf("option %d: length %d overruns the message", code, n)
+    }
+    out = append(out, Option{Code: code, Data: bytes.Clone(b[i+4 : i+4+n])})
+    i += 4 + n
+  }
+  return out, nil
+}
+
+// Option returns the data of the first option with code, or nil.
+func (p *Packet6) Option(code int) []byte {
+  for _, o := range p.Options {
+    if o.Code == code {
+      return o.Data
+    }
+  }
+  return nil
+}
+
+// Inner returns the innermost relayed message, or p itself.
+func (p *Packet6) Inner() *Packet6 {
+  for p.Relayed != nil {
+    p = p.Relayed
+  }
+  return p
+}
+
+// Relays returns the relay layers from the outermost in.
+func (p *Packet6) Relays() []*Packet6 {
+  var out []*Packet6
+  for ; p.Relayed != nil; p = p.Relayed {
+    out = append(out, p)
+  }
+  return out
+}
+
+// Lease6 is an address or prefix held in an IA option.
+type Lease6 struct {
+  IAID      uint32
+  Prefix    netip.Prefix // /128 for addresses
+  Preferred uint32
+  Valid     uint32
+  Status    int
+}
+
+// Leases returns the addresses and prefixes of the IA_NA, IA_TA and
+// IA_PD options, and the status codes found at the top level and in the
+// IAs (Success when there are none).
+func (p *Packet6) Leases() ([]Lease6, []int) {
+  var leases []Lease6
+  var statuses []int
+  if o := p.Option(OptStatusCode6); len(o) >= 2 {
+    statuses = append(statuses, int(binary.BigEndian.Uint16(o)))
+  }
+  for _, o := range p.Options {
+    var sub []byte
+    var iaid uint32
+    switch {
+    case (o.Code == OptIANA6 || o.Code == OptIAPD6) && len(o.Data) >= 12:
+      iaid, sub = binary.BigEndian.Uint32(o.Data), o.Data[12:]
+    case o.Code == OptIATA6 && len(o.Data) >= 4:
+      iaid, sub = binary.BigEndian.Uint32(o.Data), o.Data[4:]
+    default:
+      continue
+    }
+    opts, _ := ParseOptions6(sub)
+    for _, s := range opts {
+      switch {
+      case s.Code == OptStatusCode6 && len(s.Data) >= 2:
+        statuses = append(statuses, int(binary.BigEndian.Uint16(s.Data)))
+      case s.Code == OptIAAddr6 && len(s.Data) >= 24:
+        a := netip.AddrFrom16([16]byte(s.Data[:16]))
+        leases = append(leases, Lease6{
+          IAID:      iaid,
+          Prefix:    netip.PrefixFrom(a, 128),
+          Preferred: binary.BigEndian.Uint32(s.Data[16:]),
+          Valid:     binary.BigEndian.Uint32(s.Data[20:]),
+          Status:    subStatus(s.Data[24:]),
+        })
+      case s.Code == OptIAPrefix6 && len(s.Data) >= 25:
+        a := netip.AddrFrom16([16]byte(s.Data[9:25]))
+        leases = append(leases, Lease6{
+          IAID:      iaid,
+          Prefix:    netip.PrefixFrom(a, int(s.Data[8])),
+          Preferred: binary.BigEndian.Uint32(s.Data[0:]),
+          Valid:     binary.BigEndian.Uint32(s.Data[4:]),
+          Status:    subStatus(s.Data[25:]),
+        })
+      }
+    }
+  }
+  return leases, statuses
+}
+
+// subStatus returns the status code option among b's options.
+func subStatus(b []byte) int {
+  opts, _ := ParseOptions6(b)
+  for _, o := range opts {
+    if o.Code == OptStatusCode6 && len(o.Data) >= 2 {
+      return int(binary.BigEndian.Uint16(o.Data))
+    }
+  }
+  return StatusSuccess
+}
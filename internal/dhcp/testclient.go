@@ -0,0 +1,440 @@
+package dhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Option codes the test client sends.
+const (
+  optRequestList4     = 55
+  optVendorClass4     = 60
+  optORO6             = 6
+  optElapsedTime6     = 8
+  optVendorClass6     = 16
+  optRelaySourcePort6 = 135
+  // agentSourcePort4 is the relay source port sub-option of option 82
+  // (RFC 8357): the server answers to the relay's UDP source port.
+  agentSourcePort4 = 19
+)
+
+// Default ports.
+const (
+  ServerPort4 = 67
+  ServerPort6 = 547
+)
+
+// ErrNoReply is returned when the server didn't answer an exchange.
+var ErrNoReply = errors.New("no reply from the server")
+
+// TestClient plays a DHCP client behind a relay agent. Speaking as a
+// relay lets it run from any host and, with the relay source port
+// option, on an unprivileged port: the server sends its replies back to
+// the socket they came from instead of broadcasting them on a link.
+type TestClient struct {
+  Family int
+  // Server is where messages are sent.
+  Server netip.AddrPort
+  // Local is the address to bind; port 0 picks a free port.
+  Local netip.AddrPort
+  MAC   net.HardwareAddr
+  // ClientID is option 61 (DHCPv4) or the DUID (DHCPv6, a DUID-LL of
+  // MAC by default).
+  ClientID []byte
+  // RelayAddr is the giaddr (DHCPv4) or link-address (DHCPv6) the server
+  // selects a subnet by. It defaults to the local address. DHCPv4
+  // replies are sent to the giaddr, so it must be an address of this
+  // host.
+  RelayAddr netip.Addr
+  // RelayInfo are option 82 sub-options (DHCPv4) or options of the
+  // relay-forward message (DHCPv6), such as interface-id.
+  RelayInfo   []Option
+  VendorClass string
+  // Enterprise is the enterprise number of the DHCPv6 vendor class.
+  Enterprise uint32
+  // Options are sent in every client message.
+  Options []Option
+  // Prefix asks for a delegated prefix too (DHCPv6).
+  Prefix bool
+  // NoRequest stops after the offer or advertise.
+  NoRequest bool
+  // Release gives the lease back at the end.
+  Release bool
+  Timeout time.Duration
+  Retries int
+}
+
+// TestStep is one exchange with the server.
+type TestStep struct {
+  Sent     string
+  Received string
+  From     netip.AddrPort
+  Tries    int
+  RTT      time.Duration
+  Options  []DecodedOption
+  // RelayOptions are the options the server returned to the relay:
+  // option 82 or the relay-reply options.
+  RelayOptions []DecodedOption
+  Err          error
+}
+
+// TestResult is what the server handed out.
+type TestResult struct {
+  Family   int
+  Steps    []TestStep
+  Server   string
+  Address  netip.Addr
+  Leases   []Lease6
+  Statuses []string
+  // Outcome is the last message received, or "no reply".
+  Outcome string
+  Total   time.Duration
+}
+
+// testConn is a bound socket and the addresses the messages carry.
+type testConn struct {
+  conn  *net.UDPConn
+  relay netip.Addr
+  // sourcePort tells whether the relay source port option is needed.
+  sourcePort bool
+}
+
+func (c *TestClient) dial() (*testConn, error) {
+  network, serverPort := "udp4", ServerPort4
+  if c.Family == 6 {
+    network, serverPort = "udp6", ServerPort6
+  }
+  conn, err := net.ListenUDP(network, net.UDPAddrFromAddrPort(c.Local))
+  if err != nil {
+    return nil, err
+  }
+  local := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+  tc := &testConn{conn: conn, relay: c.RelayAddr, sourcePort: local.Port() != uint16(serverPort)}
+
+  if !tc.relay.IsValid() {
+    tc.relay = local.Addr().Unmap()
+    if tc.relay.IsUnspecified() {
+      // Find the address the server is routed through without sending.
+      probe, err := net.DialUDP(network, nil, net.UDPAddrFromAddrPort(c.Server))
+      if err != nil {
+        conn.Close()
+        return nil, err
+      }
+      tc.relay = probe.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+      probe.Close()
+    }
+  }
+  return tc, nil
+}
+
+// exchange sends b until a reply matches or the retries run out.
+func (c *TestClient) exchange(ctx context.Context, tc *testConn, b []byte, match func([]byte) bool) ([]byte, netip.AddrPort, int, time.Duration, error) {
+  buf := make([]byte, 65536)
+  for try := 1; try <= c.Retries+1; try++ {
+    if err := ctx.Err(); err != nil {
+      return nil, netip.AddrPort{}, try - 1, 0, err
+    }
+    start := time.Now()
+    if _, err := tc.conn.WriteToUDPAddrPort(b, c.Server); err != nil {
+      return nil, netip.AddrPort{}, try, 0, err
+    }
+    deadline := start.Add(c.Timeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+      deadline = d
+    }
+    tc.conn.SetReadDeadline(deadline)
+    for {
+      n, from, err := tc.conn.ReadFromUDPAddrPort(buf)
+      if err != nil {
+        var ne net.Error
+        if errors.As(err, &ne) && ne.Timeout() {
+          break
+        }
+        return nil, netip.AddrPort{}, try, 0, err
+      }
+      if match(buf[:n]) {
+        return append([]byte(nil), buf[:n]...), from, try, time.Since(start), nil
+      }
+    }
+  }
+  return nil, netip.AddrPort{}, c.Retries + 1, 0, ErrNoReply
+}
+
+// Run performs the exchanges of c's family.
+func (c *TestClient) Run(ctx context.Context) (*TestResult, error) {
+  if c.Timeout <= 0 {
+    c.Timeout = 3 * time.Second
+  }
+  if len(c.MAC) == 0 {
+    return nil, errors.New("a MAC address is required")
+  }
+  tc, err := c.dial()
+  if err != nil {
+    return nil, err
+  }
+  defer tc.conn.Close()
+
+  res := &TestResult{Family: c.Family, Outcome: "no reply"}
+  start := time.Now()
+  if c.Family == 6 {
+    err = c.run6(ctx, tc, res)
+  } else {
+    err = c.run4(ctx, tc, res)
+  }
+  res.Total = time.Since(start)
+  return res, err
+}
+
+func (c *TestClient) run4(ctx context.Context, tc *testConn, res *TestResult) error {
+  xid := rand.Uint32()
+  start := time.Now()
+
+  base := func(msgType int) *Packet4 {
+    p := &Packet4{
+      Op:     BootRequest,
+      HType:  1,
+      HLen:   len(c.MAC),
+      Hops:   1,
+      XID:    xid,
+      Secs:   uint16(time.Since(start).Seconds()),
+      GIAddr: tc.relay,
+      CHAddr: c.MAC,
+    }
+    if len(c.MAC) != 6 {
+      p.HType = 0
+    }
+    p.Options = []Option{{Code: OptMessageType4, Data: []byte{byte(msgType)}}}
+    if len(c.ClientID) > 0 {
+      p.Options = append(p.Options, Option{Code: OptClientID4, Data: c.ClientID})
+    }
+    if c.VendorClass != "" {
+      p.Options = append(p.Options, Option{Code: optVendorClass4, Data: []byte(c.VendorClass)})
+    }
+    p.Options = append(p.Options, Option{Code: optRequestList4, Data: []byte{1, 3, 6, 15, 26, 28, 42, 51, 54, 58, 59, 119, 121}})
+    p.Options = append(p.Options, c.Options...)
+    return p
+  }
+  relayInfo := func(p *Packet4) {
+    sub := c.RelayInfo
+    if tc.sourcePort {
+      sub = append(append([]Option(nil), sub...), Option{Code: agentSourcePort4})
+    }
+    if len(sub) > 0 {
+      // Option 82 must come last.
+      p.Options = append(p.Options, Option{Code: OptRelayAgentInfo4, Data: AppendOptions4(nil, sub)})
+    }
+  }
+  send := func(p *Packet4) (*Packet4, error) {
+    relayInfo(p)
+    step := TestStep{Sent: MessageTypeName4(p.MessageType())}
+    b, from, tries, rtt, err := c.exchange(ctx, tc, p.Marshal(), func(b []byte) bool {
+      r, err := ParsePacket4(b)
+      return err == nil && r.Op == BootReply && r.XID == xid
+    })
+    step.From, step.Tries, step.RTT, step.Err = from, tries, rtt, err
+    var reply *Packet4
+    if err == nil {
+      reply, _ = ParsePacket4(b)
+      step.Received = MessageTypeName4(reply.MessageType())
+      res.Outcome = step.Received
+      for _, o := range reply.Options {
+        if o.Code == OptRelayAgentInfo4 {
+          step.RelayOptions = append(step.RelayOptions, DecodeOption(4, o))
+        } else {
+          step.Options = append(step.Options, DecodeOption(4, o))
+        }
+      }
+    }
+    res.Steps = append(res.Steps, step)
+    return reply, err
+  }
+
+  offer, err := send(base(Discover4))
+  if err != nil {
+    return err
+  }
+  if offer.MessageType() != Offer4 {
+    return nil
+  }
+  res.Address, res.Server = offer.YIAddr, offer.ServerID().String()
+  if c.NoRequest {
+    return nil
+  }
+
+  req := base(Request4)
+  req.Options = append(req.Options,
+    Option{Code: OptRequestedAddress4, Data: offer.YIAddr.AsSlice()},
+    Option{Code: OptServerID4, Data: offer.ServerID().AsSlice()},
+  )
+  ack, err := send(req)
+  if err != nil {
+    return err
+  }
+  if ack.MessageType() != Ack4 {
+    return nil
+  }
+  res.Address = ack.YIAddr
+  if !c.Release {
+    return nil
+  }
+
+  // DHCPRELEASE has no answer.
+  rel := base(Release4)
+  rel.CIAddr = ack.YIAddr
+  rel.Options = append(rel.Options, Option{Code: OptServerID4, Data: ack.ServerID().AsSlice()})
+  relayInfo(rel)
+  _, err = tc.conn.WriteToUDPAddrPort(rel.Marshal(), c.Server)
+  res.Steps = append(res.Steps, TestStep{Sent: "RELEASE", Tries: 1, Err: err})
+  return err
+}
+
+func (c *TestClient) run6(ctx context.Context, tc *testConn, res *TestResult) error {
+  xid := rand.Uint32() & 0xffffff
+  start := time.Now()
+  duid := c.ClientID
+  if len(duid) == 0 {
+    duid = append([]byte{0, 3, 0, 1}, c.MAC...)
+  }
+  iaid := binary.BigEndian.Uint32(append(make([]byte, 4), c.MAC...)[len(c.MAC):])
+
+  base := func(msgType int, ias []Option) *Packet6 {
+    elapsed := min(time.Since(start).Milliseconds()/10, 0xffff)
+    p := &Packet6{Type: msgType, XID: xid}
+    p.Options = []Option{
+      {Code: OptClientID6, Data: duid},
+      {Code: optElapsedTime6, Data: binary.BigEndian.AppendUint16(nil, uint16(elapsed))},
+      {Code: optORO6, Data: []byte{0, 23, 0, 24, 0, 31, 0, 82}},
+    }
+    if c.VendorClass != "" {
+      v := binary.BigEndian.AppendUint32(nil, c.Enterprise)
+      v = binary.BigEndian.AppendUint16(v, uint16(len(c.VendorClass)))
+      p.Options = append(p.Options, Option{Code: optVendorClass6, Data: append(v, c.VendorClass...)})
+    }
+    p.Options = append(p.Options, ias...)
+    p.Options = append(p.Options, c.Options...)
+    return p
+  }
+  relay := func(p *Packet6) *Packet6 {
+    r := &Packet6{
+      Type:     RelayForw6,
+      LinkAddr: tc.relay,
+      PeerAddr: linkLocal(c.MAC),
+      Relayed:  p,
+      Options:  append([]Option(nil), c.RelayInfo...),
+    }
+    if tc.sourcePort {
+      r.Options = append(r.Options, Option{Code: optRelaySourcePort6, Data: []byte{0, 0}})
+    }
+    return r
+  }
+  send := func(p *Packet6) (*Packet6, error) {
+    step := TestStep{Sent: MessageTypeName6(p.Type)}
+    b, from, tries, rtt, err := c.exchange(ctx, tc, relay(p).Marshal(), func(b []byte) bool {
+      r, err := ParsePacket6(b)
+      return err == nil && r.Type == RelayRepl6 && r.Inner().XID == xid
+    })
+    step.From, step.Tries, step.RTT, step.Err = from, tries, rtt, err
+    var reply *Packet6
+    if err == nil {
+      outer, _ := ParsePacket6(b)
+      reply = outer.Inner()
+      step.Received = MessageTypeName6(reply.Type)
+      res.Outcome = step.Received
+      step.Options = DecodeOptions(6, reply.Options)
+      for _, o := range outer.Options {
+        if o.Code != OptRelayMsg6 {
+          step.RelayOptions = append(step.RelayOptions, DecodeOption(6, o))
+        }
+      }
+    }
+    res.Steps = append(res.Steps, step)
+    return reply, err
+  }
+  collect := func(p *Packet6) {
+    leases, statuses := p.Leases()
+    res.Leases, res.Statuses = leases, nil
+    for _, s := range statuses {
+      if s != StatusSuccess {
+        res.Statuses = append(res.Statuses, StatusName(s))
+      }
+    }
+    for _, l := range leases {
+      if l.Prefix.Bits() == 128 && l.Status == StatusSuccess {
+        res.Address = l.Prefix.Addr()
+        break
+      }
+    }
+  }
+
+  ias := []Option{{Code: OptIANA6, Data: binary.BigEndian.AppendUint32(make([]byte, 0, 12), iaid)}}
+  ias[0].Data = append(ias[0].Data, make([]byte, 8)...)
+  if c.Prefix {
+    pd := binary.BigEndian.AppendUint32(make([]byte, 0, 12), iaid)
+    ias = append(ias, Option{Code: OptIAPD6, Data: append(pd, make([]byte, 8)...)})
+  }
+
+  adv, err := send(base(Solicit6, ias))
+  if err != nil {
+    return err
+  }
+  collect(adv)
+  serverID := adv.Option(OptServerID6)
+  res.Server = FormatDUID(serverID)
+  if adv.Type != Advertise6 || c.NoRequest || len(res.Leases) == 0 {
+    return nil
+  }
+
+  // Ask for what was advertised.
+  var got []Option
+  for _, o := range adv.Options {
+    if o.Code == OptIANA6 || o.Code == OptIAPD6 {
+      got = append(got, o)
+    }
+  }
+  req := base(Request6, got)
+  req.Options = append(req.Options, Option{Code: OptServerID6, Data: serverID})
+  reply, err := send(req)
+  if err != nil {
+    return err
+  }
+  collect(reply)
+  if !c.Release || len(res.Leases) == 0 {
+    return nil
+  }
+
+  got = got[:0]
+  for _, o := range reply.Options {
+    if o.Code == OptIANA6 || o.Code == OptIAPD6 {
+      got = append(got, o)
+    }
+  }
+  rel := base(Release6, got)
+  rel.Options = append(rel.Options, Option{Code: OptServerID6, Data: serverID})
+  outcome := res.Outcome
+  _, err = send(rel)
+  res.Outcome = outcome
+  if err != nil {
+    return fmt.Errorf("release: %w", err)
+  }
+  return nil
+}
+
+// linkLocal returns the EUI-64 link-local address of mac, the peer
+// address a relay would see the client at.
+func linkLocal(mac net.HardwareAddr) netip.Addr {
+  b := [16]byte{0: 0xfe, 1: 0x80}
+  if len(mac) == 6 {
+    b[8], b[9], b[10] = mac[0]^0x02, mac[1], mac[2]
+    b[11], b[12] = 0xff, 0xfe
+    b[13], b[14], b[15] = mac[3], mac[4], mac[5]
+  } else {
+    copy(b[16-min(len(mac), 8):], mac)
+  }
+  return netip.AddrFrom16(b)
+}
@@ -0,0 +1,240 @@
+package dhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn serves a stand-in DHCP server on an unprivileged port of the
+// loopback address. answer returns the reply to a message, nil for
+// none; it also gets every message for the test to look at.
+func standIn(t *testing.T, network, addr string, answer func(b []byte) []byte) netip.AddrPort {
+  t.Helper()
+  conn, err := net.ListenUDP(network, net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr)))
+  if err != nil {
+    t.Skipf("no %s loopback: %v", network, err)
+  }
+  var wg sync.WaitGroup
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    buf := make([]byte, 65536)
+    for {
+      n, from, err := conn.ReadFromUDPAddrPort(buf)
+      if err != nil {
+        return
+      }
+      if reply := answer(append([]byte(nil), buf[:n]...)); reply != nil {
+        conn.WriteToUDPAddrPort(reply, from)
+      }
+    }
+  }()
+  t.Cleanup(func() {
+    conn.Close()
+    wg.Wait()
+  })
+  return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestTestClient4(t *testing.T) {
+  var mu sync.Mutex
+  var got []*Packet4
+  server := standIn(t, "udp4", "127.0.0.1:0", func(b []byte) []byte {
+    p, err := ParsePacket4(b)
+    if err != nil {
+      t.Errorf("server: %v", err)
+      return nil
+    }
+    mu.Lock()
+    got = append(got, p)
+    mu.Unlock()
+
+    reply := &Packet4{Op: BootReply, HType: p.HType, HLen: p.HLen, XID: p.XID, GIAddr: p.GIAddr, CHAddr: p.CHAddr, YIAddr: netip.MustParseAddr("192.0.2.50")}
+    msgType := byte(Offer4)
+    switch p.MessageType() {
+    case Discover4:
+    case Request4:
+      msgType = Ack4
+      if p.RequestedAddress() != reply.YIAddr {
+        msgType = Nak4
+      }
+    default:
+      return nil
+    }
+    reply.Options = []Option{
+      {Code: OptMessageType4, Data: []byte{msgType}},
+      {Code: OptServerID4, Data: []byte{127, 0, 0, 1}},
+      {Code: 3, Data: []byte{192, 0, 2, 1}},
+      {Code: OptRelayAgentInfo4, Data: p.Option(OptRelayAgentInfo4)},
+    }
+    return reply.Marshal()
+  })
+
+  mac, _ := net.ParseMAC("00:11:22:33:44:55")
+  c := &TestClient{
+    Family:      4,
+    Server:      server,
+    Local:       netip.MustParseAddrPort("127.0.0.1:0"),
+    MAC:         mac,
+    ClientID:    []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55},
+    RelayInfo:   []Option{{Code: 1, Data: []byte("eth0")}},
+    VendorClass: "PXEClient",
+    Release:     true,
+    Timeout:     time.Second,
+  }
+  res, err := c.Run(context.Background())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if res.Outcome != "ACK" || res.Address != netip.MustParseAddr("192.0.2.50") || res.Server != "127.0.0.1" {
+    t.Errorf("result = %+v", res)
+  }
+  var steps []string
+  for _, s := range res.Steps {
+    steps = append(steps, s.Sent+"/"+s.Received)
+  }
+  if want := []string{"DISCOVER/OFFER", "REQUEST/ACK", "RELEASE/"}; !slices.Equal(steps, want) {
+    t.Errorf("steps = %v, want %v", steps, want)
+  }
+
+  // Wait for the release, which has no answer.
+  deadline := time.Now().Add(time.Second)
+  for {
+    mu.Lock()
+    n := len(got)
+    mu.Unlock()
+    if n == 3 || time.Now().After(deadline) {
+      break
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  mu.Lock()
+  defer mu.Unlock()
+  if len(got) != 3 {
+    t.Fatalf("server got %d messages", len(got))
+  }
+  discover := got[0]
+  if discover.GIAddr != netip.MustParseAddr("127.0.0.1") || discover.Hops != 1 {
+    t.Errorf("giaddr %s, hops %d", discover.GIAddr, discover.Hops)
+  }
+  if string(discover.Option(optVendorClass4)) != "PXEClient" {
+    t.Errorf("vendor class %q", discover.Option(optVendorClass4))
+  }
+  sub, err := ParseOptions4(discover.Option(OptRelayAgentInfo4))
+  if err != nil {
+    t.Fatal(err)
+  }
+  // The client isn't on port 67, so it asks for replies to its port.
+  if len(sub) != 2 || string(sub[0].Data) != "eth0" || sub[1].Code != agentSourcePort4 {
+    t.Errorf("option 82 = %+v", sub)
+  }
+  if got[2].MessageType() != Release4 || got[2].CIAddr != res.Address {
+    t.Errorf("release = %+v", got[2])
+  }
+}
+
+func TestTestClient6(t *testing.T) {
+  lease := netip.MustParseAddr("2001:db8::50")
+  server := standIn(t, "udp6", "[::1]:0", func(b []byte) []byte {
+    outer, err := ParsePacket6(b)
+    if err != nil || outer.Type != RelayForw6 {
+      t.Errorf("server: %v, type %d", err, outer.Type)
+      return nil
+    }
+    if outer.Option(optRelaySourcePort6) == nil {
+      t.Error("no relay source port option")
+    }
+    if outer.LinkAddr != netip.MustParseAddr("2001:db8::1") {
+      t.Errorf("link-address %s", outer.LinkAddr)
+    }
+    p := outer.Inner()
+    typ := Advertise6
+    switch p.Type {
+    case Solicit6:
+    case Request6:
+      typ = Reply6
+    default:
+      return nil
+    }
+    iaaddr := append(lease.AsSlice(), 0, 0, 0x0e, 0x10, 0, 0, 0x1c, 0x20)
+    ia := binary.BigEndian.AppendUint32(nil, binary.BigEndian.Uint32(p.Option(OptIANA6)))
+    ia = append(ia, 0, 0, 0x07, 0x08, 0, 0, 0x0b, 0x40)
+    ia = AppendOptions6(ia, []Option{{Code: OptIAAddr6, Data: iaaddr}})
+    reply := &Packet6{
+      Type:     RelayRepl6,
+      LinkAddr: outer.LinkAddr,
+      PeerAddr: outer.PeerAddr,
+      Relayed: &Packet6{Type: typ, XID: p.XID, Options: []Option{
+        {Code: OptClientID6, Data: p.Option(OptClientID6)},
+        {Code: OptServerID6, Data: []byte{0, 3, 0, 1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}},
+        {Code: OptIANA6, Data: ia},
+      }},
+    }
+    return reply.Marshal()
+  })
+
+  mac, _ := net.ParseMAC("00:11:22:33:44:55")
+  c := &TestClient{
+    Family:    6,
+    Server:    server,
+    Local:     netip.MustParseAddrPort("[::1]:0"),
+    MAC:       mac,
+    RelayAddr: netip.MustParseAddr("2001:db8::1"),
+    Timeout:   time.Second,
+  }
+  res, err := c.Run(context.Background())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if res.Outcome != "REPLY" || res.Address != lease || len(res.Leases) != 1 || res.Leases[0].Valid != 7200 {
+    t.Errorf("result = %+v", res)
+  }
+  if len(res.Steps) != 2 || res.Steps[0].Received != "ADVERTISE" {
+    t.Errorf("steps = %+v", res.Steps)
+  }
+}
+
+func TestTestClientNoReply(t *testing.T) {
+  var mu sync.Mutex
+  tries := 0
+  server := standIn(t, "udp4", "127.0.0.1:0", func([]byte) []byte {
+    mu.Lock()
+    tries++
+    mu.Unlock()
+    return nil
+  })
+  mac, _ := net.ParseMAC("00:11:22:33:44:55")
+  c := &TestClient{
+    Family:  4,
+    Server:  server,
+    Local:   netip.MustParseAddrPort("127.0.0.1:0"),
+    MAC:     mac,
+    Timeout: 50 * time.Millisecond,
+    Retries: 1,
+  }
+  res, err := c.Run(context.Background())
+  if !errors.Is(err, ErrNoReply) {
+    t.Fatalf("err = %v", err)
+  }
+  if res.Outcome != "no reply" || len(res.Steps) != 1 || res.Steps[0].Tries != 2 {
+    t.Errorf("result = %+v", res)
+  }
+  mu.Lock()
+  defer mu.Unlock()
+  if tries != 2 {
+    t.Errorf("server got %d tries", tries)
+  }
+}
+
+func TestTestClientNeedsMAC(t *testing.T) {
+  if _, err := (&TestClient{Family: 4}).Run(context.Background()); err == nil {
+    t.Error("Run without a MAC succeeded")
+  }
+}
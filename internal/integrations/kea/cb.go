@@ -0,0 +1,420 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ServerTagAll scopes a config backend entry to every server.
+const ServerTagAll = "all"
+
+// Remote selects the config backend a remote-* command targets. It can
+// be left nil when the server has a single backend configured.
+type Remote struct {
+  Type string `json:"type,omitempty"`
+  Host string `json:"host,omitempty"`
+  Port int    `json:"port,omitempty"`
+}
+
+// Metadata is attached by cb_cmds to every object it returns.
+type Metadata struct {
+  ServerTags []string `json:"server-tags"`
+}
+
+// RemoteSubnet is a subnet as listed by remote-subnet[46]-list.
+type RemoteSubnet struct {
+  ID                int64    `json:"id"`
+  Subnet            string   `json:"subnet"`
+  SharedNetworkName string   `json:"shared-network-name,omitempty"`
+  Metadata          Metadata `json:"metadata"`
+}
+
+// RemoteNetwork is a shared network as listed by remote-network[46]-list.
+type RemoteNetwork struct {
+  Name     string   `json:"name"`
+  Metadata Metadata `json:"metadata"`
+}
+
+// RemoteOptionDef is an option definition stored in the config backend.
+type RemoteOptionDef struct {
+  Name     string   `json:"name"`
+  Code     int      `json:"code"`
+  Space    string   `json:"space"`
+  Type     string   `json:"type"`
+  Array    bool     `json:"array"`
+  Metadata Metadata `json:"metadata"`
+}
+
+// RemoteParameter is a global parameter stored in the config backend.
+type RemoteParameter struct {
+  Name     string
+  Value    json.RawMessage
+  Metadata Metadata
+}
+
+// RemoteServer is a server known to the config backend.
+type RemoteServer struct {
+  Tag         string `json:"server-tag"`
+  Description string `json:"description"`
+}
+
+// ConfigBackend issues cb_cmds (remote-*) commands to one DHCP daemon.
+type ConfigBackend struct {
+  client  *Client
+  service string
+  family  string
+  Remote  *Remote
+}
+
+// ConfigBackend returns a ConfigBackend for service (dhcp4 or dhcp6).
+func (c *Client) ConfigBackend(service string) *ConfigBackend {
+  family := "4"
+  if service == ServiceDHCP6 {
+    family = "6"
+  }
+  return &ConfigBackend{client: c, service: service, family: family}
+}
+
+// Service returns the daemon this backend sends commands to.
+func (b *ConfigBackend) Service() string {
+  return b.service
+}
+
+// cmd builds a command name, e.g. cmd("subnet", "list") is
+// remote-subnet4-list for dhcp4.
+func (b *ConfigBackend) cmd(object, action string) string {
+  return "remote-" + object + b.family + "-" + action
+}
+
+// args adds the remote selector and server tags to a command's arguments.
+func (b *ConfigBackend) args(tags []string, extra map[string]any) map[string]any {
+  a := map[string]any{}
+  for k, v := range extra {
+    a[k] = v
+  }
+  if b.Remote != nil {
+    a["remote"] = b.Remote
+  }
+  if tags != nil {
+    a["server-tags"] = tags
+  }
+  return a
+}
+
+func (b *ConfigBackend) call(ctx context.Context, command string, args map[string]any, out any) error {
+  err := b.client.Call(ctx, command, b.service, args, out)
+  if errors.Is(err, ErrEmpty) {
+    return nil
+  }
+  return err
+}
+
+// Servers returns the servers (and so the usable server tags) defined
+// in the backend.
+func (b *ConfigBackend) Servers(ctx context.Context) ([]RemoteServer, error) {
+  var out struct {
+    Servers []RemoteServer `json:"servers"`
+  }
+  err := b.call(ctx, b.cmd("server", "get-all"), b.args(nil, nil), &out)
+  return out.Servers, err
+}
+
+// SetServer creates or updates a server tag.
+func (b *ConfigBackend) SetServer(ctx context.Context, s RemoteServer) error {
+  return b.call(ctx, b.cmd("server", "set"), b.args(nil, map[string]any{
+    "servers": []RemoteServer{s},
+  }), nil)
+}
+
+// Subnets lists the subnets visible to tags.
+func (b *ConfigBackend) Subnets(ctx context.Context, tags []string) ([]RemoteSubnet, error) {
+  var out struct {
+    Subnets []RemoteSubnet `json:"subnets"`
+  }
+  err := b.call(ctx, b.cmd("subnet", "list"), b.args(tags, nil), &out)
+  return out.Subnets, err
+}
+
+// Subnet fetches a single subnet in full.
+func (b *ConfigBackend) Subnet(ctx context.Context, id int64) (json.RawMessage, error) {
+  var out struct {
+    Subnets []json.RawMessage `json:"subnets"`
+  }
+  err := b.call(ctx, b.cmd("subnet", "get-by-id"), b.args(nil, map[string]any{
+    "subnets": []map[string]int64{{"id": id}},
+  }), &out)
+  if err != nil {
+    return nil, err
+  }
+  if len(out.Subnets) == 0 {
+    return nil, fmt.Errorf("subnet %d not found in config backend", id)
+  }
+  return out.Subnets[0], nil
+}
+
+// SetSubnet creates or replaces a subnet. subnet is the full subnet
+// object as it would appear in the configuration file.
+func (b *ConfigBackend) SetSubnet(ctx context.Context, tags []string, subnet json.RawMessage) error {
+  return b.call(ctx, b.cmd("subnet", "set"), b.args(tags, map[string]any{
+    "subnets": []json.RawMessage{subnet},
+  }), nil)
+}
+
+// DeleteSubnet removes a subnet by ID.
+func (b *ConfigBackend) DeleteSubnet(ctx context.Context, id int64) error {
+  return b.call(ctx, b.cmd("subnet", "del-by-id"), b.args(nil, map[string]any{
+    "subnets": []map[string]int64{{"id": id}},
+  }), nil)
+}
+
+// Networks lists the shared networks visible to tags.
+func (b *ConfigBackend) Networks(ctx context.Context, tags []string) ([]RemoteNetwork, error) {
+  var out struct {
+    Networks []RemoteNetwork `json:"shared-networks"`
+  }
+  err := b.call(ctx, b.cmd("network", "list"), b.args(tags, nil), &out)
+  return out.Networks, err
+}
+
+// SetNetwork creates or replaces a shared network.
+func (b *ConfigBackend) SetNetwork(ctx context.Context, tags []string, network json.RawMessage) error {
+  return b.call(ctx, b.cmd("network", "set"), b.args(tags, map[string]any{
+    "shared-networks": []json.RawMessage{network},
+  }), nil)
+}
+
+// DeleteNetwork removes a shared network. When keepSubnets is false its
+// subnets are deleted with it.
+func (b *ConfigBackend) DeleteNetwork(ctx context.Context, name string, keepSubnets bool) error {
+  action := "delete"
+  if keepSubnets {
+    action = "keep"
+  }
+  return b.call(ctx, b.cmd("network", "del"), b.args(nil, map[string]any{
+    "shared-networks": []map[string]string{{"name": name}},
+    "subnets-action":  action,
+  }), nil)
+}
+
+// OptionDefs lists option definitions for a single server tag.
+func (b *ConfigBackend) OptionDefs(ctx context.Context, tag string) ([]RemoteOptionDef, error) {
+  var out struct {
+    OptionDefs []RemoteOptionDef `json:"option-defs"`
+  }
+  err := b.call(ctx, b.cmd("option-def", "get-all"), b.args([]string{tag}, nil), &out)
+  return out.OptionDefs, err
+}
+
+// SetOptionDef creates or replaces an option definition.
+func (b *ConfigBackend) SetOptionDef(ctx context.Context, tags []string, def json.RawMessage) error {
+  return b.call(ctx, b.cmd("option-def", "set"), b.args(tags, map[string]any{
+    "option-defs": []json.RawMessage{def},
+  }), nil)
+}
+
+// DeleteOptionDef removes an option definition.
+func (b *ConfigBackend) DeleteOptionDef(ctx context.Context, tags []string, code int, space string) error {
+  return b.call(ctx, b.cmd("option-def", "del"), b.args(tags, map[string]any{
+    "option-defs": []map[string]any{{"code": code, "space": space}},
+  }), nil)
+}
+
+// SetGlobalOption creates or replaces a global option-data entry.
+func (b *ConfigBackend) SetGlobalOption(ctx context.Context, tags []string, option json.RawMessage) error {
+  return b.call(ctx, b.cmd("option", "global-set"), b.args(tags, map[string]any{
+    "options": []json.RawMessage{option},
+  }), nil)
+}
+
+// GlobalParameters lists global parameters for a single server tag,
+// sorted by name.
+func (b *ConfigBackend) GlobalParameters(ctx context.Context, tag string) ([]RemoteParameter, error) {
+  // Each entry is an object holding one parameter plus its metadata.
+  var out struct {
+    Parameters []map[string]json.RawMessage `json:"parameters"`
+  }
+  if err := b.call(ctx, b.cmd("global-parameter", "get-all"), b.args([]string{tag}, nil), &out); err != nil {
+    return nil, err
+  }
+
+  var params []RemoteParameter
+  for _, entry := range out.Parameters {
+    var md Metadata
+    if raw, ok := entry["metadata"]; ok {
+      _ = json.Unmarshal(raw, &md)
+    }
+    for name, value := range entry {
+      if name == "metadata" {
+        continue
+      }
+      params = append(params, RemoteParameter{Name: name, Value: value, Metadata: md})
+    }
+  }
+  sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+  return params, nil
+}
+
+// SetGlobalParameter sets a single global parameter.
+func (b *ConfigBackend) SetGlobalParameter(ctx context.Context, tags []string, name string, value json.RawMessage) error {
+  return b.call(ctx, b.cmd("global-parameter", "set"), b.args(tags, map[string]any{
+    "parameters": map[string]json.RawMessage{name: value},
+  }), nil)
+}
+
+// DeleteGlobalParameter removes a global parameter so the value from the
+// configuration file (or the default) applies again.
+func (b *ConfigBackend) DeleteGlobalParameter(ctx context.Context, tags []string, name string) error {
+  return b.call(ctx, b.cmd("global-parameter", "del"), b.args(tags, map[string]any{
+    "parameters": []string{name},
+  }), nil)
+}
+
+// Where a piece of the running configuration was defined.
+const (
+  SourceFile    = "config file"
+  SourceBackend = "config backend"
+)
+
+// SourcedItem is one entry of the running configuration tagged with the
+// place it came from.
+type SourcedItem struct {
+  Kind       string
+  Key        string
+  Value      string
+  Source     string
+  ServerTags []string
+}
+
+// Sources compares the running configuration (config-get) with what the
+// backend holds for tag and reports, for every global parameter, shared
+// network, subnet and option definition, whether it came from the config
+// file or the config backend.
+func (b *ConfigBackend) Sources(ctx context.Context, tag string) ([]SourcedItem, error) {
+  running, err := b.client.ConfigGet(ctx, b.service)
+  if err != nil {
+    return nil, err
+  }
+
+  params, err := b.GlobalParameters(ctx, tag)
+  if err != nil {
+    return nil, err
+  }
+  subnets, err := b.Subnets(ctx, []string{tag})
+  if err != nil {
+    return nil, err
+  }
+  networks, err := b.Networks(ctx, []string{tag})
+  if err != nil {
+    return nil, err
+  }
+  defs, err := b.OptionDefs(ctx, tag)
+  if err != nil {
+    return nil, err
+  }
+
+  var items []SourcedItem
+
+  // Global parameters: only scalars, lists and maps are shown elsewhere.
+  remoteParams := map[string]RemoteParameter{}
+  for _, p := range params {
+    remoteParams[p.Name] = p
+  }
+  var names []string
+  for name, raw := range running {
+    if len(raw) > 0 && (raw[0] == '{' || raw[0] == '[') {
+      continue
+    }
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    item := SourcedItem{Kind: "parameter", Key: name, Value: string(running[name]), Source: SourceFile}
+    if p, ok := remoteParams[name]; ok {
+      item.Source = SourceBackend
+      item.ServerTags = p.Metadata.ServerTags
+    }
+    items = append(items, item)
+  }
+
+  // Shared networks and the subnets inside them.
+  remoteNets := map[string]RemoteNetwork{}
+  for _, n := range networks {
+    remoteNets[n.Name] = n
+  }
+  remoteSubnets := map[int64]RemoteSubnet{}
+  for _, s := range subnets {
+    remoteSubnets[s.ID] = s
+  }
+
+  subnetKey := "subnet" + b.family
+  var runningSubnets []RemoteSubnet
+  if raw, ok := running[subnetKey]; ok {
+    if err := json.Unmarshal(raw, &runningSubnets); err != nil {
+      return nil, fmt.Errorf("decode %s: %w", subnetKey, err)
+    }
+  }
+
+  if raw, ok := running["shared-networks"]; ok {
+    var nets []map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &nets); err != nil {
+      return nil, fmt.Errorf("decode shared-networks: %w", err)
+    }
+    for _, n := range nets {
+      var name string
+      _ = json.Unmarshal(n["name"], &name)
+
+      item := SourcedItem{Kind: "shared-network", Key: name, Source: SourceFile}
+      if rn, ok := remoteNets[name]; ok {
+        item.Source = SourceBackend
+        item.ServerTags = rn.Metadata.ServerTags
+      }
+      items = append(items, item)
+
+      var subs []RemoteSubnet
+      _ = json.Unmarshal(n[subnetKey], &subs)
+      for i := range subs {
+        subs[i].SharedNetworkName = name
+      }
+      runningSubnets = append(runningSubnets, subs...)
+    }
+  }
+
+  sort.Slice(runningSubnets, func(i, j int) bool { return runningSubnets[i].ID < runningSubnets[j].ID })
+  for _, s := range runningSubnets {
+    item := SourcedItem{Kind: "subnet", Key: fmt.Sprintf("%d", s.ID), Value: s.Subnet, Source: SourceFile}
+    if s.SharedNetworkName != "" {
+      item.Value += " (" + s.SharedNetworkName + ")"
+    }
+    if rs, ok := remoteSubnets[s.ID]; ok {
+      item.Source = SourceBackend
+      item.ServerTags = rs.Metadata.ServerTags
+    }
+    items = append(items, item)
+  }
+
+  // Option definitions, keyed by space and code.
+  remoteDefs := map[string]RemoteOptionDef{}
+  for _, d := range defs {
+    remoteDefs[fmt.Sprintf("%s/%d", d.Space, d.Code)] = d
+  }
+  if raw, ok := running["option-def"]; ok {
+    var runningDefs []RemoteOptionDef
+    if err := json.Unmarshal(raw, &runningDefs); err != nil {
+      return nil, fmt.Errorf("decode option-def: %w", err)
+    }
+    for _, d := range runningDefs {
+      key := fmt.Sprintf("%s/%d", d.Space, d.Code)
+      item := SourcedItem{Kind: "option-def", Key: key, Value: d.Name + " (" + d.Type + ")", Source: SourceFile}
+      if rd, ok := remoteDefs[key]; ok {
+        item.Source = SourceBackend
+        item.ServerTags = rd.Metadata.ServerTags
+      }
+      items = append(items, item)
+    }
+  }
+
+  return items, nil
+}
@@ -0,0 +1,39 @@
+package kea
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ClientClass is a client-classes entry.
+type ClientClass struct {
+  Name string `json:"name"`
+  Test string `json:"test,omitempty"`
+  // TemplateTest makes a template class that spawns classes named after
+  // the value of the expression.
+  TemplateTest string `json:"template-test,omitempty"`
+  // OnlyIfRequired (OnlyInAdditionalList since Kea 2.7) defers the
+  // class to the additional classes of a network, subnet or pool.
+  OnlyIfRequired       bool         `json:"only-if-required,omitempty"`
+  OnlyInAdditionalList bool         `json:"only-in-additional-list,omitempty"`
+  OptionData           []OptionData `json:"option-data,omitempty"`
+  NextServer           string       `json:"next-server,omitempty"`
+  BootFileName         string       `json:"boot-file-name,omitempty"`
+}
+
+// Additional reports whether the class is only evaluated on demand.
+func (c ClientClass) Additional() bool {
+  return c.OnlyIfRequired || c.OnlyInAdditionalList
+}
+
+// ClientClassesFromConfig extracts the client classes of a config-get
+// element, in evaluation order.
+func ClientClassesFromConfig(cfg map[string]json.RawMessage) ([]ClientClass, error) {
+  var classes []ClientClass
+  if raw, ok := cfg["client-classes"]; ok {
+    if err := json.Unmarshal(raw, &classes); err != nil {
+      return nil, fmt.Errorf("decode client-classes: %w", err)
+    }
+  }
+  return classes, nil
+}
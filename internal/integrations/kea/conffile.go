@@ -0,0 +1,354 @@
+package kea
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration files of the Kea daemons, as installed by the packages.
+const (
+  ConfFileDHCP4     = "kea-dhcp4.conf"
+  ConfFileDHCP6     = "kea-dhcp6.conf"
+  ConfFileD2        = "kea-dhcp-ddns.conf"
+  ConfFileCtrlAgent = "kea-ctrl-agent.conf"
+)
+
+// ConfFiles lists the daemon configuration files in display order.
+var ConfFiles = []string{ConfFileDHCP4, ConfFileDHCP6, ConfFileD2, ConfFileCtrlAgent}
+
+// confFileServices maps a daemon configuration file to its service; ""
+// is the Control Agent.
+var confFileServices = map[string]string{
+  ConfFileDHCP4:     ServiceDHCP4,
+  ConfFileDHCP6:     ServiceDHCP6,
+  ConfFileD2:        ServiceD2,
+  ConfFileCtrlAgent: "",
+}
+
+// ConfFileService returns the service configured by a daemon file.
+func ConfFileService(name string) (string, bool) {
+  s, ok := confFileServices[name]
+  return s, ok
+}
+
+const (
+  backupTimeLayout = "20060102T150405.000"
+  defaultKeep      = 20
+)
+
+// ErrConfigChanged is returned by ConfigFiles.Write when the file was
+// modified after the caller read it.
+var ErrConfigChanged = errors.New("the file was changed by someone else; reload it and reapply your edits")
+
+// ConfigFiles reads and writes Kea configuration files in Dir. Files are
+// saved byte for byte as given, so comments and include directives stay
+// as written; parsing is only used for validation.
+type ConfigFiles struct {
+  Dir       string
+  BackupDir string
+  // Keep is the number of backups kept per file.
+  Keep int
+
+  mu sync.Mutex
+}
+
+// NewConfigFiles returns a provider for dir. backupDir defaults to
+// dir/backups.
+func NewConfigFiles(dir, backupDir string) *ConfigFiles {
+  if backupDir == "" {
+    backupDir = filepath.Join(dir, "backups")
+  }
+  return &ConfigFiles{Dir: dir, BackupDir: backupDir, Keep: defaultKeep}
+}
+
+// ConfigFile is the content of one file.
+type ConfigFile struct {
+  Name    string
+  Path    string
+  Raw     []byte
+  ModTime time.Time
+  // Hash identifies the content read; pass it back to Write to detect
+  // concurrent edits.
+  Hash string
+}
+
+// Backup is a saved copy of a file.
+type Backup struct {
+  Name string
+  Time time.Time
+  Size int64
+}
+
+func contentHash(b []byte) string {
+  sum := sha256.Sum256(b)
+  return hex.EncodeToString(sum[:])
+}
+
+// checkName only lets plain .conf and .json files of Dir through.
+func checkName(name string) error {
+  if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") ||
+    !(strings.HasSuffix(name, ".conf") || strings.HasSuffix(name, ".json")) {
+    return fmt.Errorf("invalid config file name %q", name)
+  }
+  return nil
+}
+
+// List returns the daemon files that exist plus any other .conf or
+// .json file in Dir (include fragments, usually).
+func (c *ConfigFiles) List() ([]string, error) {
+  entries, err := os.ReadDir(c.Dir)
+  if err != nil {
+    return nil, err
+  }
+  present := map[string]bool{}
+  var others []string
+  for _, e := range entries {
+    if !e.Type().IsRegular() || checkName(e.Name()) != nil {
+      continue
+    }
+    present[e.Name()] = true
+    if _, daemon := confFileServices[e.Name()]; !daemon {
+      others = append(others, e.Name())
+    }
+  }
+
+  var out []string
+  for _, name := range ConfFiles {
+    if present[name] {
+      out = append(out, name)
+    }
+  }
+  sort.Strings(others)
+  return append(out, others...), nil
+}
+
+// Read returns a file of Dir.
+func (c *ConfigFiles) Read(name string) (ConfigFile, error) {
+  if err := checkName(name); err != nil {
+    return ConfigFile{}, err
+  }
+  p := filepath.Join(c.Dir, name)
+  raw, err := os.ReadFile(p)
+  if err != nil {
+    return ConfigFile{}, err
+  }
+  st, err := os.Stat(p)
+  if err != nil {
+    return ConfigFile{}, err
+  }
+  return ConfigFile{Name: name, Path: p, Raw: raw, ModTime: st.ModTime(), Hash: contentHash(raw)}, nil
+}
+
+// Validate parses the configuration as it would be with name's content
+// replaced by raw. A daemon file is parsed itself; any other file is
+// checked through every daemon file that includes it. It returns the
+// daemon files that were checked.
+func (c *ConfigFiles) Validate(name string, raw []byte) ([]string, error) {
+  if err := checkName(name); err != nil {
+    return nil, err
+  }
+  target := filepath.Join(c.Dir, name)
+  read := func(p string) ([]byte, error) {
+    if filepath.Clean(p) == target {
+      return raw, nil
+    }
+    return os.ReadFile(p)
+  }
+
+  var checked []string
+  for _, daemon := range ConfFiles {
+    p := filepath.Join(c.Dir, daemon)
+    if daemon != name {
+      if _, err := os.Stat(p); err != nil {
+        continue
+      }
+    }
+    root, files, err := ParseConfigFile(p, read)
+    if daemon != name && !containsPath(files, target) {
+      continue
+    }
+    if err != nil {
+      return checked, err
+    }
+    if key := rootKey(confFileServices[daemon]); root[key] == nil {
+      return checked, &ConfigError{File: p, Msg: fmt.Sprintf("no %q object", key)}
+    }
+    checked = append(checked, daemon)
+  }
+  return checked, nil
+}
+
+// Element parses a daemon file and returns the object under its
+// top-level key, the form config-test takes.
+func (c *ConfigFiles) Element(name string, raw []byte) (map[string]json.RawMessage, error) {
+  service, ok := confFileServices[name]
+  if !ok {
+    return nil, fmt.Errorf("%s is not a daemon configuration file", name)
+  }
+  target := filepath.Join(c.Dir, name)
+  root, _, err := ParseConfigFile(target, func(p string) ([]byte, error) {
+    if filepath.Clean(p) == target {
+      return raw, nil
+    }
+    return os.ReadFile(p)
+  })
+  if err != nil {
+    return nil, err
+  }
+  var elem map[string]json.RawMessage
+  if err := json.Unmarshal(root[rootKey(service)], &elem); err != nil || elem == nil {
+    return nil, &ConfigError{File: target, Msg: fmt.Sprintf("no %q object", rootKey(service))}
+  }
+  return elem, nil
+}
+
+func containsPath(files []string, p string) bool {
+  for _, f := range files {
+    if filepath.Clean(f) == p {
+      return true
+    }
+  }
+  return false
+}
+
+// Write replaces a file with raw after backing up the current content.
+// If expectHash is set and the file no longer has that hash, nothing is
+// written and ErrConfigChanged is returned. The new file is written to
+// a temporary file and renamed over the old one, keeping its mode and
+// owner, so Kea never sees a half-written configuration.
+func (c *ConfigFiles) Write(name string, raw []byte, expectHash string) (backup string, err error) {
+  if err := checkName(name); err != nil {
+    return "", err
+  }
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  p := filepath.Join(c.Dir, name)
+  mode := fs.FileMode(0o640)
+  old, err := os.ReadFile(p)
+  switch {
+  case err == nil:
+    if expectHash != "" && contentHash(old) != expectHash {
+      return "", ErrConfigChanged
+    }
+    if backup, err = c.backup(name, old); err != nil {
+      return "", fmt.Errorf("backup: %w", err)
+    }
+  case errors.Is(err, os.ErrNotExist):
+  default:
+    return "", err
+  }
+
+  st, statErr := os.Stat(p)
+  if statErr == nil {
+    mode = st.Mode().Perm()
+  }
+
+  tmp, err := os.CreateTemp(c.Dir, "."+name+".tmp-*")
+  if err != nil {
+    return backup, err
+  }
+  defer os.Remove(tmp.Name())
+
+  if _, err := tmp.Write(raw); err != nil {
+    tmp.Close()
+    return backup, err
+  }
+  if err := tmp.Chmod(mode); err != nil {
+    tmp.Close()
+    return backup, err
+  }
+  if statErr == nil {
+    // Needs privileges when the owner differs; keep going without.
+    _ = copyOwner(tmp, st)
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    return backup, err
+  }
+  if err := tmp.Close(); err != nil {
+    return backup, err
+  }
+  if err := os.Rename(tmp.Name(), p); err != nil {
+    return backup, err
+  }
+  if d, err := os.Open(c.Dir); err == nil {
+    _ = d.Sync()
+    d.Close()
+  }
+  return backup, nil
+}
+
+// backup copies content to BackupDir as name.<timestamp> and prunes old
+// copies.
+func (c *ConfigFiles) backup(name string, content []byte) (string, error) {
+  if err := os.MkdirAll(c.BackupDir, 0o750); err != nil {
+    return "", err
+  }
+  b := name + "." + time.Now().UTC().Format(backupTimeLayout)
+  if err := os.WriteFile(filepath.Join(c.BackupDir, b), content, 0o640); err != nil {
+    return "", err
+  }
+
+  backups, err := c.Backups(name)
+  if err == nil && c.Keep > 0 && len(backups) > c.Keep {
+    for _, old := range backups[c.Keep:] {
+      _ = os.Remove(filepath.Join(c.BackupDir, old.Name))
+    }
+  }
+  return b, nil
+}
+
+// Backups lists the backups of name, newest first.
+func (c *ConfigFiles) Backups(name string) ([]Backup, error) {
+  if err := checkName(name); err != nil {
+    return nil, err
+  }
+  entries, err := os.ReadDir(c.BackupDir)
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  var out []Backup
+  for _, e := range entries {
+    stamp, ok := strings.CutPrefix(e.Name(), name+".")
+    if !ok {
+      continue
+    }
+    t, err := time.Parse(backupTimeLayout, stamp)
+    if err != nil {
+      continue
+    }
+    b := Backup{Name: e.Name(), Time: t}
+    if info, err := e.Info(); err == nil {
+      b.Size = info.Size()
+    }
+    out = append(out, b)
+  }
+  sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+  return out, nil
+}
+
+// ReadBackup returns the content of one of name's backups.
+func (c *ConfigFiles) ReadBackup(name, backup string) ([]byte, error) {
+  if err := checkName(name); err != nil {
+    return nil, err
+  }
+  if backup != filepath.Base(backup) || !strings.HasPrefix(backup, name+".") {
+    return nil, fmt.Errorf("invalid backup %q", backup)
+  }
+  return os.ReadFile(filepath.Join(c.BackupDir, backup))
+}
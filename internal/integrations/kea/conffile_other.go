@@ -0,0 +1,12 @@
+//go:build !unix
+
+package kea
+
+import (
+	"io/fs"
+	"os"
+)
+
+func copyOwner(f *os.File, st fs.FileInfo) error {
+  return nil
+}
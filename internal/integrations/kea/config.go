@@ -1 +1,112 @@
-package kea
\ No newline at end of file
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+const (
+  defaultAPIPort = "8000"
+  defaultTimeout = 10 * time.Second
+)
+
+// Config describes how to reach the Kea Control Agent.
+type Config struct {
+  URL      string
+  Username string
+  Password string
+  Timeout  time.Duration
+}
+
+// ConfigFromEnv builds a Config from the loaded environment.
+// KEA_API_URL wins over KEA_API_IP when both are set.
+func ConfigFromEnv(e utils.Env) Config {
+  url := e.KEA_API_URL
+  if url == "" && e.KEA_API_IP != "" {
+    url = "http://" + e.KEA_API_IP + ":" + defaultAPIPort + "/"
+  }
+
+  return Config{
+    URL:      strings.TrimSpace(url),
+    Username: e.KEA_API_USERNAME,
+    Password: e.KEA_API_PASSWORD,
+    Timeout:  defaultTimeout,
+  }
+}
+
+// rootKey maps a service to the top-level element of its configuration.
+func rootKey(service string) string {
+  switch service {
+  case ServiceDHCP4:
+    return "Dhcp4"
+  case ServiceDHCP6:
+    return "Dhcp6"
+  case ServiceD2:
+    return "DhcpDdns"
+  }
+  return "Control-agent"
+}
+
+// ConfigGet returns the running configuration of service, i.e. the
+// object under its top-level element ("Dhcp4", "Dhcp6", ...).
+func (c *Client) ConfigGet(ctx context.Context, service string) (map[string]json.RawMessage, error) {
+  // The root also carries a "hash" string next to the element.
+  var root map[string]json.RawMessage
+  if err := c.Call(ctx, "config-get", service, nil, &root); err != nil {
+    return nil, err
+  }
+
+  raw, ok := root[rootKey(service)]
+  if !ok {
+    return nil, fmt.Errorf("kea config-get: no %s element", rootKey(service))
+  }
+
+  var cfg map[string]json.RawMessage
+  if err := json.Unmarshal(raw, &cfg); err != nil {
+    return nil, fmt.Errorf("kea config-get: decode %s: %w", rootKey(service), err)
+  }
+  return cfg, nil
+}
+
+// configRoot wraps a config element in its top-level key, the form
+// config-test and config-set take.
+func configRoot(service string, cfg map[string]json.RawMessage) map[string]any {
+  return map[string]any{rootKey(service): cfg}
+}
+
+// ConfigTest asks service to validate cfg without applying it.
+func (c *Client) ConfigTest(ctx context.Context, service string, cfg map[string]json.RawMessage) error {
+  return c.Call(ctx, "config-test", service, configRoot(service, cfg), nil)
+}
+
+// ConfigSet replaces the running configuration of service with cfg. The
+// change is lost on restart unless followed by ConfigWrite.
+func (c *Client) ConfigSet(ctx context.Context, service string, cfg map[string]json.RawMessage) error {
+  return c.Call(ctx, "config-set", service, configRoot(service, cfg), nil)
+}
+
+// ConfigWrite saves the running configuration of service to the file it
+// was loaded from.
+func (c *Client) ConfigWrite(ctx context.Context, service string) error {
+  return c.Call(ctx, "config-write", service, nil, nil)
+}
+
+// ReplaceConfig tests cfg, applies it and, if persist is set, writes it
+// to the daemon's config file.
+func (c *Client) ReplaceConfig(ctx context.Context, service string, cfg map[string]json.RawMessage, persist bool) error {
+  if err := c.ConfigTest(ctx, service, cfg); err != nil {
+    return err
+  }
+  if err := c.ConfigSet(ctx, service, cfg); err != nil {
+    return err
+  }
+  if persist {
+    return c.ConfigWrite(ctx, service)
+  }
+  return nil
+}
@@ -0,0 +1,154 @@
+package kea
+
+import (
+	"context"
+	"errors"
+)
+
+// Host identifier types, as named by Kea.
+const (
+  IdentifierHWAddress = "hw-address"
+  IdentifierDUID      = "duid"
+  IdentifierCircuitID = "circuit-id"
+  IdentifierClientID  = "client-id"
+  IdentifierFlexID    = "flex-id"
+)
+
+// IdentifierTypes lists the identifier types in Kea's preferred order.
+var IdentifierTypes = []string{
+  IdentifierHWAddress,
+  IdentifierDUID,
+  IdentifierCircuitID,
+  IdentifierClientID,
+  IdentifierFlexID,
+}
+
+// Reservation is a host reservation as used by host_cmds and in the
+// reservations list of a subnet.
+type Reservation struct {
+  SubnetID       int64        `json:"subnet-id,omitempty"`
+  HWAddress      string       `json:"hw-address,omitempty"`
+  DUID           string       `json:"duid,omitempty"`
+  CircuitID      string       `json:"circuit-id,omitempty"`
+  ClientID       string       `json:"client-id,omitempty"`
+  FlexID         string       `json:"flex-id,omitempty"`
+  IPAddress      string       `json:"ip-address,omitempty"`
+  IPAddresses    []string     `json:"ip-addresses,omitempty"`
+  Prefixes       []string     `json:"prefixes,omitempty"`
+  Hostname       string       `json:"hostname,omitempty"`
+  ClientClasses  []string     `json:"client-classes,omitempty"`
+  NextServer     string       `json:"next-server,omitempty"`
+  ServerHostname string       `json:"server-hostname,omitempty"`
+  BootFileName   string       `json:"boot-file-name,omitempty"`
+  OptionData     []OptionData `json:"option-data,omitempty"`
+}
+
+// Identifier returns the type and value of the reservation's identifier.
+func (r Reservation) Identifier() (string, string) {
+  switch {
+  case r.HWAddress != "":
+    return IdentifierHWAddress, r.HWAddress
+  case r.DUID != "":
+    return IdentifierDUID, r.DUID
+  case r.CircuitID != "":
+    return IdentifierCircuitID, r.CircuitID
+  case r.ClientID != "":
+    return IdentifierClientID, r.ClientID
+  case r.FlexID != "":
+    return IdentifierFlexID, r.FlexID
+  }
+  return "", ""
+}
+
+// SetIdentifier sets the identifier field named by typ.
+func (r *Reservation) SetIdentifier(typ, value string) error {
+  switch typ {
+  case IdentifierHWAddress:
+    r.HWAddress = value
+  case IdentifierDUID:
+    r.DUID = value
+  case IdentifierCircuitID:
+    r.CircuitID = value
+  case IdentifierClientID:
+    r.ClientID = value
+  case IdentifierFlexID:
+    r.FlexID = value
+  default:
+    return errors.New("unknown identifier type " + typ)
+  }
+  return nil
+}
+
+// Addresses returns every address reserved, v4 or v6.
+func (r Reservation) Addresses() []string {
+  var out []string
+  if r.IPAddress != "" {
+    out = append(out, r.IPAddress)
+  }
+  return append(out, r.IPAddresses...)
+}
+
+// ReservationsAll pages through every reservation held in the host
+// databases of service (reservation-get-page). Paging starts past
+// source 0, the configuration file, whose reservations come with the
+// subnets. Each source is paged in turn, so a short page only ends its
+// source; an empty result ends them all.
+func (c *Client) ReservationsAll(ctx context.Context, service string) ([]Reservation, error) {
+  const limit = 500
+
+  var out []Reservation
+  args := map[string]any{"limit": limit, "source-index": 1}
+  for {
+    var page struct {
+      Hosts []Reservation `json:"hosts"`
+      Next  struct {
+        From        int64 `json:"from"`
+        SourceIndex int   `json:"source-index"`
+      } `json:"next"`
+    }
+    err := c.Call(ctx, "reservation-get-page", service, args, &page)
+    if errors.Is(err, ErrEmpty) {
+      return out, nil
+    }
+    if err != nil {
+      return out, err
+    }
+
+    out = append(out, page.Hosts...)
+    args = map[string]any{
+      "limit":        limit,
+      "from":         page.Next.From,
+      "source-index": page.Next.SourceIndex,
+    }
+  }
+}
+
+// ReservationGet fetches one reservation by identifier.
+func (c *Client) ReservationGet(ctx context.Context, service string, subnetID int64, idType, id string) (Reservation, error) {
+  var r Reservation
+  err := c.Call(ctx, "reservation-get", service, map[string]any{
+    "subnet-id":       subnetID,
+    "identifier-type": idType,
+    "identifier":      id,
+  }, &r)
+  return r, err
+}
+
+// ReservationAdd adds r to the host backend.
+func (c *Client) ReservationAdd(ctx context.Context, service string, r Reservation) error {
+  return c.Call(ctx, "reservation-add", service, map[string]any{"reservation": r}, nil)
+}
+
+// ReservationUpdate replaces an existing reservation (reservation-update).
+func (c *Client) ReservationUpdate(ctx context.Context, service string, r Reservation) error {
+  return c.Call(ctx, "reservation-update", service, map[string]any{"reservation": r}, nil)
+}
+
+// ReservationDel deletes a reservation by identifier.
+func (c *Client) ReservationDel(ctx context.Context, service string, subnetID int64, idType, id string) error {
+  return c.Call(ctx, "reservation-del", service, map[string]any{
+    "subnet-id":       subnetID,
+    "identifier-type": idType,
+    "identifier":      id,
+  }, nil)
+}
@@ -0,0 +1,94 @@
+package kea
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pagedHosts answers reservation-get-page like Kea: a page comes from
+// the first source at or after source-index with hosts past from, and
+// the result is empty only when no source has any left. Source 0 is the
+// configuration file.
+type pagedHosts struct {
+  sources [][]Reservation
+  calls   int
+}
+
+func (p *pagedHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  var req struct {
+    Command   string `json:"command"`
+    Arguments struct {
+      Limit       int   `json:"limit"`
+      From        int64 `json:"from"`
+      SourceIndex int   `json:"source-index"`
+    } `json:"arguments"`
+  }
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Command != "reservation-get-page" {
+    http.Error(w, "bad request", http.StatusBadRequest)
+    return
+  }
+  p.calls++
+  a := req.Arguments
+  for src := a.SourceIndex; src < len(p.sources); src, a.From = src+1, 0 {
+    hosts := p.sources[src]
+    if a.From >= int64(len(hosts)) {
+      continue
+    }
+    page := hosts[a.From:min(len(hosts), int(a.From)+a.Limit)]
+    json.NewEncoder(w).Encode([]any{map[string]any{
+      "result":    ResultSuccess,
+      "arguments": map[string]any{"count": len(page), "hosts": page, "next": map[string]any{"from": a.From + int64(len(page)), "source-index": src}},
+    }})
+    return
+  }
+  json.NewEncoder(w).Encode([]any{map[string]any{"result": ResultEmpty, "text": "0 IPv4 host(s) found."}})
+}
+
+func hostsOf(n int, prefix string) []Reservation {
+  out := make([]Reservation, n)
+  for i := range out {
+    out[i] = Reservation{SubnetID: 1, HWAddress: fmt.Sprintf("%s:%02x:%02x", prefix, i/256, i%256)}
+  }
+  return out
+}
+
+func TestReservationsAll(t *testing.T) {
+  tests := []struct {
+    name    string
+    sources [][]Reservation
+    want    int
+  }{
+    {"no databases", [][]Reservation{hostsOf(3, "00:00:00:00")}, 0},
+    {"short database page", [][]Reservation{hostsOf(3, "00:00:00:00"), hostsOf(2, "00:00:00:01")}, 2},
+    {"pages across databases", [][]Reservation{hostsOf(3, "00:00:00:00"), hostsOf(501, "00:00:00:01"), nil, hostsOf(7, "00:00:00:03")}, 508},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      fake := &pagedHosts{sources: tt.sources}
+      srv := httptest.NewServer(fake)
+      defer srv.Close()
+
+      got, err := NewClient(Config{URL: srv.URL, Timeout: 5 * time.Second}).ReservationsAll(t.Context(), ServiceDHCP4)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if len(got) != tt.want {
+        t.Fatalf("got %d reservations in %d calls, want %d", len(got), fake.calls, tt.want)
+      }
+      seen := map[string]bool{}
+      for _, h := range got {
+        if h.HWAddress[:11] == "00:00:00:00" {
+          t.Errorf("config file reservation %s returned", h.HWAddress)
+        }
+        if seen[h.HWAddress] {
+          t.Errorf("%s returned twice", h.HWAddress)
+        }
+        seen[h.HWAddress] = true
+      }
+    })
+  }
+}
@@ -0,0 +1,101 @@
+package kea
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// InterfacesConfig is a DHCP daemon's "interfaces-config" element. Keys
+// without a field are kept in Other so a round trip doesn't drop them.
+type InterfacesConfig struct {
+  // Interfaces holds entries such as "eth0", "eth0/192.0.2.1" or "*".
+  Interfaces []string
+  // DHCPSocketType ("raw" or "udp") and OutboundInterface
+  // ("same-as-inbound" or "use-routing") are DHCPv4 only.
+  DHCPSocketType    string
+  OutboundInterface string
+  ReDetect          *bool
+  Other             map[string]json.RawMessage
+}
+
+var interfacesConfigKeys = []string{"interfaces", "dhcp-socket-type", "outbound-interface", "re-detect"}
+
+// UnmarshalJSON splits the known keys from the rest.
+func (ic *InterfacesConfig) UnmarshalJSON(b []byte) error {
+  var m map[string]json.RawMessage
+  if err := json.Unmarshal(b, &m); err != nil {
+    return err
+  }
+  fields := []any{&ic.Interfaces, &ic.DHCPSocketType, &ic.OutboundInterface, &ic.ReDetect}
+  for i, key := range interfacesConfigKeys {
+    if raw, ok := m[key]; ok {
+      if err := json.Unmarshal(raw, fields[i]); err != nil {
+        return fmt.Errorf("interfaces-config %s: %w", key, err)
+      }
+      delete(m, key)
+    }
+  }
+  ic.Other = m
+  return nil
+}
+
+// MarshalJSON merges the known keys back with Other.
+func (ic InterfacesConfig) MarshalJSON() ([]byte, error) {
+  m := map[string]any{}
+  for k, v := range ic.Other {
+    m[k] = v
+  }
+  ifaces := ic.Interfaces
+  if ifaces == nil {
+    ifaces = []string{}
+  }
+  m["interfaces"] = ifaces
+  if ic.DHCPSocketType != "" {
+    m["dhcp-socket-type"] = ic.DHCPSocketType
+  }
+  if ic.OutboundInterface != "" {
+    m["outbound-interface"] = ic.OutboundInterface
+  }
+  if ic.ReDetect != nil {
+    m["re-detect"] = *ic.ReDetect
+  }
+  return json.Marshal(m)
+}
+
+// ReDetectEnabled reports the effective re-detect setting, which Kea
+// defaults to true.
+func (ic InterfacesConfig) ReDetectEnabled() bool {
+  return ic.ReDetect == nil || *ic.ReDetect
+}
+
+// InterfacesConfigFromConfig extracts "interfaces-config" from a
+// config-get element.
+func InterfacesConfigFromConfig(cfg map[string]json.RawMessage) (InterfacesConfig, error) {
+  var ic InterfacesConfig
+  raw, ok := cfg["interfaces-config"]
+  if !ok {
+    return ic, nil
+  }
+  if err := json.Unmarshal(raw, &ic); err != nil {
+    return ic, fmt.Errorf("decode interfaces-config: %w", err)
+  }
+  return ic, nil
+}
+
+// SetInterfacesConfig stores ic in a config-get element.
+func SetInterfacesConfig(cfg map[string]json.RawMessage, ic InterfacesConfig) error {
+  raw, err := json.Marshal(ic)
+  if err != nil {
+    return err
+  }
+  cfg["interfaces-config"] = raw
+  return nil
+}
+
+// SplitInterfaceEntry splits "eth0/192.0.2.1" into the interface name
+// and the address Kea should bind to; plain names return addr "".
+func SplitInterfaceEntry(entry string) (name, addr string) {
+  name, addr, _ = strings.Cut(entry, "/")
+  return name, addr
+}
@@ -0,0 +1,208 @@
+package kea
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxIncludeDepth stops include cycles.
+const maxIncludeDepth = 10
+
+// ConfigError is a problem at a position in a configuration file.
+type ConfigError struct {
+  File string
+  Line int
+  Col  int
+  Msg  string
+}
+
+func (e *ConfigError) Error() string {
+  if e.Line == 0 {
+    return e.File + ": " + e.Msg
+  }
+  return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// include is an <?include "path"?> directive found in a file.
+type include struct {
+  start, end int // span of the directive
+  path       string
+}
+
+// scanJSONC blanks out the comments (#, // and /* */) and include
+// directives of Kea's configuration dialect. Every removed byte becomes
+// a space except newlines, so offsets and line numbers still match the
+// original text.
+func scanJSONC(file string, b []byte) ([]byte, []include, error) {
+  out := bytes.Clone(b)
+  var incs []include
+  blank := func(from, to int) {
+    for i := from; i < to; i++ {
+      if out[i] != '\n' {
+        out[i] = ' '
+      }
+    }
+  }
+
+  for i := 0; i < len(b); i++ {
+    switch {
+    case b[i] == '"':
+      // Skip the string, honouring escapes.
+      for i++; i < len(b) && b[i] != '"'; i++ {
+        if b[i] == '\\' {
+          i++
+        }
+      }
+
+    case b[i] == '#' || (b[i] == '/' && i+1 < len(b) && b[i+1] == '/'):
+      end := bytes.IndexByte(b[i:], '\n')
+      if end < 0 {
+        end = len(b) - i
+      }
+      blank(i, i+end)
+      i += end - 1
+
+    case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+      end := bytes.Index(b[i+2:], []byte("*/"))
+      if end < 0 {
+        return nil, nil, positionError(file, b, i, "unterminated /* comment")
+      }
+      blank(i, i+2+end+2)
+      i += 2 + end + 1
+
+    case bytes.HasPrefix(b[i:], []byte("<?include")):
+      end := bytes.Index(b[i:], []byte("?>"))
+      if end < 0 {
+        return nil, nil, positionError(file, b, i, "unterminated <?include?> directive")
+      }
+      inner := bytes.TrimSpace(b[i+len("<?include") : i+end])
+      var path string
+      if err := json.Unmarshal(inner, &path); err != nil || path == "" {
+        return nil, nil, positionError(file, b, i, "include directive needs a quoted path")
+      }
+      incs = append(incs, include{start: i, end: i + end + 2, path: path})
+      blank(i, i+end+2)
+      i += end + 1
+    }
+  }
+  return out, incs, nil
+}
+
+// positionError builds a ConfigError for a byte offset of b.
+func positionError(file string, b []byte, off int, msg string) *ConfigError {
+  if off > len(b) {
+    off = len(b)
+  }
+  line := 1 + bytes.Count(b[:off], []byte{'\n'})
+  col := off - bytes.LastIndexByte(b[:off], '\n')
+  return &ConfigError{File: file, Line: line, Col: col, Msg: msg}
+}
+
+// segment records where a piece of the expanded text came from.
+type segment struct {
+  out, from, len int
+  file           string
+  raw            []byte
+}
+
+// expander inlines include directives, remembering the origin of every
+// piece of output for error messages.
+type expander struct {
+  read     func(path string) ([]byte, error)
+  out      bytes.Buffer
+  segments []segment
+  files    []string
+}
+
+func (e *expander) expand(file string, depth int) error {
+  if depth > maxIncludeDepth {
+    return &ConfigError{File: file, Msg: "includes nested too deeply (cycle?)"}
+  }
+  raw, err := e.read(file)
+  if err != nil {
+    return err
+  }
+  e.files = append(e.files, file)
+
+  clean, incs, err := scanJSONC(file, raw)
+  if err != nil {
+    return err
+  }
+
+  emit := func(from, to int) {
+    if to > from {
+      e.segments = append(e.segments, segment{out: e.out.Len(), from: from, len: to - from, file: file, raw: raw})
+      e.out.Write(clean[from:to])
+    }
+  }
+  pos := 0
+  for _, inc := range incs {
+    emit(pos, inc.start)
+    p := inc.path
+    if !filepath.IsAbs(p) {
+      p = filepath.Join(filepath.Dir(file), p)
+    }
+    if err := e.expand(p, depth+1); err != nil {
+      var ce *ConfigError
+      if errors.As(err, &ce) {
+        return err
+      }
+      return positionError(file, raw, inc.start, err.Error())
+    }
+    pos = inc.end
+  }
+  emit(pos, len(clean))
+  return nil
+}
+
+// locate maps an offset of the expanded text back to its file.
+func (e *expander) locate(off int64, msg string) error {
+  i := sort.Search(len(e.segments), func(i int) bool {
+    s := e.segments[i]
+    return int64(s.out+s.len) > off
+  })
+  if i == len(e.segments) {
+    i--
+  }
+  if i < 0 {
+    return &ConfigError{File: "config", Msg: msg}
+  }
+  s := e.segments[i]
+  return positionError(s.file, s.raw, s.from+int(off)-s.out, msg)
+}
+
+// ParseConfigFile reads a Kea configuration file with its includes and
+// returns the top-level object, keyed by "Dhcp4", "Control-agent", ...
+// read is used for every file, so callers can substitute unsaved
+// content. Files lists the file and everything it includes.
+func ParseConfigFile(path string, read func(string) ([]byte, error)) (root map[string]json.RawMessage, files []string, err error) {
+  if read == nil {
+    read = os.ReadFile
+  }
+  e := &expander{read: read}
+  if err := e.expand(path, 0); err != nil {
+    return nil, e.files, err
+  }
+
+  dec := json.NewDecoder(bytes.NewReader(e.out.Bytes()))
+  if err := dec.Decode(&root); err != nil {
+    var se *json.SyntaxError
+    var te *json.UnmarshalTypeError
+    switch {
+    case errors.As(err, &se):
+      return nil, e.files, e.locate(se.Offset-1, se.Error())
+    case errors.As(err, &te):
+      return nil, e.files, e.locate(te.Offset-1, "the configuration must be a JSON object")
+    }
+    return nil, e.files, &ConfigError{File: path, Msg: err.Error()}
+  }
+  if dec.More() {
+    return nil, e.files, e.locate(dec.InputOffset(), "unexpected content after the configuration object")
+  }
+  return root, e.files, nil
+}
@@ -0,0 +1,134 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Lease is a lease as returned by lease_cmds. DHCPv4 leases use
+// HWAddress/ClientID, DHCPv6 leases DUID/IAID/Type.
+type Lease struct {
+  IPAddress    string `json:"ip-address"`
+  HWAddress    string `json:"hw-address,omitempty"`
+  ClientID     string `json:"client-id,omitempty"`
+  DUID         string `json:"duid,omitempty"`
+  IAID         int64  `json:"iaid,omitempty"`
+  Type         string `json:"type,omitempty"`
+  PrefixLen    int    `json:"prefix-len,omitempty"`
+  SubnetID     int64  `json:"subnet-id"`
+  ValidLft     int64  `json:"valid-lft"`
+  PreferredLft int64  `json:"preferred-lft,omitempty"`
+  CLTT         int64  `json:"cltt"`
+  FQDNFwd      bool   `json:"fqdn-fwd"`
+  FQDNRev      bool   `json:"fqdn-rev"`
+  Hostname     string `json:"hostname"`
+  State        int    `json:"state"`
+}
+
+// Lease states as reported by lease_cmds.
+const (
+  LeaseStateDefault   = 0
+  LeaseStateDeclined  = 1
+  LeaseStateReclaimed = 2
+  LeaseStateReleased  = 3
+)
+
+// Expires returns when the lease expires.
+func (l Lease) Expires() time.Time {
+  return time.Unix(l.CLTT+l.ValidLft, 0)
+}
+
+// LastSeen returns the client's last transmission time.
+func (l Lease) LastSeen() time.Time {
+  return time.Unix(l.CLTT, 0)
+}
+
+// leasePageSize is the number of leases fetched per lease[46]-get-page.
+const leasePageSize = 1000
+
+// ForEachLease pages through every lease of service (lease4-get-page or
+// lease6-get-page) and calls fn for each. Returning an error from fn
+// stops the iteration and is returned.
+func (c *Client) ForEachLease(ctx context.Context, service string, fn func(Lease) error) error {
+  return c.ForEachLeaseAfter(ctx, service, netip.Addr{}, fn)
+}
+
+// ForEachLeaseAfter is ForEachLease starting past the address after,
+// from the start when it is the zero Addr. Leases come in address order.
+func (c *Client) ForEachLeaseAfter(ctx context.Context, service string, after netip.Addr, fn func(Lease) error) error {
+  command := "lease4-get-page"
+  if service == ServiceDHCP6 {
+    command = "lease6-get-page"
+  }
+
+  from := "start"
+  if after.IsValid() {
+    from = after.String()
+  }
+  for {
+    var page struct {
+      Leases []Lease `json:"leases"`
+      Count  int     `json:"count"`
+    }
+    err := c.Call(ctx, command, service, map[string]any{"from": from, "limit": leasePageSize}, &page)
+    if errors.Is(err, ErrEmpty) {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+
+    for _, l := range page.Leases {
+      if err := fn(l); err != nil {
+        return err
+      }
+    }
+    if len(page.Leases) < leasePageSize {
+      return nil
+    }
+    from = page.Leases[len(page.Leases)-1].IPAddress
+
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+  }
+}
+
+// LeaseDatabase is a daemon's "lease-database" entry.
+type LeaseDatabase struct {
+  Type    string `json:"type"`
+  Name    string `json:"name,omitempty"`
+  Host    string `json:"host,omitempty"`
+  Persist *bool  `json:"persist,omitempty"`
+}
+
+// LeaseDatabaseFromConfig extracts the lease database from a config-get
+// element.
+func LeaseDatabaseFromConfig(cfg map[string]json.RawMessage) (LeaseDatabase, error) {
+  db := LeaseDatabase{Type: "memfile"}
+  if raw, ok := cfg["lease-database"]; ok {
+    if err := json.Unmarshal(raw, &db); err != nil {
+      return db, fmt.Errorf("decode lease-database: %w", err)
+    }
+  }
+  return db, nil
+}
+
+// LeaseFile returns the memfile CSV path, falling back to the packaged
+// default, or "" if leases aren't kept in a file.
+func (d LeaseDatabase) LeaseFile(service string) string {
+  if d.Type != "memfile" || (d.Persist != nil && !*d.Persist) {
+    return ""
+  }
+  if d.Name != "" {
+    return d.Name
+  }
+  if service == ServiceDHCP6 {
+    return "/var/lib/kea/kea-leases6.csv"
+  }
+  return "/var/lib/kea/kea-leases4.csv"
+}
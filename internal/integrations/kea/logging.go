@@ -0,0 +1,92 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Logger is an entry of a daemon's "loggers" list.
+type Logger struct {
+  Name          string      `json:"name"`
+  Severity      string      `json:"severity,omitempty"`
+  DebugLevel    int         `json:"debuglevel,omitempty"`
+  OutputOptions []LogOutput `json:"output-options,omitempty"`
+}
+
+// UnmarshalJSON also accepts "output_options", the spelling used before
+// Kea 2.5.
+func (l *Logger) UnmarshalJSON(b []byte) error {
+  type plain Logger
+  var v struct {
+    plain
+    Legacy []LogOutput `json:"output_options"`
+  }
+  if err := json.Unmarshal(b, &v); err != nil {
+    return err
+  }
+  *l = Logger(v.plain)
+  if len(l.OutputOptions) == 0 {
+    l.OutputOptions = v.Legacy
+  }
+  return nil
+}
+
+// LogOutput is one destination of a logger.
+type LogOutput struct {
+  Output  string `json:"output"`
+  Pattern string `json:"pattern,omitempty"`
+  Flush   *bool  `json:"flush,omitempty"`
+  MaxSize int64  `json:"maxsize,omitempty"`
+  MaxVer  int    `json:"maxver,omitempty"`
+}
+
+// IsFile reports whether the output is a file rather than stdout,
+// stderr or syslog.
+func (o LogOutput) IsFile() bool {
+  switch {
+  case o.Output == "", o.Output == "stdout", o.Output == "stderr":
+    return false
+  case o.Output == "syslog", strings.HasPrefix(o.Output, "syslog:"):
+    return false
+  }
+  return true
+}
+
+// Loggers returns the loggers of service's running configuration.
+func (c *Client) Loggers(ctx context.Context, service string) ([]Logger, error) {
+  cfg, err := c.ConfigGet(ctx, service)
+  if err != nil {
+    return nil, err
+  }
+  return LoggersFromConfig(cfg)
+}
+
+// LoggersFromConfig extracts the loggers from a config-get element.
+func LoggersFromConfig(cfg map[string]json.RawMessage) ([]Logger, error) {
+  raw, ok := cfg["loggers"]
+  if !ok {
+    return nil, nil
+  }
+  var loggers []Logger
+  if err := json.Unmarshal(raw, &loggers); err != nil {
+    return nil, fmt.Errorf("decode loggers: %w", err)
+  }
+  return loggers, nil
+}
+
+// LogFiles returns the distinct file outputs of loggers.
+func LogFiles(loggers []Logger) []string {
+  var out []string
+  seen := map[string]bool{}
+  for _, l := range loggers {
+    for _, o := range l.OutputOptions {
+      if o.IsFile() && !seen[o.Output] {
+        seen[o.Output] = true
+        out = append(out, o.Output)
+      }
+    }
+  }
+  return out
+}
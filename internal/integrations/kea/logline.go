@@ -0,0 +1,126 @@
+package kea
+
+import (
+	"strings"
+	"time"
+)
+
+// Log severities, most severe first.
+var LogSeverities = []string{"FATAL", "ERROR", "WARN", "INFO", "DEBUG"}
+
+// severityRank orders severities; unknown ones sort last.
+func severityRank(s string) int {
+  for i, v := range LogSeverities {
+    if v == s {
+      return i
+    }
+  }
+  return len(LogSeverities)
+}
+
+// logTimeLayout matches the default pattern's "%D{%Y-%m-%d %H:%M:%S.%q}".
+const logTimeLayout = "2006-01-02 15:04:05.000"
+
+// LogLine is a line written with Kea's default log pattern:
+//
+//   2024-01-15 10:23:45.123 INFO  [kea-dhcp4.dhcpsrv/1234.140] DHCPSRV_MEMFILE_DB opening memory file lease database: type=memfile
+//
+// Continuation lines of multi-line messages only have Raw set.
+type LogLine struct {
+  Time      time.Time `json:"time"`
+  Severity  string    `json:"severity"`
+  Logger    string    `json:"logger"`
+  MessageID string    `json:"msgid"`
+  Message   string    `json:"message"`
+  Raw       string    `json:"raw"`
+}
+
+// Parsed reports whether the line had a header.
+func (l LogLine) Parsed() bool {
+  return l.Severity != ""
+}
+
+// ParseLogLine splits a log line into its fields. Lines in another
+// format (custom patterns, continuation lines) come back with only Raw.
+func ParseLogLine(s string) LogLine {
+  l := LogLine{Raw: s}
+  if len(s) < len(logTimeLayout)+2 {
+    return l
+  }
+  t, err := time.ParseInLocation(logTimeLayout, s[:len(logTimeLayout)], time.Local)
+  if err != nil {
+    return l
+  }
+
+  rest := strings.TrimLeft(s[len(logTimeLayout):], " ")
+  sev, rest, ok := strings.Cut(rest, " ")
+  if !ok || severityRank(sev) == len(LogSeverities) {
+    return l
+  }
+  rest = strings.TrimLeft(rest, " ")
+  if !strings.HasPrefix(rest, "[") {
+    return l
+  }
+  src, rest, ok := strings.Cut(rest[1:], "] ")
+  if !ok {
+    return l
+  }
+  logger, _, _ := strings.Cut(src, "/")
+
+  l.Time, l.Severity, l.Logger = t, sev, logger
+  l.MessageID, l.Message, _ = strings.Cut(rest, " ")
+  return l
+}
+
+// LogFilter selects log lines. Empty fields match everything.
+type LogFilter struct {
+  // Severity is the least severe level shown: "WARN" shows FATAL, ERROR
+  // and WARN.
+  Severity string
+  // Logger matches the logger name or a parent of it ("kea-dhcp4"
+  // matches "kea-dhcp4.leases").
+  Logger string
+  // MessageID matches a message ID prefix, case-insensitively
+  // ("DHCP4_LEASE" matches DHCP4_LEASE_ALLOC).
+  MessageID string
+  // Text is a case-insensitive substring of the whole line.
+  Text string
+}
+
+// Empty reports whether the filter matches everything.
+func (f LogFilter) Empty() bool {
+  return f == LogFilter{}
+}
+
+// LogMatcher applies a LogFilter to consecutive lines of one file.
+// Continuation lines are judged by the header line before them.
+type LogMatcher struct {
+  Filter LogFilter
+  last   LogLine
+}
+
+// Match parses raw and reports whether it passes the filter.
+func (m *LogMatcher) Match(raw string) (LogLine, bool) {
+  l := ParseLogLine(raw)
+  hdr := l
+  if l.Parsed() {
+    m.last = l
+  } else {
+    hdr = m.last
+  }
+
+  f := m.Filter
+  if f.Severity != "" && (!hdr.Parsed() || severityRank(hdr.Severity) > severityRank(f.Severity)) {
+    return l, false
+  }
+  if f.Logger != "" && hdr.Logger != f.Logger && !strings.HasPrefix(hdr.Logger, f.Logger+".") {
+    return l, false
+  }
+  if f.MessageID != "" && !strings.HasPrefix(strings.ToUpper(hdr.MessageID), strings.ToUpper(f.MessageID)) {
+    return l, false
+  }
+  if f.Text != "" && !strings.Contains(strings.ToLower(raw), strings.ToLower(f.Text)) {
+    return l, false
+  }
+  return l, true
+}
@@ -0,0 +1,343 @@
+package kea
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OptionDef describes a standard option as Kea names it.
+type OptionDef struct {
+  Code  int
+  Name  string
+  Type  string
+  Array bool
+}
+
+// StdOptions4 holds the standard DHCPv4 option definitions known to Kea.
+var StdOptions4 = []OptionDef{
+  {1, "subnet-mask", "ipv4-address", false},
+  {2, "time-offset", "int32", false},
+  {3, "routers", "ipv4-address", true},
+  {4, "time-servers", "ipv4-address", true},
+  {5, "name-servers", "ipv4-address", true},
+  {6, "domain-name-servers", "ipv4-address", true},
+  {7, "log-servers", "ipv4-address", true},
+  {8, "cookie-servers", "ipv4-address", true},
+  {9, "lpr-servers", "ipv4-address", true},
+  {10, "impress-servers", "ipv4-address", true},
+  {11, "resource-location-servers", "ipv4-address", true},
+  {12, "host-name", "string", false},
+  {13, "boot-size", "uint16", false},
+  {14, "merit-dump", "string", false},
+  {15, "domain-name", "fqdn", false},
+  {16, "swap-server", "ipv4-address", false},
+  {17, "root-path", "string", false},
+  {18, "extensions-path", "string", false},
+  {19, "ip-forwarding", "boolean", false},
+  {20, "non-local-source-routing", "boolean", false},
+  {21, "policy-filter", "ipv4-address", true},
+  {22, "max-dgram-reassembly", "uint16", false},
+  {23, "default-ip-ttl", "uint8", false},
+  {24, "path-mtu-aging-timeout", "uint32", false},
+  {25, "path-mtu-plateau-table", "uint16", true},
+  {26, "interface-mtu", "uint16", false},
+  {27, "all-subnets-local", "boolean", false},
+  {28, "broadcast-address", "ipv4-address", false},
+  {29, "perform-mask-discovery", "boolean", false},
+  {30, "mask-supplier", "boolean", false},
+  {31, "router-discovery", "boolean", false},
+  {32, "router-solicitation-address", "ipv4-address", false},
+  {33, "static-routes", "ipv4-address", true},
+  {34, "trailer-encapsulation", "boolean", false},
+  {35, "arp-cache-timeout", "uint32", false},
+  {36, "ieee802-3-encapsulation", "boolean", false},
+  {37, "default-tcp-ttl", "uint8", false},
+  {38, "tcp-keepalive-interval", "uint32", false},
+  {39, "tcp-keepalive-garbage", "boolean", false},
+  {40, "nis-domain", "string", false},
+  {41, "nis-servers", "ipv4-address", true},
+  {42, "ntp-servers", "ipv4-address", true},
+  {43, "vendor-encapsulated-options", "empty", false},
+  {44, "netbios-name-servers", "ipv4-address", true},
+  {45, "netbios-dd-server", "ipv4-address", true},
+  {46, "netbios-node-type", "uint8", false},
+  {47, "netbios-scope", "string", false},
+  {48, "font-servers", "ipv4-address", true},
+  {49, "x-display-manager", "ipv4-address", true},
+  {50, "dhcp-requested-address", "ipv4-address", false},
+  {51, "dhcp-lease-time", "uint32", false},
+  {52, "dhcp-option-overload", "uint8", false},
+  {53, "dhcp-message-type", "uint8", false},
+  {54, "dhcp-server-identifier", "ipv4-address", false},
+  {55, "dhcp-parameter-request-list", "uint8", true},
+  {56, "dhcp-message", "string", false},
+  {57, "dhcp-max-message-size", "uint16", false},
+  {58, "dhcp-renewal-time", "uint32", false},
+  {59, "dhcp-rebinding-time", "uint32", false},
+  {60, "vendor-class-identifier", "string", false},
+  {61, "dhcp-client-identifier", "binary", false},
+  {62, "nwip-domain-name", "string", false},
+  {63, "nwip-suboptions", "binary", false},
+  {64, "nisplus-domain-name", "string", false},
+  {65, "nisplus-servers", "ipv4-address", true},
+  {66, "tftp-server-name", "string", false},
+  {67, "boot-file-name", "string", false},
+  {68, "mobile-ip-home-agent", "ipv4-address", true},
+  {69, "smtp-server", "ipv4-address", true},
+  {70, "pop-server", "ipv4-address", true},
+  {71, "nntp-server", "ipv4-address", true},
+  {72, "www-server", "ipv4-address", true},
+  {73, "finger-server", "ipv4-address", true},
+  {74, "irc-server", "ipv4-address", true},
+  {75, "streettalk-server", "ipv4-address", true},
+  {76, "streettalk-directory-assistance-server", "ipv4-address", true},
+  {77, "user-class", "binary", false},
+  {78, "slp-directory-agent", "record", false},
+  {79, "slp-service-scope", "record", false},
+  {81, "fqdn", "record", false},
+  {82, "dhcp-agent-options", "empty", false},
+  {85, "nds-servers", "ipv4-address", true},
+  {86, "nds-tree-name", "string", false},
+  {87, "nds-context", "string", false},
+  {88, "bcms-controller-names", "fqdn", true},
+  {89, "bcms-controller-address", "ipv4-address", true},
+  {90, "authenticate", "binary", false},
+  {91, "client-last-transaction-time", "uint32", false},
+  {92, "associated-ip", "ipv4-address", true},
+  {93, "client-system", "uint16", true},
+  {94, "client-ndi", "record", false},
+  {97, "uuid-guid", "record", false},
+  {98, "uap-servers", "string", false},
+  {99, "geoconf-civic", "binary", false},
+  {100, "pcode", "string", false},
+  {101, "tcode", "string", false},
+  {108, "v6-only-preferred", "uint32", false},
+  {112, "netinfo-server-address", "ipv4-address", true},
+  {113, "netinfo-server-tag", "string", false},
+  {114, "v4-captive-portal", "string", false},
+  {116, "auto-config", "uint8", false},
+  {117, "name-service-search", "uint16", true},
+  {118, "subnet-selection", "ipv4-address", false},
+  {119, "domain-search", "fqdn", true},
+  {121, "classless-static-route", "internal", false},
+  {124, "vivco-suboptions", "record", false},
+  {125, "vivso-suboptions", "uint32", false},
+  {136, "pana-agent", "ipv4-address", true},
+  {137, "v4-lost", "fqdn", false},
+  {138, "capwap-ac-v4", "ipv4-address", true},
+  {141, "sip-ua-cs-domains", "fqdn", true},
+  {146, "rdnss-selection", "record", true},
+  {159, "v4-portparams", "record", false},
+  {162, "v4-dnr", "record", false},
+  {212, "option-6rd", "record", true},
+  {213, "v4-access-domain", "fqdn", false},
+}
+
+// StdOptions6 holds the standard DHCPv6 option definitions known to Kea.
+var StdOptions6 = []OptionDef{
+  {1, "clientid", "binary", false},
+  {2, "serverid", "binary", false},
+  {3, "ia-na", "record", false},
+  {4, "ia-ta", "uint32", false},
+  {5, "iaaddr", "record", false},
+  {6, "oro", "uint16", true},
+  {7, "preference", "uint8", false},
+  {8, "elapsed-time", "uint16", false},
+  {9, "relay-msg", "binary", false},
+  {11, "auth", "record", false},
+  {12, "unicast", "ipv6-address", false},
+  {13, "status-code", "record", false},
+  {14, "rapid-commit", "empty", false},
+  {15, "user-class", "binary", false},
+  {16, "vendor-class", "record", false},
+  {17, "vendor-opts", "uint32", false},
+  {18, "interface-id", "binary", false},
+  {19, "reconf-msg", "uint8", false},
+  {20, "reconf-accept", "empty", false},
+  {21, "sip-server-dns", "fqdn", true},
+  {22, "sip-server-addr", "ipv6-address", true},
+  {23, "dns-servers", "ipv6-address", true},
+  {24, "domain-search", "fqdn", true},
+  {25, "ia-pd", "record", false},
+  {26, "iaprefix", "record", false},
+  {27, "nis-servers", "ipv6-address", true},
+  {28, "nisp-servers", "ipv6-address", true},
+  {29, "nis-domain-name", "fqdn", true},
+  {30, "nisp-domain-name", "fqdn", true},
+  {31, "sntp-servers", "ipv6-address", true},
+  {32, "information-refresh-time", "uint32", false},
+  {33, "bcmcs-server-dns", "fqdn", true},
+  {34, "bcmcs-server-addr", "ipv6-address", true},
+  {36, "geoconf-civic", "record", false},
+  {37, "remote-id", "record", false},
+  {38, "subscriber-id", "binary", false},
+  {39, "client-fqdn", "record", false},
+  {40, "pana-agent", "ipv6-address", true},
+  {41, "new-posix-timezone", "string", false},
+  {42, "new-tzdb-timezone", "string", false},
+  {43, "ero", "uint16", true},
+  {44, "lq-query", "record", false},
+  {45, "client-data", "empty", false},
+  {46, "clt-time", "uint32", false},
+  {47, "lq-relay-data", "record", false},
+  {48, "lq-client-link", "ipv6-address", true},
+  {56, "ntp-server", "empty", false},
+  {59, "bootfile-url", "string", false},
+  {60, "bootfile-param", "tuple", true},
+  {61, "client-arch-type", "uint16", true},
+  {62, "nii", "record", false},
+  {64, "aftr-name", "fqdn", false},
+  {65, "erp-local-domain-name", "fqdn", false},
+  {66, "rsoo", "empty", false},
+  {67, "pd-exclude", "binary", false},
+  {74, "rdnss-selection", "record", true},
+  {79, "client-linklayer-addr", "binary", false},
+  {80, "link-address", "ipv6-address", false},
+  {82, "sol-max-rt", "uint32", false},
+  {83, "inf-max-rt", "uint32", false},
+  {88, "dhcpv4-message", "binary", false},
+  {89, "dhcp4o6-server-addr", "ipv6-address", true},
+  {103, "v6-captive-portal", "string", false},
+  {143, "ipv6-address-andsf", "ipv6-address", true},
+  {144, "v6-dnr", "record", false},
+}
+
+// StdOption looks up a standard option of family (4 or 6) by code.
+func StdOption(family, code int) (OptionDef, bool) {
+  for _, d := range stdOptions(family) {
+    if d.Code == code {
+      return d, true
+    }
+  }
+  return OptionDef{}, false
+}
+
+// StdOptionByName looks up a standard option of family by name.
+func StdOptionByName(family int, name string) (OptionDef, bool) {
+  for _, d := range stdOptions(family) {
+    if d.Name == name {
+      return d, true
+    }
+  }
+  return OptionDef{}, false
+}
+
+// OptionName returns the Kea name of a standard option, or "option-<code>".
+func OptionName(family, code int) string {
+  if d, ok := StdOption(family, code); ok {
+    return d.Name
+  }
+  return "option-" + strconv.Itoa(code)
+}
+
+// ResolveOption fills in whichever of Name and Code is missing for a
+// standard option in the default space. It reports false when the option
+// is neither standard nor carries a code.
+func ResolveOption(family int, o *OptionData) bool {
+  if o.Space != "" && o.Space != DefaultSpace(family) {
+    return o.Code != 0
+  }
+  switch {
+  case o.Code == 0 && o.Name != "":
+    d, ok := StdOptionByName(family, o.Name)
+    if !ok {
+      return false
+    }
+    o.Code = d.Code
+  case o.Name == "" && o.Code != 0:
+    if d, ok := StdOption(family, o.Code); ok {
+      o.Name = d.Name
+    }
+  }
+  return o.Code != 0
+}
+
+// DefaultSpace is the option space of top-level options for family.
+func DefaultSpace(family int) string {
+  if family == 6 {
+    return "dhcp6"
+  }
+  return "dhcp4"
+}
+
+func stdOptions(family int) []OptionDef {
+  if family == 6 {
+    return StdOptions6
+  }
+  return StdOptions4
+}
+
+// FormatOptions renders option-data as "name=data; name=data", the
+// compact form used in CSV files.
+func FormatOptions(opts []OptionData) string {
+  parts := make([]string, 0, len(opts))
+  for _, o := range opts {
+    name := o.Name
+    if name == "" {
+      name = strconv.Itoa(o.Code)
+    }
+    parts = append(parts, name+"="+o.Data)
+  }
+  return strings.Join(parts, "; ")
+}
+
+// ParseOptions parses the compact "name=data; name=data" form. A name
+// that is a number is taken as an option code.
+func ParseOptions(s string) ([]OptionData, error) {
+  var out []OptionData
+  for _, part := range strings.Split(s, ";") {
+    part = strings.TrimSpace(part)
+    if part == "" {
+      continue
+    }
+    name, data, ok := strings.Cut(part, "=")
+    if !ok {
+      return nil, &OptionSyntaxError{Text: part}
+    }
+    name = strings.TrimSpace(name)
+    o := OptionData{Data: strings.TrimSpace(data)}
+    if code, err := strconv.Atoi(name); err == nil {
+      o.Code = code
+    } else {
+      o.Name = name
+    }
+    out = append(out, o)
+  }
+  return out, nil
+}
+
+// OptionSyntaxError reports an option that is not written as name=data.
+type OptionSyntaxError struct {
+  Text string
+}
+
+func (e *OptionSyntaxError) Error() string {
+  return "option " + strconv.Quote(e.Text) + " is not name=data"
+}
+
+// ConfigOptionDef is an option-def entry defining a custom option.
+type ConfigOptionDef struct {
+  Name  string `json:"name"`
+  Code  int    `json:"code"`
+  Type  string `json:"type"`
+  Space string `json:"space,omitempty"`
+  Array bool   `json:"array,omitempty"`
+  // RecordTypes lists the fields of a record option, Encapsulate the
+  // option space an empty option carries.
+  RecordTypes string `json:"record-types,omitempty"`
+  Encapsulate string `json:"encapsulate,omitempty"`
+}
+
+// OptionDefsFromConfig extracts the custom option definitions of a
+// config-get element.
+func OptionDefsFromConfig(cfg map[string]json.RawMessage) ([]ConfigOptionDef, error) {
+  var defs []ConfigOptionDef
+  if raw, ok := cfg["option-def"]; ok {
+    if err := json.Unmarshal(raw, &defs); err != nil {
+      return nil, fmt.Errorf("decode option-def: %w", err)
+    }
+  }
+  return defs, nil
+}
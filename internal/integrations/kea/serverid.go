@@ -0,0 +1,137 @@
+package kea
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ServerIDFile is where kea-dhcp6 keeps the DUID it generated when the
+// configuration doesn't set one.
+const ServerIDFile = "/var/lib/kea/kea-dhcp6-serverid"
+
+// ServerIdentity is how a DHCP daemon's configuration says it shows up
+// on the wire, so its packets can be told apart from other servers'.
+type ServerIdentity struct {
+  // Interfaces are the interfaces-config entries; the server identifier
+  // of a DHCPv4 server is normally an address of one of them.
+  Interfaces []string
+  // ServerIDs are configured identifiers: dhcp-server-identifier
+  // option-data values (DHCPv4) or the server-id DUID (DHCPv6).
+  ServerIDs []string
+  // Peers are the hosts of the high-availability peers.
+  Peers []string
+  // DUIDFile is the file the DHCPv6 server keeps a generated DUID in,
+  // "" when the DUID is configured or not persisted.
+  DUIDFile string
+}
+
+// ServerIdentityFromConfig collects the identity of service from its
+// config-get element.
+func ServerIdentityFromConfig(service string, cfg map[string]json.RawMessage) ServerIdentity {
+  var id ServerIdentity
+  if ic, err := InterfacesConfigFromConfig(cfg); err == nil {
+    id.Interfaces = ic.Interfaces
+  }
+
+  if service == ServiceDHCP6 {
+    var sid struct {
+      Identifier string `json:"identifier"`
+      Persist    *bool  `json:"persist"`
+    }
+    _ = json.Unmarshal(cfg["server-id"], &sid)
+    switch {
+    case sid.Identifier != "":
+      id.ServerIDs = append(id.ServerIDs, sid.Identifier)
+    case sid.Persist == nil || *sid.Persist:
+      id.DUIDFile = ServerIDFile
+      if dir := dataDirectory(cfg); dir != "" {
+        id.DUIDFile = path.Join(dir, path.Base(ServerIDFile))
+      }
+    }
+  }
+
+  var walk func(v any)
+  walk = func(v any) {
+    switch v := v.(type) {
+    case map[string]any:
+      for k, child := range v {
+        switch k {
+        case "option-data":
+          id.ServerIDs = append(id.ServerIDs, serverIDOptions(service, child)...)
+        case "peers":
+          id.Peers = append(id.Peers, peerHosts(child)...)
+        default:
+          walk(child)
+        }
+      }
+    case []any:
+      for _, child := range v {
+        walk(child)
+      }
+    }
+  }
+  for k, raw := range cfg {
+    if k == "interfaces-config" {
+      continue
+    }
+    var v any
+    if json.Unmarshal(raw, &v) == nil {
+      walk(v)
+    }
+  }
+  return id
+}
+
+// dataDirectory returns the configured data-directory, if any.
+func dataDirectory(cfg map[string]json.RawMessage) string {
+  var dir string
+  _ = json.Unmarshal(cfg["data-directory"], &dir)
+  return dir
+}
+
+// serverIDOptions picks dhcp-server-identifier values out of an
+// option-data list. DHCPv6 has no such option.
+func serverIDOptions(service string, v any) []string {
+  list, _ := v.([]any)
+  if service == ServiceDHCP6 {
+    return nil
+  }
+  var out []string
+  for _, item := range list {
+    o, _ := item.(map[string]any)
+    if o == nil {
+      continue
+    }
+    name, _ := o["name"].(string)
+    code, _ := o["code"].(float64)
+    space, _ := o["space"].(string)
+    data, _ := o["data"].(string)
+    if (space == "" || space == "dhcp4") && (name == "dhcp-server-identifier" || int(code) == 54) && data != "" {
+      out = append(out, strings.TrimSpace(data))
+    }
+  }
+  return out
+}
+
+// peerHosts returns the hosts of the "url" of every HA peer.
+func peerHosts(v any) []string {
+  list, _ := v.([]any)
+  var out []string
+  for _, item := range list {
+    p, _ := item.(map[string]any)
+    raw, _ := p["url"].(string)
+    if raw == "" {
+      continue
+    }
+    u, err := url.Parse(raw)
+    if err != nil {
+      continue
+    }
+    if host := u.Hostname(); host != "" {
+      out = append(out, host)
+    }
+  }
+  return out
+}
@@ -1 +1,197 @@
-package kea
\ No newline at end of file
+package kea
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Services the Control Agent can forward commands to.
+const (
+  ServiceDHCP4 = "dhcp4"
+  ServiceDHCP6 = "dhcp6"
+  ServiceD2    = "d2"
+)
+
+// Result codes returned by Kea.
+const (
+  ResultSuccess     = 0
+  ResultError       = 1
+  ResultUnsupported = 2
+  ResultEmpty       = 3
+  ResultConflict    = 4
+)
+
+// ErrEmpty is returned by Call when Kea answers with ResultEmpty
+// (e.g. a list command with nothing to list).
+var ErrEmpty = errors.New("kea: empty result")
+
+// Response is a single answer from a Kea daemon.
+type Response struct {
+  Result    int             `json:"result"`
+  Text      string          `json:"text,omitempty"`
+  Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// CommandError is a non-success answer to a command.
+type CommandError struct {
+  Command string
+  Service string
+  Result  int
+  Text    string
+}
+
+func (e *CommandError) Error() string {
+  if e.Service != "" {
+    return fmt.Sprintf("kea %s (%s): result %d: %s", e.Command, e.Service, e.Result, e.Text)
+  }
+  return fmt.Sprintf("kea %s: result %d: %s", e.Command, e.Result, e.Text)
+}
+
+type request struct {
+  Command   string   `json:"command"`
+  Service   []string `json:"service,omitempty"`
+  Arguments any      `json:"arguments,omitempty"`
+}
+
+// CommandCheck vets a command before it is sent, e.g. against the
+// permissions of the user in ctx. An error refuses the command.
+type CommandCheck func(ctx context.Context, command string, services []string, args any) error
+
+// CommandHook is told of every command once it is answered, refused or
+// failed: resps are the answers, err why there are none.
+type CommandHook func(ctx context.Context, command string, services []string, args any, resps []Response, err error)
+
+// Client talks to the Kea Control Agent over HTTP.
+type Client struct {
+  cfg   Config
+  http  *http.Client
+  check CommandCheck
+  hook  CommandHook
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) *Client {
+  if cfg.Timeout <= 0 {
+    cfg.Timeout = defaultTimeout
+  }
+  return &Client{
+    cfg:  cfg,
+    http: &http.Client{Timeout: cfg.Timeout},
+  }
+}
+
+// Configured reports whether the client has somewhere to send commands.
+func (c *Client) Configured() bool {
+  return c != nil && c.cfg.URL != ""
+}
+
+// SetCommandCheck makes every command pass check first. It is meant to
+// be called once, before the client is used.
+func (c *Client) SetCommandCheck(check CommandCheck) {
+  c.check = check
+}
+
+// SetCommandHook has hook told of every command, e.g. to audit it. It
+// is meant to be called once, before the client is used.
+func (c *Client) SetCommandHook(hook CommandHook) {
+  c.hook = hook
+}
+
+// Do sends a raw command and returns every response. services may be
+// nil for commands handled by the Control Agent itself.
+func (c *Client) Do(ctx context.Context, command string, services []string, args any) (out []Response, err error) {
+  if !c.Configured() {
+    return nil, errors.New("kea: control agent URL not configured")
+  }
+  if c.hook != nil {
+    defer func() { c.hook(ctx, command, services, args, out, err) }()
+  }
+  if c.check != nil {
+    if err := c.check(ctx, command, services, args); err != nil {
+      return nil, fmt.Errorf("kea %s: %w", command, err)
+    }
+  }
+
+  body, err := json.Marshal(request{Command: command, Service: services, Arguments: args})
+  if err != nil {
+    return nil, fmt.Errorf("kea %s: encode: %w", command, err)
+  }
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  if c.cfg.Username != "" {
+    req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+  }
+
+  utils.Debug("kea -> %s %v", command, services)
+
+  resp, err := c.http.Do(req)
+  if err != nil {
+    return nil, fmt.Errorf("kea %s: %w", command, err)
+  }
+  defer resp.Body.Close()
+
+  data, err := io.ReadAll(resp.Body)
+  if err != nil {
+    return nil, fmt.Errorf("kea %s: read: %w", command, err)
+  }
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("kea %s: http %s", command, resp.Status)
+  }
+
+  // The Control Agent answers with an array when a service is given and
+  // with a bare object for its own commands.
+  if err := json.Unmarshal(data, &out); err != nil {
+    var single Response
+    if err2 := json.Unmarshal(data, &single); err2 != nil {
+      return nil, fmt.Errorf("kea %s: decode: %w", command, err)
+    }
+    out = []Response{single}
+  }
+
+  return out, nil
+}
+
+// Call sends command to a single service and decodes the arguments of
+// the answer into out (which may be nil).
+func (c *Client) Call(ctx context.Context, command, service string, args any, out any) error {
+  var services []string
+  if service != "" {
+    services = []string{service}
+  }
+
+  resps, err := c.Do(ctx, command, services, args)
+  if err != nil {
+    return err
+  }
+  if len(resps) == 0 {
+    return fmt.Errorf("kea %s: no response", command)
+  }
+
+  r := resps[0]
+  switch r.Result {
+  case ResultSuccess:
+  case ResultEmpty:
+    return ErrEmpty
+  default:
+    return &CommandError{Command: command, Service: service, Result: r.Result, Text: r.Text}
+  }
+
+  if out == nil || len(r.Arguments) == 0 {
+    return nil
+  }
+  if err := json.Unmarshal(r.Arguments, out); err != nil {
+    return fmt.Errorf("kea %s: decode arguments: %w", command, err)
+  }
+  return nil
+}
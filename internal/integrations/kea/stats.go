@@ -0,0 +1,98 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatSample is one recorded value of a statistic.
+type StatSample struct {
+  Value     float64
+  Timestamp time.Time
+}
+
+// UnmarshalJSON decodes Kea's [value, "timestamp"] pairs.
+func (s *StatSample) UnmarshalJSON(b []byte) error {
+  var pair []json.RawMessage
+  if err := json.Unmarshal(b, &pair); err != nil {
+    return err
+  }
+  if len(pair) > 0 {
+    if err := json.Unmarshal(pair[0], &s.Value); err != nil {
+      // Some statistics hold strings; they are not useful as numbers.
+      s.Value = 0
+    }
+  }
+  if len(pair) > 1 {
+    var ts string
+    if err := json.Unmarshal(pair[1], &ts); err == nil {
+      s.Timestamp, _ = time.ParseInLocation("2006-01-02 15:04:05.999999", ts, time.Local)
+    }
+  }
+  return nil
+}
+
+// Statistics maps a statistic name to its samples, newest first.
+type Statistics map[string][]StatSample
+
+// Latest returns the newest value of name, or 0.
+func (s Statistics) Latest(name string) float64 {
+  if samples := s[name]; len(samples) > 0 {
+    return samples[0].Value
+  }
+  return 0
+}
+
+// StatisticGetAll returns every statistic of service.
+func (c *Client) StatisticGetAll(ctx context.Context, service string) (Statistics, error) {
+  var out Statistics
+  err := c.Call(ctx, "statistic-get-all", service, map[string]any{}, &out)
+  return out, err
+}
+
+// SubnetStats holds the per-subnet statistics Kea keeps in memory.
+type SubnetStats struct {
+  SubnetID int64
+  Values   map[string]float64
+}
+
+// BySubnet groups subnet[N].<name> statistics by subnet ID, skipping the
+// per-pool ones. The result is sorted by subnet ID.
+func (s Statistics) BySubnet() []SubnetStats {
+  subnets := map[int64]*SubnetStats{}
+  for name, samples := range s {
+    if !strings.HasPrefix(name, "subnet[") || len(samples) == 0 {
+      continue
+    }
+    end := strings.Index(name, "].")
+    if end < 0 {
+      continue
+    }
+    id, err := strconv.ParseInt(name[len("subnet["):end], 10, 64)
+    if err != nil {
+      continue
+    }
+    stat := name[end+2:]
+    if strings.Contains(stat, "[") {
+      continue
+    }
+
+    sub, ok := subnets[id]
+    if !ok {
+      sub = &SubnetStats{SubnetID: id, Values: map[string]float64{}}
+      subnets[id] = sub
+    }
+    sub.Values[stat] = samples[0].Value
+  }
+
+  out := make([]SubnetStats, 0, len(subnets))
+  for _, sub := range subnets {
+    out = append(out, *sub)
+  }
+  sort.Slice(out, func(i, j int) bool { return out[i].SubnetID < out[j].SubnetID })
+  return out
+}
@@ -1 +1,71 @@
-package kea
\ No newline at end of file
+package kea
+
+import (
+	"context"
+)
+
+// Status is the answer to status-get.
+type Status struct {
+  PID            int   `json:"pid"`
+  Uptime         int64 `json:"uptime"`
+  Reload         int64 `json:"reload"`
+  MultiThreading bool  `json:"multi-threading-enabled"`
+  // HighAvailability is filled in by DHCP servers running the HA hook,
+  // one entry per relationship.
+  HighAvailability []HARelationship `json:"high-availability,omitempty"`
+}
+
+// HARelationship is the state of an HA pair as the local server sees it.
+type HARelationship struct {
+  Mode    string `json:"ha-mode"`
+  Servers struct {
+    Local  HALocal  `json:"local"`
+    Remote HARemote `json:"remote"`
+  } `json:"ha-servers"`
+}
+
+// HALocal is the local server of an HA relationship.
+type HALocal struct {
+  ServerName string   `json:"server-name,omitempty"`
+  Role       string   `json:"role"`
+  Scopes     []string `json:"scopes"`
+  State      string   `json:"state"`
+}
+
+// HARemote is the partner as last heard from. Age is the number of
+// seconds since then.
+type HARemote struct {
+  ServerName               string   `json:"server-name,omitempty"`
+  Role                     string   `json:"role"`
+  LastScopes               []string `json:"last-scopes"`
+  LastState                string   `json:"last-state"`
+  Age                      int64    `json:"age"`
+  InTouch                  bool     `json:"in-touch"`
+  CommunicationInterrupted bool     `json:"communication-interrupted"`
+  ConnectingClients        int64    `json:"connecting-clients"`
+  UnackedClients           int64    `json:"unacked-clients"`
+  UnackedClientsLeft       int64    `json:"unacked-clients-left"`
+  AnalyzedPackets          int64    `json:"analyzed-packets"`
+}
+
+// StatusGet returns the daemon status of service.
+func (c *Client) StatusGet(ctx context.Context, service string) (Status, error) {
+  var st Status
+  err := c.Call(ctx, "status-get", service, nil, &st)
+  return st, err
+}
+
+// VersionGet returns the version string reported by service.
+func (c *Client) VersionGet(ctx context.Context, service string) (string, error) {
+  resps, err := c.Do(ctx, "version-get", []string{service}, nil)
+  if err != nil {
+    return "", err
+  }
+  if len(resps) == 0 {
+    return "", &CommandError{Command: "version-get", Service: service, Result: ResultError, Text: "no response"}
+  }
+  if r := resps[0]; r.Result != ResultSuccess {
+    return "", &CommandError{Command: "version-get", Service: service, Result: r.Result, Text: r.Text}
+  }
+  return resps[0].Text, nil
+}
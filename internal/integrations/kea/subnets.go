@@ -0,0 +1,239 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// OptionData is an entry of an option-data list.
+type OptionData struct {
+  Name       string `json:"name,omitempty"`
+  Code       int    `json:"code,omitempty"`
+  Space      string `json:"space,omitempty"`
+  Data       string `json:"data,omitempty"`
+  CSVFormat  *bool  `json:"csv-format,omitempty"`
+  AlwaysSend bool   `json:"always-send,omitempty"`
+  NeverSend  bool   `json:"never-send,omitempty"`
+}
+
+// Pool is an address pool inside a subnet.
+type Pool struct {
+  Pool        string       `json:"pool"`
+  ClientClass string       `json:"client-class,omitempty"`
+  OptionData  []OptionData `json:"option-data,omitempty"`
+  ClassGuard
+}
+
+// PDPool is a prefix delegation pool inside a DHCPv6 subnet.
+type PDPool struct {
+  Prefix       string       `json:"prefix"`
+  PrefixLen    int          `json:"prefix-len"`
+  DelegatedLen int          `json:"delegated-len"`
+  ClientClass  string       `json:"client-class,omitempty"`
+  OptionData   []OptionData `json:"option-data,omitempty"`
+  ClassGuard
+}
+
+// ClassGuard holds the class settings shared by networks, subnets and
+// pools. Kea 2.7 renamed require-client-classes to
+// evaluate-additional-classes and allows a list of client-classes.
+type ClassGuard struct {
+  ClientClasses        []string `json:"client-classes,omitempty"`
+  RequireClientClasses []string `json:"require-client-classes,omitempty"`
+  EvaluateAdditional   []string `json:"evaluate-additional-classes,omitempty"`
+}
+
+// Additional returns the classes evaluated after the lease is chosen.
+func (g ClassGuard) Additional() []string {
+  return append(append([]string(nil), g.RequireClientClasses...), g.EvaluateAdditional...)
+}
+
+// Allows reports whether a client in classes may use something guarded
+// by class (the single client-class) and g. Without any class it is
+// open to all clients.
+func (g ClassGuard) Allows(class string, classes map[string]bool) bool {
+  guards := g.ClientClasses
+  if class != "" {
+    guards = append([]string{class}, guards...)
+  }
+  if len(guards) == 0 {
+    return true
+  }
+  for _, c := range guards {
+    if classes[c] {
+      return true
+    }
+  }
+  return false
+}
+
+// Relay lists the relay addresses a network or subnet is selected by.
+type Relay struct {
+  IPAddresses []string `json:"ip-addresses,omitempty"`
+  IPAddress   string   `json:"ip-address,omitempty"`
+}
+
+// Has reports whether addr is one of the relay addresses.
+func (r *Relay) Has(addr netip.Addr) bool {
+  if r == nil {
+    return false
+  }
+  for _, s := range append([]string{r.IPAddress}, r.IPAddresses...) {
+    if a, err := netip.ParseAddr(s); err == nil && a == addr {
+      return true
+    }
+  }
+  return false
+}
+
+// Range returns the first and last address of the pool. Pools are
+// written either as "first-last" or as a prefix.
+func (p Pool) Range() (netip.Addr, netip.Addr, error) {
+  s := strings.ReplaceAll(p.Pool, " ", "")
+  if first, last, ok := strings.Cut(s, "-"); ok {
+    a, err := netip.ParseAddr(first)
+    if err != nil {
+      return netip.Addr{}, netip.Addr{}, err
+    }
+    b, err := netip.ParseAddr(last)
+    if err != nil {
+      return netip.Addr{}, netip.Addr{}, err
+    }
+    return a, b, nil
+  }
+
+  prefix, err := netip.ParsePrefix(s)
+  if err != nil {
+    return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid pool %q", p.Pool)
+  }
+  return prefix.Masked().Addr(), lastAddr(prefix), nil
+}
+
+// Contains reports whether addr falls within the pool.
+func (p Pool) Contains(addr netip.Addr) bool {
+  first, last, err := p.Range()
+  if err != nil {
+    return false
+  }
+  return first.Compare(addr) <= 0 && addr.Compare(last) <= 0
+}
+
+// Subnet is the part of a subnet4/subnet6 entry kea-web works with.
+// SharedNetwork is filled in from the enclosing shared network.
+type Subnet struct {
+  ID            int64         `json:"id"`
+  Subnet        string        `json:"subnet"`
+  Interface     string        `json:"interface,omitempty"`
+  InterfaceID   string        `json:"interface-id,omitempty"`
+  Relay         *Relay        `json:"relay,omitempty"`
+  Pools         []Pool        `json:"pools,omitempty"`
+  PDPools       []PDPool      `json:"pd-pools,omitempty"`
+  Reservations  []Reservation `json:"reservations,omitempty"`
+  OptionData    []OptionData  `json:"option-data,omitempty"`
+  ClientClass   string        `json:"client-class,omitempty"`
+  SharedNetwork string        `json:"-"`
+  ClassGuard
+}
+
+// Prefix parses the subnet prefix.
+func (s Subnet) Prefix() (netip.Prefix, error) {
+  return netip.ParsePrefix(s.Subnet)
+}
+
+// Contains reports whether addr falls within the subnet.
+func (s Subnet) Contains(addr netip.Addr) bool {
+  p, err := s.Prefix()
+  return err == nil && p.Contains(addr)
+}
+
+// Subnets returns every subnet of service's running configuration,
+// including the ones inside shared networks, sorted by ID.
+func (c *Client) Subnets(ctx context.Context, service string) ([]Subnet, error) {
+  cfg, err := c.ConfigGet(ctx, service)
+  if err != nil {
+    return nil, err
+  }
+  return SubnetsFromConfig(cfg, service)
+}
+
+// SubnetsFromConfig extracts the subnets from a config-get element.
+func SubnetsFromConfig(cfg map[string]json.RawMessage, service string) ([]Subnet, error) {
+  key := "subnet4"
+  if service == ServiceDHCP6 {
+    key = "subnet6"
+  }
+
+  var subnets []Subnet
+  if raw, ok := cfg[key]; ok {
+    if err := json.Unmarshal(raw, &subnets); err != nil {
+      return nil, fmt.Errorf("decode %s: %w", key, err)
+    }
+  }
+
+  if raw, ok := cfg["shared-networks"]; ok {
+    var nets []map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &nets); err != nil {
+      return nil, fmt.Errorf("decode shared-networks: %w", err)
+    }
+    for _, n := range nets {
+      var name string
+      _ = json.Unmarshal(n["name"], &name)
+
+      var inner []Subnet
+      if raw, ok := n[key]; ok {
+        if err := json.Unmarshal(raw, &inner); err != nil {
+          return nil, fmt.Errorf("decode shared network %s: %w", name, err)
+        }
+      }
+      for i := range inner {
+        inner[i].SharedNetwork = name
+      }
+      subnets = append(subnets, inner...)
+    }
+  }
+
+  sort.Slice(subnets, func(i, j int) bool { return subnets[i].ID < subnets[j].ID })
+  return subnets, nil
+}
+
+// SharedNetwork is a shared-networks entry without its subnets.
+type SharedNetwork struct {
+  Name        string       `json:"name"`
+  Interface   string       `json:"interface,omitempty"`
+  InterfaceID string       `json:"interface-id,omitempty"`
+  Relay       *Relay       `json:"relay,omitempty"`
+  ClientClass string       `json:"client-class,omitempty"`
+  OptionData  []OptionData `json:"option-data,omitempty"`
+  ClassGuard
+}
+
+// SharedNetworksFromConfig extracts the shared networks of a config-get
+// element.
+func SharedNetworksFromConfig(cfg map[string]json.RawMessage) ([]SharedNetwork, error) {
+  var nets []SharedNetwork
+  if raw, ok := cfg["shared-networks"]; ok {
+    if err := json.Unmarshal(raw, &nets); err != nil {
+      return nil, fmt.Errorf("decode shared-networks: %w", err)
+    }
+  }
+  return nets, nil
+}
+
+// lastAddr returns the last address of prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+  b := p.Masked().Addr().AsSlice()
+  bits := p.Bits()
+  for i := range b {
+    for bit := 0; bit < 8; bit++ {
+      if i*8+bit >= bits {
+        b[i] |= 0x80 >> bit
+      }
+    }
+  }
+  a, _ := netip.AddrFromSlice(b)
+  return a
+}
@@ -0,0 +1,144 @@
+package linux
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DiskUsage is the space on one mounted filesystem.
+type DiskUsage struct {
+  Mount  string
+  Device string
+  FSType string
+  // Paths are the requested paths that live on this filesystem.
+  Paths []string
+  Total uint64
+  Free  uint64
+  Avail uint64
+  Error string
+}
+
+// UsedPercent is the share of the filesystem unavailable to
+// unprivileged users, like df's Use%.
+func (d DiskUsage) UsedPercent() float64 {
+  used := d.Total - d.Free
+  if used+d.Avail == 0 {
+    return 0
+  }
+  return 100 * float64(used) / float64(used+d.Avail)
+}
+
+type mount struct {
+  device, point, fstype string
+}
+
+// mounts reads the mount table of the reading process.
+func (h *Host) mounts() ([]mount, error) {
+  f, err := os.Open(h.path("/proc/self/mounts"))
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  var out []mount
+  sc := bufio.NewScanner(f)
+  for sc.Scan() {
+    fields := strings.Fields(sc.Text())
+    if len(fields) < 3 {
+      continue
+    }
+    out = append(out, mount{device: unescapeMount(fields[0]), point: unescapeMount(fields[1]), fstype: fields[2]})
+  }
+  return out, sc.Err()
+}
+
+// unescapeMount undoes the octal escapes (\040 for space, ...) of
+// /proc/*/mounts.
+func unescapeMount(s string) string {
+  if !strings.Contains(s, `\`) {
+    return s
+  }
+  var b strings.Builder
+  for i := 0; i < len(s); i++ {
+    if s[i] == '\\' && i+3 < len(s) {
+      if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+        b.WriteByte(byte(n))
+        i += 3
+        continue
+      }
+    }
+    b.WriteByte(s[i])
+  }
+  return b.String()
+}
+
+// mountOf returns the longest mount point containing p.
+func mountOf(mounts []mount, p string) mount {
+  best := mount{point: "/"}
+  for _, m := range mounts {
+    if m.point == p || m.point == "/" || strings.HasPrefix(p, strings.TrimSuffix(m.point, "/")+"/") {
+      if len(m.point) >= len(best.point) {
+        best = m
+      }
+    }
+  }
+  return best
+}
+
+// existing walks up from p to the nearest path that exists below the
+// root, so a lease or log file that hasn't been created yet still maps
+// to the disk it will be written to.
+func (h *Host) existing(p string) string {
+  p = filepath.Clean(p)
+  for {
+    if _, err := os.Stat(h.path(p)); err == nil || p == "/" || p == "." {
+      return p
+    }
+    p = filepath.Dir(p)
+  }
+}
+
+// Disks returns the usage of the filesystems holding paths, one entry
+// per filesystem.
+func (h *Host) Disks(paths []string) ([]DiskUsage, error) {
+  mounts, err := h.mounts()
+  if err != nil {
+    // Without a mount table every path is reported on its own.
+    mounts = nil
+  }
+
+  var out []DiskUsage
+  index := map[string]int{}
+  for _, p := range paths {
+    if !filepath.IsAbs(p) {
+      continue
+    }
+    ex := h.existing(p)
+    m := mountOf(mounts, ex)
+    if mounts == nil {
+      m.point = ex
+    }
+    key := m.point
+    if i, ok := index[key]; ok {
+      out[i].Paths = append(out[i].Paths, p)
+      continue
+    }
+
+    d := DiskUsage{Mount: m.point, Device: m.device, FSType: m.fstype, Paths: []string{p}}
+    total, free, avail, serr := statfs(h.path(ex))
+    if serr != nil {
+      d.Error = serr.Error()
+    }
+    d.Total, d.Free, d.Avail = total, free, avail
+    index[key] = len(out)
+    out = append(out, d)
+  }
+  if err != nil && !errors.Is(err, os.ErrNotExist) {
+    return out, err
+  }
+  return out, nil
+}
@@ -0,0 +1,79 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory UnitManager for development on hosts without
+// systemd. Every unit it was created with starts out running; actions
+// change the state the way systemd would, without running anything.
+type Fake struct {
+  mu      sync.Mutex
+  units   map[string]*UnitStatus
+  nextPID uint32
+}
+
+// NewFake returns a Fake with the given units running.
+func NewFake(units ...string) *Fake {
+  f := &Fake{units: map[string]*UnitStatus{}, nextPID: 1000}
+  for _, name := range units {
+    st := &UnitStatus{Name: name, Description: name, LoadState: "loaded"}
+    f.start(st)
+    f.units[name] = st
+  }
+  return f
+}
+
+func (f *Fake) start(st *UnitStatus) {
+  f.nextPID++
+  st.ActiveState, st.SubState = "active", "running"
+  st.MainPID = f.nextPID
+  st.Since = time.Now()
+}
+
+// Status returns the unit's simulated state.
+func (f *Fake) Status(ctx context.Context, name string) (UnitStatus, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  st, ok := f.units[name]
+  if !ok {
+    return UnitStatus{Name: name, LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}, nil
+  }
+  return *st, nil
+}
+
+// Control applies action to the simulated unit.
+func (f *Fake) Control(ctx context.Context, name string, action UnitAction) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  st, ok := f.units[name]
+  if !ok {
+    return fmt.Errorf("%s %s: unit not found", action, name)
+  }
+
+  switch action {
+  case ActionStart:
+    if !st.Active() {
+      f.start(st)
+    }
+  case ActionStop:
+    st.ActiveState, st.SubState = "inactive", "dead"
+    st.MainPID = 0
+    st.Since = time.Time{}
+  case ActionRestart:
+    f.start(st)
+  case ActionReload:
+    if !st.Active() {
+      return fmt.Errorf("%s %s: unit not active", action, name)
+    }
+  default:
+    return fmt.Errorf("unknown unit action %q", action)
+  }
+  return nil
+}
+
+// Close does nothing.
+func (f *Fake) Close() {}
@@ -0,0 +1,108 @@
+package linux
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+func TestFakeControl(t *testing.T) {
+  ctx := context.Background()
+  f := NewFake(UnitDHCP4)
+
+  st, err := f.Status(ctx, UnitDHCP4)
+  if err != nil || !st.Active() || st.MainPID == 0 {
+    t.Fatalf("new unit: %+v, %v", st, err)
+  }
+  pid := st.MainPID
+
+  steps := []struct {
+    action UnitAction
+    active bool
+    newPID bool
+    fails  bool
+  }{
+    {ActionReload, true, false, false},
+    {ActionRestart, true, true, false},
+    {ActionStop, false, false, false},
+    {ActionReload, false, false, true},
+    {ActionStart, true, true, false},
+    {ActionStart, true, false, false},
+  }
+  for i, s := range steps {
+    err := f.Control(ctx, UnitDHCP4, s.action)
+    if (err != nil) != s.fails {
+      t.Fatalf("step %d %s: err = %v", i, s.action, err)
+    }
+    st, _ := f.Status(ctx, UnitDHCP4)
+    if st.Active() != s.active {
+      t.Errorf("step %d %s: active = %v, want %v", i, s.action, st.Active(), s.active)
+    }
+    if st.Active() {
+      if (st.MainPID != pid) != s.newPID {
+        t.Errorf("step %d %s: PID %d after %d, new PID %v", i, s.action, st.MainPID, pid, s.newPID)
+      }
+      pid = st.MainPID
+    } else if st.MainPID != 0 || st.Uptime() != 0 {
+      t.Errorf("step %d %s: stopped unit has PID %d, uptime %s", i, s.action, st.MainPID, st.Uptime())
+    }
+  }
+}
+
+func TestFakeUnknownUnit(t *testing.T) {
+  ctx := context.Background()
+  f := NewFake(UnitDHCP4)
+  st, err := f.Status(ctx, UnitDHCP6)
+  if err != nil || st.LoadState != "not-found" || st.Active() {
+    t.Errorf("Status of a missing unit = %+v, %v", st, err)
+  }
+  if err := f.Control(ctx, UnitDHCP6, ActionStart); err == nil {
+    t.Error("Control of a missing unit succeeded")
+  }
+  if err := f.Control(ctx, UnitDHCP4, "kill"); err == nil {
+    t.Error("unknown action succeeded")
+  }
+}
+
+func TestNewUnitManager(t *testing.T) {
+  ctx := context.Background()
+  m, err := NewUnitManager(ctx, Config{Manager: ManagerFake, Units: []string{UnitCtrlAgent}})
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer m.Close()
+  if st, _ := m.Status(ctx, UnitCtrlAgent); !st.Active() {
+    t.Errorf("fake manager: %s not running", UnitCtrlAgent)
+  }
+  if _, err := NewUnitManager(ctx, Config{Manager: ManagerNone}); !errors.Is(err, ErrUnitsDisabled) {
+    t.Errorf("none: err = %v", err)
+  }
+  if _, err := NewUnitManager(ctx, Config{Manager: "upstart"}); err == nil {
+    t.Error("unknown manager accepted")
+  }
+}
+
+func TestConfigFromEnv(t *testing.T) {
+  cfg := ConfigFromEnv(utils.Env{KEA_UNIT_MANAGER: ManagerFake})
+  if !slices.Equal(cfg.Units, DefaultUnits) {
+    t.Errorf("default units = %v", cfg.Units)
+  }
+  cfg = ConfigFromEnv(utils.Env{KEA_UNITS: "kea-dhcp4, ,kea-lfc.timer"})
+  if want := []string{"kea-dhcp4.service", "kea-lfc.timer"}; !slices.Equal(cfg.Units, want) {
+    t.Errorf("units = %v, want %v", cfg.Units, want)
+  }
+  if !cfg.Has("kea-dhcp4.service") || cfg.Has(UnitDHCP6) {
+    t.Errorf("Has: %v", cfg.Units)
+  }
+  for _, s := range []string{"start", "stop", "restart", "reload"} {
+    if a, err := ParseUnitAction(s); err != nil || string(a) != s {
+      t.Errorf("ParseUnitAction(%q) = %q, %v", s, a, err)
+    }
+  }
+  if _, err := ParseUnitAction("enable"); err == nil {
+    t.Error("ParseUnitAction accepted enable")
+  }
+}
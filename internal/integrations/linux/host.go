@@ -0,0 +1,243 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cpuSampleGap is how long Health waits between two /proc/stat reads
+// when it has no earlier sample to compare with.
+const cpuSampleGap = 250 * time.Millisecond
+
+// Host reads metrics of the machine from /proc and /sys below root, so a
+// container can be pointed at the host's filesystems (or a test at a
+// fixture directory).
+type Host struct {
+  root string
+
+  mu      sync.Mutex
+  lastCPU cpuTimes
+}
+
+// NewHost returns a reader rooted at root; "" means "/".
+func NewHost(root string) *Host {
+  if root == "" {
+    root = "/"
+  }
+  return &Host{root: root}
+}
+
+// path maps an absolute host path below the root.
+func (h *Host) path(p string) string {
+  return filepath.Join(h.root, p)
+}
+
+// HostHealth is a snapshot of the host's state.
+type HostHealth struct {
+  Load1, Load5, Load15 float64
+  CPUs                 int
+  // CPUUsed is the busy share of all CPUs in percent since the previous
+  // snapshot.
+  CPUUsed      float64
+  MemTotal     uint64
+  MemAvailable uint64
+  SwapTotal    uint64
+  SwapFree     uint64
+  Disks        []DiskUsage
+  Processes    []Process
+  Ports        []PortStatus
+  // Errors lists the parts that couldn't be read; the rest is still
+  // filled in.
+  Errors []string
+}
+
+// MemUsedPercent is the share of memory not available to new programs.
+func (hh HostHealth) MemUsedPercent() float64 {
+  if hh.MemTotal == 0 {
+    return 0
+  }
+  return 100 * float64(hh.MemTotal-hh.MemAvailable) / float64(hh.MemTotal)
+}
+
+// DHCP server and client ports.
+var DHCPPorts = []uint16{67, 68, 547}
+
+// Health collects load, CPU, memory, the disks holding paths, the Kea
+// processes and the DHCP ports.
+func (h *Host) Health(paths []string) HostHealth {
+  var hh HostHealth
+  fail := func(what string, err error) {
+    hh.Errors = append(hh.Errors, what+": "+err.Error())
+  }
+
+  if err := h.loadAvg(&hh); err != nil {
+    fail("load average", err)
+  }
+  if err := h.cpu(&hh); err != nil {
+    fail("cpu", err)
+  }
+  if err := h.memInfo(&hh); err != nil {
+    fail("memory", err)
+  }
+
+  disks, err := h.Disks(paths)
+  if err != nil {
+    fail("disk", err)
+  }
+  hh.Disks = disks
+
+  procs, err := h.KeaProcesses()
+  if err != nil {
+    fail("processes", err)
+  }
+  hh.Processes = procs
+
+  ports, err := h.UDPPorts(procs, DHCPPorts...)
+  if err != nil {
+    fail("udp", err)
+  }
+  hh.Ports = ports
+  return hh
+}
+
+func (h *Host) loadAvg(hh *HostHealth) error {
+  b, err := os.ReadFile(h.path("/proc/loadavg"))
+  if err != nil {
+    return err
+  }
+  f := strings.Fields(string(b))
+  if len(f) < 3 {
+    return fmt.Errorf("malformed /proc/loadavg")
+  }
+  vals := make([]float64, 3)
+  for i := range vals {
+    if vals[i], err = strconv.ParseFloat(f[i], 64); err != nil {
+      return fmt.Errorf("malformed /proc/loadavg: %w", err)
+    }
+  }
+  hh.Load1, hh.Load5, hh.Load15 = vals[0], vals[1], vals[2]
+  return nil
+}
+
+// cpuTimes is the aggregate "cpu" line of /proc/stat, in clock ticks.
+type cpuTimes struct {
+  busy, total uint64
+  cpus        int
+}
+
+func (h *Host) readCPU() (cpuTimes, error) {
+  var t cpuTimes
+  f, err := os.Open(h.path("/proc/stat"))
+  if err != nil {
+    return t, err
+  }
+  defer f.Close()
+
+  sc := bufio.NewScanner(f)
+  found := false
+  for sc.Scan() {
+    fields := strings.Fields(sc.Text())
+    if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+      continue
+    }
+    if fields[0] != "cpu" {
+      t.cpus++
+      continue
+    }
+    found = true
+    // user nice system idle iowait irq softirq steal; guest time is
+    // already counted in user and nice.
+    for i, v := range fields[1:] {
+      if i >= 8 {
+        break
+      }
+      n, err := strconv.ParseUint(v, 10, 64)
+      if err != nil {
+        return t, fmt.Errorf("malformed /proc/stat: %w", err)
+      }
+      t.total += n
+      if i != 3 && i != 4 {
+        t.busy += n
+      }
+    }
+  }
+  if err := sc.Err(); err != nil {
+    return t, err
+  }
+  if !found {
+    return t, fmt.Errorf("no cpu line in /proc/stat")
+  }
+  return t, nil
+}
+
+// cpu compares /proc/stat with the previous snapshot, or with a second
+// read shortly after the first one if there is none.
+func (h *Host) cpu(hh *HostHealth) error {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  prev := h.lastCPU
+  cur, err := h.readCPU()
+  if err != nil {
+    return err
+  }
+  if prev.total == 0 || cur.total <= prev.total {
+    time.Sleep(cpuSampleGap)
+    prev = cur
+    if cur, err = h.readCPU(); err != nil {
+      return err
+    }
+  }
+  h.lastCPU = cur
+
+  hh.CPUs = cur.cpus
+  if dt := cur.total - prev.total; dt > 0 && cur.busy >= prev.busy {
+    hh.CPUUsed = 100 * float64(cur.busy-prev.busy) / float64(dt)
+  }
+  return nil
+}
+
+func (h *Host) memInfo(hh *HostHealth) error {
+  f, err := os.Open(h.path("/proc/meminfo"))
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  fields := map[string]*uint64{
+    "MemTotal":     &hh.MemTotal,
+    "MemAvailable": &hh.MemAvailable,
+    "SwapTotal":    &hh.SwapTotal,
+    "SwapFree":     &hh.SwapFree,
+  }
+  sc := bufio.NewScanner(f)
+  for sc.Scan() {
+    key, rest, ok := strings.Cut(sc.Text(), ":")
+    dst, want := fields[key]
+    if !ok || !want {
+      continue
+    }
+    f := strings.Fields(rest)
+    if len(f) == 0 {
+      continue
+    }
+    n, err := strconv.ParseUint(f[0], 10, 64)
+    if err != nil {
+      return fmt.Errorf("malformed /proc/meminfo %s: %w", key, err)
+    }
+    // Values are in kB.
+    *dst = n * 1024
+  }
+  return sc.Err()
+}
+
+// ReadFile reads a file of the host.
+func (h *Host) ReadFile(p string) ([]byte, error) {
+  return os.ReadFile(h.path(p))
+}
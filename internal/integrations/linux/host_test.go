@@ -0,0 +1,143 @@
+package linux
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The fixture under testdata/host is a host with kea-dhcp4 (PID 1234)
+// bound to 10.0.0.1:67 and [fe80::ff:0:1]:547, a control agent whose
+// descriptors hold no DHCP socket, and another daemon on port 68.
+const fixtureRoot = "testdata/host"
+
+func TestHealth(t *testing.T) {
+  h := NewHost(fixtureRoot)
+  hh := h.Health([]string{"/var/lib/kea/kea-leases4.csv", "/var/lib/kea/kea-leases6.csv", "/var/log/kea logs/kea-dhcp4.log"})
+
+  for _, e := range hh.Errors {
+    // statfs needs Linux; everything else comes from the fixture.
+    if !strings.HasPrefix(e, "disk") {
+      t.Errorf("error: %s", e)
+    }
+  }
+  if hh.Load1 != 0.52 || hh.Load5 != 0.58 || hh.Load15 != 0.59 {
+    t.Errorf("load = %v %v %v", hh.Load1, hh.Load5, hh.Load15)
+  }
+  if hh.CPUs != 2 {
+    t.Errorf("CPUs = %d", hh.CPUs)
+  }
+  if hh.MemTotal != 4000000*1024 || hh.MemAvailable != 1000000*1024 || hh.SwapTotal != 2000000*1024 || hh.SwapFree != 1500000*1024 {
+    t.Errorf("memory = %+v", hh)
+  }
+  if got := hh.MemUsedPercent(); got != 75 {
+    t.Errorf("MemUsedPercent = %v", got)
+  }
+}
+
+func TestReadCPU(t *testing.T) {
+  ct, err := NewHost(fixtureRoot).readCPU()
+  if err != nil {
+    t.Fatal(err)
+  }
+  // Idle and iowait aren't busy.
+  if ct.busy != 5000 || ct.total != 10000 || ct.cpus != 2 {
+    t.Errorf("readCPU = %+v", ct)
+  }
+}
+
+func TestDisks(t *testing.T) {
+  disks, err := NewHost(fixtureRoot).Disks([]string{
+    "/var/lib/kea/kea-leases4.csv",
+    "/var/lib/kea/kea-leases6.csv",
+    "/var/log/kea logs/kea-dhcp4.log",
+    "/etc/kea/kea-dhcp4.conf",
+    "relative.log",
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  var got []string
+  for _, d := range disks {
+    got = append(got, fmt.Sprintf("%s %s %s %v", d.Mount, d.Device, d.FSType, d.Paths))
+  }
+  want := []string{
+    "/var/lib/kea /dev/sdb1 xfs [/var/lib/kea/kea-leases4.csv /var/lib/kea/kea-leases6.csv]",
+    "/var/log/kea logs /dev/sdc1 ext4 [/var/log/kea logs/kea-dhcp4.log]",
+    "/ /dev/sda1 ext4 [/etc/kea/kea-dhcp4.conf]",
+  }
+  if !slices.Equal(got, want) {
+    t.Errorf("Disks =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+  }
+}
+
+func TestKeaProcesses(t *testing.T) {
+  procs, err := NewHost(fixtureRoot).KeaProcesses()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(procs) != 2 {
+    t.Fatalf("processes = %+v", procs)
+  }
+  agent, dhcp4 := procs[0], procs[1]
+  if agent.Name != "kea-ctrl-agent" || agent.PID != 1300 || agent.FDs != 1 || agent.MaxFDs != 0 {
+    t.Errorf("agent = %+v", agent)
+  }
+  if dhcp4.Name != "kea-dhcp4" || dhcp4.PID != 1234 || dhcp4.FDs != 4 || dhcp4.MaxFDs != 1024 {
+    t.Errorf("dhcp4 = %+v", dhcp4)
+  }
+  if !dhcp4.sockets[1001] || !dhcp4.sockets[1002] || len(dhcp4.sockets) != 2 {
+    t.Errorf("dhcp4 sockets = %v", dhcp4.sockets)
+  }
+}
+
+func TestUDPPorts(t *testing.T) {
+  h := NewHost(fixtureRoot)
+  procs, err := h.KeaProcesses()
+  if err != nil {
+    t.Fatal(err)
+  }
+  ports, err := h.UDPPorts(procs, DHCPPorts...)
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := map[uint16][]UDPSocket{
+    67:  {{Addr: netip.MustParseAddrPort("10.0.0.1:67"), Inode: 1001, Process: "kea-dhcp4[1234]"}},
+    68:  {{Addr: netip.MustParseAddrPort("0.0.0.0:68"), Inode: 3001}},
+    547: {{Addr: netip.MustParseAddrPort("[fe80::ff:0:1]:547"), Inode: 1002, Process: "kea-dhcp4[1234]"}},
+  }
+  for _, p := range ports {
+    if !slices.Equal(p.Sockets, want[p.Port]) || !p.Bound() {
+      t.Errorf("port %d = %+v, want %+v", p.Port, p.Sockets, want[p.Port])
+    }
+  }
+}
+
+func TestParseProcAddr(t *testing.T) {
+  tests := map[string]string{
+    "0100007F:0043":                         "127.0.0.1:67",
+    "00000000:0044":                         "0.0.0.0:68",
+    "00000000000000000000000000000000:0223": "[::]:547",
+    "000080FE00000000FF00000001000000:0223": "[fe80::ff:0:1]:547",
+  }
+  for in, want := range tests {
+    got, err := parseProcAddr(in)
+    if err != nil || got.String() != want {
+      t.Errorf("parseProcAddr(%q) = %v, %v, want %s", in, got, err, want)
+    }
+  }
+  for _, in := range []string{"0100007F", "0100007F:zz", "01007F:0043"} {
+    if _, err := parseProcAddr(in); err == nil {
+      t.Errorf("parseProcAddr(%q) succeeded", in)
+    }
+  }
+}
+
+func TestMissingRoot(t *testing.T) {
+  hh := NewHost(t.TempDir()).Health(nil)
+  if len(hh.Errors) == 0 {
+    t.Error("no errors for an empty root")
+  }
+}
@@ -0,0 +1,149 @@
+package linux
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interface flags from /sys/class/net/<name>/flags (linux/if.h).
+const (
+  iffUp       = 0x1
+  iffLoopback = 0x8
+)
+
+// Interface is a network interface of the host.
+type Interface struct {
+  Name string
+  MAC  string
+  MTU  int
+  // OperState is the RFC 2863 state ("up", "down", "unknown", ...).
+  OperState string
+  // Up is the administrative state, Carrier whether a link is detected.
+  Up       bool
+  Carrier  bool
+  Loopback bool
+  // Kind is the device type from uevent (vlan, bridge, bond, ...); ""
+  // for plain devices.
+  Kind string
+  // Parent is the lower device of a VLAN (or macvlan, ...); VLANID the
+  // 802.1Q tag for VLANs.
+  Parent string
+  VLANID int
+  Addrs  []netip.Prefix
+}
+
+// LinkUp reports whether the interface can pass traffic. Loopback and
+// some virtual devices report operstate "unknown" while working.
+func (i Interface) LinkUp() bool {
+  return i.OperState == "up" || (i.OperState == "unknown" && i.Up && (i.Carrier || i.Loopback))
+}
+
+// Interfaces lists /sys/class/net below the root. Addresses are read
+// through the kernel of the current network namespace, so they are only
+// filled in for interfaces that exist there.
+func (h *Host) Interfaces() ([]Interface, error) {
+  entries, err := os.ReadDir(h.path("/sys/class/net"))
+  if err != nil {
+    return nil, err
+  }
+
+  var out []Interface
+  for _, e := range entries {
+    name := e.Name()
+    dir := "/sys/class/net/" + name
+    if _, err := os.Stat(h.path(dir + "/ifindex")); err != nil {
+      continue // bonding_masters and similar
+    }
+
+    i := Interface{
+      Name:      name,
+      MAC:       h.readSys(dir + "/address"),
+      OperState: h.readSys(dir + "/operstate"),
+      Carrier:   h.readSys(dir+"/carrier") == "1",
+    }
+    i.MTU, _ = strconv.Atoi(h.readSys(dir + "/mtu"))
+    if flags, err := strconv.ParseUint(strings.TrimPrefix(h.readSys(dir+"/flags"), "0x"), 16, 32); err == nil {
+      i.Up = flags&iffUp != 0
+      i.Loopback = flags&iffLoopback != 0
+    }
+    i.Kind = h.ueventValue(dir+"/uevent", "DEVTYPE")
+    i.Parent = h.lowerDevice(dir)
+    if i.Kind == "vlan" {
+      i.VLANID = h.vlanID(name)
+    }
+
+    if ifi, err := net.InterfaceByName(name); err == nil {
+      if addrs, err := ifi.Addrs(); err == nil {
+        for _, a := range addrs {
+          if p, err := netip.ParsePrefix(a.String()); err == nil {
+            i.Addrs = append(i.Addrs, p)
+          }
+        }
+      }
+    }
+    out = append(out, i)
+  }
+
+  sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+  return out, nil
+}
+
+// readSys returns a trimmed sysfs attribute, or "" if it can't be read.
+func (h *Host) readSys(p string) string {
+  b, err := os.ReadFile(h.path(p))
+  if err != nil {
+    return ""
+  }
+  return strings.TrimSpace(string(b))
+}
+
+func (h *Host) ueventValue(p, key string) string {
+  for _, line := range strings.Split(h.readSys(p), "\n") {
+    if v, ok := strings.CutPrefix(line, key+"="); ok {
+      return v
+    }
+  }
+  return ""
+}
+
+// lowerDevice follows the lower_<dev> link sysfs keeps for stacked
+// devices such as VLANs.
+func (h *Host) lowerDevice(dir string) string {
+  entries, err := os.ReadDir(h.path(dir))
+  if err != nil {
+    return ""
+  }
+  for _, e := range entries {
+    if name, ok := strings.CutPrefix(e.Name(), "lower_"); ok {
+      return name
+    }
+  }
+  return ""
+}
+
+// vlanID reads the tag from /proc/net/vlan/<name>.
+func (h *Host) vlanID(name string) int {
+  f, err := os.Open(h.path("/proc/net/vlan/" + name))
+  if err != nil {
+    return 0
+  }
+  defer f.Close()
+
+  sc := bufio.NewScanner(f)
+  for sc.Scan() {
+    // "eth0.100  VID: 100	 REORDER_HDR: 1  dev->priv_flags: 1001"
+    fields := strings.Fields(sc.Text())
+    for j := 0; j+1 < len(fields); j++ {
+      if fields[j] == "VID:" {
+        id, _ := strconv.Atoi(fields[j+1])
+        return id
+      }
+    }
+  }
+  return 0
+}
@@ -0,0 +1,116 @@
+package linux
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeaDaemons are the process names (/proc/<pid>/comm) of the Kea
+// daemons.
+var KeaDaemons = []string{"kea-dhcp4", "kea-dhcp6", "kea-dhcp-ddns", "kea-ctrl-agent"}
+
+// Process is a running Kea daemon.
+type Process struct {
+  PID  int
+  Name string
+  // FDs is the number of open file descriptors, MaxFDs the soft
+  // RLIMIT_NOFILE; both are 0 if the reader may not see them.
+  FDs    int
+  MaxFDs uint64
+  Error  string
+
+  sockets map[uint64]bool
+}
+
+// FDPercent is the share of the descriptor limit in use.
+func (p Process) FDPercent() float64 {
+  if p.MaxFDs == 0 {
+    return 0
+  }
+  return 100 * float64(p.FDs) / float64(p.MaxFDs)
+}
+
+// KeaProcesses finds the Kea daemons in /proc. Reading another user's
+// descriptors needs root or CAP_SYS_PTRACE; without it the processes are
+// still listed, with Error set.
+func (h *Host) KeaProcesses() ([]Process, error) {
+  entries, err := os.ReadDir(h.path("/proc"))
+  if err != nil {
+    return nil, err
+  }
+
+  wanted := map[string]bool{}
+  for _, n := range KeaDaemons {
+    wanted[n] = true
+  }
+
+  var out []Process
+  for _, e := range entries {
+    pid, err := strconv.Atoi(e.Name())
+    if err != nil {
+      continue
+    }
+    dir := "/proc/" + e.Name()
+    comm, err := os.ReadFile(h.path(dir + "/comm"))
+    if err != nil {
+      continue // exited meanwhile
+    }
+    name := strings.TrimSpace(string(comm))
+    if !wanted[name] {
+      continue
+    }
+
+    p := Process{PID: pid, Name: name, sockets: map[uint64]bool{}}
+    fds, err := os.ReadDir(h.path(dir + "/fd"))
+    if err != nil {
+      p.Error = err.Error()
+    } else {
+      p.FDs = len(fds)
+      for _, fd := range fds {
+        target, err := os.Readlink(h.path(dir + "/fd/" + fd.Name()))
+        if err != nil {
+          continue
+        }
+        if s, ok := strings.CutPrefix(target, "socket:["); ok {
+          if inode, err := strconv.ParseUint(strings.TrimSuffix(s, "]"), 10, 64); err == nil {
+            p.sockets[inode] = true
+          }
+        }
+      }
+    }
+    p.MaxFDs = h.maxOpenFiles(dir)
+    out = append(out, p)
+  }
+
+  sort.Slice(out, func(i, j int) bool {
+    if out[i].Name != out[j].Name {
+      return out[i].Name < out[j].Name
+    }
+    return out[i].PID < out[j].PID
+  })
+  return out, nil
+}
+
+// maxOpenFiles reads the soft "Max open files" limit of a process.
+func (h *Host) maxOpenFiles(dir string) uint64 {
+  f, err := os.Open(h.path(dir + "/limits"))
+  if err != nil {
+    return 0
+  }
+  defer f.Close()
+
+  sc := bufio.NewScanner(f)
+  for sc.Scan() {
+    if rest, ok := strings.CutPrefix(sc.Text(), "Max open files"); ok {
+      fields := strings.Fields(rest)
+      if len(fields) > 0 {
+        n, _ := strconv.ParseUint(fields[0], 10, 64)
+        return n
+      }
+    }
+  }
+  return 0
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package linux
+
+import "syscall"
+
+// statfs returns the size, free and unprivileged-available bytes of the
+// filesystem holding path.
+func statfs(path string) (total, free, avail uint64, err error) {
+  var st syscall.Statfs_t
+  if err := syscall.Statfs(path, &st); err != nil {
+    return 0, 0, 0, err
+  }
+  bs := uint64(st.Bsize)
+  return st.Blocks * bs, st.Bfree * bs, st.Bavail * bs, nil
+}
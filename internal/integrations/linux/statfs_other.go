@@ -0,0 +1,9 @@
+//go:build !linux
+
+package linux
+
+import "errors"
+
+func statfs(path string) (total, free, avail uint64, err error) {
+  return 0, 0, 0, errors.ErrUnsupported
+}
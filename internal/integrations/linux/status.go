@@ -1 +1,148 @@
-package linux
\ No newline at end of file
+package linux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Units of the Kea daemons as installed by the ISC packages.
+const (
+  UnitDHCP4     = "kea-dhcp4.service"
+  UnitDHCP6     = "kea-dhcp6.service"
+  UnitDDNS      = "kea-dhcp-ddns.service"
+  UnitCtrlAgent = "kea-ctrl-agent.service"
+)
+
+// DefaultUnits is the unit list used when KEA_UNITS isn't set.
+var DefaultUnits = []string{UnitDHCP4, UnitDHCP6, UnitDDNS, UnitCtrlAgent}
+
+// Unit manager implementations selectable with KEA_UNIT_MANAGER.
+const (
+  ManagerSystemd = "systemd"
+  ManagerFake    = "fake"
+  ManagerNone    = "none"
+)
+
+// ErrUnitsDisabled is returned by NewUnitManager when unit control is
+// turned off.
+var ErrUnitsDisabled = errors.New("unit control disabled")
+
+// UnitAction is a job that can be queued for a unit.
+type UnitAction string
+
+const (
+  ActionStart   UnitAction = "start"
+  ActionStop    UnitAction = "stop"
+  ActionRestart UnitAction = "restart"
+  ActionReload  UnitAction = "reload"
+)
+
+// UnitActions lists the actions in the order the UI offers them.
+var UnitActions = []UnitAction{ActionStart, ActionStop, ActionRestart, ActionReload}
+
+// ParseUnitAction validates an action name.
+func ParseUnitAction(s string) (UnitAction, error) {
+  for _, a := range UnitActions {
+    if string(a) == s {
+      return a, nil
+    }
+  }
+  return "", fmt.Errorf("unknown unit action %q", s)
+}
+
+// UnitStatus is the state of one unit.
+type UnitStatus struct {
+  Name        string
+  Description string
+  LoadState   string
+  ActiveState string
+  SubState    string
+  MainPID     uint32
+  // Since is when the unit last entered the active state; zero if it
+  // isn't active.
+  Since       time.Time
+  // Restarts counts automatic restarts by systemd (NRestarts).
+  Restarts    uint32
+}
+
+// Active reports whether the unit is running.
+func (s UnitStatus) Active() bool {
+  return s.ActiveState == "active" || s.ActiveState == "reloading"
+}
+
+// Uptime is how long the unit has been active.
+func (s UnitStatus) Uptime() time.Duration {
+  if !s.Active() || s.Since.IsZero() {
+    return 0
+  }
+  return time.Since(s.Since).Truncate(time.Second)
+}
+
+// UnitManager reads and controls service units.
+type UnitManager interface {
+  // Status returns the state of a unit. Units that aren't installed
+  // report LoadState "not-found" rather than an error.
+  Status(ctx context.Context, name string) (UnitStatus, error)
+  // Control queues action for a unit and waits for the job to finish.
+  Control(ctx context.Context, name string, action UnitAction) error
+  Close()
+}
+
+// Config selects the unit manager and the units it may touch.
+type Config struct {
+  Manager string
+  Units   []string
+}
+
+// ConfigFromEnv builds a Config from the loaded environment. KEA_UNITS is
+// a comma separated list; names without a suffix get ".service".
+func ConfigFromEnv(e utils.Env) Config {
+  cfg := Config{Manager: e.KEA_UNIT_MANAGER, Units: DefaultUnits}
+  if e.KEA_UNITS != "" {
+    cfg.Units = nil
+    for _, u := range strings.Split(e.KEA_UNITS, ",") {
+      u = strings.TrimSpace(u)
+      if u == "" {
+        continue
+      }
+      if !strings.Contains(u, ".") {
+        u += ".service"
+      }
+      cfg.Units = append(cfg.Units, u)
+    }
+  }
+  return cfg
+}
+
+// NewUnitManager returns the manager chosen by cfg.
+func NewUnitManager(ctx context.Context, cfg Config) (UnitManager, error) {
+  switch cfg.Manager {
+  case "", ManagerSystemd:
+    m, err := NewSystemd(ctx)
+    if err != nil {
+      return nil, err
+    }
+    return m, nil
+  case ManagerFake:
+    return NewFake(cfg.Units...), nil
+  case ManagerNone:
+    return nil, ErrUnitsDisabled
+  }
+  return nil, fmt.Errorf("unknown unit manager %q", cfg.Manager)
+}
+
+// Has reports whether name is one of the configured units. Only those
+// may be controlled from the UI.
+func (c Config) Has(name string) bool {
+  for _, u := range c.Units {
+    if u == name {
+      return true
+    }
+  }
+  return false
+}
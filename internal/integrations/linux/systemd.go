@@ -0,0 +1,127 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// Systemd is a UnitManager talking to systemd over the system D-Bus.
+// Reading state works for any user; controlling units needs root or a
+// polkit rule granting org.freedesktop.systemd1.manage-units.
+type Systemd struct {
+  mu   sync.Mutex
+  conn *dbus.Conn
+}
+
+// NewSystemd connects to the system bus.
+func NewSystemd(ctx context.Context) (*Systemd, error) {
+  conn, err := dbus.NewSystemConnectionContext(ctx)
+  if err != nil {
+    return nil, fmt.Errorf("connect to systemd: %w", err)
+  }
+  return &Systemd{conn: conn}, nil
+}
+
+// connection returns the bus connection, reconnecting if the bus went
+// away (for example after a dbus restart).
+func (s *Systemd) connection(ctx context.Context) (*dbus.Conn, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.conn != nil && s.conn.Connected() {
+    return s.conn, nil
+  }
+  if s.conn != nil {
+    s.conn.Close()
+  }
+  conn, err := dbus.NewSystemConnectionContext(ctx)
+  if err != nil {
+    s.conn = nil
+    return nil, fmt.Errorf("connect to systemd: %w", err)
+  }
+  s.conn = conn
+  return conn, nil
+}
+
+// Status reads the unit's Unit and Service properties.
+func (s *Systemd) Status(ctx context.Context, name string) (UnitStatus, error) {
+  st := UnitStatus{Name: name}
+  conn, err := s.connection(ctx)
+  if err != nil {
+    return st, err
+  }
+
+  props, err := conn.GetUnitPropertiesContext(ctx, name)
+  if err != nil {
+    return st, fmt.Errorf("%s: %w", name, err)
+  }
+  st.Description, _ = props["Description"].(string)
+  st.LoadState, _ = props["LoadState"].(string)
+  st.ActiveState, _ = props["ActiveState"].(string)
+  st.SubState, _ = props["SubState"].(string)
+  if usec, ok := props["ActiveEnterTimestamp"].(uint64); ok && usec > 0 {
+    st.Since = time.UnixMicro(int64(usec))
+  }
+  if st.LoadState == "not-found" {
+    return st, nil
+  }
+
+  svc, err := conn.GetUnitTypePropertiesContext(ctx, name, "Service")
+  if err != nil {
+    return st, fmt.Errorf("%s: %w", name, err)
+  }
+  st.MainPID, _ = svc["MainPID"].(uint32)
+  st.Restarts, _ = svc["NRestarts"].(uint32)
+  return st, nil
+}
+
+// Control queues a job in "replace" mode and waits for its result.
+func (s *Systemd) Control(ctx context.Context, name string, action UnitAction) error {
+  conn, err := s.connection(ctx)
+  if err != nil {
+    return err
+  }
+
+  var queue func(context.Context, string, string, chan<- string) (int, error)
+  switch action {
+  case ActionStart:
+    queue = conn.StartUnitContext
+  case ActionStop:
+    queue = conn.StopUnitContext
+  case ActionRestart:
+    queue = conn.RestartUnitContext
+  case ActionReload:
+    queue = conn.ReloadUnitContext
+  default:
+    return fmt.Errorf("unknown unit action %q", action)
+  }
+
+  done := make(chan string, 1)
+  if _, err := queue(ctx, name, "replace", done); err != nil {
+    return fmt.Errorf("%s %s: %w", action, name, err)
+  }
+
+  select {
+  case result := <-done:
+    if result != "done" {
+      return fmt.Errorf("%s %s: job %s", action, name, result)
+    }
+    return nil
+  case <-ctx.Done():
+    return fmt.Errorf("%s %s: %w", action, name, ctx.Err())
+  }
+}
+
+// Close drops the bus connection.
+func (s *Systemd) Close() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if s.conn != nil {
+    s.conn.Close()
+    s.conn = nil
+  }
+}
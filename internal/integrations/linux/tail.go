@@ -0,0 +1,221 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+  // MaxLineLen caps a single line; longer lines are cut.
+  MaxLineLen = 16 << 10
+  // tailMaxBytes caps how far back TailLines reads.
+  tailMaxBytes = 4 << 20
+  tailChunk    = 64 << 10
+  followPoll   = 500 * time.Millisecond
+  // maxRotated is the highest rotation suffix looked for.
+  maxRotated = 100
+)
+
+// truncateLine cuts b to MaxLineLen and drops the line ending.
+func truncateLine(b []byte) string {
+  b = bytes.TrimRight(b, "\r\n")
+  if len(b) > MaxLineLen {
+    b = b[:MaxLineLen]
+  }
+  return string(b)
+}
+
+// TailLines returns up to n lines from the end of path, reading the file
+// backwards in chunks and never more than tailMaxBytes.
+func (h *Host) TailLines(path string, n int) ([]string, error) {
+  f, err := os.Open(h.path(path))
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  st, err := f.Stat()
+  if err != nil {
+    return nil, err
+  }
+
+  pos := st.Size()
+  var data []byte
+  for pos > 0 && bytes.Count(data, []byte{'\n'}) <= n && len(data) < tailMaxBytes {
+    size := int64(tailChunk)
+    if pos < size {
+      size = pos
+    }
+    pos -= size
+    chunk := make([]byte, size)
+    if _, err := f.ReadAt(chunk, pos); err != nil && !errors.Is(err, io.EOF) {
+      return nil, err
+    }
+    data = append(chunk, data...)
+  }
+
+  lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte{'\n'})
+  if pos > 0 && len(lines) > 0 {
+    // The first line is probably only the end of one.
+    lines = lines[1:]
+  }
+  if len(lines) > n {
+    lines = lines[len(lines)-n:]
+  }
+  out := make([]string, 0, len(lines))
+  for _, l := range lines {
+    if len(l) > 0 {
+      out = append(out, truncateLine(l))
+    }
+  }
+  return out, nil
+}
+
+// readLines calls fn for every line of r. Lines longer than MaxLineLen
+// are cut rather than buffered.
+func readLines(ctx context.Context, r io.Reader, fn func(string) error) error {
+  br := bufio.NewReaderSize(r, tailChunk)
+  var long []byte
+  for n := 0; ; n++ {
+    if n%1000 == 0 && ctx.Err() != nil {
+      return ctx.Err()
+    }
+    b, err := br.ReadSlice('\n')
+    switch {
+    case errors.Is(err, bufio.ErrBufferFull):
+      if len(long) < MaxLineLen {
+        long = append(long, b...)
+      }
+      continue
+    case err != nil && !errors.Is(err, io.EOF):
+      return err
+    }
+
+    if long != nil {
+      b = append(long, b...)
+      long = nil
+    }
+    if len(b) > 0 {
+      if ferr := fn(truncateLine(b)); ferr != nil {
+        return ferr
+      }
+    }
+    if err != nil {
+      return nil
+    }
+  }
+}
+
+// ForEachLine calls fn for every line of path.
+func (h *Host) ForEachLine(ctx context.Context, path string, fn func(string) error) error {
+  f, err := os.Open(h.path(path))
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  return readLines(ctx, f, fn)
+}
+
+// RotatedFiles returns path and its rotated copies (path.1, path.2, ...
+// as written by Kea's rolling file appender) that exist, oldest first.
+func (h *Host) RotatedFiles(path string) []string {
+  var out []string
+  for i := maxRotated; i >= 1; i-- {
+    p := path + "." + strconv.Itoa(i)
+    if _, err := os.Stat(h.path(p)); err == nil {
+      out = append(out, p)
+    }
+  }
+  return append(out, path)
+}
+
+// Follow sends lines appended to path to out until ctx ends, like
+// tail -F: when the file is rotated or truncated it carries on with the
+// new file from its start. Lines already in the file are skipped.
+func (h *Host) Follow(ctx context.Context, path string, out chan<- string) error {
+  f, err := os.Open(h.path(path))
+  if err != nil {
+    return err
+  }
+  defer func() { f.Close() }()
+  if _, err := f.Seek(0, io.SeekEnd); err != nil {
+    return err
+  }
+
+  var partial []byte
+  drain := func() error {
+    buf := make([]byte, tailChunk)
+    for {
+      n, err := f.Read(buf)
+      data := buf[:n]
+      for len(data) > 0 {
+        i := bytes.IndexByte(data, '\n')
+        if i < 0 {
+          if len(partial) < MaxLineLen {
+            partial = append(partial, data...)
+          }
+          break
+        }
+        line := append(partial, data[:i]...)
+        partial = nil
+        select {
+        case out <- truncateLine(line):
+        case <-ctx.Done():
+          return ctx.Err()
+        }
+        data = data[i+1:]
+      }
+      if errors.Is(err, io.EOF) || n == 0 {
+        return nil
+      }
+      if err != nil {
+        return err
+      }
+    }
+  }
+
+  ticker := time.NewTicker(followPoll)
+  defer ticker.Stop()
+  for {
+    if err := drain(); err != nil {
+      return err
+    }
+
+    cur, err := f.Stat()
+    if err != nil {
+      return err
+    }
+    st, err := os.Stat(h.path(path))
+    switch {
+    case err == nil && !os.SameFile(cur, st):
+      // Rotated: the old file has been drained, switch to the new one.
+      nf, err := os.Open(h.path(path))
+      if err != nil {
+        break // not recreated yet; retry on the next tick
+      }
+      f.Close()
+      f, partial = nf, nil
+      continue
+    case err == nil:
+      if pos, perr := f.Seek(0, io.SeekCurrent); perr == nil && cur.Size() < pos {
+        // Truncated in place.
+        if _, err := f.Seek(0, io.SeekStart); err != nil {
+          return err
+        }
+        partial = nil
+      }
+    }
+
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-ticker.C:
+    }
+  }
+}
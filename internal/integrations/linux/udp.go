@@ -0,0 +1,118 @@
+package linux
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UDPSocket is a bound UDP socket from /proc/net/udp or udp6.
+type UDPSocket struct {
+  Addr    netip.AddrPort
+  Inode   uint64
+  // Process names the Kea daemon holding the socket, if known.
+  Process string
+}
+
+// PortStatus tells whether anything is bound to a UDP port.
+type PortStatus struct {
+  Port    uint16
+  Sockets []UDPSocket
+}
+
+// Bound reports whether any socket uses the port.
+func (p PortStatus) Bound() bool {
+  return len(p.Sockets) > 0
+}
+
+// UDPPorts reports the sockets bound to ports. Sockets are attributed to
+// procs (from KeaProcesses) by inode where possible.
+func (h *Host) UDPPorts(procs []Process, ports ...uint16) ([]PortStatus, error) {
+  owner := map[uint64]string{}
+  for _, p := range procs {
+    for inode := range p.sockets {
+      owner[inode] = fmt.Sprintf("%s[%d]", p.Name, p.PID)
+    }
+  }
+
+  out := make([]PortStatus, len(ports))
+  index := map[uint16]int{}
+  for i, port := range ports {
+    out[i].Port = port
+    index[port] = i
+  }
+
+  var firstErr error
+  for _, file := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+    sockets, err := h.readUDP(file)
+    if err != nil {
+      // udp6 is missing when IPv6 is disabled.
+      if firstErr == nil && !(file == "/proc/net/udp6" && os.IsNotExist(err)) {
+        firstErr = err
+      }
+      continue
+    }
+    for _, s := range sockets {
+      i, ok := index[s.Addr.Port()]
+      if !ok {
+        continue
+      }
+      s.Process = owner[s.Inode]
+      out[i].Sockets = append(out[i].Sockets, s)
+    }
+  }
+  return out, firstErr
+}
+
+// readUDP parses a /proc/net/udp{,6} table.
+func (h *Host) readUDP(file string) ([]UDPSocket, error) {
+  f, err := os.Open(h.path(file))
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  var out []UDPSocket
+  sc := bufio.NewScanner(f)
+  sc.Scan() // header
+  for sc.Scan() {
+    // sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+    fields := strings.Fields(sc.Text())
+    if len(fields) < 10 {
+      continue
+    }
+    addr, err := parseProcAddr(fields[1])
+    if err != nil {
+      return nil, fmt.Errorf("%s: %w", file, err)
+    }
+    inode, _ := strconv.ParseUint(fields[9], 10, 64)
+    out = append(out, UDPSocket{Addr: addr, Inode: inode})
+  }
+  return out, sc.Err()
+}
+
+// parseProcAddr decodes "0100007F:0043": the address as 32-bit words in
+// host (little-endian) byte order, the port in hex.
+func parseProcAddr(s string) (netip.AddrPort, error) {
+  hexAddr, hexPort, ok := strings.Cut(s, ":")
+  if !ok {
+    return netip.AddrPort{}, fmt.Errorf("malformed address %q", s)
+  }
+  port, err := strconv.ParseUint(hexPort, 16, 16)
+  if err != nil {
+    return netip.AddrPort{}, fmt.Errorf("malformed port %q", s)
+  }
+  b, err := hex.DecodeString(hexAddr)
+  if err != nil || (len(b) != 4 && len(b) != 16) {
+    return netip.AddrPort{}, fmt.Errorf("malformed address %q", s)
+  }
+  for i := 0; i < len(b); i += 4 {
+    b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+  }
+  addr, _ := netip.AddrFromSlice(b)
+  return netip.AddrPortFrom(addr, uint16(port)), nil
+}
@@ -1 +1,55 @@
-package sql
\ No newline at end of file
+package sql
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Config describes how to reach the Kea lease/host database (MySQL).
+type Config struct {
+  Host     string
+  Port     int
+  User     string
+  Password string
+  Name     string
+  Timeout  time.Duration
+}
+
+// ConfigFromEnv builds a Config from the loaded environment.
+func ConfigFromEnv(e utils.Env) Config {
+  return Config{
+    Host:     e.KEA_DB_HOST,
+    Port:     e.KEA_DB_PORT,
+    User:     e.KEA_DB_USER,
+    Password: e.KEA_DB_PASSWORD,
+    Name:     e.KEA_DB_NAME,
+    Timeout:  5 * time.Second,
+  }
+}
+
+// Configured reports whether enough is set to try a connection.
+func (c Config) Configured() bool {
+  return c.Host != "" && c.User != "" && c.Name != ""
+}
+
+// DSN returns the go-sql-driver/mysql data source name for c.
+func (c Config) DSN() string {
+  mc := mysql.NewConfig()
+  mc.Net = "tcp"
+  mc.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+  mc.User = c.User
+  mc.Passwd = c.Password
+  mc.DBName = c.Name
+  mc.Timeout = c.Timeout
+  mc.ReadTimeout = 30 * time.Second
+  // Kea stores expiry as TIMESTAMP; run the session in UTC so NOW() and
+  // the scanned values agree with Loc.
+  mc.ParseTime = true
+  mc.Loc = time.UTC
+  mc.Params = map[string]string{"time_zone": "'+00:00'"}
+  return mc.FormatDSN()
+}
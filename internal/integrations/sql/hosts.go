@@ -0,0 +1,295 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Values of hosts.dhcp_identifier_type.
+var identifierTypes = map[int]string{
+  0: "hw-address",
+  1: "duid",
+  2: "circuit-id",
+  3: "client-id",
+  4: "flex-id",
+}
+
+// IdentifierTypeCode returns the hosts.dhcp_identifier_type value for a
+// Kea identifier type name.
+func IdentifierTypeCode(name string) (int, bool) {
+  for code, n := range identifierTypes {
+    if n == name {
+      return code, true
+    }
+  }
+  return 0, false
+}
+
+// Host is a DHCPv4 reservation row from the hosts table.
+type Host struct {
+  HostID         int64
+  IdentifierType string
+  Identifier     string
+  SubnetID       int64
+  IPv4Address    string
+  Hostname       string
+  ClientClasses  string
+  Options        []HostOption
+}
+
+// HostOption is a host-scoped row of dhcp4_options. Value is the
+// formatted (textual) value Kea parses like option-data "data".
+type HostOption struct {
+  Code  int
+  Space string
+  Value string
+}
+
+// scopeHost is the dhcp4_options.scope_id of host options.
+const scopeHost = 3
+
+const hosts4Query = `
+SELECT host_id, dhcp_identifier_type, dhcp_identifier,
+  COALESCE(dhcp4_subnet_id, 0), ipv4_address,
+  COALESCE(hostname, ''), COALESCE(dhcp4_client_classes, '')
+FROM hosts
+WHERE dhcp4_subnet_id IS NOT NULL
+ORDER BY dhcp4_subnet_id, host_id`
+
+// Hosts4 returns every DHCPv4 reservation in the hosts table.
+func (d *DB) Hosts4(ctx context.Context) ([]Host, error) {
+  if !d.Configured() {
+    return nil, ErrNotConfigured
+  }
+
+  rows, err := d.db.QueryContext(ctx, hosts4Query)
+  if err != nil {
+    return nil, fmt.Errorf("hosts: %w", err)
+  }
+  defer rows.Close()
+
+  var out []Host
+  for rows.Next() {
+    var h Host
+    var idType int
+    var id []byte
+    var addr dbsql.NullInt64
+    if err := rows.Scan(&h.HostID, &idType, &id, &h.SubnetID, &addr, &h.Hostname, &h.ClientClasses); err != nil {
+      return nil, fmt.Errorf("hosts: %w", err)
+    }
+    h.IdentifierType = identifierTypes[idType]
+    h.Identifier = hexColon(id)
+    if addr.Valid && addr.Int64 != 0 {
+      h.IPv4Address = uint32ToAddr(uint32(addr.Int64)).String()
+    }
+    out = append(out, h)
+  }
+  return out, rows.Err()
+}
+
+// DeleteHost removes a reservation (and, through the schema's foreign
+// keys, its options) by host_id.
+func (d *DB) DeleteHost(ctx context.Context, hostID int64) error {
+  if !d.Configured() {
+    return ErrNotConfigured
+  }
+  res, err := d.db.ExecContext(ctx, "DELETE FROM hosts WHERE host_id = ?", hostID)
+  if err != nil {
+    return fmt.Errorf("delete host %d: %w", hostID, err)
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return fmt.Errorf("delete host %d: not found", hostID)
+  }
+  return nil
+}
+
+const hostOptions4Query = `
+SELECT host_id, code, COALESCE(space, 'dhcp4'), COALESCE(formatted_value, '')
+FROM dhcp4_options
+WHERE host_id IS NOT NULL AND scope_id = 3
+ORDER BY host_id, option_id`
+
+// HostOptions4 returns the options of every DHCPv4 reservation, keyed by
+// host_id. Options stored only in binary form are skipped.
+func (d *DB) HostOptions4(ctx context.Context) (map[int64][]HostOption, error) {
+  if !d.Configured() {
+    return nil, ErrNotConfigured
+  }
+
+  rows, err := d.db.QueryContext(ctx, hostOptions4Query)
+  if err != nil {
+    return nil, fmt.Errorf("host options: %w", err)
+  }
+  defer rows.Close()
+
+  out := map[int64][]HostOption{}
+  for rows.Next() {
+    var hostID int64
+    var o HostOption
+    if err := rows.Scan(&hostID, &o.Code, &o.Space, &o.Value); err != nil {
+      return nil, fmt.Errorf("host options: %w", err)
+    }
+    if o.Value == "" {
+      continue
+    }
+    out[hostID] = append(out[hostID], o)
+  }
+  return out, rows.Err()
+}
+
+// SaveHosts4 inserts hosts with a zero HostID and updates the others, all
+// in one transaction. An update replaces the address, hostname and
+// options of the row but keeps everything else. On error nothing of the
+// batch is written.
+func (d *DB) SaveHosts4(ctx context.Context, hosts []Host) error {
+  if !d.Configured() {
+    return ErrNotConfigured
+  }
+
+  tx, err := d.db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for i, h := range hosts {
+    if err := saveHost4(ctx, tx, h); err != nil {
+      return fmt.Errorf("row %d (%s %s): %w", i+1, h.IdentifierType, h.Identifier, err)
+    }
+  }
+  return tx.Commit()
+}
+
+func saveHost4(ctx context.Context, tx *dbsql.Tx, h Host) error {
+  var addr dbsql.NullInt64
+  if h.IPv4Address != "" {
+    a, err := netip.ParseAddr(h.IPv4Address)
+    if err != nil || !a.Is4() {
+      return fmt.Errorf("invalid IPv4 address %q", h.IPv4Address)
+    }
+    addr = dbsql.NullInt64{Int64: int64(addrToUint32(a)), Valid: true}
+  }
+  hostname := dbsql.NullString{String: h.Hostname, Valid: h.Hostname != ""}
+
+  hostID := h.HostID
+  if hostID == 0 {
+    idType, ok := IdentifierTypeCode(h.IdentifierType)
+    if !ok {
+      return fmt.Errorf("unknown identifier type %q", h.IdentifierType)
+    }
+    id, err := ParseIdentifier(h.Identifier)
+    if err != nil {
+      return err
+    }
+
+    res, err := tx.ExecContext(ctx, `
+INSERT INTO hosts (dhcp_identifier, dhcp_identifier_type, dhcp4_subnet_id, ipv4_address, hostname)
+VALUES (?, ?, ?, ?, ?)`, id, idType, h.SubnetID, addr, hostname)
+    if err != nil {
+      return err
+    }
+    if hostID, err = res.LastInsertId(); err != nil {
+      return err
+    }
+  } else {
+    if _, err := tx.ExecContext(ctx,
+      "UPDATE hosts SET ipv4_address = ?, hostname = ? WHERE host_id = ?",
+      addr, hostname, hostID); err != nil {
+      return err
+    }
+    if _, err := tx.ExecContext(ctx,
+      "DELETE FROM dhcp4_options WHERE host_id = ? AND scope_id = ?", hostID, scopeHost); err != nil {
+      return err
+    }
+  }
+
+  for _, o := range h.Options {
+    space := o.Space
+    if space == "" {
+      space = "dhcp4"
+    }
+    if _, err := tx.ExecContext(ctx, `
+INSERT INTO dhcp4_options (code, formatted_value, space, persistent, host_id, scope_id)
+VALUES (?, ?, ?, 0, ?, ?)`, o.Code, o.Value, space, hostID, scopeHost); err != nil {
+      return fmt.Errorf("option %d: %w", o.Code, err)
+    }
+  }
+  return nil
+}
+
+const reservedLastSeen4Query = `
+SELECT l.address, MAX(UNIX_TIMESTAMP(l.expire) - l.valid_lifetime)
+FROM hosts h
+JOIN lease4 l ON l.address = h.ipv4_address
+WHERE h.ipv4_address IS NOT NULL
+GROUP BY l.address`
+
+// ReservedLastSeen4 returns, for every reserved IPv4 address that has a
+// lease row, the client's last transmission time.
+func (d *DB) ReservedLastSeen4(ctx context.Context) (map[string]time.Time, error) {
+  if !d.Configured() {
+    return nil, ErrNotConfigured
+  }
+
+  rows, err := d.db.QueryContext(ctx, reservedLastSeen4Query)
+  if err != nil {
+    return nil, fmt.Errorf("reserved leases: %w", err)
+  }
+  defer rows.Close()
+
+  out := map[string]time.Time{}
+  for rows.Next() {
+    var addr uint32
+    var cltt int64
+    if err := rows.Scan(&addr, &cltt); err != nil {
+      return nil, fmt.Errorf("reserved leases: %w", err)
+    }
+    out[uint32ToAddr(addr).String()] = time.Unix(cltt, 0)
+  }
+  return out, rows.Err()
+}
+
+// uint32ToAddr converts Kea's INT UNSIGNED address column.
+func uint32ToAddr(v uint32) netip.Addr {
+  var b [4]byte
+  binary.BigEndian.PutUint32(b[:], v)
+  return netip.AddrFrom4(b)
+}
+
+// addrToUint32 is the inverse of uint32ToAddr.
+func addrToUint32(a netip.Addr) uint32 {
+  b := a.As4()
+  return binary.BigEndian.Uint32(b[:])
+}
+
+// ParseIdentifier converts an identifier as Kea prints it (hex, with or
+// without colon or dash separators, or a quoted string for flex-id) to
+// the bytes stored in hosts.dhcp_identifier.
+func ParseIdentifier(s string) ([]byte, error) {
+  s = strings.TrimSpace(s)
+  if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+    return []byte(s[1 : len(s)-1]), nil
+  }
+
+  h := strings.NewReplacer(":", "", "-", "", ".", "").Replace(s)
+  b, err := hex.DecodeString(h)
+  if err != nil || len(b) == 0 {
+    return nil, fmt.Errorf("invalid identifier %q", s)
+  }
+  return b, nil
+}
+
+// hexColon formats binary identifiers the way Kea prints them.
+func hexColon(b []byte) string {
+  parts := make([]string, len(b))
+  for i, v := range b {
+    parts[i] = fmt.Sprintf("%02x", v)
+  }
+  return strings.Join(parts, ":")
+}
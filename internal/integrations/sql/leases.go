@@ -0,0 +1,292 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long aggregate lease counts are reused before querying again.
+const leaseCountTTL = 15 * time.Second
+
+// Lease states as stored in the state column of lease4/lease6.
+const (
+  LeaseStateDefault   = 0
+  LeaseStateDeclined  = 1
+  LeaseStateReclaimed = 2
+  LeaseStateReleased  = 3
+)
+
+// SubnetLeaseCounts is the aggregate lease picture of one subnet.
+type SubnetLeaseCounts struct {
+  SubnetID       int64
+  Active         int64
+  Expired        int64
+  Declined       int64
+  Reclaimed      int64
+  Other          int64
+  EarliestExpiry time.Time
+  LatestExpiry   time.Time
+}
+
+// Total returns the number of lease rows for the subnet.
+func (c SubnetLeaseCounts) Total() int64 {
+  return c.Active + c.Expired + c.Declined + c.Reclaimed + c.Other
+}
+
+// LeaseCounts is a snapshot of per-subnet and per-state lease counts.
+type LeaseCounts struct {
+  Family    int
+  Subnets   []SubnetLeaseCounts
+  ByState   map[int]int64
+  FetchedAt time.Time
+}
+
+// leaseCountsQuery aggregates a lease table per subnet. An "active" lease
+// is one in the default state that has not yet expired.
+const leaseCountsQuery = `
+SELECT subnet_id,
+  COALESCE(SUM(state = 0 AND expire > NOW()), 0),
+  COALESCE(SUM(state = 0 AND expire <= NOW()), 0),
+  COALESCE(SUM(state = 1), 0),
+  COALESCE(SUM(state = 2), 0),
+  COALESCE(SUM(state NOT IN (0, 1, 2)), 0),
+  MIN(expire),
+  MAX(expire)
+FROM %s
+GROUP BY subnet_id
+ORDER BY subnet_id`
+
+const leaseStatesQuery = `SELECT state, COUNT(*) FROM %s GROUP BY state`
+
+func leaseTable(family int) (string, error) {
+  switch family {
+  case 4:
+    return "lease4", nil
+  case 6:
+    return "lease6", nil
+  }
+  return "", fmt.Errorf("sql: invalid address family %d", family)
+}
+
+// LeaseCounts returns aggregate lease counts for family (4 or 6). Results
+// are cached for a few seconds so dashboards can poll freely.
+func (d *DB) LeaseCounts(ctx context.Context, family int) (LeaseCounts, error) {
+  if !d.Configured() {
+    return LeaseCounts{}, ErrNotConfigured
+  }
+  if c, ok := d.leaseCounts.get(family); ok {
+    return c, nil
+  }
+
+  c, err := d.queryLeaseCounts(ctx, family)
+  if err != nil {
+    return LeaseCounts{}, err
+  }
+  d.leaseCounts.put(family, c)
+  return c, nil
+}
+
+func (d *DB) queryLeaseCounts(ctx context.Context, family int) (LeaseCounts, error) {
+  table, err := leaseTable(family)
+  if err != nil {
+    return LeaseCounts{}, err
+  }
+
+  out := LeaseCounts{Family: family, ByState: map[int]int64{}, FetchedAt: time.Now()}
+
+  rows, err := d.db.QueryContext(ctx, fmt.Sprintf(leaseCountsQuery, table))
+  if err != nil {
+    return out, fmt.Errorf("%s counts: %w", table, err)
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    var c SubnetLeaseCounts
+    var earliest, latest dbsql.NullTime
+    if err := rows.Scan(&c.SubnetID, &c.Active, &c.Expired, &c.Declined, &c.Reclaimed, &c.Other, &earliest, &latest); err != nil {
+      return out, fmt.Errorf("%s counts: %w", table, err)
+    }
+    c.EarliestExpiry = earliest.Time
+    c.LatestExpiry = latest.Time
+    out.Subnets = append(out.Subnets, c)
+  }
+  if err := rows.Err(); err != nil {
+    return out, fmt.Errorf("%s counts: %w", table, err)
+  }
+
+  states, err := d.db.QueryContext(ctx, fmt.Sprintf(leaseStatesQuery, table))
+  if err != nil {
+    return out, fmt.Errorf("%s states: %w", table, err)
+  }
+  defer states.Close()
+
+  for states.Next() {
+    var state int
+    var n int64
+    if err := states.Scan(&state, &n); err != nil {
+      return out, fmt.Errorf("%s states: %w", table, err)
+    }
+    out.ByState[state] = n
+  }
+  return out, states.Err()
+}
+
+type leaseCountCache struct {
+  mu      sync.Mutex
+  ttl     time.Duration
+  entries map[int]LeaseCounts
+}
+
+func newLeaseCountCache(ttl time.Duration) *leaseCountCache {
+  return &leaseCountCache{ttl: ttl, entries: map[int]LeaseCounts{}}
+}
+
+func (c *leaseCountCache) get(family int) (LeaseCounts, bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  e, ok := c.entries[family]
+  if !ok || time.Since(e.FetchedAt) > c.ttl {
+    return LeaseCounts{}, false
+  }
+  return e, true
+}
+
+func (c *leaseCountCache) put(family int, counts LeaseCounts) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.entries[family] = counts
+}
+
+// Lease is a row of lease4 or lease6. DHCPv4 rows fill HWAddress and
+// ClientID, DHCPv6 rows DUID, IAID, Type and PrefixLen.
+type Lease struct {
+  Address       string
+  HWAddress     string
+  ClientID      string
+  DUID          string
+  IAID          int64
+  Type          int
+  PrefixLen     int
+  SubnetID      int64
+  ValidLifetime int64
+  Expire        time.Time
+  Hostname      string
+  State         int
+  FQDNFwd       bool
+  FQDNRev       bool
+}
+
+// LeaseFilter narrows ForEachLease. Zero fields match everything.
+type LeaseFilter struct {
+  SubnetID  int64
+  State     *int
+  Hostname  string
+  HWAddress []byte
+  // After resumes a scan past this address.
+  After netip.Addr
+}
+
+const lease4Columns = `address, COALESCE(hwaddr, ''), COALESCE(client_id, ''), valid_lifetime, expire,
+  subnet_id, COALESCE(hostname, ''), state, fqdn_fwd, fqdn_rev`
+
+const lease6Columns = `address, COALESCE(hwaddr, ''), COALESCE(duid, ''), COALESCE(iaid, 0), lease_type,
+  COALESCE(prefix_len, 128), valid_lifetime, expire, subnet_id, COALESCE(hostname, ''), state, fqdn_fwd, fqdn_rev`
+
+// ForEachLease streams the leases of family matching f in address
+// order, calling fn for each row without loading the table into memory.
+// An error from fn stops the scan and is returned.
+func (d *DB) ForEachLease(ctx context.Context, family int, f LeaseFilter, fn func(Lease) error) error {
+  if !d.Configured() {
+    return ErrNotConfigured
+  }
+  table, err := leaseTable(family)
+  if err != nil {
+    return err
+  }
+
+  columns := lease4Columns
+  if family == 6 {
+    columns = lease6Columns
+  }
+
+  var where []string
+  var args []any
+  if f.SubnetID != 0 {
+    where = append(where, "subnet_id = ?")
+    args = append(args, f.SubnetID)
+  }
+  if f.State != nil {
+    where = append(where, "state = ?")
+    args = append(args, *f.State)
+  }
+  if f.Hostname != "" {
+    where = append(where, "hostname LIKE ?")
+    args = append(args, "%"+likeEscaper.Replace(f.Hostname)+"%")
+  }
+  if len(f.HWAddress) > 0 {
+    where = append(where, "hwaddr = ?")
+    args = append(args, f.HWAddress)
+  }
+  if f.After.IsValid() {
+    where = append(where, "address > ?")
+    if family == 4 {
+      args = append(args, addrToUint32(f.After))
+    } else {
+      args = append(args, f.After.String())
+    }
+  }
+
+  query := "SELECT " + columns + " FROM " + table
+  if len(where) > 0 {
+    query += " WHERE " + strings.Join(where, " AND ")
+  }
+  // The address is the primary key, so the order costs no sort.
+  query += " ORDER BY address"
+
+  rows, err := d.db.QueryContext(ctx, query, args...)
+  if err != nil {
+    return fmt.Errorf("%s: %w", table, err)
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    l, err := scanLease(rows, family)
+    if err != nil {
+      return fmt.Errorf("%s: %w", table, err)
+    }
+    if err := fn(l); err != nil {
+      return err
+    }
+  }
+  return rows.Err()
+}
+
+func scanLease(rows *dbsql.Rows, family int) (Lease, error) {
+  var l Lease
+  var hwaddr, id []byte
+
+  if family == 4 {
+    var addr uint32
+    if err := rows.Scan(&addr, &hwaddr, &id, &l.ValidLifetime, &l.Expire,
+      &l.SubnetID, &l.Hostname, &l.State, &l.FQDNFwd, &l.FQDNRev); err != nil {
+      return l, err
+    }
+    l.Address = uint32ToAddr(addr).String()
+    l.ClientID = hexColon(id)
+  } else {
+    if err := rows.Scan(&l.Address, &hwaddr, &id, &l.IAID, &l.Type,
+      &l.PrefixLen, &l.ValidLifetime, &l.Expire, &l.SubnetID, &l.Hostname, &l.State, &l.FQDNFwd, &l.FQDNRev); err != nil {
+      return l, err
+    }
+    l.DUID = hexColon(id)
+  }
+  l.HWAddress = hexColon(hwaddr)
+  return l, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
@@ -1 +1,54 @@
-package sql
\ No newline at end of file
+package sql
+
+import (
+	dbsql "database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNotConfigured is returned when no database is configured.
+var ErrNotConfigured = errors.New("sql: database not configured")
+
+// DB wraps the connection pool to the Kea database.
+type DB struct {
+  cfg Config
+  db  *dbsql.DB
+
+  leaseCounts *leaseCountCache
+}
+
+// Open prepares a connection pool for cfg. No connection is made until
+// the first query. A nil *DB is returned (without error) when cfg is not
+// configured, so callers can treat the database as optional.
+func Open(cfg Config) (*DB, error) {
+  if !cfg.Configured() {
+    return nil, nil
+  }
+
+  db, err := dbsql.Open("mysql", cfg.DSN())
+  if err != nil {
+    return nil, err
+  }
+  db.SetMaxOpenConns(10)
+  db.SetMaxIdleConns(5)
+  db.SetConnMaxLifetime(5 * time.Minute)
+
+  return &DB{
+    cfg:         cfg,
+    db:          db,
+    leaseCounts: newLeaseCountCache(leaseCountTTL),
+  }, nil
+}
+
+// Configured reports whether d can be queried.
+func (d *DB) Configured() bool {
+  return d != nil && d.db != nil
+}
+
+// Close releases the connection pool.
+func (d *DB) Close() error {
+  if !d.Configured() {
+    return nil
+  }
+  return d.db.Close()
+}
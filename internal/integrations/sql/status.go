@@ -1 +1,31 @@
-package sql
\ No newline at end of file
+package sql
+
+import (
+	"context"
+	"fmt"
+)
+
+// Status describes the database connection.
+type Status struct {
+  Reachable     bool
+  SchemaVersion string
+}
+
+// Status pings the database and reads the Kea schema version.
+func (d *DB) Status(ctx context.Context) (Status, error) {
+  if !d.Configured() {
+    return Status{}, ErrNotConfigured
+  }
+  if err := d.db.PingContext(ctx); err != nil {
+    return Status{}, err
+  }
+
+  st := Status{Reachable: true}
+  var major, minor int
+  err := d.db.QueryRowContext(ctx, "SELECT version, minor FROM schema_version").Scan(&major, &minor)
+  if err != nil {
+    return st, err
+  }
+  st.SchemaVersion = fmt.Sprintf("%d.%d", major, minor)
+  return st, nil
+}
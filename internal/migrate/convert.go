@@ -0,0 +1,1290 @@
+// Package migrate converts ISC DHCP (dhcpd) configuration and lease
+// files to Kea.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// Severities of report findings.
+const (
+  // Untranslated constructs are left out of the Kea configuration.
+  Untranslated = "untranslated"
+  // Approximate constructs are translated with a difference in
+  // behaviour.
+  Approximate = "approximate"
+  // Review marks settings that were translated but need checking.
+  Review = "review"
+)
+
+// Lease backends the configuration and lease import can target.
+const (
+  BackendMemfile    = "memfile"
+  BackendMySQL      = "mysql"
+  BackendPostgreSQL = "postgresql"
+)
+
+// Finding is a report entry about one statement.
+type Finding struct {
+  Line      int
+  Severity  string
+  Statement string
+  Message   string
+}
+
+// Params are the parameters Kea inherits from global to shared network
+// to subnet; classes and reservations take a few of them.
+type Params struct {
+  ValidLifetime          *int             `json:"valid-lifetime,omitempty"`
+  MinValidLifetime       *int             `json:"min-valid-lifetime,omitempty"`
+  MaxValidLifetime       *int             `json:"max-valid-lifetime,omitempty"`
+  RenewTimer             *int             `json:"renew-timer,omitempty"`
+  RebindTimer            *int             `json:"rebind-timer,omitempty"`
+  DeclineProbationPeriod *int             `json:"decline-probation-period,omitempty"`
+  Authoritative          *bool            `json:"authoritative,omitempty"`
+  MatchClientID          *bool            `json:"match-client-id,omitempty"`
+  DDNSSendUpdates        *bool            `json:"ddns-send-updates,omitempty"`
+  DDNSQualifyingSuffix   string           `json:"ddns-qualifying-suffix,omitempty"`
+  NextServer             string           `json:"next-server,omitempty"`
+  ServerHostname         string           `json:"server-hostname,omitempty"`
+  BootFileName           string           `json:"boot-file-name,omitempty"`
+  OptionData             []kea.OptionData `json:"option-data,omitempty"`
+}
+
+// inherit fills the parameters p leaves unset from q.
+func (p *Params) inherit(q Params) {
+  for _, pair := range []struct{ dst, src **int }{
+    {&p.ValidLifetime, &q.ValidLifetime},
+    {&p.MinValidLifetime, &q.MinValidLifetime},
+    {&p.MaxValidLifetime, &q.MaxValidLifetime},
+    {&p.RenewTimer, &q.RenewTimer},
+    {&p.RebindTimer, &q.RebindTimer},
+    {&p.DeclineProbationPeriod, &q.DeclineProbationPeriod},
+  } {
+    if *pair.dst == nil {
+      *pair.dst = *pair.src
+    }
+  }
+  for _, pair := range []struct{ dst, src **bool }{
+    {&p.Authoritative, &q.Authoritative},
+    {&p.MatchClientID, &q.MatchClientID},
+    {&p.DDNSSendUpdates, &q.DDNSSendUpdates},
+  } {
+    if *pair.dst == nil {
+      *pair.dst = *pair.src
+    }
+  }
+  for _, pair := range []struct{ dst, src *string }{
+    {&p.DDNSQualifyingSuffix, &q.DDNSQualifyingSuffix},
+    {&p.NextServer, &q.NextServer},
+    {&p.ServerHostname, &q.ServerHostname},
+    {&p.BootFileName, &q.BootFileName},
+  } {
+    if *pair.dst == "" {
+      *pair.dst = *pair.src
+    }
+  }
+  for _, o := range q.OptionData {
+    if !slices.ContainsFunc(p.OptionData, func(x kea.OptionData) bool { return sameOption(x, o) }) {
+      p.OptionData = append(p.OptionData, o)
+    }
+  }
+}
+
+func sameOption(a, b kea.OptionData) bool {
+  return a.Name == b.Name && a.Space == b.Space && a.Code == b.Code
+}
+
+// setOption adds o to the list, replacing an earlier value.
+func setOption(list []kea.OptionData, o kea.OptionData) []kea.OptionData {
+  for i, x := range list {
+    if sameOption(x, o) {
+      list[i] = o
+      return list
+    }
+  }
+  return append(list, o)
+}
+
+// Subnet is a subnet4 entry.
+type Subnet struct {
+  ID     int64  `json:"id"`
+  Subnet string `json:"subnet"`
+  Params
+  Pools        []kea.Pool        `json:"pools,omitempty"`
+  Reservations []kea.Reservation `json:"reservations,omitempty"`
+
+  prefix netip.Prefix
+}
+
+// SharedNetwork is a shared-networks entry.
+type SharedNetwork struct {
+  Name string `json:"name"`
+  Params
+  Subnet4 []*Subnet `json:"subnet4"`
+}
+
+// Class is a client-classes entry.
+type Class struct {
+  Name         string `json:"name"`
+  Test         string `json:"test,omitempty"`
+  TemplateTest string `json:"template-test,omitempty"`
+  Params
+  UserContext map[string]string `json:"user-context,omitempty"`
+
+  // match is the data expression subclasses are compared with.
+  match    string
+  subclass []string
+}
+
+// Hook is a hooks-libraries entry.
+type Hook struct {
+  Library    string         `json:"library"`
+  Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+// Config is the Dhcp4 element produced from a dhcpd.conf.
+type Config struct {
+  InterfacesConfig map[string]any `json:"interfaces-config"`
+  LeaseDatabase    map[string]any `json:"lease-database"`
+  Params
+  ReservationsGlobal   *bool                 `json:"reservations-global,omitempty"`
+  ReservationsInSubnet *bool                 `json:"reservations-in-subnet,omitempty"`
+  OptionDef            []kea.ConfigOptionDef `json:"option-def,omitempty"`
+  ClientClasses        []*Class              `json:"client-classes,omitempty"`
+  SharedNetworks       []*SharedNetwork      `json:"shared-networks,omitempty"`
+  Subnet4              []*Subnet             `json:"subnet4,omitempty"`
+  Reservations         []kea.Reservation     `json:"reservations,omitempty"`
+  HooksLibraries       []Hook                `json:"hooks-libraries,omitempty"`
+}
+
+// Subnets returns every subnet, those of shared networks included.
+func (c *Config) Subnets() []*Subnet {
+  subnets := slices.Clone(c.Subnet4)
+  for _, n := range c.SharedNetworks {
+    subnets = append(subnets, n.Subnet4...)
+  }
+  return subnets
+}
+
+// SubnetFor returns the subnet holding addr, or nil.
+func (c *Config) SubnetFor(addr netip.Addr) *Subnet {
+  for _, s := range c.Subnets() {
+    if s.prefix.Contains(addr) {
+      return s
+    }
+  }
+  return nil
+}
+
+// Summary counts what a conversion produced.
+type Summary struct {
+  Subnets        int
+  SharedNetworks int
+  Pools          int
+  Reservations   int
+  Classes        int
+  OptionDefs     int
+}
+
+// Result is a converted configuration and its report.
+type Result struct {
+  Config   *Config
+  Findings []Finding
+}
+
+// Summary counts the configuration's parts.
+func (r *Result) Summary() Summary {
+  s := Summary{
+    SharedNetworks: len(r.Config.SharedNetworks),
+    Reservations:   len(r.Config.Reservations),
+    Classes:        len(r.Config.ClientClasses),
+    OptionDefs:     len(r.Config.OptionDef),
+  }
+  for _, sub := range r.Config.Subnets() {
+    s.Subnets++
+    s.Pools += len(sub.Pools)
+    s.Reservations += len(sub.Reservations)
+  }
+  return s
+}
+
+// Count returns the number of findings of a severity.
+func (r *Result) Count(severity string) int {
+  n := 0
+  for _, f := range r.Findings {
+    if f.Severity == severity {
+      n++
+    }
+  }
+  return n
+}
+
+// JSON renders the configuration as a Kea configuration file.
+func (r *Result) JSON() ([]byte, error) {
+  b, err := json.MarshalIndent(map[string]any{"Dhcp4": r.Config}, "", "  ")
+  if err != nil {
+    return nil, err
+  }
+  return append(b, '\n'), nil
+}
+
+// Options control a conversion.
+type Options struct {
+  // LeaseBackend is the lease-database type to write: memfile, mysql or
+  // postgresql.
+  LeaseBackend string
+}
+
+// unsupported explains the statements that have no Kea counterpart.
+var unsupported = map[string]string{
+  "ping-check":                 "Kea checks addresses before offering them only with the ping_check hook",
+  "ping-timeout":               "Kea checks addresses before offering them only with the ping_check hook",
+  "ping-cltt-secs":             "Kea checks addresses before offering them only with the ping_check hook",
+  "get-lease-hostnames":        "Kea doesn't look up host names of addresses",
+  "log-facility":               "logging is set up in the loggers element",
+  "lease-file-name":            "the lease file is the name of the memfile lease-database",
+  "pid-file-name":              "Kea manages its own PID file",
+  "local-port":                 "the port is a command line option of kea-dhcp4",
+  "remote-port":                "the port is a command line option of kea-dhcp4",
+  "local-address":              "list the interface with its address (eth0/192.0.2.1) in interfaces-config",
+  "omapi-port":                 "OMAPI is replaced by the control socket and the Control Agent",
+  "omapi-key":                  "OMAPI is replaced by the control socket and the Control Agent",
+  "db-time-format":             "Kea stores times as it needs",
+  "lease-id-format":            "Kea writes identifiers as hex",
+  "stash-agent-options":        "Kea 2.7 and later have a stash-agent-options parameter",
+  "dynamic-bootp-lease-length": "BOOTP clients need the bootp hook and get infinite leases",
+  "dynamic-bootp-lease-cutoff": "BOOTP clients need the bootp hook and get infinite leases",
+  "boot-unknown-clients":       "guard the pools with the KNOWN class instead",
+  "always-broadcast":           "Kea follows the broadcast flag of the client",
+  "always-reply-rfc1048":       "Kea always answers with DHCP options",
+  "update-static-leases":       "DNS updates are configured with the ddns-* parameters and kea-dhcp-ddns",
+  "update-conflict-detection":  "DNS updates are configured with the ddns-* parameters and kea-dhcp-ddns",
+  "update-optimization":        "DNS updates are configured with the ddns-* parameters and kea-dhcp-ddns",
+  "ddns-rev-domainname":        "DNS updates are configured with the ddns-* parameters and kea-dhcp-ddns",
+  "ddns-ttl":                   "DNS updates are configured with the ddns-* parameters and kea-dhcp-ddns",
+  "server-identifier":          "Kea uses the address of the receiving interface; option dhcp-server-identifier overrides it",
+  "vendor-option-space":        "define the vendor's options in the vendor-encapsulated-options-space",
+  "site-option-space":          "define site options in the dhcp4 space",
+  "min-secs":                   "Kea has no minimum secs setting",
+  "one-lease-per-client":       "Kea keeps one lease per client already",
+  "infinite-is-reserved":       "Kea has no equivalent",
+  "server-duid":                "a DHCPv6 setting",
+  "authoring-byte-order":       "a lease file setting",
+  "key":                        "TSIG keys belong in the kea-dhcp-ddns configuration",
+  "zone":                       "DNS zones belong in the kea-dhcp-ddns configuration",
+  "on":                         "lease event scripts need the run_script hook",
+  "if":                         "conditional statements have no equivalent; express the condition as a client class",
+  "elsif":                      "conditional statements have no equivalent; express the condition as a client class",
+  "else":                       "conditional statements have no equivalent; express the condition as a client class",
+  "include":                    "inline the included file before converting",
+  "set":                        "lease variables have no Kea equivalent",
+  "unset":                      "lease variables have no Kea equivalent",
+  "execute":                    "running programs needs the run_script hook",
+  "log":                        "logging statements have no Kea equivalent",
+  "subnet6":                    "DHCPv6 belongs in a Dhcp6 configuration",
+  "range6":                     "DHCPv6 belongs in a Dhcp6 configuration",
+  "prefix6":                    "DHCPv6 belongs in a Dhcp6 configuration",
+  "fixed-address6":             "DHCPv6 belongs in a Dhcp6 configuration",
+  "fixed-prefix6":              "DHCPv6 belongs in a Dhcp6 configuration",
+}
+
+// declarations are the statements that open a scope.
+var declarations = map[string]bool{
+  "subnet":         true,
+  "shared-network": true,
+  "pool":           true,
+  "host":           true,
+  "group":          true,
+  "class":          true,
+  "subclass":       true,
+  "failover":       true,
+  "range":          true,
+}
+
+// scope is the context statements are read in.
+type scope struct {
+  kind    string
+  network *SharedNetwork
+  subnet  *Subnet
+  // params receives the block's parameters.
+  params *Params
+  // group collects the parameters of enclosing groups, which Kea has
+  // no place for: they are copied into the networks, subnets and hosts
+  // declared below.
+  group     Params
+  hostNames bool
+  // allow and deny are the pool permits in effect.
+  allow, deny []string
+  // ranges collects the address ranges of a pool.
+  ranges []string
+}
+
+func (sc *scope) child(kind string) *scope {
+  c := *sc
+  c.kind, c.ranges = kind, nil
+  c.allow, c.deny = slices.Clone(sc.allow), slices.Clone(sc.deny)
+  return &c
+}
+
+// pendingHost is a host declaration, placed once all subnets are known.
+type pendingHost struct {
+  st *statement
+  sc *scope
+}
+
+// pendingPool is a pool declared in a shared network, placed in the
+// subnet holding it.
+type pendingPool struct {
+  st   *statement
+  pool kea.Pool
+  net  *SharedNetwork
+}
+
+// failoverPeer is a failover peer declaration.
+type failoverPeer struct {
+  line                 int
+  name                 string
+  primary              bool
+  address, peerAddress string
+  split                int
+  maxResponseDelay     int
+}
+
+type converter struct {
+  opts     *options
+  res      *Result
+  cfg      *Config
+  nextID   int64
+  classes  map[string]*Class
+  guards   map[string]string
+  hosts    []pendingHost
+  pools    []pendingPool
+  failover []*failoverPeer
+}
+
+// Convert translates a dhcpd.conf into a Dhcp4 configuration. Only
+// syntax errors fail; anything that can't be translated is reported.
+func Convert(src string, o Options) (*Result, error) {
+  stmts, err := parse(src)
+  if err != nil {
+    return nil, err
+  }
+  c := &converter{
+    opts:    newOptions(),
+    res:     &Result{},
+    cfg:     &Config{InterfacesConfig: map[string]any{"interfaces": []string{"*"}}},
+    nextID:  1,
+    classes: map[string]*Class{},
+    guards:  map[string]string{},
+  }
+  c.res.Config = c.cfg
+  c.cfg.LeaseDatabase = leaseDatabase(o.LeaseBackend)
+  c.note(0, Review, "", "dhcpd serves the interfaces named on its command line; list them in interfaces-config instead of *")
+  if o.LeaseBackend == BackendMySQL || o.LeaseBackend == BackendPostgreSQL {
+    c.note(0, Review, "", "fill in the lease database credentials and create its schema with kea-admin db-init")
+  }
+
+  top := &scope{kind: "global", params: &c.cfg.Params}
+  c.block(stmts, top)
+  c.placePools()
+  c.placeHosts()
+  c.finishClasses()
+  c.finishFailover()
+
+  sort.SliceStable(c.res.Findings, func(i, j int) bool { return c.res.Findings[i].Line < c.res.Findings[j].Line })
+  return c.res, nil
+}
+
+func leaseDatabase(backend string) map[string]any {
+  switch backend {
+  case BackendMySQL, BackendPostgreSQL:
+    return map[string]any{"type": backend, "name": "kea", "host": "localhost", "user": "kea", "password": "kea"}
+  }
+  return map[string]any{"type": "memfile", "persist": true, "lfc-interval": 3600}
+}
+
+func (c *converter) note(line int, severity, stmt, format string, a ...any) {
+  c.res.Findings = append(c.res.Findings, Finding{Line: line, Severity: severity, Statement: stmt, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *converter) report(st *statement, severity, format string, a ...any) {
+  c.note(st.line, severity, st.text(), format, a...)
+}
+
+// block reads the statements of a scope: its parameters first, as dhcpd
+// applies them to the whole scope, then its declarations.
+func (c *converter) block(stmts []*statement, sc *scope) {
+  for _, st := range stmts {
+    if !c.isDeclaration(st) {
+      c.param(st, sc, sc.params)
+    }
+  }
+  for _, st := range stmts {
+    if c.isDeclaration(st) {
+      c.declaration(st, sc)
+    }
+  }
+}
+
+func (c *converter) isDeclaration(st *statement) bool {
+  kw := st.keyword()
+  if kw == "failover" {
+    return st.braces
+  }
+  return declarations[kw]
+}
+
+func intArg(st *statement, i int) (*int, error) {
+  n, err := strconv.Atoi(st.arg(i))
+  if err != nil || n < 0 {
+    return nil, fmt.Errorf("%q is not a number", st.arg(i))
+  }
+  return &n, nil
+}
+
+func stringArg(st *statement, i int) string {
+  if i+1 < len(st.words) && st.words[i+1].kind == tokString {
+    return string(unquote(st.words[i+1].text))
+  }
+  return st.arg(i)
+}
+
+func flagArg(st *statement, i int) (*bool, error) {
+  switch strings.ToLower(st.arg(i)) {
+  case "on", "true", "":
+    v := true
+    return &v, nil
+  case "off", "false":
+    v := false
+    return &v, nil
+  }
+  return nil, fmt.Errorf("%q is not on or off", st.arg(i))
+}
+
+// fieldAllowed reports whether a parameter can be set in the scope,
+// reporting it otherwise.
+func (c *converter) fieldAllowed(st *statement, sc *scope, field string) bool {
+  ok := true
+  switch sc.kind {
+  case "pool":
+    ok = field == "option-data"
+  case "host":
+    ok = slices.Contains([]string{"option-data", "next-server", "server-hostname", "boot-file-name"}, field)
+  case "class":
+    ok = slices.Contains([]string{"option-data", "next-server", "server-hostname", "boot-file-name", "valid-lifetime", "min-valid-lifetime", "max-valid-lifetime"}, field)
+  case "shared-network", "subnet":
+    ok = field != "decline-probation-period"
+  }
+  if !ok {
+    c.report(st, Untranslated, "Kea has no %s for a %s", field, sc.kind)
+  }
+  return ok
+}
+
+// param reads a parameter statement into p.
+func (c *converter) param(st *statement, sc *scope, p *Params) {
+  kw := st.keyword()
+  setInt := func(dst **int, field string) {
+    v, err := intArg(st, 0)
+    if err != nil {
+      c.report(st, Untranslated, "%v", err)
+      return
+    }
+    if c.fieldAllowed(st, sc, field) {
+      *dst = v
+    }
+  }
+  setString := func(dst *string, field string) {
+    if c.fieldAllowed(st, sc, field) {
+      *dst = stringArg(st, 0)
+    }
+  }
+  setFlag := func(dst **bool, field string, arg int) {
+    v, err := flagArg(st, arg)
+    if err != nil {
+      c.report(st, Untranslated, "%v", err)
+      return
+    }
+    if c.fieldAllowed(st, sc, field) {
+      *dst = v
+    }
+  }
+
+  switch kw {
+  case "option":
+    c.option(st, sc, p)
+  case "default-lease-time":
+    setInt(&p.ValidLifetime, "valid-lifetime")
+  case "min-lease-time":
+    setInt(&p.MinValidLifetime, "min-valid-lifetime")
+  case "max-lease-time":
+    setInt(&p.MaxValidLifetime, "max-valid-lifetime")
+  case "abandon-lease-time":
+    setInt(&p.DeclineProbationPeriod, "decline-probation-period")
+    c.report(st, Approximate, "Kea applies decline-probation-period to declined addresses; it doesn't ping before offering")
+  case "next-server":
+    setString(&p.NextServer, "next-server")
+  case "filename":
+    setString(&p.BootFileName, "boot-file-name")
+  case "server-name":
+    setString(&p.ServerHostname, "server-hostname")
+  case "authoritative":
+    setFlag(&p.Authoritative, "authoritative", 99)
+  case "not":
+    if strings.EqualFold(st.arg(0), "authoritative") {
+      v := false
+      if c.fieldAllowed(st, sc, "authoritative") {
+        p.Authoritative = &v
+      }
+      return
+    }
+    c.report(st, Untranslated, "unknown statement")
+  case "ddns-update-style":
+    v := !strings.EqualFold(st.arg(0), "none")
+    if c.fieldAllowed(st, sc, "ddns-send-updates") {
+      p.DDNSSendUpdates = &v
+    }
+    if v {
+      c.report(st, Review, "DNS updates are sent by kea-dhcp-ddns; enable dhcp-ddns and configure its forward and reverse zones")
+    }
+  case "ddns-updates":
+    setFlag(&p.DDNSSendUpdates, "ddns-send-updates", 0)
+  case "ddns-domainname":
+    setString(&p.DDNSQualifyingSuffix, "ddns-qualifying-suffix")
+  case "ignore-client-uids":
+    v, err := flagArg(st, 0)
+    if err != nil {
+      c.report(st, Untranslated, "%v", err)
+      return
+    }
+    ignore := !*v
+    if c.fieldAllowed(st, sc, "match-client-id") {
+      p.MatchClientID = &ignore
+    }
+  case "use-host-decl-names":
+    v, err := flagArg(st, 0)
+    if err != nil {
+      c.report(st, Untranslated, "%v", err)
+      return
+    }
+    sc.hostNames = *v
+  case "allow", "deny", "ignore":
+    c.permit(st, sc)
+  case "failover":
+    // A pool's failover peer: Kea's HA hook covers every pool.
+    if sc.kind != "pool" {
+      c.report(st, Untranslated, "unknown statement")
+    }
+  case "range":
+    c.report(st, Untranslated, "a range outside a subnet, shared network or pool")
+  case "deleted", "dynamic":
+  default:
+    if msg, ok := unsupported[kw]; ok {
+      c.report(st, Untranslated, "%s", msg)
+      return
+    }
+    c.report(st, Untranslated, "unknown statement")
+  }
+}
+
+// option reads an option definition or value.
+func (c *converter) option(st *statement, sc *scope, p *Params) {
+  if strings.EqualFold(st.arg(0), "space") {
+    c.report(st, Review, "option spaces need no declaration in Kea")
+    return
+  }
+  if strings.EqualFold(st.arg(1), "code") {
+    if sc.kind != "global" {
+      c.report(st, Untranslated, "option definitions must be global in Kea")
+      return
+    }
+    def, note, err := c.opts.define(st)
+    if err != nil {
+      c.report(st, Untranslated, "%v", err)
+      return
+    }
+    if note != "" {
+      c.report(st, Review, "%s; the definition is left out", note)
+    }
+    if def != nil {
+      c.cfg.OptionDef = append(c.cfg.OptionDef, *def)
+    }
+    return
+  }
+
+  // Lease times dhcpd sends as options are parameters in Kea.
+  switch strings.ToLower(st.arg(0)) {
+  case "dhcp-lease-time":
+    if v, err := intArg(st, 1); err == nil && c.fieldAllowed(st, sc, "valid-lifetime") {
+      p.ValidLifetime = v
+      return
+    }
+  case "dhcp-renewal-time":
+    if v, err := intArg(st, 1); err == nil && c.fieldAllowed(st, sc, "renew-timer") {
+      p.RenewTimer = v
+      return
+    }
+  case "dhcp-rebinding-time":
+    if v, err := intArg(st, 1); err == nil && c.fieldAllowed(st, sc, "rebind-timer") {
+      p.RebindTimer = v
+      return
+    }
+  }
+
+  data, approx, err := c.opts.optionValue(st)
+  if err != nil {
+    c.report(st, Untranslated, "%v", err)
+    return
+  }
+  if approx != "" {
+    c.report(st, Approximate, "%s", approx)
+  }
+  p.OptionData = setOption(p.OptionData, data)
+}
+
+// permitClass returns the class an allow/deny statement names, or "".
+func permitClass(st *statement) string {
+  rest := strings.ToLower(strings.Join(wordsText(st.words[1:]), " "))
+  switch rest {
+  case "unknown-clients", "unknown clients":
+    return "UNKNOWN"
+  case "known-clients", "known clients":
+    return "KNOWN"
+  }
+  if strings.HasPrefix(rest, "members of ") && len(st.words) == 4 {
+    return stringArg(st, 2)
+  }
+  return ""
+}
+
+func wordsText(toks []token) []string {
+  out := make([]string, len(toks))
+  for i, t := range toks {
+    out[i] = t.text
+  }
+  return out
+}
+
+// permit reads an allow, deny or ignore statement.
+func (c *converter) permit(st *statement, sc *scope) {
+  allow := st.keyword() == "allow"
+  class := permitClass(st)
+  what := strings.ToLower(st.arg(0))
+  switch {
+  case class != "":
+    if allow && sc.kind != "pool" && class == "UNKNOWN" {
+      // Outside pools, unknown clients are allowed by default.
+      return
+    }
+    if allow {
+      sc.allow = append(sc.allow, class)
+    } else {
+      sc.deny = append(sc.deny, class)
+    }
+  case what == "all":
+    if !allow {
+      sc.deny = append(sc.deny, "ALL")
+    }
+  case what == "bootp" || what == "dynamic":
+    if allow {
+      c.report(st, Untranslated, "Kea answers BOOTP clients only with the bootp hook")
+    }
+  case what == "booting":
+    if !allow {
+      c.report(st, Untranslated, "Kea has no equivalent of deny booting")
+    }
+  case what == "duplicates", what == "declines", what == "client-updates", what == "leasequery":
+    c.report(st, Untranslated, "Kea has no equivalent of %s %s", st.keyword(), what)
+  case what == "authenticated" || what == "unauthenticated":
+    c.report(st, Untranslated, "dhcpd's client authentication was never implemented")
+  default:
+    c.report(st, Untranslated, "unknown permit")
+  }
+}
+
+// guard returns the client classes of a pool with the given permits.
+// Kea pools admit members of any listed class; denies and mixed permits
+// are written as a generated class.
+func (c *converter) guard(allow, deny []string) []string {
+  slices.Sort(allow)
+  allow = slices.Compact(allow)
+  slices.Sort(deny)
+  deny = slices.Compact(deny)
+  switch {
+  case len(deny) == 0:
+    return allow
+  case len(allow) == 0 && len(deny) == 1 && deny[0] == "UNKNOWN":
+    return []string{"KNOWN"}
+  case len(allow) == 0 && len(deny) == 1 && deny[0] == "KNOWN":
+    return []string{"UNKNOWN"}
+  }
+
+  var parts []string
+  if len(allow) > 0 {
+    var members []string
+    for _, a := range allow {
+      members = append(members, "member('"+a+"')")
+    }
+    if len(members) > 1 {
+      parts = append(parts, "("+strings.Join(members, " or ")+")")
+    } else {
+      parts = append(parts, members[0])
+    }
+  }
+  for _, d := range deny {
+    parts = append(parts, "not member('"+d+"')")
+  }
+  test := strings.Join(parts, " and ")
+  if name, ok := c.guards[test]; ok {
+    return []string{name}
+  }
+  name := fmt.Sprintf("pool-permit-%d", len(c.guards)+1)
+  c.guards[test] = name
+  c.cfg.ClientClasses = append(c.cfg.ClientClasses, &Class{Name: name, Test: test})
+  return []string{name}
+}
+
+func (c *converter) declaration(st *statement, sc *scope) {
+  switch st.keyword() {
+  case "subnet":
+    c.subnet(st, sc)
+  case "shared-network":
+    c.sharedNetwork(st, sc)
+  case "group":
+    g := sc.child("group")
+    g.params = &g.group
+    c.block(st.block, g)
+  case "pool":
+    c.pool(st, sc)
+  case "range":
+    c.rangeStmt(st, sc)
+  case "host":
+    c.hosts = append(c.hosts, pendingHost{st, sc})
+  case "class":
+    c.class(st, sc)
+  case "subclass":
+    c.subclass(st, sc)
+  case "failover":
+    c.failoverPeer(st, sc)
+  }
+}
+
+func (c *converter) subnet(st *statement, sc *scope) {
+  if sc.kind != "global" && sc.kind != "group" && sc.kind != "shared-network" {
+    c.report(st, Untranslated, "a subnet inside a %s", sc.kind)
+    return
+  }
+  addr, err := netip.ParseAddr(st.arg(0))
+  mask := net.ParseIP(st.arg(2)).To4()
+  if err != nil || !addr.Is4() || !strings.EqualFold(st.arg(1), "netmask") || mask == nil {
+    c.report(st, Untranslated, "malformed subnet declaration")
+    return
+  }
+  bits, size := net.IPMask(mask).Size()
+  if size == 0 {
+    c.report(st, Untranslated, "netmask %s is not contiguous", st.arg(2))
+    return
+  }
+  prefix := netip.PrefixFrom(addr, bits)
+  if prefix.Masked() != prefix {
+    c.report(st, Approximate, "%s is not the network address of %s", addr, prefix.Masked())
+    prefix = prefix.Masked()
+  }
+
+  sub := &Subnet{ID: c.nextID, Subnet: prefix.String(), prefix: prefix}
+  c.nextID++
+  s := sc.child("subnet")
+  s.subnet, s.params, s.group = sub, &sub.Params, Params{}
+  c.block(st.block, s)
+  sub.Params.inherit(sc.group)
+
+  if sc.network != nil {
+    sc.network.Subnet4 = append(sc.network.Subnet4, sub)
+  } else {
+    c.cfg.Subnet4 = append(c.cfg.Subnet4, sub)
+  }
+}
+
+func (c *converter) sharedNetwork(st *statement, sc *scope) {
+  if sc.kind != "global" && sc.kind != "group" {
+    c.report(st, Untranslated, "a shared network inside a %s", sc.kind)
+    return
+  }
+  n := &SharedNetwork{Name: stringArg(st, 0)}
+  s := sc.child("shared-network")
+  s.network, s.params, s.group = n, &n.Params, Params{}
+  c.block(st.block, s)
+  n.Params.inherit(sc.group)
+  if len(n.Subnet4) == 0 {
+    c.report(st, Untranslated, "shared network %s has no subnets", n.Name)
+    return
+  }
+  c.cfg.SharedNetworks = append(c.cfg.SharedNetworks, n)
+}
+
+// parseRange reads "range [dynamic-bootp] first [last]".
+func (c *converter) parseRange(st *statement) (string, netip.Addr, bool) {
+  args := wordsText(st.words[1:])
+  if len(args) > 0 && strings.EqualFold(args[0], "dynamic-bootp") {
+    c.report(st, Untranslated, "Kea answers BOOTP clients only with the bootp hook; the range is kept as a normal pool")
+    args = args[1:]
+  }
+  if len(args) == 0 || len(args) > 2 {
+    c.report(st, Untranslated, "malformed range")
+    return "", netip.Addr{}, false
+  }
+  first, err := netip.ParseAddr(args[0])
+  last := first
+  if err == nil && len(args) == 2 {
+    last, err = netip.ParseAddr(args[1])
+  }
+  if err != nil || !first.Is4() || !last.Is4() {
+    c.report(st, Untranslated, "malformed range")
+    return "", netip.Addr{}, false
+  }
+  if last.Less(first) {
+    first, last = last, first
+  }
+  return first.String() + " - " + last.String(), first, true
+}
+
+// rangeStmt reads a range of a subnet, shared network or pool.
+func (c *converter) rangeStmt(st *statement, sc *scope) {
+  r, first, ok := c.parseRange(st)
+  if !ok {
+    return
+  }
+  switch {
+  case sc.kind == "pool":
+    sc.ranges = append(sc.ranges, r)
+  case sc.subnet != nil:
+    if !sc.subnet.prefix.Contains(first) {
+      c.report(st, Untranslated, "the range is outside subnet %s", sc.subnet.Subnet)
+      return
+    }
+    sc.subnet.Pools = append(sc.subnet.Pools, c.makePool(r, sc, nil))
+  case sc.network != nil:
+    c.pools = append(c.pools, pendingPool{st, c.makePool(r, sc, nil), sc.network})
+  default:
+    c.report(st, Untranslated, "a range outside a subnet, shared network or pool")
+  }
+}
+
+func (c *converter) makePool(r string, sc *scope, opts []kea.OptionData) kea.Pool {
+  p := kea.Pool{Pool: r, OptionData: opts}
+  classes := c.guard(sc.allow, sc.deny)
+  if len(classes) == 1 {
+    p.ClientClass = classes[0]
+  } else {
+    p.ClientClasses = classes
+  }
+  return p
+}
+
+func (c *converter) pool(st *statement, sc *scope) {
+  if sc.subnet == nil && sc.network == nil {
+    c.report(st, Untranslated, "a pool outside a subnet or shared network")
+    return
+  }
+  var params Params
+  s := sc.child("pool")
+  s.params = &params
+  c.block(st.block, s)
+  if len(s.ranges) == 0 {
+    c.report(st, Untranslated, "the pool has no range")
+    return
+  }
+  classes := c.guard(s.allow, s.deny)
+  if len(classes) > 1 {
+    c.report(st, Review, "pools admitting several classes use client-classes, which needs Kea 2.7 or later")
+  }
+  for _, r := range s.ranges {
+    p := c.makePool(r, s, params.OptionData)
+    if sc.subnet != nil {
+      sc.subnet.Pools = append(sc.subnet.Pools, p)
+    } else {
+      c.pools = append(c.pools, pendingPool{st, p, sc.network})
+    }
+  }
+}
+
+// placePools moves the pools declared in shared networks into the
+// subnet holding their addresses.
+func (c *converter) placePools() {
+  for _, pp := range c.pools {
+    first, _, err := pp.pool.Range()
+    placed := false
+    for _, sub := range pp.net.Subnet4 {
+      if err == nil && sub.prefix.Contains(first) {
+        sub.Pools = append(sub.Pools, pp.pool)
+        placed = true
+        break
+      }
+    }
+    if !placed {
+      c.report(pp.st, Untranslated, "no subnet of shared network %s holds %s", pp.net.Name, pp.pool.Pool)
+    }
+  }
+}
+
+// identifier reads a host's hardware or client identifier statement.
+func (c *converter) identifier(st *statement, r *kea.Reservation) bool {
+  switch st.keyword() {
+  case "hardware":
+    if len(st.words) != 3 {
+      c.report(st, Untranslated, "malformed hardware statement")
+      return true
+    }
+    mac, err := net.ParseMAC(st.arg(1))
+    if err != nil {
+      if b, ok := parseHex(st.arg(1)); ok {
+        mac = b
+      } else {
+        c.report(st, Untranslated, "malformed hardware address")
+        return true
+      }
+    }
+    r.HWAddress = dhcp.HexString(mac)
+    return true
+  case "uid":
+    if b, ok := bytesOf(st.words[len(st.words)-1]); ok {
+      r.ClientID = dhcp.HexString(b)
+    }
+    return true
+  case "host-identifier":
+    if len(st.words) != 4 || !st.words[1].is("option") {
+      c.report(st, Untranslated, "only host-identifier option is supported")
+      return true
+    }
+    b, ok := bytesOf(st.words[3])
+    if !ok {
+      c.report(st, Untranslated, "malformed identifier")
+      return true
+    }
+    switch strings.ToLower(st.arg(1)) {
+    case "agent.circuit-id":
+      r.CircuitID = dhcp.HexString(b)
+    case "dhcp-client-identifier":
+      r.ClientID = dhcp.HexString(b)
+    case "agent.remote-id":
+      r.FlexID = dhcp.HexString(b)
+      c.report(st, Review, "remote-id reservations become flex-id ones; load the flex_id hook with identifier-expression relay4[2].hex")
+    default:
+      c.report(st, Untranslated, "Kea reserves by hw-address, client-id, circuit-id or flex-id only")
+    }
+    return true
+  case "option":
+    if strings.EqualFold(st.arg(0), "dhcp-client-identifier") && len(st.words) == 3 {
+      if b, ok := bytesOf(st.words[2]); ok {
+        r.ClientID = dhcp.HexString(b)
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// placeHosts turns the host declarations into reservations, in the
+// subnet holding their fixed address.
+func (c *converter) placeHosts() {
+  seen := map[string]bool{}
+  for _, h := range c.hosts {
+    st := h.st
+    r := kea.Reservation{}
+    var params Params
+    s := h.sc.child("host")
+    s.params = &params
+    var fixed []string
+    for _, b := range st.block {
+      if c.identifier(b, &r) {
+        continue
+      }
+      switch b.keyword() {
+      case "fixed-address":
+        for _, t := range b.words[1:] {
+          if !t.is(",") {
+            fixed = append(fixed, t.text)
+          }
+        }
+      case "ddns-hostname":
+        r.Hostname = stringArg(b, 0)
+      case "option":
+        if strings.EqualFold(b.arg(0), "host-name") {
+          r.Hostname = stringArg(b, 1)
+          continue
+        }
+        c.param(b, s, s.params)
+      default:
+        if b.braces {
+          c.report(b, Untranslated, "a declaration inside a host")
+          continue
+        }
+        c.param(b, s, s.params)
+      }
+    }
+    params.inherit(h.sc.group)
+    name := stringArg(st, 0)
+    if r.Hostname == "" && s.hostNames {
+      r.Hostname = name
+    }
+    r.NextServer, r.ServerHostname, r.BootFileName, r.OptionData = params.NextServer, params.ServerHostname, params.BootFileName, params.OptionData
+
+    idType, id := r.Identifier()
+    if idType == "" {
+      c.report(st, Untranslated, "host %s has no hardware address or client identifier", name)
+      continue
+    }
+
+    var sub *Subnet
+    if len(fixed) > 0 {
+      addr, err := netip.ParseAddr(fixed[0])
+      if err != nil || !addr.Is4() {
+        c.report(st, Untranslated, "host %s: fixed-address %s must be an address in Kea", name, fixed[0])
+        continue
+      }
+      if len(fixed) > 1 {
+        c.report(st, Approximate, "host %s: Kea reserves one address; %s is kept and %s dropped", name, fixed[0], strings.Join(fixed[1:], ", "))
+      }
+      r.IPAddress = addr.String()
+      if sub = c.cfg.SubnetFor(addr); sub == nil {
+        c.report(st, Review, "host %s: no subnet holds %s, so it is a global reservation", name, addr)
+      }
+    } else {
+      sub = h.sc.subnet
+    }
+
+    key := idType + "/" + id
+    if sub != nil {
+      key += "/" + strconv.FormatInt(sub.ID, 10)
+    }
+    if seen[key] {
+      c.report(st, Untranslated, "host %s: another host already reserves %s %s here", name, idType, id)
+      continue
+    }
+    seen[key] = true
+    if sub != nil {
+      sub.Reservations = append(sub.Reservations, r)
+    } else {
+      c.cfg.Reservations = append(c.cfg.Reservations, r)
+    }
+  }
+  if len(c.cfg.Reservations) > 0 {
+    t := true
+    c.cfg.ReservationsGlobal, c.cfg.ReservationsInSubnet = &t, &t
+  }
+}
+
+// translation reports an expression that couldn't be translated and
+// keeps the original in the class's user context.
+func (c *converter) translation(st *statement, cl *Class, err error) {
+  var ee *exprError
+  if errors.As(err, &ee) {
+    c.report(st, Untranslated, "class %s: %v; the class is kept without a test and never matches", cl.Name, err)
+  } else {
+    c.report(st, Untranslated, "class %s: cannot translate the expression (%v); the class is kept without a test and never matches", cl.Name, err)
+  }
+  if cl.UserContext == nil {
+    cl.UserContext = map[string]string{}
+  }
+  cl.UserContext["dhcpd"] = st.text()
+}
+
+func (c *converter) class(st *statement, sc *scope) {
+  name := stringArg(st, 0)
+  if _, dup := c.classes[name]; dup {
+    c.report(st, Untranslated, "class %s is declared twice", name)
+    return
+  }
+  cl := &Class{Name: name}
+  c.classes[name] = cl
+  c.cfg.ClientClasses = append(c.cfg.ClientClasses, cl)
+
+  s := sc.child("class")
+  s.params = &cl.Params
+  for _, b := range st.block {
+    switch {
+    case b.keyword() == "match" && len(b.words) > 1 && b.words[1].is("if"):
+      test, approx, err := translateExpr(b.words[2:], c.opts.code)
+      if err != nil {
+        c.translation(b, cl, err)
+        continue
+      }
+      cl.Test = test
+      for _, a := range approx {
+        c.report(b, Approximate, "%s", a)
+      }
+    case b.keyword() == "match":
+      expr, approx, err := translateData(b.words[1:], c.opts.code)
+      if err != nil {
+        c.translation(b, cl, err)
+        continue
+      }
+      cl.match = expr
+      for _, a := range approx {
+        c.report(b, Approximate, "%s", a)
+      }
+    case b.keyword() == "spawn" && len(b.words) > 2 && b.words[1].is("with"):
+      expr, _, err := translateData(b.words[2:], c.opts.code)
+      if err != nil {
+        c.translation(b, cl, err)
+        continue
+      }
+      cl.TemplateTest = expr
+      c.report(b, Review, "spawned classes are named SPAWN_%s_<value> in Kea; template classes need Kea 2.5 or later", name)
+    case b.keyword() == "lease" && b.arg(0) == "limit":
+      c.report(b, Untranslated, "lease limits per class need the limits hook")
+    case b.braces:
+      c.report(b, Untranslated, "a declaration inside a class")
+    default:
+      c.param(b, s, s.params)
+    }
+  }
+  cl.Params.inherit(sc.group)
+}
+
+func (c *converter) subclass(st *statement, sc *scope) {
+  parent, ok := c.classes[stringArg(st, 0)]
+  if !ok || len(st.words) < 3 {
+    c.report(st, Untranslated, "subclass of undeclared class %s", stringArg(st, 0))
+    return
+  }
+  if parent.match == "" {
+    c.report(st, Untranslated, "class %s has no translated match expression for its subclasses", parent.Name)
+    return
+  }
+  value, ok := bytesOf(st.words[2])
+  if !ok {
+    value = []byte(st.words[2].text)
+  }
+  test := parent.match + " == " + literal(value)
+  parent.subclass = append(parent.subclass, test)
+  if !st.braces || len(st.block) == 0 {
+    return
+  }
+
+  // A subclass with settings of its own becomes a class of its own.
+  label := string(value)
+  if literal(value)[0] != '\'' {
+    label = strings.ReplaceAll(dhcp.HexString(value), ":", "")
+  }
+  cl := &Class{Name: parent.Name + "-" + label, Test: test}
+  c.cfg.ClientClasses = append(c.cfg.ClientClasses, cl)
+  s := sc.child("class")
+  s.params = &cl.Params
+  for _, b := range st.block {
+    c.param(b, s, s.params)
+  }
+}
+
+// finishClasses writes the tests of classes matched by subclasses.
+func (c *converter) finishClasses() {
+  for _, cl := range c.cfg.ClientClasses {
+    if len(cl.subclass) == 0 {
+      continue
+    }
+    test := strings.Join(cl.subclass, " or ")
+    if cl.Test != "" {
+      test = "(" + cl.Test + ") or " + test
+    }
+    cl.Test = test
+    if len(cl.subclass) > 100 {
+      c.note(0, Review, "", "class %s matches %d subclass values in one test; host reservations with client-classes scale better", cl.Name, len(cl.subclass))
+    }
+  }
+}
+
+func (c *converter) failoverPeer(st *statement, sc *scope) {
+  if len(st.words) < 3 || !st.words[1].is("peer") {
+    c.report(st, Untranslated, "malformed failover declaration")
+    return
+  }
+  f := &failoverPeer{line: st.line, name: stringArg(st, 1), split: 128}
+  for _, b := range st.block {
+    words := strings.ToLower(strings.Join(wordsText(b.words), " "))
+    switch {
+    case words == "primary":
+      f.primary = true
+    case words == "secondary":
+    case b.keyword() == "address":
+      f.address = b.arg(0)
+    case strings.HasPrefix(words, "peer address "):
+      f.peerAddress = b.arg(1)
+    case b.keyword() == "split":
+      if n, err := strconv.Atoi(b.arg(0)); err == nil {
+        f.split = n
+      }
+    case b.keyword() == "hba":
+      c.report(b, Approximate, "the hash bucket assignment becomes an even load balancing split")
+    case b.keyword() == "max-response-delay":
+      if n, err := strconv.Atoi(b.arg(0)); err == nil {
+        f.maxResponseDelay = n
+      }
+    case b.keyword() == "mclt", b.keyword() == "port", strings.HasPrefix(words, "peer port"),
+      b.keyword() == "max-unacked-updates", b.keyword() == "load", b.keyword() == "auto-partner-down",
+      b.keyword() == "max-lease-misbalance", b.keyword() == "max-lease-ownership", b.keyword() == "min-balance",
+      b.keyword() == "max-balance":
+    default:
+      c.report(b, Untranslated, "unknown failover setting")
+    }
+  }
+  if f.address == "" || f.peerAddress == "" {
+    c.report(st, Untranslated, "failover peer %s needs address and peer address", f.name)
+    return
+  }
+  c.failover = append(c.failover, f)
+  c.report(st, Approximate, "failover peer %s becomes a High Availability relationship; HA has no MCLT or lease balancing, and the peers talk through the Control Agent", f.name)
+}
+
+// finishFailover configures the HA hook for the failover peers.
+func (c *converter) finishFailover() {
+  if len(c.failover) == 0 {
+    return
+  }
+  var relationships []any
+  for _, f := range c.failover {
+    mode, other := "load-balancing", "secondary"
+    if f.split == 0 || f.split >= 255 {
+      mode, other = "hot-standby", "standby"
+    }
+    primary, secondary := f.address, f.peerAddress
+    if !f.primary {
+      primary, secondary = secondary, primary
+    }
+    this := f.name + "-primary"
+    if !f.primary {
+      this = f.name + "-" + other
+    }
+    rel := map[string]any{
+      "this-server-name":    this,
+      "mode":                mode,
+      "heartbeat-delay":     10000,
+      "max-ack-delay":       5000,
+      "max-unacked-clients": 5,
+      "peers": []any{
+        map[string]any{"name": f.name + "-primary", "url": "http://" + primary + ":8000/", "role": "primary", "auto-failover": true},
+        map[string]any{"name": f.name + "-" + other, "url": "http://" + secondary + ":8000/", "role": other, "auto-failover": true},
+      },
+    }
+    if f.maxResponseDelay > 0 {
+      rel["max-response-delay"] = f.maxResponseDelay * 1000
+    }
+    relationships = append(relationships, rel)
+  }
+  c.cfg.HooksLibraries = append(c.cfg.HooksLibraries,
+    Hook{Library: "libdhcp_lease_cmds.so"},
+    Hook{Library: "libdhcp_ha.so", Parameters: map[string]any{"high-availability": relationships}},
+  )
+  c.note(c.failover[0].line, Review, "", "check the hook library paths and the Control Agent URLs (port 8000 is assumed) of the HA peers")
+}
@@ -0,0 +1,382 @@
+package migrate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Relay agent sub-options by their ISC names (option agent.NAME).
+var agentSubOptions = map[string]int{
+  "circuit-id":          1,
+  "remote-id":           2,
+  "docsis-device-class": 4,
+  "link-selection":      5,
+  "subscriber-id":       6,
+}
+
+// exprError is an ISC expression that has no Kea equivalent.
+type exprError struct {
+  what string
+}
+
+func (e *exprError) Error() string { return e.what + " has no Kea equivalent" }
+
+// exprTranslator turns ISC dhcp-eval expressions into Kea classification
+// expressions.
+type exprTranslator struct {
+  toks []token
+  pos  int
+  // option resolves an ISC option name to its Kea code.
+  option func(name string) (int, bool)
+  // approx collects the places where the translation differs slightly.
+  approx []string
+}
+
+// translateExpr translates a boolean ISC expression.
+func translateExpr(toks []token, option func(string) (int, bool)) (string, []string, error) {
+  t := &exprTranslator{toks: toks, option: option}
+  s, err := t.boolean()
+  if err == nil && t.pos < len(t.toks) {
+    err = fmt.Errorf("unexpected %s", t.toks[t.pos])
+  }
+  return s, t.approx, err
+}
+
+// translateData translates a data ISC expression, such as the one of a
+// "match" or "spawn with" statement.
+func translateData(toks []token, option func(string) (int, bool)) (string, []string, error) {
+  t := &exprTranslator{toks: toks, option: option}
+  s, err := t.data()
+  if err == nil && t.pos < len(t.toks) {
+    err = fmt.Errorf("unexpected %s", t.toks[t.pos])
+  }
+  return s, t.approx, err
+}
+
+func (t *exprTranslator) peek() (token, bool) {
+  if t.pos >= len(t.toks) {
+    return token{}, false
+  }
+  return t.toks[t.pos], true
+}
+
+func (t *exprTranslator) next() (token, error) {
+  if t.pos >= len(t.toks) {
+    return token{}, fmt.Errorf("expression ends early")
+  }
+  t.pos++
+  return t.toks[t.pos-1], nil
+}
+
+func (t *exprTranslator) expect(s string) error {
+  tok, err := t.next()
+  if err != nil {
+    return err
+  }
+  if !tok.is(s) {
+    return fmt.Errorf("expected %s, got %s", s, tok)
+  }
+  return nil
+}
+
+func (t *exprTranslator) boolean() (string, error) {
+  left, err := t.and()
+  if err != nil {
+    return "", err
+  }
+  for {
+    tok, ok := t.peek()
+    if !ok || !tok.is("or") {
+      return left, nil
+    }
+    t.pos++
+    right, err := t.and()
+    if err != nil {
+      return "", err
+    }
+    left = left + " or " + right
+  }
+}
+
+func (t *exprTranslator) and() (string, error) {
+  left, err := t.not()
+  if err != nil {
+    return "", err
+  }
+  for {
+    tok, ok := t.peek()
+    if !ok || !tok.is("and") {
+      return left, nil
+    }
+    t.pos++
+    right, err := t.not()
+    if err != nil {
+      return "", err
+    }
+    left = left + " and " + right
+  }
+}
+
+func (t *exprTranslator) not() (string, error) {
+  if tok, ok := t.peek(); ok && tok.is("not") {
+    t.pos++
+    e, err := t.not()
+    if err != nil {
+      return "", err
+    }
+    return "not " + e, nil
+  }
+  return t.compare()
+}
+
+func (t *exprTranslator) compare() (string, error) {
+  tok, ok := t.peek()
+  if !ok {
+    return "", fmt.Errorf("expression ends early")
+  }
+  switch {
+  case tok.is("("):
+    // Either a parenthesized boolean or a data expression compared
+    // below; try the boolean first.
+    save, approx := t.pos, len(t.approx)
+    t.pos++
+    if e, err := t.boolean(); err == nil {
+      if next, ok := t.peek(); ok && next.is(")") {
+        t.pos++
+        if after, ok := t.peek(); !ok || !isCompare(after) {
+          return "(" + e + ")", nil
+        }
+      }
+    }
+    t.pos, t.approx = save, t.approx[:approx]
+  case tok.is("exists"):
+    t.pos++
+    name, err := t.next()
+    if err != nil {
+      return "", err
+    }
+    ref, err := t.optionRef(name.text)
+    if err != nil {
+      return "", err
+    }
+    return ref + ".exists", nil
+  case tok.is("known"):
+    t.pos++
+    return "member('KNOWN')", nil
+  case tok.is("static"):
+    return "", &exprError{"static"}
+  }
+
+  left, err := t.data()
+  if err != nil {
+    return "", err
+  }
+  op, ok := t.peek()
+  if !ok || !isCompare(op) {
+    return "", fmt.Errorf("expected a comparison after %s", left)
+  }
+  t.pos++
+  switch op.text {
+  case "~=", "~~":
+    return "", &exprError{"regular expression matching (" + op.text + ")"}
+  }
+  right, err := t.data()
+  if err != nil {
+    return "", err
+  }
+  if op.text == "!=" {
+    return "not (" + left + " == " + right + ")", nil
+  }
+  return left + " == " + right, nil
+}
+
+func isCompare(tok token) bool {
+  return tok.kind == tokPunct && (tok.text == "=" || tok.text == "!=" || tok.text == "~=" || tok.text == "~~")
+}
+
+// optionRef returns the Kea reference to an ISC option name.
+func (t *exprTranslator) optionRef(name string) (string, error) {
+  lower := strings.ToLower(name)
+  if sub, ok := strings.CutPrefix(lower, "agent."); ok {
+    code, ok := agentSubOptions[sub]
+    if !ok {
+      return "", &exprError{"relay agent sub-option " + name}
+    }
+    return fmt.Sprintf("relay4[%d]", code), nil
+  }
+  if strings.Contains(lower, ".") {
+    return "", &exprError{"option " + name + " of another option space"}
+  }
+  code, ok := t.option(lower)
+  if !ok {
+    return "", fmt.Errorf("unknown option %s", name)
+  }
+  return fmt.Sprintf("option[%d]", code), nil
+}
+
+// args reads a parenthesized, comma separated argument list whose
+// elements are read by the given functions in turn.
+func (t *exprTranslator) args(parts ...func() (string, error)) ([]string, error) {
+  if err := t.expect("("); err != nil {
+    return nil, err
+  }
+  out := make([]string, 0, len(parts))
+  for i, part := range parts {
+    if i > 0 {
+      if err := t.expect(","); err != nil {
+        return nil, err
+      }
+    }
+    s, err := part()
+    if err != nil {
+      return nil, err
+    }
+    out = append(out, s)
+  }
+  if err := t.expect(")"); err != nil {
+    return nil, err
+  }
+  return out, nil
+}
+
+// number reads an integer argument.
+func (t *exprTranslator) number() (string, error) {
+  tok, err := t.next()
+  if err != nil {
+    return "", err
+  }
+  if _, err := strconv.ParseInt(tok.text, 10, 64); err != nil || tok.kind != tokWord {
+    return "", fmt.Errorf("expected a number, got %s", tok)
+  }
+  return tok.text, nil
+}
+
+func (t *exprTranslator) data() (string, error) {
+  tok, err := t.next()
+  if err != nil {
+    return "", err
+  }
+  if tok.kind == tokString {
+    return literal(unquote(tok.text)), nil
+  }
+  if tok.kind == tokPunct {
+    if tok.text == "(" {
+      e, err := t.data()
+      if err != nil {
+        return "", err
+      }
+      return e, t.expect(")")
+    }
+    return "", fmt.Errorf("unexpected %s", tok)
+  }
+
+  switch strings.ToLower(tok.text) {
+  case "option":
+    name, err := t.next()
+    if err != nil {
+      return "", err
+    }
+    ref, err := t.optionRef(name.text)
+    if err != nil {
+      return "", err
+    }
+    return ref + ".hex", nil
+  case "hardware":
+    return "concat(substring(pkt4.htype,-1,all),pkt4.mac)", nil
+  case "substring":
+    a, err := t.args(t.data, t.number, t.number)
+    if err != nil {
+      return "", err
+    }
+    return "substring(" + strings.Join(a, ",") + ")", nil
+  case "suffix":
+    a, err := t.args(t.data, t.number)
+    if err != nil {
+      return "", err
+    }
+    return "substring(" + a[0] + ",-" + a[1] + ",all)", nil
+  case "concat":
+    if err := t.expect("("); err != nil {
+      return "", err
+    }
+    // ISC takes any number of arguments, Kea two.
+    var out string
+    for i := 0; ; i++ {
+      e, err := t.data()
+      if err != nil {
+        return "", err
+      }
+      if i == 0 {
+        out = e
+      } else {
+        out = "concat(" + out + "," + e + ")"
+      }
+      sep, err := t.next()
+      if err != nil {
+        return "", err
+      }
+      if sep.is(")") {
+        return out, nil
+      }
+      if !sep.is(",") {
+        return "", fmt.Errorf("expected , or ), got %s", sep)
+      }
+    }
+  case "lcase", "ucase":
+    a, err := t.args(t.data)
+    if err != nil {
+      return "", err
+    }
+    return strings.ToLower(tok.text) + "(" + a[0] + ")", nil
+  case "binary-to-ascii":
+    a, err := t.args(t.number, t.number, t.data, t.data)
+    if err != nil {
+      return "", err
+    }
+    if a[0] != "16" || a[1] != "8" {
+      return "", &exprError{"binary-to-ascii in base " + a[0]}
+    }
+    t.approx = append(t.approx, "binary-to-ascii(16, 8, ...) became hexstring, which keeps leading zeros that ISC drops")
+    return "hexstring(" + a[3] + "," + a[2] + ")", nil
+  case "encode-int":
+    a, err := t.args(t.number, t.number)
+    if err != nil {
+      return "", err
+    }
+    v, _ := strconv.ParseInt(a[0], 10, 64)
+    width, _ := strconv.Atoi(a[1])
+    if width != 8 && width != 16 && width != 32 {
+      return "", fmt.Errorf("encode-int width %d", width)
+    }
+    b := make([]byte, width/8)
+    for i := range b {
+      b[len(b)-1-i] = byte(v >> (8 * i))
+    }
+    return "0x" + strings.ToUpper(hex.EncodeToString(b)), nil
+  case "packet", "leased-address", "config-option", "pick-first-value", "reverse",
+    "extract-int", "host-decl-name", "client-state", "gethostname", "filename",
+    "server-name", "v6relay", "null", "static":
+    return "", &exprError{tok.text}
+  }
+
+  if b, ok := parseHex(tok.text); ok {
+    return "0x" + strings.ToUpper(hex.EncodeToString(b)), nil
+  }
+  if _, err := strconv.ParseUint(tok.text, 10, 32); err == nil {
+    return tok.text, nil
+  }
+  return "", fmt.Errorf("unknown expression %s", tok)
+}
+
+// literal writes data as a Kea string literal, or as hex when it isn't
+// plain text.
+func literal(b []byte) string {
+  for _, c := range b {
+    if c < 0x20 || c > 0x7e || c == '\'' || c == '\\' {
+      return "0x" + strings.ToUpper(hex.EncodeToString(b))
+    }
+  }
+  return "'" + string(b) + "'"
+}
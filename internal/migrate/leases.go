@@ -0,0 +1,265 @@
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+)
+
+// Lease states in Kea's lease files and tables.
+const (
+  leaseStateDefault  = 0
+  leaseStateDeclined = 1
+)
+
+// infiniteLifetime is the valid lifetime Kea stores for leases that never
+// expire.
+const infiniteLifetime = math.MaxUint32
+
+// maxTimestamp is the latest expire MySQL's TIMESTAMP column can hold.
+const maxTimestamp = math.MaxInt32
+
+// Lease is an IPv4 lease read from dhcpd.leases.
+type Lease struct {
+  Address  netip.Addr
+  HWAddr   string
+  ClientID string
+  Hostname string
+  // CLTT is the client's last transaction time.
+  CLTT time.Time
+  // Ends is the zero time for leases that never expire.
+  Ends     time.Time
+  State    int
+  SubnetID int64
+}
+
+// ValidLifetime returns the lease time Kea stores.
+func (l Lease) ValidLifetime() int64 {
+  if l.Ends.IsZero() {
+    return infiniteLifetime
+  }
+  return max(int64(l.Ends.Sub(l.CLTT)/time.Second), 0)
+}
+
+// Expire returns the expiry Kea stores.
+func (l Lease) Expire() int64 {
+  return l.CLTT.Unix() + l.ValidLifetime()
+}
+
+// LeaseImport is the outcome of reading a lease file.
+type LeaseImport struct {
+  Leases []Lease
+  // Skipped counts the leases left out, by binding state or DHCPv6.
+  Skipped  map[string]int
+  Findings []Finding
+}
+
+// ImportLeases reads the IPv4 leases of a dhcpd.leases file. The file
+// holds one entry per change, so the last entry of an address wins.
+// Leases are given the IDs of the subnets of cfg; those outside every
+// subnet and those not in use are skipped.
+func ImportLeases(src string, cfg *Config) (*LeaseImport, error) {
+  stmts, err := parse(src)
+  if err != nil {
+    return nil, err
+  }
+  res := &LeaseImport{Skipped: map[string]int{}}
+  latest := map[netip.Addr]*statement{}
+  for _, st := range stmts {
+    switch st.keyword() {
+    case "lease":
+      addr, err := netip.ParseAddr(st.arg(0))
+      if err != nil || !addr.Is4() || !st.braces {
+        res.Findings = append(res.Findings, Finding{Line: st.line, Severity: Untranslated, Statement: st.text(), Message: "malformed lease"})
+        continue
+      }
+      latest[addr] = st
+    case "ia-na", "ia-ta", "ia-pd":
+      res.Skipped["DHCPv6"]++
+    }
+  }
+
+  for addr, st := range latest {
+    l, reason, err := readLease(addr, st)
+    if err != nil {
+      res.Findings = append(res.Findings, Finding{Line: st.line, Severity: Untranslated, Statement: st.text(), Message: err.Error()})
+      continue
+    }
+    if reason != "" {
+      res.Skipped[reason]++
+      continue
+    }
+    sub := cfg.SubnetFor(addr)
+    if sub == nil {
+      res.Findings = append(res.Findings, Finding{Line: st.line, Severity: Untranslated, Statement: st.text(), Message: "no subnet of the configuration holds " + addr.String()})
+      continue
+    }
+    l.SubnetID = sub.ID
+    res.Leases = append(res.Leases, l)
+  }
+  sort.Slice(res.Leases, func(i, j int) bool { return res.Leases[i].Address.Less(res.Leases[j].Address) })
+  sort.Slice(res.Findings, func(i, j int) bool { return res.Findings[i].Line < res.Findings[j].Line })
+  return res, nil
+}
+
+// readLease reads one lease block. reason is set for leases that aren't
+// in use and so aren't imported.
+func readLease(addr netip.Addr, st *statement) (Lease, string, error) {
+  l := Lease{Address: addr, State: leaseStateDefault}
+  var starts time.Time
+  state := ""
+  for _, b := range st.block {
+    switch b.keyword() {
+    case "starts", "ends", "cltt":
+      t, err := leaseTime(b)
+      if err != nil {
+        return l, "", err
+      }
+      switch b.keyword() {
+      case "starts":
+        starts = t
+      case "ends":
+        l.Ends = t
+      case "cltt":
+        l.CLTT = t
+      }
+    case "binding":
+      state = strings.ToLower(b.arg(1))
+    case "hardware":
+      if hw, ok := parseHex(b.arg(1)); ok {
+        l.HWAddr = dhcp.HexString(hw)
+      }
+    case "uid":
+      if id, ok := bytesOf(b.words[len(b.words)-1]); ok {
+        l.ClientID = dhcp.HexString(id)
+      }
+    case "client-hostname":
+      l.Hostname = stringArg(b, 0)
+    }
+  }
+  if l.CLTT.IsZero() {
+    l.CLTT = starts
+  }
+
+  switch state {
+  case "active", "":
+  case "abandoned":
+    // Kea keeps declined addresses without a client.
+    l.State, l.HWAddr, l.ClientID, l.Hostname = leaseStateDeclined, "", "", ""
+  default:
+    return l, state, nil
+  }
+  if l.CLTT.IsZero() {
+    return l, "", fmt.Errorf("lease %s has no start time", addr)
+  }
+  return l, "", nil
+}
+
+// leaseTime reads "starts 3 2024/01/10 10:00:00", "ends never" and
+// "starts epoch 1704880800". Times are UTC unless db-time-format local
+// wrote them as epochs.
+func leaseTime(st *statement) (time.Time, error) {
+  switch strings.ToLower(st.arg(0)) {
+  case "never":
+    return time.Time{}, nil
+  case "epoch":
+    n, err := strconv.ParseInt(st.arg(1), 10, 64)
+    if err != nil {
+      return time.Time{}, fmt.Errorf("line %d: malformed %s time", st.line, st.keyword())
+    }
+    return time.Unix(n, 0).UTC(), nil
+  }
+  t, err := time.Parse("2006/01/02 15:04:05", st.arg(1)+" "+st.arg(2))
+  if err != nil {
+    return time.Time{}, fmt.Errorf("line %d: malformed %s time", st.line, st.keyword())
+  }
+  return t, nil
+}
+
+// WriteLeases writes leases in the format of a lease backend: a memfile
+// CSV file, or a SQL script for the mysql and postgresql schemas.
+func WriteLeases(w io.Writer, backend string, leases []Lease) error {
+  switch backend {
+  case BackendMySQL:
+    return writeSQL(w, leases, mysqlLease, "SET time_zone = '+00:00';\nSTART TRANSACTION;\n")
+  case BackendPostgreSQL:
+    return writeSQL(w, leases, postgresLease, "BEGIN;\n")
+  }
+  return writeMemfile(w, leases)
+}
+
+// memfileHeader is the lease4 CSV header of Kea 2.x.
+const memfileHeader = "address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context"
+
+func writeMemfile(w io.Writer, leases []Lease) error {
+  bw := bufio.NewWriter(w)
+  fmt.Fprintln(bw, memfileHeader)
+  for _, l := range leases {
+    // Kea escapes commas in text columns.
+    hostname := strings.ReplaceAll(l.Hostname, ",", "&#x2c")
+    fmt.Fprintf(bw, "%s,%s,%s,%d,%d,%d,0,0,%s,%d,\n", l.Address, l.HWAddr, l.ClientID, l.ValidLifetime(), l.Expire(), l.SubnetID, hostname, l.State)
+  }
+  return bw.Flush()
+}
+
+func writeSQL(w io.Writer, leases []Lease, row func(Lease) string, begin string) error {
+  bw := bufio.NewWriter(w)
+  bw.WriteString(begin)
+  for _, l := range leases {
+    bw.WriteString(row(l))
+  }
+  bw.WriteString("COMMIT;\n")
+  return bw.Flush()
+}
+
+func addrInt(a netip.Addr) uint32 {
+  b := a.As4()
+  return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func hexBytes(s string) string {
+  return strings.ReplaceAll(s, ":", "")
+}
+
+// sqlString quotes s as a MySQL string literal.
+func sqlString(s string) string {
+  if s == "" {
+    return "NULL"
+  }
+  return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", "''") + "'"
+}
+
+func mysqlLease(l Lease) string {
+  bin := func(s string) string {
+    if s == "" {
+      return "NULL"
+    }
+    return "UNHEX('" + hexBytes(s) + "')"
+  }
+  return fmt.Sprintf("INSERT IGNORE INTO lease4 (address, hwaddr, client_id, valid_lifetime, expire, subnet_id, fqdn_fwd, fqdn_rev, hostname, state) VALUES (%d, %s, %s, %d, FROM_UNIXTIME(%d), %d, 0, 0, %s, %d);\n",
+    addrInt(l.Address), bin(l.HWAddr), bin(l.ClientID), l.ValidLifetime(), min(l.Expire(), maxTimestamp), l.SubnetID, sqlString(l.Hostname), l.State)
+}
+
+func postgresLease(l Lease) string {
+  bin := func(s string) string {
+    if s == "" {
+      return "NULL"
+    }
+    return "decode('" + hexBytes(s) + "', 'hex')"
+  }
+  // PostgreSQL strings don't treat backslashes as escapes.
+  hostname := "NULL"
+  if l.Hostname != "" {
+    hostname = "'" + strings.ReplaceAll(l.Hostname, "'", "''") + "'"
+  }
+  return fmt.Sprintf("INSERT INTO lease4 (address, hwaddr, client_id, valid_lifetime, expire, subnet_id, fqdn_fwd, fqdn_rev, hostname, state) VALUES (%d, %s, %s, %d, to_timestamp(%d), %d, false, false, %s, %d) ON CONFLICT (address) DO NOTHING;\n",
+    addrInt(l.Address), bin(l.HWAddr), bin(l.ClientID), l.ValidLifetime(), min(l.Expire(), maxTimestamp), l.SubnetID, hostname, l.State)
+}
@@ -0,0 +1,333 @@
+package migrate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// iscOptionNames maps the ISC names of standard options that Kea names
+// differently.
+var iscOptionNames = map[string]string{
+  "ien116-name-servers": "name-servers",
+  "nisplus-domain":      "nisplus-domain-name",
+  "nwip-domain":         "nwip-domain-name",
+  "bootfile-name":       "boot-file-name",
+  "pxe-system-type":     "client-system",
+  "pxe-interface-id":    "client-ndi",
+  "pxe-client-id":       "uuid-guid",
+  "vivco":               "vivco-suboptions",
+  "vivso":               "vivso-suboptions",
+  "default-url":         "v4-captive-portal",
+}
+
+// classlessRouteCode is the classless static routes option (RFC 3442),
+// which dhcpd only knows through a custom definition.
+const classlessRouteCode = 121
+
+// iscTypes maps ISC option types to Kea's.
+var iscTypes = map[string]string{
+  "boolean":          "boolean",
+  "ip-address":       "ipv4-address",
+  "ip6-address":      "ipv6-address",
+  "text":             "string",
+  "string":           "binary",
+  "domain-name":      "fqdn",
+  "domain-list":      "fqdn",
+  "unsigned integer": "uint",
+  "integer":          "int",
+  "signed integer":   "int",
+}
+
+// optionDef is a known option: standard or defined in the file.
+type optionDef struct {
+  name  string
+  code  int
+  space string
+  typ   string
+  array bool
+  // records holds the field types of a record option.
+  records []string
+}
+
+// options resolves the option names of one file.
+type options struct {
+  // defs are keyed by space/name.
+  defs map[string]optionDef
+}
+
+func newOptions() *options {
+  o := &options{defs: map[string]optionDef{}}
+  for _, d := range kea.StdOptions4 {
+    o.defs["dhcp4/"+d.Name] = optionDef{name: d.Name, code: d.Code, space: "dhcp4", typ: d.Type, array: d.Array}
+  }
+  return o
+}
+
+// lookup finds an option written as "name" or "space.name".
+func (o *options) lookup(name string) (optionDef, bool) {
+  name = strings.ToLower(name)
+  space, short, ok := strings.Cut(name, ".")
+  if !ok {
+    space, short = "dhcp4", name
+  }
+  if space == "dhcp4" {
+    if kn, ok := iscOptionNames[short]; ok {
+      short = kn
+    }
+  }
+  d, ok := o.defs[space+"/"+short]
+  return d, ok
+}
+
+// code resolves the name of an option of the default space, for
+// expressions.
+func (o *options) code(name string) (int, bool) {
+  d, ok := o.lookup(name)
+  if !ok || d.space != "dhcp4" {
+    return 0, false
+  }
+  return d.code, true
+}
+
+// parseType reads an ISC option type, the words after "=".
+func parseType(words []token) (optionDef, error) {
+  var d optionDef
+  var parts []string
+  for _, w := range words {
+    parts = append(parts, strings.ToLower(w.text))
+  }
+  s := strings.Join(parts, " ")
+  if rest, ok := strings.CutPrefix(s, "array of "); ok {
+    d.array, s = true, rest
+  }
+  if inner, ok := strings.CutPrefix(s, "{ "); ok {
+    inner = strings.TrimSuffix(inner, " }")
+    for _, f := range strings.Split(inner, " , ") {
+      t, err := scalarType(f)
+      if err != nil {
+        return d, err
+      }
+      d.records = append(d.records, t)
+    }
+    d.typ = "record"
+    return d, nil
+  }
+  if rest, ok := strings.CutPrefix(s, "encapsulate "); ok {
+    d.typ, d.records = "empty", []string{rest}
+    return d, nil
+  }
+  if s == "domain-list" || s == "domain-list compressed" {
+    d.typ, d.array = "fqdn", true
+    return d, nil
+  }
+  t, err := scalarType(s)
+  d.typ = t
+  return d, err
+}
+
+func scalarType(s string) (string, error) {
+  s = strings.TrimSpace(s)
+  for _, prefix := range []string{"unsigned integer", "signed integer", "integer"} {
+    if width, ok := strings.CutPrefix(s, prefix+" "); ok {
+      switch width {
+      case "8", "16", "32":
+        return iscTypes[prefix] + width, nil
+      }
+      return "", fmt.Errorf("unsupported integer width %s", width)
+    }
+  }
+  if t, ok := iscTypes[s]; ok {
+    return t, nil
+  }
+  return "", fmt.Errorf("unsupported option type %q", s)
+}
+
+// define handles "option [space.]name code N = type". It returns the
+// option-def entry to write, or nil when the definition duplicates a
+// standard option.
+func (o *options) define(st *statement) (*kea.ConfigOptionDef, string, error) {
+  if len(st.words) < 6 || !st.words[2].is("code") || !st.words[4].is("=") {
+    return nil, "", fmt.Errorf("malformed option definition")
+  }
+  name := strings.ToLower(st.arg(0))
+  space, short, ok := strings.Cut(name, ".")
+  if !ok {
+    space, short = "dhcp4", name
+  }
+  code, err := strconv.Atoi(st.arg(2))
+  if err != nil {
+    return nil, "", fmt.Errorf("option code %q", st.arg(2))
+  }
+  d, err := parseType(st.words[5:])
+  if err != nil {
+    return nil, "", err
+  }
+  d.name, d.code, d.space = short, code, space
+
+  if space == "dhcp4" {
+    if std, ok := kea.StdOption(4, code); ok {
+      // A local name for a standard option, like the usual
+      // rfc3442-classless-static-routes: use Kea's definition.
+      def := o.defs["dhcp4/"+std.Name]
+      o.defs["dhcp4/"+short] = def
+      note := ""
+      if short != std.Name {
+        note = fmt.Sprintf("option %d is standard in Kea as %s", code, std.Name)
+      }
+      return nil, note, nil
+    }
+  }
+  o.defs[space+"/"+short] = d
+
+  def := &kea.ConfigOptionDef{Name: short, Code: code, Type: d.typ, Space: space, Array: d.array}
+  switch d.typ {
+  case "record":
+    def.RecordTypes = strings.Join(d.records, ", ")
+  case "empty":
+    def.Encapsulate = d.records[0]
+  }
+  return def, "", nil
+}
+
+// optionValue converts the value of an "option name value" statement to
+// option-data. approx explains a conversion that isn't exact.
+func (o *options) optionValue(st *statement) (data kea.OptionData, approx string, err error) {
+  if len(st.words) < 2 {
+    return data, "", fmt.Errorf("malformed option statement")
+  }
+  d, ok := o.lookup(st.arg(0))
+  if !ok {
+    return data, "", fmt.Errorf("option %s is not defined", st.arg(0))
+  }
+  data.Name = d.name
+  if d.space != "dhcp4" {
+    data.Code, data.Space = d.code, d.space
+  }
+
+  // Values are separated by commas; a value may span several words
+  // only for records, which are separated by spaces.
+  var values []token
+  for _, t := range st.words[2:] {
+    if !t.is(",") {
+      values = append(values, t)
+    }
+  }
+  if len(values) == 0 {
+    if d.typ == "empty" {
+      return data, "", nil
+    }
+    return data, "", fmt.Errorf("option %s has no value", st.arg(0))
+  }
+
+  if d.space == "dhcp4" && d.code == classlessRouteCode {
+    v, err := classlessRoutes(values)
+    data.Data = v
+    return data, "", err
+  }
+
+  switch d.typ {
+  case "binary":
+    var b []byte
+    for _, v := range values {
+      part, ok := bytesOf(v)
+      if !ok {
+        return data, "", fmt.Errorf("%s is not a string or hex", v)
+      }
+      b = append(b, part...)
+    }
+    data.Data = strings.ToUpper(hex.EncodeToString(b))
+    data.CSVFormat = new(bool)
+    return data, "", nil
+  case "string", "fqdn":
+    if d.typ == "string" && len(values) == 1 && values[0].kind == tokWord {
+      // Hex for a text option: send it as is.
+      if b, ok := parseHex(values[0].text); ok {
+        data.Data = strings.ToUpper(hex.EncodeToString(b))
+        data.CSVFormat = new(bool)
+        return data, "", nil
+      }
+    }
+    var parts []string
+    for _, v := range values {
+      s := v.text
+      if v.kind == tokString {
+        s = string(unquote(v.text))
+      }
+      parts = append(parts, strings.ReplaceAll(s, ",", `\,`))
+    }
+    data.Data = strings.Join(parts, ", ")
+    return data, "", nil
+  case "ipv4-address":
+    var parts []string
+    for _, v := range values {
+      if _, err := netip.ParseAddr(v.text); err != nil || v.kind == tokString {
+        approx = "dhcpd resolves host names in option values at startup; Kea needs addresses"
+      }
+      parts = append(parts, v.text)
+    }
+    data.Data = strings.Join(parts, ", ")
+    return data, approx, nil
+  case "boolean":
+    var parts []string
+    for _, v := range values {
+      switch strings.ToLower(v.text) {
+      case "true", "on":
+        parts = append(parts, "true")
+      case "false", "off", "ignore":
+        parts = append(parts, "false")
+      default:
+        return data, "", fmt.Errorf("%s is not a boolean", v)
+      }
+    }
+    data.Data = strings.Join(parts, ", ")
+    return data, "", nil
+  }
+
+  var parts []string
+  for _, v := range values {
+    s := v.text
+    if v.kind == tokString {
+      s = strings.ReplaceAll(string(unquote(v.text)), ",", `\,`)
+    }
+    parts = append(parts, s)
+  }
+  data.Data = strings.Join(parts, ", ")
+  return data, "", nil
+}
+
+// classlessRoutes converts the bytes dhcpd is given for option 121
+// (width, significant destination octets, router, ...) to Kea's
+// "prefix - router" list.
+func classlessRoutes(values []token) (string, error) {
+  var b []byte
+  for _, v := range values {
+    if hb, ok := parseHex(v.text); ok {
+      b = append(b, hb...)
+      continue
+    }
+    n, err := strconv.ParseUint(v.text, 10, 8)
+    if err != nil {
+      return "", fmt.Errorf("classless route byte %s", v)
+    }
+    b = append(b, byte(n))
+  }
+  var routes []string
+  for len(b) > 0 {
+    width := int(b[0])
+    octets := (width + 7) / 8
+    if width > 32 || len(b) < 1+octets+4 {
+      return "", fmt.Errorf("malformed classless static routes")
+    }
+    var dst [4]byte
+    copy(dst[:], b[1:1+octets])
+    router := netip.AddrFrom4([4]byte(b[1+octets : 5+octets]))
+    routes = append(routes, fmt.Sprintf("%s/%d - %s", netip.AddrFrom4(dst), width, router))
+    b = b[5+octets:]
+  }
+  return strings.Join(routes, ", "), nil
+}
@@ -0,0 +1,292 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Token kinds of the ISC DHCP configuration and lease file syntax.
+const (
+  tokWord = iota
+  tokString
+  tokPunct
+)
+
+// token is one word, quoted string or punctuation mark. A string keeps
+// its raw, still escaped text.
+type token struct {
+  kind int
+  text string
+  line int
+}
+
+func (t token) is(s string) bool {
+  return t.kind != tokString && strings.EqualFold(t.text, s)
+}
+
+func (t token) String() string {
+  if t.kind == tokString {
+    return `"` + t.text + `"`
+  }
+  return t.text
+}
+
+// wordChar reports whether c continues a word: names, numbers,
+// addresses, colon separated hex and dates all lex as words.
+func wordChar(c byte) bool {
+  switch {
+  case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+    return true
+  }
+  return strings.IndexByte("-_.:/+*@$%^&|", c) >= 0
+}
+
+// lex splits src into tokens, dropping comments.
+func lex(src string) ([]token, error) {
+  var toks []token
+  line := 1
+  for i := 0; i < len(src); {
+    c := src[i]
+    switch {
+    case c == '\n':
+      line++
+      i++
+    case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+      i++
+    case c == '#':
+      for i < len(src) && src[i] != '\n' {
+        i++
+      }
+    case c == '"':
+      start, startLine := i+1, line
+      i++
+      for i < len(src) && src[i] != '"' {
+        if src[i] == '\\' {
+          i++
+        }
+        if i < len(src) && src[i] == '\n' {
+          line++
+        }
+        i++
+      }
+      if i >= len(src) {
+        return nil, fmt.Errorf("line %d: unterminated string", startLine)
+      }
+      toks = append(toks, token{tokString, src[start:i], startLine})
+      i++
+    case c == '!' || c == '~':
+      if i+1 < len(src) && (src[i+1] == '=' || c == '~' && src[i+1] == '~') {
+        toks = append(toks, token{tokPunct, src[i : i+2], line})
+        i += 2
+        continue
+      }
+      toks = append(toks, token{tokPunct, src[i : i+1], line})
+      i++
+    case strings.IndexByte("{};,()=", c) >= 0:
+      toks = append(toks, token{tokPunct, src[i : i+1], line})
+      i++
+    case wordChar(c):
+      start := i
+      for i < len(src) && wordChar(src[i]) {
+        i++
+      }
+      toks = append(toks, token{tokWord, src[start:i], line})
+    default:
+      return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+    }
+  }
+  return toks, nil
+}
+
+// statement is a declaration or parameter, with the statements of its
+// block for declarations written with braces.
+type statement struct {
+  line   int
+  words  []token
+  block  []*statement
+  braces bool
+}
+
+// keyword returns the first word in lower case.
+func (s *statement) keyword() string {
+  if len(s.words) == 0 {
+    return ""
+  }
+  return strings.ToLower(s.words[0].text)
+}
+
+// arg returns the i-th word after the keyword, or "".
+func (s *statement) arg(i int) string {
+  if i+1 >= len(s.words) {
+    return ""
+  }
+  return s.words[i+1].text
+}
+
+// text renders the statement the way it was written, without its block.
+func (s *statement) text() string {
+  var b strings.Builder
+  for i, t := range s.words {
+    if i > 0 && t.text != "," && t.text != ")" && s.words[i-1].text != "(" {
+      b.WriteByte(' ')
+    }
+    b.WriteString(t.String())
+  }
+  if s.braces {
+    b.WriteString(" { ... }")
+  } else {
+    b.WriteByte(';')
+  }
+  return b.String()
+}
+
+// parse reads the statements of an ISC dhcpd.conf or dhcpd.leases file.
+func parse(src string) ([]*statement, error) {
+  toks, err := lex(src)
+  if err != nil {
+    return nil, err
+  }
+  p := &parser{toks: toks}
+  stmts, err := p.block(false)
+  if err != nil {
+    return nil, err
+  }
+  return stmts, nil
+}
+
+type parser struct {
+  toks []token
+  pos  int
+}
+
+func (p *parser) block(nested bool) ([]*statement, error) {
+  var out []*statement
+  var cur *statement
+  for p.pos < len(p.toks) {
+    t := p.toks[p.pos]
+    p.pos++
+    if t.kind == tokPunct {
+      switch t.text {
+      case ";":
+        if cur != nil {
+          out, cur = append(out, cur), nil
+        }
+        continue
+      case "{":
+        // Record types of option definitions: "= { ip-address, text }".
+        if cur != nil && len(cur.words) > 0 && (cur.words[len(cur.words)-1].is("=") || cur.words[len(cur.words)-1].is("of")) {
+          cur.words = append(cur.words, t)
+          for p.pos < len(p.toks) && !p.toks[p.pos].is("}") {
+            cur.words = append(cur.words, p.toks[p.pos])
+            p.pos++
+          }
+          if p.pos == len(p.toks) {
+            return nil, fmt.Errorf("line %d: missing }", t.line)
+          }
+          cur.words = append(cur.words, p.toks[p.pos])
+          p.pos++
+          continue
+        }
+        if cur == nil {
+          cur = &statement{line: t.line}
+        }
+        body, err := p.block(true)
+        if err != nil {
+          return nil, err
+        }
+        cur.block, cur.braces = body, true
+        out, cur = append(out, cur), nil
+        continue
+      case "}":
+        if !nested {
+          return nil, fmt.Errorf("line %d: unexpected }", t.line)
+        }
+        if cur != nil {
+          return nil, fmt.Errorf("line %d: missing ; after %s", cur.line, cur.text())
+        }
+        return out, nil
+      }
+    }
+    if cur == nil {
+      cur = &statement{line: t.line}
+    }
+    cur.words = append(cur.words, t)
+  }
+  if nested {
+    return nil, fmt.Errorf("unexpected end of file: missing }")
+  }
+  if cur != nil {
+    return nil, fmt.Errorf("line %d: missing ; after %s", cur.line, cur.text())
+  }
+  return out, nil
+}
+
+// unquote decodes the escapes of an ISC string: \n, \t, \r, \", \\,
+// octal \NNN and hex \xNN.
+func unquote(s string) []byte {
+  var out []byte
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    if c != '\\' || i+1 == len(s) {
+      out = append(out, c)
+      continue
+    }
+    i++
+    switch c = s[i]; {
+    case c == 'n':
+      out = append(out, '\n')
+    case c == 't':
+      out = append(out, '\t')
+    case c == 'r':
+      out = append(out, '\r')
+    case c == 'x' && i+2 < len(s):
+      if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+        out = append(out, byte(v))
+        i += 2
+        continue
+      }
+      out = append(out, c)
+    case c >= '0' && c <= '7':
+      j := i
+      for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+        j++
+      }
+      v, _ := strconv.ParseUint(s[i:j], 8, 16)
+      out = append(out, byte(v))
+      i = j - 1
+    default:
+      out = append(out, c)
+    }
+  }
+  return out
+}
+
+// parseHex reads colon separated hex as ISC writes it, where an octet
+// may be a single digit ("1:2:a").
+func parseHex(s string) ([]byte, bool) {
+  parts := strings.Split(s, ":")
+  if len(parts) < 2 {
+    return nil, false
+  }
+  out := make([]byte, 0, len(parts))
+  for _, p := range parts {
+    if len(p) == 0 || len(p) > 2 {
+      return nil, false
+    }
+    v, err := strconv.ParseUint(p, 16, 8)
+    if err != nil {
+      return nil, false
+    }
+    out = append(out, byte(v))
+  }
+  return out, true
+}
+
+// bytesOf returns the data of a string or colon separated hex token.
+func bytesOf(t token) ([]byte, bool) {
+  if t.kind == tokString {
+    return unquote(t.text), true
+  }
+  return parseHex(t.text)
+}
@@ -0,0 +1,174 @@
+package rogue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rannday/kea-web/internal/capture"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+)
+
+const (
+  // findingTTL drops findings that haven't recurred for this long.
+  findingTTL = 24 * time.Hour
+  // maxEvidence is the number of evidence lines kept per finding.
+  maxEvidence = 20
+)
+
+// Severities, as used by the capture analyzer.
+const (
+  SeverityError   = capture.SeverityError
+  SeverityWarning = capture.SeverityWarning
+)
+
+// Kinds of findings.
+const (
+  KindUnknownServer = "unknown-server"
+  KindNAKReceived   = "nak-received"
+  KindDeclineSpike  = "declined-spike"
+  KindDeclineStorm  = "decline-storm"
+)
+
+// ErrNoKnownServers is returned when the configured servers can't be
+// determined, so no server can be called unknown.
+var ErrNoKnownServers = errors.New("the identifiers of the Kea servers are unknown; set KEA_KNOWN_SERVERS or check the Control Agent")
+
+// Finding is a sign of a rogue or conflicting DHCP server. Repeated
+// observations of the same thing update one finding.
+type Finding struct {
+  Key      string
+  Severity string
+  Kind     string
+  Family   int
+  Subject  string
+  Message  string
+  First    time.Time
+  Last     time.Time
+  Count    int
+  // Evidence holds what was observed, oldest first.
+  Evidence []string
+}
+
+// Detector collects findings from uploaded captures and from Kea's
+// statistics.
+type Detector struct {
+  client *kea.Client
+  host   *linux.Host
+  extra  []string
+
+  mu       sync.Mutex
+  findings map[string]*Finding
+  known    *KnownServers
+  knownAt  time.Time
+  stats    map[string]statPoint
+}
+
+// NewDetector returns a detector. extra lists more servers to accept,
+// such as HA partners not reachable through this Control Agent.
+func NewDetector(client *kea.Client, host *linux.Host, extra []string) *Detector {
+  return &Detector{
+    client:   client,
+    host:     host,
+    extra:    extra,
+    findings: map[string]*Finding{},
+    stats:    map[string]statPoint{},
+  }
+}
+
+// record adds an observation to the finding under key, creating it with
+// fill on first sight.
+func (d *Detector) record(key string, at time.Time, evidence string, fill func(*Finding)) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+  f := d.findings[key]
+  if f == nil {
+    f = &Finding{Key: key, First: at}
+    d.findings[key] = f
+  }
+  fill(f)
+  f.Count++
+  if at.After(f.Last) {
+    f.Last = at
+  }
+  if at.Before(f.First) {
+    f.First = at
+  }
+  f.Evidence = append(f.Evidence, evidence)
+  if len(f.Evidence) > maxEvidence {
+    f.Evidence = f.Evidence[len(f.Evidence)-maxEvidence:]
+  }
+}
+
+// Findings returns the current findings, errors first and newest first
+// within a severity.
+func (d *Detector) Findings() []Finding {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+  cutoff := time.Now().Add(-findingTTL)
+  out := make([]Finding, 0, len(d.findings))
+  for key, f := range d.findings {
+    if f.Last.Before(cutoff) && f.Kind != KindUnknownServer {
+      delete(d.findings, key)
+      continue
+    }
+    c := *f
+    c.Evidence = append([]string(nil), f.Evidence...)
+    out = append(out, c)
+  }
+  sort.Slice(out, func(i, j int) bool {
+    if out[i].Severity != out[j].Severity {
+      return out[i].Severity == SeverityError
+    }
+    return out[i].Last.After(out[j].Last)
+  })
+  return out
+}
+
+// Dismiss forgets a finding.
+func (d *Detector) Dismiss(key string) bool {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+  _, ok := d.findings[key]
+  delete(d.findings, key)
+  return ok
+}
+
+// RecordCapture adds every server of an analysed capture that is not a
+// known server. It returns how many unknown servers were found.
+func (d *Detector) RecordCapture(ctx context.Context, name string, rep *capture.Report) (int, error) {
+  known := d.Known(ctx)
+  if len(known.Servers) == 0 {
+    return 0, ErrNoKnownServers
+  }
+  set := capture.NewServerSet(known.IDs())
+
+  n := 0
+  for _, s := range rep.Servers {
+    if set.Has(s) {
+      continue
+    }
+    n++
+    s := s
+    evidence := fmt.Sprintf("%s: %d offers, %d acks, %d NAKs from %s, first at %s (frame %d)",
+      name, s.Offers, s.Acks, s.Naks, strings.Join(s.Addrs, ", "), s.First.Format("2006-01-02 15:04:05"), s.FirstN)
+    at := s.First
+    if at.IsZero() {
+      at = time.Now()
+    }
+    d.record(KindUnknownServer+"|"+strconv.Itoa(s.Family)+"|"+s.ID, at, evidence, func(f *Finding) {
+      f.Severity = SeverityError
+      f.Kind = KindUnknownServer
+      f.Family = s.Family
+      f.Subject = s.ID
+      f.Message = fmt.Sprintf("DHCPv%d server %s (%s) answered clients but is not one of the Kea servers", s.Family, s.ID, strings.Join(s.Addrs, ", "))
+    })
+  }
+  return n, nil
+}
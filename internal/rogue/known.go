@@ -0,0 +1,154 @@
+package rogue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// knownTTL is how long the known servers are cached; they only change
+// with the configuration.
+const knownTTL = 5 * time.Minute
+
+// KnownServer is an identifier or address our own servers use.
+type KnownServer struct {
+  Family int
+  ID     string
+  // Source says where the identifier came from.
+  Source string
+}
+
+// KnownServers are the identities of the configured Kea servers.
+type KnownServers struct {
+  Servers []KnownServer
+  // Errors lists what couldn't be read; the rest is still used.
+  Errors []string
+}
+
+// IDs returns every known identifier and address.
+func (k *KnownServers) IDs() []string {
+  out := make([]string, 0, len(k.Servers))
+  for _, s := range k.Servers {
+    out = append(out, s.ID)
+  }
+  return out
+}
+
+func (k *KnownServers) add(family int, id, source string) {
+  id = strings.TrimSpace(id)
+  if id == "" {
+    return
+  }
+  for _, s := range k.Servers {
+    if s.Family == family && s.ID == id {
+      return
+    }
+  }
+  k.Servers = append(k.Servers, KnownServer{Family: family, ID: id, Source: source})
+}
+
+// Known returns the known servers, re-reading the configuration every
+// knownTTL.
+func (d *Detector) Known(ctx context.Context) *KnownServers {
+  d.mu.Lock()
+  if d.known != nil && time.Since(d.knownAt) < knownTTL {
+    k := d.known
+    d.mu.Unlock()
+    return k
+  }
+  d.mu.Unlock()
+
+  k := d.resolveKnown(ctx)
+  d.mu.Lock()
+  d.known, d.knownAt = k, time.Now()
+  d.mu.Unlock()
+  return k
+}
+
+// resolveKnown reads the server identities from the DHCP daemons'
+// configuration: configured identifiers, the addresses of the
+// interfaces they listen on, the generated DHCPv6 DUID and the HA
+// peers.
+func (d *Detector) resolveKnown(ctx context.Context) *KnownServers {
+  k := &KnownServers{}
+  for _, id := range d.extra {
+    family := 4
+    if a, err := netip.ParseAddr(id); (err == nil && a.Is6()) || (err != nil && strings.Count(id, ":") > 6) {
+      family = 6
+    }
+    k.add(family, id, "KEA_KNOWN_SERVERS")
+  }
+
+  ifaces, ifErr := d.host.Interfaces()
+  if ifErr != nil {
+    k.Errors = append(k.Errors, "interfaces: "+ifErr.Error())
+  }
+
+  for _, service := range []string{kea.ServiceDHCP4, kea.ServiceDHCP6} {
+    family := 4
+    if service == kea.ServiceDHCP6 {
+      family = 6
+    }
+    cfg, err := d.client.ConfigGet(ctx, service)
+    if err != nil {
+      k.Errors = append(k.Errors, service+": "+err.Error())
+      continue
+    }
+    id := kea.ServerIdentityFromConfig(service, cfg)
+
+    for _, sid := range id.ServerIDs {
+      k.add(family, sid, "configured server identifier")
+    }
+    if id.DUIDFile != "" {
+      b, err := d.host.ReadFile(id.DUIDFile)
+      switch {
+      case err == nil:
+        k.add(family, string(b), "generated DUID in "+id.DUIDFile)
+      case !errors.Is(err, os.ErrNotExist):
+        k.Errors = append(k.Errors, err.Error())
+      }
+    }
+
+    for _, peer := range id.Peers {
+      if a, err := netip.ParseAddr(peer); err == nil {
+        k.add(family, a.Unmap().String(), "HA peer")
+        continue
+      }
+      lookup, cancel := context.WithTimeout(ctx, 2*time.Second)
+      addrs, err := net.DefaultResolver.LookupHost(lookup, peer)
+      cancel()
+      if err != nil {
+        k.Errors = append(k.Errors, fmt.Sprintf("HA peer %s: %v", peer, err))
+      }
+      for _, a := range addrs {
+        k.add(family, a, "HA peer "+peer)
+      }
+    }
+
+    for _, entry := range id.Interfaces {
+      name, addr := kea.SplitInterfaceEntry(entry)
+      if addr != "" {
+        k.add(family, addr, "interfaces-config "+entry)
+        continue
+      }
+      for _, ifc := range ifaces {
+        if ifc.Loopback || (name != "*" && ifc.Name != name) {
+          continue
+        }
+        for _, p := range ifc.Addrs {
+          if p.Addr().Is4() == (family == 4) {
+            k.add(family, p.Addr().String(), "address of "+ifc.Name)
+          }
+        }
+      }
+    }
+  }
+  return k
+}
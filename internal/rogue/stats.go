@@ -0,0 +1,143 @@
+package rogue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Thresholds of the statistics rules, per minute.
+const (
+  declineSpikeRate = 5
+  declineStormRate = 20
+)
+
+// statPoint is the value a statistic had at the previous poll.
+type statPoint struct {
+  Value float64
+  At    time.Time
+}
+
+// statRule turns an increase of a statistic into a finding.
+type statRule struct {
+  kind     string
+  severity string
+  // match reports whether the rule applies to a statistic name.
+  match func(name string) bool
+  // rate is the increase per minute that triggers the rule; 0 means
+  // any increase.
+  rate    float64
+  message func(service, name string) string
+}
+
+var statRules = []statRule{
+  {
+    kind:     KindNAKReceived,
+    severity: SeverityWarning,
+    match:    func(name string) bool { return name == "pkt4-nak-received" },
+    message: func(service, name string) string {
+      return "kea-" + service + " received DHCPNAKs; only another server sends them"
+    },
+  },
+  {
+    kind:     KindDeclineSpike,
+    severity: SeverityWarning,
+    match: func(name string) bool {
+      return name == "declined-addresses" || (strings.HasPrefix(name, "subnet[") && strings.HasSuffix(name, "].declined-addresses"))
+    },
+    rate: declineSpikeRate,
+    message: func(service, name string) string {
+      return fmt.Sprintf("%s of kea-%s is rising fast; clients find the addresses in use, possibly handed out by another server", name, service)
+    },
+  },
+  {
+    kind:     KindDeclineStorm,
+    severity: SeverityError,
+    match:    func(name string) bool { return name == "pkt4-decline-received" || name == "pkt6-decline-received" },
+    rate:     declineStormRate,
+    message: func(service, name string) string {
+      return fmt.Sprintf("kea-%s is receiving a storm of declines (%s); another server is likely handing out the same addresses", service, name)
+    },
+  },
+}
+
+// Run polls the statistics of both DHCP daemons every interval until ctx
+// is done.
+func (d *Detector) Run(ctx context.Context, interval time.Duration) {
+  utils.Info("Watching DHCP statistics for conflicting servers every %s", interval)
+  t := time.NewTicker(interval)
+  defer t.Stop()
+  for {
+    d.Poll(ctx)
+    select {
+    case <-ctx.Done():
+      return
+    case <-t.C:
+    }
+  }
+}
+
+// Poll reads the statistics once and checks them against the previous
+// poll. The first poll of a statistic only records a baseline.
+func (d *Detector) Poll(ctx context.Context) {
+  for _, service := range []string{kea.ServiceDHCP4, kea.ServiceDHCP6} {
+    stats, err := d.client.StatisticGetAll(ctx, service)
+    if err != nil {
+      utils.Debug("Statistics of %s: %v", service, err)
+      continue
+    }
+    d.checkStats(service, stats, time.Now())
+  }
+}
+
+func (d *Detector) checkStats(service string, stats kea.Statistics, now time.Time) {
+  for name, samples := range stats {
+    if len(samples) == 0 {
+      continue
+    }
+    var rule *statRule
+    for i := range statRules {
+      if statRules[i].match(name) {
+        rule = &statRules[i]
+        break
+      }
+    }
+    if rule == nil {
+      continue
+    }
+
+    cur := statPoint{Value: samples[0].Value, At: now}
+    key := service + "|" + name
+    d.mu.Lock()
+    prev, seen := d.stats[key]
+    d.stats[key] = cur
+    d.mu.Unlock()
+    // A decrease means the server restarted or the statistic was reset.
+    if !seen || cur.Value <= prev.Value {
+      continue
+    }
+
+    delta := cur.Value - prev.Value
+    perMinute := delta / cur.At.Sub(prev.At).Minutes()
+    if rule.rate > 0 && perMinute < rule.rate {
+      continue
+    }
+    evidence := fmt.Sprintf("%s %g → %g between %s and %s (%.1f/min)", name, prev.Value, cur.Value,
+      prev.At.Format("15:04:05"), cur.At.Format("15:04:05"), perMinute)
+    family := 4
+    if service == kea.ServiceDHCP6 {
+      family = 6
+    }
+    d.record(rule.kind+"|"+service+"|"+name, now, evidence, func(f *Finding) {
+      f.Severity = rule.severity
+      f.Kind = rule.kind
+      f.Family = family
+      f.Subject = name
+      f.Message = rule.message(service, name)
+    })
+  }
+}
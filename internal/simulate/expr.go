@@ -0,0 +1,859 @@
+package simulate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+)
+
+// Expr is a parsed Kea classification expression. Values are byte
+// strings, as in Kea: integers are 32-bit big-endian, addresses their 4
+// or 16 bytes and booleans the strings "true" and "false".
+type Expr struct {
+  Source string
+  root   node
+}
+
+// Env is what an expression is evaluated against.
+type Env struct {
+  Packet *Packet
+  // Classes are the classes the packet belongs to so far.
+  Classes map[string]bool
+}
+
+type node func(env *Env) ([]byte, error)
+
+var (
+  valTrue  = []byte("true")
+  valFalse = []byte("false")
+)
+
+func boolVal(b bool) []byte {
+  if b {
+    return valTrue
+  }
+  return valFalse
+}
+
+func asBool(v []byte) (bool, error) {
+  switch string(v) {
+  case "true":
+    return true, nil
+  case "false":
+    return false, nil
+  }
+  return false, fmt.Errorf("%q is not a boolean", v)
+}
+
+// Eval evaluates a test expression, which must be boolean.
+func (e *Expr) Eval(env *Env) (bool, error) {
+  v, err := e.root(env)
+  if err != nil {
+    return false, err
+  }
+  return asBool(v)
+}
+
+// Value evaluates the expression as a string, as template classes do.
+func (e *Expr) Value(env *Env) ([]byte, error) {
+  return e.root(env)
+}
+
+// token is a lexeme of an expression.
+type token struct {
+  kind string // "str", "hex", "int", "ip", "word", or the punctuation itself
+  text string
+  pos  int
+}
+
+func lex(s string) ([]token, error) {
+  var out []token
+  isHex := func(r byte) bool {
+    return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+  }
+  for i := 0; i < len(s); {
+    c := s[i]
+    switch {
+    case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+      i++
+    case c == '\'':
+      j := strings.IndexByte(s[i+1:], '\'')
+      if j < 0 {
+        return nil, fmt.Errorf("unterminated string at %d", i)
+      }
+      out = append(out, token{"str", s[i+1 : i+1+j], i})
+      i += j + 2
+    case c == '=' && i+1 < len(s) && s[i+1] == '=':
+      out = append(out, token{"==", "==", i})
+      i += 2
+    case strings.IndexByte("()[].,*", c) >= 0:
+      out = append(out, token{string(c), string(c), i})
+      i++
+    case c == '0' && i+1 < len(s) && (s[i+1] == 'x' || s[i+1] == 'X'):
+      j := i + 2
+      for j < len(s) && isHex(s[j]) {
+        j++
+      }
+      out = append(out, token{"hex", s[i+2 : j], i})
+      i = j
+    case (c >= '0' && c <= '9') || (c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+      j := i + 1
+      for j < len(s) && (isHex(s[j]) || s[j] == '.' || s[j] == ':') {
+        // A dot followed by a letter ends the number: option[1].hex.
+        if s[j] == '.' && (j+1 >= len(s) || !isHex(s[j+1])) {
+          break
+        }
+        j++
+      }
+      text := s[i:j]
+      if strings.ContainsAny(text, ".:") {
+        out = append(out, token{"ip", text, i})
+      } else if _, err := strconv.Atoi(text); err == nil {
+        out = append(out, token{"int", text, i})
+      } else {
+        return nil, fmt.Errorf("bad number %q at %d", text, i)
+      }
+      i = j
+    case unicode.IsLetter(rune(c)):
+      j := i
+      for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '-' || s[j] == '_') {
+        j++
+      }
+      // IPv6 addresses may start with a letter: fe80::1.
+      if j < len(s) && s[j] == ':' {
+        k := j
+        for k < len(s) && (isHex(s[k]) || s[k] == ':' || s[k] == '.') {
+          k++
+        }
+        if _, err := netip.ParseAddr(s[i:k]); err == nil {
+          out = append(out, token{"ip", s[i:k], i})
+          i = k
+          continue
+        }
+      }
+      out = append(out, token{"word", strings.ToLower(s[i:j]), i})
+      i = j
+    default:
+      return nil, fmt.Errorf("unexpected %q at %d", c, i)
+    }
+  }
+  return out, nil
+}
+
+// parser builds the evaluation closures of an expression.
+type parser struct {
+  toks  []token
+  i     int
+  names func(name string) (int, bool)
+  // members are the classes referenced with member().
+  members []string
+}
+
+// ParseExpr parses a Kea expression. names resolves option names used
+// as option[name]; nil resolves nothing.
+func ParseExpr(s string, names func(string) (int, bool)) (*Expr, []string, error) {
+  toks, err := lex(s)
+  if err != nil {
+    return nil, nil, err
+  }
+  if names == nil {
+    names = func(string) (int, bool) { return 0, false }
+  }
+  p := &parser{toks: toks, names: names}
+  root, err := p.parseOr()
+  if err != nil {
+    return nil, nil, err
+  }
+  if p.i < len(p.toks) {
+    return nil, nil, p.errorf("unexpected %q", p.toks[p.i].text)
+  }
+  return &Expr{Source: s, root: root}, p.members, nil
+}
+
+func (p *parser) peek() token {
+  if p.i < len(p.toks) {
+    return p.toks[p.i]
+  }
+  return token{kind: "eof"}
+}
+
+func (p *parser) next() token {
+  t := p.peek()
+  if p.i < len(p.toks) {
+    p.i++
+  }
+  return t
+}
+
+// back returns t, just read, so that errors point at it. At the end
+// there is nothing to return.
+func (p *parser) back(t token) {
+  if t.kind != "eof" {
+    p.i--
+  }
+}
+
+func (p *parser) errorf(format string, a ...any) error {
+  pos := -1
+  if p.i < len(p.toks) {
+    pos = p.toks[p.i].pos
+  }
+  msg := fmt.Sprintf(format, a...)
+  if pos >= 0 {
+    return fmt.Errorf("%s at %d", msg, pos)
+  }
+  return errors.New(msg + " at the end")
+}
+
+func (p *parser) isWord(w string) bool {
+  t := p.peek()
+  return t.kind == "word" && t.text == w
+}
+
+func (p *parser) expect(kind string) (token, error) {
+  t := p.next()
+  if t.kind != kind {
+    p.back(t)
+    return t, p.errorf("expected %q", kind)
+  }
+  return t, nil
+}
+
+func (p *parser) expectWord(words ...string) (string, error) {
+  t := p.next()
+  if t.kind == "word" {
+    for _, w := range words {
+      if t.text == w {
+        return w, nil
+      }
+    }
+  }
+  p.back(t)
+  return "", p.errorf("expected %s", strings.Join(words, " or "))
+}
+
+func (p *parser) parseOr() (node, error) {
+  left, err := p.parseAnd()
+  if err != nil {
+    return nil, err
+  }
+  for p.isWord("or") {
+    p.next()
+    right, err := p.parseAnd()
+    if err != nil {
+      return nil, err
+    }
+    l := left
+    left = func(env *Env) ([]byte, error) {
+      a, err := evalBool(l, env)
+      if err != nil || a {
+        return boolVal(a), err
+      }
+      b, err := evalBool(right, env)
+      return boolVal(b), err
+    }
+  }
+  return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+  left, err := p.parseNot()
+  if err != nil {
+    return nil, err
+  }
+  for p.isWord("and") {
+    p.next()
+    right, err := p.parseNot()
+    if err != nil {
+      return nil, err
+    }
+    l := left
+    left = func(env *Env) ([]byte, error) {
+      a, err := evalBool(l, env)
+      if err != nil || !a {
+        return boolVal(a), err
+      }
+      b, err := evalBool(right, env)
+      return boolVal(b), err
+    }
+  }
+  return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+  if p.isWord("not") {
+    p.next()
+    inner, err := p.parseNot()
+    if err != nil {
+      return nil, err
+    }
+    return func(env *Env) ([]byte, error) {
+      v, err := evalBool(inner, env)
+      return boolVal(!v), err
+    }, nil
+  }
+  left, err := p.parseTerm()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek().kind == "==" {
+    p.next()
+    right, err := p.parseTerm()
+    if err != nil {
+      return nil, err
+    }
+    return func(env *Env) ([]byte, error) {
+      a, err := left(env)
+      if err != nil {
+        return nil, err
+      }
+      b, err := right(env)
+      if err != nil {
+        return nil, err
+      }
+      return boolVal(bytes.Equal(a, b)), nil
+    }, nil
+  }
+  return left, nil
+}
+
+func evalBool(n node, env *Env) (bool, error) {
+  v, err := n(env)
+  if err != nil {
+    return false, err
+  }
+  return asBool(v)
+}
+
+func constant(v []byte) node {
+  return func(*Env) ([]byte, error) { return v, nil }
+}
+
+func uint32Val(v uint32) []byte {
+  return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func (p *parser) parseTerm() (node, error) {
+  t := p.next()
+  switch t.kind {
+  case "str":
+    return constant([]byte(t.text)), nil
+  case "hex":
+    h := t.text
+    if len(h)%2 == 1 {
+      h = "0" + h
+    }
+    b, err := hex.DecodeString(h)
+    if err != nil {
+      return nil, fmt.Errorf("bad hex string at %d", t.pos)
+    }
+    return constant(b), nil
+  case "int":
+    n, _ := strconv.ParseInt(t.text, 10, 64)
+    return constant(uint32Val(uint32(n))), nil
+  case "ip":
+    a, err := netip.ParseAddr(t.text)
+    if err != nil {
+      return nil, fmt.Errorf("bad address %q at %d", t.text, t.pos)
+    }
+    return constant(a.AsSlice()), nil
+  case "(":
+    inner, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(")"); err != nil {
+      return nil, err
+    }
+    return inner, nil
+  case "word":
+    return p.parseWord(t)
+  }
+  p.back(t)
+  return nil, p.errorf("unexpected %q", t.text)
+}
+
+// parseIndex reads "[n]"; "*" gives -1 with any set.
+func (p *parser) parseIndex(allowName, allowAny bool) (int, bool, error) {
+  if _, err := p.expect("["); err != nil {
+    return 0, false, err
+  }
+  t := p.next()
+  n, any := 0, false
+  switch {
+  case t.kind == "int":
+    n, _ = strconv.Atoi(t.text)
+  case t.kind == "*" && allowAny:
+    any = true
+  case t.kind == "word" && allowName:
+    code, ok := p.names(t.text)
+    if !ok {
+      p.back(t)
+      return 0, false, p.errorf("unknown option %q", t.text)
+    }
+    n = code
+  default:
+    p.back(t)
+    return 0, false, p.errorf("expected a number")
+  }
+  _, err := p.expect("]")
+  return n, any, err
+}
+
+// optionAccess parses ".hex", ".text" or ".exists" after an option
+// getter.
+func (p *parser) optionAccess(get func(env *Env) ([]byte, bool)) (node, error) {
+  if _, err := p.expect("."); err != nil {
+    return nil, err
+  }
+  w, err := p.expectWord("hex", "text", "exists")
+  if err != nil {
+    return nil, err
+  }
+  if w == "exists" {
+    return func(env *Env) ([]byte, error) {
+      _, ok := get(env)
+      return boolVal(ok), nil
+    }, nil
+  }
+  return func(env *Env) ([]byte, error) {
+    v, _ := get(env)
+    return v, nil
+  }, nil
+}
+
+func (p *parser) parseArgs(n int) ([]node, error) {
+  if _, err := p.expect("("); err != nil {
+    return nil, err
+  }
+  var args []node
+  for i := 0; i < n; i++ {
+    if i > 0 {
+      if _, err := p.expect(","); err != nil {
+        return nil, err
+      }
+    }
+    a, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    args = append(args, a)
+  }
+  _, err := p.expect(")")
+  return args, err
+}
+
+// intArg reads an integer literal argument, or "all" when allowed.
+func (p *parser) intArg(allowAll bool) (int, bool, error) {
+  t := p.next()
+  if t.kind == "int" {
+    n, _ := strconv.Atoi(t.text)
+    return n, false, nil
+  }
+  if t.kind == "str" && t.text == "all" && allowAll {
+    return 0, true, nil
+  }
+  if t.kind == "word" && t.text == "all" && allowAll {
+    return 0, true, nil
+  }
+  p.back(t)
+  return 0, false, p.errorf("expected an integer")
+}
+
+func (p *parser) parseWord(t token) (node, error) {
+  switch t.text {
+  case "true", "false":
+    return constant([]byte(t.text)), nil
+  case "known", "unknown":
+    name := strings.ToUpper(t.text)
+    p.members = append(p.members, name)
+    return func(env *Env) ([]byte, error) { return boolVal(env.Classes[name]), nil }, nil
+  case "member":
+    if _, err := p.expect("("); err != nil {
+      return nil, err
+    }
+    s, err := p.expect("str")
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(")"); err != nil {
+      return nil, err
+    }
+    p.members = append(p.members, s.text)
+    return func(env *Env) ([]byte, error) { return boolVal(env.Classes[s.text]), nil }, nil
+
+  case "option":
+    code, _, err := p.parseIndex(true, false)
+    if err != nil {
+      return nil, err
+    }
+    get := func(env *Env) ([]byte, bool) { return env.Packet.option(code) }
+    if p.peek().kind == "." && p.i+1 < len(p.toks) && p.toks[p.i+1].text == "option" {
+      p.i += 2
+      sub, _, err := p.parseIndex(false, false)
+      if err != nil {
+        return nil, err
+      }
+      outer := get
+      get = func(env *Env) ([]byte, bool) {
+        b, ok := outer(env)
+        if !ok {
+          return nil, false
+        }
+        return findOption(env.Packet.subOptions(b), sub)
+      }
+    }
+    return p.optionAccess(get)
+
+  case "relay4":
+    code, _, err := p.parseIndex(false, false)
+    if err != nil {
+      return nil, err
+    }
+    return p.optionAccess(func(env *Env) ([]byte, bool) {
+      if env.Packet.Family != 4 {
+        return nil, false
+      }
+      b, ok := env.Packet.option(dhcp.OptRelayAgentInfo4)
+      if !ok {
+        return nil, false
+      }
+      return findOption(env.Packet.subOptions(b), code)
+    })
+
+  case "relay6":
+    nest, _, err := p.parseIndex(false, false)
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect("."); err != nil {
+      return nil, err
+    }
+    w, err := p.expectWord("option", "peeraddr", "linkaddr")
+    if err != nil {
+      return nil, err
+    }
+    if w != "option" {
+      return func(env *Env) ([]byte, error) {
+        r := env.Packet.relay6(nest)
+        if r == nil {
+          return []byte{}, nil
+        }
+        if w == "peeraddr" {
+          return r.PeerAddr.AsSlice(), nil
+        }
+        return r.LinkAddr.AsSlice(), nil
+      }, nil
+    }
+    code, _, err := p.parseIndex(true, false)
+    if err != nil {
+      return nil, err
+    }
+    return p.optionAccess(func(env *Env) ([]byte, bool) {
+      r := env.Packet.relay6(nest)
+      if r == nil {
+        return nil, false
+      }
+      return findOption(r.Options, code)
+    })
+
+  case "pkt4", "pkt6", "pkt":
+    if _, err := p.expect("."); err != nil {
+      return nil, err
+    }
+    f := p.next()
+    if f.kind != "word" {
+      p.back(f)
+      return nil, p.errorf("expected a packet field")
+    }
+    scope, field := t.text, f.text
+    switch scope + "." + field {
+    case "pkt4.mac", "pkt4.hlen", "pkt4.htype", "pkt4.ciaddr", "pkt4.giaddr", "pkt4.yiaddr", "pkt4.siaddr",
+      "pkt4.msgtype", "pkt4.transid", "pkt6.msgtype", "pkt6.transid",
+      "pkt.iface", "pkt.src", "pkt.dst", "pkt.len":
+    default:
+      p.back(f)
+      return nil, p.errorf("unknown field %s.%s", scope, field)
+    }
+    return func(env *Env) ([]byte, error) { return env.Packet.field(scope, field), nil }, nil
+
+  case "vendor", "vendor-class":
+    class := t.text == "vendor-class"
+    if p.peek().kind == "." {
+      p.next()
+      if _, err := p.expectWord("enterprise"); err != nil {
+        return nil, err
+      }
+      return func(env *Env) ([]byte, error) {
+        v := env.Packet.vendor(class)
+        if len(v) == 0 {
+          return []byte{}, nil
+        }
+        return uint32Val(v[0].enterprise), nil
+      }, nil
+    }
+    ent, any, err := p.parseIndex(false, true)
+    if err != nil {
+      return nil, err
+    }
+    find := func(env *Env) (vendorEntry, bool) {
+      for _, v := range env.Packet.vendor(class) {
+        if any || v.enterprise == uint32(ent) {
+          return v, true
+        }
+      }
+      return vendorEntry{}, false
+    }
+    if _, err := p.expect("."); err != nil {
+      return nil, err
+    }
+    words := []string{"exists", "option"}
+    if class {
+      words = []string{"exists", "data"}
+    }
+    w, err := p.expectWord(words...)
+    if err != nil {
+      return nil, err
+    }
+    switch w {
+    case "exists":
+      return func(env *Env) ([]byte, error) {
+        _, ok := find(env)
+        return boolVal(ok), nil
+      }, nil
+    case "data":
+      idx := 0
+      if p.peek().kind == "[" {
+        if idx, _, err = p.parseIndex(false, false); err != nil {
+          return nil, err
+        }
+      }
+      return func(env *Env) ([]byte, error) {
+        v, ok := find(env)
+        if !ok || idx >= len(v.data) {
+          return []byte{}, nil
+        }
+        return v.data[idx], nil
+      }, nil
+    }
+    code, _, err := p.parseIndex(false, false)
+    if err != nil {
+      return nil, err
+    }
+    return p.optionAccess(func(env *Env) ([]byte, bool) {
+      v, ok := find(env)
+      if !ok {
+        return nil, false
+      }
+      return findOption(v.options, code)
+    })
+
+  case "substring":
+    if _, err := p.expect("("); err != nil {
+      return nil, err
+    }
+    s, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(","); err != nil {
+      return nil, err
+    }
+    start, _, err := p.intArg(false)
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(","); err != nil {
+      return nil, err
+    }
+    length, all, err := p.intArg(true)
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(")"); err != nil {
+      return nil, err
+    }
+    return func(env *Env) ([]byte, error) {
+      v, err := s(env)
+      if err != nil {
+        return nil, err
+      }
+      return substring(v, start, length, all), nil
+    }, nil
+
+  case "split":
+    if _, err := p.expect("("); err != nil {
+      return nil, err
+    }
+    s, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(","); err != nil {
+      return nil, err
+    }
+    delim, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(","); err != nil {
+      return nil, err
+    }
+    field, _, err := p.intArg(false)
+    if err != nil {
+      return nil, err
+    }
+    if _, err := p.expect(")"); err != nil {
+      return nil, err
+    }
+    return func(env *Env) ([]byte, error) {
+      v, err := s(env)
+      if err != nil {
+        return nil, err
+      }
+      d, err := delim(env)
+      if err != nil {
+        return nil, err
+      }
+      fields := bytes.FieldsFunc(v, func(r rune) bool { return bytes.ContainsRune(d, r) })
+      if len(d) == 0 || field < 1 || field > len(fields) {
+        return []byte{}, nil
+      }
+      return fields[field-1], nil
+    }, nil
+
+  case "concat", "hexstring":
+    args, err := p.parseArgs(2)
+    if err != nil {
+      return nil, err
+    }
+    name := t.text
+    return func(env *Env) ([]byte, error) {
+      a, err := args[0](env)
+      if err != nil {
+        return nil, err
+      }
+      b, err := args[1](env)
+      if err != nil {
+        return nil, err
+      }
+      if name == "concat" {
+        return append(append([]byte(nil), a...), b...), nil
+      }
+      parts := make([]string, len(a))
+      for i, c := range a {
+        parts[i] = fmt.Sprintf("%02x", c)
+      }
+      return []byte(strings.Join(parts, string(b))), nil
+    }, nil
+
+  case "ifelse":
+    args, err := p.parseArgs(3)
+    if err != nil {
+      return nil, err
+    }
+    return func(env *Env) ([]byte, error) {
+      c, err := evalBool(args[0], env)
+      if err != nil {
+        return nil, err
+      }
+      if c {
+        return args[1](env)
+      }
+      return args[2](env)
+    }, nil
+
+  case "addrtotext", "lcase", "ucase",
+    "int8totext", "int16totext", "int32totext", "uint8totext", "uint16totext", "uint32totext":
+    args, err := p.parseArgs(1)
+    if err != nil {
+      return nil, err
+    }
+    name := t.text
+    return func(env *Env) ([]byte, error) {
+      v, err := args[0](env)
+      if err != nil {
+        return nil, err
+      }
+      return convert(name, v)
+    }, nil
+  }
+  p.back(t)
+  return nil, p.errorf("unknown keyword %q", t.text)
+}
+
+// substring follows Kea: a negative start counts from the end and a
+// negative length takes the bytes before start.
+func substring(v []byte, start, length int, all bool) []byte {
+  n := len(v)
+  if all {
+    length = n
+  }
+  if n == 0 || start < -n || start >= n {
+    return []byte{}
+  }
+  if start < 0 {
+    start += n
+  }
+  if length < 0 {
+    length = -length
+    if length <= start {
+      start -= length
+    } else {
+      length, start = start, 0
+    }
+  }
+  end := min(start+length, n)
+  return v[start:end]
+}
+
+func convert(name string, v []byte) ([]byte, error) {
+  size := map[string]int{
+    "int8totext": 1, "uint8totext": 1, "int16totext": 2, "uint16totext": 2, "int32totext": 4, "uint32totext": 4,
+  }
+  switch name {
+  case "lcase":
+    return bytes.ToLower(v), nil
+  case "ucase":
+    return bytes.ToUpper(v), nil
+  case "addrtotext":
+    a, ok := netip.AddrFromSlice(v)
+    if !ok {
+      return nil, fmt.Errorf("addrtotext: %d bytes is not an address", len(v))
+    }
+    return []byte(a.String()), nil
+  }
+  if len(v) == 0 {
+    return []byte{}, nil
+  }
+  if len(v) != size[name] {
+    return nil, fmt.Errorf("%s: needs %d bytes, got %d", name, size[name], len(v))
+  }
+  var u uint64
+  for _, c := range v {
+    u = u<<8 | uint64(c)
+  }
+  if strings.HasPrefix(name, "uint") {
+    return []byte(strconv.FormatUint(u, 10)), nil
+  }
+  bits := uint(8 * len(v))
+  signed := int64(u<<(64-bits)) >> (64 - bits)
+  return []byte(strconv.FormatInt(signed, 10)), nil
+}
+
+func findOption(opts []dhcp.Option, code int) ([]byte, bool) {
+  for _, o := range opts {
+    if o.Code == code {
+      return o.Data, true
+    }
+  }
+  return nil, false
+}
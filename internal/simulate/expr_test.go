@@ -0,0 +1,135 @@
+package simulate
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func testPacket() *Packet {
+  mac, _ := net.ParseMAC("00:11:22:33:44:55")
+  return BuildPacket(Input{
+    Family:      4,
+    Interface:   "eth0",
+    Relay:       netip.MustParseAddr("10.0.0.1"),
+    MAC:         mac,
+    VendorClass: "PXEClient:Arch:00000",
+  })
+}
+
+func testNames(name string) (int, bool) {
+  if name == "vendor-class-identifier" {
+    return 60, true
+  }
+  return 0, false
+}
+
+func TestParseExpr(t *testing.T) {
+  tests := []struct {
+    src  string
+    want bool
+  }{
+    {"true", true},
+    {"not false", true},
+    {"true and false", false},
+    {"false or true", true},
+    {"'a' == 'a'", true},
+    {"0x41 == 'A'", true},
+    {"(true)", true},
+    {"substring(option[60].hex,0,9) == 'PXEClient'", true},
+    {"substring(option[vendor-class-identifier].hex,0,9) == 'PXEClient'", true},
+    {"option[60].exists", true},
+    {"option[61].exists", false},
+    {"pkt4.giaddr == 10.0.0.1", true},
+    {"pkt.iface == 'eth0'", true},
+    {"member('KNOWN')", true},
+    {"unknown", false},
+    {"ifelse(known, 'a', 'b') == 'a'", true},
+    {"concat('a', 'b') == 'ab'", true},
+  }
+  for _, tt := range tests {
+    e, _, err := ParseExpr(tt.src, testNames)
+    if err != nil {
+      t.Errorf("ParseExpr(%q): %v", tt.src, err)
+      continue
+    }
+    got, err := e.Eval(&Env{Packet: testPacket(), Classes: map[string]bool{"KNOWN": true}})
+    if err != nil {
+      t.Errorf("Eval(%q): %v", tt.src, err)
+      continue
+    }
+    if got != tt.want {
+      t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want)
+    }
+  }
+}
+
+func TestParseExprMembers(t *testing.T) {
+  _, members, err := ParseExpr("member('voip') or known", nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if strings.Join(members, ",") != "voip,KNOWN" {
+    t.Errorf("members = %q", members)
+  }
+}
+
+func TestParseExprErrors(t *testing.T) {
+  tests := []struct {
+    src  string
+    want string
+  }{
+    {"", "unexpected \"\" at the end"},
+    {" ", "unexpected \"\" at the end"},
+    {"(", "unexpected \"\" at the end"},
+    {"not", "unexpected \"\" at the end"},
+    {"'a' ==", "unexpected \"\" at the end"},
+    {"(true", "expected \")\" at the end"},
+    {"option", "expected \"[\" at the end"},
+    {"option[", "expected a number at the end"},
+    {"option[60", "expected \"]\" at the end"},
+    {"option[60]", "expected \".\" at the end"},
+    {"option[60].", "expected hex or text or exists at the end"},
+    {"member(", "expected \"str\" at the end"},
+    {"pkt4.", "expected a packet field at the end"},
+    {"substring('ab', 0,", "expected an integer at the end"},
+    {"true true", "unexpected \"true\" at 5"},
+    {"option[nosuch].hex", "unknown option \"nosuch\" at 7"},
+    {"pkt4.nosuch", "unknown field pkt4.nosuch at 5"},
+    {"nosuch", "unknown keyword \"nosuch\" at 0"},
+    {") == 'a'", "unexpected \")\" at 0"},
+    {"'open", "unterminated string at 0"},
+  }
+  for _, tt := range tests {
+    _, _, err := ParseExpr(tt.src, testNames)
+    if err == nil || err.Error() != tt.want {
+      t.Errorf("ParseExpr(%q) = %v, want %q", tt.src, err, tt.want)
+    }
+  }
+}
+
+func FuzzParseExpr(f *testing.F) {
+  for _, s := range []string{
+    "",
+    " ",
+    "substring(option[60].hex,0,9) == 'PXEClient'",
+    "member('KNOWN') and option[77].text == 'phone'",
+    "pkt4.giaddr == 10.0.0.1",
+    "relay4[2].hex == 0x0102",
+    "vendor[4491].option[2].exists",
+    "ifelse(option[12].exists, lcase(option[12].text), 'none')",
+    "split('a,b', ',', 2) == 'b'",
+    "pkt6.msgtype == 1 or relay6[0].peeraddr == fe80::1",
+  } {
+    f.Add(s)
+  }
+  f.Fuzz(func(t *testing.T, src string) {
+    e, _, err := ParseExpr(src, testNames)
+    if err != nil {
+      return
+    }
+    env := &Env{Packet: testPacket(), Classes: map[string]bool{}}
+    _, _ = e.Value(env)
+  })
+}
@@ -0,0 +1,335 @@
+package simulate
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+)
+
+// Option codes of the simulated packets.
+const (
+  optVendorClass4 = 60
+  optUserClass4   = 77
+  optVIVCO4       = 124
+  optVIVSO4       = 125
+  optUserClass6   = 15
+  optVendorClass6 = 16
+  optVendorOpts6  = 17
+  optInterfaceID6 = 18
+)
+
+// Input describes the hypothetical client packet.
+type Input struct {
+  Family int
+  // Interface is the interface the packet arrives on.
+  Interface string
+  // Relay is the giaddr (DHCPv4) or the relay's link-address (DHCPv6);
+  // invalid for a client on a directly attached link.
+  Relay netip.Addr
+  // Source is the client's address: ciaddr for a renewing DHCPv4
+  // client, the source address of a direct DHCPv6 client.
+  Source   netip.Addr
+  MAC      net.HardwareAddr
+  ClientID []byte
+  // RelayInfo are option 82 sub-options (DHCPv4) or relay-forward
+  // options (DHCPv6).
+  RelayInfo   []dhcp.Option
+  VendorClass string
+  Enterprise  uint32
+  UserClass   string
+  Options     []dhcp.Option
+  // Requested are the codes of the parameter request list or ORO.
+  Requested []int
+}
+
+// Packet is the simulated query the expressions look at.
+type Packet struct {
+  Family int
+  V4     *dhcp.Packet4
+  // V6 is the outermost message, a relay-forward for relayed clients.
+  V6    *dhcp.Packet6
+  Iface string
+  Src   netip.Addr
+  Dst   netip.Addr
+  Len   int
+}
+
+// BuildPacket turns the input into a DISCOVER or SOLICIT.
+func BuildPacket(in Input) *Packet {
+  p := &Packet{Family: in.Family, Iface: in.Interface}
+  if in.Family == 6 {
+    msg := &dhcp.Packet6{Type: dhcp.Solicit6, XID: 0x123456}
+    duid := in.ClientID
+    if len(duid) == 0 && len(in.MAC) > 0 {
+      duid = append([]byte{0, 3, 0, 1}, in.MAC...)
+    }
+    if len(duid) > 0 {
+      msg.Options = append(msg.Options, dhcp.Option{Code: dhcp.OptClientID6, Data: duid})
+    }
+    msg.Options = append(msg.Options, dhcp.Option{Code: dhcp.OptIANA6, Data: make([]byte, 12)})
+    if len(in.Requested) > 0 {
+      var oro []byte
+      for _, c := range in.Requested {
+        oro = binary.BigEndian.AppendUint16(oro, uint16(c))
+      }
+      msg.Options = append(msg.Options, dhcp.Option{Code: 6, Data: oro})
+    }
+    if in.VendorClass != "" {
+      v := binary.BigEndian.AppendUint32(nil, in.Enterprise)
+      v = binary.BigEndian.AppendUint16(v, uint16(len(in.VendorClass)))
+      msg.Options = append(msg.Options, dhcp.Option{Code: optVendorClass6, Data: append(v, in.VendorClass...)})
+    }
+    if in.UserClass != "" {
+      v := binary.BigEndian.AppendUint16(nil, uint16(len(in.UserClass)))
+      msg.Options = append(msg.Options, dhcp.Option{Code: optUserClass6, Data: append(v, in.UserClass...)})
+    }
+    msg.Options = append(msg.Options, in.Options...)
+    p.V6, p.Src = msg, in.Source
+    if in.Relay.IsValid() {
+      p.V6 = &dhcp.Packet6{
+        Type:     dhcp.RelayForw6,
+        LinkAddr: in.Relay,
+        PeerAddr: in.Source,
+        Options:  in.RelayInfo,
+        Relayed:  msg,
+      }
+      p.Src = in.Relay
+    }
+    p.Len = len(p.V6.Marshal())
+    return p
+  }
+
+  msg := &dhcp.Packet4{
+    Op:     dhcp.BootRequest,
+    HType:  1,
+    HLen:   len(in.MAC),
+    XID:    0x12345678,
+    CHAddr: in.MAC,
+    GIAddr: in.Relay,
+  }
+  if in.Source.IsValid() {
+    msg.CIAddr = in.Source
+    p.Src = in.Source
+  }
+  if in.Relay.IsValid() {
+    msg.Hops = 1
+    p.Src = in.Relay
+  }
+  msg.Options = []dhcp.Option{{Code: dhcp.OptMessageType4, Data: []byte{dhcp.Discover4}}}
+  if len(in.ClientID) > 0 {
+    msg.Options = append(msg.Options, dhcp.Option{Code: dhcp.OptClientID4, Data: in.ClientID})
+  }
+  if in.VendorClass != "" {
+    msg.Options = append(msg.Options, dhcp.Option{Code: optVendorClass4, Data: []byte(in.VendorClass)})
+  }
+  if in.UserClass != "" {
+    msg.Options = append(msg.Options, dhcp.Option{Code: optUserClass4, Data: []byte(in.UserClass)})
+  }
+  if len(in.Requested) > 0 {
+    prl := make([]byte, 0, len(in.Requested))
+    for _, c := range in.Requested {
+      prl = append(prl, byte(c))
+    }
+    msg.Options = append(msg.Options, dhcp.Option{Code: 55, Data: prl})
+  }
+  msg.Options = append(msg.Options, in.Options...)
+  if len(in.RelayInfo) > 0 {
+    msg.Options = append(msg.Options, dhcp.Option{Code: dhcp.OptRelayAgentInfo4, Data: dhcp.AppendOptions4(nil, in.RelayInfo)})
+  }
+  p.V4 = msg
+  p.Len = len(msg.Marshal())
+  return p
+}
+
+// Options returns the options of the client's own message.
+func (p *Packet) Options() []dhcp.Option {
+  if p.Family == 6 {
+    return p.V6.Inner().Options
+  }
+  return p.V4.Options
+}
+
+func (p *Packet) option(code int) ([]byte, bool) {
+  return findOption(p.Options(), code)
+}
+
+// subOptions parses the data of an encapsulating option.
+func (p *Packet) subOptions(b []byte) []dhcp.Option {
+  var opts []dhcp.Option
+  if p.Family == 6 {
+    opts, _ = dhcp.ParseOptions6(b)
+  } else {
+    opts, _ = dhcp.ParseOptions4(b)
+  }
+  return opts
+}
+
+// relay6 returns the relay at nesting level nest counted from the
+// outermost (0); negative levels count from the client (-1).
+func (p *Packet) relay6(nest int) *dhcp.Packet6 {
+  if p.Family != 6 {
+    return nil
+  }
+  relays := p.V6.Relays()
+  if nest < 0 {
+    nest += len(relays)
+  }
+  if nest < 0 || nest >= len(relays) {
+    return nil
+  }
+  return relays[nest]
+}
+
+// Relay6 returns the relay closest to the client, which DHCPv6 subnet
+// selection looks at, or nil.
+func (p *Packet) Relay6() *dhcp.Packet6 {
+  return p.relay6(-1)
+}
+
+func (p *Packet) field(scope, field string) []byte {
+  if scope == "pkt" {
+    switch field {
+    case "iface":
+      return []byte(p.Iface)
+    case "src":
+      return p.Src.AsSlice()
+    case "dst":
+      return p.Dst.AsSlice()
+    case "len":
+      return uint32Val(uint32(p.Len))
+    }
+  }
+  if scope == "pkt6" {
+    if p.Family != 6 {
+      return []byte{}
+    }
+    m := p.V6.Inner()
+    if field == "msgtype" {
+      return uint32Val(uint32(m.Type))
+    }
+    return uint32Val(m.XID)
+  }
+  if p.Family != 4 {
+    return []byte{}
+  }
+  m := p.V4
+  addr := func(a netip.Addr) []byte {
+    if !a.IsValid() {
+      return make([]byte, 4)
+    }
+    return a.AsSlice()
+  }
+  switch field {
+  case "mac":
+    return m.CHAddr
+  case "hlen":
+    return uint32Val(uint32(m.HLen))
+  case "htype":
+    return uint32Val(uint32(m.HType))
+  case "ciaddr":
+    return addr(m.CIAddr)
+  case "giaddr":
+    return addr(m.GIAddr)
+  case "yiaddr":
+    return addr(m.YIAddr)
+  case "siaddr":
+    return addr(m.SIAddr)
+  case "msgtype":
+    return uint32Val(uint32(m.MessageType()))
+  case "transid":
+    return uint32Val(m.XID)
+  }
+  return []byte{}
+}
+
+// vendorEntry is one enterprise's part of a vendor or vendor class
+// option.
+type vendorEntry struct {
+  enterprise uint32
+  // data are the vendor class data tuples.
+  data [][]byte
+  // options are the vendor-specific options.
+  options []dhcp.Option
+}
+
+// vendor decodes the vendor class (class) or vendor-specific
+// information options of the packet.
+func (p *Packet) vendor(class bool) []vendorEntry {
+  var out []vendorEntry
+  if p.Family == 6 {
+    code := optVendorOpts6
+    if class {
+      code = optVendorClass6
+    }
+    for _, o := range p.Options() {
+      if o.Code != code || len(o.Data) < 4 {
+        continue
+      }
+      v := vendorEntry{enterprise: binary.BigEndian.Uint32(o.Data)}
+      rest := o.Data[4:]
+      if class {
+        for len(rest) >= 2 {
+          n := int(binary.BigEndian.Uint16(rest))
+          if 2+n > len(rest) {
+            break
+          }
+          v.data = append(v.data, rest[2:2+n])
+          rest = rest[2+n:]
+        }
+      } else {
+        v.options, _ = dhcp.ParseOptions6(rest)
+      }
+      out = append(out, v)
+    }
+    return out
+  }
+
+  code := optVIVSO4
+  if class {
+    code = optVIVCO4
+  }
+  b, ok := p.option(code)
+  for ok && len(b) >= 5 {
+    n := int(b[4])
+    if 5+n > len(b) {
+      break
+    }
+    v := vendorEntry{enterprise: binary.BigEndian.Uint32(b)}
+    body := b[5 : 5+n]
+    if class {
+      for len(body) >= 1 && 1+int(body[0]) <= len(body) {
+        v.data = append(v.data, body[1:1+int(body[0])])
+        body = body[1+int(body[0]):]
+      }
+    } else {
+      v.options, _ = dhcp.ParseOptions4(body)
+    }
+    out = append(out, v)
+    b = b[5+n:]
+  }
+  return out
+}
+
+// InterfaceID returns the interface-id relay option of a DHCPv6 packet.
+func (p *Packet) InterfaceID() ([]byte, bool) {
+  r := p.Relay6()
+  if r == nil {
+    return nil, false
+  }
+  return findOption(r.Options, optInterfaceID6)
+}
+
+// VendorClassName is the data of the vendor class option, which Kea
+// turns into the built-in VENDOR_CLASS_ class.
+func (p *Packet) VendorClassName() string {
+  if p.Family == 6 {
+    if v := p.vendor(true); len(v) > 0 && len(v[0].data) > 0 {
+      return string(v[0].data[0])
+    }
+    return ""
+  }
+  b, _ := p.option(optVendorClass4)
+  return string(b)
+}
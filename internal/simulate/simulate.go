@@ -0,0 +1,881 @@
+package simulate
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// Option codes subnet selection looks at.
+const (
+  optSubnetSelection4 = 118
+  agentLinkSelection4 = 5
+  agentCircuitID4     = 1
+)
+
+// Stages at which a class is assigned.
+const (
+  StageBuiltIn     = "built-in"
+  StageEarly       = "before subnet selection"
+  StageReservation = "from the reservation"
+  StageLate        = "after host lookup"
+  StageAdditional  = "additional"
+  StageSkipped     = "not evaluated"
+)
+
+// Config is the part of a Dhcp4 or Dhcp6 configuration the simulation
+// uses.
+type Config struct {
+  Family       int
+  Subnets      []kea.Subnet
+  Networks     map[string]kea.SharedNetwork
+  Classes      []kea.ClientClass
+  Options      []kea.OptionData
+  Reservations []kea.Reservation
+  OptionDefs   []kea.ConfigOptionDef
+  // Identifiers is host-reservation-identifiers, in lookup order.
+  Identifiers []string
+  // ReservationsGlobal and ReservationsInSubnet are the global
+  // settings; subnets don't override them here.
+  ReservationsGlobal   bool
+  ReservationsInSubnet bool
+}
+
+// ConfigFromKea decodes the config-get element of service.
+func ConfigFromKea(service string, cfg map[string]json.RawMessage) (*Config, error) {
+  c := &Config{Family: 4, Networks: map[string]kea.SharedNetwork{}, ReservationsInSubnet: true}
+  if service == kea.ServiceDHCP6 {
+    c.Family = 6
+  }
+  var err error
+  if c.Subnets, err = kea.SubnetsFromConfig(cfg, service); err != nil {
+    return nil, err
+  }
+  nets, err := kea.SharedNetworksFromConfig(cfg)
+  if err != nil {
+    return nil, err
+  }
+  for _, n := range nets {
+    c.Networks[n.Name] = n
+  }
+  if c.Classes, err = kea.ClientClassesFromConfig(cfg); err != nil {
+    return nil, err
+  }
+  if c.OptionDefs, err = kea.OptionDefsFromConfig(cfg); err != nil {
+    return nil, err
+  }
+
+  decode := func(key string, v any) error {
+    if raw, ok := cfg[key]; ok {
+      if err := json.Unmarshal(raw, v); err != nil {
+        return fmt.Errorf("decode %s: %w", key, err)
+      }
+    }
+    return nil
+  }
+  for key, v := range map[string]any{
+    "option-data":                  &c.Options,
+    "reservations":                 &c.Reservations,
+    "host-reservation-identifiers": &c.Identifiers,
+    "reservations-global":          &c.ReservationsGlobal,
+    "reservations-in-subnet":       &c.ReservationsInSubnet,
+  } {
+    if err := decode(key, v); err != nil {
+      return nil, err
+    }
+  }
+  if len(c.Identifiers) == 0 {
+    c.Identifiers = []string{kea.IdentifierHWAddress, kea.IdentifierDUID}
+    if c.Family == 4 {
+      c.Identifiers = append(c.Identifiers, kea.IdentifierCircuitID, kea.IdentifierClientID)
+    }
+  }
+  return c, nil
+}
+
+// OptionCode resolves an option name of the default space, standard or
+// defined in option-def.
+func (c *Config) OptionCode(name string) (int, bool) {
+  if d, ok := kea.StdOptionByName(c.Family, name); ok {
+    return d.Code, true
+  }
+  for _, d := range c.OptionDefs {
+    if d.Name == name && (d.Space == "" || d.Space == kea.DefaultSpace(c.Family)) {
+      return d.Code, true
+    }
+  }
+  return 0, false
+}
+
+// Lookups reach outside the configuration. Either may be nil.
+type Lookups struct {
+  // InterfacePrefixes returns the addresses of a local interface, used
+  // to select the subnet of a directly attached client.
+  InterfacePrefixes func(name string) []netip.Prefix
+  // Reservation looks a host up in the host backends (subnet 0 is
+  // global). It returns nil when there is none.
+  Reservation func(subnetID int64, idType, id string) (*kea.Reservation, error)
+}
+
+// ClassResult is the outcome of one class.
+type ClassResult struct {
+  Name    string
+  Test    string
+  Stage   string
+  Matched bool
+  Note    string
+}
+
+// Candidate is a subnet the client could be given a lease in.
+type Candidate struct {
+  Subnet  kea.Subnet
+  Allowed bool
+  Note    string
+}
+
+// PoolResult says whether the client may use a pool.
+type PoolResult struct {
+  Pool     string
+  Prefix   bool
+  Class    string
+  Eligible bool
+}
+
+// ReservationMatch is the reservation found for the client.
+type ReservationMatch struct {
+  Identifier  string
+  Value       string
+  Where       string
+  Reservation kea.Reservation
+}
+
+// OptionResult is an option in the merged set. Sources lists where it
+// is configured, the winning one first.
+type OptionResult struct {
+  Code    int
+  Name    string
+  Space   string
+  Data    string
+  Sources []string
+  // Sent is "yes", "if requested" or "never".
+  Sent string
+}
+
+// Result explains how the server would treat the packet.
+type Result struct {
+  Packet  *Packet
+  Dropped bool
+  // Selection explains the subnet selection step by step.
+  Selection   []string
+  Subnet      *kea.Subnet
+  Network     string
+  Candidates  []Candidate
+  Pools       []PoolResult
+  Reservation *ReservationMatch
+  Address     string
+  Classes     []ClassResult
+  Options     []OptionResult
+  Notes       []string
+}
+
+// simulation is the state of one run.
+type simulation struct {
+  cfg     *Config
+  look    Lookups
+  pkt     *Packet
+  res     *Result
+  member  map[string]bool
+  order   []string
+  exprs   map[string]*Expr
+  members map[string][]string
+}
+
+func (s *simulation) addClass(name, test, stage string, matched bool, note string) {
+  s.res.Classes = append(s.res.Classes, ClassResult{Name: name, Test: test, Stage: stage, Matched: matched, Note: note})
+  if matched && !s.member[name] {
+    s.member[name] = true
+    s.order = append(s.order, name)
+  }
+}
+
+func (s *simulation) env() *Env {
+  return &Env{Packet: s.pkt, Classes: s.member}
+}
+
+// Run simulates the server's handling of the packet described by in.
+func Run(cfg *Config, in Input, look Lookups) *Result {
+  s := &simulation{
+    cfg:     cfg,
+    look:    look,
+    pkt:     BuildPacket(in),
+    member:  map[string]bool{},
+    exprs:   map[string]*Expr{},
+    members: map[string][]string{},
+  }
+  s.res = &Result{Packet: s.pkt}
+
+  s.addClass("ALL", "", StageBuiltIn, true, "")
+  if v := s.pkt.VendorClassName(); v != "" {
+    s.addClass("VENDOR_CLASS_"+v, "", StageBuiltIn, true, "from the vendor class option")
+  }
+
+  late := s.parseClasses()
+  for _, c := range cfg.Classes {
+    if c.Additional() || late[c.Name] {
+      continue
+    }
+    s.evalClass(c, StageEarly)
+  }
+  if s.member["DROP"] {
+    s.res.Dropped = true
+    s.res.Notes = append(s.res.Notes, "The packet is in the DROP class; the server discards it.")
+    return s.res
+  }
+
+  subnet := s.selectSubnet()
+  s.lookupHost(subnet)
+
+  for _, c := range cfg.Classes {
+    if !c.Additional() && late[c.Name] {
+      s.evalClass(c, StageLate)
+    }
+  }
+  if s.res.Subnet != nil {
+    s.pools()
+    s.additional()
+  }
+  s.skipped()
+  s.mergeOptions()
+  return s.res
+}
+
+// skipped lists the classes that were never evaluated, and why.
+func (s *simulation) skipped() {
+  seen := map[string]bool{}
+  for _, c := range s.res.Classes {
+    seen[c.Name] = true
+  }
+  for _, c := range s.cfg.Classes {
+    if seen[c.Name] {
+      continue
+    }
+    note := "no test; assigned only by reservations or hooks"
+    if c.Additional() {
+      note = "only evaluated when a network, subnet or pool lists it as an additional class"
+    }
+    s.addClass(c.Name, c.Test, StageSkipped, false, note)
+  }
+}
+
+// parseClasses parses every test and returns the classes that depend,
+// directly or through member(), on KNOWN or UNKNOWN.
+func (s *simulation) parseClasses() map[string]bool {
+  for _, c := range s.cfg.Classes {
+    src := c.Test
+    if src == "" {
+      src = c.TemplateTest
+    }
+    if src == "" {
+      continue
+    }
+    e, members, err := ParseExpr(src, s.cfg.OptionCode)
+    if err != nil {
+      s.res.Notes = append(s.res.Notes, fmt.Sprintf("Class %s: cannot parse %q: %v", c.Name, src, err))
+      continue
+    }
+    s.exprs[c.Name], s.members[c.Name] = e, members
+  }
+  late := map[string]bool{"KNOWN": true, "UNKNOWN": true}
+  for _, c := range s.cfg.Classes {
+    for _, m := range s.members[c.Name] {
+      if late[m] {
+        late[c.Name] = true
+      }
+    }
+  }
+  return late
+}
+
+// evalClass evaluates a class test, or a template class's expression.
+func (s *simulation) evalClass(c kea.ClientClass, stage string) {
+  e := s.exprs[c.Name]
+  switch {
+  case c.Test == "" && c.TemplateTest == "":
+    return
+  case e == nil:
+    s.addClass(c.Name, c.Test+c.TemplateTest, stage, false, "expression does not parse")
+  case c.TemplateTest != "":
+    v, err := e.Value(s.env())
+    if err != nil {
+      s.addClass(c.Name, c.TemplateTest, stage, false, err.Error())
+      return
+    }
+    if len(v) == 0 {
+      s.addClass(c.Name, c.TemplateTest, stage, false, "template expression is empty")
+      return
+    }
+    s.addClass(c.Name, c.TemplateTest, stage, true, "template class")
+    s.addClass("SPAWN_"+c.Name+"_"+string(v), "", stage, true, "spawned by "+c.Name)
+  default:
+    ok, err := e.Eval(s.env())
+    note := ""
+    if err != nil {
+      note = err.Error()
+    }
+    s.addClass(c.Name, c.Test, stage, ok, note)
+  }
+}
+
+// allowed reports whether the client's classes let it use a subnet,
+// checking the enclosing shared network too.
+func (s *simulation) allowed(sub kea.Subnet) bool {
+  if n, ok := s.cfg.Networks[sub.SharedNetwork]; ok && !n.Allows(n.ClientClass, s.member) {
+    return false
+  }
+  return sub.Allows(sub.ClientClass, s.member)
+}
+
+func (s *simulation) explain(format string, a ...any) {
+  s.res.Selection = append(s.res.Selection, fmt.Sprintf(format, a...))
+}
+
+func subnetName(sub kea.Subnet) string {
+  return fmt.Sprintf("subnet %d (%s)", sub.ID, sub.Subnet)
+}
+
+// byAddress picks the first subnet holding addr that the client may
+// use; with relay set, relay ip-addresses are checked first.
+func (s *simulation) byAddress(addr netip.Addr, relay bool, what string) *kea.Subnet {
+  if relay {
+    for i, sub := range s.cfg.Subnets {
+      n, inNet := s.cfg.Networks[sub.SharedNetwork]
+      if !(inNet && n.Relay.Has(addr)) && !sub.Relay.Has(addr) {
+        continue
+      }
+      if !s.allowed(sub) {
+        s.explain("%s lists relay %s but the client's classes don't allow it", subnetName(sub), addr)
+        continue
+      }
+      s.explain("%s is %s, a relay address of %s", addr, what, subnetName(sub))
+      return &s.cfg.Subnets[i]
+    }
+  }
+  for i, sub := range s.cfg.Subnets {
+    if !sub.Contains(addr) {
+      continue
+    }
+    if !s.allowed(sub) {
+      s.explain("%s holds %s but the client's classes don't allow it", subnetName(sub), addr)
+      continue
+    }
+    s.explain("%s holds the %s %s", subnetName(sub), what, addr)
+    return &s.cfg.Subnets[i]
+  }
+  s.explain("no subnet the client may use holds the %s %s", what, addr)
+  return nil
+}
+
+// byInterface picks the first subnet configured on the interface.
+func (s *simulation) byInterface(name string) *kea.Subnet {
+  for i, sub := range s.cfg.Subnets {
+    iface := sub.Interface
+    if n, ok := s.cfg.Networks[sub.SharedNetwork]; ok && iface == "" {
+      iface = n.Interface
+    }
+    if iface != name || name == "" {
+      continue
+    }
+    if !s.allowed(sub) {
+      s.explain("%s is on interface %s but the client's classes don't allow it", subnetName(sub), name)
+      continue
+    }
+    s.explain("%s is configured on interface %s", subnetName(sub), name)
+    return &s.cfg.Subnets[i]
+  }
+  return nil
+}
+
+func (s *simulation) selectSubnet() *kea.Subnet {
+  var sub *kea.Subnet
+  if s.cfg.Family == 6 {
+    sub = s.select6()
+  } else {
+    sub = s.select4()
+  }
+  if sub == nil {
+    s.res.Notes = append(s.res.Notes, "No subnet is selected; the server would not answer.")
+    return nil
+  }
+  s.setSubnet(sub)
+  return sub
+}
+
+// setSubnet makes sub the selected subnet and lists the subnets of its
+// shared network the allocation engine may move the client to.
+func (s *simulation) setSubnet(sub *kea.Subnet) {
+  s.res.Subnet, s.res.Network, s.res.Candidates = sub, sub.SharedNetwork, nil
+  for _, other := range s.cfg.Subnets {
+    if other.ID != sub.ID && (sub.SharedNetwork == "" || other.SharedNetwork != sub.SharedNetwork) {
+      continue
+    }
+    c := Candidate{Subnet: other, Allowed: s.allowed(other)}
+    switch {
+    case other.ID == sub.ID:
+      c.Note = "selected"
+    case c.Allowed:
+      c.Note = "used when the selected subnet has no free address"
+    default:
+      c.Note = "not allowed for the client's classes"
+    }
+    s.res.Candidates = append(s.res.Candidates, c)
+  }
+}
+
+func (s *simulation) select4() *kea.Subnet {
+  m := s.pkt.V4
+  var selectAddr netip.Addr
+  what := ""
+  if b, ok := s.pkt.option(dhcp.OptRelayAgentInfo4); ok {
+    if v, ok := findOption(s.pkt.subOptions(b), agentLinkSelection4); ok && len(v) == 4 {
+      selectAddr, what = netip.AddrFrom4([4]byte(v)), "link-selection sub-option address"
+    }
+  }
+  if !selectAddr.IsValid() {
+    if v, ok := s.pkt.option(optSubnetSelection4); ok && len(v) == 4 {
+      selectAddr, what = netip.AddrFrom4([4]byte(v)), "subnet-selection option address"
+    }
+  }
+  if selectAddr.IsValid() {
+    return s.byAddress(selectAddr, false, what)
+  }
+  if m.GIAddr.IsValid() && !m.GIAddr.IsUnspecified() {
+    return s.byAddress(m.GIAddr, true, "giaddr")
+  }
+  if m.CIAddr.IsValid() && !m.CIAddr.IsUnspecified() {
+    return s.byAddress(m.CIAddr, false, "ciaddr")
+  }
+  s.explain("the client is on a directly attached link")
+  if sub := s.byInterface(s.pkt.Iface); sub != nil {
+    return sub
+  }
+  return s.byInterfaceAddress()
+}
+
+// byInterfaceAddress selects by the addresses of the receiving
+// interface, as Kea does for local clients.
+func (s *simulation) byInterfaceAddress() *kea.Subnet {
+  if s.pkt.Iface == "" {
+    s.explain("no receiving interface given")
+    return nil
+  }
+  if s.look.InterfacePrefixes == nil {
+    s.explain("the addresses of %s are unknown", s.pkt.Iface)
+    return nil
+  }
+  prefixes := s.look.InterfacePrefixes(s.pkt.Iface)
+  for _, p := range prefixes {
+    if p.Addr().Is4() != (s.cfg.Family == 4) || p.Addr().IsLinkLocalUnicast() {
+      continue
+    }
+    if sub := s.byAddress(p.Addr(), false, "address of "+s.pkt.Iface); sub != nil {
+      return sub
+    }
+  }
+  if len(prefixes) == 0 {
+    s.explain("interface %s has no addresses", s.pkt.Iface)
+  }
+  return nil
+}
+
+func (s *simulation) select6() *kea.Subnet {
+  relay := s.pkt.Relay6()
+  if relay == nil || relay.LinkAddr.IsUnspecified() {
+    s.explain("the client is on a directly attached link")
+    if sub := s.byInterface(s.pkt.Iface); sub != nil {
+      return sub
+    }
+    if s.pkt.Src.IsValid() && !s.pkt.Src.IsLinkLocalUnicast() {
+      return s.byAddress(s.pkt.Src, false, "source address")
+    }
+    return s.byInterfaceAddress()
+  }
+  if id, ok := s.pkt.InterfaceID(); ok {
+    for i, sub := range s.cfg.Subnets {
+      ifid := sub.InterfaceID
+      if n, ok := s.cfg.Networks[sub.SharedNetwork]; ok && ifid == "" {
+        ifid = n.InterfaceID
+      }
+      if ifid == "" || ifid != string(id) {
+        continue
+      }
+      if !s.allowed(sub) {
+        s.explain("%s has interface-id %q but the client's classes don't allow it", subnetName(sub), ifid)
+        continue
+      }
+      s.explain("%s has the relay's interface-id %q", subnetName(sub), ifid)
+      return &s.cfg.Subnets[i]
+    }
+    s.explain("no subnet has interface-id %q; falling back to the link-address", id)
+  }
+  return s.byAddress(relay.LinkAddr, true, "relay link-address")
+}
+
+// identifier returns the value of a host identifier in the packet, as
+// colon separated hex.
+func (s *simulation) identifier(typ string) []byte {
+  switch typ {
+  case kea.IdentifierHWAddress:
+    if s.pkt.Family == 4 {
+      return s.pkt.V4.CHAddr
+    }
+    if duid, ok := s.pkt.option(dhcp.OptClientID6); ok && len(duid) > 4 {
+      switch duid[1] {
+      case 1:
+        if len(duid) > 8 {
+          return duid[8:]
+        }
+      case 3:
+        return duid[4:]
+      }
+    }
+  case kea.IdentifierDUID:
+    if s.pkt.Family == 6 {
+      b, _ := s.pkt.option(dhcp.OptClientID6)
+      return b
+    }
+    // RFC 4361 client identifiers carry a DUID after the IAID.
+    if b, ok := s.pkt.option(dhcp.OptClientID4); ok && len(b) > 5 && b[0] == 0xff {
+      return b[5:]
+    }
+  case kea.IdentifierClientID:
+    b, _ := s.pkt.option(dhcp.OptClientID4)
+    return b
+  case kea.IdentifierCircuitID:
+    if b, ok := s.pkt.option(dhcp.OptRelayAgentInfo4); ok {
+      v, _ := findOption(s.pkt.subOptions(b), agentCircuitID4)
+      return v
+    }
+  }
+  return nil
+}
+
+// identifierBytes decodes a reservation identifier: hex with optional
+// separators, or quoted text.
+func identifierBytes(v string) []byte {
+  if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+    return []byte(v[1 : len(v)-1])
+  }
+  b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "", " ", "", ".", "").Replace(strings.TrimPrefix(v, "0x")))
+  if err != nil {
+    return nil
+  }
+  return b
+}
+
+func reservationMatches(r kea.Reservation, typ string, id []byte) bool {
+  var v string
+  switch typ {
+  case kea.IdentifierHWAddress:
+    v = r.HWAddress
+  case kea.IdentifierDUID:
+    v = r.DUID
+  case kea.IdentifierCircuitID:
+    v = r.CircuitID
+  case kea.IdentifierClientID:
+    v = r.ClientID
+  default:
+    return false
+  }
+  b := identifierBytes(v)
+  return v != "" && b != nil && string(b) == string(id)
+}
+
+// lookupHost finds the client's reservation in the selected subnet, the
+// other subnets of its shared network and the global reservations, and
+// assigns KNOWN or UNKNOWN and the reservation's classes.
+func (s *simulation) lookupHost(sub *kea.Subnet) {
+  var found *ReservationMatch
+  var foundIn *kea.Subnet
+
+  subnets := []*kea.Subnet{}
+  if sub != nil {
+    subnets = append(subnets, sub)
+    for i, other := range s.cfg.Subnets {
+      if other.ID != sub.ID && sub.SharedNetwork != "" && other.SharedNetwork == sub.SharedNetwork && s.allowed(other) {
+        subnets = append(subnets, &s.cfg.Subnets[i])
+      }
+    }
+  }
+
+  for _, typ := range s.cfg.Identifiers {
+    if typ == kea.IdentifierFlexID {
+      s.res.Notes = append(s.res.Notes, "flex-id reservations depend on the flex_id hook's expression and are not checked.")
+      continue
+    }
+    id := s.identifier(typ)
+    if len(id) == 0 {
+      continue
+    }
+    value := dhcp.HexString(id)
+
+    if s.cfg.ReservationsInSubnet {
+      for _, cand := range subnets {
+        for _, r := range cand.Reservations {
+          if reservationMatches(r, typ, id) {
+            found, foundIn = &ReservationMatch{typ, value, subnetName(*cand) + " in the configuration", r}, cand
+            break
+          }
+        }
+        if found == nil && s.look.Reservation != nil {
+          r, err := s.look.Reservation(cand.ID, typ, value)
+          if err != nil {
+            s.res.Notes = append(s.res.Notes, fmt.Sprintf("Host backend lookup in %s: %v", subnetName(*cand), err))
+          } else if r != nil {
+            found, foundIn = &ReservationMatch{typ, value, subnetName(*cand) + " in the host backend", *r}, cand
+          }
+        }
+        if found != nil {
+          break
+        }
+      }
+    }
+    if found == nil && s.cfg.ReservationsGlobal {
+      for _, r := range s.cfg.Reservations {
+        if reservationMatches(r, typ, id) {
+          found = &ReservationMatch{typ, value, "global reservations in the configuration", r}
+          break
+        }
+      }
+      if found == nil && s.look.Reservation != nil {
+        if r, err := s.look.Reservation(0, typ, value); err == nil && r != nil {
+          found = &ReservationMatch{typ, value, "global reservations in the host backend", *r}
+        }
+      }
+    }
+    if found != nil {
+      break
+    }
+  }
+
+  if found == nil {
+    s.addClass("UNKNOWN", "", StageBuiltIn, true, "no reservation")
+    return
+  }
+  s.res.Reservation = found
+  s.addClass("KNOWN", "", StageBuiltIn, true, "reservation by "+found.Identifier)
+  for _, c := range found.Reservation.ClientClasses {
+    s.addClass(c, "", StageReservation, true, "listed in the reservation")
+  }
+  if foundIn != nil && sub != nil && foundIn.ID != sub.ID {
+    s.explain("the client has a reservation in %s of the same shared network, so the server uses that subnet", subnetName(*foundIn))
+    s.setSubnet(foundIn)
+  }
+}
+
+// pools lists the pools of the selected subnet the client may use.
+func (s *simulation) pools() {
+  sub := s.res.Subnet
+  reserved := ""
+  if r := s.res.Reservation; r != nil {
+    for _, a := range r.Reservation.Addresses() {
+      if addr, err := netip.ParseAddr(a); err == nil && sub.Contains(addr) {
+        reserved = a
+      }
+    }
+  }
+  for _, p := range sub.Pools {
+    s.res.Pools = append(s.res.Pools, PoolResult{Pool: p.Pool, Class: strings.Join(guardNames(p.ClientClass, p.ClassGuard), ", "), Eligible: p.Allows(p.ClientClass, s.member)})
+  }
+  for _, p := range sub.PDPools {
+    name := fmt.Sprintf("%s/%d delegating /%d", p.Prefix, p.PrefixLen, p.DelegatedLen)
+    s.res.Pools = append(s.res.Pools, PoolResult{Pool: name, Prefix: true, Class: strings.Join(guardNames(p.ClientClass, p.ClassGuard), ", "), Eligible: p.Allows(p.ClientClass, s.member)})
+  }
+
+  switch {
+  case reserved != "":
+    s.res.Address = reserved + " (reserved)"
+  default:
+    for _, p := range s.res.Pools {
+      if p.Eligible && !p.Prefix {
+        s.res.Address = "a free address from " + p.Pool
+        return
+      }
+    }
+    if len(sub.Pools) == 0 {
+      return
+    }
+    for _, c := range s.res.Candidates {
+      if !c.Allowed || c.Subnet.ID == sub.ID {
+        continue
+      }
+      for _, p := range c.Subnet.Pools {
+        if !p.Allows(p.ClientClass, s.member) {
+          continue
+        }
+        s.explain("none of the pools of %s is open to the client's classes, so the server moves on to %s of the shared network", subnetName(*sub), subnetName(c.Subnet))
+        i := slices.IndexFunc(s.cfg.Subnets, func(other kea.Subnet) bool { return other.ID == c.Subnet.ID })
+        s.setSubnet(&s.cfg.Subnets[i])
+        s.res.Pools = nil
+        s.pools()
+        return
+      }
+    }
+    s.res.Notes = append(s.res.Notes, "None of the pools of "+subnetName(*sub)+" is open to the client's classes.")
+  }
+}
+
+func guardNames(class string, g kea.ClassGuard) []string {
+  if class != "" {
+    return append([]string{class}, g.ClientClasses...)
+  }
+  return g.ClientClasses
+}
+
+// pool returns the address pool the client's address comes from.
+func (s *simulation) pool() *kea.Pool {
+  sub := s.res.Subnet
+  if r := s.res.Reservation; r != nil {
+    for _, a := range r.Reservation.Addresses() {
+      addr, err := netip.ParseAddr(a)
+      if err != nil {
+        continue
+      }
+      for i := range sub.Pools {
+        if sub.Pools[i].Contains(addr) {
+          return &sub.Pools[i]
+        }
+      }
+    }
+  }
+  for i, p := range sub.Pools {
+    if p.Allows(p.ClientClass, s.member) {
+      return &sub.Pools[i]
+    }
+  }
+  return nil
+}
+
+// additional evaluates the classes the shared network, subnet and pool
+// ask for, in that order.
+func (s *simulation) additional() {
+  var names []string
+  if n, ok := s.cfg.Networks[s.res.Network]; ok {
+    names = append(names, n.Additional()...)
+  }
+  names = append(names, s.res.Subnet.Additional()...)
+  if p := s.pool(); p != nil {
+    names = append(names, p.Additional()...)
+  }
+  for _, name := range names {
+    if s.member[name] {
+      continue
+    }
+    i := slices.IndexFunc(s.cfg.Classes, func(c kea.ClientClass) bool { return c.Name == name })
+    if i < 0 {
+      s.res.Notes = append(s.res.Notes, "Additional class "+name+" is not defined.")
+      continue
+    }
+    c := s.cfg.Classes[i]
+    if c.Test == "" {
+      s.addClass(c.Name, "", StageAdditional, true, "no test, so always assigned")
+      continue
+    }
+    s.evalClass(c, StageAdditional)
+  }
+}
+
+// optionSource is one option-data list and where it is configured.
+type optionSource struct {
+  name string
+  opts []kea.OptionData
+}
+
+// mergeOptions merges the option-data lists in Kea's order of
+// precedence: reservation, pool, subnet, shared network, classes in the
+// order they were assigned, global.
+func (s *simulation) mergeOptions() {
+  var sources []optionSource
+  if r := s.res.Reservation; r != nil {
+    sources = append(sources, optionSource{"reservation (" + r.Identifier + ")", r.Reservation.OptionData})
+  }
+  if sub := s.res.Subnet; sub != nil {
+    if p := s.pool(); p != nil {
+      sources = append(sources, optionSource{"pool " + p.Pool, p.OptionData})
+    }
+    sources = append(sources, optionSource{subnetName(*sub), sub.OptionData})
+    if n, ok := s.cfg.Networks[sub.SharedNetwork]; ok {
+      sources = append(sources, optionSource{"shared network " + n.Name, n.OptionData})
+    }
+  }
+  for _, name := range s.order {
+    for _, c := range s.cfg.Classes {
+      if c.Name == name && len(c.OptionData) > 0 {
+        sources = append(sources, optionSource{"class " + name, c.OptionData})
+      }
+    }
+  }
+  sources = append(sources, optionSource{"global", s.cfg.Options})
+
+  requested := map[int]bool{}
+  if s.cfg.Family == 6 {
+    if b, ok := s.pkt.option(6); ok {
+      for i := 0; i+1 < len(b); i += 2 {
+        requested[int(b[i])<<8|int(b[i+1])] = true
+      }
+    }
+  } else {
+    if b, ok := s.pkt.option(55); ok {
+      for _, c := range b {
+        requested[int(c)] = true
+      }
+    }
+    // Kea sends these whenever they are configured.
+    for _, c := range []int{1, 3, 6, 15} {
+      requested[c] = true
+    }
+  }
+
+  index := map[string]int{}
+  for _, src := range sources {
+    for _, o := range src.opts {
+      if !kea.ResolveOption(s.cfg.Family, &o) {
+        if code, ok := s.cfg.OptionCode(o.Name); ok {
+          o.Code = code
+        }
+      }
+      space := o.Space
+      if space == "" {
+        space = kea.DefaultSpace(s.cfg.Family)
+      }
+      key := space + "/" + strconv.Itoa(o.Code) + "/" + o.Name
+      if o.Code != 0 {
+        key = space + "/" + strconv.Itoa(o.Code)
+      }
+      if i, ok := index[key]; ok {
+        s.res.Options[i].Sources = append(s.res.Options[i].Sources, src.name)
+        continue
+      }
+      r := OptionResult{Code: o.Code, Name: o.Name, Space: space, Data: o.Data, Sources: []string{src.name}}
+      if r.Name == "" {
+        r.Name = kea.OptionName(s.cfg.Family, o.Code)
+      }
+      switch {
+      case o.NeverSend:
+        r.Sent = "never"
+      case o.AlwaysSend || (space == kea.DefaultSpace(s.cfg.Family) && requested[o.Code]):
+        r.Sent = "yes"
+      default:
+        r.Sent = "if requested"
+      }
+      index[key] = len(s.res.Options)
+      s.res.Options = append(s.res.Options, r)
+    }
+  }
+}
@@ -16,6 +16,13 @@ func ParseCLI(env *Env) {
     "HTTP listen port",
   )
 
+  flag.StringVar(
+    &env.LISTEN,
+    "listen",
+    env.LISTEN,
+    "Comma separated listen addresses, e.g. 0.0.0.0:8443,[::]:8443",
+  )
+
   flag.StringVar(
     &env.STATIC_DIR,
     "static-dir",
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Env struct {
@@ -20,6 +21,60 @@ type Env struct {
 	KEA_DB_USER      string
 	KEA_DB_PASSWORD  string
 	KEA_DB_NAME      string
+
+	KEA_UNITS        string
+	KEA_UNIT_MANAGER string
+	HOST_ROOT        string
+	KEA_CONFIG_DIR   string
+	KEA_BACKUP_DIR   string
+
+	KEA_KNOWN_SERVERS string
+	KEA_STATS_POLL    int
+
+	DATA_DIR             string
+	SESSION_IDLE_TIMEOUT time.Duration
+	SESSION_MAX_AGE      time.Duration
+
+	OIDC_CONFIG         string
+	OIDC_ISSUER         string
+	OIDC_CLIENT_ID      string
+	OIDC_CLIENT_SECRET  string
+	OIDC_REDIRECT_URL   string
+	OIDC_SCOPES         string
+	OIDC_USERNAME_CLAIM string
+	OIDC_ROLE_CLAIM     string
+	OIDC_ROLE_MAP       string
+	OIDC_DEFAULT_ROLE   string
+
+	LDAP_URL                  string
+	LDAP_STARTTLS             bool
+	LDAP_CA_FILE              string
+	LDAP_INSECURE_SKIP_VERIFY bool
+	LDAP_BIND_DN              string
+	LDAP_BIND_PASSWORD        string
+	LDAP_BASE_DN              string
+	LDAP_USER_FILTER          string
+	LDAP_GROUP_BASE_DN        string
+	LDAP_GROUP_FILTER         string
+	LDAP_NESTED_GROUPS        bool
+	LDAP_GROUP_NAMES          bool
+	LDAP_ROLE_MAP             string
+	LDAP_DEFAULT_ROLE         string
+	LDAP_TIMEOUT              time.Duration
+	LDAP_POOL_SIZE            int
+
+	AUDIT_LOG             string
+	AUDIT_SYSLOG          string
+	AUDIT_SYSLOG_FACILITY string
+	AUDIT_JSON_FILE       string
+
+	LISTEN               string
+	TLS_CERT_FILE        string
+	TLS_KEY_FILE         string
+	TLS_SELF_SIGNED      bool
+	TLS_CLIENT_CA        string
+	TLS_CLIENT_AUTH      string
+	HTTP_REDIRECT_LISTEN string
 }
 
 var envOnce sync.Once
@@ -69,6 +124,26 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getDuration parses a duration environment variable such as "30m"
+func getDuration(key, defaultValue string) time.Duration {
+	value := getEnv(key, defaultValue)
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		Fatal("Invalid %s: %s. Must be a duration such as 30m or 12h.", key, value)
+	}
+	return d
+}
+
+// getBool parses a boolean environment variable such as "true" or "0"
+func getBool(key, defaultValue string) bool {
+	value := getEnv(key, defaultValue)
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		Fatal("Invalid %s: %s. Must be true or false.", key, value)
+	}
+	return b
+}
+
 // LoadEnv initializes the environment variables
 func LoadEnv() {
 	envOnce.Do(func() {
@@ -97,6 +172,70 @@ func LoadEnv() {
 		env.KEA_DB_USER = os.Getenv("KEA_DB_USER")
 		env.KEA_DB_PASSWORD = os.Getenv("KEA_DB_PASSWORD")
 		env.KEA_DB_NAME = os.Getenv("KEA_DB_NAME")
+
+		env.KEA_UNITS = os.Getenv("KEA_UNITS")
+		env.KEA_UNIT_MANAGER = getEnv("KEA_UNIT_MANAGER", "systemd")
+		env.HOST_ROOT = getEnv("HOST_ROOT", "/")
+		env.KEA_CONFIG_DIR = getEnv("KEA_CONFIG_DIR", "/etc/kea")
+		env.KEA_BACKUP_DIR = os.Getenv("KEA_BACKUP_DIR")
+
+		env.KEA_KNOWN_SERVERS = os.Getenv("KEA_KNOWN_SERVERS")
+		statsPollStr := getEnv("KEA_STATS_POLL", "60")
+		statsPoll, err := strconv.Atoi(statsPollStr)
+		if err != nil || statsPoll < 0 {
+			Fatal("Invalid KEA_STATS_POLL: %s. Must be a number of seconds, 0 to disable.", statsPollStr)
+		}
+		env.KEA_STATS_POLL = statsPoll
+
+		env.DATA_DIR = getEnv("DATA_DIR", "data")
+		env.SESSION_IDLE_TIMEOUT = getDuration("SESSION_IDLE_TIMEOUT", "30m")
+		env.SESSION_MAX_AGE = getDuration("SESSION_MAX_AGE", "12h")
+
+		env.OIDC_CONFIG = os.Getenv("OIDC_CONFIG")
+		env.OIDC_ISSUER = os.Getenv("OIDC_ISSUER")
+		env.OIDC_CLIENT_ID = os.Getenv("OIDC_CLIENT_ID")
+		env.OIDC_CLIENT_SECRET = os.Getenv("OIDC_CLIENT_SECRET")
+		env.OIDC_REDIRECT_URL = os.Getenv("OIDC_REDIRECT_URL")
+		env.OIDC_SCOPES = os.Getenv("OIDC_SCOPES")
+		env.OIDC_USERNAME_CLAIM = os.Getenv("OIDC_USERNAME_CLAIM")
+		env.OIDC_ROLE_CLAIM = os.Getenv("OIDC_ROLE_CLAIM")
+		env.OIDC_ROLE_MAP = os.Getenv("OIDC_ROLE_MAP")
+		env.OIDC_DEFAULT_ROLE = os.Getenv("OIDC_DEFAULT_ROLE")
+
+		env.LDAP_URL = os.Getenv("LDAP_URL")
+		env.LDAP_STARTTLS = getBool("LDAP_STARTTLS", "false")
+		env.LDAP_CA_FILE = os.Getenv("LDAP_CA_FILE")
+		env.LDAP_INSECURE_SKIP_VERIFY = getBool("LDAP_INSECURE_SKIP_VERIFY", "false")
+		env.LDAP_BIND_DN = os.Getenv("LDAP_BIND_DN")
+		env.LDAP_BIND_PASSWORD = os.Getenv("LDAP_BIND_PASSWORD")
+		env.LDAP_BASE_DN = os.Getenv("LDAP_BASE_DN")
+		env.LDAP_USER_FILTER = getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(|(uid={username})(sAMAccountName={username})))")
+		env.LDAP_GROUP_BASE_DN = os.Getenv("LDAP_GROUP_BASE_DN")
+		env.LDAP_GROUP_FILTER = getEnv("LDAP_GROUP_FILTER", "(|(member={dn})(uniqueMember={dn}))")
+		env.LDAP_NESTED_GROUPS = getBool("LDAP_NESTED_GROUPS", "true")
+		env.LDAP_GROUP_NAMES = getBool("LDAP_GROUP_NAMES", "false")
+		env.LDAP_ROLE_MAP = os.Getenv("LDAP_ROLE_MAP")
+		env.LDAP_DEFAULT_ROLE = os.Getenv("LDAP_DEFAULT_ROLE")
+		env.LDAP_TIMEOUT = getDuration("LDAP_TIMEOUT", "5s")
+		poolSizeStr := getEnv("LDAP_POOL_SIZE", "4")
+		poolSize, err := strconv.Atoi(poolSizeStr)
+		if err != nil || poolSize < 1 {
+			Fatal("Invalid LDAP_POOL_SIZE: %s. Must be a positive integer.", poolSizeStr)
+		}
+		env.LDAP_POOL_SIZE = poolSize
+
+		env.AUDIT_LOG = os.Getenv("AUDIT_LOG")
+		env.AUDIT_SYSLOG = os.Getenv("AUDIT_SYSLOG")
+		env.AUDIT_SYSLOG_FACILITY = getEnv("AUDIT_SYSLOG_FACILITY", "local0")
+		env.AUDIT_JSON_FILE = os.Getenv("AUDIT_JSON_FILE")
+
+		env.LISTEN = os.Getenv("LISTEN")
+		env.TLS_CERT_FILE = os.Getenv("TLS_CERT_FILE")
+		env.TLS_KEY_FILE = os.Getenv("TLS_KEY_FILE")
+		env.TLS_SELF_SIGNED = getBool("TLS_SELF_SIGNED", "false")
+		env.TLS_CLIENT_CA = os.Getenv("TLS_CLIENT_CA")
+		env.TLS_CLIENT_AUTH = getEnv("TLS_CLIENT_AUTH", "require")
+		env.HTTP_REDIRECT_LISTEN = os.Getenv("HTTP_REDIRECT_LISTEN")
 	})
 }
 
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rannday/kea-web/internal/auth"
+)
+
+func TestAPILeasesCursor(t *testing.T) {
+  s := testServer(t)
+  mux := routes(s)
+  get := func(id auth.Identity, path string) (int, []string, string) {
+    t.Helper()
+    r := httptest.NewRequest(http.MethodGet, path, nil)
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+    var res struct {
+      Data []struct {
+        IPAddress string `json:"ip-address"`
+      } `json:"data"`
+      Pagination struct {
+        Next string `json:"next"`
+      } `json:"pagination"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+      t.Fatalf("%s: %v", path, err)
+    }
+    var addrs []string
+    for _, l := range res.Data {
+      addrs = append(addrs, l.IPAddress)
+    }
+    return w.Code, addrs, res.Pagination.Next
+  }
+  // walk follows the cursor from the first page of query to the last.
+  walk := func(id auth.Identity, query string) []string {
+    t.Helper()
+    var all []string
+    after := ""
+    for range 10 {
+      path := "/api/v1/leases?limit=2&" + query
+      if after != "" {
+        path += "&after=" + url.QueryEscape(after)
+      }
+      code, addrs, next := get(id, path)
+      if code != http.StatusOK {
+        t.Fatalf("%s: status %d", path, code)
+      }
+      all = append(all, addrs...)
+      if next == "" {
+        return all
+      }
+      after = next
+    }
+    t.Fatal("the cursor doesn't end")
+    return nil
+  }
+
+  admin := auth.Identity{User: "admin", Access: auth.Access{Role: auth.RoleAdmin}}
+  want := []string{"10.0.0.51", "10.0.0.53", "192.0.2.50", "192.0.2.52", "192.0.2.54"}
+  if got := walk(admin, ""); !slices.Equal(got, want) {
+    t.Errorf("pages = %v, want %v", got, want)
+  }
+  if got := walk(admin, "subnet-id=1"); !slices.Equal(got, want[2:]) {
+    t.Errorf("subnet 1 pages = %v", got)
+  }
+  scoped := auth.Identity{User: "op", Access: auth.Access{Role: auth.RoleOperator, Subnets: []int64{2}}}
+  if got := walk(scoped, ""); !slices.Equal(got, want[:2]) {
+    t.Errorf("pages of a subnet 2 user = %v", got)
+  }
+
+  _, _, next := get(admin, "/api/v1/leases?limit=2")
+  for _, path := range []string{
+    "/api/v1/leases?offset=2",
+    "/api/v1/leases?after=bm90LWEtY3Vyc29y",
+    "/api/v1/leases?after=" + url.QueryEscape(next) + "&subnet-id=1",
+    "/api/v1/leases?service=dhcp6&after=" + url.QueryEscape(next),
+  } {
+    if code, _, _ := get(admin, path); code != http.StatusBadRequest {
+      t.Errorf("%s: status %d", path, code)
+    }
+  }
+  if strings.Contains(next, "10.0.0") {
+    t.Errorf("cursor %q isn't opaque", next)
+  }
+}
@@ -0,0 +1,175 @@
+// API reference: renders the OpenAPI document named by #api-docs, with
+// a form per operation that sends the request with the session cookie.
+document.addEventListener("DOMContentLoaded", function () {
+  const root = document.getElementById("api-docs");
+  if (!root) {
+    return;
+  }
+
+  function el(tag, attrs, children) {
+    const e = document.createElement(tag);
+    for (const [k, v] of Object.entries(attrs || {})) {
+      if (k === "text") {
+        e.textContent = v;
+      } else {
+        e.setAttribute(k, v);
+      }
+    }
+    for (const c of children || []) {
+      e.appendChild(c);
+    }
+    return e;
+  }
+
+  // example builds a skeleton value of a JSON Schema.
+  function example(schema, depth) {
+    if (!schema || depth > 4) {
+      return null;
+    }
+    switch (schema.type) {
+      case "object": {
+        const out = {};
+        for (const [k, v] of Object.entries(schema.properties || {})) {
+          out[k] = example(v, depth + 1);
+        }
+        return out;
+      }
+      case "array":
+        return [];
+      case "integer":
+      case "number":
+        return 0;
+      case "boolean":
+        return false;
+      case "string":
+        return "";
+    }
+    return null;
+  }
+
+  function operation(path, method, op) {
+    const fields = [];
+    for (const p of op.parameters || []) {
+      if (!p.name || p.in === "header") {
+        continue;
+      }
+      fields.push(el("label", { text: p.name + (p.required ? " *" : "") }, [
+        el("input", { name: p.name, "data-in": p.in, title: p.description || "" }),
+      ]));
+    }
+    for (const p of op.parameters || []) {
+      if (p.$ref) {
+        const name = p.$ref.split("/").pop();
+        fields.push(el("label", { text: name }, [el("input", { name: name, "data-in": "query" })]));
+      }
+    }
+    if (method === "put" || method === "delete") {
+      fields.push(el("label", { text: "If-Match" }, [el("input", { name: "If-Match", "data-in": "header" })]));
+    }
+
+    let body = null;
+    if (op.requestBody) {
+      const schema = op.requestBody.content["application/json"].schema;
+      body = el("textarea", { rows: "8", name: "body" });
+      body.value = JSON.stringify(example(schema, 0), null, 2);
+    }
+
+    const result = el("pre", { class: "api-result" });
+    const send = el("button", { type: "submit", text: "Send" });
+    const form = el("form", {}, [el("div", { class: "toolbar" }, fields)]);
+    if (body) {
+      form.appendChild(body);
+    }
+    form.appendChild(send);
+    form.appendChild(result);
+
+    form.addEventListener("submit", async function (ev) {
+      ev.preventDefault();
+      let url = path;
+      const query = new URLSearchParams();
+      const headers = { Accept: "application/json" };
+      for (const input of form.querySelectorAll("input")) {
+        const v = input.value.trim();
+        switch (input.dataset.in) {
+          case "path":
+            url = url.replace("{" + input.name + "}", encodeURIComponent(v));
+            break;
+          case "query":
+            if (v !== "") {
+              query.set(input.name, v);
+            }
+            break;
+          case "header":
+            if (v !== "") {
+              headers[input.name] = v;
+            }
+            break;
+        }
+      }
+      if (query.toString() !== "") {
+        url += "?" + query.toString();
+      }
+      const init = { method: method.toUpperCase(), headers: headers, credentials: "same-origin" };
+      const csrf = document.querySelector('meta[name="csrf-token"]');
+      if (csrf && init.method !== "GET") {
+        headers["X-CSRF-Token"] = csrf.content;
+      }
+      if (body) {
+        headers["Content-Type"] = "application/json";
+        init.body = body.value;
+      }
+      result.textContent = init.method + " " + url + " …";
+      try {
+        const resp = await fetch(url, init);
+        let text = resp.status + " " + resp.statusText + "\n";
+        const etag = resp.headers.get("ETag");
+        if (etag) {
+          text += "ETag: " + etag + "\n";
+        }
+        result.textContent = text + "\n" + (await resp.text());
+      } catch (err) {
+        result.textContent = String(err);
+      }
+    });
+
+    const summary = el("summary", {}, [
+      el("code", { class: "api-method api-" + method, text: method.toUpperCase() }),
+      document.createTextNode(" "),
+      el("code", { text: path }),
+      document.createTextNode(" " + (op.summary || "")),
+    ]);
+    const children = [summary];
+    if (op.description) {
+      children.push(el("p", { class: "muted", text: op.description }));
+    }
+    children.push(form);
+    return el("details", { class: "api-op" }, children);
+  }
+
+  fetch(root.dataset.spec, { credentials: "same-origin" })
+    .then(function (resp) {
+      if (!resp.ok) {
+        throw new Error(resp.status + " " + resp.statusText);
+      }
+      return resp.json();
+    })
+    .then(function (spec) {
+      root.textContent = "";
+      const byTag = new Map();
+      for (const [path, item] of Object.entries(spec.paths)) {
+        for (const [method, op] of Object.entries(item)) {
+          const tag = (op.tags || ["Other"])[0];
+          if (!byTag.has(tag)) {
+            byTag.set(tag, []);
+          }
+          byTag.get(tag).push(operation(path, method, op));
+        }
+      }
+      for (const [tag, ops] of byTag) {
+        root.appendChild(el("section", { class: "panel" }, [el("h2", { text: tag })].concat(ops)));
+      }
+    })
+    .catch(function (err) {
+      root.textContent = "Loading the OpenAPI document failed: " + err.message;
+    });
+});
@@ -0,0 +1,20 @@
+// Packet capture report: frame links point into collapsed transaction
+// details, so open the details holding the target before jumping to it.
+document.addEventListener("DOMContentLoaded", function () {
+  function reveal() {
+    if (!location.hash.startsWith("#frame-")) {
+      return;
+    }
+    const target = document.getElementById(location.hash.slice(1));
+    if (!target) {
+      return;
+    }
+    const details = target.closest("details");
+    if (details) {
+      details.open = true;
+    }
+    target.scrollIntoView();
+  }
+  window.addEventListener("hashchange", reveal);
+  reveal();
+});
@@ -0,0 +1,72 @@
+// Log viewer: with "Follow" checked, new lines matching the form's
+// filters are streamed from /logs/stream and appended to the table.
+document.addEventListener("DOMContentLoaded", function () {
+  const follow = document.getElementById("log-follow");
+  const form = document.getElementById("log-form");
+  const tbody = document.getElementById("log-lines");
+  if (!follow || !form || !tbody) {
+    return;
+  }
+
+  const MAX_ROWS = 2000;
+  let source = null;
+
+  function cell(text, code) {
+    const td = document.createElement("td");
+    if (code) {
+      const c = document.createElement("code");
+      c.textContent = text;
+      td.appendChild(c);
+    } else {
+      td.textContent = text;
+    }
+    return td;
+  }
+
+  function append(line) {
+    const tr = document.createElement("tr");
+    if (line.severity) {
+      tr.className = "log-" + line.severity;
+      tr.appendChild(cell(line.time.replace("T", " ").slice(0, 23)));
+      tr.appendChild(cell(line.severity));
+      tr.appendChild(cell(line.logger));
+      const msg = cell(line.msgid, true);
+      msg.appendChild(document.createTextNode(" " + line.message));
+      tr.appendChild(msg);
+    } else {
+      const td = cell(line.raw, true);
+      td.colSpan = 4;
+      tr.appendChild(td);
+    }
+    tbody.appendChild(tr);
+    while (tbody.rows.length > MAX_ROWS) {
+      tbody.deleteRow(0);
+    }
+    tr.scrollIntoView({ block: "end" });
+  }
+
+  function stop() {
+    if (source) {
+      source.close();
+      source = null;
+    }
+  }
+
+  follow.addEventListener("change", function () {
+    stop();
+    if (!follow.checked) {
+      return;
+    }
+    const params = new URLSearchParams(new FormData(form));
+    params.delete("history");
+    source = new EventSource("/logs/stream?" + params.toString());
+    source.onmessage = (ev) => append(JSON.parse(ev.data));
+    source.addEventListener("error", (ev) => {
+      if (ev.data) {
+        console.error("[logs]", JSON.parse(ev.data));
+        follow.checked = false;
+        stop();
+      }
+    });
+  });
+});
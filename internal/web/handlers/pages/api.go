@@ -0,0 +1,281 @@
+package pages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// API pagination limits.
+const (
+  apiDefaultLimit = 100
+  apiMaxLimit     = 1000
+  // apiMaxBody caps request bodies; whole configurations fit easily.
+  apiMaxBody = 8 << 20
+)
+
+// apiError is the envelope of every failed API response.
+type apiError struct {
+  Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+  Code    string `json:"code"`
+  Message string `json:"message"`
+}
+
+// apiPage describes the slice of a collection returned. Total is left
+// out when counting would mean reading the whole collection. Lists
+// paged by offset give NextOffset, those paged by cursor Next, to send
+// back as after; both are left out on the last page.
+type apiPage struct {
+  Limit      int    `json:"limit"`
+  Offset     int    `json:"offset"`
+  Total      *int   `json:"total,omitempty"`
+  NextOffset *int   `json:"next-offset,omitempty"`
+  Next       string `json:"next,omitempty"`
+}
+
+// apiList is the envelope of collections.
+type apiList struct {
+  Data       any     `json:"data"`
+  Pagination apiPage `json:"pagination"`
+}
+
+// apiItem is the envelope of single resources.
+type apiItem struct {
+  Data any `json:"data"`
+}
+
+// apiStatusError carries the HTTP status of a request error.
+type apiStatusError struct {
+  status int
+  code   string
+  err    error
+}
+
+func (e *apiStatusError) Error() string { return e.err.Error() }
+func (e *apiStatusError) Unwrap() error { return e.err }
+
+// apiBadRequest marks err as the client's fault.
+func apiBadRequest(format string, args ...any) error {
+  return &apiStatusError{http.StatusBadRequest, "bad_request", fmt.Errorf(format, args...)}
+}
+
+var (
+  errAPINotFound     = &apiStatusError{http.StatusNotFound, "not_found", errors.New("no such resource")}
+  errAPIPrecondition = &apiStatusError{http.StatusPreconditionRequired, "precondition_required", errors.New("send the ETag of the resource in If-Match")}
+  errAPIChanged      = &apiStatusError{http.StatusPreconditionFailed, "precondition_failed", errors.New("the resource changed since it was read; fetch it again")}
+)
+
+func apiWrite(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  if err := enc.Encode(v); err != nil {
+    utils.Debug("API: write response: %v", err)
+  }
+}
+
+// apiFail answers with the error envelope, choosing the status from
+// the error: permission and precondition errors, Kea's empty result as
+// 404, and other Kea or backend failures as 502.
+func apiFail(w http.ResponseWriter, r *http.Request, err error) {
+  status, code := http.StatusBadGateway, "upstream_error"
+  var se *apiStatusError
+  var ce *kea.CommandError
+  switch {
+  case errors.As(err, &se):
+    status, code = se.status, se.code
+  case errors.Is(err, auth.ErrForbidden):
+    status, code = http.StatusForbidden, "forbidden"
+  case errors.Is(err, kea.ErrEmpty):
+    status, code = http.StatusNotFound, "not_found"
+  case errors.Is(err, kea.ErrConfigChanged):
+    status, code = http.StatusPreconditionFailed, "precondition_failed"
+  case errors.As(err, &ce) && ce.Result == kea.ResultConflict:
+    status, code = http.StatusConflict, "conflict"
+  case errors.As(err, &ce) && ce.Result == kea.ResultError:
+    status, code = http.StatusUnprocessableEntity, "rejected"
+  }
+  if status >= http.StatusInternalServerError {
+    utils.Error("%s %s: %v", r.Method, r.URL.Path, err)
+  } else {
+    utils.Debug("%s %s: %v", r.Method, r.URL.Path, err)
+  }
+  apiWrite(w, status, apiError{Error: apiErrorBody{Code: code, Message: err.Error()}})
+}
+
+// APIRequire is auth.Require for the API: callers without perm get the
+// error envelope.
+func APIRequire(perm auth.Permission, h http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if !auth.Allowed(r.Context(), perm) {
+      apiFail(w, r, fmt.Errorf("%w: this needs the %s permission", auth.ErrForbidden, perm))
+      return
+    }
+    h(w, r)
+  }
+}
+
+// APINotFound answers unknown API paths.
+func APINotFound() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    apiFail(w, r, errAPINotFound)
+  }
+}
+
+// apiPaging reads limit and offset.
+func apiPaging(r *http.Request) (limit, offset int, err error) {
+  limit, offset = apiDefaultLimit, 0
+  q := r.URL.Query()
+  if s := q.Get("limit"); s != "" {
+    if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > apiMaxLimit {
+      return 0, 0, apiBadRequest("limit must be 1 to %d", apiMaxLimit)
+    }
+  }
+  if s := q.Get("offset"); s != "" {
+    if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+      return 0, 0, apiBadRequest("offset must be a number from 0")
+    }
+  }
+  return limit, offset, nil
+}
+
+// apiWriteList answers with one page of items, all of which are in
+// memory.
+func apiWriteList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+  limit, offset, err := apiPaging(r)
+  if err != nil {
+    apiFail(w, r, err)
+    return
+  }
+  total := len(items)
+  page := apiPage{Limit: limit, Offset: offset, Total: &total}
+  start, end := min(offset, total), min(offset+limit, total)
+  if end < total {
+    page.NextOffset = &end
+  }
+  apiWrite(w, http.StatusOK, apiList{Data: items[start:end], Pagination: page})
+}
+
+// apiService reads a DHCP service from a path value or the service
+// query parameter, dhcp4 by default.
+func apiService(r *http.Request) (string, error) {
+  s := r.PathValue("service")
+  if s == "" {
+    s = r.URL.Query().Get("service")
+  }
+  switch s {
+  case "", kea.ServiceDHCP4:
+    return kea.ServiceDHCP4, nil
+  case kea.ServiceDHCP6:
+    return kea.ServiceDHCP6, nil
+  }
+  return "", apiBadRequest("service must be dhcp4 or dhcp6")
+}
+
+// apiFamily is the address family of a DHCP service.
+func apiFamily(service string) int {
+  if service == kea.ServiceDHCP6 {
+    return 6
+  }
+  return 4
+}
+
+// apiInt64 reads an optional integer query parameter or path value.
+func apiInt64(name, s string) (int64, error) {
+  if s == "" {
+    return 0, nil
+  }
+  n, err := strconv.ParseInt(s, 10, 64)
+  if err != nil || n < 0 {
+    return 0, apiBadRequest("invalid %s %q", name, s)
+  }
+  return n, nil
+}
+
+// apiETag is the entity tag of a representation.
+func apiETag(v any) string {
+  b, _ := json.Marshal(v)
+  sum := sha256.Sum256(b)
+  return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// apiWriteTagged answers with a single resource and its ETag, or 304
+// when the client has it already.
+func apiWriteTagged(w http.ResponseWriter, r *http.Request, status int, etag string, v any) {
+  w.Header().Set("ETag", etag)
+  if status == http.StatusOK && etagListed(r.Header.Get("If-None-Match"), etag) {
+    w.WriteHeader(http.StatusNotModified)
+    return
+  }
+  apiWrite(w, status, apiItem{Data: v})
+}
+
+// etagListed reports whether an If-None-Match header names etag,
+// weakly compared, or is "*".
+func etagListed(header, etag string) bool {
+  for _, t := range strings.Split(header, ",") {
+    t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+    if t == "*" || t == etag {
+      return true
+    }
+  }
+  return false
+}
+
+// etagMatches reports whether an If-Match header names etag, strongly
+// compared (RFC 9110 §13.1.1): weak tags never match. "*" isn't taken
+// either, as an update must name the version it was based on.
+func etagMatches(header, etag string) bool {
+  for _, t := range strings.Split(header, ",") {
+    if t = strings.TrimSpace(t); t == etag && !strings.HasPrefix(t, "W/") {
+      return true
+    }
+  }
+  return false
+}
+
+// apiCheckMatch enforces optimistic concurrency on an update: the
+// If-Match header must name the current ETag. required makes a missing
+// header an error.
+func apiCheckMatch(r *http.Request, current string, required bool) error {
+  h := r.Header.Get("If-Match")
+  if h == "" {
+    if required {
+      return errAPIPrecondition
+    }
+    return nil
+  }
+  if !etagMatches(h, current) {
+    return errAPIChanged
+  }
+  return nil
+}
+
+// apiDecode reads a JSON request body into v.
+func apiDecode(r *http.Request, v any) error {
+  b, err := io.ReadAll(io.LimitReader(r.Body, apiMaxBody+1))
+  if err != nil {
+    return apiBadRequest("read body: %v", err)
+  }
+  if len(b) > apiMaxBody {
+    return &apiStatusError{http.StatusRequestEntityTooLarge, "too_large", fmt.Errorf("the body exceeds %d bytes", apiMaxBody)}
+  }
+  if err := json.Unmarshal(b, v); err != nil {
+    return apiBadRequest("invalid JSON: %v", err)
+  }
+  return nil
+}
@@ -0,0 +1,50 @@
+package pages
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPICheckMatch(t *testing.T) {
+  const current = `"0123abcd"`
+  tests := []struct {
+    ifMatch  string
+    required bool
+    err      error
+  }{
+    {"", false, nil},
+    {"", true, errAPIPrecondition},
+    {current, true, nil},
+    {`"other", ` + current, true, nil},
+    {`W/` + current, true, errAPIChanged},
+    {"*", true, errAPIChanged},
+    {`"other"`, false, errAPIChanged},
+  }
+  for _, tt := range tests {
+    r := httptest.NewRequest(http.MethodPut, "/", nil)
+    if tt.ifMatch != "" {
+      r.Header.Set("If-Match", tt.ifMatch)
+    }
+    if err := apiCheckMatch(r, current, tt.required); !errors.Is(err, tt.err) {
+      t.Errorf("If-Match %q, required %v: err = %v, want %v", tt.ifMatch, tt.required, err, tt.err)
+    }
+  }
+}
+
+func TestETagListed(t *testing.T) {
+  const etag = `"0123abcd"`
+  for header, want := range map[string]bool{
+    etag:           true,
+    "W/" + etag:    true,
+    "*":            true,
+    `"x", ` + etag: true,
+    `"x"`:          false,
+    "":             false,
+  } {
+    if got := etagListed(header, etag); got != want {
+      t.Errorf("etagListed(%q) = %v", header, got)
+    }
+  }
+}
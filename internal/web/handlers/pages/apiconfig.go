@@ -0,0 +1,402 @@
+package pages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// apiConfigFile is a configuration file of the config directory.
+type apiConfigFile struct {
+  Name     string    `json:"name"`
+  Service  string    `json:"service,omitempty"`
+  Daemon   bool      `json:"daemon"`
+  Editable bool      `json:"editable"`
+  Content  *string   `json:"content,omitempty"`
+  ModTime  time.Time `json:"mod-time,omitzero"`
+}
+
+// apiConfigVersion is a saved earlier version of a configuration file.
+type apiConfigVersion struct {
+  Version string    `json:"version"`
+  Time    time.Time `json:"time"`
+  Size    int64     `json:"size"`
+  Content *string   `json:"content,omitempty"`
+}
+
+// apiConfigFileUpdate is the body of a file update.
+type apiConfigFileUpdate struct {
+  Content string `json:"content"`
+  // KeaTest also has the daemon test a daemon file before it is saved.
+  KeaTest bool `json:"kea-test"`
+}
+
+// apiConfigMask replaces the values of passwords and secrets for
+// callers that may not change the whole server.
+const apiConfigMask = "*****"
+
+// apiConfigView is the configuration as the caller may see it. config-get
+// names no subnet, so the command check lets subnet-limited callers send
+// it; they get only their own subnets, in shared networks too, and no
+// global reservations. Callers that can't replace the configuration get
+// passwords and secrets masked.
+func apiConfigView(id auth.Identity, service string, cfg map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+  out := maps.Clone(cfg)
+  if len(id.Subnets) > 0 {
+    key := "subnet4"
+    if service == kea.ServiceDHCP6 {
+      key = "subnet6"
+    }
+    subnets, err := apiScopeSubnets(id, out[key])
+    if err != nil {
+      return nil, err
+    }
+    if subnets != nil {
+      out[key] = subnets
+    }
+    if raw, ok := out["shared-networks"]; ok {
+      var networks []map[string]json.RawMessage
+      if err := json.Unmarshal(raw, &networks); err != nil {
+        return nil, fmt.Errorf("shared-networks: %w", err)
+      }
+      kept := []map[string]json.RawMessage{}
+      for _, n := range networks {
+        subnets, err := apiScopeSubnets(id, n[key])
+        if err != nil {
+          return nil, err
+        }
+        if string(subnets) != "[]" && subnets != nil {
+          n[key] = subnets
+          kept = append(kept, n)
+        }
+      }
+      if out["shared-networks"], err = json.Marshal(kept); err != nil {
+        return nil, err
+      }
+    }
+    delete(out, "reservations")
+  }
+  if id.Can(auth.PermConfig) && id.Whole(service) {
+    return out, nil
+  }
+  for k, raw := range out {
+    var v any
+    if err := json.Unmarshal(raw, &v); err != nil {
+      return nil, fmt.Errorf("%s: %w", k, err)
+    }
+    masked, err := json.Marshal(apiMaskSecrets(k, v))
+    if err != nil {
+      return nil, err
+    }
+    out[k] = masked
+  }
+  return out, nil
+}
+
+// apiScopeSubnets keeps the subnets of a subnet4 or subnet6 list that
+// are in the caller's scope; nil when there is no list.
+func apiScopeSubnets(id auth.Identity, raw json.RawMessage) (json.RawMessage, error) {
+  if raw == nil {
+    return nil, nil
+  }
+  var subnets []json.RawMessage
+  if err := json.Unmarshal(raw, &subnets); err != nil {
+    return nil, fmt.Errorf("subnets: %w", err)
+  }
+  kept := []json.RawMessage{}
+  for _, sub := range subnets {
+    var s struct {
+      ID int64 `json:"id"`
+    }
+    if err := json.Unmarshal(sub, &s); err != nil {
+      return nil, fmt.Errorf("subnets: %w", err)
+    }
+    if id.Subnet(s.ID) {
+      kept = append(kept, sub)
+    }
+  }
+  return json.Marshal(kept)
+}
+
+// apiMaskSecrets masks the values of keys naming a password or secret,
+// such as a lease database password or a TSIG secret.
+func apiMaskSecrets(key string, v any) any {
+  if k := strings.ToLower(key); (strings.Contains(k, "password") || strings.Contains(k, "secret")) && v != nil {
+    return apiConfigMask
+  }
+  switch v := v.(type) {
+  case map[string]any:
+    for k, val := range v {
+      v[k] = apiMaskSecrets(k, val)
+    }
+  case []any:
+    for i := range v {
+      v[i] = apiMaskSecrets("", v[i])
+    }
+  }
+  return v
+}
+
+// APIConfig returns the running configuration of a DHCP server, the
+// element under Dhcp4 or Dhcp6, with its ETag, as apiConfigView lets
+// the caller see it.
+func APIConfig(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    cfg, err := func() (map[string]json.RawMessage, error) {
+      service, err := apiService(r)
+      if err != nil {
+        return nil, err
+      }
+      cfg, err := client.ConfigGet(ctx, service)
+      if err != nil {
+        return nil, err
+      }
+      id, _ := auth.FromContext(r.Context())
+      return apiConfigView(id, service, cfg)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteTagged(w, r, http.StatusOK, apiETag(cfg), cfg)
+  }
+}
+
+// APIConfigUpdate tests and applies a whole configuration element, and
+// writes it to the daemon's file with ?persist=true. If-Match must carry
+// the ETag of the configuration the client changed.
+func APIConfigUpdate(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+    defer cancel()
+
+    cfg, err := func() (map[string]json.RawMessage, error) {
+      service, err := apiService(r)
+      if err != nil {
+        return nil, err
+      }
+      persist := false
+      if s := r.URL.Query().Get("persist"); s != "" {
+        if persist, err = strconv.ParseBool(s); err != nil {
+          return nil, apiBadRequest("persist must be true or false")
+        }
+      }
+      var cfg map[string]json.RawMessage
+      if err := apiDecode(r, &cfg); err != nil {
+        return nil, err
+      }
+
+      current, err := client.ConfigGet(ctx, service)
+      if err != nil {
+        return nil, err
+      }
+      if err := apiCheckMatch(r, apiETag(current), true); err != nil {
+        return nil, err
+      }
+      if err := client.ReplaceConfig(ctx, service, cfg, persist); err != nil {
+        return nil, err
+      }
+      id, _ := auth.FromContext(r.Context())
+      utils.Info("%s replaced the %s configuration through the API (persist %t)", id.User, service, persist)
+      return client.ConfigGet(ctx, service)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteTagged(w, r, http.StatusOK, apiETag(cfg), cfg)
+  }
+}
+
+// apiConfFile checks that name is a file of the config directory the
+// caller may see.
+func apiConfFile(r *http.Request, files *kea.ConfigFiles, name string) error {
+  names, err := files.List()
+  if err != nil {
+    return err
+  }
+  if !slices.Contains(names, name) {
+    return errAPINotFound
+  }
+  if !canReadConfFile(r, name) {
+    return fmt.Errorf("%s: %w", name, auth.ErrForbidden)
+  }
+  return nil
+}
+
+// apiConfFileETag is the entity tag of a file: its content hash, which
+// ConfigFiles.Write checks.
+func apiConfFileETag(f kea.ConfigFile) string {
+  return `"` + f.Hash + `"`
+}
+
+func apiConfigFileInfo(r *http.Request, name string) apiConfigFile {
+  service, daemon := kea.ConfFileService(name)
+  return apiConfigFile{Name: name, Service: service, Daemon: daemon, Editable: canEditConfFile(r, name)}
+}
+
+// APIConfigFiles lists the configuration files the caller may see.
+func APIConfigFiles(files *kea.ConfigFiles) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    names, err := readableConfFiles(r, files)
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := make([]apiConfigFile, len(names))
+    for i, name := range names {
+      out[i] = apiConfigFileInfo(r, name)
+    }
+    apiWriteList(w, r, out)
+  }
+}
+
+// APIConfigFile returns a configuration file with its ETag.
+func APIConfigFile(files *kea.ConfigFiles) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    name := r.PathValue("name")
+    f, err := func() (kea.ConfigFile, error) {
+      if err := apiConfFile(r, files, name); err != nil {
+        return kea.ConfigFile{}, err
+      }
+      return files.Read(name)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := apiConfigFileInfo(r, name)
+    content := string(f.Raw)
+    out.Content, out.ModTime = &content, f.ModTime
+    apiWriteTagged(w, r, http.StatusOK, apiConfFileETag(f), out)
+  }
+}
+
+// APIConfigFileUpdate validates and saves a configuration file, keeping
+// the previous content as a version. If-Match must carry the ETag of the
+// content the client changed.
+func APIConfigFileUpdate(client *kea.Client, files *kea.ConfigFiles) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    name := r.PathValue("name")
+    f, err := func() (kea.ConfigFile, error) {
+      if err := apiConfFile(r, files, name); err != nil {
+        return kea.ConfigFile{}, err
+      }
+      if !canEditConfFile(r, name) {
+        return kea.ConfigFile{}, fmt.Errorf("%s: %w", name, auth.ErrForbidden)
+      }
+      var in apiConfigFileUpdate
+      if err := apiDecode(r, &in); err != nil {
+        return kea.ConfigFile{}, err
+      }
+      current, err := files.Read(name)
+      if err != nil {
+        return kea.ConfigFile{}, err
+      }
+      if err := apiCheckMatch(r, apiConfFileETag(current), true); err != nil {
+        return kea.ConfigFile{}, err
+      }
+
+      content := []byte(strings.ReplaceAll(in.Content, "\r\n", "\n"))
+      if _, err := files.Validate(name, content); err != nil {
+        return kea.ConfigFile{}, &apiStatusError{http.StatusUnprocessableEntity, "invalid", err}
+      }
+      if service, daemon := kea.ConfFileService(name); daemon && in.KeaTest {
+        elem, err := files.Element(name, content)
+        if err != nil {
+          return kea.ConfigFile{}, &apiStatusError{http.StatusUnprocessableEntity, "invalid", err}
+        }
+        if err := client.ConfigTest(ctx, service, elem); err != nil {
+          return kea.ConfigFile{}, err
+        }
+      }
+
+      // Write compares the hash again under its lock, so a save racing
+      // this one still fails with ErrConfigChanged.
+      backup, err := files.Write(name, content, current.Hash)
+      if err != nil {
+        return kea.ConfigFile{}, err
+      }
+      utils.Info("Config file %s saved through the API (backup %s)", name, backup)
+      return files.Read(name)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := apiConfigFileInfo(r, name)
+    content := string(f.Raw)
+    out.Content, out.ModTime = &content, f.ModTime
+    apiWriteTagged(w, r, http.StatusOK, apiConfFileETag(f), out)
+  }
+}
+
+// APIConfigVersions lists the saved versions of a configuration file,
+// newest first.
+func APIConfigVersions(files *kea.ConfigFiles) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    name := r.PathValue("name")
+    backups, err := func() ([]kea.Backup, error) {
+      if err := apiConfFile(r, files, name); err != nil {
+        return nil, err
+      }
+      return files.Backups(name)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := make([]apiConfigVersion, len(backups))
+    for i, b := range backups {
+      out[i] = apiConfigVersion{Version: b.Name, Time: b.Time, Size: b.Size}
+    }
+    apiWriteList(w, r, out)
+  }
+}
+
+// APIConfigVersion returns one saved version of a configuration file.
+func APIConfigVersion(files *kea.ConfigFiles) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    name, version := r.PathValue("name"), r.PathValue("version")
+    out, err := func() (apiConfigVersion, error) {
+      if err := apiConfFile(r, files, name); err != nil {
+        return apiConfigVersion{}, err
+      }
+      backups, err := files.Backups(name)
+      if err != nil {
+        return apiConfigVersion{}, err
+      }
+      i := slices.IndexFunc(backups, func(b kea.Backup) bool { return b.Name == version })
+      if i < 0 {
+        return apiConfigVersion{}, errAPINotFound
+      }
+      raw, err := files.ReadBackup(name, version)
+      if err != nil {
+        return apiConfigVersion{}, err
+      }
+      content := string(raw)
+      return apiConfigVersion{Version: version, Time: backups[i].Time, Size: backups[i].Size, Content: &content}, nil
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteTagged(w, r, http.StatusOK, apiETag(out), out)
+  }
+}
@@ -0,0 +1,143 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+)
+
+// apiServiceHealth is the state of one Kea daemon.
+type apiServiceHealth struct {
+  Service        string `json:"service"`
+  Up             bool   `json:"up"`
+  Version        string `json:"version,omitempty"`
+  PID            int    `json:"pid,omitempty"`
+  Uptime         int64  `json:"uptime,omitempty"`
+  Reload         int64  `json:"reload,omitempty"`
+  MultiThreading bool   `json:"multi-threading-enabled,omitempty"`
+  Error          string `json:"error,omitempty"`
+}
+
+type apiDatabaseHealth struct {
+  Configured    bool   `json:"configured"`
+  Reachable     bool   `json:"reachable"`
+  SchemaVersion string `json:"schema-version,omitempty"`
+  Error         string `json:"error,omitempty"`
+}
+
+type apiDiskHealth struct {
+  Mount string   `json:"mount"`
+  Paths []string `json:"paths"`
+  Total uint64   `json:"total"`
+  Avail uint64   `json:"avail"`
+  Error string   `json:"error,omitempty"`
+}
+
+type apiHostHealth struct {
+  Load         [3]float64      `json:"load"`
+  CPUs         int             `json:"cpus"`
+  CPUUsed      float64         `json:"cpu-used"`
+  MemTotal     uint64          `json:"mem-total"`
+  MemAvailable uint64          `json:"mem-available"`
+  Disks        []apiDiskHealth `json:"disks"`
+  Errors       []string        `json:"errors,omitempty"`
+}
+
+type apiHealth struct {
+  // OK is set when every daemon answers and the database, if any, is
+  // reachable.
+  OK       bool               `json:"ok"`
+  Services []apiServiceHealth `json:"services"`
+  Database apiDatabaseHealth  `json:"database"`
+  Host     apiHostHealth      `json:"host"`
+}
+
+// apiServices are the daemons the health check asks.
+var apiServices = []string{kea.ServiceDHCP4, kea.ServiceDHCP6, kea.ServiceD2}
+
+// APIHealth reports the Kea daemons, the lease database and the host.
+// Daemons outside the caller's servers are left out.
+func APIHealth(client *kea.Client, db *sql.DB, host *linux.Host) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
+    id, _ := auth.FromContext(r.Context())
+
+    out := apiHealth{OK: true, Services: []apiServiceHealth{}}
+    for _, service := range apiServices {
+      if !id.Server(service) {
+        continue
+      }
+      sh := apiServiceHealth{Service: service}
+      st, err := client.StatusGet(ctx, service)
+      if err == nil {
+        sh.Up, sh.PID, sh.Uptime, sh.Reload, sh.MultiThreading = true, st.PID, st.Uptime, st.Reload, st.MultiThreading
+        sh.Version, _ = client.VersionGet(ctx, service)
+      } else {
+        sh.Error = err.Error()
+        out.OK = false
+      }
+      out.Services = append(out.Services, sh)
+    }
+
+    st, err := db.Status(ctx)
+    out.Database = apiDatabaseHealth{Configured: db.Configured(), Reachable: st.Reachable, SchemaVersion: st.SchemaVersion}
+    if err != nil && !errors.Is(err, sql.ErrNotConfigured) {
+      out.Database.Error = err.Error()
+      out.OK = false
+    }
+
+    hh := hostHealthFor(ctx, client, host)
+    out.Host = apiHostHealth{
+      Load:         [3]float64{hh.Load1, hh.Load5, hh.Load15},
+      CPUs:         hh.CPUs,
+      CPUUsed:      hh.CPUUsed,
+      MemTotal:     hh.MemTotal,
+      MemAvailable: hh.MemAvailable,
+      Disks:        []apiDiskHealth{},
+      Errors:       hh.Errors,
+    }
+    for _, d := range hh.Disks {
+      out.Host.Disks = append(out.Host.Disks, apiDiskHealth{Mount: d.Mount, Paths: d.Paths, Total: d.Total, Avail: d.Avail, Error: d.Error})
+    }
+    apiWrite(w, http.StatusOK, apiItem{Data: out})
+  }
+}
+
+// apiHAStatus is the HA state of one DHCP server.
+type apiHAStatus struct {
+  Service       string               `json:"service"`
+  Relationships []kea.HARelationship `json:"relationships"`
+  Error         string               `json:"error,omitempty"`
+}
+
+// APIHA reports the high-availability state of the DHCP servers. A
+// server without the HA hook has no relationships.
+func APIHA(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
+    id, _ := auth.FromContext(r.Context())
+
+    out := []apiHAStatus{}
+    for _, service := range []string{kea.ServiceDHCP4, kea.ServiceDHCP6} {
+      if !id.Server(service) {
+        continue
+      }
+      ha := apiHAStatus{Service: service, Relationships: []kea.HARelationship{}}
+      if st, err := client.StatusGet(ctx, service); err != nil {
+        ha.Error = err.Error()
+      } else if st.HighAvailability != nil {
+        ha.Relationships = st.HighAvailability
+      }
+      out = append(out, ha)
+    }
+    apiWrite(w, http.StatusOK, apiItem{Data: out})
+  }
+}
@@ -0,0 +1,127 @@
+package pages
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+)
+
+// errPageFull stops a lease scan once a page is filled.
+var errPageFull = errors.New("page full")
+
+// leaseCursor is where a page of leases ended: the address and subnet
+// of its last lease. Leases are read in address order, so the next page
+// starts past the address without rescanning those before it.
+type leaseCursor struct {
+  Address  netip.Addr
+  SubnetID int64
+}
+
+// String is the cursor as clients see it, an opaque token.
+func (c leaseCursor) String() string {
+  return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.SubnetID, 10) + "/" + c.Address.String()))
+}
+
+// parseLeaseCursor reads the after parameter of a lease listing of
+// family narrowed to subnetID, 0 for any.
+func parseLeaseCursor(s string, family int, subnetID int64) (leaseCursor, error) {
+  bad := apiBadRequest("invalid after %q; send the next value of the previous page", s)
+  b, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return leaseCursor{}, bad
+  }
+  subnet, addr, ok := strings.Cut(string(b), "/")
+  if !ok {
+    return leaseCursor{}, bad
+  }
+  var c leaseCursor
+  if c.SubnetID, err = strconv.ParseInt(subnet, 10, 64); err != nil {
+    return leaseCursor{}, bad
+  }
+  if c.Address, err = netip.ParseAddr(addr); err != nil || c.Address.Is4() != (family == 4) {
+    return leaseCursor{}, bad
+  }
+  if subnetID != 0 && c.SubnetID != subnetID {
+    return leaseCursor{}, apiBadRequest("after belongs to a listing of subnet %d, not %d", c.SubnetID, subnetID)
+  }
+  return c, nil
+}
+
+// APILeases lists leases with the filters of the lease export: service,
+// subnet-id, state, hostname and hw-address. Leases are read in address
+// order from the database or Kea; pages follow one another by cursor,
+// so no total is given and next is set while more leases follow.
+func APILeases(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    out, page, err := func() ([]leaseExportRow, apiPage, error) {
+      limit, offset, err := apiPaging(r)
+      if err != nil {
+        return nil, apiPage{}, err
+      }
+      if offset != 0 {
+        return nil, apiPage{}, apiBadRequest("leases are paged with after, not offset")
+      }
+      service, err := apiService(r)
+      if err != nil {
+        return nil, apiPage{}, err
+      }
+      f, err := parseLeaseExportFilter(r)
+      if err != nil {
+        return nil, apiPage{}, apiBadRequest("%w", err)
+      }
+      f.Family = apiFamily(service)
+      if s := r.URL.Query().Get("after"); s != "" {
+        c, err := parseLeaseCursor(s, f.Family, f.SubnetID)
+        if err != nil {
+          return nil, apiPage{}, err
+        }
+        f.After = c.Address
+      }
+
+      // The database is read directly, past the Kea command check.
+      id, _ := auth.FromContext(r.Context())
+      if !id.Server(service) {
+        return nil, apiPage{}, auth.ErrForbidden
+      }
+
+      ctx, cancel := context.WithTimeout(r.Context(), exportMaxDuration)
+      defer cancel()
+
+      page := apiPage{Limit: limit}
+      out := []leaseExportRow{}
+      err = forEachExportLease(ctx, client, db, f, func(l leaseExportRow) error {
+        if !id.Subnet(l.SubnetID) {
+          return nil
+        }
+        if len(out) == limit {
+          last := out[len(out)-1]
+          addr, err := netip.ParseAddr(last.IPAddress)
+          if err != nil {
+            return err
+          }
+          page.Next = leaseCursor{Address: addr, SubnetID: last.SubnetID}.String()
+          return errPageFull
+        }
+        out = append(out, l)
+        return nil
+      })
+      if err != nil && !errors.Is(err, errPageFull) {
+        return nil, apiPage{}, err
+      }
+      return out, page, nil
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWrite(w, http.StatusOK, apiList{Data: out, Pagination: page})
+  }
+}
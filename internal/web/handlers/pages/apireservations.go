@@ -0,0 +1,289 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// apiReservationPath is the URL of a reservation.
+func apiReservationPath(service string, res kea.Reservation) string {
+  t, v := res.Identifier()
+  return fmt.Sprintf("/api/v1/reservations/%s/%d/%s/%s", service, res.SubnetID, t, url.PathEscape(v))
+}
+
+// apiReservationTarget reads the service, subnet and identifier of a
+// reservation URL.
+func apiReservationTarget(r *http.Request) (service string, subnetID int64, typ, ident string, err error) {
+  if service, err = apiService(r); err != nil {
+    return
+  }
+  if subnetID, err = apiInt64("subnet ID", r.PathValue("subnet")); err != nil {
+    return
+  }
+  typ, ident = r.PathValue("type"), r.PathValue("identifier")
+  if !slices.Contains(kea.IdentifierTypes, typ) {
+    err = apiBadRequest("unknown identifier type %q", typ)
+  }
+  return
+}
+
+// apiFindReservation looks a reservation up in the backend the
+// reservation pages use: the hosts table or host_cmds.
+func apiFindReservation(ctx context.Context, client *kea.Client, db *sql.DB, family int, subnetID int64, typ, ident string) (existingReservation, error) {
+  existing, _, err := loadExistingReservations(ctx, client, db, family)
+  if err != nil {
+    return existingReservation{}, err
+  }
+  key := reservationKey(subnetID, typ, ident)
+  for _, e := range existing {
+    t, v := e.Res.Identifier()
+    if reservationKey(e.Res.SubnetID, t, v) == key {
+      return e, nil
+    }
+  }
+  return existingReservation{}, errAPINotFound
+}
+
+// apiSaveReservation adds or updates res the way a one-row import would,
+// with the same validation and conflict checks. check vets the
+// reservation it would replace, nil for an add, before anything is
+// written. It returns the reservation as stored.
+func apiSaveReservation(ctx context.Context, client *kea.Client, db *sql.DB, family int, res kea.Reservation, check func(*existingReservation) error) (kea.Reservation, error) {
+  rec := importRecord{Line: 1, Subnet: strconv.FormatInt(res.SubnetID, 10), Res: res}
+  plan, err := planImport(ctx, client, db, family, []importRecord{rec})
+  if err != nil {
+    return kea.Reservation{}, err
+  }
+  row := plan.Rows[0]
+  switch row.Action {
+  case importError:
+    return kea.Reservation{}, &apiStatusError{http.StatusUnprocessableEntity, "invalid", errors.New(row.Message)}
+  case importConflict:
+    return kea.Reservation{}, &apiStatusError{http.StatusConflict, "conflict", errors.New(row.Message)}
+  }
+  if err := check(row.existing); err != nil {
+    return kea.Reservation{}, err
+  }
+
+  switch {
+  case row.Action == importUnchanged:
+  case plan.Source == reservationSourceHostsTable:
+    err = saveHostBatch(ctx, db, plan.Rows)
+  default:
+    err = saveReservation(ctx, client, family, row)
+  }
+  if err != nil {
+    return kea.Reservation{}, err
+  }
+  saved, err := apiFindReservation(ctx, client, db, family, row.SubnetID, row.IdentifierType, row.Identifier)
+  if err != nil {
+    // The write went through; answer with what was sent.
+    utils.Warn("API: reservation %s %s saved, but reading it back failed: %v", row.IdentifierType, row.Identifier, err)
+    return row.res, nil
+  }
+  return saved.Res, nil
+}
+
+// APIReservations lists reservations, filtered by subnet-id, hostname
+// (a substring), ip-address and identifier.
+func APIReservations(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    out, err := func() ([]kea.Reservation, error) {
+      service, err := apiService(r)
+      if err != nil {
+        return nil, err
+      }
+      q := r.URL.Query()
+      subnetID, err := apiInt64("subnet-id", q.Get("subnet-id"))
+      if err != nil {
+        return nil, err
+      }
+      hostname := strings.ToLower(strings.TrimSpace(q.Get("hostname")))
+      address := strings.TrimSpace(q.Get("ip-address"))
+      ident := strings.TrimSpace(q.Get("identifier"))
+
+      // The hosts table is read directly, past the Kea command check.
+      id, _ := auth.FromContext(r.Context())
+      if !id.Server(service) {
+        return nil, auth.ErrForbidden
+      }
+      existing, _, err := loadExistingReservations(ctx, client, db, apiFamily(service))
+      if err != nil {
+        return nil, err
+      }
+      out := []kea.Reservation{}
+      for _, e := range existing {
+        res := e.Res
+        _, v := res.Identifier()
+        switch {
+        case !id.Subnet(res.SubnetID),
+          subnetID != 0 && res.SubnetID != subnetID,
+          hostname != "" && !strings.Contains(strings.ToLower(res.Hostname), hostname),
+          address != "" && !slices.Contains(res.Addresses(), address),
+          ident != "" && !strings.EqualFold(v, ident):
+          continue
+        }
+        out = append(out, res)
+      }
+      return out, nil
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteList(w, r, out)
+  }
+}
+
+// APIReservation returns one reservation with its ETag.
+func APIReservation(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    res, err := func() (kea.Reservation, error) {
+      service, subnetID, typ, ident, err := apiReservationTarget(r)
+      if err != nil {
+        return kea.Reservation{}, err
+      }
+      id, _ := auth.FromContext(r.Context())
+      if !id.Server(service) || !id.Subnet(subnetID) {
+        return kea.Reservation{}, auth.ErrForbidden
+      }
+      e, err := apiFindReservation(ctx, client, db, apiFamily(service), subnetID, typ, ident)
+      return e.Res, err
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteTagged(w, r, http.StatusOK, apiETag(res), res)
+  }
+}
+
+// APIReservationCreate adds a reservation. The body is a reservation as
+// Kea names its fields, with subnet-id and one identifier; adding one
+// that exists is a conflict.
+func APIReservationCreate(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    service, res, err := func() (string, kea.Reservation, error) {
+      service, err := apiService(r)
+      if err != nil {
+        return "", kea.Reservation{}, err
+      }
+      var in kea.Reservation
+      if err := apiDecode(r, &in); err != nil {
+        return "", kea.Reservation{}, err
+      }
+      res, err := apiSaveReservation(ctx, client, db, apiFamily(service), in, func(e *existingReservation) error {
+        if e != nil {
+          return &apiStatusError{http.StatusConflict, "conflict", errors.New("the reservation exists; update it with PUT")}
+        }
+        return nil
+      })
+      return service, res, err
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    w.Header().Set("Location", apiReservationPath(service, res))
+    apiWriteTagged(w, r, http.StatusCreated, apiETag(res), res)
+  }
+}
+
+// APIReservationUpdate replaces the addresses, hostname and options of
+// a reservation. If-Match must carry the ETag the client read.
+func APIReservationUpdate(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    res, err := func() (kea.Reservation, error) {
+      service, subnetID, typ, ident, err := apiReservationTarget(r)
+      if err != nil {
+        return kea.Reservation{}, err
+      }
+      var in kea.Reservation
+      if err := apiDecode(r, &in); err != nil {
+        return kea.Reservation{}, err
+      }
+      if t, v := in.Identifier(); t != "" && (t != typ || !strings.EqualFold(v, ident)) {
+        return kea.Reservation{}, apiBadRequest("the identifier of a reservation can't be changed")
+      }
+      if in.SubnetID != 0 && in.SubnetID != subnetID {
+        return kea.Reservation{}, apiBadRequest("the subnet of a reservation can't be changed")
+      }
+      in.SubnetID = subnetID
+      _ = in.SetIdentifier(typ, ident)
+
+      return apiSaveReservation(ctx, client, db, apiFamily(service), in, func(e *existingReservation) error {
+        if e == nil {
+          return errAPINotFound
+        }
+        return apiCheckMatch(r, apiETag(e.Res), true)
+      })
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteTagged(w, r, http.StatusOK, apiETag(res), res)
+  }
+}
+
+// APIReservationDelete deletes a reservation. If-Match is checked when
+// given.
+func APIReservationDelete(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    err := func() error {
+      service, subnetID, typ, ident, err := apiReservationTarget(r)
+      if err != nil {
+        return err
+      }
+      e, err := apiFindReservation(ctx, client, db, apiFamily(service), subnetID, typ, ident)
+      if err != nil {
+        return err
+      }
+      if err := apiCheckMatch(r, apiETag(e.Res), false); err != nil {
+        return err
+      }
+      if e.HostID == 0 {
+        return client.ReservationDel(ctx, service, subnetID, typ, ident)
+      }
+      // Hosts table rows are deleted directly, past the Kea command
+      // check.
+      id, _ := auth.FromContext(r.Context())
+      if !id.Allows(auth.PermLeases, service, subnetID) {
+        return auth.ErrForbidden
+      }
+      return db.DeleteHost(ctx, e.HostID)
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
@@ -0,0 +1,191 @@
+package pages
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+// apiSubnet is a subnet with the shared network it belongs to.
+type apiSubnet struct {
+  kea.Subnet
+  SharedNetwork string `json:"shared-network,omitempty"`
+}
+
+// apiPool is an address pool, or a DHCPv6 prefix delegation pool, of a
+// subnet.
+type apiPool struct {
+  SubnetID     int64            `json:"subnet-id"`
+  Type         string           `json:"type"`
+  Pool         string           `json:"pool,omitempty"`
+  Prefix       string           `json:"prefix,omitempty"`
+  PrefixLen    int              `json:"prefix-len,omitempty"`
+  DelegatedLen int              `json:"delegated-len,omitempty"`
+  ClientClass  string           `json:"client-class,omitempty"`
+  OptionData   []kea.OptionData `json:"option-data,omitempty"`
+}
+
+// apiSubnetStats is the in-memory statistics of one subnet.
+type apiSubnetStats struct {
+  SubnetID   int64              `json:"subnet-id"`
+  Statistics map[string]float64 `json:"statistics"`
+}
+
+// apiSubnets reads the subnets of the requested service the caller may
+// see, filtered by the subnet-id and shared-network parameters.
+func apiSubnets(ctx context.Context, r *http.Request, client *kea.Client) (string, []kea.Subnet, error) {
+  service, err := apiService(r)
+  if err != nil {
+    return "", nil, err
+  }
+  q := r.URL.Query()
+  subnetID, err := apiInt64("subnet-id", q.Get("subnet-id"))
+  if err != nil {
+    return "", nil, err
+  }
+  subnets, err := client.Subnets(ctx, service)
+  if err != nil {
+    return "", nil, err
+  }
+
+  id, _ := auth.FromContext(r.Context())
+  network, filterNetwork := q.Get("shared-network"), q.Has("shared-network")
+  out := []kea.Subnet{}
+  for _, s := range subnets {
+    if !id.Subnet(s.ID) || (subnetID != 0 && s.ID != subnetID) || (filterNetwork && s.SharedNetwork != network) {
+      continue
+    }
+    out = append(out, s)
+  }
+  return service, out, nil
+}
+
+// APISubnets lists the subnets of a DHCP server.
+func APISubnets(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    _, subnets, err := apiSubnets(ctx, r, client)
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := make([]apiSubnet, len(subnets))
+    for i, s := range subnets {
+      out[i] = apiSubnet{Subnet: s, SharedNetwork: s.SharedNetwork}
+    }
+    apiWriteList(w, r, out)
+  }
+}
+
+// APISubnet returns one subnet by ID.
+func APISubnet(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    err := func() error {
+      subnetID, err := apiInt64("subnet ID", r.PathValue("id"))
+      if err != nil {
+        return err
+      }
+      id, _ := auth.FromContext(r.Context())
+      if !id.Subnet(subnetID) {
+        return auth.ErrForbidden
+      }
+      service, err := apiService(r)
+      if err != nil {
+        return err
+      }
+      subnets, err := client.Subnets(ctx, service)
+      if err != nil {
+        return err
+      }
+      for _, s := range subnets {
+        if s.ID == subnetID {
+          out := apiSubnet{Subnet: s, SharedNetwork: s.SharedNetwork}
+          apiWriteTagged(w, r, http.StatusOK, apiETag(out), out)
+          return nil
+        }
+      }
+      return errAPINotFound
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+    }
+  }
+}
+
+// APIPools lists the address and prefix delegation pools of the
+// subnets, with the same filters as APISubnets.
+func APIPools(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    _, subnets, err := apiSubnets(ctx, r, client)
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    out := []apiPool{}
+    for _, s := range subnets {
+      for _, p := range s.Pools {
+        out = append(out, apiPool{SubnetID: s.ID, Type: "address", Pool: p.Pool, ClientClass: p.ClientClass, OptionData: p.OptionData})
+      }
+      for _, p := range s.PDPools {
+        out = append(out, apiPool{
+          SubnetID:     s.ID,
+          Type:         "prefix",
+          Prefix:       p.Prefix,
+          PrefixLen:    p.PrefixLen,
+          DelegatedLen: p.DelegatedLen,
+          ClientClass:  p.ClientClass,
+          OptionData:   p.OptionData,
+        })
+      }
+    }
+    apiWriteList(w, r, out)
+  }
+}
+
+// APIStatistics lists the per-subnet statistics of a DHCP server.
+func APIStatistics(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    out, err := func() ([]apiSubnetStats, error) {
+      service, err := apiService(r)
+      if err != nil {
+        return nil, err
+      }
+      subnetID, err := apiInt64("subnet-id", r.URL.Query().Get("subnet-id"))
+      if err != nil {
+        return nil, err
+      }
+      stats, err := client.StatisticGetAll(ctx, service)
+      if err != nil {
+        return nil, err
+      }
+      id, _ := auth.FromContext(r.Context())
+      out := []apiSubnetStats{}
+      for _, s := range stats.BySubnet() {
+        if !id.Subnet(s.SubnetID) || (subnetID != 0 && s.SubnetID != subnetID) {
+          continue
+        }
+        out = append(out, apiSubnetStats{SubnetID: s.SubnetID, Statistics: s.Values})
+      }
+      return out, nil
+    }()
+    if err != nil {
+      apiFail(w, r, err)
+      return
+    }
+    apiWriteList(w, r, out)
+  }
+}
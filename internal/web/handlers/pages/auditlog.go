@@ -0,0 +1,153 @@
+package pages
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/audit"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// auditLogLimit is how many of the newest matching events the page
+// shows; the CSV export has them all.
+const auditLogLimit = 500
+
+// auditLogRow is one event on the Audit Log page.
+type auditLogRow struct {
+  audit.Event
+  TimeText  string
+  Arguments string
+}
+
+// auditLogFilter reads the filter from the query. Dates are whole days
+// in local time; until includes its day.
+func auditLogFilter(r *http.Request) (audit.Filter, error) {
+  q := r.URL.Query()
+  f := audit.Filter{
+    User:    strings.TrimSpace(q.Get("user")),
+    Server:  strings.TrimSpace(q.Get("server")),
+    Kind:    q.Get("kind"),
+    Command: strings.TrimSpace(q.Get("command")),
+    Result:  q.Get("result"),
+    Text:    strings.TrimSpace(q.Get("q")),
+  }
+  for _, d := range []struct {
+    name string
+    t    *time.Time
+    days int
+  }{{"since", &f.Since, 0}, {"until", &f.Until, 1}} {
+    s := q.Get(d.name)
+    if s == "" {
+      continue
+    }
+    day, err := time.ParseInLocation("2006-01-02", s, time.Local)
+    if err != nil {
+      return f, fmt.Errorf("%s must be a date like 2006-01-02", d.name)
+    }
+    *d.t = day.AddDate(0, 0, d.days)
+  }
+  return f, nil
+}
+
+// AuditLog shows the newest events of the audit log that match the
+// filter.
+func AuditLog(log *audit.Log) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    data := map[string]interface{}{"Query": q, "CSVQuery": q.Encode(), "Limit": auditLogLimit}
+    err := func() error {
+      f, err := auditLogFilter(r)
+      if err != nil {
+        return err
+      }
+      // Keep the newest matches in a ring, oldest overwritten first.
+      ring := make([]audit.Event, 0, auditLogLimit)
+      matched := 0
+      err = log.Read(func(ev audit.Event) error {
+        if !f.Match(ev) {
+          return nil
+        }
+        if len(ring) < auditLogLimit {
+          ring = append(ring, ev)
+        } else {
+          ring[matched%auditLogLimit] = ev
+        }
+        matched++
+        return nil
+      })
+      if err != nil {
+        return err
+      }
+      rows := make([]auditLogRow, 0, len(ring))
+      for i := range ring {
+        ev := ring[(matched-1-i)%len(ring)]
+        rows = append(rows, auditLogRow{
+          Event:     ev,
+          TimeText:  ev.Time.Local().Format("2006-01-02 15:04:05"),
+          Arguments: string(ev.Arguments),
+        })
+      }
+      data["Events"], data["Matched"] = rows, matched
+      return nil
+    }()
+    if err != nil {
+      utils.Error("Audit log: %v", err)
+      data["Error"] = err.Error()
+    }
+
+    handlers.RenderTemplate(w, r, "auditlog", handlers.PageData{
+      Title: "Audit Log",
+      Data:  data,
+    })
+  }
+}
+
+// AuditLogCSV exports every event that matches the filter, oldest
+// first.
+func AuditLogCSV(log *audit.Log) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    f, err := auditLogFilter(r)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+
+    name := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102"))
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+    cw := csv.NewWriter(w)
+    _ = cw.Write([]string{"time", "kind", "user", "token", "source", "server", "command", "arguments", "result", "ok", "text"})
+    err = log.Read(func(ev audit.Event) error {
+      if !f.Match(ev) {
+        return nil
+      }
+      return cw.Write([]string{
+        ev.Time.Format(time.RFC3339Nano),
+        ev.Kind,
+        ev.User,
+        ev.Token,
+        ev.Source,
+        ev.Server,
+        ev.Command,
+        string(ev.Arguments),
+        strconv.Itoa(ev.Result),
+        strconv.FormatBool(ev.OK),
+        ev.Text,
+      })
+    })
+    cw.Flush()
+    if err == nil {
+      err = cw.Error()
+    }
+    if err != nil {
+      // The header is out; all that's left is to cut the file short.
+      utils.Error("Audit log export: %v", err)
+    }
+  }
+}
@@ -0,0 +1,118 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/capture"
+	"github.com/rannday/kea-web/internal/rogue"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// maxCaptureSize caps uploaded capture files.
+const maxCaptureSize = 256 << 20
+
+// captureForm holds the analyzer settings echoed back into the form.
+type captureForm struct {
+  Servers   string
+  NAKStorm  int
+  NAKWindow int
+}
+
+func captureParams(r *http.Request) (captureForm, capture.Options) {
+  f := captureForm{Servers: r.FormValue("servers"), NAKStorm: 10, NAKWindow: 10}
+  if n, err := strconv.Atoi(r.FormValue("nak-storm")); err == nil && n > 0 {
+    f.NAKStorm = n
+  }
+  if n, err := strconv.Atoi(r.FormValue("nak-window")); err == nil && n > 0 {
+    f.NAKWindow = n
+  }
+  return f, capture.Options{
+    KnownServers:   splitList(f.Servers),
+    NAKStorm:       f.NAKStorm,
+    NAKStormWindow: time.Duration(f.NAKWindow) * time.Second,
+  }
+}
+
+// Capture shows the upload form of the packet capture analyzer. The
+// known servers default to those of the Kea configuration.
+func Capture(detector *rogue.Detector) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    form, _ := captureParams(r)
+    if form.Servers == "" {
+      ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+      form.Servers = strings.Join(detector.Known(ctx).IDs(), ", ")
+      cancel()
+    }
+    handlers.RenderTemplate(w, r, "capture", handlers.PageData{
+      Title: "Packet Capture",
+      Data:  map[string]interface{}{"Form": form},
+    })
+  }
+}
+
+// CaptureAnalyze decodes the DHCP traffic of an uploaded pcap or pcapng
+// file. Only the servers that aren't ours are kept, in the rogue server
+// report.
+func CaptureAnalyze(detector *rogue.Detector) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    extendForUpload(w)
+    r.Body = http.MaxBytesReader(w, r.Body, maxCaptureSize)
+    if err := r.ParseMultipartForm(32 << 20); err != nil {
+      http.Error(w, "Upload too large or malformed", http.StatusBadRequest)
+      return
+    }
+    defer r.MultipartForm.RemoveAll()
+
+    form, opts := captureParams(r)
+    data := map[string]interface{}{"Form": form}
+    render := func() {
+      handlers.RenderTemplate(w, r, "capture", handlers.PageData{
+        Title: "Packet Capture",
+        Data:  data,
+      })
+    }
+
+    file, header, err := r.FormFile("file")
+    if err != nil {
+      data["Error"] = "Choose a capture file"
+      render()
+      return
+    }
+    defer file.Close()
+
+    report, err := capture.Analyze(file, opts)
+    if err != nil {
+      if !errors.Is(err, capture.ErrFormat) {
+        utils.Error("Capture %s: %v", header.Filename, err)
+      }
+      data["Error"] = header.Filename + ": " + err.Error()
+      render()
+      return
+    }
+    utils.Info("Analysed capture %s: %d frames, %d DHCP packets, %d findings",
+      header.Filename, report.Frames, report.DHCP, len(report.Findings))
+
+    unknown, err := detector.RecordCapture(r.Context(), header.Filename, report)
+    if err != nil {
+      data["RogueError"] = err.Error()
+    }
+    data["Unknown"] = unknown
+
+    var malformed []*capture.Packet
+    for _, p := range report.Packets {
+      if p.Type == "malformed" {
+        malformed = append(malformed, p)
+      }
+    }
+    data["File"] = header.Filename
+    data["Report"] = report
+    data["Malformed"] = malformed
+    render()
+  }
+}
@@ -0,0 +1,178 @@
+package pages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const configBackendPath = "/config-backend"
+
+// cbParams reads the service and server tag the page is scoped to.
+func cbParams(r *http.Request) (service, tag string) {
+  service = r.FormValue("service")
+  if service != kea.ServiceDHCP6 {
+    service = kea.ServiceDHCP4
+  }
+  tag = strings.TrimSpace(r.FormValue("tag"))
+  if tag == "" {
+    tag = kea.ServerTagAll
+  }
+  return service, tag
+}
+
+// ConfigBackend shows the running configuration split by source (config
+// file or config backend) for one server tag.
+func ConfigBackend(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    service, tag := cbParams(r)
+    data := map[string]interface{}{
+      "Service": service,
+      "Tag":     tag,
+    }
+    flash(r, data)
+
+    ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+    defer cancel()
+
+    cb := client.ConfigBackend(service)
+    if servers, err := cb.Servers(ctx); err != nil {
+      data["Error"] = err.Error()
+    } else {
+      data["Servers"] = servers
+    }
+
+    if _, failed := data["Error"]; !failed {
+      items, err := cb.Sources(ctx, tag)
+      if err != nil {
+        data["Error"] = err.Error()
+      }
+      data["Items"] = items
+    }
+
+    handlers.RenderTemplate(w, r, "configbackend", handlers.PageData{
+      Title: "Config Backend",
+      Data:  data,
+    })
+  }
+}
+
+// ConfigBackendAction applies a single remote-* change posted from the
+// config backend page.
+func ConfigBackendAction(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    service, tag := cbParams(r)
+    q := url.Values{"service": {service}, "tag": {tag}}
+
+    tags := splitList(r.FormValue("server-tags"))
+    if len(tags) == 0 {
+      tags = []string{tag}
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+    defer cancel()
+
+    msg, err := applyConfigBackendAction(ctx, client.ConfigBackend(service), r, tags)
+    redirectResult(w, r, configBackendPath, q, msg, err)
+  }
+}
+
+func applyConfigBackendAction(ctx context.Context, cb *kea.ConfigBackend, r *http.Request, tags []string) (string, error) {
+  action := r.FormValue("action")
+
+  switch action {
+  case "parameter-set":
+    name := strings.TrimSpace(r.FormValue("name"))
+    if name == "" {
+      return "", errors.New("parameter name is required")
+    }
+    value, err := formJSON(r, "value")
+    if err != nil {
+      return "", err
+    }
+    return "Set " + name, cb.SetGlobalParameter(ctx, tags, name, value)
+
+  case "parameter-del":
+    name := strings.TrimSpace(r.FormValue("name"))
+    if name == "" {
+      return "", errors.New("parameter name is required")
+    }
+    return "Deleted " + name, cb.DeleteGlobalParameter(ctx, tags, name)
+
+  case "subnet-set":
+    subnet, err := formJSON(r, "json")
+    if err != nil {
+      return "", err
+    }
+    return "Subnet saved", cb.SetSubnet(ctx, tags, subnet)
+
+  case "subnet-del":
+    id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+    if err != nil {
+      return "", fmt.Errorf("invalid subnet id: %w", err)
+    }
+    return fmt.Sprintf("Deleted subnet %d", id), cb.DeleteSubnet(ctx, id)
+
+  case "network-set":
+    network, err := formJSON(r, "json")
+    if err != nil {
+      return "", err
+    }
+    return "Shared network saved", cb.SetNetwork(ctx, tags, network)
+
+  case "network-del":
+    name := strings.TrimSpace(r.FormValue("name"))
+    if name == "" {
+      return "", errors.New("shared network name is required")
+    }
+    keep := r.FormValue("keep-subnets") != ""
+    return "Deleted shared network " + name, cb.DeleteNetwork(ctx, name, keep)
+
+  case "option-def-set":
+    def, err := formJSON(r, "json")
+    if err != nil {
+      return "", err
+    }
+    return "Option definition saved", cb.SetOptionDef(ctx, tags, def)
+
+  case "option-set":
+    option, err := formJSON(r, "json")
+    if err != nil {
+      return "", err
+    }
+    return "Global option saved", cb.SetGlobalOption(ctx, tags, option)
+
+  case "server-set":
+    s := kea.RemoteServer{
+      Tag:         strings.TrimSpace(r.FormValue("name")),
+      Description: r.FormValue("description"),
+    }
+    if s.Tag == "" {
+      return "", errors.New("server tag is required")
+    }
+    return "Server " + s.Tag + " saved", cb.SetServer(ctx, s)
+  }
+
+  return "", fmt.Errorf("unknown action %q", action)
+}
+
+// formJSON returns a form field that must hold valid JSON.
+func formJSON(r *http.Request, field string) (json.RawMessage, error) {
+  raw := strings.TrimSpace(r.FormValue(field))
+  if raw == "" {
+    return nil, fmt.Errorf("%s is required", field)
+  }
+  if !json.Valid([]byte(raw)) {
+    return nil, fmt.Errorf("%s is not valid JSON", field)
+  }
+  return json.RawMessage(raw), nil
+}
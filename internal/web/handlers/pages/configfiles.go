@@ -0,0 +1,227 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const configFilesPath = "/config-files"
+
+// unitForConfFile finds the configured unit of the daemon a config file
+// belongs to: kea-dhcp4.conf maps to kea-dhcp4.service as well as to
+// Debian's kea-dhcp4-server.service.
+func unitForConfFile(cfg linux.Config, file string) string {
+  if _, daemon := kea.ConfFileService(file); !daemon {
+    return ""
+  }
+  base := strings.TrimSuffix(file, ".conf")
+  for _, u := range cfg.Units {
+    if strings.TrimSuffix(u, ".service") == base || strings.HasPrefix(u, base+"-server.") {
+      return u
+    }
+  }
+  return ""
+}
+
+// canEditConfFile reports whether the user of r may change a file: daemon
+// files need their server in scope, the others an unscoped account.
+func canEditConfFile(r *http.Request, name string) bool {
+  id, _ := auth.FromContext(r.Context())
+  service, _ := kea.ConfFileService(name)
+  return id.Can(auth.PermConfig) && id.Whole(service)
+}
+
+// canReadConfFile reports whether the user of r may see a file and its
+// versions. The files hold the database and control agent passwords, so
+// reading takes what editing does.
+func canReadConfFile(r *http.Request, name string) bool {
+  return canEditConfFile(r, name)
+}
+
+// readableConfFiles lists the files the user of r may see.
+func readableConfFiles(r *http.Request, files *kea.ConfigFiles) ([]string, error) {
+  names, err := files.List()
+  if err != nil {
+    return nil, err
+  }
+  out := []string{}
+  for _, name := range names {
+    if canReadConfFile(r, name) {
+      out = append(out, name)
+    }
+  }
+  return out, nil
+}
+
+// configFilesData fills the page data shared by the editor views.
+func configFilesData(r *http.Request, files *kea.ConfigFiles, unitCfg linux.Config, units linux.UnitManager, name string, data map[string]interface{}) (string, error) {
+  names, err := readableConfFiles(r, files)
+  if err != nil {
+    return "", err
+  }
+  data["Dir"] = files.Dir
+  data["Files"] = names
+  if name == "" {
+    if len(names) == 0 {
+      return "", fmt.Errorf("no configuration files in %s you may see", files.Dir)
+    }
+    name = names[0]
+  }
+  if !canReadConfFile(r, name) {
+    return "", fmt.Errorf("%s: %w", name, auth.ErrForbidden)
+  }
+  data["Name"] = name
+  data["Editable"] = canEditConfFile(r, name)
+  _, daemon := kea.ConfFileService(name)
+  data["Daemon"] = daemon
+  if u := unitForConfFile(unitCfg, name); u != "" && units != nil {
+    data["Unit"] = u
+  }
+  if backups, err := files.Backups(name); err == nil {
+    data["Backups"] = backups
+  }
+  return name, nil
+}
+
+// ConfigFiles shows a configuration file in an editor.
+func ConfigFiles(files *kea.ConfigFiles, unitCfg linux.Config, units linux.UnitManager) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    data := map[string]interface{}{}
+    flash(r, data)
+
+    name, err := configFilesData(r, files, unitCfg, units, r.URL.Query().Get("file"), data)
+    if err == nil {
+      var f kea.ConfigFile
+      if backup := r.URL.Query().Get("backup"); backup != "" {
+        // Viewing a backup: show it read-only.
+        var raw []byte
+        raw, err = files.ReadBackup(name, backup)
+        data["Backup"] = backup
+        data["Content"] = string(raw)
+      } else if f, err = files.Read(name); err == nil {
+        data["Content"] = string(f.Raw)
+        data["Hash"] = f.Hash
+        data["ModTime"] = formatTime(f.ModTime)
+      }
+    }
+    if err != nil {
+      utils.Error("Config files: %v", err)
+      data["Error"] = err.Error()
+    }
+
+    handlers.RenderTemplate(w, r, "configfiles", handlers.PageData{
+      Title: "Config Files",
+      Data:  data,
+    })
+  }
+}
+
+// ConfigFilesAction validates, saves or restores a configuration file.
+// Validation failures re-render the editor with the submitted text so no
+// edits are lost.
+func ConfigFilesAction(client *kea.Client, files *kea.ConfigFiles, unitCfg linux.Config, units linux.UnitManager) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+      http.Error(w, "Bad form", http.StatusBadRequest)
+      return
+    }
+    name := r.PostFormValue("file")
+    action := r.PostFormValue("action")
+    q := url.Values{"file": {name}}
+    content := strings.ReplaceAll(r.PostFormValue("content"), "\r\n", "\n")
+
+    if !canEditConfFile(r, name) {
+      redirectResult(w, r, configFilesPath, q, "", fmt.Errorf("%s: %w", name, auth.ErrForbidden))
+      return
+    }
+
+    if action == "restore" {
+      raw, err := files.ReadBackup(name, r.PostFormValue("backup"))
+      if err != nil {
+        redirectResult(w, r, configFilesPath, q, "", err)
+        return
+      }
+      content = string(raw)
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    saved := false
+    msg, err := func() (string, error) {
+      checked, err := files.Validate(name, []byte(content))
+      if err != nil {
+        return "", err
+      }
+      if r.PostFormValue("kea-test") == "on" {
+        if service, daemon := kea.ConfFileService(name); daemon {
+          elem, err := files.Element(name, []byte(content))
+          if err != nil {
+            return "", err
+          }
+          if err := client.ConfigTest(ctx, service, elem); err != nil {
+            return "", err
+          }
+        }
+      }
+
+      var notes []string
+      if len(checked) == 0 {
+        notes = append(notes, "not included by any daemon file, so not checked")
+      } else {
+        notes = append(notes, "checked "+strings.Join(checked, ", "))
+      }
+      if action == "validate" {
+        return "Valid (" + strings.Join(notes, "; ") + ")", nil
+      }
+
+      backup, err := files.Write(name, []byte(content), r.PostFormValue("hash"))
+      if err != nil {
+        return "", err
+      }
+      saved = true
+      utils.Info("Config file %s saved (backup %s)", name, backup)
+      if backup != "" {
+        notes = append(notes, "previous version saved as "+backup)
+      }
+
+      if r.PostFormValue("reload") == "on" {
+        unit := unitForConfFile(unitCfg, name)
+        if unit == "" || units == nil {
+          return "", errors.New("saved, but there is no unit to reload for " + name)
+        }
+        if err := units.Control(ctx, unit, linux.ActionReload); err != nil {
+          return "", fmt.Errorf("saved, but the reload failed: %w", err)
+        }
+        notes = append(notes, unit+" reloaded")
+      }
+      return "Saved " + name + " (" + strings.Join(notes, "; ") + ")", nil
+    }()
+
+    if err != nil && !saved && action != "restore" {
+      data := map[string]interface{}{"Error": err.Error()}
+      if _, derr := configFilesData(r, files, unitCfg, units, name, data); derr != nil {
+        data["Error"] = derr.Error()
+      }
+      data["Content"] = content
+      data["Hash"] = r.PostFormValue("hash")
+      handlers.RenderTemplate(w, r, "configfiles", handlers.PageData{
+        Title: "Config Files",
+        Data:  data,
+      })
+      return
+    }
+    redirectResult(w, r, configFilesPath, q, msg, err)
+  }
+}
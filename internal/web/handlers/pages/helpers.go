@@ -0,0 +1,82 @@
+package pages
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// uploadWindow is how long a request with an upload may take to arrive
+// and be answered. The server's ReadTimeout and WriteTimeout suit
+// ordinary requests; a large file over a slow link needs more.
+const uploadWindow = 10 * time.Minute
+
+// extendForUpload moves the read and write deadlines of the connection
+// forward by uploadWindow. Call it before reading a large body.
+func extendForUpload(w http.ResponseWriter) {
+  extendDeadlines(w, uploadWindow)
+}
+
+// extendDeadlines moves the read and write deadlines of the connection
+// forward by d, for a request that may outlast the server's timeouts.
+func extendDeadlines(w http.ResponseWriter, d time.Duration) {
+  rc := http.NewResponseController(w)
+  deadline := time.Now().Add(d)
+  for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+    if err := set(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+      utils.Debug("Connection deadline: %v", err)
+    }
+  }
+}
+
+// redirectResult sends the browser back to path after a POST, carrying
+// either err or okMsg so the page can show what happened.
+func redirectResult(w http.ResponseWriter, r *http.Request, path string, q url.Values, okMsg string, err error) {
+  if q == nil {
+    q = url.Values{}
+  }
+  if err != nil {
+    utils.Error("%s %s: %v", r.Method, r.URL.Path, err)
+    q.Set("err", err.Error())
+  } else if okMsg != "" {
+    q.Set("ok", okMsg)
+  }
+
+  target := path
+  if len(q) > 0 {
+    target += "?" + q.Encode()
+  }
+  http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// flash copies the ok/err query parameters set by redirectResult into
+// page data.
+func flash(r *http.Request, data map[string]interface{}) {
+  if msg := r.URL.Query().Get("ok"); msg != "" {
+    data["OK"] = msg
+  }
+  if msg := r.URL.Query().Get("err"); msg != "" {
+    data["Error"] = msg
+  }
+}
+
+// splitList splits a comma or whitespace separated form value.
+func splitList(s string) []string {
+  fields := strings.FieldsFunc(s, func(r rune) bool {
+    return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+  })
+  return fields
+}
+
+// familyParam reads the address family (4 or 6) from the request,
+// defaulting to 4.
+func familyParam(r *http.Request) int {
+  if r.FormValue("family") == "6" {
+    return 6
+  }
+  return 4
+}
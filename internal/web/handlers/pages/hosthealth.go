@@ -0,0 +1,46 @@
+package pages
+
+import (
+	"context"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// Used for the disk panel when the running config can't be read.
+var defaultKeaPaths = []string{"/var/lib/kea", "/var/log/kea"}
+
+// keaFilePaths returns the lease files and log files named in the
+// running DHCP configs.
+func keaFilePaths(ctx context.Context, client *kea.Client) []string {
+  var paths []string
+  for _, service := range []string{kea.ServiceDHCP4, kea.ServiceDHCP6} {
+    cfg, err := client.ConfigGet(ctx, service)
+    if err != nil {
+      utils.Debug("Host health: config-get %s: %v", service, err)
+      continue
+    }
+    if db, err := kea.LeaseDatabaseFromConfig(cfg); err == nil {
+      if f := db.LeaseFile(service); f != "" {
+        paths = append(paths, f)
+      }
+    }
+    if loggers, err := kea.LoggersFromConfig(cfg); err == nil {
+      paths = append(paths, kea.LogFiles(loggers)...)
+    }
+  }
+  if len(paths) == 0 {
+    return defaultKeaPaths
+  }
+  return paths
+}
+
+// hostHealthFor reads the host metrics, with disks for the Kea files.
+func hostHealthFor(ctx context.Context, client *kea.Client, host *linux.Host) linux.HostHealth {
+  hh := host.Health(keaFilePaths(ctx, client))
+  for _, e := range hh.Errors {
+    utils.Debug("Host health: %s", e)
+  }
+  return hh
+}
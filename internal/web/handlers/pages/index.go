@@ -1,18 +1,38 @@
 package pages
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/rogue"
 	"github.com/rannday/kea-web/internal/web/handlers"
 )
 
-func HandleIndex(w http.ResponseWriter, r *http.Request) {
-  if r.URL.Path != "/" {
-    http.NotFound(w, r)
-    return
-  }
+// Index serves the dashboard.
+func Index(client *kea.Client, db *sql.DB, host *linux.Host, detector *rogue.Detector) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+      http.NotFound(w, r)
+      return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
 
-  handlers.RenderTemplate(w, "index", handlers.PageData{
-    Title: "Kea Web",
-  })
+    handlers.RenderTemplate(w, r, "index", handlers.PageData{
+      Title: "Kea Web",
+      Data: map[string]interface{}{
+        "Leases": []leaseSummary{
+          leaseSummaryFor(ctx, db, client, 4),
+          leaseSummaryFor(ctx, db, client, 6),
+        },
+        "Host":  hostHealthFor(ctx, client, host),
+        "Rogue": detector.Findings(),
+      },
+    })
+  }
 }
@@ -0,0 +1,225 @@
+package pages
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const interfacesPath = "/interfaces"
+
+// interfaceRow is a host interface or a configured entry on the
+// interfaces-config editor.
+type interfaceRow struct {
+  linux.Interface
+  // Entry is the interfaces-config entry for the row: the configured one
+  // if there is one, otherwise the bare name.
+  Entry    string
+  Selected bool
+  Missing  bool
+  Findings []string
+}
+
+// interfacesEditor is everything the editor shows for one family.
+type interfacesEditor struct {
+  Family   int
+  Config   kea.InterfacesConfig
+  Wildcard bool
+  Rows     []interfaceRow
+  // SubnetFindings are subnets bound to an interface the host lacks.
+  SubnetFindings []string
+}
+
+// buildInterfacesEditor matches the host's interfaces with the running
+// interfaces-config and subnets.
+func buildInterfacesEditor(ifaces []linux.Interface, ic kea.InterfacesConfig, subnets []kea.Subnet, family int) interfacesEditor {
+  ed := interfacesEditor{Family: family, Config: ic}
+
+  configured := map[string]string{}
+  var order []string
+  for _, entry := range ic.Interfaces {
+    if entry == "*" {
+      ed.Wildcard = true
+      continue
+    }
+    name, _ := kea.SplitInterfaceEntry(entry)
+    if _, dup := configured[name]; !dup {
+      order = append(order, name)
+    }
+    configured[name] = entry
+  }
+
+  onHost := map[string]bool{}
+  for _, iface := range ifaces {
+    onHost[iface.Name] = true
+    row := interfaceRow{Interface: iface, Entry: iface.Name}
+    if entry, ok := configured[iface.Name]; ok {
+      row.Entry, row.Selected = entry, true
+    }
+
+    if row.Selected || ed.Wildcard {
+      if !iface.LinkUp() {
+        row.Findings = append(row.Findings, "link is down")
+      }
+      if !iface.Loopback && !interfaceInSubnet(iface, subnets, family) {
+        row.Findings = append(row.Findings, "no address in a configured subnet (only relayed traffic can be served)")
+      }
+    }
+    if _, addr := kea.SplitInterfaceEntry(row.Entry); addr != "" && row.Selected {
+      if a, err := netip.ParseAddr(addr); err != nil || !interfaceHasAddr(iface, a) {
+        row.Findings = append(row.Findings, "address "+addr+" is not on this interface")
+      }
+    }
+    ed.Rows = append(ed.Rows, row)
+  }
+
+  for _, name := range order {
+    if onHost[name] {
+      continue
+    }
+    ed.Rows = append(ed.Rows, interfaceRow{
+      Interface: linux.Interface{Name: name},
+      Entry:     configured[name],
+      Selected:  true,
+      Missing:   true,
+      Findings:  []string{"interface does not exist on this host"},
+    })
+  }
+
+  for _, s := range subnets {
+    if s.Interface != "" && !onHost[s.Interface] {
+      ed.SubnetFindings = append(ed.SubnetFindings,
+        fmt.Sprintf("subnet %d (%s) is bound to interface %s, which does not exist on this host", s.ID, s.Subnet, s.Interface))
+    }
+  }
+  return ed
+}
+
+// interfaceInSubnet reports whether one of the interface's addresses of
+// the family lies in a subnet. IPv6 link-local addresses don't count.
+func interfaceInSubnet(iface linux.Interface, subnets []kea.Subnet, family int) bool {
+  for _, p := range iface.Addrs {
+    a := p.Addr()
+    if (family == 4) != a.Is4() || a.IsLinkLocalUnicast() {
+      continue
+    }
+    for _, s := range subnets {
+      if s.Contains(a) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func interfaceHasAddr(iface linux.Interface, a netip.Addr) bool {
+  for _, p := range iface.Addrs {
+    if p.Addr() == a {
+      return true
+    }
+  }
+  return false
+}
+
+// Interfaces shows the interfaces-config editor.
+func Interfaces(client *kea.Client, host *linux.Host) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    family := familyParam(r)
+    data := map[string]interface{}{"Family": family}
+    flash(r, data)
+
+    ifaces, err := host.Interfaces()
+    if err != nil {
+      utils.Error("List interfaces: %v", err)
+      data["Error"] = "List interfaces: " + err.Error()
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
+
+    var ic kea.InterfacesConfig
+    var subnets []kea.Subnet
+    cfg, err := client.ConfigGet(ctx, familyService(family))
+    if err == nil {
+      ic, err = kea.InterfacesConfigFromConfig(cfg)
+    }
+    if err == nil {
+      subnets, err = kea.SubnetsFromConfig(cfg, familyService(family))
+    }
+    if err != nil {
+      utils.Error("Interfaces config: %v", err)
+      data["Error"] = err.Error()
+      data["ConfigUnavailable"] = true
+    }
+
+    data["Editor"] = buildInterfacesEditor(ifaces, ic, subnets, family)
+    handlers.RenderTemplate(w, r, "interfaces", handlers.PageData{
+      Title: "Interfaces",
+      Data:  data,
+    })
+  }
+}
+
+// InterfacesAction replaces interfaces-config with the submitted one,
+// after config-test, and optionally writes the config file.
+func InterfacesAction(client *kea.Client) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+      http.Error(w, "Bad form", http.StatusBadRequest)
+      return
+    }
+    family := familyParam(r)
+    service := familyService(family)
+    q := url.Values{"family": {strconv.Itoa(family)}}
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    err := func() error {
+      cfg, err := client.ConfigGet(ctx, service)
+      if err != nil {
+        return err
+      }
+      ic, err := kea.InterfacesConfigFromConfig(cfg)
+      if err != nil {
+        return err
+      }
+
+      ic.Interfaces = nil
+      seen := map[string]bool{}
+      entries := append(r.PostForm["entry"], splitList(r.PostFormValue("extra"))...)
+      for _, e := range entries {
+        if e != "" && !seen[e] {
+          seen[e] = true
+          ic.Interfaces = append(ic.Interfaces, e)
+        }
+      }
+      if family == 4 {
+        ic.DHCPSocketType = r.PostFormValue("dhcp-socket-type")
+        ic.OutboundInterface = r.PostFormValue("outbound-interface")
+      }
+      reDetect := r.PostFormValue("re-detect") == "on"
+      ic.ReDetect = &reDetect
+
+      if err := kea.SetInterfacesConfig(cfg, ic); err != nil {
+        return err
+      }
+      return client.ReplaceConfig(ctx, service, cfg, r.PostFormValue("persist") == "on")
+    }()
+    if err != nil {
+      redirectResult(w, r, interfacesPath, q, "", err)
+      return
+    }
+    utils.Info("interfaces-config of %s updated", service)
+    redirectResult(w, r, interfacesPath, q, "interfaces-config updated", nil)
+  }
+}
@@ -0,0 +1,396 @@
+package pages
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const (
+  // exportWriteWindow is how long a single write of an export may take
+  // to reach the client. The deadline rolls forward before every write
+  // to the connection, so exports may run longer than the server's
+  // WriteTimeout as long as the client keeps reading, however long the
+  // rows take to fetch in between.
+  exportWriteWindow = 30 * time.Second
+  // exportMaxDuration caps a whole export.
+  exportMaxDuration = 30 * time.Minute
+  // exportFlushEvery is the number of rows between flushes.
+  exportFlushEvery = 1000
+)
+
+// Lease export formats.
+const (
+  exportCSV    = "csv"
+  exportJSON   = "json"
+  exportNDJSON = "ndjson"
+)
+
+var leaseCSVHeader = []string{
+  "ip-address", "hw-address", "client-id", "duid", "iaid", "type", "prefix-len",
+  "subnet-id", "valid-lft", "cltt", "expires", "hostname", "state", "fqdn-fwd", "fqdn-rev",
+}
+
+// leaseExportRow is one exported lease, named like lease_cmds output.
+type leaseExportRow struct {
+  IPAddress string `json:"ip-address"`
+  HWAddress string `json:"hw-address,omitempty"`
+  ClientID  string `json:"client-id,omitempty"`
+  DUID      string `json:"duid,omitempty"`
+  IAID      int64  `json:"iaid,omitempty"`
+  Type      string `json:"type,omitempty"`
+  PrefixLen int    `json:"prefix-len,omitempty"`
+  SubnetID  int64  `json:"subnet-id"`
+  ValidLft  int64  `json:"valid-lft"`
+  CLTT      int64  `json:"cltt"`
+  Expires   string `json:"expires"`
+  Hostname  string `json:"hostname"`
+  State     string `json:"state"`
+  FQDNFwd   bool   `json:"fqdn-fwd"`
+  FQDNRev   bool   `json:"fqdn-rev"`
+}
+
+func (l leaseExportRow) csv() []string {
+  iaid := ""
+  if l.DUID != "" {
+    iaid = strconv.FormatInt(l.IAID, 10)
+  }
+  prefixLen := ""
+  if l.PrefixLen != 0 {
+    prefixLen = strconv.Itoa(l.PrefixLen)
+  }
+  return []string{
+    l.IPAddress, l.HWAddress, l.ClientID, l.DUID, iaid, l.Type, prefixLen,
+    strconv.FormatInt(l.SubnetID, 10),
+    strconv.FormatInt(l.ValidLft, 10),
+    strconv.FormatInt(l.CLTT, 10),
+    l.Expires, l.Hostname, l.State,
+    strconv.FormatBool(l.FQDNFwd),
+    strconv.FormatBool(l.FQDNRev),
+  }
+}
+
+// leaseStateName maps a lease state to its Kea name.
+func leaseStateName(state int) string {
+  if name, ok := leaseStateNames[state]; ok {
+    return name
+  }
+  return strconv.Itoa(state)
+}
+
+// parseLeaseState accepts a state name or number.
+func parseLeaseState(s string) (int, error) {
+  for code, name := range leaseStateNames {
+    if strings.EqualFold(s, name) {
+      return code, nil
+    }
+  }
+  n, err := strconv.Atoi(s)
+  if err != nil {
+    return 0, fmt.Errorf("unknown lease state %q", s)
+  }
+  return n, nil
+}
+
+var lease6Types = map[int]string{0: "IA_NA", 1: "IA_TA", 2: "IA_PD"}
+
+// leaseExportFilter is the parsed filter of an export request.
+type leaseExportFilter struct {
+  Family    int
+  SubnetID  int64
+  State     *int
+  Hostname  string
+  HWAddress string
+  // After resumes past this address, for the pages of the API.
+  After netip.Addr
+}
+
+func parseLeaseExportFilter(r *http.Request) (leaseExportFilter, error) {
+  f := leaseExportFilter{Family: 4}
+  q := r.URL.Query()
+  if q.Get("family") == "6" {
+    f.Family = 6
+  }
+  if s := q.Get("subnet-id"); s != "" {
+    id, err := strconv.ParseInt(s, 10, 64)
+    if err != nil {
+      return f, fmt.Errorf("invalid subnet-id %q", s)
+    }
+    f.SubnetID = id
+  }
+  if s := q.Get("state"); s != "" {
+    state, err := parseLeaseState(s)
+    if err != nil {
+      return f, err
+    }
+    f.State = &state
+  }
+  f.Hostname = strings.TrimSpace(q.Get("hostname"))
+  if s := strings.TrimSpace(q.Get("hw-address")); s != "" {
+    b, err := sql.ParseIdentifier(s)
+    if err != nil {
+      return f, fmt.Errorf("invalid hw-address %q", s)
+    }
+    f.HWAddress = hex.EncodeToString(b)
+  }
+  return f, nil
+}
+
+// match applies the filter to a lease read from Kea, which can't filter
+// server side.
+func (f leaseExportFilter) match(l kea.Lease) bool {
+  if f.SubnetID != 0 && l.SubnetID != f.SubnetID {
+    return false
+  }
+  if f.State != nil && l.State != *f.State {
+    return false
+  }
+  if f.Hostname != "" && !strings.Contains(strings.ToLower(l.Hostname), strings.ToLower(f.Hostname)) {
+    return false
+  }
+  if f.HWAddress != "" {
+    b, err := sql.ParseIdentifier(l.HWAddress)
+    if err != nil || hex.EncodeToString(b) != f.HWAddress {
+      return false
+    }
+  }
+  return true
+}
+
+// forEachExportLease streams leases in address order from the database
+// when one is configured, otherwise from lease_cmds.
+func forEachExportLease(ctx context.Context, client *kea.Client, db *sql.DB, f leaseExportFilter, fn func(leaseExportRow) error) error {
+  if db.Configured() {
+    sf := sql.LeaseFilter{SubnetID: f.SubnetID, State: f.State, Hostname: f.Hostname, After: f.After}
+    if f.HWAddress != "" {
+      sf.HWAddress, _ = hex.DecodeString(f.HWAddress)
+    }
+    return db.ForEachLease(ctx, f.Family, sf, func(l sql.Lease) error {
+      row := leaseExportRow{
+        IPAddress: l.Address,
+        HWAddress: l.HWAddress,
+        ClientID:  l.ClientID,
+        DUID:      l.DUID,
+        IAID:      l.IAID,
+        SubnetID:  l.SubnetID,
+        ValidLft:  l.ValidLifetime,
+        CLTT:      l.Expire.Unix() - l.ValidLifetime,
+        Expires:   l.Expire.UTC().Format(time.RFC3339),
+        Hostname:  l.Hostname,
+        State:     leaseStateName(l.State),
+        FQDNFwd:   l.FQDNFwd,
+        FQDNRev:   l.FQDNRev,
+      }
+      if f.Family == 6 {
+        row.Type = lease6Types[l.Type]
+        row.PrefixLen = l.PrefixLen
+      }
+      return fn(row)
+    })
+  }
+
+  return client.ForEachLeaseAfter(ctx, familyService(f.Family), f.After, func(l kea.Lease) error {
+    if !f.match(l) {
+      return nil
+    }
+    return fn(leaseExportRow{
+      IPAddress: l.IPAddress,
+      HWAddress: l.HWAddress,
+      ClientID:  l.ClientID,
+      DUID:      l.DUID,
+      IAID:      l.IAID,
+      Type:      l.Type,
+      PrefixLen: l.PrefixLen,
+      SubnetID:  l.SubnetID,
+      ValidLft:  l.ValidLft,
+      CLTT:      l.CLTT,
+      Expires:   l.Expires().UTC().Format(time.RFC3339),
+      Hostname:  l.Hostname,
+      State:     leaseStateName(l.State),
+      FQDNFwd:   l.FQDNFwd,
+      FQDNRev:   l.FQDNRev,
+    })
+  })
+}
+
+// Leases shows the lease export form.
+func Leases() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    handlers.RenderTemplate(w, r, "leases", handlers.PageData{
+      Title: "Leases",
+      Data: map[string]interface{}{
+        "States": leaseStateNames,
+      },
+    })
+  }
+}
+
+// LeaseExport streams the filtered lease set as CSV, JSON or NDJSON.
+// Rows are written as they are read, so memory use doesn't grow with the
+// number of leases.
+func LeaseExport(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    f, err := parseLeaseExportFilter(r)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+
+    format := r.URL.Query().Get("format")
+    var contentType string
+    switch format {
+    case "", exportCSV:
+      format, contentType = exportCSV, "text/csv; charset=utf-8"
+    case exportJSON:
+      contentType = "application/json"
+    case exportNDJSON:
+      contentType = "application/x-ndjson"
+    default:
+      http.Error(w, "format must be csv, json or ndjson", http.StatusBadRequest)
+      return
+    }
+
+    // The database is read directly, past the Kea command check.
+    id, _ := auth.FromContext(r.Context())
+    if !id.Server(familyService(f.Family)) {
+      http.Error(w, "Permission denied", http.StatusForbidden)
+      return
+    }
+
+    // The request context ends when the client goes away; the timeout
+    // stops runaway exports.
+    ctx, cancel := context.WithTimeout(r.Context(), exportMaxDuration)
+    defer cancel()
+
+    name := fmt.Sprintf("leases-v%d-%s.%s", f.Family, time.Now().Format("20060102-150405"), format)
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+    w.Header().Set("Cache-Control", "no-store")
+
+    sent := &exportWriter{w: w, rc: http.NewResponseController(w)}
+    bw := bufio.NewWriterSize(sent, 64<<10)
+    cw := csv.NewWriter(bw)
+    enc := json.NewEncoder(bw)
+
+    rows := 0
+    flush := func() error {
+      if format == exportCSV {
+        cw.Flush()
+        if err := cw.Error(); err != nil {
+          return err
+        }
+      }
+      if err := bw.Flush(); err != nil {
+        return err
+      }
+      return sent.Flush()
+    }
+
+    switch format {
+    case exportCSV:
+      _ = cw.Write(leaseCSVHeader)
+    case exportJSON:
+      _, _ = bw.WriteString("[\n")
+    }
+
+    err = forEachExportLease(ctx, client, db, f, func(l leaseExportRow) error {
+      if !id.Subnet(l.SubnetID) {
+        return nil
+      }
+      switch format {
+      case exportCSV:
+        if err := cw.Write(l.csv()); err != nil {
+          return err
+        }
+      case exportJSON:
+        if rows > 0 {
+          if _, err := bw.WriteString(","); err != nil {
+            return err
+          }
+        }
+        if err := enc.Encode(l); err != nil {
+          return err
+        }
+      case exportNDJSON:
+        if err := enc.Encode(l); err != nil {
+          return err
+        }
+      }
+
+      rows++
+      if rows%exportFlushEvery == 0 {
+        return flush()
+      }
+      return nil
+    })
+
+    if err != nil {
+      if ctx.Err() != nil {
+        utils.Info("Lease export stopped after %d rows: %v", rows, ctx.Err())
+      } else {
+        utils.Error("Lease export failed after %d rows: %v", rows, err)
+      }
+
+      if sent.n == 0 && ctx.Err() == nil {
+        // Nothing has reached the client yet, so a proper error still can.
+        w.Header().Del("Content-Disposition")
+        sent.extendDeadline()
+        http.Error(w, "Lease export failed: "+err.Error(), http.StatusBadGateway)
+        return
+      }
+      // Part of the file is out; abort the connection so the client sees
+      // a broken transfer instead of a silently truncated file.
+      panic(http.ErrAbortHandler)
+    }
+
+    if format == exportJSON {
+      _, _ = bw.WriteString("]\n")
+    }
+    if err := flush(); err != nil {
+      utils.Debug("Lease export: final flush: %v", err)
+    }
+    utils.Debug("Lease export: %d rows", rows)
+  }
+}
+
+// exportWriter passes an export to the response, moving the write
+// deadline forward before each write, and counts the bytes sent.
+type exportWriter struct {
+  w  http.ResponseWriter
+  rc *http.ResponseController
+  n  int64
+}
+
+func (e *exportWriter) extendDeadline() {
+  if err := e.rc.SetWriteDeadline(time.Now().Add(exportWriteWindow)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+    utils.Debug("Lease export: set write deadline: %v", err)
+  }
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+  e.extendDeadline()
+  n, err := e.w.Write(p)
+  e.n += int64(n)
+  return n, err
+}
+
+// Flush sends what the response has buffered to the client.
+func (e *exportWriter) Flush() error {
+  e.extendDeadline()
+  return e.rc.Flush()
+}
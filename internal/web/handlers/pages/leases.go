@@ -0,0 +1,128 @@
+package pages
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+)
+
+// Where lease counts were read from.
+const (
+  leaseSourceDatabase   = "database"
+  leaseSourceStatistics = "statistic-get-all"
+)
+
+// subnetLeaseRow is one dashboard row, whichever source it came from.
+type subnetLeaseRow struct {
+  SubnetID       int64
+  Active         int64
+  Expired        int64
+  Declined       int64
+  Reclaimed      int64
+  Capacity       int64
+  EarliestExpiry string
+  LatestExpiry   string
+}
+
+type stateCount struct {
+  State string
+  Count int64
+}
+
+// leaseSummary is the per-subnet lease picture for one address family.
+type leaseSummary struct {
+  Family int
+  Source string
+  Rows   []subnetLeaseRow
+  States []stateCount
+  Error  string
+}
+
+var leaseStateNames = map[int]string{
+  sql.LeaseStateDefault:   "default",
+  sql.LeaseStateDeclined:  "declined",
+  sql.LeaseStateReclaimed: "expired-reclaimed",
+  sql.LeaseStateReleased:  "released",
+}
+
+// leaseSummaryFor prefers the database aggregates, which survive Kea
+// restarts and work while Kea is down, and falls back to the in-memory
+// statistics of the DHCP daemon.
+func leaseSummaryFor(ctx context.Context, db *sql.DB, client *kea.Client, family int) leaseSummary {
+  out := leaseSummary{Family: family}
+
+  if db.Configured() {
+    counts, err := db.LeaseCounts(ctx, family)
+    if err == nil {
+      out.Source = leaseSourceDatabase
+      for _, c := range counts.Subnets {
+        out.Rows = append(out.Rows, subnetLeaseRow{
+          SubnetID:       c.SubnetID,
+          Active:         c.Active,
+          Expired:        c.Expired,
+          Declined:       c.Declined,
+          Reclaimed:      c.Reclaimed,
+          EarliestExpiry: formatTime(c.EarliestExpiry),
+          LatestExpiry:   formatTime(c.LatestExpiry),
+        })
+      }
+      for state, n := range counts.ByState {
+        name, ok := leaseStateNames[state]
+        if !ok {
+          name = "state " + strconv.Itoa(state)
+        }
+        out.States = append(out.States, stateCount{State: name, Count: n})
+      }
+      sort.Slice(out.States, func(i, j int) bool { return out.States[i].State < out.States[j].State })
+      return out
+    }
+    out.Error = "database: " + err.Error()
+  }
+
+  service := kea.ServiceDHCP4
+  assigned := "assigned-addresses"
+  total := "total-addresses"
+  if family == 6 {
+    service = kea.ServiceDHCP6
+    assigned = "assigned-nas"
+    total = "total-nas"
+  }
+
+  stats, err := client.StatisticGetAll(ctx, service)
+  if err != nil {
+    if out.Error != "" {
+      out.Error += "; "
+    }
+    out.Error += "statistics: " + err.Error()
+    return out
+  }
+
+  out.Source = leaseSourceStatistics
+  for _, s := range stats.BySubnet() {
+    declined := int64(s.Values["declined-addresses"])
+    active := int64(s.Values[assigned]) - declined
+    if active < 0 {
+      active = 0
+    }
+    out.Rows = append(out.Rows, subnetLeaseRow{
+      SubnetID:  s.SubnetID,
+      Active:    active,
+      Expired:   -1,
+      Declined:  declined,
+      Reclaimed: int64(s.Values["reclaimed-leases"]),
+      Capacity:  int64(s.Values[total]),
+    })
+  }
+  return out
+}
+
+func formatTime(t time.Time) string {
+  if t.IsZero() {
+    return ""
+  }
+  return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,135 @@
+package pages
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+func renderLogin(w http.ResponseWriter, r *http.Request, a *auth.Auth, data map[string]interface{}) {
+  data["NoUsers"] = a.Users.Empty() && a.OIDC == nil && a.LDAP == nil
+  data["SSO"] = a.OIDC != nil
+  handlers.RenderTemplate(w, r, "login", handlers.PageData{
+    Title: "Sign In",
+    Data:  data,
+  })
+}
+
+// Login shows the sign-in form, or goes on to the next page when the
+// browser is signed in already.
+func Login(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    next := auth.SafeNext(r.URL.Query().Get("next"))
+    if _, ok := a.Current(r); ok {
+      http.Redirect(w, r, next, http.StatusSeeOther)
+      return
+    }
+    renderLogin(w, r, a, map[string]interface{}{"Next": next})
+  }
+}
+
+// LoginAction checks the credentials and starts a session.
+func LoginAction(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    name := r.FormValue("username")
+    next := auth.SafeNext(r.FormValue("next"))
+    data := map[string]interface{}{"Next": next, "Username": name}
+
+    if a.Throttled(r) {
+      utils.Warn("Sign-in of %q from %s refused: too many failures", name, r.RemoteAddr)
+      data["Error"] = "Too many failed attempts; try again later"
+      w.WriteHeader(http.StatusTooManyRequests)
+      renderLogin(w, r, a, data)
+      return
+    }
+    id, external, err := a.Authenticate(name, r.FormValue("password"))
+    if err != nil {
+      status, msg := http.StatusUnauthorized, "Wrong user name or password"
+      switch {
+      case errors.Is(err, auth.ErrBadCredentials):
+        a.Failed(r)
+        utils.Warn("Failed sign-in of %q from %s", name, r.RemoteAddr)
+      case errors.Is(err, auth.ErrNoRole):
+        status, msg = http.StatusForbidden, "Your account has no kea-web role"
+        utils.Warn("Sign-in of %q from %s refused: %v", name, r.RemoteAddr, err)
+      default:
+        utils.Error("Sign-in of %q: %v", name, err)
+      }
+      data["Error"] = msg
+      w.WriteHeader(status)
+      renderLogin(w, r, a, data)
+      return
+    }
+    a.Succeeded(r)
+    if external {
+      if err := a.StartExternal(w, r, id.User, id.Access); err != nil {
+        utils.Warn("Sign-in of %q from %s refused: %v", name, r.RemoteAddr, err)
+        data["Error"] = "Wrong user name or password"
+        w.WriteHeader(http.StatusUnauthorized)
+        renderLogin(w, r, a, data)
+        return
+      }
+      utils.Info("%s signed in through LDAP as %s from %s", id.User, id.Access, r.RemoteAddr)
+    } else {
+      a.Start(w, r, id.User)
+      utils.Info("%s signed in from %s", id.User, r.RemoteAddr)
+    }
+    http.Redirect(w, r, next, http.StatusSeeOther)
+  }
+}
+
+// Logout ends the session.
+func Logout(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if id, ok := auth.FromContext(r.Context()); ok {
+      utils.Info("%s signed out", id.User)
+    }
+    a.End(w, r)
+    http.Redirect(w, r, "/login", http.StatusSeeOther)
+  }
+}
+
+// OIDCLogin sends the browser to the OpenID Connect provider.
+func OIDCLogin(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if a.OIDC == nil {
+      http.NotFound(w, r)
+      return
+    }
+    next := auth.SafeNext(r.URL.Query().Get("next"))
+    if err := a.OIDC.Begin(w, r, next); err != nil {
+      utils.Error("OIDC sign-in: %v", err)
+      w.WriteHeader(http.StatusBadGateway)
+      renderLogin(w, r, a, map[string]interface{}{"Next": next, "Error": "The identity provider is unreachable"})
+    }
+  }
+}
+
+// OIDCCallback finishes an OpenID Connect sign-in and starts a session
+// with the role mapped from the user's claims.
+func OIDCCallback(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if a.OIDC == nil {
+      http.NotFound(w, r)
+      return
+    }
+    user, access, next, err := a.OIDC.Finish(w, r)
+    if err != nil {
+      utils.Warn("OIDC sign-in from %s: %v", r.RemoteAddr, err)
+      w.WriteHeader(http.StatusUnauthorized)
+      renderLogin(w, r, a, map[string]interface{}{"Next": "/", "Error": "Single sign-on failed: " + err.Error()})
+      return
+    }
+    if err := a.StartExternal(w, r, user, access); err != nil {
+      utils.Warn("OIDC sign-in from %s refused: %v", r.RemoteAddr, err)
+      w.WriteHeader(http.StatusForbidden)
+      renderLogin(w, r, a, map[string]interface{}{"Next": "/", "Error": "Single sign-on failed: " + err.Error()})
+      return
+    }
+    utils.Info("%s signed in through OIDC as %s from %s", user, access, r.RemoteAddr)
+    http.Redirect(w, r, auth.SafeNext(next), http.StatusSeeOther)
+  }
+}
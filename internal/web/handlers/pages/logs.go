@@ -0,0 +1,276 @@
+package pages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const (
+  // logTailLines is how much of the end of the current file is shown.
+  logTailLines = 1000
+  // logSearchLimit is the most matches a history search keeps; older
+  // ones are dropped.
+  logSearchLimit = 2000
+  // logSearchTimeout bounds reading a file and its rotated copies.
+  logSearchTimeout = 60 * time.Second
+  // logStreamWindow is the write deadline of one streamed event, and
+  // logHeartbeat how often an idle stream sends a comment to keep
+  // proxies from closing it.
+  logStreamWindow = 30 * time.Second
+  logHeartbeat    = 15 * time.Second
+)
+
+// logFile is a file output of a Kea logger.
+type logFile struct {
+  Service string
+  Path    string
+}
+
+// keaLogFiles collects the file outputs of every daemon's loggers. The
+// viewer only ever opens these paths.
+func keaLogFiles(ctx context.Context, client *kea.Client) ([]logFile, error) {
+  var out []logFile
+  var errs []error
+  seen := map[string]bool{}
+  // "" addresses the Control Agent itself.
+  for _, service := range []string{kea.ServiceDHCP4, kea.ServiceDHCP6, kea.ServiceD2, ""} {
+    loggers, err := client.Loggers(ctx, service)
+    if err != nil {
+      errs = append(errs, err)
+      continue
+    }
+    name := service
+    if name == "" {
+      name = "ctrl-agent"
+    }
+    for _, p := range kea.LogFiles(loggers) {
+      if !seen[p] {
+        seen[p] = true
+        out = append(out, logFile{Service: name, Path: p})
+      }
+    }
+  }
+  if len(out) == 0 && len(errs) > 0 {
+    return nil, errors.Join(errs...)
+  }
+  return out, nil
+}
+
+// logParams reads the viewer's query.
+func logParams(r *http.Request) (file string, f kea.LogFilter, history bool) {
+  q := r.URL.Query()
+  f = kea.LogFilter{
+    Severity:  strings.ToUpper(q.Get("severity")),
+    Logger:    strings.TrimSpace(q.Get("logger")),
+    MessageID: strings.TrimSpace(q.Get("msgid")),
+    Text:      q.Get("q"),
+  }
+  return q.Get("file"), f, q.Get("history") == "on"
+}
+
+// pickLogFile returns file if it is one of files, the first file if
+// file is empty, and an error otherwise.
+func pickLogFile(files []logFile, file string) (string, error) {
+  if len(files) == 0 {
+    return "", errors.New("no file outputs are configured in the Kea loggers")
+  }
+  if file == "" {
+    return files[0].Path, nil
+  }
+  for _, lf := range files {
+    if lf.Path == file {
+      return file, nil
+    }
+  }
+  return "", fmt.Errorf("%s is not a configured log file", file)
+}
+
+// logRing keeps the last len(buf) lines added.
+type logRing struct {
+  buf   []kea.LogLine
+  next  int
+  total int
+  // scanned counts the lines looked at, matching or not.
+  scanned int
+}
+
+func newLogRing(size int) *logRing {
+  return &logRing{buf: make([]kea.LogLine, 0, size)}
+}
+
+func (r *logRing) add(l kea.LogLine) {
+  r.total++
+  if len(r.buf) < cap(r.buf) {
+    r.buf = append(r.buf, l)
+    return
+  }
+  r.buf[r.next] = l
+  r.next = (r.next + 1) % len(r.buf)
+}
+
+// lines returns the kept lines, oldest first.
+func (r *logRing) lines() []kea.LogLine {
+  return append(append([]kea.LogLine{}, r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// searchLogs filters the current file's tail, or with history every
+// rotated copy as well.
+func searchLogs(ctx context.Context, host *linux.Host, file string, f kea.LogFilter, history bool) (*logRing, error) {
+  ring := newLogRing(logSearchLimit)
+
+  if !history {
+    lines, err := host.TailLines(file, logTailLines)
+    if err != nil {
+      return ring, err
+    }
+    m := kea.LogMatcher{Filter: f}
+    for _, raw := range lines {
+      ring.scanned++
+      if l, ok := m.Match(raw); ok {
+        ring.add(l)
+      }
+    }
+    return ring, nil
+  }
+
+  for _, p := range host.RotatedFiles(file) {
+    m := kea.LogMatcher{Filter: f}
+    err := host.ForEachLine(ctx, p, func(raw string) error {
+      ring.scanned++
+      if l, ok := m.Match(raw); ok {
+        ring.add(l)
+      }
+      return nil
+    })
+    if err != nil {
+      return ring, fmt.Errorf("%s: %w", p, err)
+    }
+  }
+  return ring, nil
+}
+
+// Logs shows the end of a Kea log file, or searches it and its rotated
+// copies.
+func Logs(client *kea.Client, host *linux.Host) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    file, f, history := logParams(r)
+    data := map[string]interface{}{
+      "Filter":     f,
+      "History":    history,
+      "Severities": kea.LogSeverities,
+    }
+
+    // A search of the rotated files may take the whole minute, longer
+    // than the server's WriteTimeout; the page still has to be sent.
+    extendDeadlines(w, logSearchTimeout+30*time.Second)
+    ctx, cancel := context.WithTimeout(r.Context(), logSearchTimeout)
+    defer cancel()
+
+    files, err := keaLogFiles(ctx, client)
+    if err == nil {
+      data["Files"] = files
+      file, err = pickLogFile(files, file)
+    }
+    if err == nil {
+      data["File"] = file
+      var ring *logRing
+      ring, err = searchLogs(ctx, host, file, f, history)
+      data["Lines"] = ring.lines()
+      data["Matches"] = ring.total
+      data["Scanned"] = ring.scanned
+      data["Truncated"] = ring.total > len(ring.buf)
+    }
+    if err != nil {
+      utils.Error("Logs: %v", err)
+      data["Error"] = err.Error()
+    }
+
+    handlers.RenderTemplate(w, r, "logs", handlers.PageData{
+      Title: "Logs",
+      Data:  data,
+    })
+  }
+}
+
+// LogStream sends lines appended to a log file as server-sent events,
+// one JSON-encoded kea.LogLine per event.
+func LogStream(client *kea.Client, host *linux.Host) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    file, f, _ := logParams(r)
+
+    lookup, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    files, err := keaLogFiles(lookup, client)
+    cancel()
+    if err == nil {
+      file, err = pickLogFile(files, file)
+    }
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+
+    ctx := r.Context()
+    lines := make(chan string, 64)
+    followErr := make(chan error, 1)
+    go func() { followErr <- host.Follow(ctx, file, lines) }()
+
+    rc := http.NewResponseController(w)
+    send := func(s string) error {
+      if err := rc.SetWriteDeadline(time.Now().Add(logStreamWindow)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+        return err
+      }
+      if _, err := w.Write([]byte(s)); err != nil {
+        return err
+      }
+      return rc.Flush()
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Accel-Buffering", "no")
+    if err := send(": following " + file + "\n\n"); err != nil {
+      return
+    }
+
+    m := kea.LogMatcher{Filter: f}
+    heartbeat := time.NewTicker(logHeartbeat)
+    defer heartbeat.Stop()
+    for {
+      var err error
+      select {
+      case raw := <-lines:
+        l, ok := m.Match(raw)
+        if !ok {
+          continue
+        }
+        b, _ := json.Marshal(l)
+        err = send("data: " + string(b) + "\n\n")
+      case <-heartbeat.C:
+        err = send(": ping\n\n")
+      case ferr := <-followErr:
+        if ferr != nil && ctx.Err() == nil {
+          utils.Error("Follow %s: %v", file, ferr)
+          b, _ := json.Marshal(ferr.Error())
+          _ = send("event: error\ndata: " + string(b) + "\n\n")
+        }
+        return
+      case <-ctx.Done():
+        return
+      }
+      if err != nil {
+        utils.Debug("Log stream %s: %v", file, err)
+        return
+      }
+    }
+  }
+}
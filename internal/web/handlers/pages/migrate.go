@@ -0,0 +1,157 @@
+package pages
+
+import (
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/rannday/kea-web/internal/migrate"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// maxMigrateSize caps uploaded dhcpd.conf and dhcpd.leases files.
+const maxMigrateSize = 64 << 20
+
+// migrateBackends are the lease backends offered for the conversion.
+var migrateBackends = []string{migrate.BackendMemfile, migrate.BackendMySQL, migrate.BackendPostgreSQL}
+
+// Migrate shows the upload form of the ISC dhcpd converter.
+func Migrate() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    handlers.RenderTemplate(w, r, "migrate", handlers.PageData{
+      Title: "dhcpd Migration",
+      Data: map[string]interface{}{
+        "Backends": migrateBackends,
+        "Backend":  migrate.BackendMemfile,
+      },
+    })
+  }
+}
+
+// readUpload returns the content of an uploaded file, "" if none was
+// chosen.
+func readUpload(r *http.Request, field string) (string, string, error) {
+  file, header, err := r.FormFile(field)
+  if err == http.ErrMissingFile {
+    return "", "", nil
+  }
+  if err != nil {
+    return "", "", err
+  }
+  defer file.Close()
+  b, err := io.ReadAll(file)
+  return string(b), header.Filename, err
+}
+
+// MigrateAction converts an uploaded dhcpd.conf, and optionally its
+// dhcpd.leases. The output field picks the report page, the Dhcp4
+// configuration or the leases in the backend's format as a download.
+func MigrateAction() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    extendForUpload(w)
+    r.Body = http.MaxBytesReader(w, r.Body, maxMigrateSize)
+    if err := r.ParseMultipartForm(32 << 20); err != nil {
+      http.Error(w, "Upload too large or malformed", http.StatusBadRequest)
+      return
+    }
+    defer r.MultipartForm.RemoveAll()
+
+    backend := r.FormValue("backend")
+    if backend != migrate.BackendMySQL && backend != migrate.BackendPostgreSQL {
+      backend = migrate.BackendMemfile
+    }
+    data := map[string]interface{}{"Backends": migrateBackends, "Backend": backend}
+    render := func() {
+      handlers.RenderTemplate(w, r, "migrate", handlers.PageData{
+        Title: "dhcpd Migration",
+        Data:  data,
+      })
+    }
+
+    conf, confName, err := readUpload(r, "conf")
+    if err != nil || conf == "" {
+      data["Error"] = "Choose a dhcpd.conf file"
+      render()
+      return
+    }
+    leases, leasesName, err := readUpload(r, "leases")
+    if err != nil {
+      data["Error"] = "Reading the lease file: " + err.Error()
+      render()
+      return
+    }
+
+    res, err := migrate.Convert(conf, migrate.Options{LeaseBackend: backend})
+    if err != nil {
+      data["Error"] = confName + ": " + err.Error()
+      render()
+      return
+    }
+    config, err := res.JSON()
+    if err != nil {
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+
+    var imp *migrate.LeaseImport
+    if leases != "" {
+      if imp, err = migrate.ImportLeases(leases, res.Config); err != nil {
+        data["Error"] = leasesName + ": " + err.Error()
+        render()
+        return
+      }
+    }
+
+    switch r.FormValue("output") {
+    case "config":
+      w.Header().Set("Content-Type", "application/json")
+      w.Header().Set("Content-Disposition", `attachment; filename="kea-dhcp4.conf"`)
+      w.Write(config)
+      return
+    case "leases":
+      if imp == nil {
+        data["Error"] = "Choose a dhcpd.leases file to import"
+        render()
+        return
+      }
+      name, contentType := "kea-leases4.csv", "text/csv; charset=utf-8"
+      if backend != migrate.BackendMemfile {
+        name, contentType = "kea-leases4-"+backend+".sql", "application/sql"
+      }
+      w.Header().Set("Content-Type", contentType)
+      w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+      if err := migrate.WriteLeases(w, backend, imp.Leases); err != nil {
+        utils.Error("Writing migrated leases: %v", err)
+      }
+      return
+    }
+
+    utils.Info("Converted %s: %d findings", confName, len(res.Findings))
+    data["ConfName"] = confName
+    data["Result"] = res
+    data["Summary"] = res.Summary()
+    data["Config"] = string(config)
+    data["Counts"] = map[string]int{
+      migrate.Untranslated: res.Count(migrate.Untranslated),
+      migrate.Approximate:  res.Count(migrate.Approximate),
+      migrate.Review:       res.Count(migrate.Review),
+    }
+    if imp != nil {
+      type skipped struct {
+        Reason string
+        Count  int
+      }
+      var skips []skipped
+      for reason, n := range imp.Skipped {
+        skips = append(skips, skipped{reason, n})
+      }
+      sort.Slice(skips, func(i, j int) bool { return skips[i].Reason < skips[j].Reason })
+      data["LeasesName"] = leasesName
+      data["Leases"] = imp
+      data["Skipped"] = skips
+    }
+    render()
+  }
+}
@@ -0,0 +1,412 @@
+package pages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// apiParam is a path or query parameter of an operation.
+type apiParam struct {
+  Name        string
+  In          string
+  Description string
+  Type        string
+}
+
+// apiOperation describes one endpoint of the JSON API. The schemas of
+// the OpenAPI document are derived from Body and Data, the types the
+// handlers decode and encode, so the document follows them.
+type apiOperation struct {
+  Method  string
+  Path    string
+  Tag     string
+  Summary string
+  // Perm is the permission the operation needs beyond reading.
+  Perm   auth.Permission
+  Params []apiParam
+  // Body is a value of the request body type, nil for none.
+  Body any
+  // Status is the success status, 200 when zero.
+  Status int
+  // Data is a value of the type answered in the data field, nil for
+  // an empty response.
+  Data any
+  // List marks paginated collections of Data, paged by offset unless
+  // Cursor is set.
+  List   bool
+  Cursor bool
+  // Tagged marks responses with an ETag; IfMatch updates that require
+  // one.
+  Tagged  bool
+  IfMatch bool
+}
+
+var (
+  paramService     = apiParam{"service", "query", "DHCP server: dhcp4 (the default) or dhcp6.", "string"}
+  paramServicePath = apiParam{"service", "path", "DHCP server: dhcp4 or dhcp6.", "string"}
+  paramSubnetID    = apiParam{"subnet-id", "query", "Only this subnet.", "integer"}
+  paramFileName    = apiParam{"name", "path", "File name in the configuration directory.", "string"}
+  reservationPath  = []apiParam{
+    paramServicePath,
+    {"subnet", "path", "Subnet ID.", "integer"},
+    {"type", "path", "Identifier type: " + strings.Join(kea.IdentifierTypes, ", ") + ".", "string"},
+    {"identifier", "path", "Identifier value, such as aa:bb:cc:dd:ee:ff.", "string"},
+  }
+)
+
+// apiOperations are the endpoints under /api/v1. routes.go mounts each
+// one; the tests call them all and check the answers against the
+// document.
+var apiOperations = []apiOperation{
+  {Method: "GET", Path: "/api/v1/health", Tag: "Status", Summary: "Kea daemons, lease database and host health", Data: apiHealth{}},
+  {Method: "GET", Path: "/api/v1/ha", Tag: "Status", Summary: "High-availability state of the DHCP servers", Data: []apiHAStatus{}},
+
+  {Method: "GET", Path: "/api/v1/subnets", Tag: "Subnets", Summary: "List subnets", List: true, Data: apiSubnet{},
+    Params: []apiParam{paramService, paramSubnetID, {"shared-network", "query", "Only subnets of this shared network; empty for those outside any.", "string"}}},
+  {Method: "GET", Path: "/api/v1/subnets/{service}/{id}", Tag: "Subnets", Summary: "Get a subnet", Data: apiSubnet{}, Tagged: true,
+    Params: []apiParam{paramServicePath, {"id", "path", "Subnet ID.", "integer"}}},
+  {Method: "GET", Path: "/api/v1/pools", Tag: "Subnets", Summary: "List address and prefix delegation pools", List: true, Data: apiPool{},
+    Params: []apiParam{paramService, paramSubnetID, {"shared-network", "query", "Only pools of this shared network.", "string"}}},
+  {Method: "GET", Path: "/api/v1/statistics", Tag: "Subnets", Summary: "Per-subnet statistics", List: true, Data: apiSubnetStats{},
+    Params: []apiParam{paramService, paramSubnetID}},
+
+  {Method: "GET", Path: "/api/v1/leases", Tag: "Leases", Summary: "List leases in address order; pages have no total", List: true, Cursor: true, Data: leaseExportRow{},
+    Params: []apiParam{
+      paramService, paramSubnetID,
+      {"state", "query", "Lease state name or number.", "string"},
+      {"hostname", "query", "Hostname contains this.", "string"},
+      {"hw-address", "query", "Hardware address.", "string"},
+    }},
+
+  {Method: "GET", Path: "/api/v1/reservations", Tag: "Reservations", Summary: "List reservations", List: true, Data: kea.Reservation{},
+    Params: []apiParam{
+      paramService, paramSubnetID,
+      {"hostname", "query", "Hostname contains this.", "string"},
+      {"ip-address", "query", "Reserves this address.", "string"},
+      {"identifier", "query", "Identifier value.", "string"},
+    }},
+  {Method: "POST", Path: "/api/v1/reservations", Tag: "Reservations", Summary: "Add a reservation", Perm: auth.PermLeases,
+    Params: []apiParam{paramService}, Body: kea.Reservation{}, Status: http.StatusCreated, Data: kea.Reservation{}, Tagged: true},
+  {Method: "GET", Path: "/api/v1/reservations/{service}/{subnet}/{type}/{identifier}", Tag: "Reservations", Summary: "Get a reservation",
+    Params: reservationPath, Data: kea.Reservation{}, Tagged: true},
+  {Method: "PUT", Path: "/api/v1/reservations/{service}/{subnet}/{type}/{identifier}", Tag: "Reservations", Summary: "Change the addresses, hostname and options of a reservation", Perm: auth.PermLeases,
+    Params: reservationPath, Body: kea.Reservation{}, Data: kea.Reservation{}, Tagged: true, IfMatch: true},
+  {Method: "DELETE", Path: "/api/v1/reservations/{service}/{subnet}/{type}/{identifier}", Tag: "Reservations", Summary: "Delete a reservation; If-Match is checked when sent", Perm: auth.PermLeases,
+    Params: reservationPath, Status: http.StatusNoContent},
+
+  {Method: "GET", Path: "/api/v1/config/{service}", Tag: "Configuration", Summary: "Running configuration, the element under Dhcp4 or Dhcp6",
+    Params: []apiParam{paramServicePath}, Data: map[string]json.RawMessage{}, Tagged: true},
+  {Method: "PUT", Path: "/api/v1/config/{service}", Tag: "Configuration", Summary: "Test and apply a whole configuration element", Perm: auth.PermConfig,
+    Params: []apiParam{paramServicePath, {"persist", "query", "Also write it to the daemon's file.", "boolean"}},
+    Body:   map[string]json.RawMessage{}, Data: map[string]json.RawMessage{}, Tagged: true, IfMatch: true},
+  {Method: "GET", Path: "/api/v1/config-files", Tag: "Configuration", Summary: "List configuration files", Perm: auth.PermConfig, List: true, Data: apiConfigFile{}},
+  {Method: "GET", Path: "/api/v1/config-files/{name}", Tag: "Configuration", Summary: "Get a configuration file", Perm: auth.PermConfig,
+    Params: []apiParam{paramFileName}, Data: apiConfigFile{}, Tagged: true},
+  {Method: "PUT", Path: "/api/v1/config-files/{name}", Tag: "Configuration", Summary: "Validate and save a configuration file", Perm: auth.PermConfig,
+    Params: []apiParam{paramFileName}, Body: apiConfigFileUpdate{}, Data: apiConfigFile{}, Tagged: true, IfMatch: true},
+  {Method: "GET", Path: "/api/v1/config-files/{name}/versions", Tag: "Configuration", Summary: "List the saved versions of a file, newest first", Perm: auth.PermConfig,
+    Params: []apiParam{paramFileName}, List: true, Data: apiConfigVersion{}},
+  {Method: "GET", Path: "/api/v1/config-files/{name}/versions/{version}", Tag: "Configuration", Summary: "Get a saved version of a file", Perm: auth.PermConfig,
+    Params: []apiParam{paramFileName, {"version", "path", "Version name as listed.", "string"}}, Data: apiConfigVersion{}, Tagged: true},
+}
+
+// openAPISchemas builds JSON Schemas of Go types the way encoding/json
+// marshals them.
+type openAPISchemas struct {
+  seen map[reflect.Type]bool
+}
+
+var (
+  timeType = reflect.TypeFor[time.Time]()
+  rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+func (s *openAPISchemas) schema(t reflect.Type) map[string]any {
+  switch t {
+  case timeType:
+    return map[string]any{"type": "string", "format": "date-time"}
+  case rawType:
+    return map[string]any{}
+  }
+  switch t.Kind() {
+  case reflect.Pointer:
+    return s.schema(t.Elem())
+  case reflect.Bool:
+    return map[string]any{"type": "boolean"}
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return map[string]any{"type": "integer"}
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return map[string]any{"type": "integer", "minimum": 0}
+  case reflect.Float32, reflect.Float64:
+    return map[string]any{"type": "number"}
+  case reflect.String:
+    return map[string]any{"type": "string"}
+  case reflect.Slice:
+    return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+  case reflect.Array:
+    return map[string]any{"type": "array", "items": s.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+  case reflect.Map:
+    return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+  case reflect.Struct:
+    if s.seen[t] {
+      // Recursive types end in an unconstrained object.
+      return map[string]any{"type": "object"}
+    }
+    s.seen[t] = true
+    defer delete(s.seen, t)
+    props, required := map[string]any{}, []string{}
+    s.fields(t, props, &required)
+    out := map[string]any{"type": "object", "properties": props}
+    if len(required) > 0 {
+      out["required"] = required
+    }
+    return out
+  }
+  return map[string]any{}
+}
+
+// fields adds the JSON fields of struct t to props, promoting the
+// fields of embedded structs unless an outer field has the name.
+func (s *openAPISchemas) fields(t reflect.Type, props map[string]any, required *[]string) {
+  var embedded []reflect.Type
+  for i := range t.NumField() {
+    f := t.Field(i)
+    tag := f.Tag.Get("json")
+    if tag == "-" {
+      continue
+    }
+    name, opts, _ := strings.Cut(tag, ",")
+    if f.Anonymous && name == "" {
+      et := f.Type
+      if et.Kind() == reflect.Pointer {
+        et = et.Elem()
+      }
+      if et.Kind() == reflect.Struct {
+        embedded = append(embedded, et)
+        continue
+      }
+    }
+    if !f.IsExported() {
+      continue
+    }
+    if name == "" {
+      name = f.Name
+    }
+    if _, ok := props[name]; ok {
+      continue
+    }
+    props[name] = s.schema(f.Type)
+    if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") && f.Type.Kind() != reflect.Pointer {
+      *required = append(*required, name)
+    }
+  }
+  for _, et := range embedded {
+    s.fields(et, props, required)
+  }
+}
+
+func schemaOf(v any) map[string]any {
+  s := &openAPISchemas{seen: map[reflect.Type]bool{}}
+  return s.schema(reflect.TypeOf(v))
+}
+
+func openAPIRef(name string) map[string]any {
+  return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIJSON(schema map[string]any) map[string]any {
+  return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// openAPIDocument builds the OpenAPI 3.1 document of apiOperations.
+func openAPIDocument() map[string]any {
+  errorResponse := func(desc string) map[string]any {
+    return map[string]any{"description": desc, "content": openAPIJSON(openAPIRef("Error"))}
+  }
+  etagHeader := map[string]any{"ETag": map[string]any{"description": "Entity tag to send back in If-Match.", "schema": map[string]any{"type": "string"}}}
+
+  paths := map[string]any{}
+  for _, op := range apiOperations {
+    var params []any
+    for _, p := range op.Params {
+      params = append(params, map[string]any{
+        "name": p.Name, "in": p.In, "description": p.Description,
+        "required": p.In == "path", "schema": map[string]any{"type": p.Type},
+      })
+    }
+    if op.List {
+      paging := "offset"
+      if op.Cursor {
+        paging = "after"
+      }
+      params = append(params,
+        map[string]any{"$ref": "#/components/parameters/limit"},
+        map[string]any{"$ref": "#/components/parameters/" + paging})
+    }
+    if op.Method == "PUT" || op.Method == "DELETE" {
+      params = append(params, map[string]any{
+        "name": "If-Match", "in": "header", "required": op.IfMatch,
+        "description": "ETag of the resource as last read, compared strongly: weak tags and * never match.", "schema": map[string]any{"type": "string"},
+      })
+    }
+    if op.Method == "GET" && op.Tagged {
+      params = append(params, map[string]any{
+        "name": "If-None-Match", "in": "header", "description": "Answer 304 when the ETag still matches.", "schema": map[string]any{"type": "string"},
+      })
+    }
+
+    status := op.Status
+    if status == 0 {
+      status = http.StatusOK
+    }
+    ok := map[string]any{"description": http.StatusText(status)}
+    if op.Data != nil {
+      data := schemaOf(op.Data)
+      if op.List {
+        data = map[string]any{"type": "array", "items": data}
+      }
+      props := map[string]any{"data": data}
+      required := []string{"data"}
+      if op.List {
+        props["pagination"] = openAPIRef("Pagination")
+        required = append(required, "pagination")
+      }
+      ok["content"] = openAPIJSON(map[string]any{"type": "object", "properties": props, "required": required})
+    }
+    if op.Tagged {
+      ok["headers"] = etagHeader
+    }
+    if status == http.StatusCreated {
+      ok["headers"] = map[string]any{
+        "ETag":     etagHeader["ETag"],
+        "Location": map[string]any{"description": "URL of the new resource.", "schema": map[string]any{"type": "string"}},
+      }
+    }
+    responses := map[string]any{
+      strconv.Itoa(status): ok,
+      "400":                errorResponse("Invalid parameters or body"),
+      "401":                errorResponse("No session or API token"),
+      "403":                errorResponse("Outside the caller's role, servers or subnets"),
+      "502":                errorResponse("Kea or the database failed"),
+    }
+    if op.Method == "GET" && op.Tagged {
+      responses["304"] = map[string]any{"description": "Not modified"}
+    }
+    if strings.Contains(op.Path, "{") {
+      responses["404"] = errorResponse("No such resource")
+    }
+    if op.Method != "GET" {
+      responses["409"] = errorResponse("Conflicts with another resource")
+      responses["412"] = errorResponse("The resource changed since it was read")
+      responses["422"] = errorResponse("Rejected by validation or by Kea")
+    }
+    if op.IfMatch {
+      responses["428"] = errorResponse("If-Match is missing")
+    }
+
+    o := map[string]any{
+      "operationId": openAPIOperationID(op),
+      "summary":     op.Summary,
+      "tags":        []string{op.Tag},
+      "responses":   responses,
+    }
+    if op.Perm != "" {
+      o["description"] = fmt.Sprintf("Needs the %s permission.", op.Perm)
+    }
+    if len(params) > 0 {
+      o["parameters"] = params
+    }
+    if op.Body != nil {
+      o["requestBody"] = map[string]any{"required": true, "content": openAPIJSON(schemaOf(op.Body))}
+    }
+    item, _ := paths[op.Path].(map[string]any)
+    if item == nil {
+      item = map[string]any{}
+      paths[op.Path] = item
+    }
+    item[strings.ToLower(op.Method)] = o
+  }
+
+  return map[string]any{
+    "openapi": "3.1.0",
+    "info": map[string]any{
+      "title":       "kea-web API",
+      "version":     "1",
+      "description": "JSON access to the Kea servers kea-web manages. Authenticate with a session cookie or an API token. With a session, changes must come from a kea-web page or send the session's CSRF token in X-CSRF-Token.",
+    },
+    "servers":  []any{map[string]any{"url": "/"}},
+    "security": []any{map[string]any{"bearer": []string{}}, map[string]any{"session": []string{}}},
+    "paths":    paths,
+    "components": map[string]any{
+      "schemas": map[string]any{
+        "Error":      schemaOf(apiError{}),
+        "Pagination": schemaOf(apiPage{}),
+      },
+      "parameters": map[string]any{
+        "limit": map[string]any{"name": "limit", "in": "query", "description": "Page size.",
+          "schema": map[string]any{"type": "integer", "minimum": 1, "maximum": apiMaxLimit, "default": apiDefaultLimit}},
+        "offset": map[string]any{"name": "offset", "in": "query", "description": "Items to skip.",
+          "schema": map[string]any{"type": "integer", "minimum": 0, "default": 0}},
+        "after": map[string]any{"name": "after", "in": "query", "description": "The next value of the previous page; the first page when left out.",
+          "schema": map[string]any{"type": "string"}},
+      },
+      "securitySchemes": map[string]any{
+        "bearer":  map[string]any{"type": "http", "scheme": "bearer", "description": "An API token from the API Tokens page."},
+        "session": map[string]any{"type": "apiKey", "in": "cookie", "name": auth.CookieName},
+      },
+    },
+  }
+}
+
+// openAPIOperationID names an operation after its method and path:
+// GET /api/v1/config-files/{name} is getConfigFilesName.
+func openAPIOperationID(op apiOperation) string {
+  id := strings.ToLower(op.Method)
+  for _, seg := range strings.Split(strings.TrimPrefix(op.Path, "/api/v1/"), "/") {
+    seg = strings.Trim(seg, "{}")
+    for _, word := range strings.Split(seg, "-") {
+      if word != "" {
+        id += strings.ToUpper(word[:1]) + word[1:]
+      }
+    }
+  }
+  return id
+}
+
+var openAPIBytes = sync.OnceValue(func() []byte {
+  b, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+  if err != nil {
+    utils.Fatal("OpenAPI document: %v", err)
+  }
+  return b
+})
+
+// OpenAPISpec serves the OpenAPI document.
+func OpenAPISpec() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    _, _ = w.Write(openAPIBytes())
+  }
+}
+
+// APIDocs shows the interactive API reference; the script of the asset
+// bundle renders it from the OpenAPI document.
+func APIDocs() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    handlers.RenderTemplate(w, r, "apidocs", handlers.PageData{
+      Title: "API Reference",
+      Data:  map[string]interface{}{"Spec": "/api/openapi.json"},
+    })
+  }
+}
@@ -0,0 +1,405 @@
+package pages
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const reservationAuditPath = "/reservations/audit"
+
+// Where an audited reservation is stored.
+const (
+  reservationSourceHostsTable = "hosts table"
+  reservationSourceHostCmds   = "host backend"
+  reservationSourceConfig     = "config file"
+)
+
+// auditRow is one reservation with the problems found for it.
+type auditRow struct {
+  Key            string
+  Source         string
+  SubnetID       int64
+  IdentifierType string
+  Identifier     string
+  Address        string
+  Hostname       string
+  LastSeen       string
+  Findings       []string
+  Deletable      bool
+}
+
+// reservationAudit is the result of auditing one address family.
+type reservationAudit struct {
+  Family  int
+  Days    int
+  Checked int
+  Rows    []auditRow
+  // CanDelete is set when at least one row may be deleted.
+  CanDelete bool
+}
+
+// auditParams reads the family and unused-days threshold from the query.
+func auditParams(r *http.Request) (family, days int) {
+  family = familyParam(r)
+  days, err := strconv.Atoi(r.FormValue("days"))
+  if err != nil || days <= 0 {
+    days = 30
+  }
+  return family, days
+}
+
+func familyService(family int) string {
+  if family == 6 {
+    return kea.ServiceDHCP6
+  }
+  return kea.ServiceDHCP4
+}
+
+// runReservationAudit compares reservations with the running config and
+// the lease set. Only reservations with at least one finding are kept.
+func runReservationAudit(ctx context.Context, client *kea.Client, db *sql.DB, family, days int) (reservationAudit, error) {
+  audit := reservationAudit{Family: family, Days: days}
+  service := familyService(family)
+
+  subnets, err := client.Subnets(ctx, service)
+  if err != nil {
+    return audit, fmt.Errorf("read subnets: %w", err)
+  }
+  byID := map[int64]kea.Subnet{}
+  for _, s := range subnets {
+    byID[s.ID] = s
+  }
+
+  rows, err := auditReservations(ctx, client, db, family, subnets)
+  if err != nil {
+    return audit, err
+  }
+  audit.Checked = len(rows)
+
+  lastSeen, err := reservedLastSeen(ctx, client, db, family, rows)
+  if err != nil {
+    return audit, fmt.Errorf("read leases: %w", err)
+  }
+
+  // Identifiers reserved in more than one subnet.
+  subnetsByIdentifier := map[string]map[int64]bool{}
+  for _, row := range rows {
+    k := row.IdentifierType + "=" + strings.ToLower(row.Identifier)
+    if subnetsByIdentifier[k] == nil {
+      subnetsByIdentifier[k] = map[int64]bool{}
+    }
+    subnetsByIdentifier[k][row.SubnetID] = true
+  }
+
+  id, _ := auth.FromContext(ctx)
+  cutoff := time.Now().AddDate(0, 0, -days)
+  for _, row := range rows {
+    if !id.Subnet(row.SubnetID) {
+      continue
+    }
+    row.Deletable = row.Deletable && id.Allows(auth.PermLeases, service, row.SubnetID)
+    subnet, exists := byID[row.SubnetID]
+    if !exists && row.SubnetID != 0 {
+      row.Findings = append(row.Findings, fmt.Sprintf("subnet %d does not exist", row.SubnetID))
+    }
+
+    if row.Address != "" {
+      addr, err := netip.ParseAddr(row.Address)
+      switch {
+      case err != nil:
+        row.Findings = append(row.Findings, "invalid address")
+      case exists && !subnet.Contains(addr):
+        row.Findings = append(row.Findings, "address outside subnet "+subnet.Subnet)
+      }
+
+      seen, ok := lastSeen[row.Address]
+      switch {
+      case ok:
+        row.LastSeen = formatTime(seen)
+        if seen.Before(cutoff) {
+          row.Findings = append(row.Findings, fmt.Sprintf("no lease in %d days", days))
+        }
+      default:
+        row.Findings = append(row.Findings, "no lease")
+      }
+    }
+
+    k := row.IdentifierType + "=" + strings.ToLower(row.Identifier)
+    if n := len(subnetsByIdentifier[k]); n > 1 {
+      row.Findings = append(row.Findings, fmt.Sprintf("identifier reserved in %d subnets", n))
+    }
+
+    if len(row.Findings) > 0 {
+      audit.Rows = append(audit.Rows, row)
+      audit.CanDelete = audit.CanDelete || row.Deletable
+    }
+  }
+
+  sort.SliceStable(audit.Rows, func(i, j int) bool { return audit.Rows[i].SubnetID < audit.Rows[j].SubnetID })
+  return audit, nil
+}
+
+// auditReservations collects reservations from the hosts table (DHCPv4
+// with a database) or host_cmds, plus those in the configuration file.
+func auditReservations(ctx context.Context, client *kea.Client, db *sql.DB, family int, subnets []kea.Subnet) ([]auditRow, error) {
+  var rows []auditRow
+
+  if family == 4 && db.Configured() {
+    hosts, err := db.Hosts4(ctx)
+    if err != nil {
+      return nil, err
+    }
+    for _, h := range hosts {
+      rows = append(rows, auditRow{
+        Key:            "db|" + strconv.FormatInt(h.HostID, 10),
+        Source:         reservationSourceHostsTable,
+        SubnetID:       h.SubnetID,
+        IdentifierType: h.IdentifierType,
+        Identifier:     h.Identifier,
+        Address:        h.IPv4Address,
+        Hostname:       h.Hostname,
+        Deletable:      true,
+      })
+    }
+  } else {
+    hosts, err := client.ReservationsAll(ctx, familyService(family))
+    if err != nil {
+      return nil, fmt.Errorf("read reservations: %w", err)
+    }
+    for _, h := range hosts {
+      rows = append(rows, reservationAuditRows(h, h.SubnetID, reservationSourceHostCmds)...)
+    }
+  }
+
+  for _, s := range subnets {
+    for _, h := range s.Reservations {
+      rows = append(rows, reservationAuditRows(h, s.ID, reservationSourceConfig)...)
+    }
+  }
+  return rows, nil
+}
+
+// reservationAuditRows turns a reservation into one row per address, or
+// a single row if it reserves none.
+func reservationAuditRows(h kea.Reservation, subnetID int64, source string) []auditRow {
+  idType, id := h.Identifier()
+  base := auditRow{
+    Source:         source,
+    SubnetID:       subnetID,
+    IdentifierType: idType,
+    Identifier:     id,
+    Hostname:       h.Hostname,
+  }
+  if source == reservationSourceHostCmds {
+    base.Key = strings.Join([]string{"cmd", strconv.FormatInt(subnetID, 10), idType, id}, "|")
+    base.Deletable = true
+  }
+
+  addrs := h.Addresses()
+  if len(addrs) == 0 {
+    return []auditRow{base}
+  }
+  out := make([]auditRow, 0, len(addrs))
+  for _, a := range addrs {
+    row := base
+    row.Address = a
+    out = append(out, row)
+  }
+  return out
+}
+
+// reservedLastSeen maps reserved addresses to the last time a client
+// used them.
+func reservedLastSeen(ctx context.Context, client *kea.Client, db *sql.DB, family int, rows []auditRow) (map[string]time.Time, error) {
+  if family == 4 && db.Configured() {
+    return db.ReservedLastSeen4(ctx)
+  }
+
+  reserved := map[string]bool{}
+  for _, row := range rows {
+    if row.Address != "" {
+      reserved[row.Address] = true
+    }
+  }
+
+  out := map[string]time.Time{}
+  err := client.ForEachLease(ctx, familyService(family), func(l kea.Lease) error {
+    if reserved[l.IPAddress] {
+      if seen := l.LastSeen(); seen.After(out[l.IPAddress]) {
+        out[l.IPAddress] = seen
+      }
+    }
+    return nil
+  })
+  return out, err
+}
+
+// ReservationAudit shows reservations that look orphaned or stale.
+func ReservationAudit(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    family, days := auditParams(r)
+    data := map[string]interface{}{"Family": family, "Days": days}
+    flash(r, data)
+
+    ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+    defer cancel()
+
+    audit, err := runReservationAudit(ctx, client, db, family, days)
+    if err != nil {
+      utils.Error("Reservation audit: %v", err)
+      data["Error"] = err.Error()
+    }
+    data["Audit"] = audit
+
+    handlers.RenderTemplate(w, r, "reservationaudit", handlers.PageData{
+      Title: "Reservation Audit",
+      Data:  data,
+    })
+  }
+}
+
+// ReservationAuditCSV downloads the audit report as CSV.
+func ReservationAuditCSV(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    family, days := auditParams(r)
+
+    ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+    defer cancel()
+
+    audit, err := runReservationAudit(ctx, client, db, family, days)
+    if err != nil {
+      utils.Error("Reservation audit: %v", err)
+      http.Error(w, err.Error(), http.StatusBadGateway)
+      return
+    }
+
+    name := fmt.Sprintf("reservation-audit-v%d-%s.csv", family, time.Now().Format("20060102"))
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+    cw := csv.NewWriter(w)
+    _ = cw.Write([]string{"source", "subnet-id", "identifier-type", "identifier", "address", "hostname", "last-seen", "findings"})
+    for _, row := range audit.Rows {
+      _ = cw.Write([]string{
+        row.Source,
+        strconv.FormatInt(row.SubnetID, 10),
+        row.IdentifierType,
+        row.Identifier,
+        row.Address,
+        row.Hostname,
+        row.LastSeen,
+        strings.Join(row.Findings, "; "),
+      })
+    }
+    cw.Flush()
+  }
+}
+
+// ReservationAuditDelete deletes the selected reservations. The first
+// POST renders a confirmation page; only a POST with confirm=yes deletes.
+func ReservationAuditDelete(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+      http.Error(w, "Bad form", http.StatusBadRequest)
+      return
+    }
+    family, days := auditParams(r)
+    q := url.Values{"family": {strconv.Itoa(family)}, "days": {strconv.Itoa(days)}}
+    keys := r.PostForm["key"]
+
+    if len(keys) == 0 {
+      redirectResult(w, r, reservationAuditPath, q, "", errors.New("no reservations selected"))
+      return
+    }
+
+    if r.PostFormValue("confirm") != "yes" {
+      handlers.RenderTemplate(w, r, "reservationaudit_confirm", handlers.PageData{
+        Title: "Delete Reservations",
+        Data: map[string]interface{}{
+          "Family": family,
+          "Days":   days,
+          "Keys":   keys,
+        },
+      })
+      return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+    defer cancel()
+
+    // Rows of the hosts table are deleted directly, so their subnet is
+    // looked up to check the user's scope.
+    id, _ := auth.FromContext(r.Context())
+    var hostSubnets map[int64]int64
+    if id.Scoped() && family == 4 && db.Configured() {
+      hosts, err := db.Hosts4(ctx)
+      if err != nil {
+        redirectResult(w, r, reservationAuditPath, q, "", err)
+        return
+      }
+      hostSubnets = map[int64]int64{}
+      for _, h := range hosts {
+        hostSubnets[h.HostID] = h.SubnetID
+      }
+    }
+
+    var failed []string
+    for _, key := range keys {
+      if err := deleteAuditedReservation(ctx, client, db, family, key, func(hostID int64) bool {
+        return hostSubnets == nil || id.Allows(auth.PermLeases, kea.ServiceDHCP4, hostSubnets[hostID])
+      }); err != nil {
+        utils.Error("Delete reservation %s: %v", key, err)
+        failed = append(failed, key+": "+err.Error())
+      }
+    }
+
+    if len(failed) > 0 {
+      err := fmt.Errorf("%d of %d deletes failed: %s", len(failed), len(keys), strings.Join(failed, "; "))
+      redirectResult(w, r, reservationAuditPath, q, "", err)
+      return
+    }
+    redirectResult(w, r, reservationAuditPath, q, fmt.Sprintf("Deleted %d reservations", len(keys)), nil)
+  }
+}
+
+// deleteAuditedReservation deletes a reservation by the key built in
+// auditReservations: "db|<host_id>" or "cmd|<subnet>|<type>|<identifier>".
+// allowHost vets hosts table rows; host_cmds deletes pass the Kea command
+// check instead.
+func deleteAuditedReservation(ctx context.Context, client *kea.Client, db *sql.DB, family int, key string, allowHost func(hostID int64) bool) error {
+  parts := strings.SplitN(key, "|", 4)
+  switch {
+  case len(parts) == 2 && parts[0] == "db":
+    id, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil {
+      return err
+    }
+    if !allowHost(id) {
+      return auth.ErrForbidden
+    }
+    return db.DeleteHost(ctx, id)
+
+  case len(parts) == 4 && parts[0] == "cmd":
+    subnetID, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil {
+      return err
+    }
+    return client.ReservationDel(ctx, familyService(family), subnetID, parts[2], parts[3])
+  }
+  return fmt.Errorf("invalid reservation key %q", key)
+}
@@ -0,0 +1,721 @@
+package pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const (
+  // maxImportSize caps uploaded reservation files.
+  maxImportSize = 10 << 20
+  // importBatchSize is the number of rows written per batch.
+  importBatchSize = 50
+)
+
+// Columns of the reservation CSV format, in export order.
+var reservationCSVHeader = []string{"subnet-id", "identifier-type", "identifier", "ip-address", "hostname", "options"}
+
+// What applying an import row would do.
+const (
+  importAdd       = "add"
+  importUpdate    = "update"
+  importUnchanged = "unchanged"
+  importConflict  = "conflict"
+  importError     = "error"
+)
+
+// importRecord is one parsed row of an import file.
+type importRecord struct {
+  Line   int
+  Subnet string
+  Res    kea.Reservation
+  Err    error
+}
+
+// existingReservation is a reservation already held by the backend.
+type existingReservation struct {
+  Res    kea.Reservation
+  HostID int64
+}
+
+// importPlanRow is the preview of one import row.
+type importPlanRow struct {
+  Line           int
+  Action         string
+  Message        string
+  SubnetID       int64
+  IdentifierType string
+  Identifier     string
+  Address        string
+  Hostname       string
+  Options        string
+
+  addrs    []string
+  res      kea.Reservation
+  existing *existingReservation
+}
+
+// importPlan is the dry-run result for a whole file.
+type importPlan struct {
+  Family int
+  Source string
+  Rows   []importPlanRow
+  Counts map[string]int
+}
+
+// Digest identifies the plan, with the reservations it would replace,
+// so apply can tell whether the preview still holds.
+func (p importPlan) Digest() string {
+  type digestRow struct {
+    importPlanRow
+    Existing *existingReservation
+  }
+  rows := make([]digestRow, len(p.Rows))
+  for i, row := range p.Rows {
+    rows[i] = digestRow{row, row.existing}
+  }
+  b, _ := json.Marshal(struct {
+    Family int
+    Source string
+    Rows   []digestRow
+  }{p.Family, p.Source, rows})
+  sum := sha256.Sum256(b)
+  return hex.EncodeToString(sum[:])
+}
+
+// parseReservations reads CSV or JSON; format "auto" sniffs the content.
+func parseReservations(content, format string) ([]importRecord, error) {
+  if format == "" || format == "auto" {
+    format = "csv"
+    if t := strings.TrimSpace(content); strings.HasPrefix(t, "[") || strings.HasPrefix(t, "{") {
+      format = "json"
+    }
+  }
+
+  switch format {
+  case "csv":
+    return parseReservationsCSV(strings.NewReader(content))
+  case "json":
+    return parseReservationsJSON([]byte(content))
+  }
+  return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseReservationsCSV(r io.Reader) ([]importRecord, error) {
+  cr := csv.NewReader(r)
+  cr.FieldsPerRecord = -1
+  cr.TrimLeadingSpace = true
+
+  header, err := cr.Read()
+  if err != nil {
+    return nil, fmt.Errorf("read CSV header: %w", err)
+  }
+  cols := map[string]int{}
+  for i, h := range header {
+    cols[strings.ToLower(strings.TrimSpace(h))] = i
+  }
+  col := func(rec []string, names ...string) string {
+    for _, n := range names {
+      if i, ok := cols[n]; ok && i < len(rec) {
+        return strings.TrimSpace(rec[i])
+      }
+    }
+    return ""
+  }
+  if _, ok := cols["identifier"]; !ok {
+    return nil, errors.New("CSV header has no identifier column")
+  }
+
+  var out []importRecord
+  for line := 2; ; line++ {
+    rec, err := cr.Read()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      out = append(out, importRecord{Line: line, Err: err})
+      continue
+    }
+
+    ir := importRecord{Line: line, Subnet: col(rec, "subnet-id", "subnet")}
+    ir.Res.IPAddress = col(rec, "ip-address", "ip")
+    ir.Res.Hostname = col(rec, "hostname")
+    if err := ir.Res.SetIdentifier(col(rec, "identifier-type", "type"), col(rec, "identifier")); err != nil {
+      ir.Err = err
+    }
+
+    if opts := col(rec, "options"); opts != "" && ir.Err == nil {
+      if strings.HasPrefix(opts, "[") {
+        ir.Err = json.Unmarshal([]byte(opts), &ir.Res.OptionData)
+      } else {
+        ir.Res.OptionData, ir.Err = kea.ParseOptions(opts)
+      }
+    }
+    out = append(out, ir)
+  }
+  return out, nil
+}
+
+func parseReservationsJSON(data []byte) ([]importRecord, error) {
+  // Accept a bare array or {"reservations": [...]}.
+  var items []json.RawMessage
+  if err := json.Unmarshal(data, &items); err != nil {
+    var wrapped struct {
+      Reservations []json.RawMessage `json:"reservations"`
+    }
+    if err2 := json.Unmarshal(data, &wrapped); err2 != nil {
+      return nil, fmt.Errorf("decode JSON: %w", err)
+    }
+    items = wrapped.Reservations
+  }
+
+  out := make([]importRecord, 0, len(items))
+  for i, raw := range items {
+    ir := importRecord{Line: i + 1}
+    if err := json.Unmarshal(raw, &ir.Res); err != nil {
+      ir.Err = err
+      out = append(out, ir)
+      continue
+    }
+
+    // "subnet" may name the subnet by prefix instead of subnet-id.
+    var extra struct {
+      Subnet string `json:"subnet"`
+    }
+    _ = json.Unmarshal(raw, &extra)
+    ir.Subnet = extra.Subnet
+    if ir.Subnet == "" && ir.Res.SubnetID != 0 {
+      ir.Subnet = strconv.FormatInt(ir.Res.SubnetID, 10)
+    }
+    out = append(out, ir)
+  }
+  return out, nil
+}
+
+// loadExistingReservations reads the reservations an import is compared
+// against: the hosts table (DHCPv4 with a database) or host_cmds.
+func loadExistingReservations(ctx context.Context, client *kea.Client, db *sql.DB, family int) ([]existingReservation, string, error) {
+  if family == 4 && db.Configured() {
+    hosts, err := db.Hosts4(ctx)
+    if err != nil {
+      return nil, "", err
+    }
+    options, err := db.HostOptions4(ctx)
+    if err != nil {
+      return nil, "", err
+    }
+
+    out := make([]existingReservation, 0, len(hosts))
+    for _, h := range hosts {
+      res := kea.Reservation{SubnetID: h.SubnetID, IPAddress: h.IPv4Address, Hostname: h.Hostname}
+      _ = res.SetIdentifier(h.IdentifierType, h.Identifier)
+      for _, o := range options[h.HostID] {
+        od := kea.OptionData{Code: o.Code, Data: o.Value}
+        if o.Space != kea.DefaultSpace(family) {
+          od.Space = o.Space
+        } else {
+          kea.ResolveOption(family, &od)
+        }
+        res.OptionData = append(res.OptionData, od)
+      }
+      out = append(out, existingReservation{Res: res, HostID: h.HostID})
+    }
+    return out, reservationSourceHostsTable, nil
+  }
+
+  hosts, err := client.ReservationsAll(ctx, familyService(family))
+  if err != nil {
+    return nil, "", err
+  }
+  out := make([]existingReservation, 0, len(hosts))
+  for _, h := range hosts {
+    out = append(out, existingReservation{Res: h})
+  }
+  return out, reservationSourceHostCmds, nil
+}
+
+// planImport works out, row by row, what an import would do.
+func planImport(ctx context.Context, client *kea.Client, db *sql.DB, family int, records []importRecord) (importPlan, error) {
+  plan := importPlan{Family: family, Counts: map[string]int{}}
+
+  subnets, err := client.Subnets(ctx, familyService(family))
+  if err != nil {
+    return plan, fmt.Errorf("read subnets: %w", err)
+  }
+  existing, source, err := loadExistingReservations(ctx, client, db, family)
+  if err != nil {
+    return plan, fmt.Errorf("read reservations: %w", err)
+  }
+  plan.Source = source
+
+  byIdentifier := map[string]*existingReservation{}
+  byAddress := map[string]*existingReservation{}
+  for i := range existing {
+    e := &existing[i]
+    t, v := e.Res.Identifier()
+    byIdentifier[reservationKey(e.Res.SubnetID, t, v)] = e
+    for _, a := range e.Res.Addresses() {
+      byAddress[reservationKey(e.Res.SubnetID, "ip", a)] = e
+    }
+  }
+
+  // The hosts table is written directly, so the subnet scope is
+  // checked here rather than by the Kea command check.
+  id, _ := auth.FromContext(ctx)
+  seen := map[string]int{}
+  for _, rec := range records {
+    row := planImportRow(family, rec, subnets)
+    if row.Action == "" && !id.Allows(auth.PermLeases, familyService(family), row.SubnetID) {
+      row.Action, row.Message = importError, fmt.Sprintf("subnet %d is outside your access", row.SubnetID)
+    }
+    if row.Action == "" {
+      idKey := reservationKey(row.SubnetID, row.IdentifierType, row.Identifier)
+      current := byIdentifier[idKey]
+      row.Action, row.Message = importConflictCheck(row, idKey, current, seen, byAddress)
+
+      if row.Action == "" {
+        switch {
+        case current == nil:
+          row.Action = importAdd
+        case sameReservation(current.Res, row.res):
+          row.Action = importUnchanged
+          row.existing = current
+        default:
+          row.Action = importUpdate
+          row.existing = current
+        }
+      }
+
+      seen[idKey] = rec.Line
+      for _, a := range row.addrs {
+        seen[reservationKey(row.SubnetID, "ip", a)] = rec.Line
+      }
+    }
+
+    plan.Counts[row.Action]++
+    plan.Rows = append(plan.Rows, row)
+  }
+  return plan, nil
+}
+
+// importConflictCheck reports rows that clash with an earlier row of the
+// file or with a reservation of a different client.
+func importConflictCheck(row importPlanRow, idKey string, current *existingReservation, seen map[string]int, byAddress map[string]*existingReservation) (string, string) {
+  if line := seen[idKey]; line != 0 {
+    return importConflict, fmt.Sprintf("identifier repeats line %d", line)
+  }
+  for _, a := range row.addrs {
+    addrKey := reservationKey(row.SubnetID, "ip", a)
+    if line := seen[addrKey]; line != 0 {
+      return importConflict, fmt.Sprintf("address %s repeats line %d", a, line)
+    }
+    if other := byAddress[addrKey]; other != nil && other != current {
+      t, v := other.Res.Identifier()
+      return importConflict, "address " + a + " already reserved for " + t + " " + v
+    }
+  }
+  return "", ""
+}
+
+// planImportRow validates one record. It leaves Action empty when the
+// row is valid and still needs comparing with existing reservations.
+func planImportRow(family int, rec importRecord, subnets []kea.Subnet) importPlanRow {
+  t, v := rec.Res.Identifier()
+  row := importPlanRow{
+    Line:           rec.Line,
+    IdentifierType: t,
+    Identifier:     strings.ToLower(v),
+    Address:        rec.Res.IPAddress,
+    Hostname:       rec.Res.Hostname,
+    Options:        kea.FormatOptions(rec.Res.OptionData),
+  }
+  fail := func(msg string) importPlanRow {
+    row.Action, row.Message = importError, msg
+    return row
+  }
+
+  if rec.Err != nil {
+    return fail(rec.Err.Error())
+  }
+  if t == "" {
+    return fail("missing identifier")
+  }
+
+  subnet, ok := findSubnet(subnets, rec.Subnet)
+  if !ok {
+    return fail("unknown subnet " + strconv.Quote(rec.Subnet))
+  }
+  row.SubnetID = subnet.ID
+
+  // Several addresses may be given, separated by spaces (DHCPv6).
+  raw := append(strings.Fields(rec.Res.IPAddress), rec.Res.IPAddresses...)
+  if family == 4 && len(raw) > 1 {
+    return fail("only one IPv4 address can be reserved")
+  }
+  for _, a := range raw {
+    addr, err := netip.ParseAddr(a)
+    if err != nil || addr.Is4() != (family == 4) {
+      return fail("invalid address " + a)
+    }
+    if !subnet.Contains(addr) {
+      return fail("address " + a + " outside subnet " + subnet.Subnet)
+    }
+    row.addrs = append(row.addrs, addr.String())
+  }
+  row.Address = strings.Join(row.addrs, " ")
+
+  res := rec.Res
+  res.SubnetID = subnet.ID
+  res.IPAddress = ""
+  res.IPAddresses = nil
+  if family == 4 {
+    res.IPAddress = row.Address
+  } else {
+    res.IPAddresses = row.addrs
+  }
+  _ = res.SetIdentifier(t, row.Identifier)
+  for i := range res.OptionData {
+    if !kea.ResolveOption(family, &res.OptionData[i]) {
+      return fail("unknown option " + res.OptionData[i].Name)
+    }
+  }
+  row.res = res
+  return row
+}
+
+// findSubnet resolves a subnet given by ID or by prefix.
+func findSubnet(subnets []kea.Subnet, ref string) (kea.Subnet, bool) {
+  ref = strings.TrimSpace(ref)
+  if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+    for _, s := range subnets {
+      if s.ID == id {
+        return s, true
+      }
+    }
+    return kea.Subnet{}, false
+  }
+  if p, err := netip.ParsePrefix(ref); err == nil {
+    for _, s := range subnets {
+      if sp, err := s.Prefix(); err == nil && sp.Masked() == p.Masked() {
+        return s, true
+      }
+    }
+  }
+  return kea.Subnet{}, false
+}
+
+func reservationKey(subnetID int64, kind, value string) string {
+  return strconv.FormatInt(subnetID, 10) + "|" + kind + "|" + strings.ToLower(value)
+}
+
+// sameReservation compares the fields an import can set.
+func sameReservation(a, b kea.Reservation) bool {
+  if strings.Join(a.Addresses(), ",") != strings.Join(b.Addresses(), ",") || a.Hostname != b.Hostname {
+    return false
+  }
+  if len(a.OptionData) != len(b.OptionData) {
+    return false
+  }
+  key := func(o kea.OptionData) string {
+    space := o.Space
+    if space == "dhcp4" || space == "dhcp6" {
+      space = ""
+    }
+    return space + "/" + strconv.Itoa(o.Code) + "=" + o.Data
+  }
+  var ka, kb []string
+  for i := range a.OptionData {
+    ka = append(ka, key(a.OptionData[i]))
+    kb = append(kb, key(b.OptionData[i]))
+  }
+  sort.Strings(ka)
+  sort.Strings(kb)
+  return reflect.DeepEqual(ka, kb)
+}
+
+// importResult summarises an applied import.
+type importResult struct {
+  Applied  int
+  Skipped  int
+  Failures []string
+}
+
+// applyImport writes the add and update rows of plan in batches.
+func applyImport(ctx context.Context, client *kea.Client, db *sql.DB, plan importPlan) importResult {
+  var result importResult
+  var todo []importPlanRow
+  for _, row := range plan.Rows {
+    if row.Action == importAdd || row.Action == importUpdate {
+      todo = append(todo, row)
+    } else {
+      result.Skipped++
+    }
+  }
+
+  for start := 0; start < len(todo); start += importBatchSize {
+    if err := ctx.Err(); err != nil {
+      result.Failures = append(result.Failures, err.Error())
+      return result
+    }
+    end := min(start+importBatchSize, len(todo))
+    batch := todo[start:end]
+
+    if plan.Source == reservationSourceHostsTable {
+      if err := saveHostBatch(ctx, db, batch); err != nil {
+        result.Failures = append(result.Failures, fmt.Sprintf("lines %d-%d: %v", batch[0].Line, batch[len(batch)-1].Line, err))
+        continue
+      }
+      result.Applied += len(batch)
+      continue
+    }
+
+    for _, row := range batch {
+      if err := saveReservation(ctx, client, plan.Family, row); err != nil {
+        result.Failures = append(result.Failures, fmt.Sprintf("line %d: %v", row.Line, err))
+        continue
+      }
+      result.Applied++
+    }
+  }
+  return result
+}
+
+func saveHostBatch(ctx context.Context, db *sql.DB, batch []importPlanRow) error {
+  hosts := make([]sql.Host, 0, len(batch))
+  for _, row := range batch {
+    h := sql.Host{
+      IdentifierType: row.IdentifierType,
+      Identifier:     row.Identifier,
+      SubnetID:       row.SubnetID,
+      IPv4Address:    row.Address,
+      Hostname:       row.Hostname,
+    }
+    if row.existing != nil {
+      h.HostID = row.existing.HostID
+    }
+    for _, o := range row.res.OptionData {
+      h.Options = append(h.Options, sql.HostOption{Code: o.Code, Space: o.Space, Value: o.Data})
+    }
+    hosts = append(hosts, h)
+  }
+  return db.SaveHosts4(ctx, hosts)
+}
+
+// saveReservation adds or updates one reservation through host_cmds. An
+// update keeps the fields the import format doesn't carry.
+func saveReservation(ctx context.Context, client *kea.Client, family int, row importPlanRow) error {
+  service := familyService(family)
+  if row.existing == nil {
+    return client.ReservationAdd(ctx, service, row.res)
+  }
+
+  res := row.existing.Res
+  res.IPAddress = row.res.IPAddress
+  res.IPAddresses = row.res.IPAddresses
+  res.Hostname = row.res.Hostname
+  res.OptionData = row.res.OptionData
+
+  err := client.ReservationUpdate(ctx, service, res)
+  var cmdErr *kea.CommandError
+  if errors.As(err, &cmdErr) && cmdErr.Result == kea.ResultUnsupported {
+    // Older Kea has no reservation-update: delete and add instead.
+    t, v := res.Identifier()
+    if err := client.ReservationDel(ctx, service, res.SubnetID, t, v); err != nil {
+      return err
+    }
+    return client.ReservationAdd(ctx, service, res)
+  }
+  return err
+}
+
+// importParams reads the address family of an import or export.
+func importParams(r *http.Request) int {
+  if r.FormValue("family") == "6" {
+    return 6
+  }
+  return 4
+}
+
+// ReservationImport shows the import form.
+func ReservationImport() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    data := map[string]interface{}{"Family": importParams(r)}
+    flash(r, data)
+    handlers.RenderTemplate(w, r, "reservationimport", handlers.PageData{
+      Title: "Import Reservations",
+      Data:  data,
+    })
+  }
+}
+
+// ReservationImportAction previews an uploaded file (action=preview) or
+// applies it (action=apply). The file content travels in the form, so
+// apply re-plans against the reservations as they are at that moment,
+// and applies only when that gives the plan of the preview.
+func ReservationImportAction(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    extendForUpload(w)
+    r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+    if err := r.ParseMultipartForm(maxImportSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+      http.Error(w, "Upload too large or malformed", http.StatusBadRequest)
+      return
+    }
+
+    family := importParams(r)
+    format := r.FormValue("format")
+    content := r.FormValue("content")
+    if file, _, err := r.FormFile("file"); err == nil {
+      b, err := io.ReadAll(file)
+      file.Close()
+      if err != nil {
+        http.Error(w, "Failed to read upload", http.StatusBadRequest)
+        return
+      }
+      if len(b) > 0 {
+        content = string(b)
+      }
+    }
+
+    data := map[string]interface{}{
+      "Family":  family,
+      "Format":  format,
+      "Content": content,
+    }
+    render := func() {
+      handlers.RenderTemplate(w, r, "reservationimport", handlers.PageData{
+        Title: "Import Reservations",
+        Data:  data,
+      })
+    }
+
+    records, err := parseReservations(content, format)
+    if err != nil {
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+    defer cancel()
+
+    plan, err := planImport(ctx, client, db, family, records)
+    if err != nil {
+      utils.Error("Reservation import: %v", err)
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+
+    if r.FormValue("action") == "apply" {
+      if r.FormValue("plan") != plan.Digest() {
+        data["Plan"] = plan
+        data["Error"] = "The plan changed since the preview; check it again before applying"
+        render()
+        return
+      }
+      result := applyImport(ctx, client, db, plan)
+      data["Result"] = result
+      if len(result.Failures) == 0 {
+        data["OK"] = fmt.Sprintf("Applied %d rows, skipped %d", result.Applied, result.Skipped)
+      } else {
+        data["Error"] = fmt.Sprintf("Applied %d rows, %d failures", result.Applied, len(result.Failures))
+      }
+      render()
+      return
+    }
+
+    data["Plan"] = plan
+    render()
+  }
+}
+
+// ReservationExport downloads existing reservations as CSV or JSON in
+// the same format the import accepts.
+func ReservationExport(client *kea.Client, db *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    family := importParams(r)
+    format := r.FormValue("format")
+    if format != "json" {
+      format = "csv"
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+    defer cancel()
+
+    existing, _, err := loadExistingReservations(ctx, client, db, family)
+    if err != nil {
+      utils.Error("Reservation export: %v", err)
+      http.Error(w, err.Error(), http.StatusBadGateway)
+      return
+    }
+    id, _ := auth.FromContext(r.Context())
+    if !id.Server(familyService(family)) {
+      http.Error(w, "Permission denied", http.StatusForbidden)
+      return
+    }
+    existing = slices.DeleteFunc(existing, func(e existingReservation) bool { return !id.Subnet(e.Res.SubnetID) })
+
+    name := fmt.Sprintf("reservations-v%d-%s.%s", family, time.Now().Format("20060102"), format)
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+    if format == "json" {
+      out := make([]kea.Reservation, 0, len(existing))
+      for _, e := range existing {
+        out = append(out, e.Res)
+      }
+      w.Header().Set("Content-Type", "application/json")
+      enc := json.NewEncoder(w)
+      enc.SetIndent("", "  ")
+      _ = enc.Encode(out)
+      return
+    }
+
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    cw := csv.NewWriter(w)
+    _ = cw.Write(reservationCSVHeader)
+    for _, e := range existing {
+      t, v := e.Res.Identifier()
+      _ = cw.Write([]string{
+        strconv.FormatInt(e.Res.SubnetID, 10),
+        t,
+        v,
+        strings.Join(e.Res.Addresses(), " "),
+        e.Res.Hostname,
+        csvOptions(e.Res.OptionData),
+      })
+    }
+    cw.Flush()
+  }
+}
+
+// csvOptions uses the compact name=data form unless a value contains the
+// separator, in which case the options are written as a JSON array.
+func csvOptions(opts []kea.OptionData) string {
+  for _, o := range opts {
+    if strings.Contains(o.Data, ";") {
+      b, _ := json.Marshal(opts)
+      return string(b)
+    }
+  }
+  return kea.FormatOptions(opts)
+}
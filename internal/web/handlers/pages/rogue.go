@@ -0,0 +1,41 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rannday/kea-web/internal/rogue"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// Rogue lists the signs of rogue or conflicting DHCP servers together
+// with the identities of our own servers they were checked against.
+func Rogue(detector *rogue.Detector) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
+
+    data := map[string]interface{}{
+      "Known":    detector.Known(ctx),
+      "Findings": detector.Findings(),
+    }
+    flash(r, data)
+    handlers.RenderTemplate(w, r, "rogue", handlers.PageData{
+      Title: "Rogue DHCP Servers",
+      Data:  data,
+    })
+  }
+}
+
+// RogueDismiss forgets a finding, e.g. once the server was dealt with.
+func RogueDismiss(detector *rogue.Detector) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if !detector.Dismiss(r.FormValue("key")) {
+      redirectResult(w, r, "/rogue", nil, "", errors.New("no such finding; it may have expired"))
+      return
+    }
+    redirectResult(w, r, "/rogue", nil, "Finding dismissed", nil)
+  }
+}
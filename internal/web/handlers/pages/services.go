@@ -0,0 +1,119 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const servicesPath = "/services"
+
+// serviceRow is one unit on the services page.
+type serviceRow struct {
+  linux.UnitStatus
+  Error string
+  // Manage is set when the user may control the unit.
+  Manage bool
+}
+
+// unitService returns the Kea service a unit runs, "" for the control
+// agent and units it doesn't recognize.
+func unitService(unit string) string {
+  name := strings.TrimSuffix(unit, ".service")
+  switch {
+  case strings.HasSuffix(name, "dhcp4"):
+    return kea.ServiceDHCP4
+  case strings.HasSuffix(name, "dhcp6"):
+    return kea.ServiceDHCP6
+  case strings.HasSuffix(name, "ddns"):
+    return kea.ServiceD2
+  }
+  return ""
+}
+
+// canControlUnit reports whether the user of r may start and stop unit.
+func canControlUnit(r *http.Request, unit string) bool {
+  id, _ := auth.FromContext(r.Context())
+  return id.Can(auth.PermConfig) && id.Whole(unitService(unit))
+}
+
+// Services shows the state of the Kea units.
+func Services(m linux.UnitManager, cfg linux.Config) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    data := map[string]interface{}{"Actions": linux.UnitActions}
+    flash(r, data)
+
+    if m == nil {
+      if _, ok := data["Error"]; !ok {
+        data["Error"] = "Unit control is not available on this host."
+      }
+    } else {
+      ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+      defer cancel()
+
+      rows := make([]serviceRow, 0, len(cfg.Units))
+      for _, name := range cfg.Units {
+        st, err := m.Status(ctx, name)
+        row := serviceRow{UnitStatus: st, Manage: canControlUnit(r, name)}
+        if err != nil {
+          utils.Error("Unit status %s: %v", name, err)
+          row.Error = err.Error()
+        }
+        rows = append(rows, row)
+      }
+      data["Units"] = rows
+    }
+
+    handlers.RenderTemplate(w, r, "services", handlers.PageData{
+      Title: "Services",
+      Data:  data,
+    })
+  }
+}
+
+// ServiceAction starts, stops, restarts or reloads one of the configured
+// units.
+func ServiceAction(m linux.UnitManager, cfg linux.Config) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    unit := r.PathValue("unit")
+    action, err := linux.ParseUnitAction(r.PathValue("action"))
+    switch {
+    case err != nil:
+    case m == nil:
+      err = errors.New("unit control is not available on this host")
+    case !cfg.Has(unit):
+      err = fmt.Errorf("unit %q is not managed here", unit)
+    case !canControlUnit(r, unit):
+      err = fmt.Errorf("%s: %w", unit, auth.ErrForbidden)
+    }
+    if err != nil {
+      redirectResult(w, r, servicesPath, nil, "", err)
+      return
+    }
+
+    // A stop or restart waits for the daemon to exit; allow for Kea's
+    // own shutdown timeout, past the server's WriteTimeout.
+    const wait = 90 * time.Second
+    ctx, cancel := context.WithTimeout(r.Context(), wait)
+    defer cancel()
+    if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 5*time.Second)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+      utils.Debug("Unit action: set write deadline: %v", err)
+    }
+
+    if err := m.Control(ctx, unit, action); err != nil {
+      redirectResult(w, r, servicesPath, nil, "", err)
+      return
+    }
+    utils.Info("Unit %s: %s", unit, action)
+    redirectResult(w, r, servicesPath, nil, fmt.Sprintf("%s: %s done", unit, action), nil)
+  }
+}
@@ -0,0 +1,87 @@
+package pages
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+)
+
+// asUser returns r carrying an identity with access a.
+func asUser(r *http.Request, name string, a auth.Access) *http.Request {
+  return r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{User: name, Access: a}))
+}
+
+func TestServiceAction(t *testing.T) {
+  units := linux.NewFake(linux.UnitDHCP4, linux.UnitDHCP6)
+  cfg := linux.Config{Manager: linux.ManagerFake, Units: []string{linux.UnitDHCP4, linux.UnitDHCP6}}
+  mux := http.NewServeMux()
+  mux.HandleFunc("POST /services/{unit}/{action}", ServiceAction(units, cfg))
+
+  tests := []struct {
+    user   string
+    access auth.Access
+    unit   string
+    action string
+    err    string
+    active bool
+  }{
+    {"viewer", auth.Access{Role: auth.RoleViewer}, linux.UnitDHCP4, "stop", "permission denied", true},
+    {"v6admin", auth.Access{Role: auth.RoleAdmin, Servers: []string{"dhcp6"}}, linux.UnitDHCP4, "stop", "permission denied", true},
+    {"admin", auth.Access{Role: auth.RoleAdmin}, "sshd.service", "stop", "not managed here", false},
+    {"admin", auth.Access{Role: auth.RoleAdmin}, linux.UnitDHCP4, "enable", "unknown unit action", true},
+    {"admin", auth.Access{Role: auth.RoleAdmin}, linux.UnitDHCP4, "stop", "", false},
+    {"admin", auth.Access{Role: auth.RoleAdmin}, linux.UnitDHCP4, "start", "", true},
+  }
+  for _, tt := range tests {
+    r := asUser(httptest.NewRequest(http.MethodPost, "/services/"+tt.unit+"/"+tt.action, nil), tt.user, tt.access)
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusSeeOther {
+      t.Errorf("%s %s %s: status %d", tt.user, tt.action, tt.unit, w.Code)
+      continue
+    }
+    loc, _ := url.Parse(w.Header().Get("Location"))
+    if got := loc.Query().Get("err"); !strings.Contains(got, tt.err) || (tt.err == "") != (got == "") {
+      t.Errorf("%s %s %s: err = %q, want %q", tt.user, tt.action, tt.unit, got, tt.err)
+    }
+    if tt.unit != linux.UnitDHCP4 {
+      continue
+    }
+    if st, _ := units.Status(context.Background(), linux.UnitDHCP4); st.Active() != tt.active {
+      t.Errorf("%s %s %s: active = %v, want %v", tt.user, tt.action, tt.unit, st.Active(), tt.active)
+    }
+  }
+}
+
+func TestServicesPage(t *testing.T) {
+  units := linux.NewFake(linux.UnitDHCP4)
+  cfg := linux.Config{Manager: linux.ManagerFake, Units: []string{linux.UnitDHCP4, linux.UnitDHCP6}}
+  if err := units.Control(context.Background(), linux.UnitDHCP4, linux.ActionStop); err != nil {
+    t.Fatal(err)
+  }
+
+  r := asUser(httptest.NewRequest(http.MethodGet, "/services", nil), "admin", auth.Access{Role: auth.RoleAdmin})
+  w := httptest.NewRecorder()
+  Services(units, cfg)(w, r)
+  if w.Code != http.StatusOK {
+    t.Fatalf("status %d", w.Code)
+  }
+  body := w.Body.String()
+  for _, want := range []string{linux.UnitDHCP4, linux.UnitDHCP6, "inactive", "not-found"} {
+    if !strings.Contains(body, want) {
+      t.Errorf("page lacks %q", want)
+    }
+  }
+
+  w = httptest.NewRecorder()
+  Services(nil, cfg)(w, r)
+  if !strings.Contains(w.Body.String(), "Unit control is not available on this host.") {
+    t.Error("page without a unit manager lacks the notice")
+  }
+}
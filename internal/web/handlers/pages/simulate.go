@@ -0,0 +1,228 @@
+package pages
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/dhcp"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/simulate"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+// simulateForm holds the packet fields echoed back into the form.
+type simulateForm struct {
+  Family      int
+  Interface   string
+  Relay       string
+  Source      string
+  MAC         string
+  ClientID    string
+  RelayInfo   string
+  VendorClass string
+  UserClass   string
+  Options     string
+  Requested   string
+}
+
+func simulateParams(r *http.Request) simulateForm {
+  return simulateForm{
+    Family:      familyParam(r),
+    Interface:   strings.TrimSpace(r.FormValue("interface")),
+    Relay:       strings.TrimSpace(r.FormValue("relay")),
+    Source:      strings.TrimSpace(r.FormValue("source")),
+    MAC:         strings.TrimSpace(r.FormValue("mac")),
+    ClientID:    strings.TrimSpace(r.FormValue("client-id")),
+    RelayInfo:   r.FormValue("relay-info"),
+    VendorClass: r.FormValue("vendor-class"),
+    UserClass:   r.FormValue("user-class"),
+    Options:     r.FormValue("options"),
+    Requested:   r.FormValue("requested"),
+  }
+}
+
+// input turns the form into the simulated packet's description.
+func (f simulateForm) input(cfg *simulate.Config) (simulate.Input, error) {
+  in := simulate.Input{Family: f.Family, Interface: f.Interface, VendorClass: f.VendorClass, UserClass: f.UserClass}
+  var err error
+  if f.Relay != "" {
+    if in.Relay, err = netip.ParseAddr(f.Relay); err != nil {
+      return in, fmt.Errorf("relay address: %w", err)
+    }
+  }
+  if f.Source != "" {
+    if in.Source, err = netip.ParseAddr(f.Source); err != nil {
+      return in, fmt.Errorf("client address: %w", err)
+    }
+  }
+  if f.MAC != "" {
+    if in.MAC, err = net.ParseMAC(f.MAC); err != nil {
+      return in, fmt.Errorf("MAC address: %w", err)
+    }
+  }
+  if f.ClientID != "" {
+    if in.ClientID, err = hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(strings.TrimPrefix(f.ClientID, "0x"))); err != nil {
+      return in, errors.New("client identifier must be hex")
+    }
+  }
+  if len(in.MAC) == 0 && len(in.ClientID) == 0 {
+    return in, errors.New("a MAC address or client identifier is needed")
+  }
+  if ent, data, ok := strings.Cut(f.VendorClass, ":"); ok && f.Family == 6 {
+    if n, err := strconv.ParseUint(ent, 10, 32); err == nil {
+      in.Enterprise, in.VendorClass = uint32(n), data
+    }
+  }
+
+  for _, line := range strings.Split(f.RelayInfo, "\n") {
+    if line = strings.TrimSpace(line); line == "" {
+      continue
+    }
+    o, err := dhcp.ParseOption(f.Family, true, line)
+    if err != nil {
+      return in, fmt.Errorf("relay information: %w", err)
+    }
+    in.RelayInfo = append(in.RelayInfo, o)
+  }
+  for _, line := range strings.Split(f.Options, "\n") {
+    if line = strings.TrimSpace(line); line == "" {
+      continue
+    }
+    o, err := dhcp.ParseOption(f.Family, false, line)
+    if err != nil {
+      // Options defined in option-def resolve too.
+      name, value, _ := strings.Cut(line, "=")
+      code, ok := cfg.OptionCode(strings.TrimSpace(name))
+      if !ok {
+        return in, fmt.Errorf("options: %w", err)
+      }
+      o = dhcp.Option{Code: code, Data: dhcp.ParseOptionValue(value)}
+    }
+    in.Options = append(in.Options, o)
+  }
+  for _, name := range splitList(f.Requested) {
+    code, err := strconv.Atoi(name)
+    if err != nil {
+      c, ok := cfg.OptionCode(name)
+      if !ok {
+        return in, fmt.Errorf("requested options: unknown option %q", name)
+      }
+      code = c
+    }
+    in.Requested = append(in.Requested, code)
+  }
+  return in, nil
+}
+
+// simulateLookups reaches the host's interfaces and Kea's host backend.
+type simulateLookups struct {
+  ctx     context.Context
+  client  *kea.Client
+  host    *linux.Host
+  service string
+  // unavailable is set when host_cmds isn't loaded, so that it is
+  // reported once rather than for every subnet.
+  unavailable error
+}
+
+func (l *simulateLookups) interfacePrefixes(name string) []netip.Prefix {
+  ifaces, err := l.host.Interfaces()
+  if err != nil {
+    return nil
+  }
+  for _, iface := range ifaces {
+    if iface.Name == name {
+      return iface.Addrs
+    }
+  }
+  return nil
+}
+
+func (l *simulateLookups) reservation(subnetID int64, idType, id string) (*kea.Reservation, error) {
+  if l.unavailable != nil {
+    return nil, nil
+  }
+  r, err := l.client.ReservationGet(l.ctx, l.service, subnetID, idType, id)
+  var cmdErr *kea.CommandError
+  switch {
+  case errors.Is(err, kea.ErrEmpty):
+    return nil, nil
+  case errors.As(err, &cmdErr) && cmdErr.Result == kea.ResultUnsupported:
+    l.unavailable = err
+    return nil, nil
+  case err != nil:
+    return nil, err
+  }
+  return &r, nil
+}
+
+// Simulate shows how the running Dhcp4 or Dhcp6 configuration would
+// treat a hypothetical client packet: the subnet and shared network it
+// selects, the classes that match, the pools and reservation that apply
+// and the options the reply would carry.
+func Simulate(client *kea.Client, host *linux.Host) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    form := simulateParams(r)
+    data := map[string]interface{}{"Form": form}
+    render := func() {
+      handlers.RenderTemplate(w, r, "simulate", handlers.PageData{
+        Title: "Subnet Simulator",
+        Data:  data,
+      })
+    }
+    if r.FormValue("run") == "" {
+      render()
+      return
+    }
+
+    service := kea.ServiceDHCP4
+    if form.Family == 6 {
+      service = kea.ServiceDHCP6
+    }
+    ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+    defer cancel()
+
+    raw, err := client.ConfigGet(ctx, service)
+    if err != nil {
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+    cfg, err := simulate.ConfigFromKea(service, raw)
+    if err != nil {
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+    in, err := form.input(cfg)
+    if err != nil {
+      data["Error"] = err.Error()
+      render()
+      return
+    }
+
+    look := &simulateLookups{ctx: ctx, client: client, host: host, service: service}
+    res := simulate.Run(cfg, in, simulate.Lookups{
+      InterfacePrefixes: look.interfacePrefixes,
+      Reservation:       look.reservation,
+    })
+    if look.unavailable != nil {
+      res.Notes = append(res.Notes, "Reservations in the host backend were not checked: "+look.unavailable.Error())
+    }
+    data["Result"] = res
+    data["Packet"] = dhcp.DecodeOptions(form.Family, res.Packet.Options())
+    if relay := res.Packet.Relay6(); relay != nil {
+      data["RelayOptions"] = dhcp.DecodeOptions(6, relay.Options)
+    }
+    render()
+  }
+}
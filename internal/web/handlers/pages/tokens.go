@@ -0,0 +1,162 @@
+package pages
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const tokensPath = "/tokens"
+
+// tokenRow is one API token on the tokens page.
+type tokenRow struct {
+  auth.Token
+  ScopesText   string
+  ExpiresText  string
+  LastUsedText string
+  Status       string
+  Revocable    bool
+}
+
+func renderTokens(w http.ResponseWriter, r *http.Request, a *auth.Auth, data map[string]interface{}) {
+  data["Scopes"] = auth.Scopes
+  id, _ := auth.FromContext(r.Context())
+  admin := id.Can(auth.PermUsers)
+  data["Admin"] = admin
+
+  list, err := a.Tokens.List()
+  if err != nil {
+    utils.Error("Tokens: %v", err)
+    data["Error"] = err.Error()
+  }
+  now := time.Now()
+  rows := []tokenRow{}
+  for _, t := range slices.Backward(list) {
+    own := t.OwnedBy(id)
+    if !own && !admin {
+      continue
+    }
+    scopes := make([]string, len(t.Scopes))
+    for i, s := range t.Scopes {
+      scopes[i] = string(s)
+    }
+    row := tokenRow{
+      Token:        t,
+      ScopesText:   strings.Join(scopes, ", "),
+      ExpiresText:  formatTime(t.Expires),
+      LastUsedText: formatTime(t.LastUsed),
+      Status:       "active",
+      Revocable:    t.Revoked.IsZero(),
+    }
+    switch {
+    case !t.Revoked.IsZero():
+      row.Status = "revoked " + formatTime(t.Revoked)
+    case !t.Active(now):
+      row.Status, row.Revocable = "expired", false
+    }
+    rows = append(rows, row)
+  }
+  data["Tokens"] = rows
+
+  handlers.RenderTemplate(w, r, "tokens", handlers.PageData{
+    Title: "API Tokens",
+    Data:  data,
+  })
+}
+
+// Tokens lists the API tokens of the signed in user, and every token
+// for admins, with forms to create and revoke them.
+func Tokens(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    data := map[string]interface{}{}
+    flash(r, data)
+    renderTokens(w, r, a, data)
+  }
+}
+
+// TokensAction creates a personal or, for admins, a service token, or
+// revokes one. A new token is shown on the page it renders, never in a
+// redirect URL. Tokens can't manage tokens.
+func TokensAction(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+      http.Error(w, "Bad form", http.StatusBadRequest)
+      return
+    }
+    id, _ := auth.FromContext(r.Context())
+    if id.Token != "" {
+      http.Error(w, "API tokens can't manage tokens", http.StatusForbidden)
+      return
+    }
+    action := r.PostFormValue("action")
+
+    if action == "revoke" {
+      tokenID := r.PostFormValue("id")
+      err := func() error {
+        t, err := a.Tokens.Get(tokenID)
+        if err != nil {
+          return err
+        }
+        if !id.Can(auth.PermUsers) && !t.OwnedBy(id) {
+          return auth.ErrForbidden
+        }
+        return a.Tokens.Revoke(tokenID)
+      }()
+      if err == nil {
+        utils.Info("%s revoked API token %s", id.User, tokenID)
+      }
+      redirectResult(w, r, tokensPath, nil, "Revoked token "+tokenID, err)
+      return
+    }
+
+    secret, tok, err := func() (string, auth.Token, error) {
+      scopes, err := auth.ParseScopes(strings.Join(r.PostForm["scope"], ","))
+      if err != nil {
+        return "", auth.Token{}, err
+      }
+      expires, err := auth.ParseExpiry(r.PostFormValue("expires"), time.Now())
+      if err != nil {
+        return "", auth.Token{}, err
+      }
+      tok := auth.Token{Name: r.PostFormValue("name"), Scopes: scopes, Expires: expires}
+      switch action {
+      case "create":
+        if !id.Access.Covers(scopes) {
+          return "", auth.Token{}, fmt.Errorf("%w: your role doesn't cover those scopes", auth.ErrForbidden)
+        }
+        tok.Owner = id.User
+        // Users signed in through an identity provider have no account
+        // to follow, so their token keeps the access they have now.
+        if id.External {
+          tok.External, tok.Access = true, id.Access
+        }
+      case "service":
+        if !id.Can(auth.PermUsers) {
+          return "", auth.Token{}, auth.ErrForbidden
+        }
+        tok.Access, err = auth.ParseAccess(string(auth.ScopeRole(scopes)), r.PostFormValue("servers"), r.PostFormValue("subnets"))
+        if err != nil {
+          return "", auth.Token{}, err
+        }
+      default:
+        return "", auth.Token{}, fmt.Errorf("unknown action %q", action)
+      }
+      return a.Tokens.Create(tok)
+    }()
+    if err != nil {
+      redirectResult(w, r, tokensPath, nil, "", err)
+      return
+    }
+    utils.Info("%s created API token %s (%s) for %s", id.User, tok.ID, tok.Name, tok.User())
+    renderTokens(w, r, a, map[string]interface{}{
+      "OK":     fmt.Sprintf("Created token %s; copy it now, it isn't shown again", tok.Name),
+      "Secret": secret,
+    })
+  }
+}
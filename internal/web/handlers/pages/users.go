@@ -0,0 +1,122 @@
+package pages
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/utils"
+	"github.com/rannday/kea-web/internal/web/handlers"
+)
+
+const usersPath = "/users"
+
+// userRow is one account on the users page, with its scope as the form
+// fields show it.
+type userRow struct {
+  auth.User
+  ServersText   string
+  SubnetsText   string
+  LastLoginText string
+  Self          bool
+}
+
+// Users lists the accounts with forms to change them.
+func Users(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    data := map[string]interface{}{"Roles": auth.Roles}
+    flash(r, data)
+
+    list, err := a.Users.List()
+    if err != nil {
+      utils.Error("Users: %v", err)
+      data["Error"] = err.Error()
+    }
+    id, _ := auth.FromContext(r.Context())
+    rows := make([]userRow, 0, len(list))
+    for _, u := range list {
+      subnets := make([]string, len(u.Subnets))
+      for i, s := range u.Subnets {
+        subnets[i] = strconv.FormatInt(s, 10)
+      }
+      if u.Role == "" {
+        u.Role = auth.RoleAdmin
+      }
+      rows = append(rows, userRow{
+        User:          u,
+        ServersText:   strings.Join(u.Servers, ", "),
+        SubnetsText:   strings.Join(subnets, ", "),
+        LastLoginText: formatTime(u.LastLogin),
+        Self:          strings.EqualFold(u.Name, id.User),
+      })
+    }
+    data["Users"] = rows
+
+    handlers.RenderTemplate(w, r, "users", handlers.PageData{
+      Title: "Users",
+      Data:  data,
+    })
+  }
+}
+
+// UsersAction adds an account, or changes the access, password or
+// existence of one. Admins can't change or delete their own account
+// here, so there is always an admin left.
+func UsersAction(a *auth.Auth) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+      http.Error(w, "Bad form", http.StatusBadRequest)
+      return
+    }
+    id, _ := auth.FromContext(r.Context())
+    name := strings.TrimSpace(r.PostFormValue("name"))
+    action := r.PostFormValue("action")
+
+    msg, err := func() (string, error) {
+      if action != "add" && action != "password" && strings.EqualFold(name, id.User) {
+        return "", errors.New("you can't change your own access or delete your own account")
+      }
+      switch action {
+      case "add", "access":
+        access, err := auth.ParseAccess(r.PostFormValue("role"), r.PostFormValue("servers"), r.PostFormValue("subnets"))
+        if err != nil {
+          return "", err
+        }
+        if action == "add" {
+          if err := a.Users.Add(name, r.PostFormValue("password"), access); err != nil {
+            return "", err
+          }
+          return fmt.Sprintf("Added %s as %s", name, access), nil
+        }
+        if err := a.Users.SetAccess(name, access); err != nil {
+          return "", err
+        }
+        return fmt.Sprintf("%s is now %s", name, access), nil
+      case "password":
+        if err := a.Users.SetPassword(name, r.PostFormValue("password")); err != nil {
+          return "", err
+        }
+        // Sign the account out everywhere, except this browser when
+        // admins change their own password.
+        if !strings.EqualFold(name, id.User) {
+          a.Sessions.DeleteUser(name)
+        }
+        return "Changed the password of " + name, nil
+      case "delete":
+        if err := a.Users.Delete(name); err != nil {
+          return "", err
+        }
+        a.Sessions.DeleteUser(name)
+        return "Deleted " + name, nil
+      }
+      return "", fmt.Errorf("unknown action %q", action)
+    }()
+    if err == nil {
+      utils.Info("%s: %s", id.User, msg)
+    }
+    redirectResult(w, r, usersPath, nil, msg, err)
+  }
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"embed"
+	"fmt"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strings"
 
+	"github.com/rannday/kea-web/internal/auth"
 	"github.com/rannday/kea-web/internal/utils"
 )
 
@@ -17,7 +21,55 @@ type PageData struct {
 	Title     string
 	CSSBundle string
 	JSBundle  string
+	// User is the signed in user, "" on the login page
+	User string
+	// CSRFToken is the session's CSRF token, for scripts to send in the
+	// X-CSRF-Token header; forms put it in with {{csrfField}}
+	CSRFToken string
 	Data      map[string]interface{}
+
+	identity auth.Identity
+}
+
+// Can reports whether the signed in user has a permission ("read",
+// "leases", "config" or "users"), for templates to hide what they
+// can't do
+func (d PageData) Can(perm string) bool {
+	return d.User != "" && d.identity.Can(auth.Permission(perm))
+}
+
+// templateFuncs are available to every template. csrfField is replaced
+// for each page by one that knows the session's token
+var templateFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"bytes":     formatBytes,
+	"csrfField": func() template.HTML { return "" },
+}
+
+// csrfField returns the hidden input carrying token, "" without a
+// session. Forms that post put it first, which makes it the first part
+// of a multipart body, where the check looks for it
+func csrfField(token string) func() template.HTML {
+	return func() template.HTML {
+		if token == "" {
+			return ""
+		}
+		return template.HTML(`<input type="hidden" name="` + auth.CSRFField + `" value="` + template.HTMLEscapeString(token) + `" />`)
+	}
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 GiB"
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 // Cached asset filenames (set once at startup)
@@ -33,7 +85,7 @@ func SetBundledAssets(css, js string) {
 }
 
 // RenderTemplate loads layout.html + specific content template
-func RenderTemplate(w http.ResponseWriter, tmpl string, data PageData) {
+func RenderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data PageData) {
 	layout := "templates/layout.html"
 	content := "templates/" + tmpl + ".html"
 
@@ -43,15 +95,25 @@ func RenderTemplate(w http.ResponseWriter, tmpl string, data PageData) {
 	data.CSSBundle = cssBundle
 	data.JSBundle = jsBundle
 
-	t, err := template.ParseFS(templatesFS, layout, content)
+	if id, ok := auth.FromContext(r.Context()); ok {
+		data.User = id.User
+		data.CSRFToken = id.CSRF
+		data.identity = id
+	}
+
+	t, err := template.New("layout").Funcs(templateFuncs).ParseFS(templatesFS, layout, content)
 	if err != nil {
 		utils.Error("Failed to parse templates: %v", err)
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
 	}
+	t.Funcs(template.FuncMap{"csrfField": csrfField(data.CSRFToken)})
 
-	if err := t.ExecuteTemplate(w, "layout", data); err != nil {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "layout", data); err != nil {
 		utils.Error("Failed to execute template: %v", err)
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		return
 	}
+	_, _ = w.Write(buf.Bytes())
 }
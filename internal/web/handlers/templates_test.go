@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"html/template"
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// postForm matches the opening tag of a form that posts, however its
+// method is quoted
+var postForm = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod\s*=\s*["']?post\b[^>]*>`)
+
+func TestPostFormsHaveCSRFField(t *testing.T) {
+  names, err := fs.Glob(templatesFS, "templates/*.html")
+  if err != nil {
+    t.Fatal(err)
+  }
+  forms := 0
+  for _, name := range names {
+    b, err := templatesFS.ReadFile(name)
+    if err != nil {
+      t.Fatal(err)
+    }
+    page := string(b)
+    for _, at := range postForm.FindAllStringIndex(page, -1) {
+      forms++
+      if !strings.HasPrefix(strings.TrimSpace(page[at[1]:]), "{{csrfField}}") {
+        t.Errorf("%s: %s doesn't start with {{csrfField}}", name, page[at[0]:at[1]])
+      }
+    }
+  }
+  if forms == 0 {
+    t.Error("no form posts")
+  }
+}
+
+func TestCSRFField(t *testing.T) {
+  if got := csrfField("")(); got != "" {
+    t.Errorf("without a session: %q", got)
+  }
+  want := template.HTML(`<input type="hidden" name="csrf_token" value="a&lt;b" />`)
+  if got := csrfField("a<b")(); got != want {
+    t.Errorf("got %q, want %q", got, want)
+  }
+}
@@ -0,0 +1,267 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// ListenConfig is where the server listens and how it does TLS.
+type ListenConfig struct {
+  // Addrs are the addresses served, e.g. 0.0.0.0:8443 and [::]:8443.
+  Addrs []string
+  // CertFile and KeyFile turn TLS on. They are read again on Reload.
+  CertFile string
+  KeyFile  string
+  // SelfSigned generates a self-signed pair when the files don't exist.
+  SelfSigned bool
+  // ClientCA asks clients for a certificate issued by one of its CAs.
+  ClientCA   string
+  ClientAuth tls.ClientAuthType
+  // RedirectAddrs answer plain HTTP with a redirect to the TLS
+  // listener.
+  RedirectAddrs []string
+}
+
+// ListenConfigFromEnv reads the listen settings. LISTEN defaults to
+// 127.0.0.1 on PORT, which is also the port of addresses without one.
+// TLS_SELF_SIGNED without files keeps the pair under DATA_DIR.
+func ListenConfigFromEnv(e utils.Env) (ListenConfig, error) {
+  cfg := ListenConfig{
+    CertFile:   e.TLS_CERT_FILE,
+    KeyFile:    e.TLS_KEY_FILE,
+    SelfSigned: e.TLS_SELF_SIGNED,
+    ClientCA:   e.TLS_CLIENT_CA,
+  }
+  listen := e.LISTEN
+  if listen == "" {
+    listen = "127.0.0.1"
+  }
+  var err error
+  if cfg.Addrs, err = listenAddrs("LISTEN", listen, e.PORT); err != nil {
+    return cfg, err
+  }
+  if e.HTTP_REDIRECT_LISTEN != "" {
+    if cfg.RedirectAddrs, err = listenAddrs("HTTP_REDIRECT_LISTEN", e.HTTP_REDIRECT_LISTEN, "80"); err != nil {
+      return cfg, err
+    }
+  }
+
+  if cfg.SelfSigned {
+    if cfg.CertFile == "" {
+      cfg.CertFile = filepath.Join(e.DATA_DIR, "tls", "cert.pem")
+    }
+    if cfg.KeyFile == "" {
+      cfg.KeyFile = filepath.Join(e.DATA_DIR, "tls", "key.pem")
+    }
+  }
+  if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+    return cfg, errors.New("TLS_CERT_FILE and TLS_KEY_FILE go together")
+  }
+  if !cfg.TLS() && (cfg.ClientCA != "" || len(cfg.RedirectAddrs) > 0) {
+    return cfg, errors.New("TLS_CLIENT_CA and HTTP_REDIRECT_LISTEN need TLS_CERT_FILE and TLS_KEY_FILE or TLS_SELF_SIGNED")
+  }
+  switch strings.ToLower(e.TLS_CLIENT_AUTH) {
+  case "", "require":
+    cfg.ClientAuth = tls.RequireAndVerifyClientCert
+  case "optional":
+    cfg.ClientAuth = tls.VerifyClientCertIfGiven
+  default:
+    return cfg, fmt.Errorf("TLS_CLIENT_AUTH: %q must be require or optional", e.TLS_CLIENT_AUTH)
+  }
+  if cfg.ClientCA == "" {
+    cfg.ClientAuth = tls.NoClientCert
+  }
+  return cfg, nil
+}
+
+// listenAddrs splits a comma separated list of addresses, adding port
+// to those without one. "[::]" and "::" are both IPv6.
+func listenAddrs(name, list, port string) ([]string, error) {
+  var out []string
+  for _, a := range splitEnvList(list) {
+    if _, _, err := net.SplitHostPort(a); err != nil {
+      host := strings.TrimSuffix(strings.TrimPrefix(a, "["), "]")
+      a = net.JoinHostPort(host, port)
+    }
+    if _, _, err := net.SplitHostPort(a); err != nil {
+      return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    out = append(out, a)
+  }
+  if len(out) == 0 {
+    return nil, fmt.Errorf("%s: no addresses", name)
+  }
+  return out, nil
+}
+
+// TLS reports whether the server speaks HTTPS.
+func (c ListenConfig) TLS() bool {
+  return c.CertFile != ""
+}
+
+// network picks the socket family of addr: IPv4 and IPv6 literals get
+// their own, so 0.0.0.0 and [::] on one port don't collide, and names
+// and an empty host listen on both.
+func network(addr string) string {
+  host, _, _ := net.SplitHostPort(addr)
+  ip, err := netip.ParseAddr(host)
+  switch {
+  case err != nil:
+    return "tcp"
+  case ip.Is4():
+    return "tcp4"
+  }
+  return "tcp6"
+}
+
+// Listeners are the sockets the server and its redirect answer on.
+type Listeners struct {
+  srv      *http.Server
+  redirect *http.Server
+  certs    *certStore
+  main     []net.Listener
+  plain    []net.Listener
+}
+
+// Listen binds every address of cfg for srv, preparing TLS first. No
+// address is served until Serve.
+func Listen(srv *http.Server, cfg ListenConfig) (*Listeners, error) {
+  l := &Listeners{srv: srv}
+  if cfg.TLS() {
+    if cfg.SelfSigned {
+      made, err := ensureSelfSigned(cfg.CertFile, cfg.KeyFile, cfg.Addrs)
+      if err != nil {
+        return nil, fmt.Errorf("self-signed certificate: %w", err)
+      }
+      if made {
+        utils.Warn("Generated a self-signed certificate in %s; browsers will warn until it is replaced", cfg.CertFile)
+      }
+    }
+    l.certs = &certStore{certFile: cfg.CertFile, keyFile: cfg.KeyFile, clientCA: cfg.ClientCA, clientAuth: cfg.ClientAuth}
+    if err := l.certs.load(); err != nil {
+      return nil, fmt.Errorf("TLS: %w", err)
+    }
+    srv.TLSConfig = l.certs.tlsConfig()
+  }
+
+  for _, addr := range cfg.Addrs {
+    ln, err := net.Listen(network(addr), addr)
+    if err != nil {
+      l.close()
+      return nil, err
+    }
+    l.main = append(l.main, ln)
+  }
+  if len(cfg.RedirectAddrs) > 0 {
+    _, port, _ := net.SplitHostPort(l.main[0].Addr().String())
+    l.redirect = &http.Server{
+      Handler:           redirectHTTPS(port),
+      ReadHeaderTimeout: 5 * time.Second,
+      IdleTimeout:       60 * time.Second,
+    }
+    for _, addr := range cfg.RedirectAddrs {
+      ln, err := net.Listen(network(addr), addr)
+      if err != nil {
+        l.close()
+        return nil, err
+      }
+      l.plain = append(l.plain, ln)
+    }
+  }
+  return l, nil
+}
+
+func (l *Listeners) close() {
+  for _, ln := range append(l.main, l.plain...) {
+    ln.Close()
+  }
+}
+
+// Serve serves every listener until Shutdown; a listener failing ends
+// the process.
+func (l *Listeners) Serve() {
+  scheme := "http"
+  if l.certs != nil {
+    scheme = "https"
+  }
+  for _, ln := range l.main {
+    utils.Info("Server running on %s://%s", scheme, ln.Addr())
+    go func() {
+      var err error
+      if l.certs != nil {
+        err = l.srv.ServeTLS(ln, "", "")
+      } else {
+        err = l.srv.Serve(ln)
+      }
+      if err != nil && !errors.Is(err, http.ErrServerClosed) {
+        utils.Fatal("Server failed on %s: %v", ln.Addr(), err)
+      }
+    }()
+  }
+  for _, ln := range l.plain {
+    utils.Info("Redirecting http://%s to HTTPS", ln.Addr())
+    go func() {
+      if err := l.redirect.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        utils.Fatal("Redirect failed on %s: %v", ln.Addr(), err)
+      }
+    }()
+  }
+}
+
+// Reload reads the certificate, key and client CAs again, e.g. after
+// a renewal. The old ones stay in use when that fails.
+func (l *Listeners) Reload() error {
+  if l.certs == nil {
+    return nil
+  }
+  if err := l.certs.load(); err != nil {
+    return err
+  }
+  utils.Info("Reloaded TLS certificate %s", l.certs.certFile)
+  return nil
+}
+
+// Shutdown stops the redirect and the server gracefully.
+func (l *Listeners) Shutdown(ctx context.Context) error {
+  var errs []error
+  if l.redirect != nil {
+    errs = append(errs, l.redirect.Shutdown(ctx))
+  }
+  errs = append(errs, l.srv.Shutdown(ctx))
+  return errors.Join(errs...)
+}
+
+// redirectHTTPS sends plain HTTP requests to the same host and path on
+// the HTTPS port.
+func redirectHTTPS(port string) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+      host = h
+    }
+    if host == "" {
+      http.Error(w, "Use HTTPS", http.StatusBadRequest)
+      return
+    }
+    if port != "443" {
+      host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+    } else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+      host = "[" + host + "]"
+    }
+    status := http.StatusMovedPermanently
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+      status = http.StatusPermanentRedirect
+    }
+    http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+  })
+}
@@ -0,0 +1,509 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rannday/kea-web/internal/audit"
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/rogue"
+)
+
+// fakeAgent is a Kea control agent holding a small configuration and a
+// host backend, enough for every API endpoint to succeed.
+type fakeAgent struct {
+  mu    sync.Mutex
+  hosts map[string]map[string]any
+}
+
+var fakeConfig = map[string]string{
+  kea.ServiceDHCP4: `{"Dhcp4": {
+    "valid-lifetime": 4000,
+    "lease-database": {"type": "mysql", "name": "kea", "password": "secret"},
+    "subnet4": [{"id": 1, "subnet": "192.0.2.0/24", "pools": [{"pool": "192.0.2.10-192.0.2.100"}]}],
+    "shared-networks": [{"name": "net1", "subnet4": [{"id": 2, "subnet": "10.0.0.0/24", "pools": [{"pool": "10.0.0.10-10.0.0.99"}]}]}]
+  }, "hash": "x"}`,
+  kea.ServiceDHCP6: `{"Dhcp6": {"subnet6": [{"id": 1, "subnet": "2001:db8:1::/64", "pools": [{"pool": "2001:db8:1::10-2001:db8:1::ff"}]}]}, "hash": "y"}`,
+}
+
+// fakeLeases are the DHCPv4 leases of the agent, in address order.
+var fakeLeases = []struct {
+  addr   netip.Addr
+  subnet int64
+}{
+  {netip.MustParseAddr("10.0.0.51"), 2},
+  {netip.MustParseAddr("10.0.0.53"), 2},
+  {netip.MustParseAddr("192.0.2.50"), 1},
+  {netip.MustParseAddr("192.0.2.52"), 1},
+  {netip.MustParseAddr("192.0.2.54"), 1},
+}
+
+func (f *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  var req struct {
+    Command   string         `json:"command"`
+    Service   []string       `json:"service"`
+    Arguments map[string]any `json:"arguments"`
+  }
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  service := ""
+  if len(req.Service) > 0 {
+    service = req.Service[0]
+  }
+  answer := func(result int, text string, args any) {
+    resp := map[string]any{"result": result, "text": text}
+    if args != nil {
+      resp["arguments"] = args
+    }
+    json.NewEncoder(w).Encode([]any{resp})
+  }
+  hostKey := func(subnet any, typ, id any) string {
+    return fmt.Sprintf("%s/%v/%v/%v", service, subnet, typ, id)
+  }
+
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  switch req.Command {
+  case "config-get":
+    answer(kea.ResultSuccess, "", json.RawMessage(fakeConfig[service]))
+  case "config-test", "config-set", "config-write":
+    answer(kea.ResultSuccess, "ok", nil)
+  case "status-get":
+    answer(kea.ResultSuccess, "", map[string]any{"pid": 100, "uptime": 3600, "reload": 60, "multi-threading-enabled": true})
+  case "version-get":
+    answer(kea.ResultSuccess, "2.6.1", nil)
+  case "statistic-get-all":
+    answer(kea.ResultSuccess, "", map[string]any{
+      "subnet[1].total-addresses":    [][]any{{91, "2026-10-19 04:00:00.000001"}},
+      "subnet[1].assigned-addresses": [][]any{{10, "2026-10-19 04:00:00.000001"}},
+      "subnet[1].declined-addresses": [][]any{{0, "2026-10-19 04:00:00.000001"}},
+    })
+  case "lease6-get-page":
+    answer(kea.ResultEmpty, "0 leases found", nil)
+  case "lease4-get-page":
+    var leases []any
+    for i, l := range fakeLeases {
+      if from, _ := req.Arguments["from"].(string); from != "start" && l.addr.Compare(netip.MustParseAddr(from)) <= 0 {
+        continue
+      }
+      leases = append(leases, map[string]any{
+        "ip-address": l.addr.String(), "subnet-id": l.subnet, "cltt": 1700000000, "valid-lft": 3600,
+        "hw-address": fmt.Sprintf("aa:bb:cc:dd:ee:%02x", i+1), "hostname": "a", "state": 0,
+      })
+    }
+    if len(leases) == 0 {
+      answer(kea.ResultEmpty, "0 leases found", nil)
+      return
+    }
+    answer(kea.ResultSuccess, "", map[string]any{"count": len(leases), "leases": leases})
+  case "reservation-get-page":
+    var hosts []any
+    for k, h := range f.hosts {
+      if strings.HasPrefix(k, service+"/") {
+        hosts = append(hosts, h)
+      }
+    }
+    if len(hosts) == 0 || req.Arguments["from"] != nil {
+      answer(kea.ResultEmpty, "0 hosts found", nil)
+      return
+    }
+    answer(kea.ResultSuccess, "", map[string]any{"count": len(hosts), "hosts": hosts, "next": map[string]any{"from": 0, "source-index": 1}})
+  case "reservation-get":
+    a := req.Arguments
+    if h, ok := f.hosts[hostKey(a["subnet-id"], a["identifier-type"], a["identifier"])]; ok {
+      answer(kea.ResultSuccess, "", h)
+      return
+    }
+    answer(kea.ResultEmpty, "Host not found.", nil)
+  case "reservation-add", "reservation-update":
+    h := req.Arguments["reservation"].(map[string]any)
+    var typ string
+    for _, t := range kea.IdentifierTypes {
+      if h[t] != nil {
+        typ = t
+        break
+      }
+    }
+    key := hostKey(h["subnet-id"], typ, h[typ])
+    if _, ok := f.hosts[key]; ok == (req.Command == "reservation-add") {
+      answer(kea.ResultError, "Host already exists or not found.", nil)
+      return
+    }
+    f.hosts[key] = h
+    answer(kea.ResultSuccess, "Host added.", nil)
+  case "reservation-del":
+    a := req.Arguments
+    key := hostKey(a["subnet-id"], a["identifier-type"], a["identifier"])
+    if _, ok := f.hosts[key]; !ok {
+      answer(kea.ResultEmpty, "Host not deleted (not found).", nil)
+      return
+    }
+    delete(f.hosts, key)
+    answer(kea.ResultSuccess, "Host deleted.", nil)
+  default:
+    answer(kea.ResultUnsupported, "'"+req.Command+"' command not supported.", nil)
+  }
+}
+
+// testServer mounts the routes against a fake control agent, the host
+// fixture of the linux package and a configuration directory.
+func testServer(t *testing.T) *Server {
+  t.Helper()
+  agent := httptest.NewServer(&fakeAgent{hosts: map[string]map[string]any{
+    kea.ServiceDHCP4 + "/1/hw-address/aa:bb:cc:dd:ee:01": {"subnet-id": 1, "hw-address": "aa:bb:cc:dd:ee:01", "ip-address": "192.0.2.11", "hostname": "a"},
+  }})
+  t.Cleanup(agent.Close)
+
+  dir := t.TempDir()
+  confDir := filepath.Join(dir, "etc")
+  if err := os.MkdirAll(confDir, 0o755); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(filepath.Join(confDir, "kea-dhcp4.conf"), []byte(`{"Dhcp4": {"valid-lifetime": 4000}}`), 0o644); err != nil {
+    t.Fatal(err)
+  }
+  users, err := auth.NewUsers(filepath.Join(dir, "users.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  log, err := audit.Open(audit.Config{File: filepath.Join(dir, "audit.log")})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  s := &Server{
+    kea:       kea.NewClient(kea.Config{URL: agent.URL, Timeout: 5 * time.Second}),
+    units:     linux.NewFake(linux.DefaultUnits...),
+    unitCfg:   linux.Config{Manager: linux.ManagerFake, Units: linux.DefaultUnits},
+    host:      linux.NewHost("../integrations/linux/testdata/host"),
+    confFiles: kea.NewConfigFiles(confDir, ""),
+    auth:      auth.New(users, auth.NewSessions(time.Hour, time.Hour)),
+    audit:     log,
+  }
+  s.kea.SetCommandCheck(auth.CheckCommand)
+  s.rogue = rogue.NewDetector(s.kea, s.host, nil)
+  return s
+}
+
+// apiCall is a request for one documented operation. Fields are
+// functions so that calls can use what earlier ones answered.
+type apiCall struct {
+  op      string
+  path    func() string
+  body    func() any
+  ifMatch func() string
+  // keep gets the data of the answer.
+  keep func(data json.RawMessage, etag string)
+}
+
+func TestOpenAPIMatchesHandlers(t *testing.T) {
+  s := testServer(t)
+  mux := routes(s)
+  admin := auth.Identity{User: "admin", Access: auth.Access{Role: auth.RoleAdmin}}
+
+  do := func(method, path string, body any, ifMatch string) *httptest.ResponseRecorder {
+    var rd io.Reader
+    if body != nil {
+      b, err := json.Marshal(body)
+      if err != nil {
+        t.Fatal(err)
+      }
+      rd = bytes.NewReader(b)
+    }
+    r := httptest.NewRequest(method, path, rd)
+    if body != nil {
+      r.Header.Set("Content-Type", "application/json")
+    }
+    if ifMatch != "" {
+      r.Header.Set("If-Match", ifMatch)
+    }
+    r = r.WithContext(auth.WithIdentity(r.Context(), admin))
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    return w
+  }
+
+  w := do("GET", "/api/openapi.json", nil, "")
+  var doc map[string]any
+  if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+    t.Fatalf("openapi.json: %v", err)
+  }
+  paths := doc["paths"].(map[string]any)
+
+  var documented []string
+  for path, item := range paths {
+    for method := range item.(map[string]any) {
+      documented = append(documented, strings.ToUpper(method)+" "+path)
+    }
+  }
+  sort.Strings(documented)
+  var mounted []string
+  for _, r := range apiRoutes(s) {
+    mounted = append(mounted, r.pattern)
+  }
+  for _, p := range mounted {
+    if !slices.Contains(documented, p) {
+      t.Errorf("%s is mounted but not documented", p)
+    }
+  }
+  for _, d := range documented {
+    if !slices.Contains(mounted, d) {
+      t.Errorf("%s is documented but not mounted", d)
+    }
+  }
+
+  const resPath = "/api/v1/reservations/dhcp4/1/hw-address/aa:bb:cc:dd:ee:02"
+  var configData, configETag, resETag, fileETag, version string
+  calls := []apiCall{
+    {op: "GET /api/v1/health"},
+    {op: "GET /api/v1/ha"},
+    {op: "GET /api/v1/subnets", path: func() string { return "/api/v1/subnets?service=dhcp4" }},
+    {op: "GET /api/v1/subnets/{service}/{id}", path: func() string { return "/api/v1/subnets/dhcp4/2" }},
+    {op: "GET /api/v1/pools"},
+    {op: "GET /api/v1/statistics"},
+    {op: "GET /api/v1/leases", path: func() string { return "/api/v1/leases?limit=1" }},
+    {op: "GET /api/v1/reservations"},
+    {op: "POST /api/v1/reservations",
+      path: func() string { return "/api/v1/reservations?service=dhcp4" },
+      body: func() any {
+        return kea.Reservation{SubnetID: 1, HWAddress: "aa:bb:cc:dd:ee:02", IPAddress: "192.0.2.12", Hostname: "b"}
+      }},
+    {op: "GET /api/v1/reservations/{service}/{subnet}/{type}/{identifier}",
+      path: func() string { return resPath },
+      keep: func(_ json.RawMessage, etag string) { resETag = etag }},
+    {op: "PUT /api/v1/reservations/{service}/{subnet}/{type}/{identifier}",
+      path: func() string { return resPath },
+      body: func() any {
+        return kea.Reservation{SubnetID: 1, HWAddress: "aa:bb:cc:dd:ee:02", IPAddress: "192.0.2.13", Hostname: "b2"}
+      },
+      ifMatch: func() string { return resETag }},
+    {op: "DELETE /api/v1/reservations/{service}/{subnet}/{type}/{identifier}", path: func() string { return resPath }},
+    {op: "GET /api/v1/config/{service}",
+      path: func() string { return "/api/v1/config/dhcp4" },
+      keep: func(data json.RawMessage, etag string) { configData, configETag = string(data), etag }},
+    {op: "PUT /api/v1/config/{service}",
+      path:    func() string { return "/api/v1/config/dhcp4" },
+      body:    func() any { return json.RawMessage(configData) },
+      ifMatch: func() string { return configETag }},
+    {op: "GET /api/v1/config-files"},
+    {op: "GET /api/v1/config-files/{name}",
+      path: func() string { return "/api/v1/config-files/kea-dhcp4.conf" },
+      keep: func(_ json.RawMessage, etag string) { fileETag = etag }},
+    {op: "PUT /api/v1/config-files/{name}",
+      path:    func() string { return "/api/v1/config-files/kea-dhcp4.conf" },
+      body:    func() any { return map[string]any{"content": `{"Dhcp4": {"valid-lifetime": 7200}}`, "kea-test": false} },
+      ifMatch: func() string { return fileETag }},
+    {op: "GET /api/v1/config-files/{name}/versions",
+      path: func() string { return "/api/v1/config-files/kea-dhcp4.conf/versions" },
+      keep: func(data json.RawMessage, _ string) {
+        var vs []struct {
+          Version string `json:"version"`
+        }
+        if json.Unmarshal(data, &vs) == nil && len(vs) > 0 {
+          version = vs[0].Version
+        }
+      }},
+    {op: "GET /api/v1/config-files/{name}/versions/{version}",
+      path: func() string { return "/api/v1/config-files/kea-dhcp4.conf/versions/" + version }},
+  }
+
+  called := map[string]bool{}
+  for _, c := range calls {
+    called[c.op] = true
+    method, pattern, _ := strings.Cut(c.op, " ")
+    op, ok := paths[pattern].(map[string]any)[strings.ToLower(method)].(map[string]any)
+    if !ok {
+      t.Errorf("%s: not in the document", c.op)
+      continue
+    }
+
+    path := pattern
+    if c.path != nil {
+      path = c.path()
+    }
+    var body any
+    if c.body != nil {
+      body = c.body()
+      if rb, ok := op["requestBody"].(map[string]any); ok {
+        schema := rb["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+        for _, p := range checkSchema(doc, schema, jsonValue(t, body), "body") {
+          t.Errorf("%s: request %s", c.op, p)
+        }
+      } else {
+        t.Errorf("%s: sends a body the document doesn't describe", c.op)
+      }
+    }
+    ifMatch := ""
+    if c.ifMatch != nil {
+      if ifMatch = c.ifMatch(); ifMatch == "" {
+        t.Errorf("%s: no ETag to send", c.op)
+      }
+    }
+
+    w := do(method, path, body, ifMatch)
+    responses := op["responses"].(map[string]any)
+    var success string
+    for code := range responses {
+      if code[0] == '2' {
+        success = code
+      }
+    }
+    if strconv.Itoa(w.Code) != success {
+      t.Errorf("%s %s: status %d, want %s: %s", method, path, w.Code, success, w.Body)
+      continue
+    }
+    resp := responses[success].(map[string]any)
+    if headers, ok := resp["headers"].(map[string]any); ok {
+      for h := range headers {
+        if w.Header().Get(h) == "" {
+          t.Errorf("%s: no %s header", c.op, h)
+        }
+      }
+    }
+    content, ok := resp["content"].(map[string]any)
+    if !ok {
+      if w.Body.Len() > 0 {
+        t.Errorf("%s: undocumented body %s", c.op, w.Body)
+      }
+      continue
+    }
+    schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+    var got any
+    if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+      t.Errorf("%s: %v", c.op, err)
+      continue
+    }
+    for _, p := range checkSchema(doc, schema, got, "response") {
+      t.Errorf("%s: %s", c.op, p)
+    }
+    if c.keep != nil {
+      var env struct {
+        Data json.RawMessage `json:"data"`
+      }
+      json.Unmarshal(w.Body.Bytes(), &env)
+      c.keep(env.Data, w.Header().Get("ETag"))
+    }
+  }
+  for _, d := range documented {
+    if !called[d] {
+      t.Errorf("%s: no test call", d)
+    }
+  }
+
+  // Failures answer the error envelope.
+  for _, path := range []string{"/api/v1/subnets/dhcp4/99", "/api/v1/subnets?service=dhcp9", "/api/v1/nosuch"} {
+    w := do("GET", path, nil, "")
+    var got any
+    json.Unmarshal(w.Body.Bytes(), &got)
+    for _, p := range checkSchema(doc, map[string]any{"$ref": "#/components/schemas/Error"}, got, "error") {
+      t.Errorf("GET %s (%d): %s", path, w.Code, p)
+    }
+  }
+}
+
+// jsonValue is v as encoding/json decodes it into an any.
+func jsonValue(t *testing.T, v any) any {
+  b, err := json.Marshal(v)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var out any
+  json.Unmarshal(b, &out)
+  return out
+}
+
+// checkSchema validates v against the subset of JSON Schema the document
+// uses. Objects are closed: a property the schema doesn't list is a
+// problem unless additionalProperties allows it.
+func checkSchema(doc, schema map[string]any, v any, at string) []string {
+  if ref, ok := schema["$ref"].(string); ok {
+    name := strings.TrimPrefix(ref, "#/components/schemas/")
+    target, ok := doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+    if !ok {
+      return []string{at + ": unknown $ref " + ref}
+    }
+    return checkSchema(doc, target, v, at)
+  }
+  typ, _ := schema["type"].(string)
+  wrong := func() []string {
+    return []string{fmt.Sprintf("%s: %T is not %s", at, v, typ)}
+  }
+  switch typ {
+  case "":
+    return nil
+  case "string":
+    if _, ok := v.(string); !ok {
+      return wrong()
+    }
+  case "boolean":
+    if _, ok := v.(bool); !ok {
+      return wrong()
+    }
+  case "integer", "number":
+    n, ok := v.(float64)
+    if !ok || (typ == "integer" && n != float64(int64(n))) {
+      return wrong()
+    }
+    if min, ok := schema["minimum"].(float64); ok && n < min {
+      return []string{fmt.Sprintf("%s: %v is below %v", at, n, min)}
+    }
+  case "array":
+    a, ok := v.([]any)
+    if !ok {
+      return wrong()
+    }
+    if n, ok := schema["minItems"].(float64); ok && float64(len(a)) < n {
+      return []string{fmt.Sprintf("%s: %d items, want %v", at, len(a), n)}
+    }
+    var out []string
+    if items, ok := schema["items"].(map[string]any); ok {
+      for i, e := range a {
+        out = append(out, checkSchema(doc, items, e, fmt.Sprintf("%s[%d]", at, i))...)
+      }
+    }
+    return out
+  case "object":
+    o, ok := v.(map[string]any)
+    if !ok {
+      return wrong()
+    }
+    var out []string
+    props, _ := schema["properties"].(map[string]any)
+    required, _ := schema["required"].([]any)
+    for _, r := range required {
+      if _, ok := o[r.(string)]; !ok {
+        out = append(out, fmt.Sprintf("%s: no %s", at, r))
+      }
+    }
+    extra, open := schema["additionalProperties"].(map[string]any)
+    for k, e := range o {
+      if p, ok := props[k].(map[string]any); ok {
+        out = append(out, checkSchema(doc, p, e, at+"."+k)...)
+      } else if open {
+        out = append(out, checkSchema(doc, extra, e, at+"."+k)...)
+      } else if props != nil {
+        out = append(out, fmt.Sprintf("%s: undocumented property %s", at, k))
+      }
+    }
+    return out
+  }
+  return nil
+}
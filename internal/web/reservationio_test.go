@@ -0,0 +1,79 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+)
+
+var planField = regexp.MustCompile(`name="plan" value="([0-9a-f]+)"`)
+
+func TestReservationImportPlanChanged(t *testing.T) {
+  s := testServer(t)
+  mux := routes(s)
+  admin := auth.Identity{User: "admin", Access: auth.Access{Role: auth.RoleAdmin}}
+  serve := func(r *http.Request) string {
+    t.Helper()
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), admin)))
+    if w.Code != http.StatusOK {
+      t.Fatalf("%s %s: status %d", r.Method, r.URL.Path, w.Code)
+    }
+    return html.UnescapeString(w.Body.String())
+  }
+  post := func(form url.Values) string {
+    r := httptest.NewRequest(http.MethodPost, "/reservations/import", strings.NewReader(form.Encode()))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return serve(r)
+  }
+
+  form := url.Values{
+    "family": {"4"},
+    "format": {"csv"},
+    "content": {"subnet-id,identifier-type,identifier,ip-address,hostname\n" +
+      "1,hw-address,aa:bb:cc:dd:ee:01,192.0.2.11,a-renamed\n" +
+      "1,hw-address,aa:bb:cc:dd:ee:03,192.0.2.13,c\n"},
+    "action": {"preview"},
+  }
+  m := planField.FindStringSubmatch(post(form))
+  if m == nil {
+    t.Fatal("preview has no plan field")
+  }
+  preview := m[1]
+
+  // Someone adds the second reservation before the import is applied,
+  // turning its add into an update.
+  b, _ := json.Marshal(kea.Reservation{SubnetID: 1, HWAddress: "aa:bb:cc:dd:ee:03", IPAddress: "192.0.2.14", Hostname: "c-other"})
+  r := httptest.NewRequest(http.MethodPost, "/api/v1/reservations?service=dhcp4", bytes.NewReader(b))
+  r.Header.Set("Content-Type", "application/json")
+  w := httptest.NewRecorder()
+  mux.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), admin)))
+  if w.Code != http.StatusCreated {
+    t.Fatalf("add reservation: status %d: %s", w.Code, w.Body)
+  }
+
+  form.Set("action", "apply")
+  form.Set("plan", preview)
+  body := post(form)
+  if !strings.Contains(body, "The plan changed since the preview") || strings.Contains(body, "Applied") {
+    t.Fatal("apply went ahead with a changed plan")
+  }
+  m = planField.FindStringSubmatch(body)
+  if m == nil || m[1] == preview {
+    t.Fatalf("refused apply shows plan %v, preview had %s", m, preview)
+  }
+
+  form.Set("plan", m[1])
+  if body := post(form); !strings.Contains(body, "Applied 2 rows, skipped 0") {
+    t.Errorf("apply of the new plan: %s", body)
+  }
+}
@@ -3,14 +3,73 @@ package web
 import (
 	"net/http"
 
+	"github.com/rannday/kea-web/internal/auth"
 	"github.com/rannday/kea-web/internal/web/handlers"
 	"github.com/rannday/kea-web/internal/web/handlers/pages"
 )
 
-func routes(_ any) http.Handler {
+func routes(s *Server) http.Handler {
   mux := http.NewServeMux()
 
-  mux.HandleFunc("/", pages.HandleIndex)
+  mux.HandleFunc("/", pages.Index(s.kea, s.db, s.host, s.rogue))
+
+  mux.HandleFunc("/api/", pages.APINotFound())
+  mux.HandleFunc("GET /api-docs", pages.APIDocs())
+  mux.HandleFunc("GET /api/openapi.json", pages.OpenAPISpec())
+  for _, r := range apiRoutes(s) {
+    mux.HandleFunc(r.pattern, r.handler)
+  }
+
+  mux.HandleFunc("GET /audit", auth.Require(auth.PermUsers, pages.AuditLog(s.audit)))
+  mux.HandleFunc("GET /audit.csv", auth.Require(auth.PermUsers, pages.AuditLogCSV(s.audit)))
+
+  mux.HandleFunc("GET /capture", pages.Capture(s.rogue))
+  mux.HandleFunc("POST /capture", pages.CaptureAnalyze(s.rogue))
+
+  mux.HandleFunc("GET /config-backend", pages.ConfigBackend(s.kea))
+  mux.HandleFunc("POST /config-backend", auth.Require(auth.PermConfig, pages.ConfigBackendAction(s.kea)))
+
+  mux.HandleFunc("GET /config-files", auth.Require(auth.PermConfig, pages.ConfigFiles(s.confFiles, s.unitCfg, s.units)))
+  mux.HandleFunc("POST /config-files", auth.Require(auth.PermConfig, pages.ConfigFilesAction(s.kea, s.confFiles, s.unitCfg, s.units)))
+
+  mux.HandleFunc("GET /interfaces", pages.Interfaces(s.kea, s.host))
+  mux.HandleFunc("POST /interfaces", auth.Require(auth.PermConfig, pages.InterfacesAction(s.kea)))
+
+  mux.HandleFunc("GET /leases", pages.Leases())
+  mux.HandleFunc("GET /leases/export", pages.LeaseExport(s.kea, s.db))
+
+  mux.HandleFunc("GET /login", pages.Login(s.auth))
+  mux.HandleFunc("POST /login", pages.LoginAction(s.auth))
+  mux.HandleFunc("GET /login/oidc", pages.OIDCLogin(s.auth))
+  mux.HandleFunc("GET /login/oidc/callback", pages.OIDCCallback(s.auth))
+  mux.HandleFunc("POST /logout", pages.Logout(s.auth))
+
+  mux.HandleFunc("GET /logs", pages.Logs(s.kea, s.host))
+  mux.HandleFunc("GET /logs/stream", pages.LogStream(s.kea, s.host))
+
+  mux.HandleFunc("GET /migrate", pages.Migrate())
+  mux.HandleFunc("POST /migrate", pages.MigrateAction())
+
+  mux.HandleFunc("GET /reservations/audit", pages.ReservationAudit(s.kea, s.db))
+  mux.HandleFunc("GET /reservations/audit.csv", pages.ReservationAuditCSV(s.kea, s.db))
+  mux.HandleFunc("POST /reservations/audit/delete", auth.Require(auth.PermLeases, pages.ReservationAuditDelete(s.kea, s.db)))
+  mux.HandleFunc("GET /reservations/import", pages.ReservationImport())
+  mux.HandleFunc("POST /reservations/import", auth.Require(auth.PermLeases, pages.ReservationImportAction(s.kea, s.db)))
+  mux.HandleFunc("GET /reservations/export", pages.ReservationExport(s.kea, s.db))
+
+  mux.HandleFunc("GET /rogue", pages.Rogue(s.rogue))
+  mux.HandleFunc("POST /rogue/dismiss", auth.Require(auth.PermLeases, pages.RogueDismiss(s.rogue)))
+
+  mux.HandleFunc("GET /services", pages.Services(s.units, s.unitCfg))
+  mux.HandleFunc("POST /services/{unit}/{action}", auth.Require(auth.PermConfig, pages.ServiceAction(s.units, s.unitCfg)))
+
+  mux.HandleFunc("GET /simulate", pages.Simulate(s.kea, s.host))
+
+  mux.HandleFunc("GET /tokens", pages.Tokens(s.auth))
+  mux.HandleFunc("POST /tokens", pages.TokensAction(s.auth))
+
+  mux.HandleFunc("GET /users", auth.Require(auth.PermUsers, pages.Users(s.auth)))
+  mux.HandleFunc("POST /users", auth.Require(auth.PermUsers, pages.UsersAction(s.auth)))
 
   mux.HandleFunc("/sw.js", handlers.ServiceWorker())
   mux.HandleFunc("/robots.txt", handlers.RobotsTxt())
@@ -24,3 +83,35 @@ func routes(_ any) http.Handler {
 
   return mux
 }
+
+// apiRoute is a route of the JSON API.
+type apiRoute struct {
+  pattern string
+  handler http.HandlerFunc
+}
+
+// apiRoutes are the routes of the JSON API. Each is in the OpenAPI
+// document, which the tests check against this list.
+func apiRoutes(s *Server) []apiRoute {
+  return []apiRoute{
+    {"GET /api/v1/config-files", pages.APIRequire(auth.PermConfig, pages.APIConfigFiles(s.confFiles))},
+    {"GET /api/v1/config-files/{name}", pages.APIRequire(auth.PermConfig, pages.APIConfigFile(s.confFiles))},
+    {"PUT /api/v1/config-files/{name}", pages.APIRequire(auth.PermConfig, pages.APIConfigFileUpdate(s.kea, s.confFiles))},
+    {"GET /api/v1/config-files/{name}/versions", pages.APIRequire(auth.PermConfig, pages.APIConfigVersions(s.confFiles))},
+    {"GET /api/v1/config-files/{name}/versions/{version}", pages.APIRequire(auth.PermConfig, pages.APIConfigVersion(s.confFiles))},
+    {"GET /api/v1/config/{service}", pages.APIConfig(s.kea)},
+    {"PUT /api/v1/config/{service}", pages.APIRequire(auth.PermConfig, pages.APIConfigUpdate(s.kea))},
+    {"GET /api/v1/ha", pages.APIHA(s.kea)},
+    {"GET /api/v1/health", pages.APIHealth(s.kea, s.db, s.host)},
+    {"GET /api/v1/leases", pages.APILeases(s.kea, s.db)},
+    {"GET /api/v1/pools", pages.APIPools(s.kea)},
+    {"GET /api/v1/reservations", pages.APIReservations(s.kea, s.db)},
+    {"POST /api/v1/reservations", pages.APIRequire(auth.PermLeases, pages.APIReservationCreate(s.kea, s.db))},
+    {"GET /api/v1/reservations/{service}/{subnet}/{type}/{identifier}", pages.APIReservation(s.kea, s.db)},
+    {"PUT /api/v1/reservations/{service}/{subnet}/{type}/{identifier}", pages.APIRequire(auth.PermLeases, pages.APIReservationUpdate(s.kea, s.db))},
+    {"DELETE /api/v1/reservations/{service}/{subnet}/{type}/{identifier}", pages.APIRequire(auth.PermLeases, pages.APIReservationDelete(s.kea, s.db))},
+    {"GET /api/v1/statistics", pages.APIStatistics(s.kea)},
+    {"GET /api/v1/subnets", pages.APISubnets(s.kea)},
+    {"GET /api/v1/subnets/{service}/{id}", pages.APISubnet(s.kea)},
+  }
+}
@@ -1,29 +1,129 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/rannday/kea-web/internal/audit"
+	"github.com/rannday/kea-web/internal/auth"
+	"github.com/rannday/kea-web/internal/integrations/kea"
+	"github.com/rannday/kea-web/internal/integrations/linux"
+	"github.com/rannday/kea-web/internal/integrations/sql"
+	"github.com/rannday/kea-web/internal/rogue"
+	"github.com/rannday/kea-web/internal/utils"
 	"github.com/rannday/kea-web/internal/web/handlers"
 )
 
 type Server struct {
   httpServer *http.Server
+  kea        *kea.Client
+  db         *sql.DB
+  units      linux.UnitManager
+  unitCfg    linux.Config
+  host       *linux.Host
+  confFiles  *kea.ConfigFiles
+  rogue      *rogue.Detector
+  auth       *auth.Auth
+  audit      *audit.Log
 }
 
-func NewServer(addr string) *http.Server {
+func NewServer(env utils.Env) *http.Server {
   handlers.SetBundledAssets(handlers.BundledCSS, handlers.BundledJS)
-  
-  s := &Server{}
+
+  db, err := sql.Open(sql.ConfigFromEnv(env))
+  if err != nil {
+    utils.Error("Database disabled: %v", err)
+  }
+
+  users, err := auth.NewUsers(filepath.Join(env.DATA_DIR, "users.json"))
+  if err != nil {
+    utils.Fatal("User accounts: %v", err)
+  }
+  tokens, err := auth.NewTokens(filepath.Join(env.DATA_DIR, "tokens.json"))
+  if err != nil {
+    utils.Fatal("API tokens: %v", err)
+  }
+  oidcCfg, err := auth.OIDCConfigFromEnv(env)
+  if err != nil {
+    utils.Fatal("OIDC: %v", err)
+  }
+  ldapCfg, err := auth.LDAPConfigFromEnv(env)
+  if err != nil {
+    utils.Fatal("LDAP: %v", err)
+  }
+  auditCfg := audit.ConfigFromEnv(env)
+  auditLog, err := audit.Open(auditCfg)
+  if err != nil {
+    utils.Fatal("Audit log: %v", err)
+  }
+  if auditCfg.Syslog != "" {
+    utils.Info("Audit events go to syslog at %s", auditCfg.Syslog)
+  }
+  if users.Empty() && !oidcCfg.Enabled() && !ldapCfg.Enabled() {
+    utils.Warn("No user accounts yet; create one with: kea-web user add NAME")
+  }
+
+  unitCfg := linux.ConfigFromEnv(env)
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  units, err := linux.NewUnitManager(ctx, unitCfg)
+  cancel()
+  if err != nil && !errors.Is(err, linux.ErrUnitsDisabled) {
+    utils.Error("Unit control disabled: %v", err)
+  }
+
+  s := &Server{
+    kea:       kea.NewClient(kea.ConfigFromEnv(env)),
+    db:        db,
+    units:     units,
+    unitCfg:   unitCfg,
+    host:      linux.NewHost(env.HOST_ROOT),
+    confFiles: kea.NewConfigFiles(env.KEA_CONFIG_DIR, env.KEA_BACKUP_DIR),
+    auth:      auth.New(users, auth.NewSessions(env.SESSION_IDLE_TIMEOUT, env.SESSION_MAX_AGE)),
+    audit:     auditLog,
+  }
+  s.auth.Tokens = tokens
+  if oidcCfg.Enabled() {
+    s.auth.OIDC = auth.NewOIDC(oidcCfg)
+    utils.Info("OIDC sign-in through %s", oidcCfg.Issuer)
+  }
+  if ldapCfg.Enabled() {
+    if s.auth.LDAP, err = auth.NewLDAP(ldapCfg); err != nil {
+      utils.Fatal("LDAP: %v", err)
+    }
+    utils.Info("LDAP sign-in through %s", ldapCfg.URL)
+  }
+  s.kea.SetCommandCheck(auth.CheckCommand)
+  s.kea.SetCommandHook(s.audit.KeaCommand)
+  s.rogue = rogue.NewDetector(s.kea, s.host, splitEnvList(env.KEA_KNOWN_SERVERS))
   mux := routes(s)
 
   s.httpServer = &http.Server{
-    Addr:         addr,
-    Handler:      mux,
+    Handler:      s.auth.Middleware(s.audit.Middleware(mux)),
     ReadTimeout:  5 * time.Second,
     WriteTimeout: 10 * time.Second,
     IdleTimeout:  60 * time.Second,
   }
 
+  if env.KEA_STATS_POLL > 0 {
+    ctx, stop := context.WithCancel(context.Background())
+    s.httpServer.RegisterOnShutdown(stop)
+    go s.rogue.Run(ctx, time.Duration(env.KEA_STATS_POLL)*time.Second)
+  }
+
   return s.httpServer
 }
+
+// splitEnvList splits a comma separated environment variable.
+func splitEnvList(v string) []string {
+  var out []string
+  for _, item := range strings.Split(v, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      out = append(out, item)
+    }
+  }
+  return out
+}
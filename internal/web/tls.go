@@ -0,0 +1,172 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rannday/kea-web/internal/utils"
+)
+
+// selfSignedValidity is how long a generated certificate is valid.
+const selfSignedValidity = 2 * 365 * 24 * time.Hour
+
+// certStore holds the server certificate and the CAs trusted for client
+// certificates, and swaps them when the files are reloaded. Handshakes
+// in progress keep the pair they started with.
+type certStore struct {
+  certFile   string
+  keyFile    string
+  clientCA   string
+  clientAuth tls.ClientAuthType
+
+  mu   sync.RWMutex
+  cert *tls.Certificate
+  cas  *x509.CertPool
+}
+
+// load reads the certificate, key and client CAs. On error the ones
+// loaded before stay in use.
+func (c *certStore) load() error {
+  cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+  if err != nil {
+    return err
+  }
+  var cas *x509.CertPool
+  if c.clientCA != "" {
+    data, err := os.ReadFile(c.clientCA)
+    if err != nil {
+      return err
+    }
+    cas = x509.NewCertPool()
+    if !cas.AppendCertsFromPEM(data) {
+      return fmt.Errorf("%s: no PEM certificates", c.clientCA)
+    }
+  }
+  if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+    utils.Warn("TLS certificate %s expired on %s", c.certFile, cert.Leaf.NotAfter.Format(time.DateOnly))
+  }
+
+  c.mu.Lock()
+  c.cert, c.cas = &cert, cas
+  c.mu.Unlock()
+  return nil
+}
+
+// tlsConfig is the server's TLS configuration. Each handshake reads the
+// current certificate and client CAs.
+func (c *certStore) tlsConfig() *tls.Config {
+  current := func() *tls.Config {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return &tls.Config{
+      MinVersion:   tls.VersionTLS12,
+      Certificates: []tls.Certificate{*c.cert},
+      ClientAuth:   c.clientAuth,
+      ClientCAs:    c.cas,
+      NextProtos:   []string{"h2", "http/1.1"},
+    }
+  }
+  return &tls.Config{
+    MinVersion: tls.VersionTLS12,
+    GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+      c.mu.RLock()
+      defer c.mu.RUnlock()
+      return c.cert, nil
+    },
+    GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+      return current(), nil
+    },
+  }
+}
+
+// ensureSelfSigned writes a self-signed certificate and key for the
+// host's name and the listen addresses when the files don't exist yet.
+// It reports whether it did.
+func ensureSelfSigned(certFile, keyFile string, addrs []string) (bool, error) {
+  _, certErr := os.Stat(certFile)
+  _, keyErr := os.Stat(keyFile)
+  switch {
+  case certErr == nil && keyErr == nil:
+    return false, nil
+  case certErr == nil || keyErr == nil:
+    return false, fmt.Errorf("only one of %s and %s exists; remove it to generate a new pair", certFile, keyFile)
+  case !errors.Is(certErr, os.ErrNotExist):
+    return false, certErr
+  case !errors.Is(keyErr, os.ErrNotExist):
+    return false, keyErr
+  }
+
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return false, err
+  }
+  serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+  if err != nil {
+    return false, err
+  }
+  host, err := os.Hostname()
+  if err != nil || host == "" {
+    host = "localhost"
+  }
+  now := time.Now()
+  tmpl := &x509.Certificate{
+    SerialNumber:          serial,
+    Subject:               pkix.Name{CommonName: host, Organization: []string{"kea-web self-signed"}},
+    NotBefore:             now.Add(-time.Hour),
+    NotAfter:              now.Add(selfSignedValidity),
+    KeyUsage:              x509.KeyUsageDigitalSignature,
+    ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    BasicConstraintsValid: true,
+    DNSNames:              []string{host, "localhost"},
+    IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+  }
+  for _, addr := range addrs {
+    h, _, err := net.SplitHostPort(addr)
+    if err != nil {
+      continue
+    }
+    if ip, err := netip.ParseAddr(h); err == nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+      tmpl.IPAddresses = append(tmpl.IPAddresses, ip.AsSlice())
+    } else if err != nil && h != "" && h != host && h != "localhost" {
+      tmpl.DNSNames = append(tmpl.DNSNames, h)
+    }
+  }
+  der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+  if err != nil {
+    return false, err
+  }
+  keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+  if err != nil {
+    return false, err
+  }
+
+  for _, f := range []struct {
+    path string
+    typ  string
+    der  []byte
+    perm os.FileMode
+  }{{keyFile, "PRIVATE KEY", keyDER, 0o600}, {certFile, "CERTIFICATE", der, 0o644}} {
+    if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+      return false, err
+    }
+    data := pem.EncodeToMemory(&pem.Block{Type: f.typ, Bytes: f.der})
+    if err := os.WriteFile(f.path, data, f.perm); err != nil {
+      return false, err
+    }
+  }
+  return true, nil
+}